@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/api"
+	apipb "github.com/censys/scan-takehome/pkg/api/proto"
+	"github.com/censys/scan-takehome/pkg/store"
+	"google.golang.org/grpc"
+)
+
+// shutdownTimeout bounds how long open streams get on shutdown
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	// Emit JSON logs so they can be shipped to structured log aggregators
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	// Get configuration from environment variables
+	storeType := getEnv("STORE_TYPE", "sqlite")
+	storeConnection := getEnv("STORE_CONNECTION", "/data/scans.db")
+	grpcPort := getEnv("GRPC_PORT", "50051")
+
+	slog.Info("starting grpc server",
+		slog.String("store_type", storeType),
+		slog.String("store_connection", storeConnection),
+		slog.String("grpc_port", grpcPort),
+	)
+
+	// Create store
+	s, err := store.NewStore(storeType, storeConnection)
+	if err != nil {
+		fatal("failed to create store", err)
+	}
+	defer s.Close()
+
+	lis, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		fatal("failed to listen", err)
+	}
+
+	server := grpc.NewServer()
+	apipb.RegisterScanStoreServer(server, api.NewGRPCServer(s))
+
+	// Shut down on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		slog.Info("shutting down grpc server")
+		// WatchRecords streams only end when clients disconnect, so force
+		// them closed if a graceful stop takes too long
+		timer := time.AfterFunc(shutdownTimeout, server.Stop)
+		defer timer.Stop()
+		server.GracefulStop()
+	}()
+
+	if err := server.Serve(lis); err != nil {
+		fatal("grpc server error", err)
+	}
+
+	slog.Info("grpc server shut down gracefully")
+}
+
+// fatal logs an error and exits
+// Like log.Fatalf, deferred calls do not run
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
+// getEnv returns the value of an environment variable or a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/api"
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// shutdownTimeout bounds how long in-flight requests get on shutdown
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	// Emit JSON logs so they can be shipped to structured log aggregators
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	dumpOpenAPI := flag.Bool("dump-openapi", false, "print the OpenAPI spec to stdout and exit")
+	flag.Parse()
+	if *dumpOpenAPI {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(api.OpenAPISpec()); err != nil {
+			fatal("failed to encode openapi spec", err)
+		}
+		return
+	}
+
+	// Get configuration from environment variables
+	storeType := getEnv("STORE_TYPE", "sqlite")
+	storeConnection := getEnv("STORE_CONNECTION", "/data/scans.db")
+	apiAddr := getEnv("API_ADDR", ":8080")
+	apiKeys := splitList(os.Getenv("API_KEYS"))
+	adminKeys := splitList(os.Getenv("ADMIN_API_KEYS"))
+	corsOrigins := splitList(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	httpsAddr := getEnv("HTTPS_ADDR", ":8443")
+	certFile := os.Getenv("HTTPS_CERT_FILE")
+	keyFile := os.Getenv("HTTPS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		fatal("invalid HTTPS configuration", errors.New("HTTPS_CERT_FILE and HTTPS_KEY_FILE must be set together"))
+	}
+	httpsEnabled := certFile != ""
+	httpDisabled := false
+	if v := os.Getenv("HTTP_DISABLED"); v != "" {
+		var err error
+		if httpDisabled, err = strconv.ParseBool(v); err != nil {
+			fatal("invalid HTTP_DISABLED", err)
+		}
+	}
+	var rateLimit float64
+	if v := os.Getenv("CLIENT_RATE_LIMIT_RPS"); v != "" {
+		var err error
+		if rateLimit, err = strconv.ParseFloat(v, 64); err != nil {
+			fatal("invalid CLIENT_RATE_LIMIT_RPS", err)
+		}
+		if rateLimit < 0 {
+			fatal("invalid CLIENT_RATE_LIMIT_RPS", fmt.Errorf("must not be negative, got %v", rateLimit))
+		}
+	}
+	var maxBodyBytes int64 = 1 << 20
+	if v := os.Getenv("MAX_REQUEST_BODY_BYTES"); v != "" {
+		var err error
+		if maxBodyBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			fatal("invalid MAX_REQUEST_BODY_BYTES", err)
+		}
+		if maxBodyBytes < 0 {
+			fatal("invalid MAX_REQUEST_BODY_BYTES", fmt.Errorf("must not be negative, got %d", maxBodyBytes))
+		}
+	}
+	requestTimeout := 30 * time.Second
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		var err error
+		if requestTimeout, err = time.ParseDuration(v); err != nil {
+			fatal("invalid REQUEST_TIMEOUT", err)
+		}
+		if requestTimeout < 0 {
+			fatal("invalid REQUEST_TIMEOUT", fmt.Errorf("must not be negative, got %v", requestTimeout))
+		}
+	}
+	if httpDisabled && !httpsEnabled {
+		fatal("invalid HTTPS configuration", errors.New("HTTP_DISABLED requires HTTPS_CERT_FILE and HTTPS_KEY_FILE"))
+	}
+
+	slog.Info("starting api server",
+		slog.String("store_type", storeType),
+		slog.String("store_connection", storeConnection),
+		slog.String("api_addr", apiAddr),
+		slog.Bool("http_disabled", httpDisabled),
+		slog.Bool("https_enabled", httpsEnabled),
+		slog.String("https_addr", httpsAddr),
+		slog.Int("api_keys", len(apiKeys)),
+		slog.Int("admin_api_keys", len(adminKeys)),
+		slog.Float64("client_rate_limit_rps", rateLimit),
+		slog.Any("cors_allowed_origins", corsOrigins),
+		slog.Int64("max_request_body_bytes", maxBodyBytes),
+		slog.Duration("request_timeout", requestTimeout),
+	)
+	if len(apiKeys) == 0 {
+		slog.Warn("API_KEYS is not set, api is unauthenticated")
+	}
+
+	// Create store
+	s, err := store.NewStore(storeType, storeConnection)
+	if err != nil {
+		fatal("failed to create store", err)
+	}
+	defer func() {
+		// A memory-persistent store saves its snapshot on Close
+		if err := s.Close(); err != nil {
+			slog.Error("failed to close store", slog.Any("error", err))
+		}
+	}()
+
+	// Shut down on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	opts := []api.ServerOption{
+		api.WithAPIKeys(apiKeys),
+		api.WithAdminAPIKeys(adminKeys),
+		api.WithMaxBodySize(maxBodyBytes),
+		api.WithRequestTimeout(requestTimeout),
+	}
+	if len(corsOrigins) > 0 {
+		opts = append(opts, api.WithCORS(corsOrigins, []string{http.MethodGet, http.MethodPost, http.MethodDelete}))
+	}
+	if rateLimit > 0 {
+		// Allow a second's worth of requests at once
+		opts = append(opts, api.WithClientRateLimit(rateLimit, max(int(math.Ceil(rateLimit)), 1)))
+	}
+	var servers []*api.Server
+	errCh := make(chan error, 2)
+
+	if !httpDisabled {
+		server := api.NewServer(s, &http.Server{
+			Addr:              apiAddr,
+			ReadHeaderTimeout: 10 * time.Second,
+		}, opts...)
+		servers = append(servers, server)
+		go func() {
+			errCh <- server.ListenAndServe()
+		}()
+	}
+
+	if httpsEnabled {
+		certs, err := api.NewCertReloader(certFile, keyFile)
+		if err != nil {
+			fatal("failed to load TLS certificate", err)
+		}
+		// Rotated certificates are picked up without a restart
+		go func() {
+			if err := certs.Watch(ctx); err != nil {
+				slog.Error("TLS certificate reload disabled", slog.Any("error", err))
+			}
+		}()
+
+		server := api.NewServer(s, &http.Server{
+			Addr:              httpsAddr,
+			ReadHeaderTimeout: 10 * time.Second,
+			TLSConfig:         certs.TLSConfig(),
+		}, opts...)
+		servers = append(servers, server)
+		go func() {
+			errCh <- server.ListenAndServeTLS()
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("api server error", err)
+		}
+	case <-ctx.Done():
+		slog.Info("shutting down api server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, server := range servers {
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				slog.Error("failed to shut down api server", slog.Any("error", err))
+			}
+		}
+	}
+
+	slog.Info("api server shut down gracefully")
+}
+
+// fatal logs an error and exits
+// Like log.Fatalf, deferred calls do not run
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
+// getEnv returns the value of an environment variable or a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
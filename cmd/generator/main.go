@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/censys/scan-takehome/pkg/generator"
+	"golang.org/x/time/rate"
+)
+
+func main() {
+	// Emit JSON logs so they can be shipped to structured log aggregators
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	projectID := flag.String("project", "test-project", "GCP project ID")
+	topicID := flag.String("topic", "scan-topic", "Pub/Sub topic to publish to")
+	count := flag.Int("count", 1000, "messages to publish; 0 publishes until interrupted")
+	msgRate := flag.Float64("rate", 100, "messages per second; 0 publishes as fast as possible")
+	ipCount := flag.Int("ip-count", 256, "distinct IPs to rotate through")
+	version := flag.Int("version", 1, "scan data version, 1 or 2")
+	seed := flag.Int64("seed", 0, "random seed for reproducible runs; 0 picks one")
+	dryRun := flag.Bool("dry-run", false, "print messages to stdout instead of publishing them")
+	flag.Parse()
+
+	if *count < 0 {
+		fatal("invalid --count", fmt.Errorf("must not be negative, got %d", *count))
+	}
+	if *msgRate < 0 {
+		fatal("invalid --rate", fmt.Errorf("must not be negative, got %v", *msgRate))
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	gen, err := generator.New(*ipCount, *version, *seed)
+	if err != nil {
+		fatal("invalid generator configuration", err)
+	}
+
+	// The seed is logged so a run can be repeated
+	slog.Info("starting generator",
+		slog.String("project", *projectID),
+		slog.String("topic", *topicID),
+		slog.Int("count", *count),
+		slog.Float64("rate", *msgRate),
+		slog.Int("ip_count", *ipCount),
+		slog.Int("version", *version),
+		slog.Int64("seed", *seed),
+		slog.Bool("dry_run", *dryRun),
+	)
+
+	// Stop on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var publish func(ctx context.Context, data []byte) error
+	var wait func() (failed int64)
+	if *dryRun {
+		out := bufio.NewWriter(os.Stdout)
+		defer out.Flush()
+		publish = func(_ context.Context, data []byte) error {
+			out.Write(data)
+			return out.WriteByte('\n')
+		}
+		wait = func() int64 { return 0 }
+	} else {
+		client, err := pubsub.NewClient(ctx, *projectID)
+		if err != nil {
+			fatal("failed to create pubsub client", err)
+		}
+		defer client.Close()
+		topic := client.Topic(*topicID)
+		defer topic.Stop()
+		publish, wait = publisher(topic)
+	}
+
+	limit := rate.Inf
+	if *msgRate > 0 {
+		limit = rate.Limit(*msgRate)
+	}
+	limiter := rate.NewLimiter(limit, 1)
+
+	start := time.Now()
+	sent := 0
+	for *count == 0 || sent < *count {
+		if err := limiter.Wait(ctx); err != nil {
+			break
+		}
+		data, err := gen.NextMessage()
+		if err != nil {
+			fatal("failed to generate message", err)
+		}
+		if err := publish(ctx, data); err != nil {
+			fatal("failed to write message", err)
+		}
+		sent++
+	}
+
+	failed := wait()
+	slog.Info("generator finished",
+		slog.Int("sent", sent),
+		slog.Int64("failed", failed),
+		slog.Duration("elapsed", time.Since(start)),
+	)
+	if failed > 0 {
+		fatal("failed to publish messages", errors.New("see the logged publish errors"))
+	}
+}
+
+// publisher returns a function that publishes to topic without waiting for
+// the result, so the client can batch messages, and a function that waits
+// for every published message and returns how many failed
+func publisher(topic *pubsub.Topic) (func(context.Context, []byte) error, func() int64) {
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+
+	publish := func(ctx context.Context, data []byte) error {
+		result := topic.Publish(ctx, &pubsub.Message{Data: data})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Background so messages already handed to the client are
+			// still confirmed after an interrupt
+			if _, err := result.Get(context.Background()); err != nil {
+				failed.Add(1)
+				slog.Error("failed to publish message", slog.Any("error", err))
+			}
+		}()
+		return nil
+	}
+	wait := func() int64 {
+		wg.Wait()
+		return failed.Load()
+	}
+	return publish, wait
+}
+
+// fatal logs an error and exits
+// Like log.Fatalf, deferred calls do not run
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
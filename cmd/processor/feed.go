@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/censys/scan-takehome/pkg/processor"
+)
+
+// feed processes the newline-delimited scan messages in path, or stdin if
+// path is "-", instead of consuming them from a message broker
+// Used when FEED_FILE is set, for imports and local testing
+func feed(ctx context.Context, proc *processor.Processor, path string) error {
+	r := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open feed file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	processed, skipped, errored, err := proc.ProcessFromReader(ctx, r)
+	slog.Info("feed finished",
+		slog.String("feed_file", path),
+		slog.Int("processed", processed),
+		slog.Int("skipped", skipped),
+		slog.Int("errored", errored),
+	)
+	return err
+}
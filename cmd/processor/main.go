@@ -2,38 +2,177 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/censys/scan-takehome/pkg/processor"
 	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Circuit breaker settings used when CIRCUIT_BREAKER=true
+const (
+	circuitBreakerThreshold = 5
+	circuitBreakerDelay     = 10 * time.Second
 )
 
 func main() {
+	// Emit JSON logs so they can be shipped to structured log aggregators
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	dryRun := flag.Bool("dry-run", false, "log records instead of writing them to the store; also set by DRY_RUN=true")
+	flag.Parse()
+
+	if err := run(*dryRun); err != nil {
+		slog.Error("processor failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	slog.Info("processor shut down gracefully")
+}
+
+// run starts the processor and blocks until it shuts down
+// Errors are returned rather than exiting so deferred cleanup, such as
+// saving a memory store's snapshot, always runs
+func run(dryRun bool) error {
 	// Get configuration from environment variables
-	projectID := getEnv("PUBSUB_PROJECT_ID", "test-project")
-	subscriptionID := getEnv("PUBSUB_SUBSCRIPTION_ID", "scan-sub")
+	// FEED_FILE processes an NDJSON file ("-" for stdin) instead of
+	// consuming, so no broker settings are needed
+	feedFile := os.Getenv("FEED_FILE")
+	consumerType := getEnv("CONSUMER_TYPE", "pubsub")
+	var (
+		consumerConfig *processor.ConsumerConfig
+		kafkaConfig    *processor.KafkaConfig
+		natsConfig     *processor.NATSConfig
+		err            error
+	)
+	if feedFile == "" {
+		switch consumerType {
+		case "pubsub":
+			consumerConfig, err = processor.ConsumerConfigFromEnv()
+		case "kafka":
+			kafkaConfig, err = processor.KafkaConfigFromEnv()
+		case "nats":
+			natsConfig, err = processor.NATSConfigFromEnv()
+		default:
+			err = fmt.Errorf("unknown CONSUMER_TYPE %q, expected pubsub, kafka or nats", consumerType)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid consumer configuration: %w", err)
+		}
+	}
 	storeType := getEnv("STORE_TYPE", "sqlite")
 	storeConnection := getEnv("STORE_CONNECTION", "/data/scans.db")
+	metricsAddr := getEnv("METRICS_ADDR", ":9090")
+	serviceAllowlist := splitList(os.Getenv("SERVICE_ALLOWLIST"))
+	geoIPDatabase := os.Getenv("GEOIP_DATABASE_PATH")
+	if v := os.Getenv("DRY_RUN"); v != "" {
+		envDryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid DRY_RUN: %w", err)
+		}
+		dryRun = dryRun || envDryRun
+	}
+	circuitBreaker := false
+	if v := os.Getenv("CIRCUIT_BREAKER"); v != "" {
+		if circuitBreaker, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid CIRCUIT_BREAKER: %w", err)
+		}
+	}
 
-	log.Printf("starting processor with config:")
-	log.Printf("  project ID: %s", projectID)
-	log.Printf("  subscription ID: %s", subscriptionID)
-	log.Printf("  store type: %s", storeType)
-	log.Printf("  store connection: %s", storeConnection)
+	var sourceAttrs []any
+	switch {
+	case feedFile != "":
+		sourceAttrs = append(sourceAttrs, slog.String("feed_file", feedFile))
+	case kafkaConfig != nil:
+		sourceAttrs = append(sourceAttrs,
+			slog.String("consumer_type", consumerType),
+			slog.Any("kafka_brokers", kafkaConfig.Brokers),
+			slog.String("kafka_topic", kafkaConfig.Topic),
+			slog.String("kafka_consumer_group", kafkaConfig.ConsumerGroup),
+		)
+	case natsConfig != nil:
+		sourceAttrs = append(sourceAttrs,
+			slog.String("consumer_type", consumerType),
+			slog.String("nats_url", natsConfig.URL),
+			slog.String("nats_stream", natsConfig.Stream),
+			slog.String("nats_consumer", natsConfig.Consumer),
+		)
+	default:
+		sourceAttrs = append(sourceAttrs,
+			slog.String("consumer_type", consumerType),
+			slog.String("project_id", consumerConfig.ProjectID),
+			slog.String("subscription_id", consumerConfig.SubscriptionID),
+		)
+	}
+	slog.Info("starting processor", append(sourceAttrs,
+		slog.String("store_type", storeType),
+		slog.String("store_connection", storeConnection),
+		slog.String("metrics_addr", metricsAddr),
+		slog.Any("service_allowlist", serviceAllowlist),
+		slog.Bool("dry_run", dryRun),
+		slog.Bool("circuit_breaker", circuitBreaker),
+		slog.String("geoip_database", geoIPDatabase),
+	)...)
 
 	// Create store
 	s, err := store.NewStore(storeType, storeConnection)
 	if err != nil {
-		log.Fatalf("failed to create store: %v", err)
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+	if dryRun {
+		// The configured store is still opened so a dry run checks it is
+		// reachable, but nothing is written to it
+		s = store.NewDryRunStore(s, nil)
+		slog.Warn("dry run enabled, records are logged but not stored")
 	}
-	defer s.Close()
-	log.Printf("store initialized successfully")
+	if circuitBreaker {
+		// Fail upserts fast while the store is down; the messages are NACKed
+		// and redelivered once the breaker closes
+		s = store.NewCircuitBreakerStore(s, circuitBreakerThreshold, circuitBreakerDelay)
+		go logBreakerState(s.(*store.CircuitBreakerStore))
+	}
+	// Store latencies are served with the processor metrics on METRICS_ADDR
+	s = store.NewMetricsStore(s, prometheus.DefaultRegisterer)
+	defer func() {
+		// A memory-persistent store saves its snapshot on Close
+		if err := s.Close(); err != nil {
+			slog.Error("failed to close store", slog.Any("error", err))
+		}
+	}()
+	slog.Info("store initialized successfully")
 
 	// Create processor
-	proc := processor.NewProcessor(s)
+	procOpts := []processor.Option{
+		processor.WithMetrics(prometheus.DefaultRegisterer),
+		processor.WithServiceAllowlist(serviceAllowlist),
+	}
+	if geoIPDatabase != "" {
+		geoIP, err := processor.NewGeoIPEnricher(processor.GeoIPConfig{DatabasePath: geoIPDatabase})
+		if err != nil {
+			return fmt.Errorf("failed to open GeoIP database: %w", err)
+		}
+		procOpts = append(procOpts, processor.WithEnrichers(geoIP))
+	}
+	proc := processor.NewProcessor(s, procOpts...)
+
+	// Serve Prometheus metrics
+	metricsServer := &http.Server{Addr: metricsAddr, Handler: promhttp.Handler()}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server error", slog.Any("error", err))
+		}
+	}()
+	defer metricsServer.Close()
 
 	// Create context that cancels on SIGINT/SIGTERM
 	ctx, cancel := context.WithCancel(context.Background())
@@ -44,25 +183,56 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigChan
-		log.Printf("received signal %v, shutting down...", sig)
+		slog.Info("received signal, shutting down", slog.String("signal", sig.String()))
 		cancel()
 	}()
 
+	if feedFile != "" {
+		// An interrupted feed is a clean shutdown
+		if err := feed(ctx, proc, feedFile); err != nil && !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("feed error: %w", err)
+		}
+		return nil
+	}
+
 	// Create and start consumer
-	consumer, err := processor.NewConsumer(ctx, projectID, subscriptionID, proc)
+	var consumer messageConsumer
+	switch {
+	case kafkaConfig != nil:
+		consumer, err = processor.NewKafkaConsumer(ctx, kafkaConfig, proc)
+	case natsConfig != nil:
+		consumer, err = processor.NewNATSConsumer(ctx, natsConfig, proc)
+	default:
+		consumer, err = processor.NewConsumerFromConfig(ctx, consumerConfig, proc)
+	}
 	if err != nil {
-		log.Fatalf("failed to create consumer: %v", err)
+		return fmt.Errorf("failed to create consumer: %w", err)
 	}
 	defer consumer.Close()
-	log.Printf("consumer initialized successfully")
+	slog.Info("consumer initialized successfully")
 
 	// Start consuming messages (blocks until context is canceled)
-	log.Printf("starting to consume messages...")
 	if err := consumer.Start(ctx); err != nil {
-		log.Fatalf("consumer error: %v", err)
+		return fmt.Errorf("consumer error: %w", err)
 	}
+	return nil
+}
+
+// messageConsumer is implemented by the Pub/Sub, Kafka and NATS consumers
+type messageConsumer interface {
+	Start(ctx context.Context) error
+	Close() error
+}
 
-	log.Printf("processor shut down gracefully")
+// logBreakerState logs every state change of the store circuit breaker
+func logBreakerState(cb *store.CircuitBreakerStore) {
+	for state := range cb.StateChange() {
+		if state == store.CBOpen {
+			slog.Warn("store circuit breaker opened", slog.Duration("retry_after", circuitBreakerDelay))
+			continue
+		}
+		slog.Info("store circuit breaker state changed", slog.String("state", state.String()))
+	}
 }
 
 // getEnv returns the value of an environment variable or a default value
@@ -72,3 +242,14 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// responsePreview is how many characters of a response list shows
+const responsePreview = 60
+
+const usage = `Usage: scan-query <command> [flags] [args]
+
+Commands:
+  get [--json] <ip> <port> <service>   Show a single record
+  list [--json] [--limit N] [--offset N] [--service S] [--port P]
+                                       List records, newest first
+  stats [--json]                       Show record counts
+  delete <ip> <port> <service>         Soft-delete a record
+  export [--format json|csv] [--output file]
+                                       Export every record
+
+The store is selected with STORE_TYPE and STORE_CONNECTION, as for the
+processor and API.
+`
+
+// errUsage reports invalid arguments; the usage text is printed with it
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	// Stop long-running exports on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "scan-query: %v\n\n%s", err, usage)
+		stop()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scan-query: %v\n", err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+// run executes the command in args, writing its output to stdout
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+
+	var cmd func(context.Context, []string, io.Writer) error
+	switch args[0] {
+	case "get":
+		cmd = runGet
+	case "list":
+		cmd = runList
+	case "stats":
+		cmd = runStats
+	case "delete":
+		cmd = runDelete
+	case "export":
+		cmd = runExport
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+	return cmd(ctx, args[1:], stdout)
+}
+
+// openStore creates the store configured by the environment
+func openStore() (store.Store, error) {
+	s, err := store.NewStore(getEnv("STORE_TYPE", "sqlite"), getEnv("STORE_CONNECTION", "/data/scans.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store: %w", err)
+	}
+	return s, nil
+}
+
+// newFlagSet returns a flag set for a command that reports errors instead
+// of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseFlags parses args with fs and checks the number of positional
+// arguments left
+func parseFlags(fs *flag.FlagSet, args []string, positional int) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s: %v", errUsage, fs.Name(), err)
+	}
+	if fs.NArg() != positional {
+		return fmt.Errorf("%w: %s takes %d arguments, got %d", errUsage, fs.Name(), positional, fs.NArg())
+	}
+	return nil
+}
+
+// parseKey parses the ip, port and service arguments of get and delete
+func parseKey(args []string) (string, uint32, string, error) {
+	port, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("%w: invalid port %q", errUsage, args[1])
+	}
+	return args[0], uint32(port), args[2], nil
+}
+
+// runGet shows a single record
+func runGet(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlagSet("get")
+	asJSON := fs.Bool("json", false, "write JSON")
+	if err := parseFlags(fs, args, 3); err != nil {
+		return err
+	}
+	ip, port, service, err := parseKey(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	r, err := s.Get(ctx, ip, port, service)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return fmt.Errorf("record %s:%d/%s not found", ip, port, service)
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, r)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "IP\t%s\n", r.IP)
+	fmt.Fprintf(tw, "Port\t%d\n", r.Port)
+	fmt.Fprintf(tw, "Service\t%s\n", r.Service)
+	fmt.Fprintf(tw, "Last timestamp\t%d\n", r.LastTimestamp)
+	fmt.Fprintf(tw, "Updated at\t%s\n", r.UpdatedAt.Format(time.RFC3339))
+	fmt.Fprintf(tw, "First seen at\t%s\n", r.FirstSeenAt.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Scan count\t%d\n", r.ScanCount)
+	if r.TLSVersion != "" {
+		fmt.Fprintf(tw, "TLS version\t%s\n", r.TLSVersion)
+	}
+	if r.StatusCode != 0 {
+		fmt.Fprintf(tw, "Status code\t%d\n", r.StatusCode)
+	}
+	if r.ExpiresAt != nil {
+		fmt.Fprintf(tw, "Expires at\t%s\n", r.ExpiresAt.Format(time.RFC3339))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// The response is printed as is, since it may span several lines
+	_, err = fmt.Fprintf(stdout, "\n%s\n", r.Response)
+	return err
+}
+
+// runList lists records, optionally filtered by service or port
+func runList(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlagSet("list")
+	asJSON := fs.Bool("json", false, "write JSON")
+	limit := fs.Int("limit", 100, "maximum records to list; 0 lists all")
+	offset := fs.Int("offset", 0, "records to skip")
+	service := fs.String("service", "", "only list this service")
+	port := fs.Uint("port", 0, "only list this port")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if *service != "" && *port != 0 {
+		return fmt.Errorf("%w: --service and --port cannot be combined", errUsage)
+	}
+	if *limit < 0 || *offset < 0 {
+		return fmt.Errorf("%w: --limit and --offset must not be negative", errUsage)
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var records []*store.ServiceRecord
+	switch {
+	case *service != "":
+		records, err = s.ListByService(ctx, *service, *limit, *offset)
+	case *port != 0:
+		records, err = s.ListByPort(ctx, uint32(*port), *limit, *offset)
+	default:
+		records, err = s.List(ctx, *limit, *offset)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, records)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tPORT\tSERVICE\tLAST_TIMESTAMP\tSCANS\tRESPONSE")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%s\n", r.IP, r.Port, r.Service, r.LastTimestamp, r.ScanCount, preview(r.Response))
+	}
+	return tw.Flush()
+}
+
+// preview shortens a response to one line for the list table
+func preview(response string) string {
+	quoted := strconv.Quote(response)
+	quoted = quoted[1 : len(quoted)-1]
+	if len(quoted) > responsePreview {
+		quoted = quoted[:responsePreview-3] + "..."
+	}
+	return quoted
+}
+
+// runStats shows record counts by service and port
+func runStats(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlagSet("stats")
+	asJSON := fs.Bool("json", false, "write JSON")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	stats, err := s.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, stats)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Total records\t%d\n", stats.TotalRecords)
+	fmt.Fprintf(tw, "Oldest timestamp\t%d\n", stats.OldestTimestamp)
+	fmt.Fprintf(tw, "Newest timestamp\t%d\n", stats.NewestTimestamp)
+
+	services := make([]string, 0, len(stats.RecordsByService))
+	for service := range stats.RecordsByService {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	fmt.Fprintln(tw, "\nSERVICE\tRECORDS")
+	for _, service := range services {
+		fmt.Fprintf(tw, "%s\t%d\n", service, stats.RecordsByService[service])
+	}
+
+	ports := make([]uint32, 0, len(stats.RecordsByPort))
+	for port := range stats.RecordsByPort {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	fmt.Fprintln(tw, "\nPORT\tRECORDS")
+	for _, port := range ports {
+		fmt.Fprintf(tw, "%d\t%d\n", port, stats.RecordsByPort[port])
+	}
+	return tw.Flush()
+}
+
+// runDelete soft-deletes a record
+func runDelete(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlagSet("delete")
+	if err := parseFlags(fs, args, 3); err != nil {
+		return err
+	}
+	ip, port, service, err := parseKey(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	deleted, err := s.Delete(ctx, ip, port, service)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("record %s:%d/%s not found", ip, port, service)
+	}
+	_, err = fmt.Fprintf(stdout, "deleted %s:%d/%s\n", ip, port, service)
+	return err
+}
+
+// runExport writes every record as NDJSON or CSV to stdout or a file
+func runExport(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlagSet("export")
+	format := fs.String("format", "json", "output format, json or csv")
+	output := fs.String("output", "", "file to write instead of stdout")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	var export func(context.Context, store.Store, io.Writer) error
+	switch strings.ToLower(*format) {
+	case "json":
+		export = store.ExportJSON
+	case "csv":
+		export = store.ExportCSV
+	default:
+		return fmt.Errorf("%w: unknown format %q", errUsage, *format)
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if *output == "" {
+		return export(ctx, s, stdout)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if err := export(ctx, s, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// getEnv returns the value of an environment variable or a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// TestMain runs the CLI instead of the tests when the test binary is
+// re-executed by runCLI
+func TestMain(m *testing.M) {
+	if os.Getenv("SCAN_QUERY_RUN_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCLI runs scan-query with args against the SQLite database at dbPath
+// and returns its stdout, stderr and exit code
+func runCLI(t *testing.T, dbPath string, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(),
+		"SCAN_QUERY_RUN_MAIN=1",
+		"STORE_TYPE=sqlite",
+		"STORE_CONNECTION="+dbPath,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("Failed to run scan-query: %v", err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+// newTestDB creates a SQLite database holding a few records
+func newTestDB(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "scans.db")
+	s, err := store.NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer s.Close()
+
+	records := []*store.ServiceRecord{
+		{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "hello, world"},
+		{IP: "2.2.2.2", Port: 22, Service: "SSH", LastTimestamp: 2000, Response: "SSH-2.0-OpenSSH"},
+		{IP: "3.3.3.3", Port: 8080, Service: "HTTP", LastTimestamp: 3000, Response: "line one\nline two"},
+	}
+	for _, r := range records {
+		if _, err := s.Upsert(context.Background(), r); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+	return path
+}
+
+// TestGet tests the table and JSON output of get and its not found error
+func TestGet(t *testing.T) {
+	db := newTestDB(t)
+
+	stdout, stderr, code := runCLI(t, db, "get", "1.1.1.1", "80", "HTTP")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	for _, want := range []string{"1.1.1.1", "HTTP", "Scan count", "hello, world"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, stdout)
+		}
+	}
+
+	stdout, _, code = runCLI(t, db, "get", "--json", "2.2.2.2", "22", "SSH")
+	var r store.ServiceRecord
+	if code != 0 || json.Unmarshal([]byte(stdout), &r) != nil || r.Response != "SSH-2.0-OpenSSH" {
+		t.Errorf("Expected JSON record, got exit code %d and:\n%s", code, stdout)
+	}
+
+	_, stderr, code = runCLI(t, db, "get", "9.9.9.9", "80", "HTTP")
+	if code != 1 || !strings.Contains(stderr, "not found") {
+		t.Errorf("Expected not found with exit code 1, got %d: %s", code, stderr)
+	}
+}
+
+// TestList tests list with its filters, pagination and JSON output
+func TestList(t *testing.T) {
+	db := newTestDB(t)
+
+	stdout, stderr, code := runCLI(t, db, "list")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "IP") || !strings.HasPrefix(lines[1], "3.3.3.3") {
+		t.Errorf("Expected header and 3 rows newest first, got:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, `line one\nline two`) {
+		t.Errorf("Expected multi-line response on one row, got:\n%s", stdout)
+	}
+
+	stdout, _, _ = runCLI(t, db, "list", "--service", "HTTP", "--limit", "1", "--offset", "1")
+	if !strings.Contains(stdout, "1.1.1.1") || strings.Contains(stdout, "3.3.3.3") || strings.Contains(stdout, "2.2.2.2") {
+		t.Errorf("Expected second HTTP record only, got:\n%s", stdout)
+	}
+
+	stdout, _, _ = runCLI(t, db, "list", "--json", "--port", "22")
+	var records []store.ServiceRecord
+	if err := json.Unmarshal([]byte(stdout), &records); err != nil || len(records) != 1 || records[0].IP != "2.2.2.2" {
+		t.Errorf("Expected one JSON record on port 22, got (err %v):\n%s", err, stdout)
+	}
+}
+
+// TestStats tests the stats tables
+func TestStats(t *testing.T) {
+	db := newTestDB(t)
+
+	stdout, stderr, code := runCLI(t, db, "stats")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	for _, want := range []string{"Total records     3", "HTTP     2", "8080  1"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, stdout)
+		}
+	}
+}
+
+// TestDelete tests that delete hides a record and fails when repeated
+func TestDelete(t *testing.T) {
+	db := newTestDB(t)
+
+	stdout, stderr, code := runCLI(t, db, "delete", "1.1.1.1", "80", "HTTP")
+	if code != 0 || !strings.Contains(stdout, "deleted 1.1.1.1:80/HTTP") {
+		t.Fatalf("Expected delete to succeed, got exit code %d: %s%s", code, stdout, stderr)
+	}
+
+	_, _, code = runCLI(t, db, "get", "1.1.1.1", "80", "HTTP")
+	if code != 1 {
+		t.Errorf("Expected deleted record to be gone, got exit code %d", code)
+	}
+	_, _, code = runCLI(t, db, "delete", "1.1.1.1", "80", "HTTP")
+	if code != 1 {
+		t.Errorf("Expected second delete to fail, got exit code %d", code)
+	}
+}
+
+// TestExport tests NDJSON export to stdout and CSV export to a file
+func TestExport(t *testing.T) {
+	db := newTestDB(t)
+
+	stdout, stderr, code := runCLI(t, db, "export")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	if lines := strings.Count(stdout, "\n"); lines != 3 {
+		t.Errorf("Expected 3 NDJSON lines, got %d:\n%s", lines, stdout)
+	}
+
+	out := filepath.Join(t.TempDir(), "scans.csv")
+	if _, stderr, code := runCLI(t, db, "export", "--format", "csv", "--output", out); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "ip,port,service,last_timestamp,") || !strings.Contains(string(data), `"hello, world"`) {
+		t.Errorf("Expected CSV export, got:\n%s", data)
+	}
+}
+
+// TestUsageErrors tests that invalid arguments print usage and exit with 2
+func TestUsageErrors(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, args := range [][]string{
+		{},
+		{"frobnicate"},
+		{"get", "1.1.1.1"},
+		{"get", "1.1.1.1", "http", "HTTP"},
+		{"list", "--service", "HTTP", "--port", "80"},
+		{"export", "--format", "xml"},
+	} {
+		_, stderr, code := runCLI(t, db, args...)
+		if code != 2 || !strings.Contains(stderr, "Usage:") {
+			t.Errorf("Expected usage error for %v, got exit code %d: %s", args, code, stderr)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// bearerPrefix is the Authorization scheme accepted by APIKeyAuth
+const bearerPrefix = "Bearer "
+
+// APIKeyAuth rejects requests whose Authorization header does not carry one
+// of validKeys as a bearer token
+func APIKeyAuth(validKeys []string) Middleware {
+	keys := make([][]byte, len(validKeys))
+	for i, k := range validKeys {
+		keys[i] = []byte(k)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := bearerToken(r)
+			if !ok {
+				unauthorized(w, "missing api key")
+				return
+			}
+			if !validKey(keys, key) {
+				unauthorized(w, "invalid api key")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len(bearerPrefix):])
+	return token, token != ""
+}
+
+// validKey compares key against every valid key in constant time so the
+// response time does not reveal how much of a key matched
+func validKey(keys [][]byte, key string) bool {
+	found := 0
+	for _, k := range keys {
+		found |= subtle.ConstantTimeCompare(k, []byte(key))
+	}
+	return found == 1
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	writeError(w, http.StatusUnauthorized, msg)
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// TestAPIKeyAuth tests valid, missing and wrong keys on protected and
+// public routes
+func TestAPIKeyAuth(t *testing.T) {
+	s := store.NewMemoryStore()
+	defer s.Close()
+	srv := NewServer(s, &http.Server{}, WithAPIKeys([]string{"key-one", "key-two"}))
+
+	tests := []struct {
+		name   string
+		target string
+		auth   string
+		status int
+	}{
+		{"valid key", "/stats", "Bearer key-two", http.StatusOK},
+		{"lowercase scheme", "/stats", "bearer key-one", http.StatusOK},
+		{"missing header", "/stats", "", http.StatusUnauthorized},
+		{"wrong key", "/records", "Bearer key-three", http.StatusUnauthorized},
+		{"key prefix", "/records", "Bearer key", http.StatusUnauthorized},
+		{"wrong scheme", "/records", "Basic key-one", http.StatusUnauthorized},
+		{"health is public", "/health", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
+
+// TestNoAPIKeys tests that routes stay open when no keys are configured
+func TestNoAPIKeys(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 without API keys configured, got %d", rec.Code)
+	}
+}
+
+// TestDeleteOldRecords tests the admin cleanup endpoint and its key check
+func TestDeleteOldRecords(t *testing.T) {
+	_, s := newTestServer(t)
+	srv := NewServer(s, &http.Server{},
+		WithAPIKeys([]string{"user-key"}),
+		WithAdminAPIKeys([]string{"admin-key"}),
+	)
+
+	deleteRecords := func(query, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/admin/records"+query, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := deleteRecords("?older_than_timestamp=2000", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a key, got %d", rec.Code)
+	}
+	if rec := deleteRecords("?older_than_timestamp=2000", "user-key"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a non-admin key, got %d", rec.Code)
+	}
+	for _, query := range []string{"", "?older_than_timestamp=abc", "?older_than_timestamp=0"} {
+		if rec := deleteRecords(query, "admin-key"); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+
+	// The 5 HTTP records are at 1000-1004 and the SSH records at 2000-2004
+	rec := deleteRecords("?older_than_timestamp=2000", "admin-key")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var resp deleteResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Deleted != 5 {
+		t.Errorf("Expected 5 deleted records, got %d", resp.Deleted)
+	}
+	if count, _ := s.Count(context.Background()); count != 5 {
+		t.Errorf("Expected 5 remaining records, got %d", count)
+	}
+}
+
+// TestAdminRoutesDisabled tests that admin routes are absent without admin keys
+func TestAdminRoutesDisabled(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/records?older_than_timestamp=2000", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without admin keys, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// Bulk import limits
+const (
+	// importBatchSize is how many records are sent to BulkUpsert at once
+	importBatchSize = 500
+	// maxImportBodyBytes bounds a /admin/bulk-import body, in place of the
+	// server's default body limit
+	maxImportBodyBytes = 100 << 20
+	// maxImportLineBytes bounds a single NDJSON line
+	maxImportLineBytes = 16 << 20
+	// maxImportErrors bounds the line errors listed in an import response;
+	// Failed still counts every failed line
+	maxImportErrors = 1000
+)
+
+// ndjsonContentType is the media type accepted by /admin/bulk-import
+const ndjsonContentType = "application/x-ndjson"
+
+// importRecord is one line of a bulk import, in the same shape as the
+// records returned by the API so exported records can be imported as-is
+// Fields set by the store, such as updated_at and scan_count, are ignored
+type importRecord struct {
+	IP            store.IPAddress `json:"ip"`
+	Port          uint32          `json:"port"`
+	Service       string          `json:"service"`
+	LastTimestamp int64           `json:"last_timestamp"`
+	Response      string          `json:"response"`
+	TLSVersion    string          `json:"tls_version"`
+	StatusCode    int             `json:"status_code"`
+}
+
+// serviceRecord validates the line and returns it as a store record
+// Services are upper-cased like the processor does, so imported records
+// share keys with scanned ones
+func (r *importRecord) serviceRecord() (*store.ServiceRecord, error) {
+	switch {
+	case r.IP == "":
+		return nil, errors.New("ip is required")
+	case r.Port == 0 || r.Port > 65535:
+		return nil, fmt.Errorf("invalid port: %d", r.Port)
+	case strings.TrimSpace(r.Service) == "":
+		return nil, errors.New("service is required")
+	}
+	return &store.ServiceRecord{
+		IP:            r.IP,
+		Port:          r.Port,
+		Service:       strings.ToUpper(strings.TrimSpace(r.Service)),
+		LastTimestamp: r.LastTimestamp,
+		Response:      r.Response,
+		TLSVersion:    r.TLSVersion,
+		StatusCode:    r.StatusCode,
+	}, nil
+}
+
+// importError reports a line that could not be imported
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importResponse is the JSON body of a /admin/bulk-import response
+// Skipped counts records the store already held with a newer timestamp
+type importResponse struct {
+	Imported int           `json:"imported"`
+	Skipped  int           `json:"skipped"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors"`
+}
+
+// handleBulkImport upserts the newline-delimited JSON records in the body
+// Lines that fail to parse are reported in the response without stopping
+// the import; blank lines are ignored
+// Records are written in batches, so batches written before a store error
+// or an oversized body stay imported
+func (s *Server) handleBulkImport(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != ndjsonContentType {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+ndjsonContentType)
+		return
+	}
+
+	resp := importResponse{Errors: []importError{}}
+	batch := make([]*store.ServiceRecord, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := s.store.BulkUpsert(r.Context(), batch)
+		if err != nil {
+			return err
+		}
+		resp.Imported += n
+		resp.Skipped += len(batch) - n
+		batch = make([]*store.ServiceRecord, 0, importBatchSize)
+		return nil
+	}
+	fail := func(line int, err error) {
+		resp.Failed++
+		if len(resp.Errors) < maxImportErrors {
+			resp.Errors = append(resp.Errors, importError{Line: line, Error: err.Error()})
+		}
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var rec importRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			fail(line, err)
+			continue
+		}
+		record, err := rec.serviceRecord()
+		if err != nil {
+			fail(line, err)
+			continue
+		}
+
+		batch = append(batch, record)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				writeStoreError(w, "failed to import records", err)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to read line %d: %v", line+1, err))
+		return
+	}
+	if err := flush(); err != nil {
+		writeStoreError(w, "failed to import records", err)
+		return
+	}
+
+	slog.Info("imported records",
+		slog.Int("imported", resp.Imported),
+		slog.Int("skipped", resp.Skipped),
+		slog.Int("failed", resp.Failed),
+		slog.String("request_id", RequestIDFromContext(r.Context())),
+	)
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// newImportServer returns a server over an empty memory store with an admin
+// key and a function that posts body to /admin/bulk-import
+func newImportServer(t *testing.T) (store.Store, func(body, contentType, auth string) *httptest.ResponseRecorder) {
+	t.Helper()
+
+	s := store.NewMemoryStore()
+	t.Cleanup(func() { s.Close() })
+	srv := NewServer(s, &http.Server{},
+		WithAPIKeys([]string{"user-key"}),
+		WithAdminAPIKeys([]string{"admin-key"}),
+	)
+
+	post := func(body, contentType, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/bulk-import", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+auth)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	return s, post
+}
+
+// TestBulkImport tests that 1000 records are imported across batches and
+// that bad lines are reported without stopping the import
+func TestBulkImport(t *testing.T) {
+	s, post := newImportServer(t)
+
+	var body strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&body, `{"ip":"10.0.%d.%d","port":80,"service":"http","last_timestamp":%d,"response":"r%d"}`+"\n", i/256, i%256, 1000+i, i)
+		if i == 500 {
+			body.WriteString("\n{not json}\n")
+			body.WriteString(`{"ip":"10.0.0.1","port":0,"service":"HTTP"}` + "\n")
+		}
+	}
+
+	rec := post(body.String(), "application/x-ndjson", "admin-key")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp importResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Imported != 1000 || resp.Skipped != 0 || resp.Failed != 2 {
+		t.Errorf("Expected 1000 imported, 0 skipped and 2 failed, got %+v", resp)
+	}
+	// Lines 1-501 are records, 502 is blank
+	if len(resp.Errors) != 2 || resp.Errors[0].Line != 503 || resp.Errors[1].Line != 504 {
+		t.Errorf("Expected errors on lines 503 and 504, got %+v", resp.Errors)
+	}
+
+	ctx := context.Background()
+	if count, _ := s.Count(ctx); count != 1000 {
+		t.Errorf("Expected 1000 records, got %d", count)
+	}
+	record, err := s.Get(ctx, "10.0.0.5", 80, "HTTP")
+	if err != nil || record == nil || record.Response != "r5" {
+		t.Errorf("Expected the imported record with an upper-cased service, got %+v, %v", record, err)
+	}
+
+	// Importing older scans again skips them
+	rec = post(`{"ip":"10.0.0.5","port":80,"service":"HTTP","last_timestamp":1}`, "application/x-ndjson; charset=utf-8", "admin-key")
+	resp = importResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Imported != 0 || resp.Skipped != 1 {
+		t.Errorf("Expected 1 skipped record, got %+v", resp)
+	}
+}
+
+// TestBulkImportRejected tests the key and Content-Type checks
+func TestBulkImportRejected(t *testing.T) {
+	s, post := newImportServer(t)
+	line := `{"ip":"1.1.1.1","port":80,"service":"HTTP","last_timestamp":1000}`
+
+	if rec := post(line, "application/x-ndjson", "user-key"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a non-admin key, got %d", rec.Code)
+	}
+	if rec := post(line, "application/json", "admin-key"); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for application/json, got %d", rec.Code)
+	}
+	if count, _ := s.Count(context.Background()); count != 0 {
+		t.Errorf("Expected no records imported, got %d", count)
+	}
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	apipb "github.com/censys/scan-takehome/pkg/api/proto"
+	"github.com/censys/scan-takehome/pkg/processor"
+	"github.com/censys/scan-takehome/pkg/store"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer implements the ScanStore gRPC service over a store.Store
+type GRPCServer struct {
+	apipb.UnimplementedScanStoreServer
+
+	store store.Store
+	now   func() time.Time // clock used for timestamp validation
+}
+
+// NewGRPCServer creates a gRPC service backed by s
+func NewGRPCServer(s store.Store) *GRPCServer {
+	return &GRPCServer{store: s, now: time.Now}
+}
+
+// GetRecord returns a record by its composite key
+func (g *GRPCServer) GetRecord(ctx context.Context, req *apipb.GetRecordRequest) (*apipb.ServiceRecord, error) {
+	if err := processor.ValidateKey(req.GetIp(), req.GetPort(), req.GetService()); err != nil {
+		return nil, validationStatus(err)
+	}
+
+	record, err := g.store.Get(ctx, req.GetIp(), req.GetPort(), req.GetService())
+	if err != nil {
+		return nil, storeStatus("failed to get record", err)
+	}
+	if record == nil {
+		return nil, status.Error(codes.NotFound, "record not found")
+	}
+
+	return toProtoRecord(record), nil
+}
+
+// ListRecords returns a page of records
+func (g *GRPCServer) ListRecords(ctx context.Context, req *apipb.ListRecordsRequest) (*apipb.ListRecordsResponse, error) {
+	if req.GetLimit() < 0 || req.GetOffset() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit and offset must not be negative")
+	}
+
+	records, err := g.store.List(ctx, int(req.GetLimit()), int(req.GetOffset()))
+	if err != nil {
+		return nil, storeStatus("failed to list records", err)
+	}
+
+	resp := &apipb.ListRecordsResponse{Records: make([]*apipb.ServiceRecord, len(records))}
+	for i, r := range records {
+		resp.Records[i] = toProtoRecord(r)
+	}
+	return resp, nil
+}
+
+// UpsertRecord stores a record if it is newer than the existing one
+func (g *GRPCServer) UpsertRecord(ctx context.Context, req *apipb.UpsertRecordRequest) (*apipb.UpsertRecordResponse, error) {
+	if req.GetRecord() == nil {
+		return nil, status.Error(codes.InvalidArgument, "record is required")
+	}
+
+	record := fromProtoRecord(req.GetRecord())
+	if err := processor.ValidateRecord(record, g.now()); err != nil {
+		return nil, validationStatus(err)
+	}
+
+	updated, err := g.store.Upsert(ctx, record)
+	if err != nil {
+		return nil, storeStatus("failed to upsert record", err)
+	}
+
+	return &apipb.UpsertRecordResponse{Updated: updated}, nil
+}
+
+// WatchRecords streams store events until the client cancels or the store
+// is closed
+// Headers are sent once the watch is registered, so clients can wait on
+// them before making changes they expect to observe
+func (g *GRPCServer) WatchRecords(req *apipb.WatchRecordsRequest, stream apipb.ScanStore_WatchRecordsServer) error {
+	ctx := stream.Context()
+
+	events, err := g.store.Watch(ctx)
+	if err != nil {
+		if errors.Is(err, store.ErrStoreClosed) {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		return storeStatus("failed to watch records", err)
+	}
+	defer g.store.Unwatch(events)
+
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for ev := range events {
+		if err := stream.Send(toProtoEvent(ev)); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return status.Error(codes.Unavailable, store.ErrStoreClosed.Error())
+}
+
+// validationStatus converts a *processor.ValidationError into an
+// INVALID_ARGUMENT status with one field violation per invalid field
+func validationStatus(err error) error {
+	var verr *processor.ValidationError
+	if !errors.As(err, &verr) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	details := &errdetails.BadRequest{}
+	for _, fe := range verr.Errors {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       fe.Field,
+			Description: fe.Message,
+		})
+	}
+
+	st, detailErr := status.New(codes.InvalidArgument, verr.Error()).WithDetails(details)
+	if detailErr != nil {
+		return status.Error(codes.InvalidArgument, verr.Error())
+	}
+	return st.Err()
+}
+
+// storeStatus logs a store failure and reports it without internal details
+func storeStatus(msg string, err error) error {
+	slog.Error(msg, slog.Any("error", err))
+	return status.Error(codes.Internal, msg)
+}
+
+func toProtoRecord(r *store.ServiceRecord) *apipb.ServiceRecord {
+	if r == nil {
+		return nil
+	}
+	pr := &apipb.ServiceRecord{
+		Ip:            r.IP.String(),
+		Port:          r.Port,
+		Service:       r.Service,
+		LastTimestamp: r.LastTimestamp,
+		Response:      r.Response,
+		TlsVersion:    r.TLSVersion,
+		StatusCode:    int32(r.StatusCode),
+	}
+	if !r.UpdatedAt.IsZero() {
+		pr.UpdatedAt = timestamppb.New(r.UpdatedAt)
+	}
+	return pr
+}
+
+// fromProtoRecord converts a client-supplied record, ignoring updated_at
+// which the store maintains
+func fromProtoRecord(pr *apipb.ServiceRecord) *store.ServiceRecord {
+	return &store.ServiceRecord{
+		IP:            store.IPAddress(pr.GetIp()),
+		Port:          pr.GetPort(),
+		Service:       pr.GetService(),
+		LastTimestamp: pr.GetLastTimestamp(),
+		Response:      pr.GetResponse(),
+		TLSVersion:    pr.GetTlsVersion(),
+		StatusCode:    int(pr.GetStatusCode()),
+	}
+}
+
+// eventTypes maps store event types to their protobuf enum values
+var eventTypes = map[store.EventType]apipb.EventType{
+	store.EventCreated: apipb.EventType_EVENT_TYPE_CREATED,
+	store.EventUpdated: apipb.EventType_EVENT_TYPE_UPDATED,
+	store.EventSkipped: apipb.EventType_EVENT_TYPE_SKIPPED,
+}
+
+func toProtoEvent(ev store.StoreEvent) *apipb.RecordEvent {
+	return &apipb.RecordEvent{
+		Type:     eventTypes[ev.Type],
+		Record:   toProtoRecord(ev.Record),
+		Previous: toProtoRecord(ev.Previous),
+	}
+}
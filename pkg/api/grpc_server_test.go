@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	apipb "github.com/censys/scan-takehome/pkg/api/proto"
+	"github.com/censys/scan-takehome/pkg/store"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCTestClient serves a GRPCServer for s over an in-memory listener
+func newGRPCTestClient(t *testing.T, s store.Store) apipb.ScanStoreClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	apipb.RegisterScanStoreServer(server, NewGRPCServer(s))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return apipb.NewScanStoreClient(conn)
+}
+
+// fieldViolations returns the field names reported in a status's BadRequest details
+func fieldViolations(t *testing.T, err error) []string {
+	t.Helper()
+
+	st := status.Convert(err)
+	var fields []string
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	return fields
+}
+
+// TestGRPCGetRecord tests lookups, NOT_FOUND and key validation
+func TestGRPCGetRecord(t *testing.T) {
+	_, s := newTestServer(t)
+	client := newGRPCTestClient(t, s)
+	ctx := context.Background()
+
+	record, err := client.GetRecord(ctx, &apipb.GetRecordRequest{Ip: "10.0.0.2", Port: 22, Service: "SSH"})
+	if err != nil {
+		t.Fatalf("GetRecord failed: %v", err)
+	}
+	if record.GetResponse() != "ssh 10.0.0.2" {
+		t.Errorf("Expected response 'ssh 10.0.0.2', got %q", record.GetResponse())
+	}
+	if record.GetUpdatedAt() == nil {
+		t.Error("Expected updated_at to be set")
+	}
+
+	_, err = client.GetRecord(ctx, &apipb.GetRecordRequest{Ip: "10.0.0.2", Port: 443, Service: "HTTPS"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", code)
+	}
+
+	_, err = client.GetRecord(ctx, &apipb.GetRecordRequest{Ip: "not-an-ip", Port: 0, Service: "SSH"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", code)
+	}
+	fields := fieldViolations(t, err)
+	if len(fields) != 2 || fields[0] != "ip" || fields[1] != "port" {
+		t.Errorf("Expected ip and port violations, got %v", fields)
+	}
+}
+
+// TestGRPCListRecords tests pagination and argument validation
+func TestGRPCListRecords(t *testing.T) {
+	_, s := newTestServer(t)
+	client := newGRPCTestClient(t, s)
+	ctx := context.Background()
+
+	resp, err := client.ListRecords(ctx, &apipb.ListRecordsRequest{})
+	if err != nil {
+		t.Fatalf("ListRecords failed: %v", err)
+	}
+	if len(resp.GetRecords()) != 10 {
+		t.Errorf("Expected 10 records, got %d", len(resp.GetRecords()))
+	}
+
+	resp, err = client.ListRecords(ctx, &apipb.ListRecordsRequest{Limit: 3, Offset: 1})
+	if err != nil {
+		t.Fatalf("ListRecords failed: %v", err)
+	}
+	if len(resp.GetRecords()) != 3 {
+		t.Errorf("Expected 3 records, got %d", len(resp.GetRecords()))
+	}
+	if got := resp.GetRecords()[0].GetLastTimestamp(); got != 2003 {
+		t.Errorf("Expected second newest record (2003) first, got %d", got)
+	}
+
+	_, err = client.ListRecords(ctx, &apipb.ListRecordsRequest{Limit: -1})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", code)
+	}
+}
+
+// TestGRPCUpsertRecord tests writes, out-of-order skips and validation
+func TestGRPCUpsertRecord(t *testing.T) {
+	s := store.NewMemoryStore()
+	defer s.Close()
+	client := newGRPCTestClient(t, s)
+	ctx := context.Background()
+
+	upsert := func(ts int64, response string) (*apipb.UpsertRecordResponse, error) {
+		return client.UpsertRecord(ctx, &apipb.UpsertRecordRequest{Record: &apipb.ServiceRecord{
+			Ip: "1.1.1.1", Port: 443, Service: "HTTPS", LastTimestamp: ts, Response: response,
+			TlsVersion: "TLSv1.3", StatusCode: 200,
+		}})
+	}
+
+	resp, err := upsert(2000, "new")
+	if err != nil {
+		t.Fatalf("UpsertRecord failed: %v", err)
+	}
+	if !resp.GetUpdated() {
+		t.Error("Expected first upsert to update")
+	}
+
+	resp, err = upsert(1000, "old")
+	if err != nil {
+		t.Fatalf("UpsertRecord failed: %v", err)
+	}
+	if resp.GetUpdated() {
+		t.Error("Expected older upsert to be skipped")
+	}
+
+	stored, err := s.Get(ctx, "1.1.1.1", 443, "HTTPS")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if stored.Response != "new" || stored.TLSVersion != "TLSv1.3" || stored.StatusCode != 200 {
+		t.Errorf("Expected newest record with V3 fields, got %+v", stored)
+	}
+
+	_, err = client.UpsertRecord(ctx, &apipb.UpsertRecordRequest{Record: &apipb.ServiceRecord{
+		Ip: "1.1.1.1", Port: 443, Service: "https", LastTimestamp: time.Now().Add(time.Hour).Unix(),
+	}})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", code)
+	}
+	fields := fieldViolations(t, err)
+	if len(fields) != 2 || fields[0] != "service" || fields[1] != "timestamp" {
+		t.Errorf("Expected service and timestamp violations, got %v", fields)
+	}
+
+	_, err = client.UpsertRecord(ctx, &apipb.UpsertRecordRequest{})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a missing record, got %v", code)
+	}
+}
+
+// TestGRPCWatchRecords tests that upserts are streamed to watchers
+func TestGRPCWatchRecords(t *testing.T) {
+	s := store.NewMemoryStore()
+	defer s.Close()
+	client := newGRPCTestClient(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchRecords(ctx, &apipb.WatchRecordsRequest{})
+	if err != nil {
+		t.Fatalf("WatchRecords failed: %v", err)
+	}
+	// Headers arrive once the server has registered the watch
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Header failed: %v", err)
+	}
+
+	for _, ts := range []int64{2000, 3000, 1000} {
+		_, err := client.UpsertRecord(ctx, &apipb.UpsertRecordRequest{Record: &apipb.ServiceRecord{
+			Ip: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: ts,
+		}})
+		if err != nil {
+			t.Fatalf("UpsertRecord failed: %v", err)
+		}
+	}
+
+	expected := []apipb.EventType{
+		apipb.EventType_EVENT_TYPE_CREATED,
+		apipb.EventType_EVENT_TYPE_UPDATED,
+		apipb.EventType_EVENT_TYPE_SKIPPED,
+	}
+	for i, want := range expected {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if ev.GetType() != want {
+			t.Errorf("Event %d: expected %v, got %v", i, want, ev.GetType())
+		}
+	}
+
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("Expected Canceled after cancel, got %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// requestIDHeader carries the per-request ID back to the client
+const requestIDHeader = "X-Request-ID"
+
+// contextKey namespaces values stored in request contexts by this package
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// Middleware wraps an http.Handler with cross-cutting behaviour
+type Middleware = func(http.Handler) http.Handler
+
+// Chain composes middleware so the first argument is the outermost wrapper
+func Chain(middleware ...Middleware) Middleware {
+	return func(h http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			h = middleware[i](h)
+		}
+		return h
+	}
+}
+
+// RequestIDFromContext returns the ID assigned by RequestID, or "" if none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// RequestID assigns every request a UUID, returned in the X-Request-ID
+// header and available via RequestIDFromContext
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := uuid.NewString()
+			w.Header().Set(requestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+		})
+	}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades take over the connection, which they look
+// for directly rather than through http.ResponseController
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// RequestLogger logs the method, path, status code and latency of every
+// request
+func RequestLogger(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			logger.Info("handled request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Duration("latency", time.Since(start)),
+				slog.String("request_id", RequestIDFromContext(r.Context())),
+			)
+		})
+	}
+}
+
+// Recovery turns a panicking handler into a 500 response and logs the stack
+// trace instead of letting net/http drop the connection
+func Recovery(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				// ErrAbortHandler is net/http's signal to abort silently
+				if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(rec)
+				}
+
+				logger.Error("recovered from panic",
+					slog.Any("panic", rec),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("request_id", RequestIDFromContext(r.Context())),
+					slog.String("stack", string(debug.Stack())),
+				)
+				writeError(w, http.StatusInternalServerError, "internal server error")
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Client rate limiter settings for RateLimitMiddleware
+const (
+	// clientIdleTimeout is how long a client's limiter is kept after its
+	// last request
+	clientIdleTimeout = 5 * time.Minute
+	// clientSweepInterval is how often idle limiters are removed
+	clientSweepInterval = time.Minute
+)
+
+// clientLimiter is the token bucket of one client IP
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen atomic.Int64 // Unix nanoseconds of the last request
+}
+
+// clientRateLimiter holds a token bucket per client IP
+type clientRateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	clients sync.Map // IP -> *clientLimiter
+}
+
+// get returns the limiter for ip, creating it on first use
+func (l *clientRateLimiter) get(ip string, now time.Time) *clientLimiter {
+	v, ok := l.clients.Load(ip)
+	if !ok {
+		v, _ = l.clients.LoadOrStore(ip, &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)})
+	}
+	c := v.(*clientLimiter)
+	c.lastSeen.Store(now.UnixNano())
+	return c
+}
+
+// sweep removes limiters that have been idle for clientIdleTimeout
+// A removed client starts again with a full bucket
+func (l *clientRateLimiter) sweep(now time.Time) {
+	cutoff := now.Add(-clientIdleTimeout).UnixNano()
+	l.clients.Range(func(ip, v any) bool {
+		if v.(*clientLimiter).lastSeen.Load() < cutoff {
+			l.clients.Delete(ip)
+		}
+		return true
+	})
+}
+
+// RateLimitMiddleware allows each client IP requestsPerSecond requests per
+// second with bursts of up to burst, and rejects the rest with 429 Too Many
+// Requests and a Retry-After header
+// Clients are identified by RemoteAddr, so behind a proxy every request
+// shares the proxy's limit; a client's limiter is dropped after 5 minutes
+// without requests by a goroutine that runs for the life of the process
+func RateLimitMiddleware(requestsPerSecond float64, burst int) Middleware {
+	l := &clientRateLimiter{limit: rate.Limit(requestsPerSecond), burst: burst}
+	go func() {
+		ticker := time.NewTicker(clientSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			l.sweep(now)
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now()
+			res := l.get(clientIP(r), now).limiter.ReserveN(now, 1)
+			if delay := res.DelayFrom(now); !res.OK() || delay > 0 {
+				res.CancelAt(now)
+				retryAfter := int(math.Ceil(delay.Seconds()))
+				if !res.OK() {
+					// Only a zero burst never allows a request
+					retryAfter = int(clientIdleTimeout.Seconds())
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+				writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the host part of the request's RemoteAddr
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// corsAllowedHeaders are the request headers browsers may send cross-origin
+const corsAllowedHeaders = "Authorization, Content-Type"
+
+// CORSMiddleware lets browser pages from allowedOrigins call the API
+// A request whose Origin is listed, or any Origin if allowedOrigins holds
+// "*", gets an Access-Control-Allow-Origin header; preflight OPTIONS
+// requests are answered directly with the allowed methods and headers, or
+// 403 for other origins
+// Requests without an Origin header are passed through unchanged
+func CORSMiddleware(allowedOrigins []string, allowedMethods []string) Middleware {
+	anyOrigin := slices.Contains(allowedOrigins, "*")
+	methods := strings.Join(allowedMethods, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Responses differ by Origin, so caches must key on it
+			w.Header().Add("Vary", "Origin")
+			allowed := anyOrigin || slices.Contains(allowedOrigins, origin)
+			if allowed {
+				if anyOrigin {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				if !allowed {
+					writeError(w, http.StatusForbidden, "origin not allowed")
+					return
+				}
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MaxBodySize rejects requests whose body is larger than maxBytes with 413
+// Request Entity Too Large
+// A declared Content-Length over the limit is rejected before the handler
+// runs; otherwise the body is wrapped in http.MaxBytesReader, so a handler
+// reading past the limit gets an *http.MaxBytesError
+func MaxBodySize(maxBytes int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// timeoutWriter buffers a handler's response so RequestTimeout can discard
+// it and answer 503 instead
+// Writes fail once ctx is done, even before RequestTimeout has answered, so
+// a handler woken by the cancellation never sees its late write succeed
+type timeoutWriter struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.status == 0 && tw.ctx.Err() == nil {
+		tw.status = status
+	}
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.ctx.Err() != nil {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+// RequestTimeout cancels the request context after d and answers 503
+// Service Unavailable if the handler has not returned by then
+// The response is buffered until the handler returns, so streaming and
+// WebSocket routes must not be wrapped; a handler that panics is re-panicked
+// on the request goroutine so Recovery still sees it
+func RequestTimeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{ctx: ctx, header: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+						return
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+			case <-ctx.Done():
+			}
+
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			// A handler that returned only after the deadline timed out too
+			if ctx.Err() != nil {
+				writeError(w, http.StatusServiceUnavailable, "request timed out")
+				return
+			}
+			maps.Copy(w.Header(), tw.header)
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/google/uuid"
+)
+
+// TestRecoveryReturns500 tests that a panicking handler produces a 500 and
+// the server keeps serving later requests
+func TestRecoveryReturns500(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ts := httptest.NewServer(Chain(RequestID(), RequestLogger(logger), Recovery(logger))(mux))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/panic")
+	if err != nil {
+		t.Fatalf("Request to panicking handler failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/ok")
+	if err != nil {
+		t.Fatalf("Request after panic failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 after recovering, got %d", resp.StatusCode)
+	}
+
+	out := logs.String()
+	if !strings.Contains(out, "recovered from panic") || !strings.Contains(out, "middleware_test.go") {
+		t.Errorf("Expected panic log with stack trace, got %s", out)
+	}
+	if !strings.Contains(out, `"status":500`) {
+		t.Errorf("Expected request log with status 500, got %s", out)
+	}
+}
+
+// TestRequestID tests that each request gets a UUID in the header and context
+func TestRequestID(t *testing.T) {
+	var fromContext string
+	h := RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromContext = RequestIDFromContext(r.Context())
+	}))
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		id := rec.Header().Get("X-Request-ID")
+		if _, err := uuid.Parse(id); err != nil {
+			t.Errorf("Expected X-Request-ID to be a UUID, got %q", id)
+		}
+		if fromContext != id {
+			t.Errorf("Expected context ID %q, got %q", id, fromContext)
+		}
+		if seen[id] {
+			t.Errorf("Expected unique request IDs, got %q twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+// TestRequestLogger tests the logged request attributes
+func TestRequestLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	h := Chain(RequestID(), RequestLogger(logger))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/records/x", nil))
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry %q: %v", logs.String(), err)
+	}
+	if entry["method"] != "GET" || entry["path"] != "/records/x" || entry["status"] != float64(404) {
+		t.Errorf("Expected GET /records/x 404, got %v", entry)
+	}
+	if _, ok := entry["latency"]; !ok {
+		t.Error("Expected latency attribute")
+	}
+	if id, _ := entry["request_id"].(string); id == "" {
+		t.Error("Expected request_id attribute")
+	}
+}
+
+// TestChainOrder tests that Chain applies middleware outermost first
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	h := Chain(mw("a"), mw("b"), mw("c"))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		order = append(order, "handler")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := strings.Join(order, ","); got != "a,b,c,handler" {
+		t.Errorf("Expected a,b,c,handler, got %s", got)
+	}
+}
+
+// TestRateLimitMiddleware tests that requests beyond a client's burst are
+// rejected with 429 and Retry-After, without affecting other clients
+func TestRateLimitMiddleware(t *testing.T) {
+	srv, _ := newTestServer(t)
+	handler := RateLimitMiddleware(1, 5)(srv.Handler())
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var ok, limited int
+	for i := 0; i < 20; i++ {
+		// The port changes between connections but the client does not
+		rec := send(fmt.Sprintf("10.1.1.1:%d", 40000+i))
+		switch rec.Code {
+		case http.StatusOK:
+			ok++
+		case http.StatusTooManyRequests:
+			limited++
+			if rec.Header().Get("Retry-After") != "1" {
+				t.Errorf("Expected Retry-After 1, got %q", rec.Header().Get("Retry-After"))
+			}
+		default:
+			t.Errorf("Expected 200 or 429, got %d", rec.Code)
+		}
+	}
+	if ok != 5 || limited != 15 {
+		t.Errorf("Expected 5 allowed and 15 limited requests, got %d and %d", ok, limited)
+	}
+
+	if rec := send("10.2.2.2:40000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another client to be allowed, got %d", rec.Code)
+	}
+}
+
+// TestClientRateLimiterSweep tests that limiters idle for 5 minutes are
+// removed and active ones kept
+func TestClientRateLimiterSweep(t *testing.T) {
+	l := &clientRateLimiter{limit: 1, burst: 1}
+	now := time.Now()
+	l.get("10.1.1.1", now.Add(-6*time.Minute))
+	l.get("10.2.2.2", now.Add(-time.Minute))
+
+	l.sweep(now)
+
+	if _, ok := l.clients.Load("10.1.1.1"); ok {
+		t.Error("Expected the idle limiter to be removed")
+	}
+	if _, ok := l.clients.Load("10.2.2.2"); !ok {
+		t.Error("Expected the active limiter to be kept")
+	}
+}
+
+// TestWithClientRateLimit tests that the option rate limits every route
+func TestWithClientRateLimit(t *testing.T) {
+	s := store.NewMemoryStore()
+	defer s.Close()
+	srv := NewServer(s, &http.Server{}, WithClientRateLimit(1, 1))
+
+	codes := make([]int, 2)
+	for i := range codes {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		codes[i] = rec.Code
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Expected 200 then 429, got %v", codes)
+	}
+}
+
+// corsRequest sends a request with the given Origin through CORSMiddleware
+// in front of a handler that always returns 200
+func corsRequest(origins []string, method, origin string, preflight bool) *httptest.ResponseRecorder {
+	handler := CORSMiddleware(origins, []string{http.MethodGet, http.MethodDelete})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handled", "true")
+		}))
+
+	req := httptest.NewRequest(method, "/records", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestCORSMatchedOrigin tests that a listed origin is echoed back
+func TestCORSMatchedOrigin(t *testing.T) {
+	rec := corsRequest([]string{"https://a.example", "https://b.example"}, http.MethodGet, "https://b.example", false)
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://b.example" {
+		t.Errorf("Expected https://b.example, got %q", got)
+	}
+	if rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected Vary: Origin, got %q", rec.Header().Get("Vary"))
+	}
+	if rec.Header().Get("X-Handled") != "true" {
+		t.Error("Expected the request to reach the handler")
+	}
+}
+
+// TestCORSUnmatchedOrigin tests that other origins get no CORS headers and
+// that requests without an Origin pass through untouched
+func TestCORSUnmatchedOrigin(t *testing.T) {
+	rec := corsRequest([]string{"https://a.example"}, http.MethodGet, "https://evil.example", false)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no Access-Control-Allow-Origin, got %q", got)
+	}
+	if rec.Header().Get("X-Handled") != "true" {
+		t.Error("Expected the request to reach the handler")
+	}
+
+	rec = corsRequest([]string{"https://a.example"}, http.MethodOptions, "https://evil.example", true)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a preflight from another origin, got %d", rec.Code)
+	}
+
+	rec = corsRequest([]string{"https://a.example"}, http.MethodGet, "", false)
+	if rec.Header().Get("Vary") != "" || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers without an Origin, got %v", rec.Header())
+	}
+}
+
+// TestCORSWildcard tests that "*" allows any origin
+func TestCORSWildcard(t *testing.T) {
+	rec := corsRequest([]string{"*"}, http.MethodGet, "https://anything.example", false)
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected *, got %q", got)
+	}
+}
+
+// TestCORSPreflight tests that a preflight is answered without reaching the
+// handler
+func TestCORSPreflight(t *testing.T) {
+	rec := corsRequest([]string{"https://a.example"}, http.MethodOptions, "https://a.example", true)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, DELETE" {
+		t.Errorf("Expected GET, DELETE, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+		t.Errorf("Expected Authorization to be allowed, got %q", got)
+	}
+	if rec.Header().Get("X-Handled") != "" {
+		t.Error("Expected the preflight not to reach the handler")
+	}
+}
+
+// TestWithCORS tests that preflights reach the middleware ahead of API key
+// checks, which browsers do not send on preflights
+func TestWithCORS(t *testing.T) {
+	s := store.NewMemoryStore()
+	defer s.Close()
+	srv := NewServer(s, &http.Server{}, WithAPIKeys([]string{"key"}), WithCORS([]string{"https://a.example"}, []string{http.MethodGet}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/records", nil)
+	req.Header.Set("Origin", "https://a.example")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://a.example" {
+		t.Errorf("Expected an allowed preflight, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+// TestMaxBodySize tests that bodies over the limit get 413, whether the
+// size is declared or only found while reading
+func TestMaxBodySize(t *testing.T) {
+	handler := MaxBodySize(10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			var maxErr *http.MaxBytesError
+			if !errors.As(err, &maxErr) {
+				t.Errorf("Expected *http.MaxBytesError, got %v", err)
+			}
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789")))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for a body at the limit, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", 100))))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a declared oversized body, got %d", rec.Code)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Errorf("Expected a JSON error body, got %q", rec.Body.String())
+	}
+
+	// Without a Content-Length the limit is only hit while reading
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", 100)))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an undeclared oversized body, got %d", rec.Code)
+	}
+}
+
+// TestRequestTimeout tests that a handler overrunning the timeout gets a
+// 503 JSON error once its context is cancelled
+func TestRequestTimeout(t *testing.T) {
+	handlerDone := make(chan error, 1)
+	handler := RequestTimeout(50 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		_, err := w.Write([]byte("too late"))
+		handlerDone <- err
+	}))
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the timeout to answer promptly, took %v", elapsed)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error != "request timed out" {
+		t.Errorf("Expected a JSON timeout error, got %q", rec.Body.String())
+	}
+	if err := <-handlerDone; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("Expected writes after the timeout to fail with ErrHandlerTimeout, got %v", err)
+	}
+}
+
+// TestRequestTimeoutPassesResponse tests that a handler finishing in time
+// has its status, headers and body sent unchanged
+func TestRequestTimeoutPassesResponse(t *testing.T) {
+	handler := RequestTimeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handled", "true")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}))
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set(requestIDHeader, "outer")
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusCreated || rec.Body.String() != "done" {
+		t.Errorf("Expected 201 done, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Handled") != "true" || rec.Header().Get(requestIDHeader) != "outer" {
+		t.Errorf("Expected handler and outer headers, got %v", rec.Header())
+	}
+}
+
+// TestRequestTimeoutPanic tests that a panic in a timed handler still
+// reaches Recovery
+func TestRequestTimeoutPanic(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	handler := Chain(Recovery(logger), RequestTimeout(time.Second))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rec.Code)
+	}
+}
+
+// TestServerRequestLimits tests that the server rejects oversized bodies
+// and does not time out record streams
+func TestServerRequestLimits(t *testing.T) {
+	s := store.NewMemoryStore()
+	defer s.Close()
+	srv := NewServer(s, &http.Server{}, WithMaxBodySize(10), WithRequestTimeout(50*time.Millisecond))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/stats", strings.NewReader(strings.Repeat("x", 100)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", resp.StatusCode)
+	}
+
+	stream := openStream(t, ts)
+	time.Sleep(100 * time.Millisecond)
+	s.Upsert(context.Background(), &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+
+	line, err := bufio.NewReader(stream.Body).ReadString('\n')
+	if err != nil || line != "event: created\n" {
+		t.Errorf("Expected the stream to outlive the timeout, got %q, %v", line, err)
+	}
+}
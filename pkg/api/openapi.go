@@ -0,0 +1,259 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// openAPIVersion is the version of the API described by OpenAPISpec
+const openAPIVersion = "1.0.0"
+
+// swaggerUIFiles holds the Swagger UI 4.15.5 distribution served at
+// /swagger-ui/, configured to load /openapi.json
+//
+//go:embed swaggerui
+var swaggerUIFiles embed.FS
+
+// bearerAuth is the name of the API key security scheme
+const bearerAuth = "bearerAuth"
+
+// OpenAPISpec returns an OpenAPI 3.0 description of the HTTP API, served at
+// /openapi.json
+// Routes are described whether or not they are enabled; /admin routes need
+// admin keys and every route but /health needs an API key when keys are
+// configured
+func OpenAPISpec() *openapi3.T {
+	authenticated := openapi3.NewSecurityRequirements().With(openapi3.NewSecurityRequirement().Authenticate(bearerAuth))
+
+	// WithItems only takes an inline schema, so the reference is set directly
+	records := openapi3.NewArraySchema()
+	records.Items = schemaRef("Record")
+
+	schemas := openapi3.Schemas{
+		"Record": openapi3.NewSchemaRef("", openapi3.NewObjectSchema().
+			WithProperty("ip", openapi3.NewStringSchema().WithFormat("ip")).
+			WithProperty("port", portSchema()).
+			WithProperty("service", openapi3.NewStringSchema()).
+			WithProperty("last_timestamp", openapi3.NewInt64Schema()).
+			WithProperty("response", openapi3.NewStringSchema()).
+			WithProperty("updated_at", openapi3.NewDateTimeSchema()).
+			WithProperty("first_seen_at", openapi3.NewDateTimeSchema()).
+			WithProperty("scan_count", openapi3.NewInt64Schema()).
+			WithProperty("previous_response", openapi3.NewStringSchema()).
+			WithProperty("response_truncated", openapi3.NewBoolSchema()).
+			WithProperty("tls_version", openapi3.NewStringSchema()).
+			WithProperty("status_code", openapi3.NewInt32Schema()).
+			WithProperty("message_id", openapi3.NewStringSchema()).
+			WithRequired([]string{"ip", "port", "service", "last_timestamp", "response", "updated_at", "first_seen_at", "scan_count"})),
+		"RecordList": openapi3.NewSchemaRef("", openapi3.NewObjectSchema().
+			WithProperty("data", records).
+			WithProperty("total", openapi3.NewInt64Schema()).
+			WithProperty("limit", openapi3.NewInt32Schema()).
+			WithProperty("offset", openapi3.NewInt32Schema()).
+			WithRequired([]string{"data", "limit", "offset"})),
+		"Stats": openapi3.NewSchemaRef("", openapi3.NewObjectSchema().
+			WithProperty("total_records", openapi3.NewInt64Schema()).
+			WithProperty("records_by_service", openapi3.NewObjectSchema().WithAdditionalProperties(openapi3.NewInt64Schema())).
+			WithProperty("records_by_port", openapi3.NewObjectSchema().WithAdditionalProperties(openapi3.NewInt64Schema())).
+			WithProperty("oldest_timestamp", openapi3.NewInt64Schema()).
+			WithProperty("newest_timestamp", openapi3.NewInt64Schema())),
+		"Health": openapi3.NewSchemaRef("", openapi3.NewObjectSchema().
+			WithProperty("status", openapi3.NewStringSchema().WithEnum("ok", "unhealthy")).
+			WithProperty("error", openapi3.NewStringSchema())),
+		"Deleted": openapi3.NewSchemaRef("", openapi3.NewObjectSchema().
+			WithProperty("deleted", openapi3.NewInt64Schema())),
+		"ImportResult": openapi3.NewSchemaRef("", openapi3.NewObjectSchema().
+			WithProperty("imported", openapi3.NewIntegerSchema()).
+			WithProperty("skipped", openapi3.NewIntegerSchema()).
+			WithProperty("failed", openapi3.NewIntegerSchema()).
+			WithProperty("errors", openapi3.NewArraySchema().WithItems(openapi3.NewObjectSchema().
+				WithProperty("line", openapi3.NewIntegerSchema()).
+				WithProperty("error", openapi3.NewStringSchema()))).
+			WithRequired([]string{"imported", "skipped", "failed", "errors"})),
+		"Error": openapi3.NewSchemaRef("", openapi3.NewObjectSchema().
+			WithProperty("error", openapi3.NewStringSchema()).
+			WithRequired([]string{"error"})),
+	}
+
+	paginated := func(op *openapi3.Operation) *openapi3.Operation {
+		op.AddParameter(openapi3.NewQueryParameter("limit").
+			WithDescription("Page size, capped at " + strconv.Itoa(maxLimit)).
+			WithSchema(openapi3.NewInt32Schema().WithMin(1).WithDefault(defaultLimit)))
+		op.AddParameter(openapi3.NewQueryParameter("offset").
+			WithDescription("Records to skip").
+			WithSchema(openapi3.NewInt32Schema().WithMin(0).WithDefault(0)))
+		return op
+	}
+
+	health := operation("getHealth", "Check the API and store are reachable",
+		jsonResponseSpec(http.StatusOK, "Healthy", "Health"),
+		jsonResponseSpec(http.StatusServiceUnavailable, "The store is unreachable", "Health"))
+
+	stats := operation("getStats", "Count records by service and port",
+		jsonResponseSpec(http.StatusOK, "Record counts", "Stats"),
+		errorResponseSpec(http.StatusInternalServerError, "The store failed"))
+	stats.Security = authenticated
+
+	list := paginated(operation("listRecords", "List records, newest scan first",
+		jsonResponseSpec(http.StatusOK, "A page of records", "RecordList"),
+		errorResponseSpec(http.StatusBadRequest, "Invalid filter or pagination"),
+		errorResponseSpec(http.StatusInternalServerError, "The store failed")))
+	list.AddParameter(openapi3.NewQueryParameter("ip").WithDescription("Only records for this IP").WithSchema(openapi3.NewStringSchema()))
+	list.AddParameter(openapi3.NewQueryParameter("port").WithDescription("Only records on this port").WithSchema(portSchema()))
+	list.AddParameter(openapi3.NewQueryParameter("service").WithDescription("Only records for this service").WithSchema(openapi3.NewStringSchema()))
+	list.Security = authenticated
+
+	search := paginated(operation("searchRecords", "Search responses case-insensitively",
+		jsonResponseSpec(http.StatusOK, "A page of matching records, without a total", "RecordList"),
+		errorResponseSpec(http.StatusBadRequest, "Search term too short or invalid pagination"),
+		errorResponseSpec(http.StatusInternalServerError, "The store failed")))
+	search.AddParameter(openapi3.NewQueryParameter("q").
+		WithDescription("Text to find in responses").
+		WithRequired(true).
+		WithSchema(openapi3.NewStringSchema().WithMinLength(minSearchLength)))
+	search.Security = authenticated
+
+	stream := operation("streamRecords", "Stream created and updated records as server-sent events",
+		apiResponse{http.StatusOK, openapi3.NewResponse().
+			WithDescription("An event stream; each event is named created or updated").
+			WithContent(openapi3.NewContentWithSchema(openapi3.NewStringSchema(), []string{"text/event-stream"}))},
+		errorResponseSpec(http.StatusServiceUnavailable, "The store is closed"))
+	stream.Security = authenticated
+
+	ws := operation("recordsWebSocket", "Subscribe to filtered record events and look up IPs over a WebSocket",
+		apiResponse{http.StatusSwitchingProtocols, openapi3.NewResponse().WithDescription("Switched to the WebSocket protocol")})
+	ws.Security = authenticated
+
+	get := operation("getRecord", "Get one record",
+		jsonResponseSpec(http.StatusOK, "The record", "Record"),
+		errorResponseSpec(http.StatusBadRequest, "Invalid port"),
+		errorResponseSpec(http.StatusNotFound, "No such record"),
+		errorResponseSpec(http.StatusInternalServerError, "The store failed"))
+	get.AddParameter(openapi3.NewPathParameter("ip").WithSchema(openapi3.NewStringSchema()))
+	get.AddParameter(openapi3.NewPathParameter("port").WithSchema(portSchema()))
+	get.AddParameter(openapi3.NewPathParameter("service").WithSchema(openapi3.NewStringSchema()))
+	get.Security = authenticated
+
+	deleteOld := operation("deleteOldRecords", "Delete records last scanned before a time (admin)",
+		jsonResponseSpec(http.StatusOK, "How many records were deleted", "Deleted"),
+		errorResponseSpec(http.StatusBadRequest, "Invalid timestamp"),
+		errorResponseSpec(http.StatusInternalServerError, "The store failed"))
+	deleteOld.AddParameter(openapi3.NewQueryParameter("older_than_timestamp").
+		WithDescription("Unix time in seconds").
+		WithRequired(true).
+		WithSchema(openapi3.NewInt64Schema().WithMin(1)))
+	deleteOld.Security = authenticated
+
+	bulkImport := operation("bulkImportRecords", "Upsert newline-delimited JSON records (admin)",
+		jsonResponseSpec(http.StatusOK, "Import counts and the lines that failed to parse", "ImportResult"),
+		errorResponseSpec(http.StatusRequestEntityTooLarge, "Body over 100 MB"),
+		errorResponseSpec(http.StatusUnsupportedMediaType, "Content-Type is not "+ndjsonContentType),
+		errorResponseSpec(http.StatusInternalServerError, "The store failed; earlier batches stay imported"))
+	bulkImport.RequestBody = &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody().
+		WithDescription("One record per line, in the Record shape; store-managed fields are ignored").
+		WithRequired(true).
+		WithContent(openapi3.NewContentWithSchema(openapi3.NewStringSchema(), []string{ndjsonContentType}))}
+	bulkImport.Security = authenticated
+
+	return &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:       "Scan API",
+			Description: "Query the latest scan result for each host, port and service",
+			Version:     openAPIVersion,
+		},
+		Paths: openapi3.NewPaths(
+			openapi3.WithPath("/health", &openapi3.PathItem{Get: health}),
+			openapi3.WithPath("/stats", &openapi3.PathItem{Get: stats}),
+			openapi3.WithPath("/records", &openapi3.PathItem{Get: list}),
+			openapi3.WithPath("/records/search", &openapi3.PathItem{Get: search}),
+			openapi3.WithPath("/records/stream", &openapi3.PathItem{Get: stream}),
+			openapi3.WithPath("/records/{ip}/{port}/{service}", &openapi3.PathItem{Get: get}),
+			openapi3.WithPath("/ws", &openapi3.PathItem{Get: ws}),
+			openapi3.WithPath("/admin/records", &openapi3.PathItem{Delete: deleteOld}),
+			openapi3.WithPath("/admin/bulk-import", &openapi3.PathItem{Post: bulkImport}),
+		),
+		Components: &openapi3.Components{
+			Schemas: schemas,
+			SecuritySchemes: openapi3.SecuritySchemes{
+				bearerAuth: &openapi3.SecuritySchemeRef{Value: openapi3.NewSecurityScheme().
+					WithType("http").
+					WithScheme("bearer").
+					WithDescription("An API key, or an admin key for /admin routes")},
+			},
+		},
+	}
+}
+
+// apiResponse is a response an operation can return with its status
+type apiResponse struct {
+	status   int
+	response *openapi3.Response
+}
+
+// operation returns an operation with the given responses
+func operation(id, summary string, responses ...apiResponse) *openapi3.Operation {
+	op := openapi3.NewOperation()
+	op.OperationID = id
+	op.Summary = summary
+	op.Responses = openapi3.NewResponsesWithCapacity(len(responses))
+	for _, r := range responses {
+		op.Responses.Set(strconv.Itoa(r.status), &openapi3.ResponseRef{Value: r.response})
+	}
+	return op
+}
+
+// jsonResponseSpec returns a response whose body is the named schema
+func jsonResponseSpec(status int, description, schema string) apiResponse {
+	return apiResponse{status, openapi3.NewResponse().
+		WithDescription(description).
+		WithJSONSchemaRef(schemaRef(schema))}
+}
+
+// errorResponseSpec returns a response with an Error body
+func errorResponseSpec(status int, description string) apiResponse {
+	return jsonResponseSpec(status, description, "Error")
+}
+
+// schemaRef references a schema in components
+func schemaRef(name string) *openapi3.SchemaRef {
+	return openapi3.NewSchemaRef("#/components/schemas/"+name, nil)
+}
+
+// portSchema describes a TCP/UDP port
+func portSchema() *openapi3.Schema {
+	return openapi3.NewInt32Schema().WithMin(1).WithMax(65535)
+}
+
+// openAPIJSON is the encoded OpenAPISpec, built on first use
+var openAPIJSON = sync.OnceValues(func() ([]byte, error) {
+	return OpenAPISpec().MarshalJSON()
+})
+
+// handleOpenAPI serves OpenAPISpec as JSON
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	data, err := openAPIJSON()
+	if err != nil {
+		slog.Error("failed to encode openapi spec", slog.Any("error", err))
+		writeError(w, http.StatusInternalServerError, "failed to encode openapi spec")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// swaggerUI serves the embedded Swagger UI under /swagger-ui/
+func swaggerUI() http.Handler {
+	files, err := fs.Sub(swaggerUIFiles, "swaggerui")
+	if err != nil {
+		// The embedded directory is fixed at compile time
+		panic(err)
+	}
+	return http.StripPrefix("/swagger-ui/", http.FileServerFS(files))
+}
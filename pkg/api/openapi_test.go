@@ -0,0 +1,91 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// TestOpenAPISpecValid tests that the generated spec loads and validates,
+// so a broken spec fails CI
+func TestOpenAPISpecValid(t *testing.T) {
+	data, err := OpenAPISpec().MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %v", err)
+	}
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(data)
+	if err != nil {
+		t.Fatalf("LoadFromData failed: %v", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		t.Errorf("Expected a valid spec, got %v", err)
+	}
+}
+
+// TestOpenAPISpecRoutes tests that every route registered on the server is
+// described
+func TestOpenAPISpecRoutes(t *testing.T) {
+	spec := OpenAPISpec()
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/health"},
+		{http.MethodGet, "/stats"},
+		{http.MethodGet, "/records"},
+		{http.MethodGet, "/records/search"},
+		{http.MethodGet, "/records/stream"},
+		{http.MethodGet, "/records/{ip}/{port}/{service}"},
+		{http.MethodGet, "/ws"},
+		{http.MethodDelete, "/admin/records"},
+		{http.MethodPost, "/admin/bulk-import"},
+	} {
+		item := spec.Paths.Value(route.path)
+		if item == nil || item.GetOperation(route.method) == nil {
+			t.Errorf("Expected %s %s in the spec", route.method, route.path)
+		}
+	}
+}
+
+// TestServeOpenAPI tests that the spec and Swagger UI are served without an
+// API key
+func TestServeOpenAPI(t *testing.T) {
+	s := store.NewMemoryStore()
+	t.Cleanup(func() { s.Close() })
+	handler := NewServer(s, &http.Server{}, WithAPIKeys([]string{"secret"})).Handler()
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %q", ct)
+	}
+	if _, err := openapi3.NewLoader().LoadFromData(w.Body.Bytes()); err != nil {
+		t.Errorf("Expected a loadable spec, got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/swagger-ui/", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	body, _ := io.ReadAll(w.Body)
+	if !strings.Contains(string(body), "swagger-ui-bundle.js") {
+		t.Errorf("Expected the Swagger UI page, got %q", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/swagger-ui/swagger-initializer.js", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "../openapi.json") {
+		t.Errorf("Expected the initializer to load ../openapi.json, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,4 @@
+// Package apipb contains the gRPC definition of the scan store API
+package apipb
+
+//go:generate protoc -I ../../.. --go_out=../../.. --go_opt=paths=source_relative --go-grpc_out=../../.. --go-grpc_opt=paths=source_relative pkg/api/proto/store.proto
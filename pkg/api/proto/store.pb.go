@@ -0,0 +1,640 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.7
+// 	protoc        v5.29.3
+// source: pkg/api/proto/store.proto
+
+package apipb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// EventType mirrors store.EventType
+type EventType int32
+
+const (
+	EventType_EVENT_TYPE_UNSPECIFIED EventType = 0
+	EventType_EVENT_TYPE_CREATED     EventType = 1
+	EventType_EVENT_TYPE_UPDATED     EventType = 2
+	EventType_EVENT_TYPE_SKIPPED     EventType = 3
+)
+
+// Enum value maps for EventType.
+var (
+	EventType_name = map[int32]string{
+		0: "EVENT_TYPE_UNSPECIFIED",
+		1: "EVENT_TYPE_CREATED",
+		2: "EVENT_TYPE_UPDATED",
+		3: "EVENT_TYPE_SKIPPED",
+	}
+	EventType_value = map[string]int32{
+		"EVENT_TYPE_UNSPECIFIED": 0,
+		"EVENT_TYPE_CREATED":     1,
+		"EVENT_TYPE_UPDATED":     2,
+		"EVENT_TYPE_SKIPPED":     3,
+	}
+)
+
+func (x EventType) Enum() *EventType {
+	p := new(EventType)
+	*p = x
+	return p
+}
+
+func (x EventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_pkg_api_proto_store_proto_enumTypes[0].Descriptor()
+}
+
+func (EventType) Type() protoreflect.EnumType {
+	return &file_pkg_api_proto_store_proto_enumTypes[0]
+}
+
+func (x EventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EventType.Descriptor instead.
+func (EventType) EnumDescriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{0}
+}
+
+// ServiceRecord is a stored scan result
+type ServiceRecord struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Port          uint32                 `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	Service       string                 `protobuf:"bytes,3,opt,name=service,proto3" json:"service,omitempty"`
+	LastTimestamp int64                  `protobuf:"varint,4,opt,name=last_timestamp,json=lastTimestamp,proto3" json:"last_timestamp,omitempty"`
+	Response      string                 `protobuf:"bytes,5,opt,name=response,proto3" json:"response,omitempty"`
+	UpdatedAt     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	TlsVersion    string                 `protobuf:"bytes,7,opt,name=tls_version,json=tlsVersion,proto3" json:"tls_version,omitempty"`
+	StatusCode    int32                  `protobuf:"varint,8,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServiceRecord) Reset() {
+	*x = ServiceRecord{}
+	mi := &file_pkg_api_proto_store_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServiceRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServiceRecord) ProtoMessage() {}
+
+func (x *ServiceRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_api_proto_store_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServiceRecord.ProtoReflect.Descriptor instead.
+func (*ServiceRecord) Descriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ServiceRecord) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *ServiceRecord) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *ServiceRecord) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *ServiceRecord) GetLastTimestamp() int64 {
+	if x != nil {
+		return x.LastTimestamp
+	}
+	return 0
+}
+
+func (x *ServiceRecord) GetResponse() string {
+	if x != nil {
+		return x.Response
+	}
+	return ""
+}
+
+func (x *ServiceRecord) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *ServiceRecord) GetTlsVersion() string {
+	if x != nil {
+		return x.TlsVersion
+	}
+	return ""
+}
+
+func (x *ServiceRecord) GetStatusCode() int32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+type GetRecordRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Port          uint32                 `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	Service       string                 `protobuf:"bytes,3,opt,name=service,proto3" json:"service,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRecordRequest) Reset() {
+	*x = GetRecordRequest{}
+	mi := &file_pkg_api_proto_store_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRecordRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRecordRequest) ProtoMessage() {}
+
+func (x *GetRecordRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_api_proto_store_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRecordRequest.ProtoReflect.Descriptor instead.
+func (*GetRecordRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetRecordRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *GetRecordRequest) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *GetRecordRequest) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+type ListRecordsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// limit of 0 returns all records
+	Limit         int32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int32 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRecordsRequest) Reset() {
+	*x = ListRecordsRequest{}
+	mi := &file_pkg_api_proto_store_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRecordsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRecordsRequest) ProtoMessage() {}
+
+func (x *ListRecordsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_api_proto_store_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRecordsRequest.ProtoReflect.Descriptor instead.
+func (*ListRecordsRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListRecordsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListRecordsRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type ListRecordsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*ServiceRecord       `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRecordsResponse) Reset() {
+	*x = ListRecordsResponse{}
+	mi := &file_pkg_api_proto_store_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRecordsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRecordsResponse) ProtoMessage() {}
+
+func (x *ListRecordsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_api_proto_store_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRecordsResponse.ProtoReflect.Descriptor instead.
+func (*ListRecordsResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListRecordsResponse) GetRecords() []*ServiceRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+type UpsertRecordRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Record        *ServiceRecord         `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpsertRecordRequest) Reset() {
+	*x = UpsertRecordRequest{}
+	mi := &file_pkg_api_proto_store_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpsertRecordRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpsertRecordRequest) ProtoMessage() {}
+
+func (x *UpsertRecordRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_api_proto_store_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpsertRecordRequest.ProtoReflect.Descriptor instead.
+func (*UpsertRecordRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UpsertRecordRequest) GetRecord() *ServiceRecord {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+type UpsertRecordResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// updated is false when the record was skipped for being older
+	Updated       bool `protobuf:"varint,1,opt,name=updated,proto3" json:"updated,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpsertRecordResponse) Reset() {
+	*x = UpsertRecordResponse{}
+	mi := &file_pkg_api_proto_store_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpsertRecordResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpsertRecordResponse) ProtoMessage() {}
+
+func (x *UpsertRecordResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_api_proto_store_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpsertRecordResponse.ProtoReflect.Descriptor instead.
+func (*UpsertRecordResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UpsertRecordResponse) GetUpdated() bool {
+	if x != nil {
+		return x.Updated
+	}
+	return false
+}
+
+type WatchRecordsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRecordsRequest) Reset() {
+	*x = WatchRecordsRequest{}
+	mi := &file_pkg_api_proto_store_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRecordsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRecordsRequest) ProtoMessage() {}
+
+func (x *WatchRecordsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_api_proto_store_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRecordsRequest.ProtoReflect.Descriptor instead.
+func (*WatchRecordsRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{6}
+}
+
+// RecordEvent describes a change (or skipped change) to a record
+type RecordEvent struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Type   EventType              `protobuf:"varint,1,opt,name=type,proto3,enum=scanstore.EventType" json:"type,omitempty"`
+	Record *ServiceRecord         `protobuf:"bytes,2,opt,name=record,proto3" json:"record,omitempty"`
+	// previous is the replaced record, when known
+	Previous      *ServiceRecord `protobuf:"bytes,3,opt,name=previous,proto3" json:"previous,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordEvent) Reset() {
+	*x = RecordEvent{}
+	mi := &file_pkg_api_proto_store_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordEvent) ProtoMessage() {}
+
+func (x *RecordEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_api_proto_store_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordEvent.ProtoReflect.Descriptor instead.
+func (*RecordEvent) Descriptor() ([]byte, []int) {
+	return file_pkg_api_proto_store_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RecordEvent) GetType() EventType {
+	if x != nil {
+		return x.Type
+	}
+	return EventType_EVENT_TYPE_UNSPECIFIED
+}
+
+func (x *RecordEvent) GetRecord() *ServiceRecord {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+func (x *RecordEvent) GetPrevious() *ServiceRecord {
+	if x != nil {
+		return x.Previous
+	}
+	return nil
+}
+
+var File_pkg_api_proto_store_proto protoreflect.FileDescriptor
+
+const file_pkg_api_proto_store_proto_rawDesc = "" +
+	"\n" +
+	"\x19pkg/api/proto/store.proto\x12\tscanstore\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8d\x02\n" +
+	"\rServiceRecord\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\rR\x04port\x12\x18\n" +
+	"\aservice\x18\x03 \x01(\tR\aservice\x12%\n" +
+	"\x0elast_timestamp\x18\x04 \x01(\x03R\rlastTimestamp\x12\x1a\n" +
+	"\bresponse\x18\x05 \x01(\tR\bresponse\x129\n" +
+	"\n" +
+	"updated_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12\x1f\n" +
+	"\vtls_version\x18\a \x01(\tR\n" +
+	"tlsVersion\x12\x1f\n" +
+	"\vstatus_code\x18\b \x01(\x05R\n" +
+	"statusCode\"P\n" +
+	"\x10GetRecordRequest\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\rR\x04port\x12\x18\n" +
+	"\aservice\x18\x03 \x01(\tR\aservice\"B\n" +
+	"\x12ListRecordsRequest\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x05R\x06offset\"I\n" +
+	"\x13ListRecordsResponse\x122\n" +
+	"\arecords\x18\x01 \x03(\v2\x18.scanstore.ServiceRecordR\arecords\"G\n" +
+	"\x13UpsertRecordRequest\x120\n" +
+	"\x06record\x18\x01 \x01(\v2\x18.scanstore.ServiceRecordR\x06record\"0\n" +
+	"\x14UpsertRecordResponse\x12\x18\n" +
+	"\aupdated\x18\x01 \x01(\bR\aupdated\"\x15\n" +
+	"\x13WatchRecordsRequest\"\x9f\x01\n" +
+	"\vRecordEvent\x12(\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x14.scanstore.EventTypeR\x04type\x120\n" +
+	"\x06record\x18\x02 \x01(\v2\x18.scanstore.ServiceRecordR\x06record\x124\n" +
+	"\bprevious\x18\x03 \x01(\v2\x18.scanstore.ServiceRecordR\bprevious*o\n" +
+	"\tEventType\x12\x1a\n" +
+	"\x16EVENT_TYPE_UNSPECIFIED\x10\x00\x12\x16\n" +
+	"\x12EVENT_TYPE_CREATED\x10\x01\x12\x16\n" +
+	"\x12EVENT_TYPE_UPDATED\x10\x02\x12\x16\n" +
+	"\x12EVENT_TYPE_SKIPPED\x10\x032\xb8\x02\n" +
+	"\tScanStore\x12B\n" +
+	"\tGetRecord\x12\x1b.scanstore.GetRecordRequest\x1a\x18.scanstore.ServiceRecord\x12L\n" +
+	"\vListRecords\x12\x1d.scanstore.ListRecordsRequest\x1a\x1e.scanstore.ListRecordsResponse\x12O\n" +
+	"\fUpsertRecord\x12\x1e.scanstore.UpsertRecordRequest\x1a\x1f.scanstore.UpsertRecordResponse\x12H\n" +
+	"\fWatchRecords\x12\x1e.scanstore.WatchRecordsRequest\x1a\x16.scanstore.RecordEvent0\x01B5Z3github.com/censys/scan-takehome/pkg/api/proto;apipbb\x06proto3"
+
+var (
+	file_pkg_api_proto_store_proto_rawDescOnce sync.Once
+	file_pkg_api_proto_store_proto_rawDescData []byte
+)
+
+func file_pkg_api_proto_store_proto_rawDescGZIP() []byte {
+	file_pkg_api_proto_store_proto_rawDescOnce.Do(func() {
+		file_pkg_api_proto_store_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pkg_api_proto_store_proto_rawDesc), len(file_pkg_api_proto_store_proto_rawDesc)))
+	})
+	return file_pkg_api_proto_store_proto_rawDescData
+}
+
+var file_pkg_api_proto_store_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_pkg_api_proto_store_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_pkg_api_proto_store_proto_goTypes = []any{
+	(EventType)(0),                // 0: scanstore.EventType
+	(*ServiceRecord)(nil),         // 1: scanstore.ServiceRecord
+	(*GetRecordRequest)(nil),      // 2: scanstore.GetRecordRequest
+	(*ListRecordsRequest)(nil),    // 3: scanstore.ListRecordsRequest
+	(*ListRecordsResponse)(nil),   // 4: scanstore.ListRecordsResponse
+	(*UpsertRecordRequest)(nil),   // 5: scanstore.UpsertRecordRequest
+	(*UpsertRecordResponse)(nil),  // 6: scanstore.UpsertRecordResponse
+	(*WatchRecordsRequest)(nil),   // 7: scanstore.WatchRecordsRequest
+	(*RecordEvent)(nil),           // 8: scanstore.RecordEvent
+	(*timestamppb.Timestamp)(nil), // 9: google.protobuf.Timestamp
+}
+var file_pkg_api_proto_store_proto_depIdxs = []int32{
+	9,  // 0: scanstore.ServiceRecord.updated_at:type_name -> google.protobuf.Timestamp
+	1,  // 1: scanstore.ListRecordsResponse.records:type_name -> scanstore.ServiceRecord
+	1,  // 2: scanstore.UpsertRecordRequest.record:type_name -> scanstore.ServiceRecord
+	0,  // 3: scanstore.RecordEvent.type:type_name -> scanstore.EventType
+	1,  // 4: scanstore.RecordEvent.record:type_name -> scanstore.ServiceRecord
+	1,  // 5: scanstore.RecordEvent.previous:type_name -> scanstore.ServiceRecord
+	2,  // 6: scanstore.ScanStore.GetRecord:input_type -> scanstore.GetRecordRequest
+	3,  // 7: scanstore.ScanStore.ListRecords:input_type -> scanstore.ListRecordsRequest
+	5,  // 8: scanstore.ScanStore.UpsertRecord:input_type -> scanstore.UpsertRecordRequest
+	7,  // 9: scanstore.ScanStore.WatchRecords:input_type -> scanstore.WatchRecordsRequest
+	1,  // 10: scanstore.ScanStore.GetRecord:output_type -> scanstore.ServiceRecord
+	4,  // 11: scanstore.ScanStore.ListRecords:output_type -> scanstore.ListRecordsResponse
+	6,  // 12: scanstore.ScanStore.UpsertRecord:output_type -> scanstore.UpsertRecordResponse
+	8,  // 13: scanstore.ScanStore.WatchRecords:output_type -> scanstore.RecordEvent
+	10, // [10:14] is the sub-list for method output_type
+	6,  // [6:10] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_pkg_api_proto_store_proto_init() }
+func file_pkg_api_proto_store_proto_init() {
+	if File_pkg_api_proto_store_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_api_proto_store_proto_rawDesc), len(file_pkg_api_proto_store_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pkg_api_proto_store_proto_goTypes,
+		DependencyIndexes: file_pkg_api_proto_store_proto_depIdxs,
+		EnumInfos:         file_pkg_api_proto_store_proto_enumTypes,
+		MessageInfos:      file_pkg_api_proto_store_proto_msgTypes,
+	}.Build()
+	File_pkg_api_proto_store_proto = out.File
+	file_pkg_api_proto_store_proto_goTypes = nil
+	file_pkg_api_proto_store_proto_depIdxs = nil
+}
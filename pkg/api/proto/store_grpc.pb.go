@@ -0,0 +1,251 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: pkg/api/proto/store.proto
+
+package apipb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ScanStore_GetRecord_FullMethodName    = "/scanstore.ScanStore/GetRecord"
+	ScanStore_ListRecords_FullMethodName  = "/scanstore.ScanStore/ListRecords"
+	ScanStore_UpsertRecord_FullMethodName = "/scanstore.ScanStore/UpsertRecord"
+	ScanStore_WatchRecords_FullMethodName = "/scanstore.ScanStore/WatchRecords"
+)
+
+// ScanStoreClient is the client API for ScanStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ScanStore exposes the scan record store to programmatic clients
+type ScanStoreClient interface {
+	// GetRecord returns a record by its composite key, or NOT_FOUND
+	GetRecord(ctx context.Context, in *GetRecordRequest, opts ...grpc.CallOption) (*ServiceRecord, error)
+	// ListRecords returns records ordered by last_timestamp descending
+	ListRecords(ctx context.Context, in *ListRecordsRequest, opts ...grpc.CallOption) (*ListRecordsResponse, error)
+	// UpsertRecord stores a record if it is newer than the existing one
+	UpsertRecord(ctx context.Context, in *UpsertRecordRequest, opts ...grpc.CallOption) (*UpsertRecordResponse, error)
+	// WatchRecords streams record changes until the client cancels
+	WatchRecords(ctx context.Context, in *WatchRecordsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RecordEvent], error)
+}
+
+type scanStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewScanStoreClient(cc grpc.ClientConnInterface) ScanStoreClient {
+	return &scanStoreClient{cc}
+}
+
+func (c *scanStoreClient) GetRecord(ctx context.Context, in *GetRecordRequest, opts ...grpc.CallOption) (*ServiceRecord, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServiceRecord)
+	err := c.cc.Invoke(ctx, ScanStore_GetRecord_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scanStoreClient) ListRecords(ctx context.Context, in *ListRecordsRequest, opts ...grpc.CallOption) (*ListRecordsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRecordsResponse)
+	err := c.cc.Invoke(ctx, ScanStore_ListRecords_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scanStoreClient) UpsertRecord(ctx context.Context, in *UpsertRecordRequest, opts ...grpc.CallOption) (*UpsertRecordResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpsertRecordResponse)
+	err := c.cc.Invoke(ctx, ScanStore_UpsertRecord_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scanStoreClient) WatchRecords(ctx context.Context, in *WatchRecordsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RecordEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ScanStore_ServiceDesc.Streams[0], ScanStore_WatchRecords_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRecordsRequest, RecordEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ScanStore_WatchRecordsClient = grpc.ServerStreamingClient[RecordEvent]
+
+// ScanStoreServer is the server API for ScanStore service.
+// All implementations must embed UnimplementedScanStoreServer
+// for forward compatibility.
+//
+// ScanStore exposes the scan record store to programmatic clients
+type ScanStoreServer interface {
+	// GetRecord returns a record by its composite key, or NOT_FOUND
+	GetRecord(context.Context, *GetRecordRequest) (*ServiceRecord, error)
+	// ListRecords returns records ordered by last_timestamp descending
+	ListRecords(context.Context, *ListRecordsRequest) (*ListRecordsResponse, error)
+	// UpsertRecord stores a record if it is newer than the existing one
+	UpsertRecord(context.Context, *UpsertRecordRequest) (*UpsertRecordResponse, error)
+	// WatchRecords streams record changes until the client cancels
+	WatchRecords(*WatchRecordsRequest, grpc.ServerStreamingServer[RecordEvent]) error
+	mustEmbedUnimplementedScanStoreServer()
+}
+
+// UnimplementedScanStoreServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedScanStoreServer struct{}
+
+func (UnimplementedScanStoreServer) GetRecord(context.Context, *GetRecordRequest) (*ServiceRecord, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRecord not implemented")
+}
+func (UnimplementedScanStoreServer) ListRecords(context.Context, *ListRecordsRequest) (*ListRecordsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRecords not implemented")
+}
+func (UnimplementedScanStoreServer) UpsertRecord(context.Context, *UpsertRecordRequest) (*UpsertRecordResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpsertRecord not implemented")
+}
+func (UnimplementedScanStoreServer) WatchRecords(*WatchRecordsRequest, grpc.ServerStreamingServer[RecordEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchRecords not implemented")
+}
+func (UnimplementedScanStoreServer) mustEmbedUnimplementedScanStoreServer() {}
+func (UnimplementedScanStoreServer) testEmbeddedByValue()                   {}
+
+// UnsafeScanStoreServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ScanStoreServer will
+// result in compilation errors.
+type UnsafeScanStoreServer interface {
+	mustEmbedUnimplementedScanStoreServer()
+}
+
+func RegisterScanStoreServer(s grpc.ServiceRegistrar, srv ScanStoreServer) {
+	// If the following call pancis, it indicates UnimplementedScanStoreServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ScanStore_ServiceDesc, srv)
+}
+
+func _ScanStore_GetRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRecordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScanStoreServer).GetRecord(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScanStore_GetRecord_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScanStoreServer).GetRecord(ctx, req.(*GetRecordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScanStore_ListRecords_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRecordsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScanStoreServer).ListRecords(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScanStore_ListRecords_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScanStoreServer).ListRecords(ctx, req.(*ListRecordsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScanStore_UpsertRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpsertRecordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScanStoreServer).UpsertRecord(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScanStore_UpsertRecord_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScanStoreServer).UpsertRecord(ctx, req.(*UpsertRecordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScanStore_WatchRecords_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRecordsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ScanStoreServer).WatchRecords(m, &grpc.GenericServerStream[WatchRecordsRequest, RecordEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ScanStore_WatchRecordsServer = grpc.ServerStreamingServer[RecordEvent]
+
+// ScanStore_ServiceDesc is the grpc.ServiceDesc for ScanStore service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ScanStore_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "scanstore.ScanStore",
+	HandlerType: (*ScanStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRecord",
+			Handler:    _ScanStore_GetRecord_Handler,
+		},
+		{
+			MethodName: "ListRecords",
+			Handler:    _ScanStore_ListRecords_Handler,
+		},
+		{
+			MethodName: "UpsertRecord",
+			Handler:    _ScanStore_UpsertRecord_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRecords",
+			Handler:       _ScanStore_WatchRecords_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pkg/api/proto/store.proto",
+}
@@ -0,0 +1,543 @@
+// Package api serves stored scan results over HTTP
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// Pagination limits for list endpoints
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// minSearchLength is the shortest accepted /records/search term
+const minSearchLength = 3
+
+// Request limits applied unless overridden by ServerOptions
+const (
+	defaultMaxBodyBytes   = 1 << 20
+	defaultRequestTimeout = 30 * time.Second
+)
+
+// Server is an HTTP API over a store.Store
+type Server struct {
+	store      store.Store
+	httpServer *http.Server
+	mux        *http.ServeMux
+	handler    http.Handler // mux wrapped in middleware
+	apiKeys    []string     // bearer keys for protected routes; none disables auth
+	adminKeys  []string     // bearer keys for /admin routes; none disables them
+	rateLimit  Middleware   // per-client rate limit; nil disables it
+	cors       Middleware   // cross-origin headers; nil disables them
+
+	maxBodyBytes   int64         // largest accepted request body; 0 disables the limit
+	requestTimeout time.Duration // deadline for non-streaming routes; 0 disables it
+
+	shutdown     chan struct{} // closed on Shutdown to end record streams
+	shutdownOnce sync.Once
+}
+
+// ServerOption configures a Server
+type ServerOption func(*Server)
+
+// WithAdminAPIKeys enables the /admin maintenance routes, which require one
+// of keys as a bearer token
+// Admin routes are not registered when no admin keys are configured
+func WithAdminAPIKeys(keys []string) ServerOption {
+	return func(s *Server) {
+		s.adminKeys = keys
+	}
+}
+
+// WithClientRateLimit limits each client IP to requestsPerSecond requests
+// per second with bursts of up to burst, using RateLimitMiddleware
+// Servers given the same option share the limits, so a client cannot double
+// its rate by using both the HTTP and HTTPS listeners
+func WithClientRateLimit(requestsPerSecond float64, burst int) ServerOption {
+	limit := RateLimitMiddleware(requestsPerSecond, burst)
+	return func(s *Server) {
+		s.rateLimit = limit
+	}
+}
+
+// WithCORS lets browser pages from allowedOrigins ("*" for any) make
+// allowedMethods requests, using CORSMiddleware
+func WithCORS(allowedOrigins []string, allowedMethods []string) ServerOption {
+	return func(s *Server) {
+		s.cors = CORSMiddleware(allowedOrigins, allowedMethods)
+	}
+}
+
+// WithMaxBodySize rejects request bodies larger than maxBytes with 413,
+// instead of the 1 MB default; 0 disables the limit
+// /admin/bulk-import always accepts up to 100 MB
+func WithMaxBodySize(maxBytes int64) ServerOption {
+	return func(s *Server) {
+		s.maxBodyBytes = maxBytes
+	}
+}
+
+// WithRequestTimeout answers 503 when a request takes longer than d,
+// instead of the 30 second default; 0 disables the timeout
+// /records/stream, /ws and /admin/bulk-import are long-lived and never
+// time out
+func WithRequestTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
+}
+
+// WithAPIKeys requires one of keys as a bearer token on every route except
+// /health, /openapi.json and /swagger-ui/
+// An empty list leaves the API unauthenticated
+func WithAPIKeys(keys []string) ServerOption {
+	return func(s *Server) {
+		s.apiKeys = keys
+	}
+}
+
+// NewServer creates a server for s, using srv for the listener settings
+// srv's Handler is replaced with the API routes, wrapped in request ID,
+// logging, panic recovery and, when configured, CORS and rate limiting
+// middleware; routes also limit their body size and, other than streams and
+// imports, time out
+func NewServer(s store.Store, srv *http.Server, opts ...ServerOption) *Server {
+	server := &Server{
+		store:      s,
+		httpServer: srv,
+		mux:        http.NewServeMux(),
+		shutdown:   make(chan struct{}),
+
+		maxBodyBytes:   defaultMaxBodyBytes,
+		requestTimeout: defaultRequestTimeout,
+	}
+	for _, opt := range opts {
+		opt(server)
+	}
+
+	// Protected routes require an API key when any are configured
+	protect := Chain()
+	if len(server.apiKeys) > 0 {
+		protect = APIKeyAuth(server.apiKeys)
+	}
+	// Limits are per route so imports can accept larger bodies, and streams
+	// can stay open indefinitely
+	limitBody := Chain()
+	if server.maxBodyBytes > 0 {
+		limitBody = MaxBodySize(server.maxBodyBytes)
+	}
+	bounded := limitBody
+	if server.requestTimeout > 0 {
+		bounded = Chain(limitBody, RequestTimeout(server.requestTimeout))
+	}
+
+	server.mux.Handle("GET /health", bounded(http.HandlerFunc(server.handleHealth)))
+	server.mux.Handle("GET /openapi.json", bounded(http.HandlerFunc(server.handleOpenAPI)))
+	server.mux.Handle("GET /swagger-ui/", bounded(swaggerUI()))
+	server.mux.Handle("GET /stats", protect(bounded(http.HandlerFunc(server.handleStats))))
+	server.mux.Handle("GET /records", protect(bounded(http.HandlerFunc(server.handleListRecords))))
+	server.mux.Handle("GET /records/search", protect(bounded(http.HandlerFunc(server.handleSearchRecords))))
+	server.mux.Handle("GET /records/stream", protect(limitBody(http.HandlerFunc(server.handleStreamRecords))))
+	server.mux.Handle("GET /ws", protect(limitBody(http.HandlerFunc(server.handleWebSocket))))
+	server.mux.Handle("GET /records/{ip}/{port}/{service}", protect(bounded(http.HandlerFunc(server.handleGetRecord))))
+
+	if len(server.adminKeys) > 0 {
+		admin := APIKeyAuth(server.adminKeys)
+		server.mux.Handle("DELETE /admin/records", admin(bounded(http.HandlerFunc(server.handleDeleteOldRecords))))
+		server.mux.Handle("POST /admin/bulk-import", admin(MaxBodySize(maxImportBodyBytes)(http.HandlerFunc(server.handleBulkImport))))
+	}
+
+	logger := slog.Default()
+	middleware := []Middleware{
+		RequestID(),
+		RequestLogger(logger),
+		Recovery(logger),
+	}
+	if server.cors != nil {
+		// Before rate limiting so 429 responses can be read by the page
+		middleware = append(middleware, server.cors)
+	}
+	if server.rateLimit != nil {
+		// Inside the logger so rejected requests are still logged
+		middleware = append(middleware, server.rateLimit)
+	}
+	server.handler = Chain(middleware...)(server.mux)
+
+	srv.Handler = server.handler
+	// Streams never finish on their own, so end them rather than letting
+	// them hold up a graceful shutdown
+	srv.RegisterOnShutdown(server.stopStreams)
+	return server
+}
+
+// Handler returns the API routes wrapped in middleware
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// ListenAndServe serves the API until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	return s.httpServer.ListenAndServe()
+}
+
+// ListenAndServeTLS serves the API over HTTPS until Shutdown is called,
+// using the certificates from the http.Server's TLSConfig
+func (s *Server) ListenAndServeTLS() error {
+	return s.httpServer.ListenAndServeTLS("", "")
+}
+
+// Shutdown gracefully stops the server
+// Open record streams are ended so their requests can complete
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// stopStreams ends every open record stream
+func (s *Server) stopStreams() {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+}
+
+// recordResponse is the JSON representation of a store.ServiceRecord
+type recordResponse struct {
+	IP                string    `json:"ip"`
+	Port              uint32    `json:"port"`
+	Service           string    `json:"service"`
+	LastTimestamp     int64     `json:"last_timestamp"`
+	Response          string    `json:"response"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	FirstSeenAt       time.Time `json:"first_seen_at"`
+	ScanCount         int64     `json:"scan_count"`
+	PreviousResponse  string    `json:"previous_response,omitempty"`
+	ResponseTruncated bool      `json:"response_truncated,omitempty"`
+	TLSVersion        string    `json:"tls_version,omitempty"`
+	StatusCode        int       `json:"status_code,omitempty"`
+	MessageID         string    `json:"message_id,omitempty"`
+}
+
+func newRecordResponse(r *store.ServiceRecord) recordResponse {
+	resp := recordResponse{
+		IP:                r.IP.String(),
+		Port:              r.Port,
+		Service:           r.Service,
+		LastTimestamp:     r.LastTimestamp,
+		Response:          r.Response,
+		UpdatedAt:         r.UpdatedAt,
+		FirstSeenAt:       r.FirstSeenAt,
+		ScanCount:         r.ScanCount,
+		PreviousResponse:  r.PreviousResponse,
+		ResponseTruncated: r.ResponseTruncated,
+		TLSVersion:        r.TLSVersion,
+		StatusCode:        r.StatusCode,
+	}
+	if r.MessageMetadata != nil {
+		resp.MessageID = r.MessageMetadata.MessageID
+	}
+	return resp
+}
+
+// listResponse is the body returned by list endpoints
+// Total counts the matching records across all pages; search results do
+// not include it
+type listResponse struct {
+	Data   []recordResponse `json:"data"`
+	Total  *int64           `json:"total,omitempty"`
+	Limit  int              `json:"limit"`
+	Offset int              `json:"offset"`
+}
+
+func newListResponse(records []*store.ServiceRecord, total *int64, limit, offset int) listResponse {
+	resp := listResponse{
+		Data:   make([]recordResponse, 0, len(records)),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	for _, r := range records {
+		resp.Data = append(resp.Data, newRecordResponse(r))
+	}
+	return resp
+}
+
+// statsResponse is the JSON representation of store.StoreStats
+type statsResponse struct {
+	TotalRecords     int64            `json:"total_records"`
+	RecordsByService map[string]int64 `json:"records_by_service"`
+	RecordsByPort    map[uint32]int64 `json:"records_by_port"`
+	OldestTimestamp  int64            `json:"oldest_timestamp"`
+	NewestTimestamp  int64            `json:"newest_timestamp"`
+}
+
+// deleteResponse is the body returned by DELETE /admin/records
+type deleteResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// errorResponse is the body returned for failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// handleHealth reports 503 with the store's error when it is unreachable,
+// so it can back a readiness probe
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.HealthCheck(r.Context()); err != nil {
+		slog.Warn("store health check failed", slog.Any("error", err))
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unhealthy", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.store.Stats(r.Context())
+	if err != nil {
+		writeStoreError(w, "failed to get stats", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statsResponse{
+		TotalRecords:     stats.TotalRecords,
+		RecordsByService: stats.RecordsByService,
+		RecordsByPort:    stats.RecordsByPort,
+		OldestTimestamp:  stats.OldestTimestamp,
+		NewestTimestamp:  stats.NewestTimestamp,
+	})
+}
+
+func (s *Server) handleGetRecord(w http.ResponseWriter, r *http.Request) {
+	port, err := parsePort(r.PathValue("port"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	record, err := s.store.Get(r.Context(), store.CanonicalIP(r.PathValue("ip")), port, r.PathValue("service"))
+	if err != nil {
+		writeStoreError(w, "failed to get record", err)
+		return
+	}
+	if record == nil {
+		writeError(w, http.StatusNotFound, "record not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newRecordResponse(record))
+}
+
+func (s *Server) handleListRecords(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, offset, err := parsePagination(query.Get("limit"), query.Get("offset"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ip := store.CanonicalIP(query.Get("ip"))
+	service := query.Get("service")
+	var port uint32
+	hasPort := query.Get("port") != ""
+	if hasPort {
+		if port, err = parsePort(query.Get("port")); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	records, total, err := s.listRecords(r.Context(), ip, port, hasPort, service, limit, offset)
+	if err != nil {
+		writeStoreError(w, "failed to list records", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newListResponse(records, &total, limit, offset))
+}
+
+func (s *Server) handleSearchRecords(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := query.Get("q")
+	if len(q) < minSearchLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("q must be at least %d characters", minSearchLength))
+		return
+	}
+
+	limit, offset, err := parsePagination(query.Get("limit"), query.Get("offset"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	records, err := s.store.SearchByResponse(r.Context(), q, limit, offset)
+	if err != nil {
+		writeStoreError(w, "failed to search records", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newListResponse(records, nil, limit, offset))
+}
+
+func (s *Server) handleDeleteOldRecords(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("older_than_timestamp")
+	before, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || before <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid older_than_timestamp: %q", raw))
+		return
+	}
+
+	deleted, err := s.store.DeleteOlderThan(r.Context(), before)
+	if err != nil {
+		writeStoreError(w, "failed to delete records", err)
+		return
+	}
+
+	slog.Info("deleted old records",
+		slog.Int64("older_than_timestamp", before),
+		slog.Int64("deleted", deleted),
+		slog.String("request_id", RequestIDFromContext(r.Context())),
+	)
+	writeJSON(w, http.StatusOK, deleteResponse{Deleted: deleted})
+}
+
+// listRecords picks the store query for the given filters and returns a
+// page of records with the number of matches across all pages
+// Single filters are paginated by the store; combined filters narrow the
+// most selective query and paginate the result here
+func (s *Server) listRecords(ctx context.Context, ip string, port uint32, hasPort bool, service string, limit, offset int) ([]*store.ServiceRecord, int64, error) {
+	switch {
+	case ip != "":
+		records, err := s.store.ListByIP(ctx, ip)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = filterRecords(records, port, hasPort, service)
+		return paginate(records, limit, offset), int64(len(records)), nil
+
+	case service != "" && hasPort:
+		records, err := s.store.ListByService(ctx, service, 0, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = filterRecords(records, port, true, "")
+		return paginate(records, limit, offset), int64(len(records)), nil
+
+	case service != "":
+		records, err := s.store.ListByService(ctx, service, limit, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		stats, err := s.store.Stats(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		return records, stats.RecordsByService[service], nil
+
+	case hasPort:
+		records, err := s.store.ListByPort(ctx, port, limit, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		stats, err := s.store.Stats(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		return records, stats.RecordsByPort[port], nil
+
+	default:
+		result, err := s.store.ListV2(ctx, limit, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Records, result.TotalCount, nil
+	}
+}
+
+// filterRecords keeps records matching the optional port and service
+func filterRecords(records []*store.ServiceRecord, port uint32, hasPort bool, service string) []*store.ServiceRecord {
+	filtered := records[:0]
+	for _, r := range records {
+		if hasPort && r.Port != port {
+			continue
+		}
+		if service != "" && r.Service != service {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	return filtered
+}
+
+// paginate applies offset/limit to an already filtered slice
+func paginate(records []*store.ServiceRecord, limit, offset int) []*store.ServiceRecord {
+	if offset >= len(records) {
+		return nil
+	}
+	records = records[offset:]
+	if limit < len(records) {
+		records = records[:limit]
+	}
+	return records
+}
+
+// parsePagination validates limit and offset query parameters
+// limit defaults to 100 and is capped at 1000
+func parsePagination(limitStr, offsetStr string) (int, int, error) {
+	limit := defaultLimit
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid limit: %q", limitStr)
+		}
+		limit = min(n, maxLimit)
+	}
+
+	offset := 0
+	if offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid offset: %q", offsetStr)
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+// parsePort validates a TCP/UDP port number
+func parsePort(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid port: %q", s)
+	}
+	return uint32(n), nil
+}
+
+// writeJSON encodes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode response", slog.Any("error", err))
+	}
+}
+
+// writeError writes a JSON error body
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+// writeStoreError logs a store failure and reports it without internal details
+func writeStoreError(w http.ResponseWriter, msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	writeError(w, http.StatusInternalServerError, msg)
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// newTestServer returns a server backed by a memory store seeded with
+// 5 HTTP records on port 80 and 5 SSH records on port 22
+func newTestServer(t *testing.T) (*Server, store.Store) {
+	t.Helper()
+
+	s := store.NewMemoryStore()
+	t.Cleanup(func() { s.Close() })
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		records := []*store.ServiceRecord{
+			{IP: store.IPAddress(ip), Port: 80, Service: "HTTP", LastTimestamp: int64(1000 + i), Response: "http " + ip},
+			{IP: store.IPAddress(ip), Port: 22, Service: "SSH", LastTimestamp: int64(2000 + i), Response: "ssh " + ip},
+		}
+		if _, err := s.BulkUpsert(ctx, records); err != nil {
+			t.Fatalf("BulkUpsert failed: %v", err)
+		}
+	}
+
+	return NewServer(s, &http.Server{}), s
+}
+
+// do performs a request against the server and decodes the JSON body into v
+func do(t *testing.T, srv *Server, target string, v any) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %q", ct)
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("Failed to decode %s response %q: %v", target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// TestHealth tests the liveness endpoint
+func TestHealth(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	var body map[string]string
+	if code := do(t, srv, "/health", &body); code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+	if body["status"] != "ok" {
+		t.Errorf("Expected status ok, got %q", body["status"])
+	}
+}
+
+// TestHealthUnhealthyStore tests that /health returns 503 when the store
+// cannot be reached
+func TestHealthUnhealthyStore(t *testing.T) {
+	s, err := store.NewSQLiteStore(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	s.Close()
+
+	if err := s.HealthCheck(context.Background()); err == nil {
+		t.Error("Expected HealthCheck to fail on a closed store")
+	}
+
+	var body map[string]string
+	if code := do(t, NewServer(s, &http.Server{}), "/health", &body); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", code)
+	}
+	if body["status"] != "unhealthy" || body["error"] == "" {
+		t.Errorf("Expected unhealthy status with an error, got %v", body)
+	}
+}
+
+// TestStats tests the stats endpoint
+func TestStats(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	var body struct {
+		TotalRecords     int64            `json:"total_records"`
+		RecordsByService map[string]int64 `json:"records_by_service"`
+		RecordsByPort    map[string]int64 `json:"records_by_port"`
+		OldestTimestamp  int64            `json:"oldest_timestamp"`
+		NewestTimestamp  int64            `json:"newest_timestamp"`
+	}
+	if code := do(t, srv, "/stats", &body); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+
+	if body.TotalRecords != 10 {
+		t.Errorf("Expected 10 records, got %d", body.TotalRecords)
+	}
+	if body.RecordsByService["SSH"] != 5 {
+		t.Errorf("Expected 5 SSH records, got %d", body.RecordsByService["SSH"])
+	}
+	if body.RecordsByPort["80"] != 5 {
+		t.Errorf("Expected 5 records on port 80, got %d", body.RecordsByPort["80"])
+	}
+	if body.OldestTimestamp != 1000 || body.NewestTimestamp != 2004 {
+		t.Errorf("Expected timestamps 1000-2004, got %d-%d", body.OldestTimestamp, body.NewestTimestamp)
+	}
+}
+
+// TestGetRecord tests fetching a single record by key
+func TestGetRecord(t *testing.T) {
+	srv, s := newTestServer(t)
+
+	var record recordResponse
+	if code := do(t, srv, "/records/10.0.0.3/22/SSH", &record); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if record.IP != "10.0.0.3" || record.Port != 22 || record.Service != "SSH" {
+		t.Errorf("Expected 10.0.0.3:22/SSH, got %s:%d/%s", record.IP, record.Port, record.Service)
+	}
+	if record.Response != "ssh 10.0.0.3" {
+		t.Errorf("Expected response 'ssh 10.0.0.3', got %q", record.Response)
+	}
+	if record.FirstSeenAt.IsZero() {
+		t.Error("Expected first_seen_at to be set")
+	}
+	if record.MessageID != "" {
+		t.Errorf("Expected no message_id for a record not from Pub/Sub, got %q", record.MessageID)
+	}
+
+	meta := &store.MessageMetadata{MessageID: "msg-1", SubscriptionID: "scan-sub", PublishTime: time.Now()}
+	if _, err := s.Upsert(context.Background(), &store.ServiceRecord{IP: "10.0.0.3", Port: 22, Service: "SSH", LastTimestamp: 3000, Response: "ssh", MessageMetadata: meta}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if code := do(t, srv, "/records/10.0.0.3/22/SSH", &record); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if record.MessageID != "msg-1" {
+		t.Errorf("Expected message_id msg-1, got %q", record.MessageID)
+	}
+
+	// Any spelling of the IP finds the record, which keeps the canonical one
+	record = recordResponse{}
+	if code := do(t, srv, "/records/::ffff:10.0.0.3/22/SSH", &record); code != http.StatusOK {
+		t.Fatalf("Expected 200 for another spelling of the IP, got %d", code)
+	}
+	if record.IP != "10.0.0.3" {
+		t.Errorf("Expected IP 10.0.0.3, got %s", record.IP)
+	}
+
+	var errBody errorResponse
+	if code := do(t, srv, "/records/10.0.0.3/443/HTTPS", &errBody); code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", code)
+	}
+	if errBody.Error == "" {
+		t.Error("Expected an error message for a missing record")
+	}
+
+	if code := do(t, srv, "/records/10.0.0.3/http/SSH", &errBody); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-numeric port, got %d", code)
+	}
+}
+
+// TestListRecords tests filters and pagination metadata
+func TestListRecords(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	tests := []struct {
+		name          string
+		target        string
+		expectedCount int
+		expectedTotal int64
+		expectedLimit int
+		expectedOff   int
+	}{
+		{"all", "/records", 10, 10, defaultLimit, 0},
+		{"limit", "/records?limit=3", 3, 10, 3, 0},
+		{"offset past end", "/records?limit=5&offset=8", 2, 10, 5, 8},
+		{"capped limit", "/records?limit=5000", 10, 10, maxLimit, 0},
+		{"by ip", "/records?ip=10.0.0.1", 2, 2, defaultLimit, 0},
+		{"by ip paginated", "/records?ip=10.0.0.1&limit=1&offset=1", 1, 2, 1, 1},
+		{"by port", "/records?port=22", 5, 5, defaultLimit, 0},
+		{"by service", "/records?service=HTTP&limit=2&offset=1", 2, 5, 2, 1},
+		{"by ip and port", "/records?ip=10.0.0.1&port=80", 1, 1, defaultLimit, 0},
+		{"by ip spelling", "/records?ip=::ffff:10.0.0.1", 2, 2, defaultLimit, 0},
+		{"by ip spelling and port", "/records?ip=::ffff:10.0.0.1&port=80", 1, 1, defaultLimit, 0},
+		{"by port and service", "/records?port=80&service=SSH", 0, 0, defaultLimit, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body listResponse
+			if code := do(t, srv, tt.target, &body); code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", code)
+			}
+			if body.Data == nil {
+				t.Error("Expected data to encode as an array, got null")
+			}
+			if len(body.Data) != tt.expectedCount {
+				t.Errorf("Expected %d records, got %d", tt.expectedCount, len(body.Data))
+			}
+			if body.Total == nil || *body.Total != tt.expectedTotal {
+				t.Errorf("Expected total %d, got %v", tt.expectedTotal, body.Total)
+			}
+			if body.Limit != tt.expectedLimit || body.Offset != tt.expectedOff {
+				t.Errorf("Expected limit %d and offset %d, got %d and %d", tt.expectedLimit, tt.expectedOff, body.Limit, body.Offset)
+			}
+		})
+	}
+}
+
+// TestListRecordsBadRequest tests that invalid query parameters return 400
+func TestListRecordsBadRequest(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	for _, target := range []string{
+		"/records?limit=abc",
+		"/records?limit=0",
+		"/records?offset=-1",
+		"/records?port=70000",
+		"/records?port=0",
+	} {
+		var body errorResponse
+		if code := do(t, srv, target, &body); code != http.StatusBadRequest {
+			t.Errorf("%s: Expected 400, got %d", target, code)
+		}
+		if body.Error == "" {
+			t.Errorf("%s: Expected an error message", target)
+		}
+	}
+}
+
+// TestSearchRecords tests response search and its query validation
+func TestSearchRecords(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	var body listResponse
+	if code := do(t, srv, "/records/search?q=SSH%2010.0.0", &body); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(body.Data) != 5 {
+		t.Errorf("Expected 5 matches, got %d", len(body.Data))
+	}
+	for _, r := range body.Data {
+		if r.Service != "SSH" {
+			t.Errorf("Expected only SSH records, got %s", r.Service)
+		}
+	}
+
+	body = listResponse{}
+	if code := do(t, srv, "/records/search?q=http&limit=2&offset=4", &body); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(body.Data) != 1 || body.Limit != 2 || body.Offset != 4 || body.Total != nil {
+		t.Errorf("Expected 1 match at limit 2, offset 4 without a total, got %+v", body)
+	}
+
+	for _, target := range []string{
+		"/records/search",
+		"/records/search?q=",
+		"/records/search?q=ab",
+		"/records/search?q=abc&limit=-1",
+	} {
+		var errBody errorResponse
+		if code := do(t, srv, target, &errBody); code != http.StatusBadRequest {
+			t.Errorf("%s: Expected 400, got %d", target, code)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// streamEvent is the data of one /records/stream event
+type streamEvent struct {
+	Type     store.EventType `json:"type"`
+	Record   recordResponse  `json:"record"`
+	Previous *recordResponse `json:"previous,omitempty"`
+}
+
+func newStreamEvent(ev store.StoreEvent) streamEvent {
+	e := streamEvent{Type: ev.Type, Record: newRecordResponse(ev.Record)}
+	if ev.Previous != nil {
+		previous := newRecordResponse(ev.Previous)
+		e.Previous = &previous
+	}
+	return e
+}
+
+// handleStreamRecords streams created and updated records as server-sent
+// events until the client disconnects, the server shuts down or the store
+// is closed
+// Each event is named after its store.EventType; skipped upserts are not
+// sent
+// Headers are flushed once the watch is registered, so clients can wait on
+// them before making changes they expect to observe
+func (s *Server) handleStreamRecords(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	events, err := s.store.Watch(ctx)
+	if err != nil {
+		if errors.Is(err, store.ErrStoreClosed) {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		writeStoreError(w, "failed to watch records", err)
+		return
+	}
+	defer s.store.Unwatch(events)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.Error("streaming not supported", slog.Any("error", err))
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Type == store.EventSkipped {
+				continue
+			}
+			if err := writeEvent(w, ev); err != nil {
+				slog.Warn("failed to write record event", slog.Any("error", err))
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes ev in the SSE wire format
+func writeEvent(w http.ResponseWriter, ev store.StoreEvent) error {
+	data, err := json.Marshal(newStreamEvent(ev))
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return err
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// openStream starts a /records/stream request against ts and returns the
+// response once the watch is registered
+func openStream(t *testing.T, ts *httptest.Server) *http.Response {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/records/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", ct)
+	}
+	return resp
+}
+
+// TestStreamRecords tests that records upserted concurrently arrive as
+// created and updated events, and that skipped upserts are not sent
+func TestStreamRecords(t *testing.T) {
+	srv, s := newTestServer(t)
+	ts := httptest.NewServer(srv.Handler())
+	// Cleanups run last-first, so the stream is closed before the server
+	t.Cleanup(ts.Close)
+
+	resp := openStream(t, ts)
+
+	// Skipped first, so it would arrive before the others if it were sent
+	ctx := context.Background()
+	s.Upsert(ctx, &store.ServiceRecord{IP: "10.0.0.0", Port: 80, Service: "HTTP", LastTimestamp: 1, Response: "stale"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ip := store.IPAddress(fmt.Sprintf("10.0.0.%d", i))
+			s.Upsert(ctx, &store.ServiceRecord{IP: ip, Port: 80, Service: "HTTP", LastTimestamp: 5000, Response: "new " + ip.String()})
+			s.Upsert(ctx, &store.ServiceRecord{IP: ip, Port: 443, Service: "HTTPS", LastTimestamp: 5000, Response: "tls " + ip.String()})
+		}()
+	}
+	wg.Wait()
+
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(resp.Body)
+	var name string
+	for received := 0; received < 10 && scanner.Scan(); {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var ev streamEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				t.Fatalf("Failed to decode event %q: %v", line, err)
+			}
+			if string(ev.Type) != name {
+				t.Errorf("Expected data type %q to match event %q", ev.Type, name)
+			}
+			switch ev.Type {
+			case store.EventUpdated:
+				if ev.Previous == nil || ev.Record.Response != "new "+ev.Record.IP {
+					t.Errorf("Expected update with the previous record, got %+v", ev)
+				}
+			case store.EventCreated:
+				if ev.Record.Port != 443 || ev.Previous != nil {
+					t.Errorf("Expected an HTTPS insert, got %+v", ev)
+				}
+			}
+			counts[name]++
+			received++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+
+	if counts["updated"] != 5 || counts["created"] != 5 || len(counts) != 2 {
+		t.Errorf("Expected 5 created and 5 updated events, got %v", counts)
+	}
+}
+
+// TestStreamRecordsShutdown tests that Shutdown ends open streams
+func TestStreamRecordsShutdown(t *testing.T) {
+	srv, _ := newTestServer(t)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	resp := openStream(t, ts)
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		t.Errorf("Expected no events, got %q", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Errorf("Expected the stream to end cleanly, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// CertReloader serves a TLS certificate pair from disk and reloads it when
+// the files change, so certificates can be rotated without a restart
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewCertReloader loads the PEM certificate and key pair from certFile and
+// keyFile
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload replaces the served certificate with the pair on disk
+func (r *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig returns a server config that serves the current certificate
+// Cipher suites are left to crypto/tls, which picks secure defaults
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Watch reloads the certificate whenever the directories holding the
+// certificate and key change, until ctx is done
+// Whole directories are watched so that files replaced by rename, such as
+// Kubernetes secret mounts, are picked up; a pair that fails to load (for
+// example, a new certificate written before its key) is logged and the
+// previous certificate kept
+func (r *CertReloader) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	for _, dir := range uniqueDirs(r.certFile, r.keyFile) {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				slog.Warn("failed to reload TLS certificate", slog.String("file", ev.Name), slog.Any("error", err))
+				continue
+			}
+			slog.Info("reloaded TLS certificate", slog.String("file", ev.Name))
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Error("TLS certificate watcher error", slog.Any("error", err))
+		}
+	}
+}
+
+// uniqueDirs returns the directories of files without duplicates
+func uniqueDirs(files ...string) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		dir := filepath.Dir(f)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
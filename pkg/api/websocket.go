@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection settings
+const (
+	// wsPingPeriod is how often the server pings an idle client
+	wsPingPeriod = 30 * time.Second
+	// wsPongWait is how long the server waits for a pong before treating
+	// the connection as dead
+	wsPongWait = wsPingPeriod + 10*time.Second
+	// wsWriteWait bounds a single write to the client
+	wsWriteWait = 10 * time.Second
+	// wsMaxMessageBytes bounds a client message
+	wsMaxMessageBytes = 4096
+)
+
+// Client actions accepted on /ws
+const (
+	wsActionSubscribe   = "subscribe"
+	wsActionUnsubscribe = "unsubscribe"
+	wsActionQuery       = "query"
+)
+
+// wsUpgrader keeps the default origin check, which rejects cross-origin
+// browser requests
+var wsUpgrader = websocket.Upgrader{}
+
+// wsRequest is a message sent by a /ws client
+// subscribe starts or replaces the live event filter, unsubscribe stops
+// it and query looks up every record for IP
+type wsRequest struct {
+	Action string   `json:"action"`
+	Filter wsFilter `json:"filter"`
+	IP     string   `json:"ip"`
+}
+
+// wsFilter selects the records a subscription receives; zero fields match
+// every record
+type wsFilter struct {
+	IP      string `json:"ip,omitempty"`
+	Port    uint32 `json:"port,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+// matches reports whether r passes the filter
+// Services compare case-insensitively and IPs in canonical form
+func (f *wsFilter) matches(r *store.ServiceRecord) bool {
+	if f.IP != "" && store.CanonicalIP(f.IP) != r.IP.String() {
+		return false
+	}
+	if f.Port != 0 && f.Port != r.Port {
+		return false
+	}
+	return f.Service == "" || strings.EqualFold(f.Service, r.Service)
+}
+
+// wsResponse is a message sent to a /ws client
+// Type is "subscribed", "unsubscribed", "event", "result" or "error"
+type wsResponse struct {
+	Type    string           `json:"type"`
+	Event   *streamEvent     `json:"event,omitempty"`
+	Records []recordResponse `json:"records,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// wsSession is one /ws connection
+type wsSession struct {
+	conn  *websocket.Conn
+	store store.Store
+
+	writeMu sync.Mutex // gorilla/websocket allows one writer at a time
+
+	mu     sync.Mutex
+	filter wsFilter
+	events <-chan store.StoreEvent // nil when not subscribed
+}
+
+// handleWebSocket upgrades to a WebSocket that answers record queries and
+// streams created and updated records matching the client's subscription
+// The connection is pinged every 30 seconds and closed if the client stops
+// answering, stops reading or the server shuts down
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Upgrade replies with an HTTP error itself on failure
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	sess := &wsSession{conn: conn, store: s.store}
+	defer sess.unsubscribe()
+
+	go sess.heartbeat(ctx, s.shutdown)
+	sess.readLoop(ctx)
+}
+
+// readLoop handles client messages until the connection fails or closes
+// Messages that are not valid JSON are answered with an error
+func (c *wsSession) readLoop(ctx context.Context) {
+	c.conn.SetReadLimit(wsMaxMessageBytes)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.write(wsResponse{Type: "error", Error: "invalid message: " + err.Error()})
+			continue
+		}
+
+		switch req.Action {
+		case wsActionSubscribe:
+			c.subscribe(ctx, req.Filter)
+		case wsActionUnsubscribe:
+			c.unsubscribe()
+			c.write(wsResponse{Type: "unsubscribed"})
+		case wsActionQuery:
+			c.query(ctx, req.IP)
+		default:
+			c.write(wsResponse{Type: "error", Error: "unknown action: " + req.Action})
+		}
+	}
+}
+
+// subscribe replaces the filter, starting a watch on the first call, and
+// confirms once events are being delivered
+func (c *wsSession) subscribe(ctx context.Context, filter wsFilter) {
+	c.mu.Lock()
+	c.filter = filter
+	if c.events == nil {
+		events, err := c.store.Watch(ctx)
+		if err != nil {
+			c.mu.Unlock()
+			slog.Error("failed to watch records", slog.Any("error", err))
+			c.write(wsResponse{Type: "error", Error: "failed to watch records"})
+			return
+		}
+		c.events = events
+		go c.forward(events)
+	}
+	c.mu.Unlock()
+
+	c.write(wsResponse{Type: "subscribed"})
+}
+
+// unsubscribe stops the watch, if any
+func (c *wsSession) unsubscribe() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.events != nil {
+		c.store.Unwatch(c.events)
+		c.events = nil
+	}
+}
+
+// forward sends matching created and updated events until the watch ends
+func (c *wsSession) forward(events <-chan store.StoreEvent) {
+	for ev := range events {
+		if ev.Type == store.EventSkipped {
+			continue
+		}
+		c.mu.Lock()
+		match := c.filter.matches(ev.Record)
+		c.mu.Unlock()
+		if !match {
+			continue
+		}
+
+		e := newStreamEvent(ev)
+		if err := c.write(wsResponse{Type: "event", Event: &e}); err != nil {
+			return
+		}
+	}
+}
+
+// query sends every record for ip as a single result
+func (c *wsSession) query(ctx context.Context, ip string) {
+	if ip == "" {
+		c.write(wsResponse{Type: "error", Error: "ip is required"})
+		return
+	}
+	records, err := c.store.ListByIP(ctx, ip)
+	if err != nil {
+		slog.Error("failed to list records", slog.Any("error", err))
+		c.write(wsResponse{Type: "error", Error: "failed to list records"})
+		return
+	}
+
+	resp := wsResponse{Type: "result", Records: make([]recordResponse, 0, len(records))}
+	for _, r := range records {
+		resp.Records = append(resp.Records, newRecordResponse(r))
+	}
+	c.write(resp)
+}
+
+// heartbeat pings the client every wsPingPeriod until ctx is done, and
+// closes the connection when the server shuts down
+func (c *wsSession) heartbeat(ctx context.Context, shutdown <-chan struct{}) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-shutdown:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+			c.conn.Close()
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// write sends resp as a JSON text message
+func (c *wsSession) write(resp wsResponse) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := c.conn.WriteJSON(resp); err != nil {
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/gorilla/websocket"
+)
+
+// dialWS connects a WebSocket client to the /ws endpoint of ts
+func dialWS(t *testing.T, ts *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// readWS reads the next server message and checks its type
+func readWS(t *testing.T, conn *websocket.Conn, wantType string) wsResponse {
+	t.Helper()
+
+	var resp wsResponse
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if resp.Type != wantType {
+		t.Fatalf("Expected a %s message, got %+v", wantType, resp)
+	}
+	return resp
+}
+
+// TestWebSocketSubscribe tests that a subscription only receives events
+// matching its filter, and that queries share the connection
+func TestWebSocketSubscribe(t *testing.T) {
+	srv, s := newTestServer(t)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	conn := dialWS(t, ts)
+	if err := conn.WriteJSON(map[string]any{"action": "subscribe", "filter": map[string]string{"service": "ssh"}}); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	readWS(t, conn, "subscribed")
+
+	ctx := context.Background()
+	for _, r := range []*store.ServiceRecord{
+		{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+		{IP: "1.1.1.1", Port: 22, Service: "SSH", LastTimestamp: 1000, Response: "ssh 1"},
+		{IP: "2.2.2.2", Port: 443, Service: "HTTPS", LastTimestamp: 1000},
+		{IP: "10.0.0.1", Port: 22, Service: "SSH", LastTimestamp: 5000, Response: "ssh 2"},
+	} {
+		if _, err := s.Upsert(ctx, r); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+
+	first := readWS(t, conn, "event")
+	if first.Event.Type != store.EventCreated || first.Event.Record.IP != "1.1.1.1" || first.Event.Record.Service != "SSH" {
+		t.Errorf("Expected SSH created on 1.1.1.1, got %+v", first.Event)
+	}
+	second := readWS(t, conn, "event")
+	if second.Event.Type != store.EventUpdated || second.Event.Record.Response != "ssh 2" || second.Event.Previous == nil {
+		t.Errorf("Expected SSH update on 10.0.0.1, got %+v", second.Event)
+	}
+
+	// Any HTTP or HTTPS event would arrive before the result
+	if err := conn.WriteJSON(map[string]string{"action": "query", "ip": "1.1.1.1"}); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	result := readWS(t, conn, "result")
+	if len(result.Records) != 2 {
+		t.Errorf("Expected 2 records for 1.1.1.1, got %d", len(result.Records))
+	}
+
+	if err := conn.WriteJSON(map[string]string{"action": "nope"}); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	readWS(t, conn, "error")
+}
+
+// TestWebSocketUnsubscribe tests that events stop after unsubscribing
+func TestWebSocketUnsubscribe(t *testing.T) {
+	srv, s := newTestServer(t)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	conn := dialWS(t, ts)
+	conn.WriteJSON(map[string]string{"action": "subscribe"})
+	readWS(t, conn, "subscribed")
+	conn.WriteJSON(map[string]string{"action": "unsubscribe"})
+	readWS(t, conn, "unsubscribed")
+
+	s.Upsert(context.Background(), &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+
+	conn.WriteJSON(map[string]string{"action": "query", "ip": "1.1.1.1"})
+	readWS(t, conn, "result")
+}
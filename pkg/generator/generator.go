@@ -0,0 +1,88 @@
+// Package generator builds synthetic scan messages for load testing
+package generator
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+)
+
+// services are the services synthetic scans report, as the scanner does
+var services = []string{"HTTP", "SSH", "DNS"}
+
+// Generator produces scan messages for a fixed pool of IPs
+// The same seed, IP count and version produce the same sequence of scans,
+// apart from their timestamps
+// A Generator is not safe for concurrent use
+type Generator struct {
+	rand    *rand.Rand
+	ips     []string
+	version int
+	next    int // index in ips of the next scan
+	now     func() time.Time
+}
+
+// New returns a generator that rotates through ipCount distinct IPv4
+// addresses, encoding data as version (scanning.V1 or scanning.V2)
+func New(ipCount, version int, seed int64) (*Generator, error) {
+	if ipCount < 1 {
+		return nil, fmt.Errorf("ip count must be positive, got %d", ipCount)
+	}
+	if version != scanning.V1 && version != scanning.V2 {
+		return nil, fmt.Errorf("unsupported data version: %d", version)
+	}
+
+	g := &Generator{
+		rand:    rand.New(rand.NewSource(seed)),
+		version: version,
+		now:     time.Now,
+	}
+	seen := make(map[uint32]bool, ipCount)
+	for len(g.ips) < ipCount {
+		// Skip 0.0.0.0/8, which is not a host address
+		n := g.rand.Uint32()
+		if n>>24 == 0 || seen[n] {
+			continue
+		}
+		seen[n] = true
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		g.ips = append(g.ips, ip.String())
+	}
+	return g, nil
+}
+
+// Next returns the next scan, for the next IP in the pool, a random port
+// and service, and the current time
+func (g *Generator) Next() *scanning.Scan {
+	scan := &scanning.Scan{
+		Ip:          g.ips[g.next],
+		Port:        uint32(g.rand.Intn(65535) + 1),
+		Service:     services[g.rand.Intn(len(services))],
+		Timestamp:   g.now().Unix(),
+		DataVersion: g.version,
+	}
+	g.next = (g.next + 1) % len(g.ips)
+
+	response := fmt.Sprintf("service response: %d", g.rand.Intn(100))
+	if g.version == scanning.V1 {
+		scan.Data = &scanning.V1Data{ResponseBytesUtf8: []byte(response)}
+	} else {
+		scan.Data = &scanning.V2Data{ResponseStr: response}
+	}
+	return scan
+}
+
+// NextMessage returns the next scan encoded as a Pub/Sub message body
+func (g *Generator) NextMessage() ([]byte, error) {
+	data, err := json.Marshal(g.Next())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode scan: %w", err)
+	}
+	return data, nil
+}
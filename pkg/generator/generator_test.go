@@ -0,0 +1,61 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+)
+
+// TestGeneratorReproducible tests that the same seed produces the same scans
+func TestGeneratorReproducible(t *testing.T) {
+	a, err := New(10, scanning.V1, 42)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	b, _ := New(10, scanning.V1, 42)
+
+	for i := 0; i < 50; i++ {
+		sa, sb := a.Next(), b.Next()
+		sa.Timestamp, sb.Timestamp = 0, 0
+		if !reflect.DeepEqual(sa, sb) {
+			t.Fatalf("Expected scan %d to match, got %+v and %+v", i, sa, sb)
+		}
+	}
+}
+
+// TestGeneratorRotatesIPs tests that scans cycle through ipCount distinct IPs
+func TestGeneratorRotatesIPs(t *testing.T) {
+	g, err := New(5, scanning.V2, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var first []string
+	seen := make(map[string]bool)
+	for i := 0; i < 15; i++ {
+		scan := g.Next()
+		if i < 5 {
+			first = append(first, scan.Ip)
+		} else if scan.Ip != first[i%5] {
+			t.Errorf("Expected scan %d to reuse %s, got %s", i, first[i%5], scan.Ip)
+		}
+		seen[scan.Ip] = true
+		if scan.Port < 1 || scan.Port > 65535 {
+			t.Errorf("Expected a port in 1-65535, got %d", scan.Port)
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("Expected 5 distinct IPs, got %d", len(seen))
+	}
+}
+
+// TestNewInvalid tests that bad IP counts and versions are rejected
+func TestNewInvalid(t *testing.T) {
+	if _, err := New(0, scanning.V1, 1); err == nil {
+		t.Error("Expected error for zero IPs")
+	}
+	if _, err := New(1, scanning.V3, 1); err == nil {
+		t.Error("Expected error for version 3")
+	}
+}
@@ -0,0 +1,152 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default Pub/Sub flow control limits
+const (
+	defaultMaxOutstandingMessages = 1000
+	defaultMaxOutstandingBytes    = 500 << 20 // 500 MB
+)
+
+// ConsumerConfig holds everything needed to build a Consumer
+// The flow control fields are applied to the subscription's ReceiveSettings
+type ConsumerConfig struct {
+	// ProjectID and SubscriptionID identify the subscription; both are required
+	ProjectID      string
+	SubscriptionID string
+
+	// Concurrency is the worker pool size, 0 processes in the Receive callback
+	Concurrency int
+	// DrainTimeout bounds how long Close waits for in-flight messages
+	DrainTimeout time.Duration
+	// DeadLetterTopicID receives messages that fail MaxDeliveries times;
+	// empty NACKs failing messages indefinitely
+	DeadLetterTopicID string
+	// MaxDeliveries is how many failed attempts a message gets before it is
+	// dead-lettered
+	MaxDeliveries int
+	// RetainAckedMessages keeps acknowledged messages on the subscription
+	// for this long so ReplayFromTimestamp can redeliver them; zero leaves
+	// the subscription's retention unchanged
+	RetainAckedMessages time.Duration
+
+	// MaxOutstandingMessages caps messages pulled but not yet acknowledged
+	MaxOutstandingMessages int
+	// MaxOutstandingBytes caps the total size of unacknowledged messages
+	MaxOutstandingBytes int64
+	// NumGoroutines is the number of goroutines pulling from the subscription
+	NumGoroutines int
+}
+
+// DefaultConsumerConfig returns the settings used when none are configured
+// ProjectID and SubscriptionID are left empty
+func DefaultConsumerConfig() ConsumerConfig {
+	return ConsumerConfig{
+		DrainTimeout:           defaultDrainTimeout,
+		MaxDeliveries:          defaultMaxDeliveries,
+		MaxOutstandingMessages: defaultMaxOutstandingMessages,
+		MaxOutstandingBytes:    defaultMaxOutstandingBytes,
+		NumGoroutines:          runtime.GOMAXPROCS(0),
+	}
+}
+
+// ConsumerConfigFromEnv loads consumer settings from the environment,
+// falling back to DefaultConsumerConfig for unset optional variables
+//
+//	PUBSUB_PROJECT_ID (required)
+//	PUBSUB_SUBSCRIPTION_ID (required)
+//	PUBSUB_DEAD_LETTER_TOPIC_ID
+//	CONSUMER_CONCURRENCY
+//	CONSUMER_DRAIN_TIMEOUT
+//	CONSUMER_MAX_DELIVERIES
+//	CONSUMER_RETAIN_ACKED_MESSAGES
+//	CONSUMER_MAX_OUTSTANDING_MESSAGES
+//	CONSUMER_MAX_OUTSTANDING_BYTES
+//	CONSUMER_NUM_GOROUTINES
+func ConsumerConfigFromEnv() (*ConsumerConfig, error) {
+	cfg := DefaultConsumerConfig()
+	cfg.ProjectID = os.Getenv("PUBSUB_PROJECT_ID")
+	cfg.SubscriptionID = os.Getenv("PUBSUB_SUBSCRIPTION_ID")
+	cfg.DeadLetterTopicID = os.Getenv("PUBSUB_DEAD_LETTER_TOPIC_ID")
+
+	if err := intFromEnv("CONSUMER_CONCURRENCY", &cfg.Concurrency); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("CONSUMER_DRAIN_TIMEOUT", &cfg.DrainTimeout); err != nil {
+		return nil, err
+	}
+	if err := intFromEnv("CONSUMER_MAX_DELIVERIES", &cfg.MaxDeliveries); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("CONSUMER_RETAIN_ACKED_MESSAGES", &cfg.RetainAckedMessages); err != nil {
+		return nil, err
+	}
+	if err := intFromEnv("CONSUMER_MAX_OUTSTANDING_MESSAGES", &cfg.MaxOutstandingMessages); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("CONSUMER_MAX_OUTSTANDING_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONSUMER_MAX_OUTSTANDING_BYTES: %w", err)
+		}
+		cfg.MaxOutstandingBytes = n
+	}
+	if err := intFromEnv("CONSUMER_NUM_GOROUTINES", &cfg.NumGoroutines); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate reports every missing required field
+func (cfg *ConsumerConfig) Validate() error {
+	var missing []string
+	if cfg.ProjectID == "" {
+		missing = append(missing, "project ID is required (set PUBSUB_PROJECT_ID)")
+	}
+	if cfg.SubscriptionID == "" {
+		missing = append(missing, "subscription ID is required (set PUBSUB_SUBSCRIPTION_ID)")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid consumer config: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// intFromEnv parses key into dst when it is set
+func intFromEnv(key string, dst *int) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*dst = n
+	return nil
+}
+
+// durationFromEnv parses key into dst when it is set
+func durationFromEnv(key string, dst *time.Duration) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*dst = d
+	return nil
+}
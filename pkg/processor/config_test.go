@@ -0,0 +1,135 @@
+package processor
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequiredEnv sets the variables ConsumerConfigFromEnv requires
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("PUBSUB_PROJECT_ID", "test-project")
+	t.Setenv("PUBSUB_SUBSCRIPTION_ID", "scan-sub")
+}
+
+// TestConsumerConfigFromEnv tests defaults and environment overrides
+func TestConsumerConfigFromEnv(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := ConsumerConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConsumerConfigFromEnv failed: %v", err)
+	}
+	if cfg.ProjectID != "test-project" || cfg.SubscriptionID != "scan-sub" {
+		t.Errorf("Expected test-project/scan-sub, got %s/%s", cfg.ProjectID, cfg.SubscriptionID)
+	}
+	if cfg.MaxOutstandingMessages != 1000 {
+		t.Errorf("Expected default 1000 messages, got %d", cfg.MaxOutstandingMessages)
+	}
+	if cfg.MaxOutstandingBytes != 500<<20 {
+		t.Errorf("Expected default 500MB, got %d", cfg.MaxOutstandingBytes)
+	}
+	if cfg.NumGoroutines != runtime.GOMAXPROCS(0) {
+		t.Errorf("Expected default GOMAXPROCS goroutines, got %d", cfg.NumGoroutines)
+	}
+	if cfg.DrainTimeout != 30*time.Second || cfg.MaxDeliveries != 5 {
+		t.Errorf("Expected default 30s drain timeout and 5 deliveries, got %s and %d", cfg.DrainTimeout, cfg.MaxDeliveries)
+	}
+
+	t.Setenv("PUBSUB_DEAD_LETTER_TOPIC_ID", "dead-letters")
+	t.Setenv("CONSUMER_CONCURRENCY", "8")
+	t.Setenv("CONSUMER_DRAIN_TIMEOUT", "5s")
+	t.Setenv("CONSUMER_MAX_DELIVERIES", "2")
+	t.Setenv("CONSUMER_RETAIN_ACKED_MESSAGES", "24h")
+	t.Setenv("CONSUMER_MAX_OUTSTANDING_MESSAGES", "10")
+	t.Setenv("CONSUMER_MAX_OUTSTANDING_BYTES", "2048")
+	t.Setenv("CONSUMER_NUM_GOROUTINES", "3")
+
+	cfg, err = ConsumerConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConsumerConfigFromEnv failed: %v", err)
+	}
+	want := ConsumerConfig{
+		ProjectID:              "test-project",
+		SubscriptionID:         "scan-sub",
+		Concurrency:            8,
+		DrainTimeout:           5 * time.Second,
+		DeadLetterTopicID:      "dead-letters",
+		MaxDeliveries:          2,
+		RetainAckedMessages:    24 * time.Hour,
+		MaxOutstandingMessages: 10,
+		MaxOutstandingBytes:    2048,
+		NumGoroutines:          3,
+	}
+	if *cfg != want {
+		t.Errorf("Expected %+v, got %+v", want, *cfg)
+	}
+}
+
+// TestConsumerConfigFromEnvInvalid tests that malformed values are rejected
+func TestConsumerConfigFromEnvInvalid(t *testing.T) {
+	for _, key := range []string{
+		"CONSUMER_CONCURRENCY",
+		"CONSUMER_DRAIN_TIMEOUT",
+		"CONSUMER_MAX_DELIVERIES",
+		"CONSUMER_RETAIN_ACKED_MESSAGES",
+		"CONSUMER_MAX_OUTSTANDING_MESSAGES",
+		"CONSUMER_MAX_OUTSTANDING_BYTES",
+		"CONSUMER_NUM_GOROUTINES",
+	} {
+		t.Run(key, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv(key, "lots")
+			if _, err := ConsumerConfigFromEnv(); err == nil {
+				t.Errorf("Expected error for invalid %s", key)
+			}
+		})
+	}
+}
+
+// TestConsumerConfigFromEnvMissingRequired tests that each missing required
+// variable is named in the error
+func TestConsumerConfigFromEnvMissingRequired(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		sub     string
+		want    []string
+	}{
+		{"missing project", "", "scan-sub", []string{"PUBSUB_PROJECT_ID"}},
+		{"missing subscription", "test-project", "", []string{"PUBSUB_SUBSCRIPTION_ID"}},
+		{"missing both", "", "", []string{"PUBSUB_PROJECT_ID", "PUBSUB_SUBSCRIPTION_ID"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PUBSUB_PROJECT_ID", tt.project)
+			t.Setenv("PUBSUB_SUBSCRIPTION_ID", tt.sub)
+
+			cfg, err := ConsumerConfigFromEnv()
+			if err == nil {
+				t.Fatalf("Expected error, got config %+v", cfg)
+			}
+			for _, key := range tt.want {
+				if !strings.Contains(err.Error(), key) {
+					t.Errorf("Expected error to mention %s, got %q", key, err)
+				}
+			}
+		})
+	}
+}
+
+// TestNewConsumerFromConfigInvalid tests that an incomplete config is
+// rejected before connecting to Pub/Sub
+func TestNewConsumerFromConfigInvalid(t *testing.T) {
+	cfg := DefaultConsumerConfig()
+	cfg.ProjectID = "test-project"
+
+	_, err := NewConsumerFromConfig(context.Background(), &cfg, NewProcessor(nil))
+	if err == nil || !strings.Contains(err.Error(), "PUBSUB_SUBSCRIPTION_ID") {
+		t.Errorf("Expected missing subscription error, got %v", err)
+	}
+}
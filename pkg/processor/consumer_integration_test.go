@@ -0,0 +1,138 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// publishRaw publishes data to the test topic as-is
+func publishRaw(srv *pstest.Server, data []byte) string {
+	return srv.Publish(fmt.Sprintf("projects/%s/topics/%s", testProject, testTopic), data, nil)
+}
+
+// scanMessage builds a scan envelope for the given version and data
+func scanMessage(ip string, version int, data interface{}) []byte {
+	raw, _ := json.Marshal(data)
+	msg, _ := json.Marshal(map[string]interface{}{
+		"ip":           ip,
+		"port":         uint32(80),
+		"service":      "HTTP",
+		"timestamp":    int64(1000),
+		"data_version": version,
+		"data":         json.RawMessage(raw),
+	})
+	return msg
+}
+
+// TestConsumerEndToEnd publishes V1 and V2 scans to a fake Pub/Sub server
+// and checks that Start stores every one of them
+func TestConsumerEndToEnd(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc)
+
+	expected := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		ip := fmt.Sprintf("10.0.1.%d", i)
+		response := fmt.Sprintf("v1 response %d", i)
+		publishRaw(srv, scanMessage(ip, scanning.V1, scanning.V1Data{ResponseBytesUtf8: []byte(response)}))
+		expected[ip] = response
+	}
+	for i := 0; i < 5; i++ {
+		ip := fmt.Sprintf("10.0.2.%d", i)
+		response := fmt.Sprintf("v2 response %d", i)
+		publishRaw(srv, scanMessage(ip, scanning.V2, scanning.V2Data{ResponseStr: response}))
+		expected[ip] = response
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- consumer.Start(ctx) }()
+
+	waitForAcks(t, srv, len(expected))
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	count, err := memStore.Count(context.Background())
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != int64(len(expected)) {
+		t.Errorf("Expected %d records, got %d", len(expected), count)
+	}
+	for ip, response := range expected {
+		record, err := memStore.Get(context.Background(), ip, 80, "HTTP")
+		if err != nil || record == nil {
+			t.Errorf("Expected record for %s, got %v (err %v)", ip, record, err)
+			continue
+		}
+		if record.Response != response {
+			t.Errorf("Expected response %q for %s, got %q", response, ip, record.Response)
+		}
+	}
+}
+
+// TestConsumerNacksMalformedMessage tests that malformed JSON is NACKed and
+// redelivered while the consumer keeps processing other messages
+func TestConsumerNacksMalformedMessage(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc)
+
+	badID := publishRaw(srv, []byte(`{not json`))
+	publishScan(t, srv, "10.0.0.1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- consumer.Start(ctx) }()
+
+	waitForAcks(t, srv, 1)
+
+	// A NACKed message is redelivered, so wait for a second attempt
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if m := srv.Message(badID); m != nil && m.Deliveries >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	bad := srv.Message(badID)
+	if bad.Acks != 0 {
+		t.Errorf("Expected malformed message not to be acked, got %d acks", bad.Acks)
+	}
+	if bad.Deliveries < 2 {
+		t.Errorf("Expected malformed message to be redelivered after NACK, got %d deliveries", bad.Deliveries)
+	}
+	if record, _ := memStore.Get(context.Background(), "10.0.0.1", 80, "HTTP"); record == nil {
+		t.Error("Expected the valid message to be stored")
+	}
+}
@@ -0,0 +1,698 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const (
+	testProject = "test-project"
+	testTopic   = "scan-topic"
+	testSub     = "scan-sub"
+)
+
+// newTestConsumer starts a pstest server with a topic and subscription and
+// returns a consumer bound to it
+func newTestConsumer(t *testing.T, proc *Processor, opts ...ConsumerOption) (*Consumer, *pstest.Server) {
+	t.Helper()
+
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, testProject)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	topic, err := client.CreateTopic(ctx, testTopic)
+	if err != nil {
+		t.Fatalf("CreateTopic failed: %v", err)
+	}
+	if _, err := client.CreateSubscription(ctx, testSub, pubsub.SubscriptionConfig{Topic: topic}); err != nil {
+		t.Fatalf("CreateSubscription failed: %v", err)
+	}
+
+	consumer, err := NewConsumer(ctx, testProject, testSub, proc, opts...)
+	if err != nil {
+		t.Fatalf("NewConsumer failed: %v", err)
+	}
+	return consumer, srv
+}
+
+// publishScan publishes a V2 scan for ip to the test topic
+func publishScan(t *testing.T, srv *pstest.Server, ip string) string {
+	t.Helper()
+
+	data, _ := json.Marshal(map[string]string{"response_str": "ok"})
+	msg, _ := json.Marshal(map[string]interface{}{
+		"ip":           ip,
+		"port":         uint32(80),
+		"service":      "HTTP",
+		"timestamp":    int64(1000),
+		"data_version": scanning.V2,
+		"data":         json.RawMessage(data),
+	})
+	return srv.Publish(fmt.Sprintf("projects/%s/topics/%s", testProject, testTopic), msg, nil)
+}
+
+// waitForAcks polls the server until n messages have been acked
+func waitForAcks(t *testing.T, srv *pstest.Server, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		acked := 0
+		for _, m := range srv.Messages() {
+			if m.Acks > 0 {
+				acked++
+			}
+		}
+		if acked >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d acks", n)
+}
+
+// slowStore delays every upsert and records the peak number running at once
+type slowStore struct {
+	store.Store
+	delay     time.Duration
+	active    atomic.Int32
+	maxActive atomic.Int32
+}
+
+func (s *slowStore) Upsert(ctx context.Context, record *store.ServiceRecord) (bool, error) {
+	n := s.active.Add(1)
+	defer s.active.Add(-1)
+	for {
+		peak := s.maxActive.Load()
+		if n <= peak || s.maxActive.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	return s.Store.Upsert(ctx, record)
+}
+
+// TestConsumerConcurrency tests that the worker pool processes messages in
+// parallel without exceeding the configured concurrency
+func TestConsumerConcurrency(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	slow := &slowStore{Store: memStore, delay: 50 * time.Millisecond}
+
+	proc := NewProcessor(slow, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc, WithConcurrency(3))
+
+	const messages = 12
+	for i := 0; i < messages; i++ {
+		publishScan(t, srv, fmt.Sprintf("10.0.0.%d", i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- consumer.Start(ctx) }()
+
+	waitForAcks(t, srv, messages)
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	if peak := slow.maxActive.Load(); peak < 2 || peak > 3 {
+		t.Errorf("Expected between 2 and 3 concurrent upserts, got %d", peak)
+	}
+	count, err := memStore.Count(context.Background())
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != messages {
+		t.Errorf("Expected %d records, got %d", messages, count)
+	}
+}
+
+// TestConsumerCloseDrainsWorkers tests that Close waits for a message that
+// is already being processed and acks it
+func TestConsumerCloseDrainsWorkers(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	gate := &gatedStore{Store: memStore, started: make(chan struct{}), release: make(chan struct{})}
+
+	proc := NewProcessor(gate, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc, WithConcurrency(1), WithDrainTimeout(5*time.Second))
+	id := publishScan(t, srv, "10.0.0.1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go consumer.Start(ctx)
+
+	select {
+	case <-gate.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for processing to start")
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- consumer.Close() }()
+
+	select {
+	case <-closed:
+		t.Fatal("Expected Close to wait for the in-flight message")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(gate.release)
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Close")
+	}
+
+	if msg := srv.Message(id); msg == nil || msg.Acks != 1 {
+		t.Errorf("Expected drained message to be acked once, got %+v", msg)
+	}
+}
+
+// gatedStore blocks the first upsert until release is closed
+type gatedStore struct {
+	store.Store
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedStore) Upsert(ctx context.Context, record *store.ServiceRecord) (bool, error) {
+	s.once.Do(func() { close(s.started) })
+	<-s.release
+	return s.Store.Upsert(ctx, record)
+}
+
+// TestConsumerDeadLetter tests that a message which keeps failing is
+// published to the dead-letter topic and acked after max deliveries
+func TestConsumerDeadLetter(t *testing.T) {
+	proc := NewProcessor(failingStore{}, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc,
+		WithDeadLetterTopic("scan-dlq"),
+		WithMaxDeliveries(3),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := pubsub.NewClient(ctx, testProject)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.CreateTopic(ctx, "scan-dlq"); err != nil {
+		t.Fatalf("CreateTopic failed: %v", err)
+	}
+
+	id := publishScan(t, srv, "10.0.0.1")
+	go consumer.Start(ctx)
+	waitForAcks(t, srv, 1)
+	consumer.Close()
+
+	original := srv.Message(id)
+	if original.Deliveries != 3 {
+		t.Errorf("Expected 3 deliveries, got %d", original.Deliveries)
+	}
+	if original.Acks != 1 {
+		t.Errorf("Expected original to be acked once, got %d", original.Acks)
+	}
+
+	var dead *pstest.Message
+	for _, m := range srv.Messages() {
+		if m.ID != id {
+			dead = m
+		}
+	}
+	if dead == nil {
+		t.Fatal("Expected a dead-lettered message")
+	}
+	if string(dead.Data) != string(original.Data) {
+		t.Errorf("Expected dead-lettered data %q, got %q", original.Data, dead.Data)
+	}
+	if got := dead.Attributes[errorAttribute]; !strings.Contains(got, "store unavailable") {
+		t.Errorf("Expected %s attribute to contain the store error, got %q", errorAttribute, got)
+	}
+
+	if _, ok := consumer.attempts.Load(id); ok {
+		t.Error("Expected attempt tracking to be cleared after dead-lettering")
+	}
+}
+
+// waitForActive polls until the slow store has an upsert in progress
+func waitForActive(t *testing.T, s *slowStore) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.active.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for processing to start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestConsumerCloseWaitsForInFlight tests that Close blocks until a slow
+// message finishes when it completes within the drain timeout
+func TestConsumerCloseWaitsForInFlight(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	slow := &slowStore{Store: memStore, delay: 300 * time.Millisecond}
+
+	proc := NewProcessor(slow, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc, WithDrainTimeout(5*time.Second))
+	id := publishScan(t, srv, "10.0.0.1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go consumer.Start(ctx)
+	waitForActive(t, slow)
+
+	start := time.Now()
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected Close to wait for processing, returned after %v", elapsed)
+	}
+
+	if msg := srv.Message(id); msg.Acks != 1 {
+		t.Errorf("Expected in-flight message to be acked once, got %d", msg.Acks)
+	}
+	record, err := memStore.Get(context.Background(), "10.0.0.1", 80, "HTTP")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if record == nil {
+		t.Error("Expected in-flight message to be stored")
+	}
+}
+
+// TestConsumerCloseDrainTimeout tests that Close gives up after the drain
+// timeout and reports the abandoned messages
+func TestConsumerCloseDrainTimeout(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	slow := &slowStore{Store: memStore, delay: 500 * time.Millisecond}
+
+	handler := &captureHandler{}
+	proc := NewProcessor(slow, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc,
+		WithDrainTimeout(50*time.Millisecond),
+		WithLogger(slog.New(handler)),
+	)
+	publishScan(t, srv, "10.0.0.1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go consumer.Start(ctx)
+	waitForActive(t, slow)
+
+	start := time.Now()
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected Close to return after the drain timeout, took %v", elapsed)
+	}
+
+	rec := handler.last()
+	if rec.Level != slog.LevelWarn {
+		t.Errorf("Expected warn level, got %v", rec.Level)
+	}
+	var inFlight int64 = -1
+	rec.Attrs(func(a slog.Attr) bool {
+		if a.Key == "in_flight" {
+			inFlight = a.Value.Int64()
+		}
+		return true
+	})
+	if inFlight != 1 {
+		t.Errorf("Expected 1 abandoned message, got %d", inFlight)
+	}
+
+	// Let the abandoned message finish before the store is closed
+	time.Sleep(slow.delay)
+}
+
+// TestConsumerMaxOutstandingMessages tests that a flow control limit of one
+// message processes slow messages sequentially
+func TestConsumerMaxOutstandingMessages(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	slow := &slowStore{Store: memStore, delay: 100 * time.Millisecond}
+
+	proc := NewProcessor(slow, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc, WithMaxOutstandingMessages(1))
+	publishScan(t, srv, "10.0.0.1")
+	publishScan(t, srv, "10.0.0.2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go consumer.Start(ctx)
+
+	start := time.Now()
+	waitForAcks(t, srv, 2)
+	consumer.Close()
+
+	if peak := slow.maxActive.Load(); peak != 1 {
+		t.Errorf("Expected sequential processing, got %d concurrent upserts", peak)
+	}
+	if elapsed := time.Since(start); elapsed < 2*slow.delay {
+		t.Errorf("Expected at least %v for two sequential messages, took %v", 2*slow.delay, elapsed)
+	}
+}
+
+// countingStore counts upserts so tests can tell how often Process ran
+type countingStore struct {
+	store.Store
+	upserts atomic.Int32
+}
+
+func (s *countingStore) Upsert(ctx context.Context, record *store.ServiceRecord) (bool, error) {
+	s.upserts.Add(1)
+	return s.Store.Upsert(ctx, record)
+}
+
+// TestConsumerDeduplication tests that a message ID delivered twice is only
+// processed once
+func TestConsumerDeduplication(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	counting := &countingStore{Store: memStore}
+
+	proc := NewProcessor(counting, WithMetrics(prometheus.NewRegistry()),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, _ := newTestConsumer(t, proc, WithDeduplication(time.Minute, 100))
+	defer consumer.Close()
+
+	data := []byte(`{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "a"}}`)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		consumer.handle(ctx, &pubsub.Message{ID: "dup", Data: data})
+	}
+
+	if n := counting.upserts.Load(); n != 1 {
+		t.Errorf("Expected Process to run once, got %d", n)
+	}
+	if got := testutil.ToFloat64(proc.metrics.duplicatesSkipped); got != 1 {
+		t.Errorf("Expected duplicates_skipped_total 1, got %v", got)
+	}
+
+	// A different ID is still processed
+	consumer.handle(ctx, &pubsub.Message{ID: "other", Data: data})
+	if n := counting.upserts.Load(); n != 2 {
+		t.Errorf("Expected Process to run for a new ID, got %d upserts", n)
+	}
+}
+
+// TestDedupWindow tests expiry and capacity eviction of remembered IDs
+func TestDedupWindow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	d := newDedupWindow(time.Minute, 2)
+	d.now = func() time.Time { return now }
+
+	d.add("a")
+	now = now.Add(30 * time.Second)
+	d.add("b")
+	if !d.seen("a") || !d.seen("b") {
+		t.Fatal("Expected a and b to be seen")
+	}
+
+	// a falls out of the window
+	now = now.Add(31 * time.Second)
+	if d.seen("a") {
+		t.Error("Expected a to expire")
+	}
+	if !d.seen("b") {
+		t.Error("Expected b to still be seen")
+	}
+
+	// Adding beyond capacity evicts the oldest
+	d.add("c")
+	d.add("e")
+	if d.seen("b") {
+		t.Error("Expected b to be evicted at capacity")
+	}
+	if !d.seen("c") || !d.seen("e") {
+		t.Error("Expected c and e to be seen")
+	}
+}
+
+// TestConsumerIPRateLimit tests that a flood of messages for one IP is
+// delayed to the configured rate without delaying other IPs
+func TestConsumerIPRateLimit(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	counting := &countingStore{Store: memStore}
+
+	proc := NewProcessor(counting, WithMetrics(prometheus.NewRegistry()),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	// At 1 RPS the burst covers all but the last 2 of 100 messages, which
+	// then wait about a second each
+	consumer, _ := newTestConsumer(t, proc, WithIPRateLimit(1, 98))
+	defer consumer.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		data := fmt.Sprintf(`{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": %d, "data_version": 2, "data": {"response_str": "a"}}`, 1000+i)
+		consumer.handle(ctx, &pubsub.Message{ID: fmt.Sprint(i), Data: []byte(data)})
+	}
+	if elapsed := time.Since(start); elapsed < 1900*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Expected 100 messages at 1 RPS with burst 98 to take about 2s, took %v", elapsed)
+	}
+	if n := counting.upserts.Load(); n != 100 {
+		t.Errorf("Expected every message to be processed, got %d upserts", n)
+	}
+	if got := testutil.ToFloat64(proc.metrics.rateLimited); got != 2 {
+		t.Errorf("Expected rate_limited_messages_total 2, got %v", got)
+	}
+
+	// Another IP has its own bucket
+	start = time.Now()
+	data := []byte(`{"ip": "2.2.2.2", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "a"}}`)
+	consumer.handle(ctx, &pubsub.Message{ID: "other", Data: data})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected another IP to be processed without delay, took %v", elapsed)
+	}
+}
+
+// TestIPRateLimiterSweep tests that only limiters idle past the timeout
+// are removed
+func TestIPRateLimiterSweep(t *testing.T) {
+	l := newIPRateLimiter(1, 1)
+	now := time.Now()
+	l.get("10.1.1.1", now.Add(-6*time.Minute))
+	l.get("10.2.2.2", now.Add(-time.Minute))
+
+	l.sweep(now)
+
+	if _, ok := l.limiters.Load("10.1.1.1"); ok {
+		t.Error("Expected the idle limiter to be removed")
+	}
+	if _, ok := l.limiters.Load("10.2.2.2"); !ok {
+		t.Error("Expected the active limiter to be kept")
+	}
+}
+
+// TestWithIPRateLimitSettings tests that a non-positive rate disables the
+// limit and a burst below 1 is raised to 1
+func TestWithIPRateLimitSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		rps       float64
+		burst     int
+		wantBurst int // 0 when the limit is disabled
+	}{
+		{"valid", 10, 5, 5},
+		{"zero burst", 10, 0, 1},
+		{"negative burst", 10, -3, 1},
+		{"zero rate", 0, 5, 0},
+		{"negative rate", -1, 5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Consumer{}
+			WithIPRateLimit(tt.rps, tt.burst).applyConsumer(c)
+			switch {
+			case tt.wantBurst == 0 && c.ipRateLimit != nil:
+				t.Error("Expected the limit to be disabled")
+			case tt.wantBurst != 0 && c.ipRateLimit == nil:
+				t.Fatal("Expected the limit to be enabled")
+			case tt.wantBurst != 0 && c.ipRateLimit.burst != tt.wantBurst:
+				t.Errorf("Expected burst %d, got %d", tt.wantBurst, c.ipRateLimit.burst)
+			}
+		})
+	}
+
+	// A clamped burst lets messages through instead of failing every wait
+	l := newIPRateLimiter(10, 0)
+	if _, err := l.wait(context.Background(), "1.1.1.1"); err != nil {
+		t.Errorf("Expected a zero burst to be usable, got %v", err)
+	}
+}
+
+// TestConsumerCloseInterruptsRateLimit tests that Close ends a rate limit
+// wait and NACKs the message instead of holding up shutdown
+func TestConsumerCloseInterruptsRateLimit(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	counting := &countingStore{Store: memStore}
+
+	proc := NewProcessor(counting, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	// One message per 1000 seconds, so the second waits until Close
+	consumer, _ := newTestConsumer(t, proc, WithIPRateLimit(0.001, 1))
+
+	data := []byte(`{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "a"}}`)
+	consumer.handle(context.Background(), &pubsub.Message{ID: "first", Data: data})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.handle(context.Background(), &pubsub.Message{ID: "second", Data: data})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	consumer.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Close to interrupt the rate limit wait")
+	}
+	if n := counting.upserts.Load(); n != 1 {
+		t.Errorf("Expected only the first message to be processed, got %d upserts", n)
+	}
+}
+
+// TestNewConsumerFromConfig tests that every config field reaches the consumer
+func TestNewConsumerFromConfig(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	proc := NewProcessor(memStore)
+
+	// Create the topic and subscription through the positional constructor
+	newTestConsumer(t, proc)
+
+	cfg := ConsumerConfig{
+		ProjectID:              testProject,
+		SubscriptionID:         testSub,
+		Concurrency:            4,
+		DrainTimeout:           time.Second,
+		DeadLetterTopicID:      "dead-letters",
+		MaxDeliveries:          2,
+		RetainAckedMessages:    time.Hour,
+		MaxOutstandingMessages: 10,
+		MaxOutstandingBytes:    1 << 20,
+		NumGoroutines:          1,
+	}
+	consumer, err := NewConsumerFromConfig(context.Background(), &cfg, proc)
+	if err != nil {
+		t.Fatalf("NewConsumerFromConfig failed: %v", err)
+	}
+	defer consumer.Close()
+
+	if consumer.concurrency != 4 || consumer.drainTimeout != time.Second || consumer.maxDeliveries != 2 {
+		t.Errorf("Unexpected settings: concurrency %d, drain timeout %s, max deliveries %d",
+			consumer.concurrency, consumer.drainTimeout, consumer.maxDeliveries)
+	}
+	if consumer.deadLetter == nil || consumer.deadLetterTopicID != "dead-letters" {
+		t.Errorf("Expected dead-letter topic dead-letters, got %q", consumer.deadLetterTopicID)
+	}
+	if consumer.flow.MaxOutstandingMessages != 10 || consumer.flow.MaxOutstandingBytes != 1<<20 || consumer.flow.NumGoroutines != 1 {
+		t.Errorf("Unexpected flow control settings: %+v", consumer.flow)
+	}
+	if consumer.retainAcked != time.Hour {
+		t.Errorf("Expected 1h acked message retention, got %s", consumer.retainAcked)
+	}
+}
+
+// TestConsumerReplayFromTimestamp tests that acked message retention is
+// enabled on the subscription and that a replay seeks it
+// pstest loses the message data when seeking back over messages, so this
+// seeks past a backlog instead: the skipped messages are acked without
+// being delivered, which shows the seek reached the server with the time
+func TestConsumerReplayFromTimestamp(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	proc := NewProcessor(memStore, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc,
+		WithRetainAckedMessages(time.Hour),
+		WithDeduplication(time.Hour, 100),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := pubsub.NewClient(ctx, testProject)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+	cfg, err := client.Subscription(testSub).Config(ctx)
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if !cfg.RetainAckedMessages || cfg.RetentionDuration != time.Hour {
+		t.Errorf("Expected acked messages retained for 1h, got %v for %s", cfg.RetainAckedMessages, cfg.RetentionDuration)
+	}
+
+	var backlog []string
+	for i := 0; i < 10; i++ {
+		backlog = append(backlog, publishScan(t, srv, fmt.Sprintf("10.0.0.%d", i)))
+	}
+	consumer.dedup.add(backlog[0])
+
+	if err := consumer.ReplayFromTimestamp(ctx, time.Now()); err != nil {
+		t.Fatalf("ReplayFromTimestamp failed: %v", err)
+	}
+	if consumer.dedup.seen(backlog[0]) {
+		t.Error("Expected replay to forget deduplicated message IDs")
+	}
+
+	go consumer.Start(ctx)
+	for i := 0; i < 3; i++ {
+		publishScan(t, srv, fmt.Sprintf("10.0.1.%d", i))
+	}
+	waitForAcks(t, srv, 13)
+	consumer.Close()
+
+	for _, id := range backlog {
+		if msg := srv.Message(id); msg.Deliveries != 0 || msg.Acks != 1 {
+			t.Errorf("Expected skipped message %s to be acked without delivery, got %d deliveries and %d acks", id, msg.Deliveries, msg.Acks)
+		}
+	}
+	if count, _ := memStore.Count(ctx); count != 3 {
+		t.Errorf("Expected only the 3 messages after the seek to be stored, got %d", count)
+	}
+}
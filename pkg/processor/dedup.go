@@ -0,0 +1,87 @@
+package processor
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupWindow remembers message IDs seen within a time window, bounded to a
+// maximum number of entries
+// IDs are evicted oldest first, either once they fall outside the window or
+// to make room when the window is full
+type dedupWindow struct {
+	window   time.Duration
+	capacity int
+	now      func() time.Time
+
+	mu    sync.Mutex
+	order *list.List               // of dedupEntry, oldest first
+	ids   map[string]*list.Element // message ID -> element in order
+}
+
+// dedupEntry is a remembered message ID and when it was recorded
+type dedupEntry struct {
+	id   string
+	seen time.Time
+}
+
+// newDedupWindow creates a window holding at most capacity IDs for window
+func newDedupWindow(window time.Duration, capacity int) *dedupWindow {
+	return &dedupWindow{
+		window:   window,
+		capacity: capacity,
+		now:      time.Now,
+		order:    list.New(),
+		ids:      make(map[string]*list.Element),
+	}
+}
+
+// seen reports whether id was recorded within the window
+func (d *dedupWindow) seen(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expireLocked(d.now())
+	_, ok := d.ids[id]
+	return ok
+}
+
+// add records id, evicting the oldest IDs if the window is full
+func (d *dedupWindow) add(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.expireLocked(now)
+	if e, ok := d.ids[id]; ok {
+		d.order.Remove(e)
+	}
+	for d.order.Len() >= d.capacity && d.order.Len() > 0 {
+		d.removeLocked(d.order.Front())
+	}
+	d.ids[id] = d.order.PushBack(dedupEntry{id: id, seen: now})
+}
+
+// reset forgets every recorded ID
+func (d *dedupWindow) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.order.Init()
+	clear(d.ids)
+}
+
+// expireLocked drops IDs recorded before the window
+func (d *dedupWindow) expireLocked(now time.Time) {
+	cutoff := now.Add(-d.window)
+	for e := d.order.Front(); e != nil && !e.Value.(dedupEntry).seen.After(cutoff); e = d.order.Front() {
+		d.removeLocked(e)
+	}
+}
+
+// removeLocked drops a single entry
+func (d *dedupWindow) removeLocked(e *list.Element) {
+	d.order.Remove(e)
+	delete(d.ids, e.Value.(dedupEntry).id)
+}
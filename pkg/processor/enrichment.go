@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// Enricher adds data such as geolocation or ASN to a record after it has
+// been stored
+type Enricher interface {
+	Enrich(ctx context.Context, r *store.ServiceRecord) error
+}
+
+// WithEnrichers calls each enricher, in order, on every record an Upsert
+// updates
+// Enricher errors are logged and do not fail the message
+func WithEnrichers(enrichers ...Enricher) Option {
+	return processorOption(func(p *Processor) {
+		p.enrichers = append(p.enrichers, enrichers...)
+	})
+}
+
+// enrich runs the configured enrichers on an updated record
+func (p *Processor) enrich(ctx context.Context, record *store.ServiceRecord) {
+	for _, e := range p.enrichers {
+		if err := e.Enrich(ctx, record); err != nil {
+			p.logger.Warn("failed to enrich record",
+				slog.String("ip", record.IP.String()),
+				slog.Int("port", int(record.Port)),
+				slog.String("service", record.Service),
+				slog.Any("error", err),
+			)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// mmdbMetadataMarker starts the metadata section at the end of every
+// MaxMind DB file
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMetadataMaxSize bounds how far from the end of the file the metadata
+// section can start
+const mmdbMetadataMaxSize = 128 << 10
+
+// ErrNotMaxMindDB is returned by NewGeoIPEnricher for a file without MaxMind
+// DB metadata
+var ErrNotMaxMindDB = errors.New("not a MaxMind DB file")
+
+// GeoIPConfig configures a GeoIPEnricher
+type GeoIPConfig struct {
+	// DatabasePath is a MaxMind GeoLite2-Country .mmdb file
+	DatabasePath string
+}
+
+// GeoIPResult is what a GeoIP lookup found for an address, zero values
+// when it found nothing
+type GeoIPResult struct {
+	Country string
+	ASN     uint32
+}
+
+// GeoIPLookup resolves an address against a GeoIP database
+type GeoIPLookup func(addr netip.Addr) (GeoIPResult, error)
+
+// GeoIPEnricher sets a record's Country and ASN from a MaxMind database
+// This is a stub: the database is checked when the enricher is created,
+// but no lookup is wired in yet, so records are left unchanged
+type GeoIPEnricher struct {
+	lookup GeoIPLookup
+}
+
+// NewGeoIPEnricher checks that cfg.DatabasePath is a readable MaxMind DB
+// file and returns an enricher for it
+func NewGeoIPEnricher(cfg GeoIPConfig) (*GeoIPEnricher, error) {
+	if cfg.DatabasePath == "" {
+		return nil, errors.New("GeoIP database path is required")
+	}
+	if err := checkMaxMindDB(cfg.DatabasePath); err != nil {
+		return nil, err
+	}
+	return &GeoIPEnricher{lookup: stubGeoIPLookup}, nil
+}
+
+// Enrich sets r's Country and ASN to what the lookup found for r.IP,
+// leaving fields the lookup did not find unchanged
+func (e *GeoIPEnricher) Enrich(ctx context.Context, r *store.ServiceRecord) error {
+	addr, err := netip.ParseAddr(r.IP.String())
+	if err != nil {
+		return fmt.Errorf("failed to parse IP %q: %w", r.IP, err)
+	}
+	result, err := e.lookup(addr.Unmap())
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", addr, err)
+	}
+	if result.Country != "" {
+		r.Country = result.Country
+	}
+	if result.ASN != 0 {
+		r.ASN = result.ASN
+	}
+	return nil
+}
+
+// stubGeoIPLookup finds nothing for every address
+func stubGeoIPLookup(netip.Addr) (GeoIPResult, error) {
+	return GeoIPResult{}, nil
+}
+
+// checkMaxMindDB reports an error unless path ends with a MaxMind DB
+// metadata section
+func checkMaxMindDB(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat GeoIP database: %w", err)
+	}
+	offset := max(info.Size()-mmdbMetadataMaxSize, 0)
+	tail, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+	if !bytes.Contains(tail, mmdbMetadataMarker) {
+		return fmt.Errorf("%s: %w", path, ErrNotMaxMindDB)
+	}
+	return nil
+}
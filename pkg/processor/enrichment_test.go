@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// mockEnricher records the records it is called with and returns err
+type mockEnricher struct {
+	calls []store.ServiceRecord
+	err   error
+}
+
+func (e *mockEnricher) Enrich(ctx context.Context, r *store.ServiceRecord) error {
+	e.calls = append(e.calls, *r)
+	return e.err
+}
+
+// TestProcessEnrichers tests that every enricher is called on an update, in
+// order, and that none are called on a skipped message
+func TestProcessEnrichers(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	failing := &mockEnricher{err: errors.New("lookup failed")}
+	second := &mockEnricher{}
+	proc := NewProcessor(memStore,
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithEnrichers(failing, second),
+	)
+	ctx := context.Background()
+
+	if err := proc.Process(ctx, scanMessage("10.0.0.1", scanning.V2, scanning.V2Data{ResponseStr: "ok"})); err != nil {
+		t.Fatalf("Expected an enricher error not to fail the message, got %v", err)
+	}
+	for name, e := range map[string]*mockEnricher{"failing": failing, "second": second} {
+		if len(e.calls) != 1 {
+			t.Fatalf("Expected the %s enricher to be called once, got %d", name, len(e.calls))
+		}
+		if got := e.calls[0]; got.IP != "10.0.0.1" || got.Port != 80 || got.Service != "HTTP" {
+			t.Errorf("Expected the %s enricher to get the updated record, got %+v", name, got)
+		}
+	}
+
+	// The same timestamp again is skipped by the store
+	if err := proc.Process(ctx, scanMessage("10.0.0.1", scanning.V2, scanning.V2Data{ResponseStr: "again"})); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(failing.calls) != 1 || len(second.calls) != 1 {
+		t.Errorf("Expected no enricher calls for a skipped message, got %d and %d", len(failing.calls)-1, len(second.calls)-1)
+	}
+}
+
+// TestGeoIPEnricher tests that the enricher only accepts MaxMind DB files
+// and copies what the lookup finds onto the record
+func TestGeoIPEnricher(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(dbPath, append([]byte("search tree"), mmdbMetadataMarker...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	otherPath := filepath.Join(dir, "other.db")
+	if err := os.WriteFile(otherPath, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewGeoIPEnricher(GeoIPConfig{}); err == nil {
+		t.Error("Expected an error without a database path")
+	}
+	if _, err := NewGeoIPEnricher(GeoIPConfig{DatabasePath: filepath.Join(dir, "missing.mmdb")}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+	if _, err := NewGeoIPEnricher(GeoIPConfig{DatabasePath: otherPath}); !errors.Is(err, ErrNotMaxMindDB) {
+		t.Errorf("Expected ErrNotMaxMindDB, got %v", err)
+	}
+
+	e, err := NewGeoIPEnricher(GeoIPConfig{DatabasePath: dbPath})
+	if err != nil {
+		t.Fatalf("NewGeoIPEnricher failed: %v", err)
+	}
+	record := &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP"}
+	if err := e.Enrich(context.Background(), record); err != nil {
+		t.Fatalf("Enrich failed: %v", err)
+	}
+	if record.Country != "" || record.ASN != 0 {
+		t.Errorf("Expected the stub lookup to leave the record unchanged, got %q and %d", record.Country, record.ASN)
+	}
+
+	e.lookup = func(addr netip.Addr) (GeoIPResult, error) {
+		if addr != netip.MustParseAddr("1.1.1.1") {
+			t.Errorf("Expected lookup of 1.1.1.1, got %s", addr)
+		}
+		return GeoIPResult{Country: "AU", ASN: 13335}, nil
+	}
+	for _, ip := range []store.IPAddress{"1.1.1.1", "::ffff:1.1.1.1"} {
+		record := &store.ServiceRecord{IP: ip, Port: 80, Service: "HTTP"}
+		if err := e.Enrich(context.Background(), record); err != nil {
+			t.Fatalf("Enrich failed: %v", err)
+		}
+		if record.Country != "AU" || record.ASN != 13335 {
+			t.Errorf("Expected AU and AS13335 for %s, got %q and %d", ip, record.Country, record.ASN)
+		}
+	}
+}
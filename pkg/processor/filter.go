@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/netip"
+)
+
+// errFiltered is returned by parseRawScan for a scan a MessageFilter
+// dropped, so callers can ACK the message instead of failing it
+var errFiltered = errors.New("scan dropped by message filter")
+
+// MessageFilter decides whether a scan is stored
+// It is given the scan's normalized IP and service, before its data is
+// decoded
+type MessageFilter interface {
+	ShouldProcess(ctx context.Context, ip string, port uint32, service string) bool
+}
+
+// WithFilters drops scans that any of filters rejects, evaluated in order
+// Dropped messages are ACKed without being stored or reported as errors,
+// and counted by filtered_messages_total
+// Each service of a multi-scan message is filtered on its own
+func WithFilters(filters ...MessageFilter) Option {
+	return processorOption(func(p *Processor) {
+		p.filters = append(p.filters, filters...)
+	})
+}
+
+// shouldProcess reports whether every filter accepts the scan, counting and
+// logging it when one does not
+func (p *Processor) shouldProcess(ctx context.Context, ip string, port uint32, service string) bool {
+	for _, f := range p.filters {
+		if !f.ShouldProcess(ctx, ip, port, service) {
+			p.metrics.incFiltered()
+			p.logger.Debug("filtered scan",
+				slog.String("ip", ip),
+				slog.Int("port", int(port)),
+				slog.String("service", service),
+			)
+			return false
+		}
+	}
+	return true
+}
+
+// PrivateIPFilter drops scans of private (RFC 1918 and RFC 4193), loopback
+// and link-local addresses
+type PrivateIPFilter struct{}
+
+func (PrivateIPFilter) ShouldProcess(_ context.Context, ip string, _ uint32, _ string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		// Scans are validated before filtering, so this is not expected
+		return true
+	}
+	addr = addr.Unmap()
+	return !addr.IsPrivate() && !addr.IsLoopback() &&
+		!addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast()
+}
+
+// portBlocklist drops scans of the ports it holds
+type portBlocklist map[uint32]struct{}
+
+func (b portBlocklist) ShouldProcess(_ context.Context, _ string, port uint32, _ string) bool {
+	_, blocked := b[port]
+	return !blocked
+}
+
+// PortBlocklistFilter drops scans of any of ports
+func PortBlocklistFilter(ports []uint32) MessageFilter {
+	blocked := make(portBlocklist, len(ports))
+	for _, port := range ports {
+		blocked[port] = struct{}{}
+	}
+	return blocked
+}
+
+// serviceAllowlistFilter drops scans of services outside its allowlist
+type serviceAllowlistFilter struct {
+	allowlist serviceAllowlist
+}
+
+func (f serviceAllowlistFilter) ShouldProcess(_ context.Context, _ string, _ uint32, service string) bool {
+	return f.allowlist.allows(service)
+}
+
+// ServiceAllowlistFilter drops scans whose service is not one of services,
+// compared case-insensitively
+// Unlike WithServiceAllowlist the scans are ACKed rather than rejected with
+// ErrServiceNotAllowed; an empty list allows every service
+func ServiceAllowlistFilter(services []string) MessageFilter {
+	return serviceAllowlistFilter{allowlist: newServiceAllowlist(services)}
+}
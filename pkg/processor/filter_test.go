@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestPrivateIPFilter tests which addresses the filter drops
+func TestPrivateIPFilter(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"1.1.1.1", true},
+		{"2606:4700::1111", true},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.32.0.1", true},
+		{"192.168.1.1", false},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"169.254.1.1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:192.168.1.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := (PrivateIPFilter{}).ShouldProcess(context.Background(), tt.ip, 80, "HTTP"); got != tt.want {
+			t.Errorf("ShouldProcess(%s): expected %v, got %v", tt.ip, tt.want, got)
+		}
+	}
+}
+
+// TestPortBlocklistFilter tests that only the listed ports are dropped
+func TestPortBlocklistFilter(t *testing.T) {
+	f := PortBlocklistFilter([]uint32{23, 445})
+	tests := []struct {
+		port uint32
+		want bool
+	}{
+		{23, false},
+		{445, false},
+		{22, true},
+		{80, true},
+	}
+
+	for _, tt := range tests {
+		if got := f.ShouldProcess(context.Background(), "1.1.1.1", tt.port, "HTTP"); got != tt.want {
+			t.Errorf("ShouldProcess(port %d): expected %v, got %v", tt.port, tt.want, got)
+		}
+	}
+}
+
+// TestServiceAllowlistFilter tests that services outside the allowlist are
+// dropped, case-insensitively, and that an empty list allows all
+func TestServiceAllowlistFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []string
+		service  string
+		want     bool
+	}{
+		{"listed", []string{"HTTP", "SSH"}, "HTTP", true},
+		{"case-insensitive", []string{"http"}, "HTTP", true},
+		{"unlisted", []string{"HTTP", "SSH"}, "FTP", false},
+		{"empty list", nil, "FTP", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := ServiceAllowlistFilter(tt.services)
+			if got := f.ShouldProcess(context.Background(), "1.1.1.1", 80, tt.service); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestProcessFilters tests that Process ACKs scans any filter rejects
+// without decoding or storing them, and counts each one
+func TestProcessFilters(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore,
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithMetrics(prometheus.NewPedanticRegistry()),
+		WithFilters(PrivateIPFilter{}, PortBlocklistFilter([]uint32{23}), ServiceAllowlistFilter([]string{"HTTP", "TELNET"})),
+	)
+	ctx := context.Background()
+
+	messages := []struct {
+		name    string
+		message string
+	}{
+		{"accepted", `{"ip": "1.1.1.1", "port": 80, "service": "http", "timestamp": 1000, "data_version": 2, "data": {"response_str": "ok"}}`},
+		{"private IP", `{"ip": "10.0.0.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "ok"}}`},
+		{"blocked port", `{"ip": "1.1.1.2", "port": 23, "service": "TELNET", "timestamp": 1000, "data_version": 2, "data": {"response_str": "ok"}}`},
+		{"service not allowed", `{"ip": "1.1.1.3", "port": 22, "service": "SSH", "timestamp": 1000, "data_version": 2, "data": {"response_str": "ok"}}`},
+		// The data is never decoded, so an unknown version is not an error
+		{"undecodable data", `{"ip": "192.168.0.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 99, "data": {}}`},
+	}
+	for _, m := range messages {
+		if err := proc.Process(ctx, []byte(m.message)); err != nil {
+			t.Errorf("%s: expected no error, got %v", m.name, err)
+		}
+	}
+	if memStore.Len() != 1 {
+		t.Errorf("Expected only the accepted scan to be stored, got %d records", memStore.Len())
+	}
+	if got := testutil.ToFloat64(proc.metrics.filtered); got != 4 {
+		t.Errorf("Expected filtered_messages_total 4, got %v", got)
+	}
+
+	// The envelope is still validated before the filters run
+	if err := proc.Process(ctx, []byte(`{"ip": "not an ip", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {}}`)); err == nil {
+		t.Error("Expected an invalid envelope to fail before filtering")
+	}
+
+	// Each service of a multi-scan is filtered on its own
+	v2, _ := json.Marshal(scanning.V2Data{ResponseStr: "ok"})
+	multi, _ := json.Marshal(scanning.MultiScan{
+		DataType:  scanning.DataTypeMulti,
+		IP:        "2.2.2.2",
+		Timestamp: 1000,
+		Services: []scanning.ServiceEntry{
+			{Port: 80, Service: "HTTP", DataVersion: scanning.V2, Data: v2},
+			{Port: 23, Service: "TELNET", DataVersion: scanning.V2, Data: v2},
+		},
+	})
+	if err := proc.Process(ctx, multi); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if got, _ := memStore.ListByIP(ctx, "2.2.2.2"); len(got) != 1 || got[0].Port != 80 {
+		t.Errorf("Expected only port 80 stored for 2.2.2.2, got %d records", len(got))
+	}
+
+	// A batch ACKs filtered messages alongside stored ones
+	errs := proc.ProcessBatch(ctx, [][]byte{
+		[]byte(`{"ip": "3.3.3.3", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "ok"}}`),
+		[]byte(`{"ip": "127.0.0.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "ok"}}`),
+	})
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Expected no error for batch message %d, got %v", i, err)
+		}
+	}
+	if got, _ := memStore.Get(ctx, "127.0.0.1", 80, "HTTP"); got != nil {
+		t.Error("Expected the loopback scan in the batch to be dropped")
+	}
+	if got := testutil.ToFloat64(proc.metrics.filtered); got != 6 {
+		t.Errorf("Expected filtered_messages_total 6, got %v", got)
+	}
+}
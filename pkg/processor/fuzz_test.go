@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// The seed corpus is checked in under testdata/fuzz/<target>: valid V1 and
+// V2 messages, a truncated message, null fields and out of range ports
+// Run a target with, for example:
+//
+//	go test ./pkg/processor -run '^$' -fuzz=FuzzParseScan -fuzztime=60s
+//
+// and check in any failing input the fuzzer writes to testdata/fuzz
+
+// newFuzzProcessor returns a processor over a memory store that discards
+// its logs
+func newFuzzProcessor() (*Processor, store.Store) {
+	s := store.NewMemoryStore()
+	return NewProcessor(s, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))), s
+}
+
+// FuzzParseScan tests that parseScan never panics, and that every scan it
+// accepts passes the envelope rules
+func FuzzParseScan(f *testing.F) {
+	proc, _ := newFuzzProcessor()
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		scan, result, err := proc.parseScan(context.Background(), data)
+		if err != nil {
+			if scan != nil || result != nil {
+				t.Errorf("Expected no scan with error %v, got %+v, %+v", err, scan, result)
+			}
+			return
+		}
+
+		if scan == nil || result == nil {
+			t.Fatalf("Expected a scan and result without an error, got %+v, %+v", scan, result)
+		}
+		if net.ParseIP(scan.Ip) == nil {
+			t.Errorf("Expected a valid IP, got %q", scan.Ip)
+		}
+		if scan.Port < 1 || scan.Port > 65535 {
+			t.Errorf("Expected a port in 1-65535, got %d", scan.Port)
+		}
+		if scan.Service == "" {
+			t.Error("Expected a service")
+		}
+		if scan.Timestamp <= 0 {
+			t.Errorf("Expected a positive timestamp, got %d", scan.Timestamp)
+		}
+		if result.ResponseHash != store.HashResponse(result.Response) {
+			t.Errorf("Expected the hash of %q, got %s", result.Response, result.ResponseHash)
+		}
+	})
+}
+
+// FuzzProcessMessage tests that Process never panics, and that a scan it
+// accepts leaves a record for the scan's key in the store
+func FuzzProcessMessage(f *testing.F) {
+	proc, s := newFuzzProcessor()
+
+	// A newer record for the V1 seed's key, so updates and skips both run
+	ctx := context.Background()
+	if _, err := s.Upsert(ctx, &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1800000000, Response: "newer"}); err != nil {
+		f.Fatalf("Upsert failed: %v", err)
+	}
+	if _, err := s.Upsert(ctx, &store.ServiceRecord{IP: "2001:db8::1", Port: 22, Service: "SSH", LastTimestamp: 1, Response: "older"}); err != nil {
+		f.Fatalf("Upsert failed: %v", err)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := proc.Process(ctx, data); err != nil {
+			return
+		}
+
+		// Multi-service reports do not parse as a single scan
+		scan, _, err := proc.parseScan(context.Background(), data)
+		if err != nil {
+			return
+		}
+		record, err := s.Get(ctx, scan.Ip, scan.Port, scan.Service)
+		if err != nil || record == nil {
+			t.Errorf("Expected a record for %s:%d/%s, got %v", scan.Ip, scan.Port, scan.Service, err)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/generator"
+	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// TestParseGeneratedScans tests that synthetic load test messages of each
+// data version parse like scanner messages
+func TestParseGeneratedScans(t *testing.T) {
+	proc := NewProcessor(store.NewMemoryStore(), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	for _, version := range []int{scanning.V1, scanning.V2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			gen, err := generator.New(20, version, 7)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+
+			for i := 0; i < 100; i++ {
+				data, err := gen.NextMessage()
+				if err != nil {
+					t.Fatalf("NextMessage failed: %v", err)
+				}
+				scan, result, err := proc.parseScan(context.Background(), data)
+				if err != nil {
+					t.Fatalf("Expected message %d to parse, got %v: %s", i, err, data)
+				}
+				if scan.DataVersion != version {
+					t.Errorf("Expected data version %d, got %d", version, scan.DataVersion)
+				}
+				if result.Response == "" {
+					t.Errorf("Expected a response in message %d", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Kafka start offsets for a consumer group without committed offsets
+const (
+	KafkaOffsetEarliest = "earliest"
+	KafkaOffsetLatest   = "latest"
+)
+
+// kafkaRetryDelay is how long a failed Kafka record waits before it is
+// processed again
+const kafkaRetryDelay = time.Second
+
+// KafkaConfig holds everything needed to build a KafkaConsumer
+type KafkaConfig struct {
+	// Brokers seeds the client with at least one broker address; required
+	Brokers []string
+	// Topic is consumed for scan messages; required
+	Topic string
+	// ConsumerGroup tracks offsets so instances share the topic's
+	// partitions; required
+	ConsumerGroup string
+	// StartOffset is where a group without committed offsets starts,
+	// KafkaOffsetEarliest (the default) or KafkaOffsetLatest
+	StartOffset string
+
+	// DeadLetterTopic receives records that fail MaxDeliveries times, and
+	// invalid records at once; empty drops them with an error log
+	DeadLetterTopic string
+	// MaxDeliveries is how many failed attempts a record gets before it is
+	// dead-lettered or dropped
+	MaxDeliveries int
+	// DrainTimeout bounds how long Close waits for records being processed
+	DrainTimeout time.Duration
+}
+
+// KafkaConfigFromEnv loads Kafka consumer settings from the environment
+//
+//	KAFKA_BROKERS (required, comma-separated)
+//	KAFKA_TOPIC (required)
+//	KAFKA_CONSUMER_GROUP (required)
+//	KAFKA_START_OFFSET
+//	KAFKA_DEAD_LETTER_TOPIC
+//	CONSUMER_MAX_DELIVERIES
+//	CONSUMER_DRAIN_TIMEOUT
+func KafkaConfigFromEnv() (*KafkaConfig, error) {
+	cfg := &KafkaConfig{
+		Topic:           os.Getenv("KAFKA_TOPIC"),
+		ConsumerGroup:   os.Getenv("KAFKA_CONSUMER_GROUP"),
+		StartOffset:     os.Getenv("KAFKA_START_OFFSET"),
+		DeadLetterTopic: os.Getenv("KAFKA_DEAD_LETTER_TOPIC"),
+		MaxDeliveries:   defaultMaxDeliveries,
+		DrainTimeout:    defaultDrainTimeout,
+	}
+	for _, b := range strings.Split(os.Getenv("KAFKA_BROKERS"), ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			cfg.Brokers = append(cfg.Brokers, b)
+		}
+	}
+	if err := intFromEnv("CONSUMER_MAX_DELIVERIES", &cfg.MaxDeliveries); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("CONSUMER_DRAIN_TIMEOUT", &cfg.DrainTimeout); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every missing or invalid field
+func (cfg *KafkaConfig) Validate() error {
+	var problems []string
+	if len(cfg.Brokers) == 0 {
+		problems = append(problems, "at least one broker is required (set KAFKA_BROKERS)")
+	}
+	if cfg.Topic == "" {
+		problems = append(problems, "topic is required (set KAFKA_TOPIC)")
+	}
+	if cfg.ConsumerGroup == "" {
+		problems = append(problems, "consumer group is required (set KAFKA_CONSUMER_GROUP)")
+	}
+	switch cfg.StartOffset {
+	case "", KafkaOffsetEarliest, KafkaOffsetLatest:
+	default:
+		problems = append(problems, fmt.Sprintf("start offset must be %q or %q, got %q",
+			KafkaOffsetEarliest, KafkaOffsetLatest, cfg.StartOffset))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid kafka config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// KafkaConsumer consumes scan messages from a Kafka topic as a member of a
+// consumer group
+// Records carry the same JSON envelope as Pub/Sub messages
+// Partitions are processed concurrently, each in order, and offsets are
+// committed only for records that were stored or dead-lettered
+// (at-least-once semantics)
+type KafkaConsumer struct {
+	client    *kgo.Client
+	processor *Processor
+	logger    *slog.Logger
+	cfg       KafkaConfig
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc // stops polling, set while Start is running
+	stopped chan struct{}      // closed once Start has returned
+	closed  bool
+}
+
+// NewKafkaConsumer connects to the brokers in cfg
+// The consumer logs with the processor's logger
+func NewKafkaConsumer(ctx context.Context, cfg *KafkaConfig, proc *Processor) (*KafkaConsumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	offset := kgo.NewOffset().AtStart()
+	if cfg.StartOffset == KafkaOffsetLatest {
+		offset = kgo.NewOffset().AtEnd()
+	}
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ConsumerGroup(cfg.ConsumerGroup),
+		kgo.ConsumeTopics(cfg.Topic),
+		kgo.ConsumeResetOffset(offset),
+		// Only records marked once processed are committed, and partitions
+		// are not reassigned while a poll is still being processed
+		kgo.AutoCommitMarks(),
+		kgo.BlockRebalanceOnPoll(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka client: %w", err)
+	}
+	if err := client.Ping(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to kafka: %w", err)
+	}
+
+	c := &KafkaConsumer{
+		client:    client,
+		processor: proc,
+		logger:    proc.logger,
+		cfg:       *cfg,
+	}
+	if c.cfg.MaxDeliveries < 1 {
+		c.cfg.MaxDeliveries = defaultMaxDeliveries
+	}
+	if c.cfg.DrainTimeout <= 0 {
+		c.cfg.DrainTimeout = defaultDrainTimeout
+	}
+	return c, nil
+}
+
+// Start consumes records from the topic
+// This method blocks until the context is cancelled or Close is called
+func (c *KafkaConsumer) Start(ctx context.Context) error {
+	c.logger.Info("starting to consume messages",
+		slog.String("topic", c.cfg.Topic),
+		slog.String("consumer_group", c.cfg.ConsumerGroup),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	c.mu.Lock()
+	c.cancel = cancel
+	c.stopped = stopped
+	c.mu.Unlock()
+
+	for {
+		fetches := c.client.PollFetches(ctx)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			return nil
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			c.logger.Error("failed to fetch records",
+				slog.String("topic", topic),
+				slog.Int("partition", int(partition)),
+				slog.Any("error", err),
+			)
+		})
+
+		var wg sync.WaitGroup
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			if len(p.Records) == 0 {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.handlePartition(ctx, p.Records)
+			}()
+		})
+		wg.Wait()
+		c.client.AllowRebalance()
+	}
+}
+
+// handlePartition processes one partition's records in order until ctx is
+// cancelled, so the rest are consumed again after a restart
+func (c *KafkaConsumer) handlePartition(ctx context.Context, records []*kgo.Record) {
+	for _, r := range records {
+		if ctx.Err() != nil || !c.handle(ctx, r) {
+			return
+		}
+		c.client.MarkCommitRecords(r)
+	}
+}
+
+// handle processes a record until it succeeds, is dead-lettered or is
+// dropped, and reports whether its offset can be committed
+// Invalid records are never retried, and without a dead-letter topic a
+// record is dropped after MaxDeliveries attempts, so one record cannot stall
+// the partitions polled with it
+// With a dead-letter topic a record is retried until it is published there
+func (c *KafkaConsumer) handle(ctx context.Context, r *kgo.Record) bool {
+	// Let a record that is already being processed complete after shutdown
+	// starts rather than failing on a cancelled context
+	procCtx := context.WithoutCancel(ctx)
+	for attempt := 1; ; attempt++ {
+		// Process logs failures itself
+		err := c.processor.Process(procCtx, r.Value)
+		if err == nil {
+			return true
+		}
+		if attempt >= c.cfg.MaxDeliveries || isPermanent(err) {
+			if c.cfg.DeadLetterTopic == "" {
+				c.logger.Error("dropped message",
+					slog.String("topic", r.Topic),
+					slog.Int("partition", int(r.Partition)),
+					slog.Int64("offset", r.Offset),
+					slog.Int("attempts", attempt),
+					slog.Any("error", err),
+				)
+				return true
+			}
+			if c.publishDeadLetter(procCtx, r, err) {
+				return true
+			}
+		}
+
+		timer := time.NewTimer(kafkaRetryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// publishDeadLetter copies a record that keeps failing to the dead-letter
+// topic, with the processing error in the errorAttribute header, and
+// reports whether it was published
+func (c *KafkaConsumer) publishDeadLetter(ctx context.Context, r *kgo.Record, procErr error) bool {
+	headers := append([]kgo.RecordHeader{}, r.Headers...)
+	headers = append(headers, kgo.RecordHeader{Key: errorAttribute, Value: []byte(procErr.Error())})
+	dead := &kgo.Record{Topic: c.cfg.DeadLetterTopic, Key: r.Key, Value: r.Value, Headers: headers}
+
+	if err := c.client.ProduceSync(ctx, dead).FirstErr(); err != nil {
+		c.logger.Error("failed to publish to dead-letter topic",
+			slog.String("topic", c.cfg.DeadLetterTopic),
+			slog.Int("partition", int(r.Partition)),
+			slog.Int64("offset", r.Offset),
+			slog.Any("error", err),
+		)
+		return false
+	}
+
+	c.logger.Warn("dead-lettered message",
+		slog.String("topic", c.cfg.DeadLetterTopic),
+		slog.Int("partition", int(r.Partition)),
+		slog.Int64("offset", r.Offset),
+		slog.Any("error", procErr),
+	)
+	return true
+}
+
+// Close stops polling, waits up to the drain timeout for records being
+// processed, commits the offsets of processed records and leaves the group
+// Records still processing when the timeout expires are consumed again by
+// whichever member next owns their partition
+func (c *KafkaConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.stopped != nil {
+		select {
+		case <-c.stopped:
+		case <-time.After(c.cfg.DrainTimeout):
+			c.logger.Warn("drain timeout expired, abandoning in-flight messages",
+				slog.Duration("timeout", c.cfg.DrainTimeout))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.DrainTimeout)
+	defer cancel()
+	err := c.client.CommitMarkedOffsets(ctx)
+	c.client.CloseAllowingRebalance()
+	if err != nil {
+		return fmt.Errorf("failed to commit offsets: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// TestKafkaConfigFromEnv tests defaults and environment overrides
+func TestKafkaConfigFromEnv(t *testing.T) {
+	t.Setenv("KAFKA_BROKERS", "kafka-1:9092, kafka-2:9092,")
+	t.Setenv("KAFKA_TOPIC", "scans")
+	t.Setenv("KAFKA_CONSUMER_GROUP", "processor")
+
+	cfg, err := KafkaConfigFromEnv()
+	if err != nil {
+		t.Fatalf("KafkaConfigFromEnv failed: %v", err)
+	}
+	if len(cfg.Brokers) != 2 || cfg.Brokers[0] != "kafka-1:9092" || cfg.Brokers[1] != "kafka-2:9092" {
+		t.Errorf("Expected two trimmed brokers, got %q", cfg.Brokers)
+	}
+	if cfg.StartOffset != "" || cfg.MaxDeliveries != 5 || cfg.DrainTimeout != 30*time.Second {
+		t.Errorf("Expected defaults, got %+v", cfg)
+	}
+
+	t.Setenv("KAFKA_START_OFFSET", KafkaOffsetLatest)
+	t.Setenv("KAFKA_DEAD_LETTER_TOPIC", "scans-dead-letter")
+	t.Setenv("CONSUMER_MAX_DELIVERIES", "2")
+	cfg, err = KafkaConfigFromEnv()
+	if err != nil {
+		t.Fatalf("KafkaConfigFromEnv failed: %v", err)
+	}
+	if cfg.StartOffset != KafkaOffsetLatest || cfg.DeadLetterTopic != "scans-dead-letter" || cfg.MaxDeliveries != 2 {
+		t.Errorf("Expected overrides, got %+v", cfg)
+	}
+}
+
+// TestKafkaConfigValidate tests that every problem is reported
+func TestKafkaConfigValidate(t *testing.T) {
+	err := (&KafkaConfig{StartOffset: "middle"}).Validate()
+	if err == nil {
+		t.Fatal("Expected error for empty config")
+	}
+	for _, want := range []string{"KAFKA_BROKERS", "KAFKA_TOPIC", "KAFKA_CONSUMER_GROUP", `"middle"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+// TestKafkaConsumerEndToEnd publishes 20 scans to a real Kafka topic and
+// checks that Start stores every one of them
+// It is skipped unless KAFKA_TEST_BROKERS is set, for example to
+// localhost:9092 for a broker that allows automatic topic creation
+func TestKafkaConsumerEndToEnd(t *testing.T) {
+	brokers := os.Getenv("KAFKA_TEST_BROKERS")
+	if brokers == "" {
+		t.Skip("KAFKA_TEST_BROKERS not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	topic := fmt.Sprintf("scans-test-%d", time.Now().UnixNano())
+	producer, err := kgo.NewClient(kgo.SeedBrokers(strings.Split(brokers, ",")...), kgo.AllowAutoTopicCreation())
+	if err != nil {
+		t.Fatalf("Failed to create producer: %v", err)
+	}
+	defer producer.Close()
+	for i := 0; i < 20; i++ {
+		value := scanMessage(fmt.Sprintf("10.0.0.%d", i), scanning.V2, scanning.V2Data{ResponseStr: "ok"})
+		if err := producer.ProduceSync(ctx, &kgo.Record{Topic: topic, Value: value}).FirstErr(); err != nil {
+			t.Fatalf("Failed to produce message %d: %v", i, err)
+		}
+	}
+
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	proc := NewProcessor(memStore, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, err := NewKafkaConsumer(ctx, &KafkaConfig{
+		Brokers:       strings.Split(brokers, ","),
+		Topic:         topic,
+		ConsumerGroup: topic,
+	}, proc)
+	if err != nil {
+		t.Fatalf("Failed to create consumer: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- consumer.Start(ctx) }()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for memStore.Len() < 20 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Start failed: %v", err)
+	}
+	if memStore.Len() != 20 {
+		t.Errorf("Expected 20 records stored, got %d", memStore.Len())
+	}
+}
+
+// TestKafkaConsumerHandleGivesUp tests that without a dead-letter topic an
+// invalid record is skipped at once and a failing one after MaxDeliveries
+// attempts, rather than being retried forever
+func TestKafkaConsumerHandleGivesUp(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	counting := &countingStore{Store: failingStore{memStore}}
+	proc := NewProcessor(counting, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	c := &KafkaConsumer{processor: proc, logger: proc.logger, cfg: KafkaConfig{MaxDeliveries: 2}}
+	ctx := context.Background()
+
+	for _, value := range []string{
+		`{"ip": `,
+		`{"ip": "not an ip", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {}}`,
+	} {
+		start := time.Now()
+		if !c.handle(ctx, &kgo.Record{Value: []byte(value)}) {
+			t.Errorf("Expected invalid record %q to be committed", value)
+		}
+		if elapsed := time.Since(start); elapsed >= kafkaRetryDelay {
+			t.Errorf("Expected invalid record %q not to be retried, took %v", value, elapsed)
+		}
+	}
+
+	start := time.Now()
+	if !c.handle(ctx, &kgo.Record{Value: scanMessage("10.0.0.1", scanning.V2, scanning.V2Data{ResponseStr: "ok"})}) {
+		t.Error("Expected a failing record to be committed after MaxDeliveries attempts")
+	}
+	if n := counting.upserts.Load(); n != 2 {
+		t.Errorf("Expected 2 attempts, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < kafkaRetryDelay || elapsed >= 2*kafkaRetryDelay {
+		t.Errorf("Expected one retry delay, took %v", elapsed)
+	}
+}
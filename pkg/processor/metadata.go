@@ -0,0 +1,24 @@
+package processor
+
+import (
+	"context"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// messageMetadataKey is the context key for the metadata of the message
+// being processed
+type messageMetadataKey struct{}
+
+// WithMessageMetadata returns a context carrying the metadata of the message
+// being processed, which Process stores on the records it upserts
+func WithMessageMetadata(ctx context.Context, m *store.MessageMetadata) context.Context {
+	return context.WithValue(ctx, messageMetadataKey{}, m)
+}
+
+// MessageMetadataFromContext returns the message metadata carried by ctx, or
+// nil if there is none
+func MessageMetadataFromContext(ctx context.Context) *store.MessageMetadata {
+	m, _ := ctx.Value(messageMetadataKey{}).(*store.MessageMetadata)
+	return m
+}
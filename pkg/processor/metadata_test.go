@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// TestProcessStoresMessageMetadata tests that Process stores the message
+// metadata carried by the context, and none without it
+func TestProcessStoresMessageMetadata(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	proc := NewProcessor(memStore, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	meta := &store.MessageMetadata{MessageID: "msg-1", SubscriptionID: "scan-sub", PublishTime: time.Unix(1700000000, 0).UTC()}
+	ctx := WithMessageMetadata(context.Background(), meta)
+	if err := proc.Process(ctx, scanMessage("10.0.0.1", scanning.V2, scanning.V2Data{ResponseStr: "ok"})); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if err := proc.Process(context.Background(), scanMessage("10.0.0.2", scanning.V2, scanning.V2Data{ResponseStr: "ok"})); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	record, err := memStore.Get(context.Background(), "10.0.0.1", 80, "HTTP")
+	if err != nil || record == nil {
+		t.Fatalf("Expected record, got %v (err %v)", record, err)
+	}
+	if record.MessageMetadata == nil || *record.MessageMetadata != *meta {
+		t.Errorf("Expected message metadata %+v, got %+v", meta, record.MessageMetadata)
+	}
+
+	record, err = memStore.Get(context.Background(), "10.0.0.2", 80, "HTTP")
+	if err != nil || record == nil {
+		t.Fatalf("Expected record, got %v (err %v)", record, err)
+	}
+	if record.MessageMetadata != nil {
+		t.Errorf("Expected no message metadata, got %+v", record.MessageMetadata)
+	}
+}
+
+// TestConsumerStoresMessageMetadata tests that the consumer records the ID,
+// subscription and publish time of the message behind each record
+func TestConsumerStoresMessageMetadata(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	consumer, srv := newTestConsumer(t, proc)
+	id := publishScan(t, srv, "10.0.3.1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- consumer.Start(ctx) }()
+
+	waitForAcks(t, srv, 1)
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	record, err := memStore.Get(context.Background(), "10.0.3.1", 80, "HTTP")
+	if err != nil || record == nil {
+		t.Fatalf("Expected record, got %v (err %v)", record, err)
+	}
+	meta := record.MessageMetadata
+	if meta == nil {
+		t.Fatal("Expected message metadata, got nil")
+	}
+	if meta.MessageID != id || meta.SubscriptionID != testSub {
+		t.Errorf("Expected message %s from %s, got %+v", id, testSub, meta)
+	}
+	if meta.PublishTime.IsZero() {
+		t.Error("Expected the publish time to be set")
+	}
+}
@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Result label values for messages_processed_total
+const (
+	resultUpdated = "updated"
+	resultSkipped = "skipped"
+	resultError   = "error"
+)
+
+// storeCountTimeout bounds the Count query made on each scrape
+const storeCountTimeout = 5 * time.Second
+
+// ProcessorMetrics holds the Prometheus collectors for a Processor
+// A nil *ProcessorMetrics is valid and records nothing
+type ProcessorMetrics struct {
+	messagesProcessed  *prometheus.CounterVec
+	processingDuration prometheus.Histogram
+	storeRecords       prometheus.GaugeFunc
+	messagesReceived   prometheus.Counter
+	duplicatesSkipped  prometheus.Counter
+	rateLimited        prometheus.Counter
+	filtered           prometheus.Counter
+}
+
+// newProcessorMetrics creates the collectors and registers them with reg
+// store_records_total is sampled from Store.Count when scraped
+func newProcessorMetrics(reg prometheus.Registerer, s store.Store) *ProcessorMetrics {
+	m := &ProcessorMetrics{
+		messagesProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "messages_processed_total",
+			Help: "Scan messages processed, by result.",
+		}, []string{"result"}),
+		processingDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "message_processing_duration_seconds",
+			Help:    "Time taken to parse and store a scan message.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}),
+		storeRecords: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "store_records_total",
+			Help: "Number of records in the store.",
+		}, func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), storeCountTimeout)
+			defer cancel()
+			count, err := s.Count(ctx)
+			if err != nil {
+				return 0
+			}
+			return float64(count)
+		}),
+		messagesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pubsub_messages_received_total",
+			Help: "Messages received from the Pub/Sub subscription.",
+		}),
+		duplicatesSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "duplicates_skipped_total",
+			Help: "Redelivered messages ACKed without processing by the deduplication window.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limited_messages_total",
+			Help: "Messages delayed by the per-IP rate limit.",
+		}),
+		filtered: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "filtered_messages_total",
+			Help: "Scans dropped by a message filter, counting each service of a multi-scan.",
+		}),
+	}
+
+	// Pre-create each result so the series exist before the first message
+	for _, result := range []string{resultUpdated, resultSkipped, resultError} {
+		m.messagesProcessed.WithLabelValues(result)
+	}
+
+	reg.MustRegister(m.messagesProcessed, m.processingDuration, m.storeRecords, m.messagesReceived, m.duplicatesSkipped, m.rateLimited, m.filtered)
+	return m
+}
+
+// observeProcessed records the outcome and duration of a processed message
+func (m *ProcessorMetrics) observeProcessed(result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.messagesProcessed.WithLabelValues(result).Inc()
+	m.processingDuration.Observe(d.Seconds())
+}
+
+// incReceived counts a message received from Pub/Sub
+func (m *ProcessorMetrics) incReceived() {
+	if m == nil {
+		return
+	}
+	m.messagesReceived.Inc()
+}
+
+// incDuplicate counts a message skipped as a duplicate
+func (m *ProcessorMetrics) incDuplicate() {
+	if m == nil {
+		return
+	}
+	m.duplicatesSkipped.Inc()
+}
+
+// incRateLimited counts a message delayed by the per-IP rate limit
+func (m *ProcessorMetrics) incRateLimited() {
+	if m == nil {
+		return
+	}
+	m.rateLimited.Inc()
+}
+
+// incFiltered counts a scan dropped by a message filter
+func (m *ProcessorMetrics) incFiltered() {
+	if m == nil {
+		return
+	}
+	m.filtered.Inc()
+}
@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestProcessorMetrics tests that Process updates the registered metrics
+func TestProcessorMetrics(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	proc := NewProcessor(memStore, WithMetrics(reg))
+	ctx := context.Background()
+
+	messages := []string{
+		`{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 2000, "data_version": 2, "data": {"response_str": "a"}}`,
+		`{"ip": "1.1.1.2", "port": 80, "service": "HTTP", "timestamp": 2000, "data_version": 2, "data": {"response_str": "b"}}`,
+		// Older than the stored record
+		`{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "c"}}`,
+		`{not json`,
+	}
+	for _, m := range messages {
+		proc.Process(ctx, []byte(m))
+	}
+
+	expected := map[string]float64{resultUpdated: 2, resultSkipped: 1, resultError: 1}
+	for result, want := range expected {
+		got := testutil.ToFloat64(proc.metrics.messagesProcessed.WithLabelValues(result))
+		if got != want {
+			t.Errorf("Expected %v %s messages, got %v", want, result, got)
+		}
+	}
+
+	if got := testutil.ToFloat64(proc.metrics.storeRecords); got != 2 {
+		t.Errorf("Expected store_records_total 2, got %v", got)
+	}
+
+	// Gathering through the pedantic registry validates the metric families
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+		if f.GetName() == "message_processing_duration_seconds" {
+			if count := f.GetMetric()[0].GetHistogram().GetSampleCount(); count != 4 {
+				t.Errorf("Expected 4 duration observations, got %d", count)
+			}
+		}
+	}
+	for _, name := range []string{
+		"messages_processed_total",
+		"message_processing_duration_seconds",
+		"store_records_total",
+		"pubsub_messages_received_total",
+		"duplicates_skipped_total",
+		"filtered_messages_total",
+	} {
+		if !names[name] {
+			t.Errorf("Expected metric %s to be registered", name)
+		}
+	}
+}
+
+// TestProcessorWithoutMetrics tests that metrics are optional
+func TestProcessorWithoutMetrics(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore)
+	if proc.metrics != nil {
+		t.Error("Expected no metrics without WithMetrics")
+	}
+
+	message := `{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "a"}}`
+	if err := proc.Process(context.Background(), []byte(message)); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// ProcessFunc processes a single scan message
+type ProcessFunc = func(ctx context.Context, data []byte) error
+
+// ProcessorMiddleware wraps message processing, see WithMiddleware
+// It may inspect or replace data before calling next, inspect the error
+// next returns, or return without calling next to drop the message
+type ProcessorMiddleware func(ctx context.Context, data []byte, next ProcessFunc) error
+
+// chainMiddleware wraps final in mw so mw[0] runs first
+func chainMiddleware(mw []ProcessorMiddleware, final ProcessFunc) ProcessFunc {
+	h := final
+	for i := len(mw) - 1; i >= 0; i-- {
+		m, next := mw[i], h
+		h = func(ctx context.Context, data []byte) error {
+			return m(ctx, data, next)
+		}
+	}
+	return h
+}
+
+// LoggingMiddleware logs the size, duration and error of every message at
+// debug level
+func LoggingMiddleware(logger *slog.Logger) ProcessorMiddleware {
+	return func(ctx context.Context, data []byte, next ProcessFunc) error {
+		start := time.Now()
+		err := next(ctx, data)
+		attrs := []slog.Attr{
+			slog.Int("size", len(data)),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		}
+		logger.LogAttrs(ctx, slog.LevelDebug, "processed message", attrs...)
+		return err
+	}
+}
+
+// MetricsMiddleware counts messages by result and times them, including
+// any middleware after it in the chain, registering the collectors with reg
+func MetricsMiddleware(reg prometheus.Registerer) ProcessorMiddleware {
+	messages := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "middleware_messages_total",
+		Help: "Scan messages passed through the processor middleware, by result.",
+	}, []string{"result"})
+	duration := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "middleware_message_duration_seconds",
+		Help:    "Time taken by the rest of the processor middleware chain.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+	for _, result := range []string{"success", resultError} {
+		messages.WithLabelValues(result)
+	}
+	reg.MustRegister(messages, duration)
+
+	return func(ctx context.Context, data []byte, next ProcessFunc) error {
+		start := time.Now()
+		err := next(ctx, data)
+		duration.Observe(time.Since(start).Seconds())
+		result := "success"
+		if err != nil {
+			result = resultError
+		}
+		messages.WithLabelValues(result).Inc()
+		return err
+	}
+}
+
+// RateLimitMiddleware holds each message until the limiter allows it, at
+// limit messages per second with bursts of up to burst
+// A message whose context ends while waiting fails, so it is redelivered
+func RateLimitMiddleware(limit rate.Limit, burst int) ProcessorMiddleware {
+	limiter := rate.NewLimiter(limit, burst)
+	return func(ctx context.Context, data []byte, next ProcessFunc) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("failed to wait for rate limit: %w", err)
+		}
+		return next(ctx, data)
+	}
+}
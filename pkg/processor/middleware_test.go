@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+)
+
+// middlewareMessage is a valid scan message used by the middleware tests
+const middlewareMessage = `{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 2000, "data_version": 2, "data": {"response_str": "a"}}`
+
+// recordingMiddleware appends name to calls before and after calling next
+func recordingMiddleware(name string, calls *[]string) ProcessorMiddleware {
+	return func(ctx context.Context, data []byte, next ProcessFunc) error {
+		*calls = append(*calls, name+" before")
+		err := next(ctx, data)
+		*calls = append(*calls, name+" after")
+		return err
+	}
+}
+
+// TestMiddlewareOrder tests that middleware runs in the order given, around
+// the processor
+func TestMiddlewareOrder(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	var calls []string
+	core := func(ctx context.Context, data []byte, next ProcessFunc) error {
+		err := next(ctx, data)
+		if memStore.Len() == 1 {
+			calls = append(calls, "stored")
+		}
+		return err
+	}
+	proc := NewProcessor(memStore,
+		WithMiddleware(recordingMiddleware("a", &calls), recordingMiddleware("b", &calls)),
+		WithMiddleware(core),
+	)
+	if err := proc.Process(context.Background(), []byte(middlewareMessage)); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	want := []string{"a before", "b before", "stored", "b after", "a after"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected calls %v, got %v", want, calls)
+	}
+}
+
+// TestMiddlewareShortCircuit tests that a middleware can stop a message
+// from reaching the processor or replace its data
+func TestMiddlewareShortCircuit(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	ctx := context.Background()
+
+	errDropped := errors.New("dropped")
+	var calls []string
+	drop := func(ctx context.Context, data []byte, next ProcessFunc) error {
+		if strings.Contains(string(data), "1.1.1.1") {
+			return errDropped
+		}
+		return next(ctx, data)
+	}
+	proc := NewProcessor(memStore, WithMiddleware(drop, recordingMiddleware("inner", &calls)))
+
+	if err := proc.Process(ctx, []byte(middlewareMessage)); !errors.Is(err, errDropped) {
+		t.Errorf("Expected middleware error, got %v", err)
+	}
+	if len(calls) != 0 || memStore.Len() != 0 {
+		t.Errorf("Expected chain to stop, got calls %v and %d records", calls, memStore.Len())
+	}
+
+	rewrite := func(ctx context.Context, data []byte, next ProcessFunc) error {
+		return next(ctx, []byte(strings.Replace(string(data), `"a"`, `"rewritten"`, 1)))
+	}
+	proc = NewProcessor(memStore, WithMiddleware(rewrite))
+	if err := proc.Process(ctx, []byte(middlewareMessage)); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if record, _ := memStore.Get(ctx, "1.1.1.1", 80, "HTTP"); record == nil || record.Response != "rewritten" {
+		t.Errorf("Expected rewritten response, got %+v", record)
+	}
+}
+
+// TestMetricsMiddleware tests that messages are counted by result
+func TestMetricsMiddleware(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	ctx := context.Background()
+
+	reg := prometheus.NewPedanticRegistry()
+	proc := NewProcessor(memStore,
+		WithMiddleware(MetricsMiddleware(reg), LoggingMiddleware(slog.New(slog.NewTextHandler(io.Discard, nil)))),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	proc.Process(ctx, []byte(middlewareMessage))
+	proc.Process(ctx, []byte(`{not json`))
+
+	expected := `
+# HELP middleware_messages_total Scan messages passed through the processor middleware, by result.
+# TYPE middleware_messages_total counter
+middleware_messages_total{result="error"} 1
+middleware_messages_total{result="success"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "middleware_messages_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(reg, "middleware_message_duration_seconds"); n != 1 {
+		t.Errorf("Expected duration histogram, got %d series", n)
+	}
+}
+
+// TestLoggingMiddleware tests that each message is logged with its error
+func TestLoggingMiddleware(t *testing.T) {
+	handler := &captureHandler{}
+	proc := NewProcessor(store.NewMemoryStore(),
+		WithMiddleware(LoggingMiddleware(slog.New(handler))),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	proc.Process(context.Background(), []byte(`{not json`))
+
+	r := handler.last()
+	attrs := make(map[string]slog.Value)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value
+		return true
+	})
+	if r.Level != slog.LevelDebug || r.Message != "processed message" {
+		t.Errorf("Expected debug 'processed message', got %s %q", r.Level, r.Message)
+	}
+	if attrs["size"].Int64() != 9 || attrs["error"].Any() == nil {
+		t.Errorf("Unexpected attributes: %v", attrs)
+	}
+}
+
+// TestRateLimitMiddleware tests that messages over the burst wait for the
+// limiter and fail if their context ends first
+func TestRateLimitMiddleware(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore, WithMiddleware(RateLimitMiddleware(rate.Every(time.Hour), 1)))
+	if err := proc.Process(context.Background(), []byte(middlewareMessage)); err != nil {
+		t.Fatalf("Expected first message within burst, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := proc.Process(ctx, []byte(middlewareMessage)); err == nil {
+		t.Errorf("Expected rate limited message to fail")
+	}
+}
@@ -0,0 +1,265 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATS consumer modes
+const (
+	// NATSModePull fetches messages in batches of up to MaxAckPending
+	NATSModePull = "pull"
+	// NATSModePush has the server deliver messages to an inbox subject
+	NATSModePush = "push"
+)
+
+// natsNakDelay is how long JetStream waits before redelivering a message
+// that failed to process
+const natsNakDelay = 5 * time.Second
+
+// NATSConfig holds everything needed to build a NATSConsumer
+type NATSConfig struct {
+	// URL of the NATS server; required
+	URL string
+	// Stream holding the scan messages; required
+	Stream string
+	// Consumer is the durable consumer name, created on the stream if it
+	// does not exist; required
+	Consumer string
+	// MaxAckPending caps messages delivered but not yet acknowledged; zero
+	// keeps the server default
+	MaxAckPending int
+	// Mode is NATSModePull (the default) or NATSModePush
+	Mode string
+	// DrainTimeout bounds how long Close waits for in-flight messages
+	DrainTimeout time.Duration
+}
+
+// NATSConfigFromEnv loads NATS JetStream consumer settings from the
+// environment
+//
+//	NATS_URL (required)
+//	NATS_STREAM (required)
+//	NATS_CONSUMER (required)
+//	NATS_MAX_ACK_PENDING
+//	NATS_MODE
+//	CONSUMER_DRAIN_TIMEOUT
+func NATSConfigFromEnv() (*NATSConfig, error) {
+	cfg := &NATSConfig{
+		URL:          os.Getenv("NATS_URL"),
+		Stream:       os.Getenv("NATS_STREAM"),
+		Consumer:     os.Getenv("NATS_CONSUMER"),
+		Mode:         os.Getenv("NATS_MODE"),
+		DrainTimeout: defaultDrainTimeout,
+	}
+	if err := intFromEnv("NATS_MAX_ACK_PENDING", &cfg.MaxAckPending); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("CONSUMER_DRAIN_TIMEOUT", &cfg.DrainTimeout); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every missing or invalid field
+func (cfg *NATSConfig) Validate() error {
+	var problems []string
+	if cfg.URL == "" {
+		problems = append(problems, "URL is required (set NATS_URL)")
+	}
+	if cfg.Stream == "" {
+		problems = append(problems, "stream is required (set NATS_STREAM)")
+	}
+	if cfg.Consumer == "" {
+		problems = append(problems, "consumer is required (set NATS_CONSUMER)")
+	}
+	if cfg.MaxAckPending < 0 {
+		problems = append(problems, fmt.Sprintf("max ack pending must not be negative, got %d", cfg.MaxAckPending))
+	}
+	switch cfg.Mode {
+	case "", NATSModePull, NATSModePush:
+	default:
+		problems = append(problems, fmt.Sprintf("mode must be %q or %q, got %q", NATSModePull, NATSModePush, cfg.Mode))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid nats config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// NATSConsumer consumes scan messages from a NATS JetStream durable consumer
+// Messages carry the same JSON envelope as Pub/Sub messages; each is acked
+// once stored and NAKed with natsNakDelay when processing fails, so
+// JetStream redelivers it (at-least-once semantics)
+type NATSConsumer struct {
+	conn      *nats.Conn
+	processor *Processor
+	logger    *slog.Logger
+	cfg       NATSConfig
+
+	// consume starts delivery from the pull or push consumer
+	consume func(jetstream.MessageHandler) (jetstream.ConsumeContext, error)
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc // stops consuming, set while Start is running
+	stopped chan struct{}      // closed once Start has returned
+	closed  bool
+}
+
+// NewNATSConsumer connects to the server in cfg and creates or updates the
+// durable consumer on the stream
+// The consumer logs with the processor's logger
+func NewNATSConsumer(ctx context.Context, cfg *NATSConfig, proc *Processor) (*NATSConsumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	conn, err := nats.Connect(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create jetstream context: %w", err)
+	}
+
+	c := &NATSConsumer{
+		conn:      conn,
+		processor: proc,
+		logger:    proc.logger,
+		cfg:       *cfg,
+	}
+	if c.cfg.Mode == "" {
+		c.cfg.Mode = NATSModePull
+	}
+	if c.cfg.DrainTimeout <= 0 {
+		c.cfg.DrainTimeout = defaultDrainTimeout
+	}
+
+	consumerCfg := jetstream.ConsumerConfig{
+		Durable:       cfg.Consumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxAckPending: cfg.MaxAckPending,
+	}
+	onErr := jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		c.logger.Error("jetstream consume error", slog.String("consumer", cfg.Consumer), slog.Any("error", err))
+	})
+	if c.cfg.Mode == NATSModePush {
+		consumerCfg.DeliverSubject = conn.NewRespInbox()
+		cons, err := js.CreateOrUpdatePushConsumer(ctx, cfg.Stream, consumerCfg)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create push consumer: %w", err)
+		}
+		c.consume = func(h jetstream.MessageHandler) (jetstream.ConsumeContext, error) {
+			return cons.Consume(h, onErr)
+		}
+	} else {
+		cons, err := js.CreateOrUpdateConsumer(ctx, cfg.Stream, consumerCfg)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create pull consumer: %w", err)
+		}
+		opts := []jetstream.PullConsumeOpt{onErr}
+		if cfg.MaxAckPending > 0 {
+			opts = append(opts, jetstream.PullMaxMessages(cfg.MaxAckPending))
+		}
+		c.consume = func(h jetstream.MessageHandler) (jetstream.ConsumeContext, error) {
+			return cons.Consume(h, opts...)
+		}
+	}
+
+	return c, nil
+}
+
+// Start consumes messages from the durable consumer
+// This method blocks until the context is cancelled or Close is called
+func (c *NATSConsumer) Start(ctx context.Context) error {
+	c.logger.Info("starting to consume messages",
+		slog.String("stream", c.cfg.Stream),
+		slog.String("consumer", c.cfg.Consumer),
+		slog.String("mode", c.cfg.Mode),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	c.mu.Lock()
+	c.cancel = cancel
+	c.stopped = stopped
+	c.mu.Unlock()
+
+	// Let a message that is already being processed complete after
+	// shutdown starts rather than failing on a cancelled context
+	procCtx := context.WithoutCancel(ctx)
+	cc, err := c.consume(func(msg jetstream.Msg) {
+		c.handle(procCtx, msg)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start consuming: %w", err)
+	}
+
+	<-ctx.Done()
+	// Drain delivers messages already buffered to the handler before the
+	// subscription closes
+	cc.Drain()
+	<-cc.Closed()
+	return nil
+}
+
+// handle processes a single message and acknowledges it
+func (c *NATSConsumer) handle(ctx context.Context, msg jetstream.Msg) {
+	// Process logs failures itself
+	if err := c.processor.Process(ctx, msg.Data()); err != nil {
+		if err := msg.NakWithDelay(natsNakDelay); err != nil {
+			c.logger.Warn("failed to nak message", slog.Any("error", err))
+		}
+		return
+	}
+	// ACK only after successful processing (at-least-once semantics)
+	if err := msg.Ack(); err != nil {
+		c.logger.Warn("failed to ack message", slog.Any("error", err))
+	}
+}
+
+// Close stops consuming, waits up to the drain timeout for in-flight
+// messages and then closes the connection
+// Messages still processing when the timeout expires are redelivered once
+// their ack wait passes
+func (c *NATSConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.stopped != nil {
+		select {
+		case <-c.stopped:
+		case <-time.After(c.cfg.DrainTimeout):
+			c.logger.Warn("drain timeout expired, abandoning in-flight messages",
+				slog.Duration("timeout", c.cfg.DrainTimeout))
+		}
+	}
+
+	c.conn.Close()
+	return nil
+}
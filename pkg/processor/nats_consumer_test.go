@@ -0,0 +1,176 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsTestURL is the address of the JetStream server started by TestMain,
+// empty when nats-server is not installed
+var natsTestURL string
+
+// TestMain starts a nats-server with JetStream for the NATS consumer tests
+// when the binary is on the PATH
+func TestMain(m *testing.M) {
+	stop := startNATSServer()
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startNATSServer runs nats-server on a free port and sets natsTestURL once
+// it accepts connections, returning a function that stops it
+func startNATSServer() func() {
+	bin, err := exec.LookPath("nats-server")
+	if err != nil {
+		return func() {}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return func() {}
+	}
+	addr := l.Addr().String()
+	l.Close()
+	dir, err := os.MkdirTemp("", "nats-test")
+	if err != nil {
+		return func() {}
+	}
+
+	_, port, _ := net.SplitHostPort(addr)
+	cmd := exec.Command(bin, "-js", "-a", "127.0.0.1", "-p", port, "-sd", dir)
+	stop := func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		os.RemoveAll(dir)
+	}
+	if err := cmd.Start(); err != nil {
+		stop()
+		return func() {}
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			natsTestURL = "nats://" + addr
+			break
+		}
+	}
+	return stop
+}
+
+// TestNATSConfigFromEnv tests defaults, overrides and validation
+func TestNATSConfigFromEnv(t *testing.T) {
+	t.Setenv("NATS_URL", "nats://localhost:4222")
+	t.Setenv("NATS_STREAM", "SCANS")
+	t.Setenv("NATS_CONSUMER", "processor")
+
+	cfg, err := NATSConfigFromEnv()
+	if err != nil {
+		t.Fatalf("NATSConfigFromEnv failed: %v", err)
+	}
+	want := NATSConfig{URL: "nats://localhost:4222", Stream: "SCANS", Consumer: "processor", DrainTimeout: 30 * time.Second}
+	if *cfg != want {
+		t.Errorf("Expected %+v, got %+v", want, *cfg)
+	}
+
+	t.Setenv("NATS_MODE", NATSModePush)
+	t.Setenv("NATS_MAX_ACK_PENDING", "100")
+	cfg, err = NATSConfigFromEnv()
+	if err != nil {
+		t.Fatalf("NATSConfigFromEnv failed: %v", err)
+	}
+	if cfg.Mode != NATSModePush || cfg.MaxAckPending != 100 {
+		t.Errorf("Expected push mode with 100 pending, got %+v", cfg)
+	}
+
+	err = (&NATSConfig{Mode: "poll", MaxAckPending: -1}).Validate()
+	if err == nil {
+		t.Fatal("Expected error for empty config")
+	}
+	for _, want := range []string{"NATS_URL", "NATS_STREAM", "NATS_CONSUMER", "negative", `"poll"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+// TestNATSConsumerEndToEnd publishes 10 scans to a JetStream stream and
+// checks that Start stores every one of them, in both consumer modes
+func TestNATSConsumerEndToEnd(t *testing.T) {
+	if natsTestURL == "" {
+		t.Skip("nats-server not installed")
+	}
+
+	for _, mode := range []string{NATSModePull, NATSModePush} {
+		t.Run(mode, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			nc, err := nats.Connect(natsTestURL)
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer nc.Close()
+			js, err := jetstream.New(nc)
+			if err != nil {
+				t.Fatalf("Failed to create jetstream context: %v", err)
+			}
+			stream := "SCANS_" + strings.ToUpper(mode)
+			subject := "scans." + mode
+			if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{Name: stream, Subjects: []string{subject}}); err != nil {
+				t.Fatalf("Failed to create stream: %v", err)
+			}
+			for i := 0; i < 10; i++ {
+				data := scanMessage(fmt.Sprintf("10.0.0.%d", i), scanning.V2, scanning.V2Data{ResponseStr: "ok"})
+				if _, err := js.Publish(ctx, subject, data); err != nil {
+					t.Fatalf("Failed to publish message %d: %v", i, err)
+				}
+			}
+
+			memStore := store.NewMemoryStore()
+			defer memStore.Close()
+			proc := NewProcessor(memStore, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+			consumer, err := NewNATSConsumer(ctx, &NATSConfig{
+				URL:           natsTestURL,
+				Stream:        stream,
+				Consumer:      "processor",
+				MaxAckPending: 5,
+				Mode:          mode,
+			}, proc)
+			if err != nil {
+				t.Fatalf("Failed to create consumer: %v", err)
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- consumer.Start(ctx) }()
+
+			deadline := time.Now().Add(10 * time.Second)
+			for memStore.Len() < 10 && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if err := consumer.Close(); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Errorf("Start failed: %v", err)
+			}
+			if memStore.Len() != 10 {
+				t.Errorf("Expected 10 records stored, got %d", memStore.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package processor
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a Processor
+type Option interface {
+	applyProcessor(*Processor)
+}
+
+// ConsumerOption configures a Consumer
+type ConsumerOption interface {
+	applyConsumer(*Consumer)
+}
+
+// processorOption adapts a function to Option
+type processorOption func(*Processor)
+
+func (f processorOption) applyProcessor(p *Processor) { f(p) }
+
+// consumerOption adapts a function to ConsumerOption
+type consumerOption func(*Consumer)
+
+func (f consumerOption) applyConsumer(c *Consumer) { f(c) }
+
+// LoggerOption sets the logger and is accepted by both NewProcessor and
+// NewConsumer
+type LoggerOption struct {
+	logger *slog.Logger
+}
+
+func (o LoggerOption) applyProcessor(p *Processor) { p.logger = o.logger }
+func (o LoggerOption) applyConsumer(c *Consumer)   { c.logger = o.logger }
+
+// WithLogger sets the structured logger (default slog.Default())
+func WithLogger(l *slog.Logger) LoggerOption {
+	return LoggerOption{logger: l}
+}
+
+// WithMetrics registers the processor's Prometheus metrics with reg
+// Without this option metrics are not recorded
+func WithMetrics(reg prometheus.Registerer) Option {
+	return processorOption(func(p *Processor) {
+		p.metrics = newProcessorMetrics(reg, p.store)
+	})
+}
+
+// WithChangeHandler calls f after an update replaces a different response
+// old only differs from new in its Response, as stores keep just the
+// previous response
+func WithChangeHandler(f func(old, new *store.ServiceRecord)) Option {
+	return processorOption(func(p *Processor) {
+		p.onChange = f
+	})
+}
+
+// WithServiceNormalizer sets how service names are normalized before
+// validation (default UpperCaseNormalizer)
+func WithServiceNormalizer(n ServiceNormalizer) Option {
+	return processorOption(func(p *Processor) {
+		p.normalizer = n
+	})
+}
+
+// WithResponseNormalizer sets how decoded responses are rewritten before
+// they are stored (default NopNormalizer)
+func WithResponseNormalizer(n ResponseNormalizer) Option {
+	return processorOption(func(p *Processor) {
+		p.responseNormalizer = n
+	})
+}
+
+// WithErrorStore records every error returned by Process in es, keyed by
+// the message's IP, port and service
+func WithErrorStore(es *store.ErrorTrackingStore) Option {
+	return processorOption(func(p *Processor) {
+		p.errorStore = es
+	})
+}
+
+// WithServiceAllowlist makes Process reject scans whose normalized service
+// is not one of services, compared case-insensitively, with
+// ErrServiceNotAllowed
+// An empty list allows every service
+func WithServiceAllowlist(services []string) Option {
+	return processorOption(func(p *Processor) {
+		p.allowlist = newServiceAllowlist(services)
+	})
+}
+
+// WithMaxResponseBytes truncates responses longer than n bytes and marks
+// the record as ResponseTruncated (default 64 KB, n <= 0 disables)
+func WithMaxResponseBytes(n int) Option {
+	return processorOption(func(p *Processor) {
+		p.maxResponseBytes = n
+	})
+}
+
+// WithBatchWorkers sets how many goroutines parse messages in ProcessBatch
+// (default GOMAXPROCS, n < 1 parses on a single goroutine)
+func WithBatchWorkers(n int) Option {
+	return processorOption(func(p *Processor) {
+		p.batchWorkers = max(n, 1)
+	})
+}
+
+// WithMiddleware adds middleware around Process
+// The first middleware given is the outermost, so it sees each message first
+// and its result last
+func WithMiddleware(mw ...ProcessorMiddleware) Option {
+	return processorOption(func(p *Processor) {
+		p.middleware = append(p.middleware, mw...)
+	})
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider
+// (default otel.GetTracerProvider())
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return processorOption(func(p *Processor) {
+		p.tracer = tp.Tracer(tracerName)
+	})
+}
+
+// WithConcurrency processes messages on a pool of n workers fed by a
+// bounded queue, applying back-pressure to Receive when all are busy
+// By default messages are processed directly in the Receive callback
+func WithConcurrency(n int) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.concurrency = n
+	})
+}
+
+// WithDrainTimeout sets how long Close waits for in-flight messages
+// (default 30s)
+func WithDrainTimeout(d time.Duration) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.drainTimeout = d
+	})
+}
+
+// WithDeadLetterTopic publishes messages that fail WithMaxDeliveries times
+// to topicID with an X-Error attribute, then ACKs the original
+// Without this option failing messages are NACKed indefinitely
+func WithDeadLetterTopic(topicID string) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.deadLetterTopicID = topicID
+	})
+}
+
+// WithMaxDeliveries sets how many failed attempts a message gets before it
+// is dead-lettered (default 5)
+func WithMaxDeliveries(n int) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.maxDeliveries = n
+	})
+}
+
+// WithRetainAckedMessages updates the subscription to keep acknowledged
+// messages for d, so ReplayFromTimestamp can redeliver messages that were
+// already processed
+// Pub/Sub accepts retention between 10 minutes and 7 days
+func WithRetainAckedMessages(d time.Duration) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.retainAcked = d
+	})
+}
+
+// WithDeduplication ACKs redelivered messages without processing them when
+// their ID was processed within window, remembering at most capacity IDs
+// Skipped messages are counted by duplicates_skipped_total
+func WithDeduplication(window time.Duration, capacity int) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.dedup = newDedupWindow(window, capacity)
+	})
+}
+
+// WithIPRateLimit delays messages so each scanned IP is processed at most
+// rps times per second, with bursts of up to burst
+// Messages are held rather than dropped; delayed messages are counted by
+// rate_limited_messages_total, and Close interrupts the wait and NACKs them
+// An rps of 0 or less disables the limit and a burst below 1 is raised to 1
+// An IP's limiter is dropped after 5 minutes without messages
+func WithIPRateLimit(rps float64, burst int) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		if !(rps > 0) {
+			c.ipRateLimit = nil
+			return
+		}
+		c.ipRateLimit = newIPRateLimiter(rps, burst)
+	})
+}
+
+// WithConsumerConfig replaces all flow control settings with those in cfg;
+// its other fields are ignored, use NewConsumerFromConfig to apply them
+func WithConsumerConfig(cfg ConsumerConfig) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.flow = cfg
+	})
+}
+
+// WithMaxOutstandingMessages caps unacknowledged messages held by the
+// subscriber (default 1000)
+func WithMaxOutstandingMessages(n int) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.flow.MaxOutstandingMessages = n
+	})
+}
+
+// WithMaxOutstandingBytes caps the size of unacknowledged messages held by
+// the subscriber (default 500 MB)
+func WithMaxOutstandingBytes(n int64) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.flow.MaxOutstandingBytes = n
+	})
+}
+
+// WithNumGoroutines sets how many goroutines pull from the subscription
+// (default GOMAXPROCS)
+func WithNumGoroutines(n int) ConsumerOption {
+	return consumerOption(func(c *Consumer) {
+		c.flow.NumGoroutines = n
+	})
+}
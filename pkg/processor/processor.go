@@ -3,12 +3,33 @@ package processor
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/censys/scan-takehome/pkg/scanning"
 	"github.com/censys/scan-takehome/pkg/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans created by this package
+const tracerName = "github.com/censys/scan-takehome/pkg/processor"
+
+const (
+	// defaultMaxResponseBytes bounds stored responses unless
+	// WithMaxResponseBytes is given
+	defaultMaxResponseBytes = 64 << 10
+	// truncatedSuffix marks a response cut to the maximum size
+	truncatedSuffix = "...[TRUNCATED]"
 )
 
 // rawScan is used for JSON unmarshalling with json.RawMessage for the Data field
@@ -21,104 +42,648 @@ type rawScan struct {
 	Data        json.RawMessage `json:"data"`
 }
 
+// scanResult holds the fields extracted from a scan's versioned data
+type scanResult struct {
+	Response          string
+	ResponseHash      string
+	ResponseTruncated bool
+	TLSVersion        string
+	StatusCode        int
+}
+
 // Processor handles scan message processing
 type Processor struct {
-	store store.Store
+	store   store.Store
+	now     func() time.Time  // clock used for timestamp validation
+	metrics *ProcessorMetrics // nil unless WithMetrics is given
+	logger  *slog.Logger
+	tracer  trace.Tracer
+
+	// onChange is called when an update replaces a different response
+	onChange func(old, new *store.ServiceRecord)
+	// enrichers run in order on every updated record
+	enrichers []Enricher
+	// filters drop scans before their data is decoded
+	filters []MessageFilter
+
+	normalizer         ServiceNormalizer
+	responseNormalizer ResponseNormalizer
+	allowlist          serviceAllowlist // nil allows every service
+	registry           *MessageTypeRegistry
+
+	maxResponseBytes int // longer responses are truncated, 0 disables
+
+	batchWorkers int // goroutines parsing messages in ProcessBatch
+
+	middleware []ProcessorMiddleware
+	handler    ProcessFunc // processInternal wrapped in middleware
+
+	errorStore *store.ErrorTrackingStore // nil unless WithErrorStore is given
 }
 
 // NewProcessor creates a new processor with the given store
-func NewProcessor(s store.Store) *Processor {
-	return &Processor{store: s}
+func NewProcessor(s store.Store, opts ...Option) *Processor {
+	p := &Processor{
+		store:              s,
+		now:                time.Now,
+		logger:             slog.Default(),
+		tracer:             otel.GetTracerProvider().Tracer(tracerName),
+		normalizer:         UpperCaseNormalizer{},
+		responseNormalizer: NopNormalizer{},
+		registry:           NewMessageTypeRegistry(),
+		maxResponseBytes:   defaultMaxResponseBytes,
+		batchWorkers:       runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt.applyProcessor(p)
+	}
+	p.handler = chainMiddleware(p.middleware, p.processInternal)
+	return p
+}
+
+// RegisterHandler sets the handler used for scans with the given data
+// version, so new formats can be added without changing parseScan
+func (p *Processor) RegisterHandler(version int, h DataVersionHandler) {
+	p.registry.RegisterHandler(version, h)
 }
 
-// Process processes a single scan message
+// Process processes a single scan message, passing it through the
+// WithMiddleware chain first
+// Invalid envelopes are reported as a *ValidationError, services outside
+// the allowlist as ErrServiceNotAllowed and data versions without a handler
+// as ErrUnknownVersion
+// Failures are recorded with WithErrorStore's store when one is set
 func (p *Processor) Process(ctx context.Context, data []byte) error {
-	// Parse the scan message
-	scan, response, err := p.parseScan(data)
+	err := p.handler(ctx, data)
+	if err != nil && p.errorStore != nil {
+		p.recordError(ctx, data, err)
+	}
+	return err
+}
+
+// processInternal is the ProcessFunc at the end of the middleware chain
+func (p *Processor) processInternal(ctx context.Context, data []byte) error {
+	ctx, span := p.tracer.Start(ctx, "Processor.Process")
+	defer span.End()
+
+	start := time.Now()
+	updated, err := p.process(ctx, data)
+
+	result := resultSkipped
+	switch {
+	case err != nil:
+		result = resultError
+	case updated:
+		result = resultUpdated
+	}
+	p.metrics.observeProcessed(result, time.Since(start))
+
 	if err != nil {
-		return fmt.Errorf("failed to parse scan: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		p.logFailure(err)
 	}
 
-	// Create service record
-	record := &store.ServiceRecord{
-		IP:            scan.Ip,
-		Port:          scan.Port,
-		Service:       scan.Service,
-		LastTimestamp: scan.Timestamp,
-		Response:      response,
+	return err
+}
+
+// logFailure logs a message that could not be processed, as a warning if
+// the message itself was rejected
+func (p *Processor) logFailure(err error) {
+	var verr *ValidationError
+	if errors.As(err, &verr) || errors.Is(err, ErrServiceNotAllowed) {
+		p.logger.Warn("rejected invalid message", slog.Any("error", err))
+	} else {
+		p.logger.Error("failed to process message", slog.Any("error", err))
 	}
+}
+
+// isPermanent reports whether err means the message itself is unusable, so
+// processing it again would fail the same way
+func isPermanent(err error) bool {
+	var verr *ValidationError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &verr) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) ||
+		errors.Is(err, ErrServiceNotAllowed) || errors.Is(err, ErrUnknownVersion)
+}
 
+// recordError records err against the key of the message it failed on
+// Messages without an IP cannot be attributed to a host and are not
+// recorded; multi-scan messages are recorded against their IP alone
+func (p *Processor) recordError(ctx context.Context, data []byte, err error) {
+	// Type errors still decode the other fields, so the result is ignored
+	var key struct {
+		IP      string `json:"ip"`
+		Port    uint32 `json:"port"`
+		Service string `json:"service"`
+	}
+	_ = json.Unmarshal(data, &key)
+	if key.IP == "" {
+		return
+	}
+	if key.Service != "" {
+		key.Service = p.normalizer.Normalize(key.Service)
+	}
+	if rerr := p.errorStore.RecordError(ctx, key.IP, key.Port, key.Service, err); rerr != nil {
+		p.logger.Error("failed to record processing error", slog.Any("error", rerr))
+	}
+}
+
+// ProcessBatch processes several scan messages and returns one error per
+// message, nil where the message was stored or skipped as older
+// Messages are parsed on a pool of WithBatchWorkers goroutines and the valid
+// ones written with a single BulkUpsert, so a store failure is returned for
+// every valid message in the batch
+// Multi-scan messages add one record per service to the BulkUpsert
+// BulkUpsert does not report which records it updated, so change detection,
+// WithChangeHandler and WithEnrichers only apply to Process, as does
+// WithMiddleware
+func (p *Processor) ProcessBatch(ctx context.Context, messages [][]byte) []error {
+	ctx, span := p.tracer.Start(ctx, "Processor.ProcessBatch")
+	defer span.End()
+	span.SetAttributes(attribute.Int("batch_size", len(messages)))
+
+	start := time.Now()
+	errs := make([]error, len(messages))
+	records := make([][]*store.ServiceRecord, len(messages))
+
+	// Each worker only writes the indices it takes from next, so the
+	// results need no locking and stay in input order
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(p.batchWorkers, len(messages)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				records[i], errs[i] = p.parseRecords(ctx, messages[i])
+			}
+		}()
+	}
+	for i := range messages {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	valid := make([]*store.ServiceRecord, 0, len(records))
+	for _, r := range records {
+		valid = append(valid, r...)
+	}
+
+	updated := 0
+	if len(valid) > 0 {
+		upsertCtx, upsertSpan := p.tracer.Start(ctx, "store.BulkUpsert")
+		n, err := p.store.BulkUpsert(upsertCtx, valid)
+		if err != nil {
+			upsertSpan.RecordError(err)
+			upsertSpan.SetStatus(codes.Error, err.Error())
+			err = fmt.Errorf("failed to upsert records: %w", err)
+			for i, r := range records {
+				if len(r) > 0 {
+					errs[i] = err
+				}
+			}
+		}
+		upsertSpan.SetAttributes(attribute.Int("updated", n))
+		upsertSpan.End()
+		updated = n
+	}
+
+	// The batch is timed as a whole, so each message is recorded with the
+	// average duration
+	var avg time.Duration
+	if len(messages) > 0 {
+		avg = time.Since(start) / time.Duration(len(messages))
+	}
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+			p.logFailure(err)
+			p.metrics.observeProcessed(resultError, avg)
+		}
+	}
+	for i := 0; i < len(messages)-failed; i++ {
+		result := resultSkipped
+		if i < updated {
+			result = resultUpdated
+		}
+		p.metrics.observeProcessed(result, avg)
+	}
+	if failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d messages failed", failed, len(messages)))
+	}
+
+	p.logger.Info("processed batch",
+		slog.Int("messages", len(messages)),
+		slog.Int("updated", updated),
+		slog.Int("failed", failed),
+	)
+	return errs
+}
+
+// process parses and stores a scan message, reporting whether the store
+// was updated
+func (p *Processor) process(ctx context.Context, data []byte) (bool, error) {
+	dataType, err := messageDataType(data)
+	if err != nil {
+		return false, err
+	}
+	if dataType == scanning.DataTypeMulti {
+		return p.processMulti(ctx, data)
+	}
+
+	record, err := p.parseRecord(ctx, data)
+	if errors.Is(err, errFiltered) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	record.MessageMetadata = MessageMetadataFromContext(ctx)
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("ip", record.IP.String()),
+		attribute.Int("port", int(record.Port)),
+		attribute.String("service", record.Service),
+	)
+
+	return p.upsert(ctx, record)
+}
+
+// upsert stores a record, logging the outcome, and runs change detection
+// and the enrichers if it was updated
+func (p *Processor) upsert(ctx context.Context, record *store.ServiceRecord) (bool, error) {
 	// Upsert to store (handles out-of-order messages via timestamp comparison)
-	updated, err := p.store.Upsert(ctx, record)
+	upsertCtx, upsertSpan := p.tracer.Start(ctx, "store.Upsert")
+	updated, err := p.store.Upsert(upsertCtx, record)
 	if err != nil {
-		return fmt.Errorf("failed to upsert record: %w", err)
+		upsertSpan.RecordError(err)
+		upsertSpan.SetStatus(codes.Error, err.Error())
+		upsertSpan.End()
+		return false, fmt.Errorf("failed to upsert record: %w", err)
 	}
+	upsertSpan.SetAttributes(attribute.Bool("updated", updated))
+	upsertSpan.End()
 
+	attrs := []any{
+		slog.String("ip", record.IP.String()),
+		slog.Int("port", int(record.Port)),
+		slog.String("service", record.Service),
+		slog.Int64("timestamp", record.LastTimestamp),
+	}
 	if updated {
-		log.Printf("updated record: ip=%s port=%d service=%s timestamp=%d",
-			scan.Ip, scan.Port, scan.Service, scan.Timestamp)
+		p.logger.Info("updated record", attrs...)
+		p.detectChange(ctx, record.IP.String(), record.Port, record.Service)
+		p.enrich(ctx, record)
 	} else {
-		log.Printf("skipped older record: ip=%s port=%d service=%s timestamp=%d",
-			scan.Ip, scan.Port, scan.Service, scan.Timestamp)
+		p.logger.Info("skipped older record", attrs...)
 	}
 
-	return nil
+	return updated, nil
 }
 
-// parseScan parses a scan message and extracts the response string
-func (p *Processor) parseScan(data []byte) (*scanning.Scan, string, error) {
-	var raw rawScan
-	if err := json.Unmarshal(data, &raw); err != nil {
-		return nil, "", fmt.Errorf("failed to unmarshal scan: %w", err)
+// processMulti parses a multi-scan message and upserts each of its records,
+// reporting whether any record was updated
+// Records are upserted one at a time so change detection and enrichers run
+// on each one updated; a store failure stops the rest, and redelivery
+// skips those already stored
+func (p *Processor) processMulti(ctx context.Context, data []byte) (bool, error) {
+	records, err := p.parseMultiScan(ctx, data)
+	if err != nil {
+		return false, err
+	}
+	if len(records) == 0 {
+		// Every service was dropped by a filter
+		return false, nil
+	}
+	metadata := MessageMetadataFromContext(ctx)
+	for _, r := range records {
+		r.MessageMetadata = metadata
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("ip", records[0].IP.String()),
+		attribute.Int("services", len(records)),
+	)
+
+	updated := 0
+	for _, r := range records {
+		ok, err := p.upsert(ctx, r)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			updated++
+		}
+	}
+
+	p.logger.Info("processed multi-scan",
+		slog.String("ip", records[0].IP.String()),
+		slog.Int("services", len(records)),
+		slog.Int("updated", updated),
+		slog.Int64("timestamp", records[0].LastTimestamp),
+	)
+
+	return updated > 0, nil
+}
+
+// messageDataType returns the data_type of a message envelope
+// Unknown data types are reported as a *ValidationError; malformed JSON is
+// left for the parser of a single scan to report
+func messageDataType(data []byte) (string, error) {
+	var envelope struct {
+		DataType string `json:"data_type"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return "", nil
 	}
+	switch envelope.DataType {
+	case "", scanning.DataTypeMulti:
+		return envelope.DataType, nil
+	}
+	verr := &ValidationError{}
+	verr.add("data_type", "%q is not a known data type", envelope.DataType)
+	return "", verr
+}
+
+// parseRecords parses a scan or multi-scan message into the records to store
+func (p *Processor) parseRecords(ctx context.Context, data []byte) ([]*store.ServiceRecord, error) {
+	dataType, err := messageDataType(data)
+	if err != nil {
+		return nil, err
+	}
+	if dataType == scanning.DataTypeMulti {
+		return p.parseMultiScan(ctx, data)
+	}
+	record, err := p.parseRecord(ctx, data)
+	if errors.Is(err, errFiltered) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []*store.ServiceRecord{record}, nil
+}
 
-	var response string
-	switch raw.DataVersion {
-	case scanning.V1:
-		var v1 scanning.V1Data
-		if err := json.Unmarshal(raw.Data, &v1); err != nil {
-			return nil, "", fmt.Errorf("failed to unmarshal V1 data: %w", err)
+// parseMultiScan parses a multi-scan message into one record per service
+// Each service is validated and decoded like a single scan, and the whole
+// message is rejected if any service is invalid
+// Services dropped by a filter are left out
+func (p *Processor) parseMultiScan(ctx context.Context, data []byte) ([]*store.ServiceRecord, error) {
+	var multi scanning.MultiScan
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal multi-scan: %w", err)
+	}
+	if len(multi.Services) == 0 {
+		verr := &ValidationError{}
+		verr.add("services", "must not be empty")
+		return nil, verr
+	}
+
+	records := make([]*store.ServiceRecord, 0, len(multi.Services))
+	for i, entry := range multi.Services {
+		raw := &rawScan{
+			IP:          multi.IP,
+			Port:        entry.Port,
+			Service:     entry.Service,
+			Timestamp:   multi.Timestamp,
+			DataVersion: entry.DataVersion,
+			Data:        entry.Data,
+		}
+		scan, result, err := p.parseRawScan(ctx, raw)
+		if errors.Is(err, errFiltered) {
+			continue
 		}
-		// Go's json.Unmarshal automatically decodes base64 into []byte
-		response = string(v1.ResponseBytesUtf8)
+		if err != nil {
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				return nil, verr.prefixed(fmt.Sprintf("services[%d].", i))
+			}
+			return nil, fmt.Errorf("failed to parse multi-scan service %d: %w", i, err)
+		}
+		record, err := p.newRecord(scan, result)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
 
-	case scanning.V2:
-		var v2 scanning.V2Data
-		if err := json.Unmarshal(raw.Data, &v2); err != nil {
-			return nil, "", fmt.Errorf("failed to unmarshal V2 data: %w", err)
+// parseRecord parses a scan message into the record to store
+func (p *Processor) parseRecord(ctx context.Context, data []byte) (*store.ServiceRecord, error) {
+	scan, result, err := p.parseScan(ctx, data)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			return nil, verr
 		}
-		response = v2.ResponseStr
+		return nil, fmt.Errorf("failed to parse scan: %w", err)
+	}
+	return p.newRecord(scan, result)
+}
 
-	default:
-		return nil, "", fmt.Errorf("unknown data version: %d", raw.DataVersion)
+// newRecord builds the record to store for a parsed scan, rejecting
+// services outside the allowlist
+func (p *Processor) newRecord(scan *scanning.Scan, result *scanResult) (*store.ServiceRecord, error) {
+	if !p.allowlist.allows(scan.Service) {
+		return nil, fmt.Errorf("%w: %q", ErrServiceNotAllowed, scan.Service)
 	}
 
+	return &store.ServiceRecord{
+		IP:                store.IPAddress(scan.Ip),
+		Port:              scan.Port,
+		Service:           scan.Service,
+		LastTimestamp:     scan.Timestamp,
+		Response:          result.Response,
+		ResponseHash:      result.ResponseHash,
+		ResponseTruncated: result.ResponseTruncated,
+		TLSVersion:        result.TLSVersion,
+		StatusCode:        result.StatusCode,
+	}, nil
+}
+
+// detectChange reads back an updated record and reports a changed response
+// The store fills in PreviousResponse atomically with the update, so the
+// record is re-read rather than compared with a copy fetched beforehand
+func (p *Processor) detectChange(ctx context.Context, ip string, port uint32, service string) {
+	record, err := p.store.Get(ctx, ip, port, service)
+	if err != nil {
+		// The update itself succeeded, so this does not fail the message
+		p.logger.Warn("failed to read back updated record",
+			slog.String("ip", ip),
+			slog.Int("port", int(port)),
+			slog.String("service", service),
+			slog.Any("error", err),
+		)
+		return
+	}
+	// Soft-deleted records are updated but not returned
+	if record == nil || !record.ResponseChanged() {
+		return
+	}
+
+	p.logger.Warn("response changed",
+		slog.String("ip", ip),
+		slog.Int("port", int(port)),
+		slog.String("service", service),
+		slog.String("old_response", record.PreviousResponse),
+		slog.String("new_response", record.Response),
+	)
+
+	if p.onChange != nil {
+		old := *record
+		old.Response = record.PreviousResponse
+		old.PreviousResponse = ""
+		p.onChange(&old, record)
+	}
+}
+
+// parseScan parses a scan message and extracts the response fields
+func (p *Processor) parseScan(ctx context.Context, data []byte) (*scanning.Scan, *scanResult, error) {
+	var raw rawScan
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal scan: %w", err)
+	}
+	return p.parseRawScan(ctx, &raw)
+}
+
+// parseRawScan validates an unmarshaled scan and extracts the response
+// fields
+// Scans a filter drops are reported as errFiltered before their data is
+// decoded
+func (p *Processor) parseRawScan(ctx context.Context, raw *rawScan) (*scanning.Scan, *scanResult, error) {
+	// Normalize before validation so accepted variants such as "http" pass
+	// the service name rules
+	raw.Service = p.normalizer.Normalize(raw.Service)
+	if err := validateScan(raw, p.now()); err != nil {
+		return nil, nil, err
+	}
+	ip, err := normalizeIP(raw.IP)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.shouldProcess(ctx, ip, raw.Port, raw.Service) {
+		return nil, nil, errFiltered
+	}
+
+	result, err := p.registry.decode(raw.DataVersion, raw.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Normalize before truncating and hashing so both see the stored form
+	result.Response = p.responseNormalizer.Normalize(raw.Service, result.Response)
+	if p.maxResponseBytes > 0 && len(result.Response) > p.maxResponseBytes {
+		p.logger.Warn("truncated oversized response",
+			slog.String("ip", ip),
+			slog.Int("port", int(raw.Port)),
+			slog.String("service", raw.Service),
+			slog.Int("size", len(result.Response)),
+			slog.Int("max_size", p.maxResponseBytes),
+		)
+		result.Response = truncateResponse(result.Response, p.maxResponseBytes)
+		result.ResponseTruncated = true
+	}
+	result.ResponseHash = store.HashResponse(result.Response)
+
 	scan := &scanning.Scan{
-		Ip:          raw.IP,
+		Ip:          ip,
 		Port:        raw.Port,
 		Service:     raw.Service,
 		Timestamp:   raw.Timestamp,
 		DataVersion: raw.DataVersion,
 	}
 
-	return scan, response, nil
+	return scan, result, nil
 }
 
+// truncateResponse cuts response to at most n bytes, backing off to a rune
+// boundary so valid UTF-8 stays valid, and appends truncatedSuffix
+func truncateResponse(response string, n int) string {
+	for n > 0 && !utf8.RuneStart(response[n]) {
+		n--
+	}
+	return response[:n] + truncatedSuffix
+}
+
+const (
+	// defaultDrainTimeout bounds how long Close waits for queued messages
+	defaultDrainTimeout = 30 * time.Second
+	// defaultMaxDeliveries is how many failed attempts a message gets
+	// before it is dead-lettered
+	defaultMaxDeliveries = 5
+	// errorAttribute carries the last processing error on dead-lettered messages
+	errorAttribute = "X-Error"
+)
+
 // Consumer handles Pub/Sub message consumption
 type Consumer struct {
 	client       *pubsub.Client
 	subscription *pubsub.Subscription
 	processor    *Processor
+	logger       *slog.Logger
+	concurrency  int            // worker pool size, 0 processes in the Receive callback
+	drainTimeout time.Duration  // how long Close waits for in-flight messages
+	flow         ConsumerConfig // only the flow control fields are used
+
+	deadLetterTopicID string
+	deadLetter        *pubsub.Topic // nil unless WithDeadLetterTopic is given
+	maxDeliveries     int
+	attempts          sync.Map // message ID -> failed attempts (int)
+
+	retainAcked time.Duration // acked message retention, 0 leaves it unchanged
+
+	dedup       *dedupWindow   // nil unless WithDeduplication is given
+	ipRateLimit *ipRateLimiter // nil unless WithIPRateLimit is given
+
+	mu        sync.Mutex
+	cancel    context.CancelFunc // stops Receive, set while Start is running
+	receiving chan struct{}      // closed once Receive has returned
+
+	// closing is cancelled when Close is called, interrupting waits that
+	// must not hold up the drain
+	closing     context.Context
+	stopClosing context.CancelFunc
+
+	// inflight tracks messages between delivery and ACK/NACK
+	// inflightCount mirrors it so Close can report what it abandons
+	inflight      sync.WaitGroup
+	inflightCount atomic.Int64
+}
+
+// NewConsumer creates a new Pub/Sub consumer with default settings
+// adjusted by opts
+// The consumer logs with the processor's logger unless WithLogger is given
+func NewConsumer(ctx context.Context, projectID, subscriptionID string, processor *Processor, opts ...ConsumerOption) (*Consumer, error) {
+	cfg := DefaultConsumerConfig()
+	cfg.ProjectID = projectID
+	cfg.SubscriptionID = subscriptionID
+	return newConsumer(ctx, &cfg, processor, opts)
+}
+
+// NewConsumerFromConfig creates a new Pub/Sub consumer from cfg, typically
+// the result of ConsumerConfigFromEnv
+// The consumer logs with the processor's logger
+func NewConsumerFromConfig(ctx context.Context, cfg *ConsumerConfig, proc *Processor) (*Consumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newConsumer(ctx, cfg, proc, nil)
 }
 
-// NewConsumer creates a new Pub/Sub consumer
-func NewConsumer(ctx context.Context, projectID, subscriptionID string, processor *Processor) (*Consumer, error) {
-	client, err := pubsub.NewClient(ctx, projectID)
+// newConsumer connects to the subscription in cfg and applies opts on top
+// of cfg's settings
+func newConsumer(ctx context.Context, cfg *ConsumerConfig, processor *Processor, opts []ConsumerOption) (*Consumer, error) {
+	client, err := pubsub.NewClient(ctx, cfg.ProjectID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create pubsub client: %w", err)
 	}
 
-	sub := client.Subscription(subscriptionID)
+	sub := client.Subscription(cfg.SubscriptionID)
 
 	// Check if subscription exists
 	exists, err := sub.Exists(ctx)
@@ -128,42 +693,311 @@ func NewConsumer(ctx context.Context, projectID, subscriptionID string, processo
 	}
 	if !exists {
 		client.Close()
-		return nil, fmt.Errorf("subscription %s does not exist", subscriptionID)
+		return nil, fmt.Errorf("subscription %s does not exist", cfg.SubscriptionID)
 	}
 
-	return &Consumer{
+	c := &Consumer{
 		client:       client,
 		subscription: sub,
 		processor:    processor,
-	}, nil
+		logger:       processor.logger,
+	}
+	c.applyConfig(*cfg)
+	for _, opt := range opts {
+		opt.applyConsumer(c)
+	}
+	if c.deadLetterTopicID != "" {
+		c.deadLetter = client.Topic(c.deadLetterTopicID)
+	}
+	if c.retainAcked > 0 {
+		_, err := sub.Update(ctx, pubsub.SubscriptionConfigToUpdate{
+			RetainAckedMessages: true,
+			RetentionDuration:   c.retainAcked,
+		})
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to enable acked message retention: %w", err)
+		}
+	}
+
+	c.closing, c.stopClosing = context.WithCancel(context.Background())
+	if c.ipRateLimit != nil {
+		go c.ipRateLimit.run(c.closing)
+	}
+	return c, nil
+}
+
+// applyConfig copies every setting in cfg except the subscription identity
+func (c *Consumer) applyConfig(cfg ConsumerConfig) {
+	c.concurrency = cfg.Concurrency
+	c.drainTimeout = cfg.DrainTimeout
+	c.deadLetterTopicID = cfg.DeadLetterTopicID
+	c.maxDeliveries = cfg.MaxDeliveries
+	c.retainAcked = cfg.RetainAckedMessages
+	c.flow = cfg
+}
+
+// ReplayFromTimestamp seeks the subscription back to t, so messages
+// published since then are delivered again by Start, including ones that
+// were already acknowledged if the subscription retains them (see
+// WithRetainAckedMessages)
+// Seeking forward instead acknowledges every message published before t
+// Message IDs remembered by WithDeduplication are forgotten so the replayed
+// messages are processed rather than skipped
+func (c *Consumer) ReplayFromTimestamp(ctx context.Context, t time.Time) error {
+	cfg, err := c.subscription.Config(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get subscription config: %w", err)
+	}
+	if !cfg.RetainAckedMessages {
+		c.logger.Warn("subscription does not retain acked messages, only unacknowledged messages will be replayed",
+			slog.String("subscription", c.subscription.ID()))
+	}
+
+	if err := c.subscription.SeekToTime(ctx, t); err != nil {
+		return fmt.Errorf("failed to seek subscription: %w", err)
+	}
+	if c.dedup != nil {
+		c.dedup.reset()
+	}
+	c.logger.Info("replaying messages",
+		slog.String("subscription", c.subscription.ID()),
+		slog.Time("from", t),
+	)
+	return nil
 }
 
 // Start starts consuming messages from the subscription
-// This method blocks until the context is cancelled
+// This method blocks until the context is cancelled or Close is called
 func (c *Consumer) Start(ctx context.Context) error {
-	log.Printf("starting to consume messages from subscription: %s", c.subscription.ID())
+	c.logger.Info("starting to consume messages",
+		slog.String("subscription", c.subscription.ID()),
+		slog.Int("concurrency", c.concurrency),
+		slog.Int("max_outstanding_messages", c.flow.MaxOutstandingMessages),
+		slog.Int64("max_outstanding_bytes", c.flow.MaxOutstandingBytes),
+		slog.Int("num_goroutines", c.flow.NumGoroutines),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	receiving := make(chan struct{})
+	defer close(receiving)
+	c.mu.Lock()
+	c.cancel = cancel
+	c.receiving = receiving
+	c.mu.Unlock()
+
+	c.subscription.ReceiveSettings.MaxOutstandingMessages = c.flow.MaxOutstandingMessages
+	c.subscription.ReceiveSettings.MaxOutstandingBytes = int(c.flow.MaxOutstandingBytes)
+	c.subscription.ReceiveSettings.NumGoroutines = c.flow.NumGoroutines
+
+	var err error
+	if c.concurrency > 0 {
+		err = c.receivePooled(ctx)
+	} else {
+		err = c.subscription.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+			c.processor.metrics.incReceived()
+			c.begin()
+			defer c.finish()
+
+			// Let a message that is already being processed complete after
+			// shutdown starts rather than failing on a cancelled context
+			c.handle(context.WithoutCancel(ctx), msg)
+		})
+	}
+
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("subscription receive error: %w", err)
+	}
+
+	return nil
+}
+
+// receivePooled hands messages from Receive to a fixed pool of workers
+// The Receive callback blocks while the queue is full, so the subscriber
+// stops pulling once every worker is busy
+func (c *Consumer) receivePooled(ctx context.Context) error {
+	queue := make(chan *pubsub.Message, c.concurrency)
+
+	// Workers outlive the receive context so queued messages can finish
+	// during the drain in Close
+	workerCtx := context.WithoutCancel(ctx)
+	for i := 0; i < c.concurrency; i++ {
+		go func() {
+			for msg := range queue {
+				c.handle(workerCtx, msg)
+				c.finish()
+			}
+		}()
+	}
 
 	err := c.subscription.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		// Process the message
-		if err := c.processor.Process(ctx, msg.Data); err != nil {
-			log.Printf("failed to process message: %v", err)
-			// NACK the message so it will be redelivered
+		c.processor.metrics.incReceived()
+		c.begin()
+
+		select {
+		case queue <- msg:
+		case <-ctx.Done():
+			// Shutting down - let the message be redelivered elsewhere
 			msg.Nack()
-			return
+			c.finish()
 		}
+	})
+
+	// Receive waits for its callbacks, so nothing else will be enqueued
+	close(queue)
+	return err
+}
 
-		// ACK only after successful processing (at-least-once semantics)
+// begin marks a message as in flight
+func (c *Consumer) begin() {
+	c.inflight.Add(1)
+	c.inflightCount.Add(1)
+}
+
+// finish marks an in-flight message as acknowledged
+func (c *Consumer) finish() {
+	c.inflightCount.Add(-1)
+	c.inflight.Done()
+}
+
+// handle processes a single message and acknowledges it
+func (c *Consumer) handle(ctx context.Context, msg *pubsub.Message) {
+	if c.dedup != nil && c.dedup.seen(msg.ID) {
+		c.processor.metrics.incDuplicate()
+		c.logger.Debug("skipped duplicate message", slog.String("message_id", msg.ID))
 		msg.Ack()
+		return
+	}
+
+	if c.ipRateLimit != nil {
+		if ip := messageIP(msg.Data); ip != "" {
+			// ctx outlives shutdown, so Close has to end the wait itself
+			waitCtx, cancel := context.WithCancel(ctx)
+			stop := context.AfterFunc(c.closing, cancel)
+			delayed, err := c.ipRateLimit.wait(waitCtx, ip)
+			stop()
+			cancel()
+			if delayed {
+				c.processor.metrics.incRateLimited()
+			}
+			if err != nil {
+				c.logger.Warn("rate limit wait failed", slog.String("ip", ip), slog.Any("error", err))
+				msg.Nack()
+				return
+			}
+		}
+	}
+
+	// Records remember the message that last updated them, for debugging
+	// dropped or duplicate records
+	ctx = WithMessageMetadata(ctx, &store.MessageMetadata{
+		MessageID:      msg.ID,
+		SubscriptionID: c.subscription.ID(),
+		PublishTime:    msg.PublishTime,
 	})
 
-	if err != nil && ctx.Err() == nil {
-		return fmt.Errorf("subscription receive error: %w", err)
+	// Process logs failures itself
+	if err := c.processor.Process(ctx, msg.Data); err != nil {
+		if c.deadLetter != nil && c.recordFailure(msg.ID) >= c.maxDeliveries {
+			c.publishDeadLetter(ctx, msg, err)
+			return
+		}
+		// NACK the message so it will be redelivered
+		msg.Nack()
+		return
 	}
 
-	return nil
+	c.attempts.Delete(msg.ID)
+	// Only successfully processed IDs are remembered, so a failed message
+	// is still retried when redelivered
+	if c.dedup != nil {
+		c.dedup.add(msg.ID)
+	}
+	// ACK only after successful processing (at-least-once semantics)
+	msg.Ack()
+}
+
+// recordFailure increments and returns the failed attempts for a message
+func (c *Consumer) recordFailure(id string) int {
+	for {
+		v, loaded := c.attempts.LoadOrStore(id, 1)
+		if !loaded {
+			return 1
+		}
+		n := v.(int)
+		if c.attempts.CompareAndSwap(id, n, n+1) {
+			return n + 1
+		}
+	}
 }
 
-// Close closes the Pub/Sub client
+// publishDeadLetter moves a message that keeps failing to the dead-letter
+// topic and ACKs the original
+// If publishing fails the message is NACKed so it is retried later
+func (c *Consumer) publishDeadLetter(ctx context.Context, msg *pubsub.Message, procErr error) {
+	attrs := make(map[string]string, len(msg.Attributes)+1)
+	for k, v := range msg.Attributes {
+		attrs[k] = v
+	}
+	attrs[errorAttribute] = procErr.Error()
+
+	result := c.deadLetter.Publish(ctx, &pubsub.Message{Data: msg.Data, Attributes: attrs})
+	if _, err := result.Get(ctx); err != nil {
+		c.logger.Error("failed to publish to dead-letter topic",
+			slog.String("message_id", msg.ID),
+			slog.String("topic", c.deadLetterTopicID),
+			slog.Any("error", err),
+		)
+		msg.Nack()
+		return
+	}
+
+	c.logger.Warn("dead-lettered message",
+		slog.String("message_id", msg.ID),
+		slog.String("topic", c.deadLetterTopicID),
+		slog.Any("error", procErr),
+	)
+	c.attempts.Delete(msg.ID)
+	msg.Ack()
+}
+
+// Close stops receiving, waits up to the drain timeout for in-flight
+// messages to be acknowledged and then closes the Pub/Sub client
+// Messages waiting on WithIPRateLimit are NACKed rather than drained
+// Messages still processing when the timeout expires are abandoned and will
+// be redelivered once their ack deadline passes
 func (c *Consumer) Close() error {
+	c.stopClosing()
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	receiving := c.receiving
+	c.mu.Unlock()
+
+	if receiving != nil {
+		done := make(chan struct{})
+		go func() {
+			// Receive must return before waiting so no new message can be
+			// added to the in-flight group during Wait
+			<-receiving
+			c.inflight.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(c.drainTimeout):
+			c.logger.Warn("drain timeout expired, abandoning in-flight messages",
+				slog.Duration("timeout", c.drainTimeout),
+				slog.Int64("in_flight", c.inflightCount.Load()),
+			)
+		}
+	}
+
+	if c.deadLetter != nil {
+		c.deadLetter.Stop()
+	}
 	return c.client.Close()
 }
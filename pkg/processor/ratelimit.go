@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// IP rate limiter settings for WithIPRateLimit
+const (
+	// ipIdleTimeout is how long an IP's limiter is kept after its last
+	// message
+	ipIdleTimeout = 5 * time.Minute
+	// ipSweepInterval is how often idle limiters are removed
+	ipSweepInterval = time.Minute
+)
+
+// ipLimiter is the token bucket of one scanned IP
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen atomic.Int64 // Unix nanoseconds of the last message
+}
+
+// ipRateLimiter holds a token bucket per scanned IP, so one host flooding
+// the subscription is delayed without slowing down the others
+// Limiters are created on first use and dropped by run once idle
+type ipRateLimiter struct {
+	limit    rate.Limit
+	burst    int
+	limiters sync.Map // IP -> *ipLimiter
+}
+
+// newIPRateLimiter creates a limiter allowing rps messages per second for
+// each IP, with bursts of up to burst
+// rps must be positive; a burst below 1 is raised to 1, since a zero burst
+// would never allow a message
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{limit: rate.Limit(rps), burst: max(burst, 1)}
+}
+
+// get returns the limiter for ip, creating it on first use
+func (l *ipRateLimiter) get(ip string, now time.Time) *ipLimiter {
+	v, ok := l.limiters.Load(ip)
+	if !ok {
+		v, _ = l.limiters.LoadOrStore(ip, &ipLimiter{limiter: rate.NewLimiter(l.limit, l.burst)})
+	}
+	il := v.(*ipLimiter)
+	il.lastSeen.Store(now.UnixNano())
+	return il
+}
+
+// wait blocks until a message for ip is allowed and reports whether it had
+// to wait for a token
+func (l *ipRateLimiter) wait(ctx context.Context, ip string) (bool, error) {
+	il := l.get(ip, time.Now())
+
+	delayed := il.limiter.Tokens() < 1
+	err := il.limiter.Wait(ctx)
+	// A long wait still counts as use, so the limiter is not swept under it
+	il.lastSeen.Store(time.Now().UnixNano())
+	if err != nil {
+		return delayed, fmt.Errorf("failed to wait for rate limit: %w", err)
+	}
+	return delayed, nil
+}
+
+// sweep removes limiters that have been idle for ipIdleTimeout
+// An IP seen again starts with a full bucket
+func (l *ipRateLimiter) sweep(now time.Time) {
+	cutoff := now.Add(-ipIdleTimeout).UnixNano()
+	l.limiters.Range(func(ip, v any) bool {
+		if v.(*ipLimiter).lastSeen.Load() < cutoff {
+			l.limiters.Delete(ip)
+		}
+		return true
+	})
+}
+
+// run sweeps idle limiters every ipSweepInterval until ctx is cancelled
+func (l *ipRateLimiter) run(ctx context.Context) {
+	ticker := time.NewTicker(ipSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.sweep(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// messageIP returns the IP of a scan message, normalized as it will be
+// stored, or "" if it has none or is not valid JSON
+// Messages without a usable IP are not rate limited; Process rejects them
+func messageIP(data []byte) string {
+	var msg struct {
+		IP string `json:"ip"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return ""
+	}
+	ip, err := normalizeIP(msg.IP)
+	if err != nil {
+		return ""
+	}
+	return ip
+}
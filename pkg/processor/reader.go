@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// maxLineBytes bounds a single message read by ProcessFromReader
+// Raw responses are base64 encoded, so lines can be well over the 64 KB
+// default of bufio.Scanner
+const maxLineBytes = 16 << 20
+
+// ProcessFromReader processes a stream of newline-delimited scan messages,
+// such as an NDJSON file or stdin, and returns how many lines were
+// processed, skipped as blank and failed
+// A failed message is logged and counted but does not stop the stream; err
+// is only set if the context is cancelled or r cannot be read
+func (p *Processor) ProcessFromReader(ctx context.Context, r io.Reader) (processed, skipped, errored int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineBytes)
+
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return processed, skipped, errored, err
+		}
+
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			skipped++
+			continue
+		}
+		if err := p.Process(ctx, data); err != nil {
+			errored++
+			p.logger.Warn("failed to process line", slog.Int("line", line), slog.Any("error", err))
+			continue
+		}
+		processed++
+	}
+	if err := scanner.Err(); err != nil {
+		return processed, skipped, errored, fmt.Errorf("failed to read messages: %w", err)
+	}
+	return processed, skipped, errored, ctx.Err()
+}
@@ -0,0 +1,87 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// TestProcessFromReader tests that a 100-line NDJSON file is tallied into
+// processed, blank and failed lines
+func TestProcessFromReader(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+
+	// 90 valid messages, 5 blank lines and 5 that fail to process
+	var b strings.Builder
+	for i := 0; i < 100; i++ {
+		switch {
+		case i%20 == 5:
+			b.WriteString("  \n")
+		case i%20 == 15:
+			b.WriteString("{not json\n")
+		default:
+			message, _ := json.Marshal(map[string]interface{}{
+				"ip":           fmt.Sprintf("10.0.0.%d", i),
+				"port":         80,
+				"service":      "HTTP",
+				"timestamp":    1000,
+				"data_version": 2,
+				"data":         map[string]string{"response_str": "ok"},
+			})
+			b.Write(message)
+			b.WriteByte('\n')
+		}
+	}
+	path := filepath.Join(t.TempDir(), "messages.ndjson")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	processed, skipped, errored, err := proc.ProcessFromReader(ctx, f)
+	if err != nil {
+		t.Fatalf("ProcessFromReader failed: %v", err)
+	}
+	if processed != 90 || skipped != 5 || errored != 5 {
+		t.Errorf("Expected 90 processed, 5 skipped and 5 errored, got %d, %d and %d", processed, skipped, errored)
+	}
+	if memStore.Len() != 90 {
+		t.Errorf("Expected 90 records stored, got %d", memStore.Len())
+	}
+}
+
+// TestProcessFromReaderCancelled tests that a cancelled context stops the
+// stream and is returned
+func TestProcessFromReaderCancelled(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	processed, skipped, errored, err := proc.ProcessFromReader(ctx, strings.NewReader("\n\n"))
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if processed+skipped+errored != 0 {
+		t.Errorf("Expected no lines read, got %d, %d and %d", processed, skipped, errored)
+	}
+}
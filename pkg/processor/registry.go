@@ -0,0 +1,154 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+)
+
+// ErrUnknownVersion is returned by Process when no handler is registered for
+// a scan's data version
+var ErrUnknownVersion = errors.New("unknown data version")
+
+// DataVersionHandler decodes the data field of one scan data version into
+// the response to store
+type DataVersionHandler interface {
+	Decode(data json.RawMessage) (string, error)
+}
+
+// fieldDecoder is implemented by built-in handlers whose data carries more
+// than the response, such as the TLS version and status code of V3 and V4
+type fieldDecoder interface {
+	decodeFields(data json.RawMessage) (*scanResult, error)
+}
+
+// MessageTypeRegistry maps data version codes to the handlers that decode
+// them
+// It is safe for concurrent use, so handlers may be registered while
+// messages are processed
+type MessageTypeRegistry struct {
+	mu       sync.RWMutex
+	handlers map[int]DataVersionHandler
+}
+
+// NewMessageTypeRegistry creates a registry holding the built-in handlers
+// for scanning.V1 through scanning.V4
+func NewMessageTypeRegistry() *MessageTypeRegistry {
+	return &MessageTypeRegistry{handlers: map[int]DataVersionHandler{
+		scanning.V1: v1Handler{},
+		scanning.V2: v2Handler{},
+		scanning.V3: v3Handler{},
+		scanning.V4: v4Handler{},
+	}}
+}
+
+// RegisterHandler sets the handler for version, replacing any handler
+// already registered for it
+func (r *MessageTypeRegistry) RegisterHandler(version int, h DataVersionHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[version] = h
+}
+
+// Handler returns the handler for version, or ErrUnknownVersion
+func (r *MessageTypeRegistry) Handler(version int) (DataVersionHandler, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return h, nil
+}
+
+// decode runs the handler for version on data
+func (r *MessageTypeRegistry) decode(version int, data json.RawMessage) (*scanResult, error) {
+	h, err := r.Handler(version)
+	if err != nil {
+		return nil, err
+	}
+	if fd, ok := h.(fieldDecoder); ok {
+		return fd.decodeFields(data)
+	}
+	response, err := h.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return &scanResult{Response: response}, nil
+}
+
+// v1Handler decodes V1 data, whose response bytes arrive base64 encoded
+type v1Handler struct{}
+
+// Decode returns the V1 response with invalid UTF-8 replaced
+func (v1Handler) Decode(data json.RawMessage) (string, error) {
+	var v1 scanning.V1Data
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return "", fmt.Errorf("failed to unmarshal V1 data: %w", err)
+	}
+	return v1.DecodeResponse()
+}
+
+// v2Handler decodes V2 data, whose response is a plain string
+type v2Handler struct{}
+
+// Decode returns the V2 response
+func (v2Handler) Decode(data json.RawMessage) (string, error) {
+	var v2 scanning.V2Data
+	if err := json.Unmarshal(data, &v2); err != nil {
+		return "", fmt.Errorf("failed to unmarshal V2 data: %w", err)
+	}
+	return v2.ResponseStr, nil
+}
+
+// v3Handler decodes V3 data, which adds the TLS version and status code
+type v3Handler struct{}
+
+// Decode returns the V3 response
+func (h v3Handler) Decode(data json.RawMessage) (string, error) {
+	result, err := h.decodeFields(data)
+	if err != nil {
+		return "", err
+	}
+	return result.Response, nil
+}
+
+func (v3Handler) decodeFields(data json.RawMessage) (*scanResult, error) {
+	var v3 scanning.V3Data
+	if err := json.Unmarshal(data, &v3); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal V3 data: %w", err)
+	}
+	return &scanResult{Response: v3.ResponseStr, TLSVersion: v3.TLSVersion, StatusCode: v3.StatusCode}, nil
+}
+
+// v4Handler decodes V4 data, the protobuf encoding of the V3 fields
+type v4Handler struct{}
+
+// Decode returns the V4 response
+func (h v4Handler) Decode(data json.RawMessage) (string, error) {
+	result, err := h.decodeFields(data)
+	if err != nil {
+		return "", err
+	}
+	return result.Response, nil
+}
+
+func (v4Handler) decodeFields(data json.RawMessage) (*scanResult, error) {
+	// The envelope is still JSON, so the protobuf bytes arrive base64 encoded
+	var payload []byte
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal V4 data: %w", err)
+	}
+	v4, err := scanning.ProtoUnmarshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &scanResult{
+		Response:   v4.GetResponseStr(),
+		TLSVersion: v4.GetTlsVersion(),
+		StatusCode: int(v4.GetStatusCode()),
+	}, nil
+}
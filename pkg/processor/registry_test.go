@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// upperHandler is a DataVersionHandler for a test format whose data is
+// {"text": ...}, recording the data it is given
+type upperHandler struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (h *upperHandler) Decode(data json.RawMessage) (string, error) {
+	h.mu.Lock()
+	h.calls = append(h.calls, string(data))
+	h.mu.Unlock()
+
+	var v struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(v.Text), nil
+}
+
+// TestRegisterHandler tests that a handler registered for a new version is
+// given the data field and its response is stored
+func TestRegisterHandler(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	ctx := context.Background()
+
+	proc := NewProcessor(memStore)
+	message := []byte(`{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 100, "data": {"text": "hello"}}`)
+	if err := proc.Process(ctx, message); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("Expected ErrUnknownVersion before registering, got %v", err)
+	}
+
+	h := &upperHandler{}
+	proc.RegisterHandler(100, h)
+	if err := proc.Process(ctx, message); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	if len(h.calls) != 1 || h.calls[0] != `{"text": "hello"}` {
+		t.Errorf("Expected handler to be called once with the data field, got %q", h.calls)
+	}
+	record, err := memStore.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if err != nil || record == nil {
+		t.Fatalf("Expected record, got %+v (err %v)", record, err)
+	}
+	if record.Response != "HELLO" || record.ResponseHash != store.HashResponse("HELLO") {
+		t.Errorf("Expected decoded response HELLO, got %+v", record)
+	}
+
+	bad := []byte(`{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 2000, "data_version": 100, "data": "not an object"}`)
+	if err := proc.Process(ctx, bad); err == nil {
+		t.Errorf("Expected handler error to fail the message")
+	}
+}
+
+// TestRegisterHandlerReplacesBuiltin tests that a built-in version can be
+// overridden, and that processors do not share registries
+func TestRegisterHandlerReplacesBuiltin(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+	ctx := context.Background()
+
+	proc := NewProcessor(memStore)
+	proc.RegisterHandler(2, &upperHandler{})
+	message := []byte(`{"ip": "1.1.1.1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"text": "hello", "response_str": "ignored"}}`)
+	if err := proc.Process(ctx, message); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if record, _ := memStore.Get(ctx, "1.1.1.1", 80, "HTTP"); record == nil || record.Response != "HELLO" {
+		t.Errorf("Expected replaced V2 handler to be used, got %+v", record)
+	}
+
+	h, err := NewMessageTypeRegistry().Handler(2)
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if _, ok := h.(v2Handler); !ok {
+		t.Errorf("Expected a new registry to keep the built-in V2 handler, got %T", h)
+	}
+}
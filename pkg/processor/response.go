@@ -0,0 +1,100 @@
+package processor
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ResponseNormalizer rewrites a decoded response before it is stored, so
+// records and change detection see only the parts that matter
+// service is the normalized service name
+type ResponseNormalizer interface {
+	Normalize(service, response string) string
+}
+
+// NopNormalizer stores responses unchanged; it is the default response
+// normalizer
+type NopNormalizer struct{}
+
+// Normalize returns response unchanged
+func (NopNormalizer) Normalize(service, response string) string {
+	return response
+}
+
+// StripHTMLNormalizer reduces HTTP and HTTPS responses to their visible
+// text, one line per block of text with whitespace collapsed
+// Scripts, styles and the document head are dropped; responses of other
+// services are unchanged
+type StripHTMLNormalizer struct{}
+
+// Normalize returns the visible text of an HTTP or HTTPS response
+func (StripHTMLNormalizer) Normalize(service, response string) string {
+	if service != "HTTP" && service != "HTTPS" {
+		return response
+	}
+	doc, err := html.Parse(strings.NewReader(response))
+	if err != nil {
+		return response
+	}
+
+	var b strings.Builder
+	writeVisibleText(&b, doc)
+
+	lines := strings.Split(b.String(), "\n")
+	text := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			text = append(text, line)
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+// hiddenElements hold no visible text
+var hiddenElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Template: true,
+}
+
+// blockElements start a new line of text
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Br: true, atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Fieldset: true, atom.Figcaption: true, atom.Figure: true, atom.Footer: true,
+	atom.Form: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true, atom.Li: true,
+	atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true, atom.Pre: true,
+	atom.Section: true, atom.Table: true, atom.Td: true, atom.Th: true, atom.Tr: true,
+	atom.Ul: true,
+}
+
+// lineBreaks replaces line breaks in text with spaces
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// writeVisibleText writes the text under n, with a newline around each
+// block element
+func writeVisibleText(b *strings.Builder, n *html.Node) {
+	switch {
+	case n.Type == html.TextNode:
+		// Line breaks in text are plain whitespace; only blocks start lines
+		b.WriteString(lineBreaks.Replace(n.Data))
+		return
+	case n.Type == html.ElementNode && hiddenElements[n.DataAtom]:
+		return
+	}
+
+	block := n.Type == html.ElementNode && blockElements[n.DataAtom]
+	if block {
+		b.WriteByte('\n')
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeVisibleText(b, c)
+	}
+	if block {
+		b.WriteByte('\n')
+	}
+}
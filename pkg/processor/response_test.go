@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+const testHTMLPage = `<!DOCTYPE html>
+<html>
+<head><title>Welcome</title><style>p { color: red; }</style></head>
+<body>
+  <h1>Welcome   to nginx!</h1>
+  <p>If you see this page, the web server
+     is working.</p>
+  <script>console.log("<p>hidden</p>");</script>
+  <p>For online documentation please refer to <a href="http://nginx.org/">nginx.org</a>.</p>
+</body>
+</html>`
+
+const testHTMLText = "Welcome to nginx!\n" +
+	"If you see this page, the web server is working.\n" +
+	"For online documentation please refer to nginx.org."
+
+// TestStripHTMLNormalizer tests that HTTP and HTTPS responses are reduced to
+// their visible text and other services are unchanged
+func TestStripHTMLNormalizer(t *testing.T) {
+	tests := []struct {
+		service  string
+		response string
+		want     string
+	}{
+		{"HTTP", testHTMLPage, testHTMLText},
+		{"HTTPS", testHTMLPage, testHTMLText},
+		{"HTTP", "plain text body", "plain text body"},
+		{"HTTP", "", ""},
+		{"SSH", testHTMLPage, testHTMLPage},
+		{"SSH", "SSH-2.0-OpenSSH_8.9<br>", "SSH-2.0-OpenSSH_8.9<br>"},
+	}
+
+	for _, tt := range tests {
+		if got := (StripHTMLNormalizer{}).Normalize(tt.service, tt.response); got != tt.want {
+			t.Errorf("Normalize(%q, %q): expected %q, got %q", tt.service, tt.response, tt.want, got)
+		}
+	}
+}
+
+// TestProcessResponseNormalizer tests that the response normalizer runs on
+// the decoded response before it is stored and hashed
+func TestProcessResponseNormalizer(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore, WithResponseNormalizer(StripHTMLNormalizer{}))
+	ctx := context.Background()
+
+	data, _ := json.Marshal(map[string]string{"response_str": testHTMLPage})
+	message, _ := json.Marshal(map[string]interface{}{
+		"ip":           "1.1.1.1",
+		"port":         80,
+		"service":      "http",
+		"timestamp":    1000,
+		"data_version": 2,
+		"data":         json.RawMessage(data),
+	})
+	if err := proc.Process(ctx, message); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	record, _ := memStore.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if record == nil {
+		t.Fatal("Expected record to exist")
+	}
+	if record.Response != testHTMLText {
+		t.Errorf("Expected response %q, got %q", testHTMLText, record.Response)
+	}
+	if want := store.HashResponse(testHTMLText); record.ResponseHash != want {
+		t.Errorf("Expected response hash %s, got %s", want, record.ResponseHash)
+	}
+}
+
+// TestProcessDefaultResponseNormalizer tests that responses are stored
+// unchanged by default
+func TestProcessDefaultResponseNormalizer(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore)
+	ctx := context.Background()
+
+	data, _ := json.Marshal(map[string]string{"response_str": testHTMLPage})
+	message, _ := json.Marshal(map[string]interface{}{
+		"ip":           "1.1.1.1",
+		"port":         80,
+		"service":      "HTTP",
+		"timestamp":    1000,
+		"data_version": 2,
+		"data":         json.RawMessage(data),
+	})
+	if err := proc.Process(ctx, message); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	record, _ := memStore.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if record == nil || record.Response != testHTMLPage {
+		t.Errorf("Expected response to be stored unchanged, got %+v", record)
+	}
+}
@@ -0,0 +1,50 @@
+package processor
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrServiceNotAllowed is returned by Process when a scan's normalized
+// service name is not in the allowlist given to WithServiceAllowlist
+var ErrServiceNotAllowed = errors.New("service not allowed")
+
+// ServiceNormalizer maps free-text service names to the form stored in
+// records, so variants such as "http" and "HTTP" share a record
+type ServiceNormalizer interface {
+	Normalize(service string) string
+}
+
+// UpperCaseNormalizer trims surrounding whitespace and upper-cases service
+// names; it is the default normalizer
+type UpperCaseNormalizer struct{}
+
+// Normalize returns the trimmed, upper-cased service name
+func (UpperCaseNormalizer) Normalize(service string) string {
+	return strings.ToUpper(strings.TrimSpace(service))
+}
+
+// serviceAllowlist is a case-insensitive set of service names
+// A nil allowlist allows every service
+type serviceAllowlist map[string]struct{}
+
+// newServiceAllowlist builds an allowlist, returning nil for an empty list
+func newServiceAllowlist(services []string) serviceAllowlist {
+	if len(services) == 0 {
+		return nil
+	}
+	allowed := make(serviceAllowlist, len(services))
+	for _, s := range services {
+		allowed[strings.ToUpper(strings.TrimSpace(s))] = struct{}{}
+	}
+	return allowed
+}
+
+// allows reports whether service is in the allowlist
+func (a serviceAllowlist) allows(service string) bool {
+	if a == nil {
+		return true
+	}
+	_, ok := a[strings.ToUpper(service)]
+	return ok
+}
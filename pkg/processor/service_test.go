@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// TestUpperCaseNormalizer tests trimming and upper-casing of service names
+func TestUpperCaseNormalizer(t *testing.T) {
+	tests := []struct {
+		service string
+		want    string
+	}{
+		{"HTTP", "HTTP"},
+		{"http", "HTTP"},
+		{"Http", "HTTP"},
+		{"  ssh\t", "SSH"},
+		{"http-alt", "HTTP-ALT"},
+	}
+
+	for _, tt := range tests {
+		if got := (UpperCaseNormalizer{}).Normalize(tt.service); got != tt.want {
+			t.Errorf("Normalize(%q): expected %q, got %q", tt.service, tt.want, got)
+		}
+	}
+}
+
+// serviceMessage builds a V2 scan message for the given service name
+func serviceMessage(service string) []byte {
+	return []byte(`{"ip": "1.1.1.1", "port": 80, "service": "` + service +
+		`", "timestamp": 1000, "data_version": 2, "data": {"response_str": "a"}}`)
+}
+
+// TestProcessNormalizesService tests that case variants share one record
+func TestProcessNormalizesService(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore)
+	ctx := context.Background()
+
+	for _, service := range []string{"http", "Http", " HTTP "} {
+		if err := proc.Process(ctx, serviceMessage(service)); err != nil {
+			t.Fatalf("Process failed for %q: %v", service, err)
+		}
+	}
+
+	count, _ := memStore.Count(ctx)
+	if count != 1 {
+		t.Errorf("Expected 1 record, got %d", count)
+	}
+	if record, _ := memStore.Get(ctx, "1.1.1.1", 80, "HTTP"); record == nil {
+		t.Error("Expected record stored under HTTP")
+	}
+}
+
+// prefixNormalizer is a custom normalizer used to test WithServiceNormalizer
+type prefixNormalizer struct{}
+
+func (prefixNormalizer) Normalize(service string) string { return "X" + service }
+
+// TestWithServiceNormalizer tests that a custom normalizer replaces the default
+func TestWithServiceNormalizer(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore, WithServiceNormalizer(prefixNormalizer{}))
+	ctx := context.Background()
+
+	if err := proc.Process(ctx, serviceMessage("HTTP")); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if record, _ := memStore.Get(ctx, "1.1.1.1", 80, "XHTTP"); record == nil {
+		t.Error("Expected record stored under XHTTP")
+	}
+}
+
+// TestServiceAllowlist tests allowlist acceptance and rejection
+func TestServiceAllowlist(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		service   string
+		allowed   bool
+	}{
+		{"listed", []string{"HTTP", "SSH"}, "HTTP", true},
+		{"listed after normalization", []string{"HTTP"}, "http", true},
+		{"lowercase allowlist", []string{"ssh"}, "SSH", true},
+		{"not listed", []string{"HTTP", "SSH"}, "FTP", false},
+		{"empty allowlist", nil, "FTP", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memStore := store.NewMemoryStore()
+			defer memStore.Close()
+
+			proc := NewProcessor(memStore, WithServiceAllowlist(tt.allowlist))
+			err := proc.Process(context.Background(), serviceMessage(tt.service))
+
+			if tt.allowed {
+				if err != nil {
+					t.Errorf("Expected service to be allowed, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrServiceNotAllowed) {
+				t.Errorf("Expected ErrServiceNotAllowed, got %v", err)
+			}
+			if count, _ := memStore.Count(context.Background()); count != 0 {
+				t.Errorf("Expected no records to be stored, got %d", count)
+			}
+		})
+	}
+}
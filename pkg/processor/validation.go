@@ -0,0 +1,122 @@
+package processor
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// maxClockSkew is how far in the future a scan timestamp may be
+const maxClockSkew = 5 * time.Minute
+
+// servicePattern is the allowed format for service names
+var servicePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_-]{0,31}$`)
+
+// FieldError describes a single invalid envelope field
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError accumulates every invalid field in a scan envelope
+type ValidationError struct {
+	Errors []FieldError
+}
+
+// Error joins the field errors into a single message
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return "invalid scan: " + strings.Join(parts, "; ")
+}
+
+// add records a field error
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateScan checks the envelope fields of a scan message
+// Returns nil when valid, otherwise a *ValidationError listing every problem
+func validateScan(raw *rawScan, now time.Time) error {
+	verr := &ValidationError{}
+	verr.checkKey(raw.IP, raw.Port, raw.Service)
+	verr.checkTimestamp(raw.Timestamp, now)
+	return verr.orNil()
+}
+
+// ValidateRecord applies the scan envelope rules to a record written
+// outside the processor
+// Returns nil when valid, otherwise a *ValidationError listing every problem
+func ValidateRecord(r *store.ServiceRecord, now time.Time) error {
+	verr := &ValidationError{}
+	verr.checkKey(r.IP.String(), r.Port, r.Service)
+	verr.checkTimestamp(r.LastTimestamp, now)
+	return verr.orNil()
+}
+
+// ValidateKey checks a record's composite key against the envelope rules
+// Returns nil when valid, otherwise a *ValidationError listing every problem
+func ValidateKey(ip string, port uint32, service string) error {
+	verr := &ValidationError{}
+	verr.checkKey(ip, port, service)
+	return verr.orNil()
+}
+
+// checkKey validates the ip, port and service fields
+func (e *ValidationError) checkKey(ip string, port uint32, service string) {
+	if net.ParseIP(ip) == nil {
+		e.add("ip", "%q is not a valid IP address", ip)
+	}
+	if port < 1 || port > 65535 {
+		e.add("port", "%d is out of range 1-65535", port)
+	}
+	if !servicePattern.MatchString(service) {
+		e.add("service", "%q must match %s", service, servicePattern)
+	}
+}
+
+// normalizeIP returns the canonical form of an IP address so the same host
+// always maps to the same record, see store.IPAddress
+// Input that is neither an IPv4 nor an IPv6 address is rejected
+func normalizeIP(raw string) (string, error) {
+	ip, err := store.ParseIPAddress(raw)
+	if err != nil {
+		verr := &ValidationError{}
+		verr.add("ip", "%q is not a valid IP address", raw)
+		return "", verr
+	}
+	return ip.String(), nil
+}
+
+// checkTimestamp rejects non-positive timestamps and ones too far ahead of now
+func (e *ValidationError) checkTimestamp(ts int64, now time.Time) {
+	if ts <= 0 {
+		e.add("timestamp", "%d must be positive", ts)
+	} else if limit := now.Add(maxClockSkew).Unix(); ts > limit {
+		e.add("timestamp", "%d is more than %s in the future", ts, maxClockSkew)
+	}
+}
+
+// prefixed returns a copy of e with prefix added to every field name, for
+// fields nested in a larger message
+func (e *ValidationError) prefixed(prefix string) *ValidationError {
+	out := &ValidationError{Errors: make([]FieldError, len(e.Errors))}
+	for i, fe := range e.Errors {
+		out.Errors[i] = FieldError{Field: prefix + fe.Field, Message: fe.Message}
+	}
+	return out
+}
+
+// orNil returns e if any field errors were recorded
+func (e *ValidationError) orNil() error {
+	if len(e.Errors) > 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// TestValidateScan tests each envelope validation rule including boundaries
+func TestValidateScan(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	valid := rawScan{IP: "1.1.1.1", Port: 80, Service: "HTTP", Timestamp: 1000}
+
+	tests := []struct {
+		name      string
+		modify    func(r *rawScan)
+		wantField string // empty means valid
+	}{
+		{"valid", func(r *rawScan) {}, ""},
+		{"ipv6", func(r *rawScan) { r.IP = "2001:db8::1" }, ""},
+		{"empty ip", func(r *rawScan) { r.IP = "" }, "ip"},
+		{"malformed ip", func(r *rawScan) { r.IP = "1.1.1.256" }, "ip"},
+		{"port 0", func(r *rawScan) { r.Port = 0 }, "port"},
+		{"port 1", func(r *rawScan) { r.Port = 1 }, ""},
+		{"port 65535", func(r *rawScan) { r.Port = 65535 }, ""},
+		{"port 65536", func(r *rawScan) { r.Port = 65536 }, "port"},
+		{"empty service", func(r *rawScan) { r.Service = "" }, "service"},
+		{"lowercase service", func(r *rawScan) { r.Service = "http" }, "service"},
+		{"leading digit service", func(r *rawScan) { r.Service = "1HTTP" }, "service"},
+		{"service with separators", func(r *rawScan) { r.Service = "HTTP_ALT-2" }, ""},
+		{"service 32 chars", func(r *rawScan) { r.Service = "S" + strings.Repeat("X", 31) }, ""},
+		{"service 33 chars", func(r *rawScan) { r.Service = "S" + strings.Repeat("X", 32) }, "service"},
+		{"timestamp 0", func(r *rawScan) { r.Timestamp = 0 }, "timestamp"},
+		{"negative timestamp", func(r *rawScan) { r.Timestamp = -1 }, "timestamp"},
+		{"timestamp 1", func(r *rawScan) { r.Timestamp = 1 }, ""},
+		{"timestamp at skew limit", func(r *rawScan) { r.Timestamp = now.Add(5 * time.Minute).Unix() }, ""},
+		{"timestamp past skew limit", func(r *rawScan) { r.Timestamp = now.Add(5*time.Minute).Unix() + 1 }, "timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := valid
+			tt.modify(&raw)
+
+			err := validateScan(&raw, now)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Expected *ValidationError, got %v", err)
+			}
+			if len(verr.Errors) != 1 || verr.Errors[0].Field != tt.wantField {
+				t.Errorf("Expected a single %s error, got %+v", tt.wantField, verr.Errors)
+			}
+		})
+	}
+}
+
+// TestValidationErrorAccumulates tests that every invalid field is reported
+func TestValidationErrorAccumulates(t *testing.T) {
+	raw := rawScan{IP: "", Port: 0, Service: "", Timestamp: 0}
+
+	err := validateScan(&raw, time.Now())
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	if len(verr.Errors) != 4 {
+		t.Errorf("Expected 4 field errors, got %d: %v", len(verr.Errors), verr)
+	}
+	for _, field := range []string{"ip", "port", "service", "timestamp"} {
+		if !strings.Contains(verr.Error(), field+":") {
+			t.Errorf("Expected error message to mention %s, got %q", field, verr.Error())
+		}
+	}
+}
+
+// TestNormalizeIP tests canonicalization of IP address forms
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"ipv4", "1.2.3.4", "1.2.3.4", false},
+		{"ipv6", "2001:DB8:0:0:0:0:0:1", "2001:db8::1", false},
+		{"ipv4-mapped ipv6", "::ffff:1.2.3.4", "1.2.3.4", false},
+		{"ipv4-mapped ipv6 hex", "::ffff:0102:0304", "1.2.3.4", false},
+		{"ipv4 loopback", "127.0.0.1", "127.0.0.1", false},
+		{"ipv6 loopback", "0:0:0:0:0:0:0:1", "::1", false},
+		{"empty", "", "", true},
+		{"garbage", "not-an-ip", "", true},
+		{"out of range octet", "1.2.3.256", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeIP(tt.raw)
+			if tt.wantErr {
+				var verr *ValidationError
+				if !errors.As(err, &verr) {
+					t.Fatalf("Expected *ValidationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestProcessNormalizesIP tests that mapped and plain IPv4 forms of the same
+// host, and different spellings of one IPv6 address, update a single record
+func TestProcessNormalizesIP(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore)
+	ctx := context.Background()
+
+	messages := []string{
+		`{"ip": "1.2.3.4", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "a"}}`,
+		`{"ip": "::ffff:1.2.3.4", "port": 80, "service": "HTTP", "timestamp": 2000, "data_version": 2, "data": {"response_str": "b"}}`,
+		`{"ip": "::1", "port": 80, "service": "HTTP", "timestamp": 1000, "data_version": 2, "data": {"response_str": "c"}}`,
+		`{"ip": "0:0::1", "port": 80, "service": "HTTP", "timestamp": 2000, "data_version": 2, "data": {"response_str": "d"}}`,
+	}
+	for _, m := range messages {
+		if err := proc.Process(ctx, []byte(m)); err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+	}
+
+	count, _ := memStore.Count(ctx)
+	if count != 2 {
+		t.Errorf("Expected 2 records, got %d", count)
+	}
+	record, _ := memStore.Get(ctx, "1.2.3.4", 80, "HTTP")
+	if record == nil || record.Response != "b" {
+		t.Errorf("Expected record 1.2.3.4 with response b, got %+v", record)
+	}
+	record, _ = memStore.Get(ctx, "::1", 80, "HTTP")
+	if record == nil || record.IP != "::1" || record.Response != "d" || record.ScanCount != 2 {
+		t.Errorf("Expected record ::1 with response d after 2 scans, got %+v", record)
+	}
+}
+
+// TestProcessReturnsValidationError tests that Process surfaces validation
+// failures as *ValidationError and does not store the record
+func TestProcessReturnsValidationError(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	defer memStore.Close()
+
+	proc := NewProcessor(memStore)
+	ctx := context.Background()
+
+	message := []byte(`{"ip": "not-an-ip", "port": 80, "service": "HTTP", "timestamp": 1000,
+		"data_version": 2, "data": {"response_str": "hello"}}`)
+
+	err := proc.Process(ctx, message)
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("Expected *ValidationError, got %T: %v", err, err)
+	}
+
+	count, _ := memStore.Count(ctx)
+	if count != 0 {
+		t.Errorf("Expected no records to be stored, got %d", count)
+	}
+
+	// Malformed JSON is not a validation error
+	err = proc.Process(ctx, []byte(`{not json`))
+	var verr *ValidationError
+	if err == nil || errors.As(err, &verr) {
+		t.Errorf("Expected a non-validation error for malformed JSON, got %v", err)
+	}
+}
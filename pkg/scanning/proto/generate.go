@@ -0,0 +1,4 @@
+// Package scanningpb contains the protobuf encoding of scan data
+package scanningpb
+
+//go:generate protoc -I ../../.. --go_out=../../.. --go_opt=paths=source_relative pkg/scanning/proto/scan.proto
@@ -0,0 +1,153 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.7
+// 	protoc        v5.29.3
+// source: pkg/scanning/proto/scan.proto
+
+package scanningpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// V4Data is the protobuf encoding of a scan's data field
+// It carries the same fields as the V3 JSON format
+type V4Data struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ResponseStr     string                 `protobuf:"bytes,1,opt,name=response_str,json=responseStr,proto3" json:"response_str,omitempty"`
+	TlsVersion      string                 `protobuf:"bytes,2,opt,name=tls_version,json=tlsVersion,proto3" json:"tls_version,omitempty"`
+	CertFingerprint string                 `protobuf:"bytes,3,opt,name=cert_fingerprint,json=certFingerprint,proto3" json:"cert_fingerprint,omitempty"`
+	StatusCode      int32                  `protobuf:"varint,4,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *V4Data) Reset() {
+	*x = V4Data{}
+	mi := &file_pkg_scanning_proto_scan_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *V4Data) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*V4Data) ProtoMessage() {}
+
+func (x *V4Data) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_scanning_proto_scan_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use V4Data.ProtoReflect.Descriptor instead.
+func (*V4Data) Descriptor() ([]byte, []int) {
+	return file_pkg_scanning_proto_scan_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *V4Data) GetResponseStr() string {
+	if x != nil {
+		return x.ResponseStr
+	}
+	return ""
+}
+
+func (x *V4Data) GetTlsVersion() string {
+	if x != nil {
+		return x.TlsVersion
+	}
+	return ""
+}
+
+func (x *V4Data) GetCertFingerprint() string {
+	if x != nil {
+		return x.CertFingerprint
+	}
+	return ""
+}
+
+func (x *V4Data) GetStatusCode() int32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+var File_pkg_scanning_proto_scan_proto protoreflect.FileDescriptor
+
+const file_pkg_scanning_proto_scan_proto_rawDesc = "" +
+	"\n" +
+	"\x1dpkg/scanning/proto/scan.proto\x12\bscanning\"\x98\x01\n" +
+	"\x06V4Data\x12!\n" +
+	"\fresponse_str\x18\x01 \x01(\tR\vresponseStr\x12\x1f\n" +
+	"\vtls_version\x18\x02 \x01(\tR\n" +
+	"tlsVersion\x12)\n" +
+	"\x10cert_fingerprint\x18\x03 \x01(\tR\x0fcertFingerprint\x12\x1f\n" +
+	"\vstatus_code\x18\x04 \x01(\x05R\n" +
+	"statusCodeB?Z=github.com/censys/scan-takehome/pkg/scanning/proto;scanningpbb\x06proto3"
+
+var (
+	file_pkg_scanning_proto_scan_proto_rawDescOnce sync.Once
+	file_pkg_scanning_proto_scan_proto_rawDescData []byte
+)
+
+func file_pkg_scanning_proto_scan_proto_rawDescGZIP() []byte {
+	file_pkg_scanning_proto_scan_proto_rawDescOnce.Do(func() {
+		file_pkg_scanning_proto_scan_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pkg_scanning_proto_scan_proto_rawDesc), len(file_pkg_scanning_proto_scan_proto_rawDesc)))
+	})
+	return file_pkg_scanning_proto_scan_proto_rawDescData
+}
+
+var file_pkg_scanning_proto_scan_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pkg_scanning_proto_scan_proto_goTypes = []any{
+	(*V4Data)(nil), // 0: scanning.V4Data
+}
+var file_pkg_scanning_proto_scan_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_pkg_scanning_proto_scan_proto_init() }
+func file_pkg_scanning_proto_scan_proto_init() {
+	if File_pkg_scanning_proto_scan_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_scanning_proto_scan_proto_rawDesc), len(file_pkg_scanning_proto_scan_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_pkg_scanning_proto_scan_proto_goTypes,
+		DependencyIndexes: file_pkg_scanning_proto_scan_proto_depIdxs,
+		MessageInfos:      file_pkg_scanning_proto_scan_proto_msgTypes,
+	}.Build()
+	File_pkg_scanning_proto_scan_proto = out.File
+	file_pkg_scanning_proto_scan_proto_goTypes = nil
+	file_pkg_scanning_proto_scan_proto_depIdxs = nil
+}
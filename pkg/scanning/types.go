@@ -1,9 +1,21 @@
 package scanning
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	scanningpb "github.com/censys/scan-takehome/pkg/scanning/proto"
+	"google.golang.org/protobuf/proto"
+)
+
 const (
 	Version = iota
 	V1
 	V2
+	V3
+	V4
 )
 
 type Scan struct {
@@ -15,10 +27,82 @@ type Scan struct {
 	Data        interface{} `json:"data"`
 }
 
+// DataTypeMulti is the data_type of a MultiScan envelope
+// Envelopes without a data_type are a single Scan
+const DataTypeMulti = "multi"
+
+// MultiScan is a host report carrying every service found on an IP in one
+// message
+// Each service is decoded like the data of a Scan with the same port,
+// service and data version, and shares the report's timestamp
+type MultiScan struct {
+	DataType  string         `json:"data_type"`
+	IP        string         `json:"ip"`
+	Timestamp int64          `json:"timestamp"`
+	Services  []ServiceEntry `json:"services"`
+}
+
+// ServiceEntry is one service in a MultiScan
+type ServiceEntry struct {
+	Port        uint32          `json:"port"`
+	Service     string          `json:"service"`
+	DataVersion int             `json:"data_version"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// V1Data carries the response as raw bytes; in the JSON envelope the data
+// field holds them base64 encoded
 type V1Data struct {
 	ResponseBytesUtf8 []byte `json:"response_bytes_utf8"`
 }
 
+// EncodeResponse returns V1 data carrying the bytes of s
+func EncodeResponse(s string) *V1Data {
+	return &V1Data{ResponseBytesUtf8: []byte(s)}
+}
+
+// DecodeResponse returns the response as a UTF-8 string
+// The base64 layer is already removed when the data is unmarshaled from
+// JSON; this only makes the bytes safe to store as text
+// Each run of consecutive bytes that are not valid UTF-8 is replaced by a
+// single U+FFFD replacement character, so "a\xff\xfeb" decodes to "a\uFFFDb";
+// valid input, including the empty response, is returned unchanged
+func (v *V1Data) DecodeResponse() (string, error) {
+	if v == nil {
+		return "", errors.New("missing V1 data")
+	}
+	return strings.ToValidUTF8(string(v.ResponseBytesUtf8), "\uFFFD"), nil
+}
+
 type V2Data struct {
 	ResponseStr string `json:"response_str"`
 }
+
+type V3Data struct {
+	ResponseStr     string `json:"response_str"`
+	TLSVersion      string `json:"tls_version"`
+	CertFingerprint string `json:"cert_fingerprint"`
+	StatusCode      int    `json:"status_code"`
+}
+
+// V4Data is protobuf encoded; in the JSON envelope the data field holds the
+// base64 encoded message bytes
+type V4Data = scanningpb.V4Data
+
+// ProtoUnmarshal decodes the protobuf bytes of a V4 data field
+func ProtoUnmarshal(b []byte) (*V4Data, error) {
+	var v4 V4Data
+	if err := proto.Unmarshal(b, &v4); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal V4 data: %w", err)
+	}
+	return &v4, nil
+}
+
+// ProtoMarshal encodes V4 data into protobuf bytes
+func ProtoMarshal(v4 *V4Data) ([]byte, error) {
+	b, err := proto.Marshal(v4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal V4 data: %w", err)
+	}
+	return b, nil
+}
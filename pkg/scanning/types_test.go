@@ -0,0 +1,65 @@
+package scanning
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestV1DataDecodeResponse tests decoding of valid and invalid UTF-8
+func TestV1DataDecodeResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"empty", nil, ""},
+		{"ascii", []byte("HTTP/1.1 200 OK"), "HTTP/1.1 200 OK"},
+		{"multibyte", []byte("héllo 世界"), "héllo 世界"},
+		{"invalid byte", []byte("a\xffb"), "a�b"},
+		{"invalid run", []byte("a\xff\xfe\xfdb"), "a�b"},
+		{"separate invalid bytes", []byte("\xffa\xff"), "�a�"},
+		{"truncated rune", []byte("a\xe4\xb8"), "a�"},
+		{"literal replacement char", []byte("a�b"), "a�b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&V1Data{ResponseBytesUtf8: tt.input}).DecodeResponse()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	var v1 *V1Data
+	if _, err := v1.DecodeResponse(); err == nil {
+		t.Errorf("Expected error for nil V1 data")
+	}
+}
+
+// TestV1DataRoundTrip tests that EncodeResponse bytes survive the base64
+// JSON envelope unchanged, including bytes that are not valid UTF-8
+func TestV1DataRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "hello", "héllo 世界", "\x00\x01\xff\xfe binary"} {
+		b, err := json.Marshal(EncodeResponse(s))
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		var v1 V1Data
+		if err := json.Unmarshal(b, &v1); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if !bytes.Equal(v1.ResponseBytesUtf8, []byte(s)) {
+			t.Errorf("Expected bytes %q, got %q", s, v1.ResponseBytesUtf8)
+		}
+	}
+
+	got, err := EncodeResponse("héllo").DecodeResponse()
+	if err != nil || got != "héllo" {
+		t.Errorf("Expected %q, got %q (err %v)", "héllo", got, err)
+	}
+}
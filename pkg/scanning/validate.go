@@ -0,0 +1,110 @@
+package scanning
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxServiceLength is the longest service name Validate accepts, in
+	// characters
+	MaxServiceLength = 64
+
+	// MaxClockSkew is how far in the future Validate accepts a timestamp
+	MaxClockSkew = 10 * time.Minute
+)
+
+// FieldError describes a single invalid field
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ScanValidationError lists every invalid field of a scan or its data
+type ScanValidationError struct {
+	Errors []FieldError
+}
+
+// Error joins the field errors into a single message
+func (e *ScanValidationError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return "invalid scan: " + strings.Join(parts, "; ")
+}
+
+// add records a field error
+func (e *ScanValidationError) add(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// orNil returns e if any field errors were recorded
+func (e *ScanValidationError) orNil() error {
+	if len(e.Errors) > 0 {
+		return e
+	}
+	return nil
+}
+
+// Validate checks the envelope fields of the scan
+// Returns nil when valid, otherwise a *ScanValidationError listing every
+// problem
+// The data payload is not checked, see ValidateV1Data and ValidateV2Data
+func (s *Scan) Validate() error {
+	return s.validate(time.Now())
+}
+
+// validate checks the envelope fields against the given current time
+func (s *Scan) validate(now time.Time) error {
+	verr := &ScanValidationError{}
+	if net.ParseIP(s.Ip) == nil {
+		verr.add("ip", "%q is not a valid IP address", s.Ip)
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		verr.add("port", "%d is out of range 1-65535", s.Port)
+	}
+	if s.Service == "" {
+		verr.add("service", "must not be empty")
+	} else if n := utf8.RuneCountInString(s.Service); n > MaxServiceLength {
+		verr.add("service", "%d characters is longer than %d", n, MaxServiceLength)
+	}
+	if s.Timestamp <= 0 {
+		verr.add("timestamp", "%d must be positive", s.Timestamp)
+	} else if limit := now.Add(MaxClockSkew).Unix(); s.Timestamp > limit {
+		verr.add("timestamp", "%d is more than %s in the future", s.Timestamp, MaxClockSkew)
+	}
+	switch s.DataVersion {
+	case V1, V2, V3, V4:
+	default:
+		verr.add("data_version", "unknown data version %d", s.DataVersion)
+	}
+	return verr.orNil()
+}
+
+// ValidateV1Data checks a V1 payload
+// Returns nil when valid, otherwise a *ScanValidationError
+func ValidateV1Data(v1 *V1Data) error {
+	verr := &ScanValidationError{}
+	if v1 == nil {
+		verr.add("data", "must not be empty")
+	} else if !utf8.Valid(v1.ResponseBytesUtf8) {
+		verr.add("data.response_bytes_utf8", "is not valid UTF-8")
+	}
+	return verr.orNil()
+}
+
+// ValidateV2Data checks a V2 payload
+// Returns nil when valid, otherwise a *ScanValidationError
+func ValidateV2Data(v2 *V2Data) error {
+	verr := &ScanValidationError{}
+	if v2 == nil {
+		verr.add("data", "must not be empty")
+	} else if !utf8.ValidString(v2.ResponseStr) {
+		verr.add("data.response_str", "is not valid UTF-8")
+	}
+	return verr.orNil()
+}
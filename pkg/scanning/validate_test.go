@@ -0,0 +1,125 @@
+package scanning
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestScanValidate tests each envelope validation rule including boundaries
+func TestScanValidate(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	valid := Scan{Ip: "1.1.1.1", Port: 80, Service: "HTTP", Timestamp: 1000, DataVersion: V2}
+
+	tests := []struct {
+		name       string
+		modify     func(s *Scan)
+		wantFields []string // empty means valid
+	}{
+		{"valid", func(s *Scan) {}, nil},
+		{"zero value", func(s *Scan) { *s = Scan{} }, []string{"ip", "port", "service", "timestamp", "data_version"}},
+		{"ipv6", func(s *Scan) { s.Ip = "2001:db8::1" }, nil},
+		{"empty ip", func(s *Scan) { s.Ip = "" }, []string{"ip"}},
+		{"malformed ip", func(s *Scan) { s.Ip = "1.1.1.256" }, []string{"ip"}},
+		{"hostname", func(s *Scan) { s.Ip = "example.com" }, []string{"ip"}},
+		{"port 0", func(s *Scan) { s.Port = 0 }, []string{"port"}},
+		{"port 1", func(s *Scan) { s.Port = 1 }, nil},
+		{"port 65535", func(s *Scan) { s.Port = 65535 }, nil},
+		{"port 65536", func(s *Scan) { s.Port = 65536 }, []string{"port"}},
+		{"empty service", func(s *Scan) { s.Service = "" }, []string{"service"}},
+		{"lowercase service", func(s *Scan) { s.Service = "http" }, nil},
+		{"service 64 chars", func(s *Scan) { s.Service = strings.Repeat("S", 64) }, nil},
+		{"service 64 multibyte chars", func(s *Scan) { s.Service = strings.Repeat("é", 64) }, nil},
+		{"service 65 chars", func(s *Scan) { s.Service = strings.Repeat("S", 65) }, []string{"service"}},
+		{"timestamp 0", func(s *Scan) { s.Timestamp = 0 }, []string{"timestamp"}},
+		{"negative timestamp", func(s *Scan) { s.Timestamp = -1 }, []string{"timestamp"}},
+		{"timestamp 1", func(s *Scan) { s.Timestamp = 1 }, nil},
+		{"timestamp at skew limit", func(s *Scan) { s.Timestamp = now.Add(10 * time.Minute).Unix() }, nil},
+		{"timestamp past skew limit", func(s *Scan) { s.Timestamp = now.Add(10*time.Minute).Unix() + 1 }, []string{"timestamp"}},
+		{"data version 0", func(s *Scan) { s.DataVersion = 0 }, []string{"data_version"}},
+		{"data version 1", func(s *Scan) { s.DataVersion = V1 }, nil},
+		{"data version 4", func(s *Scan) { s.DataVersion = V4 }, nil},
+		{"data version 5", func(s *Scan) { s.DataVersion = 5 }, []string{"data_version"}},
+		{"negative data version", func(s *Scan) { s.DataVersion = -1 }, []string{"data_version"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.modify(&s)
+			expectFields(t, s.validate(now), tt.wantFields)
+		})
+	}
+}
+
+// TestScanValidateNow tests that Validate checks timestamps against the
+// current time
+func TestScanValidateNow(t *testing.T) {
+	s := Scan{Ip: "1.1.1.1", Port: 80, Service: "HTTP", Timestamp: time.Now().Unix(), DataVersion: V1}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	s.Timestamp = time.Now().Add(time.Hour).Unix()
+	expectFields(t, s.Validate(), []string{"timestamp"})
+}
+
+// TestValidateData tests the V1 and V2 payload checks
+func TestValidateData(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantFields []string
+	}{
+		{"v1 nil", ValidateV1Data(nil), []string{"data"}},
+		{"v1 zero value", ValidateV1Data(&V1Data{}), nil},
+		{"v1 valid", ValidateV1Data(&V1Data{ResponseBytesUtf8: []byte("hello")}), nil},
+		{"v1 invalid utf8", ValidateV1Data(&V1Data{ResponseBytesUtf8: []byte{0xff, 0xfe}}), []string{"data.response_bytes_utf8"}},
+		{"v2 nil", ValidateV2Data(nil), []string{"data"}},
+		{"v2 zero value", ValidateV2Data(&V2Data{}), nil},
+		{"v2 valid", ValidateV2Data(&V2Data{ResponseStr: "hello"}), nil},
+		{"v2 invalid utf8", ValidateV2Data(&V2Data{ResponseStr: "\xff"}), []string{"data.response_str"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectFields(t, tt.err, tt.wantFields)
+		})
+	}
+}
+
+// TestScanValidationErrorMessage tests that every field error is included in
+// the message
+func TestScanValidationErrorMessage(t *testing.T) {
+	s := Scan{Ip: "bad", Port: 80, Service: "HTTP", Timestamp: 1000, DataVersion: 9}
+	err := s.Validate()
+	want := `invalid scan: ip: "bad" is not a valid IP address; data_version: unknown data version 9`
+	if err == nil || err.Error() != want {
+		t.Errorf("Expected %q, got %v", want, err)
+	}
+}
+
+// expectFields fails the test unless err is a *ScanValidationError for
+// exactly the given fields, in order, or nil when none are given
+func expectFields(t *testing.T, err error, want []string) {
+	t.Helper()
+	if len(want) == 0 {
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		return
+	}
+
+	var verr *ScanValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ScanValidationError, got %v", err)
+	}
+	if len(verr.Errors) != len(want) {
+		t.Fatalf("Expected errors for %v, got %+v", want, verr.Errors)
+	}
+	for i, fe := range verr.Errors {
+		if fe.Field != want[i] {
+			t.Errorf("Expected error %d for %s, got %s", i, want[i], fe.Field)
+		}
+	}
+}
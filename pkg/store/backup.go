@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteBackupRetry is how long Backup waits before retrying when the
+// source database is locked by a writer
+const sqliteBackupRetry = 10 * time.Millisecond
+
+// Backup copies a consistent snapshot of the database to destPath using
+// SQLite's online backup API, without blocking other readers or writers
+// for longer than the copy itself
+// The snapshot is written to a temporary file beside destPath and renamed
+// into place, so destPath is never left half-written
+func (s *SQLiteStore) Backup(ctx context.Context, destPath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), filepath.Base(destPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	tmp.Close()
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := s.backupTo(ctx, tmpPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move backup into place: %w", err)
+	}
+	return nil
+}
+
+// BackupToWriter writes a consistent snapshot of the database to w
+// The snapshot is staged in a temporary file, since the backup API can only
+// write to another database
+func (s *SQLiteStore) BackupToWriter(ctx context.Context, w io.Writer) error {
+	dir, err := os.MkdirTemp("", "sqlite-backup-*")
+	if err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup.db")
+	if err := s.backupTo(ctx, path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// backupTo copies the database into the SQLite file at path
+// The destination is closed before returning, so the file is complete
+func (s *SQLiteStore) backupTo(ctx context.Context, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup database: %w", err)
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open backup database: %w", err)
+	}
+	defer destConn.Close()
+
+	srcConn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			return sqliteBackup(ctx, destDriver.(*sqlite3.SQLiteConn), srcDriver.(*sqlite3.SQLiteConn))
+		})
+	})
+}
+
+// sqliteBackup copies every page of src's main database to dest in a single
+// step, retrying while src is locked
+func sqliteBackup(ctx context.Context, dest, src *sqlite3.SQLiteConn) error {
+	backup, err := dest.Backup("main", src, "main")
+	if err != nil {
+		return fmt.Errorf("failed to start backup: %w", err)
+	}
+
+	for {
+		done, err := backup.Step(-1)
+		if err != nil {
+			backup.Close()
+			return fmt.Errorf("failed to copy database: %w", err)
+		}
+		if done {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			backup.Close()
+			return ctx.Err()
+		case <-time.After(sqliteBackupRetry):
+		}
+	}
+
+	if err := backup.Finish(); err != nil {
+		return fmt.Errorf("failed to finish backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,609 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// BadgerStore implements Store interface using BadgerDB, an embedded
+// key-value store written in pure Go
+// Useful where SQLite's CGo dependency gets in the way, such as
+// cross-compiled builds
+// Records are stored as JSON under their "ip:port:service" key; every
+// query other than Get and ListByIP scans all records
+type BadgerStore struct {
+	db  *badger.DB
+	hub watchHub
+
+	// mu serializes writes, so a read-modify-write transaction never
+	// conflicts with another and events are published in commit order
+	mu sync.Mutex
+}
+
+// NewBadgerStore creates a new Badger store in dir, creating it if needed
+func NewBadgerStore(dir string) (*BadgerStore, error) {
+	opts := badger.DefaultOptions(dir).WithLoggingLevel(badger.WARNING)
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return &BadgerStore{db: db}, nil
+}
+
+// badgerGet decodes the record stored under key, or returns nil if there is
+// none
+func badgerGet(txn *badger.Txn, key []byte) (*ServiceRecord, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return badgerDecode(item)
+}
+
+// badgerDecode decodes the record stored in item
+func badgerDecode(item *badger.Item) (*ServiceRecord, error) {
+	var r ServiceRecord
+	err := item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &r)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode record %q: %w", item.Key(), err)
+	}
+	return &r, nil
+}
+
+// badgerPut encodes r and stores it under its key
+func badgerPut(txn *badger.Txn, r *ServiceRecord) error {
+	val, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	return txn.Set([]byte(makeKey(r.IP.String(), r.Port, r.Service)), val)
+}
+
+// Upsert inserts or updates a record if the timestamp is newer
+func (s *BadgerStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ev StoreEvent
+	err := s.db.Update(func(txn *badger.Txn) error {
+		var err error
+		ev, err = upsertBadger(txn, r)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to upsert record: %w", err)
+	}
+
+	if s.hub.active() {
+		s.hub.publish(ev)
+	}
+	return ev.Type != EventSkipped, nil
+}
+
+// BulkUpsert upserts a batch of records, committing in as few transactions
+// as Badger's transaction size limit allows
+func (s *BadgerStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	records = dedupeRecords(records)
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]StoreEvent, 0, len(records))
+	txn := s.db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+	for _, r := range records {
+		ev, err := upsertBadger(txn, r)
+		if errors.Is(err, badger.ErrTxnTooBig) {
+			// Commit what fits and retry the record in a new transaction
+			if err := txn.Commit(); err != nil {
+				return 0, fmt.Errorf("failed to commit transaction: %w", err)
+			}
+			txn = s.db.NewTransaction(true)
+			ev, err = upsertBadger(txn, r)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to upsert record: %w", err)
+		}
+		events = append(events, ev)
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	updated := 0
+	for _, ev := range events {
+		if ev.Type != EventSkipped {
+			updated++
+		}
+		if s.hub.active() {
+			s.hub.publish(ev)
+		}
+	}
+	return updated, nil
+}
+
+// UpdateIfUnchanged replaces a record if its LastTimestamp equals
+// expectedTimestamp, reading and writing it in one transaction
+func (s *BadgerStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ev *StoreEvent
+	err := s.db.Update(func(txn *badger.Txn) error {
+		existing, err := badgerGet(txn, []byte(makeKey(r.IP.String(), r.Port, r.Service)))
+		if err != nil {
+			return err
+		}
+		if existing == nil || existing.DeletedAt != nil || existing.LastTimestamp != expectedTimestamp {
+			return nil
+		}
+		record := upsertedRecord(r, existing, time.Now())
+		if err := badgerPut(txn, record); err != nil {
+			return err
+		}
+		ev = &StoreEvent{Type: EventUpdated, Record: copyRecord(record), Previous: existing}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to update record: %w", err)
+	}
+	if ev == nil {
+		return false, nil
+	}
+
+	if s.hub.active() {
+		s.hub.publish(*ev)
+	}
+	return true, nil
+}
+
+// upsertBadger stores r in txn if it is newer than the existing record and
+// returns the resulting event
+func upsertBadger(txn *badger.Txn, r *ServiceRecord) (StoreEvent, error) {
+	existing, err := badgerGet(txn, []byte(makeKey(r.IP.String(), r.Port, r.Service)))
+	if err != nil {
+		return StoreEvent{}, err
+	}
+	if existing != nil && r.LastTimestamp <= existing.LastTimestamp {
+		return StoreEvent{Type: EventSkipped, Record: copyRecord(r), Previous: existing}, nil
+	}
+
+	record := upsertedRecord(r, existing, time.Now())
+	if err := badgerPut(txn, record); err != nil {
+		return StoreEvent{}, err
+	}
+
+	if existing != nil {
+		return StoreEvent{Type: EventUpdated, Record: copyRecord(record), Previous: existing}, nil
+	}
+	return StoreEvent{Type: EventCreated, Record: copyRecord(record)}, nil
+}
+
+// Get retrieves a record by its composite key
+func (s *BadgerStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	var r *ServiceRecord
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		r, err = badgerGet(txn, []byte(makeKey(ip, port, service)))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get record: %w", err)
+	}
+	if r == nil || r.DeletedAt != nil {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// scan decodes every record whose key starts with prefix and returns those
+// matching match, in key order
+func (s *BadgerStore) scan(prefix []byte, match func(*ServiceRecord) bool) ([]*ServiceRecord, error) {
+	matched := make([]*ServiceRecord, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			r, err := badgerDecode(it.Item())
+			if err != nil {
+				return err
+			}
+			if match(r) {
+				matched = append(matched, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan records: %w", err)
+	}
+	return matched, nil
+}
+
+// GetMulti retrieves several records in a single read transaction
+func (s *BadgerStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	found := make(map[RecordKey]*ServiceRecord, len(keys))
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, k := range keys {
+			r, err := badgerGet(txn, []byte(makeKey(k.IP, k.Port, k.Service)))
+			if err != nil {
+				return err
+			}
+			if r != nil && r.DeletedAt == nil {
+				found[r.Key()] = r
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get records: %w", err)
+	}
+	return found, nil
+}
+
+// List returns all records with optional pagination
+func (s *BadgerStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(nil, func(*ServiceRecord) bool { return true }, limit, offset)
+}
+
+// ListV2 returns a page of records and the number of live records, taken
+// from one scan
+func (s *BadgerStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	all, err := s.sortedWhere(nil, func(*ServiceRecord) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	return &ListResult{Records: paginate(all, limit, offset), TotalCount: int64(len(all))}, nil
+}
+
+// listWhere returns matching records under prefix in compareListOrder with
+// optional pagination
+// Soft-deleted records are never matched
+func (s *BadgerStore) listWhere(prefix []byte, match func(*ServiceRecord) bool, limit, offset int) ([]*ServiceRecord, error) {
+	all, err := s.sortedWhere(prefix, match)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(all, limit, offset), nil
+}
+
+// sortedWhere returns every live record under prefix that matches, in
+// compareListOrder
+func (s *BadgerStore) sortedWhere(prefix []byte, match func(*ServiceRecord) bool) ([]*ServiceRecord, error) {
+	all, err := s.scan(prefix, func(r *ServiceRecord) bool {
+		return r.DeletedAt == nil && match(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort by timestamp descending, then by composite key
+	sort.SliceStable(all, func(i, j int) bool {
+		return compareListOrder(all[i], all[j]) < 0
+	})
+	return all, nil
+}
+
+// ListByIP returns all records for the given IP address
+// Keys start with the IP, so only that IP's records are scanned; the IP is
+// still compared since an IPv6 prefix can continue with more groups
+func (s *BadgerStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	return s.listWhere([]byte(ip+":"), func(r *ServiceRecord) bool { return r.IP.String() == ip }, 0, 0)
+}
+
+// ListByService returns records for the given service with optional pagination
+func (s *BadgerStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(nil, func(r *ServiceRecord) bool { return r.Service == service }, limit, offset)
+}
+
+// ListByPort returns records for the given port with optional pagination
+func (s *BadgerStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(nil, func(r *ServiceRecord) bool { return r.Port == port }, limit, offset)
+}
+
+// ListByTimestampRange returns records within the given timestamp window
+func (s *BadgerStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(nil, func(r *ServiceRecord) bool {
+		return (from == 0 || r.LastTimestamp >= from) && (to == 0 || r.LastTimestamp <= to)
+	}, limit, offset)
+}
+
+// ListByCIDR returns records whose IP falls within the given CIDR range
+func (s *BadgerStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.listWhere(nil, func(r *ServiceRecord) bool {
+		return cidrContains(network, r.IP.String())
+	}, limit, offset)
+}
+
+// SearchByResponse returns records whose response contains query, ignoring case
+func (s *BadgerStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	query = strings.ToLower(query)
+	return s.listWhere(nil, func(r *ServiceRecord) bool {
+		return strings.Contains(strings.ToLower(r.Response), query)
+	}, limit, offset)
+}
+
+// ListAfter returns records after the given cursor using keyset pagination
+func (s *BadgerStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	// Collect records that come after the cursor
+	page, err := s.scan(nil, func(r *ServiceRecord) bool {
+		return r.DeletedAt == nil && after.precedes(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(page, compareListOrder)
+
+	if limit > 0 && limit < len(page) {
+		page = page[:limit]
+	}
+
+	return page, nil
+}
+
+// ListDistinctIPs returns the unique IPs of live records with optional
+// pagination
+func (s *BadgerStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	ips, err := badgerDistinct(s, recordIP)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(ips, limit, offset), nil
+}
+
+// CountDistinctIPs returns the number of unique IPs of live records
+func (s *BadgerStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	ips, err := badgerDistinct(s, recordIP)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ips)), nil
+}
+
+// ListDistinctServices returns the unique services of live records
+func (s *BadgerStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return badgerDistinct(s, recordService)
+}
+
+// ListDistinctPorts returns the unique ports of live records
+func (s *BadgerStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return badgerDistinct(s, recordPort)
+}
+
+// badgerDistinct returns the sorted unique values of key over the live
+// records of s
+func badgerDistinct[T cmp.Ordered](s *BadgerStore, key func(*ServiceRecord) T) ([]T, error) {
+	live, err := s.scan(nil, func(r *ServiceRecord) bool { return r.DeletedAt == nil })
+	if err != nil {
+		return nil, err
+	}
+	return distinct(live, key), nil
+}
+
+// FindCoOccurrence returns the IPs with a live record matching every filter
+func (s *BadgerStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	live, err := s.scan(nil, func(r *ServiceRecord) bool { return r.DeletedAt == nil })
+	if err != nil {
+		return nil, err
+	}
+	return coOccurring(live, services), nil
+}
+
+// ListChangedSince returns records updated after timestamp with a new
+// response hash
+func (s *BadgerStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	changed, err := s.scan(nil, func(r *ServiceRecord) bool {
+		return r.DeletedAt == nil && r.LastTimestamp > timestamp && hashChanged(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort by timestamp descending, then IP ascending
+	sort.SliceStable(changed, func(i, j int) bool {
+		if changed[i].LastTimestamp != changed[j].LastTimestamp {
+			return changed[i].LastTimestamp > changed[j].LastTimestamp
+		}
+		return changed[i].IP < changed[j].IP
+	})
+
+	return changed, nil
+}
+
+// setDeletedAt sets DeletedAt on the record under the key if its deleted
+// state is currently wantDeleted, reporting whether it changed
+func (s *BadgerStore) setDeletedAt(key string, wantDeleted bool, deletedAt *time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	err := s.db.Update(func(txn *badger.Txn) error {
+		r, err := badgerGet(txn, []byte(key))
+		if err != nil || r == nil || (r.DeletedAt != nil) != wantDeleted {
+			return err
+		}
+		r.DeletedAt = deletedAt
+		changed = true
+		return badgerPut(txn, r)
+	})
+	if err != nil {
+		return false, err
+	}
+	return changed, nil
+}
+
+// Delete soft-deletes a record by setting DeletedAt
+func (s *BadgerStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	now := time.Now()
+	deleted, err := s.setDeletedAt(makeKey(ip, port, service), false, &now)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete record: %w", err)
+	}
+	return deleted, nil
+}
+
+// Undelete clears DeletedAt on a soft-deleted record
+func (s *BadgerStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	restored, err := s.setDeletedAt(makeKey(ip, port, service), true, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to undelete record: %w", err)
+	}
+	return restored, nil
+}
+
+// ListDeleted returns soft-deleted records, most recently deleted first
+func (s *BadgerStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	deleted, err := s.scan(nil, func(r *ServiceRecord) bool { return r.DeletedAt != nil })
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort by deletion time descending, then timestamp descending
+	sort.SliceStable(deleted, func(i, j int) bool {
+		if !deleted[i].DeletedAt.Equal(*deleted[j].DeletedAt) {
+			return deleted[i].DeletedAt.After(*deleted[j].DeletedAt)
+		}
+		return deleted[i].LastTimestamp > deleted[j].LastTimestamp
+	})
+
+	return paginate(deleted, limit, offset), nil
+}
+
+// deleteWhere removes every record matching match and returns how many
+// were removed
+func (s *BadgerStore) deleteWhere(match func(*ServiceRecord) bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Holding mu keeps the matched records from changing before the delete
+	matched, err := s.scan(nil, match)
+	if err != nil {
+		return 0, err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, r := range matched {
+		if err := wb.Delete([]byte(makeKey(r.IP.String(), r.Port, r.Service))); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(len(matched)), nil
+}
+
+// DeleteOlderThan removes records with a timestamp before beforeTimestamp
+// Soft-deleted records are removed as well
+func (s *BadgerStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	deleted, err := s.deleteWhere(func(r *ServiceRecord) bool {
+		return r.LastTimestamp < beforeTimestamp
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old records: %w", err)
+	}
+	return deleted, nil
+}
+
+// PurgeExpired removes records whose ExpiresAt is in the past
+func (s *BadgerStore) PurgeExpired(ctx context.Context) (int64, error) {
+	now := time.Now()
+	purged, err := s.deleteWhere(func(r *ServiceRecord) bool {
+		return r.ExpiresAt != nil && r.ExpiresAt.Before(now)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired records: %w", err)
+	}
+	return purged, nil
+}
+
+// Count returns the total number of records
+func (s *BadgerStore) Count(ctx context.Context) (int64, error) {
+	stats, err := s.Stats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return stats.TotalRecords, nil
+}
+
+// Stats computes aggregate counts from a single scan over the records
+func (s *BadgerStore) Stats(ctx context.Context) (*StoreStats, error) {
+	stats := &StoreStats{
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
+	}
+
+	live, err := s.scan(nil, func(r *ServiceRecord) bool { return r.DeletedAt == nil })
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range live {
+		stats.TotalRecords++
+		stats.RecordsByService[r.Service]++
+		stats.RecordsByPort[r.Port]++
+		if i == 0 || r.LastTimestamp < stats.OldestTimestamp {
+			stats.OldestTimestamp = r.LastTimestamp
+		}
+		if i == 0 || r.LastTimestamp > stats.NewestTimestamp {
+			stats.NewestTimestamp = r.LastTimestamp
+		}
+	}
+
+	return stats, nil
+}
+
+// Watch returns a channel of record change events
+// Only changes made through this store instance are reported; Badger
+// allows a single process per directory, so that is every change
+func (s *BadgerStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.hub.watch(ctx)
+}
+
+// Unwatch stops delivery to a channel returned by Watch and closes it
+func (s *BadgerStore) Unwatch(ch <-chan StoreEvent) {
+	s.hub.unwatch(ch)
+}
+
+// HealthCheck returns an error once the database has been closed
+func (s *BadgerStore) HealthCheck(ctx context.Context) error {
+	if s.db.IsClosed() {
+		return errors.New("database is closed")
+	}
+	return nil
+}
+
+// Close closes any open watch channels and the database
+func (s *BadgerStore) Close() error {
+	s.hub.close()
+	return s.db.Close()
+}
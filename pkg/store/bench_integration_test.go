@@ -0,0 +1,17 @@
+//go:build integration
+
+package store
+
+import "testing"
+
+// BenchmarkPostgresStoreUpsert measures Upsert of new records into a
+// PostgresStore holding 10 000 records
+// Needs the integration build tag and POSTGRES_TEST_URL
+func BenchmarkPostgresStoreUpsert(b *testing.B) {
+	s, err := NewPostgresStore(postgresTestURL(b))
+	if err != nil {
+		b.Fatalf("Failed to create PostgreSQL store: %v", err)
+	}
+	defer s.Close()
+	benchUpsertNew(b, s)
+}
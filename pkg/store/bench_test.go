@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Benchmarks in this file are recorded in BENCHMARKS.md by
+// scripts/benchmarks.sh; rerun it after changing them
+
+// benchWarmRecords is how many records a store holds before timing starts
+const benchWarmRecords = 10000
+
+// benchRecord returns a record with a distinct key for every i
+func benchRecord(i int) *ServiceRecord {
+	return &ServiceRecord{
+		IP:            IPAddress(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)),
+		Port:          uint32(i%1000 + 1),
+		Service:       []string{"HTTP", "SSH", "DNS"}[i%3],
+		LastTimestamp: int64(i + 1),
+		Response:      "bench response",
+	}
+}
+
+// benchRecords returns n records with keys starting at from
+func benchRecords(from, n int) []*ServiceRecord {
+	records := make([]*ServiceRecord, n)
+	for i := range records {
+		records[i] = benchRecord(from + i)
+	}
+	return records
+}
+
+// warmStore fills s with benchWarmRecords records, so timed operations run
+// against indexes of a realistic size
+func warmStore(b *testing.B, s Store) {
+	b.Helper()
+
+	ctx := context.Background()
+	for from := 0; from < benchWarmRecords; from += 1000 {
+		if _, err := s.BulkUpsert(ctx, benchRecords(from, 1000)); err != nil {
+			b.Fatalf("BulkUpsert failed: %v", err)
+		}
+	}
+}
+
+// benchUpsertNew times Upsert of new records into a warmed store
+func benchUpsertNew(b *testing.B, s Store) {
+	warmStore(b, s)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Upsert(ctx, benchRecord(benchWarmRecords+i)); err != nil {
+			b.Fatalf("Upsert failed: %v", err)
+		}
+	}
+}
+
+// benchList1000 times List pages of 1000 records across a warmed store
+func benchList1000(b *testing.B, s Store) {
+	warmStore(b, s)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		offset := i % (benchWarmRecords / 1000) * 1000
+		records, err := s.List(ctx, 1000, offset)
+		if err != nil {
+			b.Fatalf("List failed: %v", err)
+		}
+		if len(records) != 1000 {
+			b.Fatalf("Expected 1000 records, got %d", len(records))
+		}
+	}
+}
+
+// benchBulkUpsert times BulkUpsert of batches of size new records into a
+// warmed store, and reports the cost per record
+func benchBulkUpsert(b *testing.B, s Store, size int) {
+	warmStore(b, s)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batch := benchRecords(benchWarmRecords+i*size, size)
+		if _, err := s.BulkUpsert(ctx, batch); err != nil {
+			b.Fatalf("BulkUpsert failed: %v", err)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*size), "ns/record")
+}
+
+// benchBackends are the stores measured by the batching benchmarks; the
+// network stores need a server and have their own benchmarks
+var benchBackends = []struct {
+	name string
+	open func(b *testing.B) Store
+}{
+	{"Memory", func(*testing.B) Store { return NewMemoryStore() }},
+	{"SQLite", func(b *testing.B) Store { return newBenchSQLiteStore(b) }},
+}
+
+// BenchmarkMemoryStoreUpsert measures Upsert of new records into a
+// MemoryStore holding 10 000 records
+func BenchmarkMemoryStoreUpsert(b *testing.B) {
+	benchUpsertNew(b, NewMemoryStore())
+}
+
+// BenchmarkSQLiteStoreUpsert measures Upsert of new records into a
+// SQLiteStore holding 10 000 records
+func BenchmarkSQLiteStoreUpsert(b *testing.B) {
+	benchUpsertNew(b, newBenchSQLiteStore(b))
+}
+
+// BenchmarkMemoryStoreList1000 measures List pages of 1000 records from a
+// MemoryStore holding 10 000 records
+func BenchmarkMemoryStoreList1000(b *testing.B) {
+	benchList1000(b, NewMemoryStore())
+}
+
+// BenchmarkSQLiteStoreList1000 measures List pages of 1000 records from a
+// SQLiteStore holding 10 000 records
+func BenchmarkSQLiteStoreList1000(b *testing.B) {
+	benchList1000(b, newBenchSQLiteStore(b))
+}
+
+// BenchmarkBulkUpsert100 measures BulkUpsert of 100 record batches
+func BenchmarkBulkUpsert100(b *testing.B) {
+	for _, backend := range benchBackends {
+		b.Run(backend.name, func(b *testing.B) {
+			benchBulkUpsert(b, backend.open(b), 100)
+		})
+	}
+}
+
+// BenchmarkBulkUpsert1000 measures BulkUpsert of 1000 record batches
+func BenchmarkBulkUpsert1000(b *testing.B) {
+	for _, backend := range benchBackends {
+		b.Run(backend.name, func(b *testing.B) {
+			benchBulkUpsert(b, backend.open(b), 1000)
+		})
+	}
+}
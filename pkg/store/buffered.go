@@ -0,0 +1,408 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BufferedStore is a Store that collects upserts in memory and writes them
+// to the store it wraps in batches with BulkUpsert
+// Only the newest record per key is kept, so a key scanned many times
+// between flushes costs a single write
+// Get sees buffered records before they are flushed; other reads go to the
+// wrapped store and do not see them until the next flush
+type BufferedStore struct {
+	inner      Store
+	maxBufSize int
+	interval   time.Duration
+
+	mu       sync.Mutex
+	buf      map[string]*ServiceRecord
+	flushing map[string]*ServiceRecord // records being written by Flush
+	closed   bool
+
+	flushMu sync.Mutex // serializes flushes so batches are written in order
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewBufferedStore wraps inner and starts a goroutine that flushes the
+// buffer every flushInterval, or sooner once maxBufSize records are waiting
+// Close must be called to flush the remaining records and stop the goroutine
+func NewBufferedStore(inner Store, maxBufSize int, flushInterval time.Duration) (*BufferedStore, error) {
+	if maxBufSize <= 0 {
+		return nil, fmt.Errorf("invalid buffer size: %d", maxBufSize)
+	}
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("invalid flush interval: %s", flushInterval)
+	}
+
+	s := &BufferedStore{
+		inner:      inner,
+		maxBufSize: maxBufSize,
+		interval:   flushInterval,
+		buf:        make(map[string]*ServiceRecord),
+		full:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// run flushes the buffer on every tick or when Upsert reports it full,
+// until Close is called
+func (s *BufferedStore) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.full:
+		}
+		if err := s.Flush(context.Background()); err != nil {
+			slog.Warn("failed to flush buffered records", slog.Any("error", err))
+		}
+	}
+}
+
+// bufferedLocked returns the newest buffered record for key, or nil
+// Caller must hold mu
+func (s *BufferedStore) bufferedLocked(key string) *ServiceRecord {
+	if r, ok := s.buf[key]; ok {
+		return r
+	}
+	return s.flushing[key]
+}
+
+// bufferLocked adds a copy of r unless a record at least as new is already
+// buffered, and reports whether it was added
+// Caller must hold mu
+func (s *BufferedStore) bufferLocked(r *ServiceRecord) bool {
+	key := makeKey(r.IP.String(), r.Port, r.Service)
+	if existing := s.bufferedLocked(key); existing != nil && r.LastTimestamp <= existing.LastTimestamp {
+		return false
+	}
+	s.buf[key] = copyRecord(canonicalRecord(r))
+	return true
+}
+
+// signalFullLocked wakes the flush goroutine once the buffer is full
+// Caller must hold mu
+func (s *BufferedStore) signalFullLocked() {
+	if len(s.buf) < s.maxBufSize {
+		return
+	}
+	select {
+	case s.full <- struct{}{}:
+	default:
+	}
+}
+
+// Upsert buffers the record until the next flush
+// Returns false if a record at least as new is already buffered; a buffered
+// record older than the stored one is still skipped when it is flushed
+func (s *BufferedStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false, errors.New("buffered store is closed")
+	}
+	buffered := s.bufferLocked(r)
+	s.signalFullLocked()
+	return buffered, nil
+}
+
+// BulkUpsert buffers a batch of records until the next flush
+// Returns the number of records buffered, with the same caveat as Upsert
+func (s *BufferedStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, errors.New("buffered store is closed")
+	}
+	buffered := 0
+	for _, r := range dedupeRecords(records) {
+		if s.bufferLocked(r) {
+			buffered++
+		}
+	}
+	s.signalFullLocked()
+	return buffered, nil
+}
+
+// Flush writes every buffered record to the wrapped store
+// Records that fail to be written stay buffered for the next flush unless a
+// newer record for the same key has been buffered since
+func (s *BufferedStore) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if len(s.buf) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	// Keep the batch visible to Get until it is written
+	s.flushing = s.buf
+	s.buf = make(map[string]*ServiceRecord)
+	records := make([]*ServiceRecord, 0, len(s.flushing))
+	for _, r := range s.flushing {
+		records = append(records, r)
+	}
+	s.mu.Unlock()
+
+	_, err := s.inner.BulkUpsert(ctx, records)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		for key, r := range s.flushing {
+			if newer, ok := s.buf[key]; !ok || r.LastTimestamp > newer.LastTimestamp {
+				s.buf[key] = r
+			}
+		}
+	}
+	s.flushing = nil
+	if err != nil {
+		return fmt.Errorf("failed to flush %d buffered records: %w", len(records), err)
+	}
+	return nil
+}
+
+// Len returns the number of records waiting to be flushed
+func (s *BufferedStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buf) + len(s.flushing)
+}
+
+// UpdateIfUnchanged flushes the buffer, so the expected timestamp is
+// compared with the latest record, then updates the wrapped store
+func (s *BufferedStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	if err := s.Flush(ctx); err != nil {
+		return false, err
+	}
+	return s.inner.UpdateIfUnchanged(ctx, r, expectedTimestamp)
+}
+
+// Get returns a copy of the buffered record if there is one, otherwise reads
+// it from the wrapped store
+// A buffered record does not yet have the fields the store fills in, such
+// as UpdatedAt, ScanCount and PreviousResponse
+func (s *BufferedStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	s.mu.Lock()
+	r := s.bufferedLocked(makeKey(ip, port, service))
+	if r != nil {
+		r = copyRecord(r)
+	}
+	s.mu.Unlock()
+
+	if r != nil {
+		return r, nil
+	}
+	return s.inner.Get(ctx, ip, port, service)
+}
+
+// GetMulti returns copies of the buffered records for keys and reads the
+// rest from the wrapped store, as Get does
+func (s *BufferedStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	found := make(map[RecordKey]*ServiceRecord, len(keys))
+	var unbuffered []RecordKey
+	s.mu.Lock()
+	for _, k := range keys {
+		if r := s.bufferedLocked(makeKey(k.IP, k.Port, k.Service)); r != nil {
+			found[r.Key()] = copyRecord(r)
+		} else {
+			unbuffered = append(unbuffered, k)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(unbuffered) == 0 {
+		return found, nil
+	}
+	stored, err := s.inner.GetMulti(ctx, unbuffered)
+	if err != nil {
+		return nil, err
+	}
+	for k, r := range stored {
+		found[k] = r
+	}
+	return found, nil
+}
+
+// List reads from the wrapped store
+func (s *BufferedStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.List(ctx, limit, offset)
+}
+
+// ListV2 reads from the wrapped store
+func (s *BufferedStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	return s.inner.ListV2(ctx, limit, offset)
+}
+
+// ListByIP reads from the wrapped store
+func (s *BufferedStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return s.inner.ListByIP(ctx, ip)
+}
+
+// ListByService reads from the wrapped store
+func (s *BufferedStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByService(ctx, service, limit, offset)
+}
+
+// ListByPort reads from the wrapped store
+func (s *BufferedStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByPort(ctx, port, limit, offset)
+}
+
+// ListByTimestampRange reads from the wrapped store
+func (s *BufferedStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByTimestampRange(ctx, from, to, limit, offset)
+}
+
+// ListByCIDR reads from the wrapped store
+func (s *BufferedStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByCIDR(ctx, cidr, limit, offset)
+}
+
+// SearchByResponse reads from the wrapped store
+func (s *BufferedStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.SearchByResponse(ctx, query, limit, offset)
+}
+
+// ListAfter reads from the wrapped store
+func (s *BufferedStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return s.inner.ListAfter(ctx, after, limit)
+}
+
+// ListDistinctIPs reads from the wrapped store
+func (s *BufferedStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	return s.inner.ListDistinctIPs(ctx, limit, offset)
+}
+
+// CountDistinctIPs reads from the wrapped store
+func (s *BufferedStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	return s.inner.CountDistinctIPs(ctx)
+}
+
+// ListDistinctServices reads from the wrapped store
+func (s *BufferedStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return s.inner.ListDistinctServices(ctx)
+}
+
+// ListDistinctPorts reads from the wrapped store
+func (s *BufferedStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return s.inner.ListDistinctPorts(ctx)
+}
+
+// FindCoOccurrence reads from the wrapped store
+func (s *BufferedStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	return s.inner.FindCoOccurrence(ctx, services)
+}
+
+// ListChangedSince reads from the wrapped store
+func (s *BufferedStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.inner.ListChangedSince(ctx, timestamp)
+}
+
+// Delete flushes the buffer, so earlier upserts are not applied after the
+// delete, then soft-deletes the record in the wrapped store
+func (s *BufferedStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	if err := s.Flush(ctx); err != nil {
+		return false, err
+	}
+	return s.inner.Delete(ctx, ip, port, service)
+}
+
+// Undelete flushes the buffer, then restores the record in the wrapped store
+func (s *BufferedStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	if err := s.Flush(ctx); err != nil {
+		return false, err
+	}
+	return s.inner.Undelete(ctx, ip, port, service)
+}
+
+// ListDeleted reads from the wrapped store
+func (s *BufferedStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListDeleted(ctx, limit, offset)
+}
+
+// DeleteOlderThan flushes the buffer, so old buffered records are removed
+// too, then deletes from the wrapped store
+func (s *BufferedStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	if err := s.Flush(ctx); err != nil {
+		return 0, err
+	}
+	return s.inner.DeleteOlderThan(ctx, beforeTimestamp)
+}
+
+// PurgeExpired flushes the buffer, then purges the wrapped store
+func (s *BufferedStore) PurgeExpired(ctx context.Context) (int64, error) {
+	if err := s.Flush(ctx); err != nil {
+		return 0, err
+	}
+	return s.inner.PurgeExpired(ctx)
+}
+
+// Count reads from the wrapped store
+func (s *BufferedStore) Count(ctx context.Context) (int64, error) {
+	return s.inner.Count(ctx)
+}
+
+// Stats reads from the wrapped store
+func (s *BufferedStore) Stats(ctx context.Context) (*StoreStats, error) {
+	return s.inner.Stats(ctx)
+}
+
+// Watch calls the wrapped store, so events are sent as records are flushed
+func (s *BufferedStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.inner.Watch(ctx)
+}
+
+// Unwatch calls the wrapped store
+func (s *BufferedStore) Unwatch(ch <-chan StoreEvent) {
+	s.inner.Unwatch(ch)
+}
+
+// HealthCheck calls the wrapped store
+func (s *BufferedStore) HealthCheck(ctx context.Context) error {
+	return s.inner.HealthCheck(ctx)
+}
+
+// Close stops the flush goroutine, writes the remaining buffered records and
+// closes the wrapped store
+// Upserts after Close return an error
+func (s *BufferedStore) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+
+		close(s.stop)
+		<-s.done
+
+		err = errors.Join(s.Flush(context.Background()), s.inner.Close())
+	})
+	return err
+}
+
+// Unwrap returns the wrapped store
+func (s *BufferedStore) Unwrap() Store {
+	return s.inner
+}
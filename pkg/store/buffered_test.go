@@ -0,0 +1,252 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingBulkStore fails BulkUpsert while err is set
+type failingBulkStore struct {
+	Store
+	mu  sync.Mutex
+	err error
+}
+
+func (s *failingBulkStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return s.Store.BulkUpsert(ctx, records)
+}
+
+// waitFor polls cond until it is true or a second has passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestBufferedStore tests read-your-writes before a flush and that Close
+// writes the remaining records
+func TestBufferedStore(t *testing.T) {
+	inner := NewMemoryStore()
+	s, err := NewBufferedStore(inner, 100, time.Hour)
+	if err != nil {
+		t.Fatalf("NewBufferedStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if ok, _ := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "new"}); !ok {
+		t.Error("Expected first upsert to be buffered")
+	}
+	if ok, _ := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "old"}); ok {
+		t.Error("Expected older upsert not to be buffered")
+	}
+	n, _ := s.BulkUpsert(ctx, []*ServiceRecord{
+		{IP: "2.2.2.2", Port: 22, Service: "SSH", LastTimestamp: 1000},
+		{IP: "2.2.2.2", Port: 22, Service: "SSH", LastTimestamp: 3000, Response: "ssh"},
+	})
+	if n != 1 {
+		t.Errorf("Expected 1 record buffered, got %d", n)
+	}
+
+	if inner.Len() != 0 {
+		t.Errorf("Expected nothing written before flush, got %d records", inner.Len())
+	}
+	if s.Len() != 2 {
+		t.Errorf("Expected 2 buffered records, got %d", s.Len())
+	}
+	if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r == nil || r.Response != "new" {
+		t.Errorf("Expected buffered record from Get, got %+v", r)
+	}
+	if r, _ := s.Get(ctx, "2.2.2.2", 22, "SSH"); r == nil || r.LastTimestamp != 3000 {
+		t.Errorf("Expected newest buffered record from Get, got %+v", r)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if inner.Len() != 2 {
+		t.Errorf("Expected Close to flush 2 records, got %d", inner.Len())
+	}
+	if r, _ := inner.Get(ctx, "1.1.1.1", 80, "HTTP"); r == nil || r.Response != "new" || r.ScanCount != 1 {
+		t.Errorf("Expected flushed record, got %+v", r)
+	}
+	if _, err := s.Upsert(ctx, &ServiceRecord{IP: "3.3.3.3", Port: 80, Service: "HTTP", LastTimestamp: 1000}); err == nil {
+		t.Error("Expected Upsert after Close to fail")
+	}
+}
+
+// TestBufferedStoreFlushTriggers tests flushing on a full buffer and on the
+// flush interval
+func TestBufferedStoreFlushTriggers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("size", func(t *testing.T) {
+		inner := NewMemoryStore()
+		s, _ := NewBufferedStore(inner, 10, time.Hour)
+		defer s.Close()
+
+		for i := 0; i < 9; i++ {
+			s.Upsert(ctx, &ServiceRecord{IP: IPAddress(fmt.Sprintf("10.0.0.%d", i)), Port: 80, Service: "HTTP", LastTimestamp: 1000})
+		}
+		time.Sleep(10 * time.Millisecond)
+		if inner.Len() != 0 {
+			t.Errorf("Expected no flush below the buffer size, got %d records", inner.Len())
+		}
+		s.Upsert(ctx, &ServiceRecord{IP: "10.0.0.9", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+		waitFor(t, func() bool { return inner.Len() == 10 })
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		inner := NewMemoryStore()
+		s, _ := NewBufferedStore(inner, 100, 10*time.Millisecond)
+		defer s.Close()
+
+		s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+		waitFor(t, func() bool { return inner.Len() == 1 })
+		if s.Len() != 0 {
+			t.Errorf("Expected empty buffer after flush, got %d", s.Len())
+		}
+	})
+}
+
+// TestBufferedStoreFlushError tests that records stay buffered when the
+// wrapped store fails and are written by a later flush
+func TestBufferedStoreFlushError(t *testing.T) {
+	inner := &failingBulkStore{Store: NewMemoryStore(), err: errors.New("unavailable")}
+	s, _ := NewBufferedStore(inner, 100, time.Hour)
+	ctx := context.Background()
+
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "a"})
+	if err := s.Flush(ctx); err == nil {
+		t.Fatal("Expected Flush to fail")
+	}
+	if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r == nil || r.Response != "a" {
+		t.Errorf("Expected record to stay buffered, got %+v", r)
+	}
+
+	inner.mu.Lock()
+	inner.err = nil
+	inner.mu.Unlock()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if r, _ := inner.Get(ctx, "1.1.1.1", 80, "HTTP"); r == nil || r.Response != "a" {
+		t.Errorf("Expected record to be flushed on Close, got %+v", r)
+	}
+}
+
+// TestBufferedStoreConcurrent upserts and reads from many goroutines while
+// the buffer is flushed, then checks that the newest record for every key
+// was written
+func TestBufferedStoreConcurrent(t *testing.T) {
+	inner := NewMemoryStore()
+	s, _ := NewBufferedStore(inner, 50, time.Millisecond)
+	ctx := context.Background()
+
+	const workers, keys, rounds = 8, 20, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				for k := 0; k < keys; k++ {
+					ip := fmt.Sprintf("10.0.0.%d", k)
+					ts := int64(i*workers + w + 1)
+					s.Upsert(ctx, &ServiceRecord{IP: IPAddress(ip), Port: 80, Service: "HTTP", LastTimestamp: ts})
+					if r, err := s.Get(ctx, ip, 80, "HTTP"); err != nil || r == nil {
+						t.Errorf("Expected to read back %s, got %+v (err %v)", ip, r, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if inner.Len() != keys {
+		t.Errorf("Expected %d records, got %d", keys, inner.Len())
+	}
+	for k := 0; k < keys; k++ {
+		r, _ := inner.Get(ctx, fmt.Sprintf("10.0.0.%d", k), 80, "HTTP")
+		if r == nil || r.LastTimestamp != rounds*workers {
+			t.Errorf("Expected newest timestamp %d, got %+v", rounds*workers, r)
+		}
+	}
+}
+
+// TestNewBufferedStoreInvalid tests that invalid options are rejected
+func TestNewBufferedStoreInvalid(t *testing.T) {
+	if _, err := NewBufferedStore(NewMemoryStore(), 0, time.Second); err == nil {
+		t.Error("Expected error for zero buffer size")
+	}
+	if _, err := NewBufferedStore(NewMemoryStore(), 10, 0); err == nil {
+		t.Error("Expected error for zero flush interval")
+	}
+}
+
+// BenchmarkBufferedSQLiteUpsert100 upserts batches of 100 records one at a
+// time through a BufferedStore, for comparison with BenchmarkSQLiteUpsert100
+func BenchmarkBufferedSQLiteUpsert100(b *testing.B) {
+	s, err := NewBufferedStore(newBenchSQLiteStore(b), 1000, 100*time.Millisecond)
+	if err != nil {
+		b.Fatalf("NewBufferedStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, r := range benchBatch(i) {
+			if _, err := s.Upsert(ctx, r); err != nil {
+				b.Fatalf("Upsert failed: %v", err)
+			}
+		}
+	}
+	if err := s.Close(); err != nil {
+		b.Fatalf("Close failed: %v", err)
+	}
+}
+
+// BenchmarkBufferedStoreConcurrentUpsert upserts from 10 goroutines at once
+// into a BufferedStore over SQLite
+func BenchmarkBufferedStoreConcurrentUpsert(b *testing.B) {
+	s, err := NewBufferedStore(newBenchSQLiteStore(b), 1000, 100*time.Millisecond)
+	if err != nil {
+		b.Fatalf("NewBufferedStore failed: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.SetParallelism(10)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			r := &ServiceRecord{IP: IPAddress(fmt.Sprintf("10.1.%d.%d", i/256%256, i%256)), Port: 80, Service: "HTTP", LastTimestamp: int64(i), Response: "bench"}
+			if _, err := s.Upsert(ctx, r); err != nil {
+				b.Errorf("Upsert failed: %v", err)
+				return
+			}
+			i++
+		}
+	})
+}
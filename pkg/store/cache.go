@@ -0,0 +1,353 @@
+package store
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/jonboulle/clockwork"
+)
+
+// cacheEntry is a cached record and when it stops being served
+type cacheEntry struct {
+	record  *ServiceRecord
+	expires time.Time
+}
+
+// cacheStripes is the number of write generations a CachingStore keeps
+// Keys share a generation by hash, so a write can cost a concurrent read of
+// another key its fill but never lets a stale record be cached
+const cacheStripes = 64
+
+// CachingStore is a Store that serves Get from an LRU cache in front of the
+// store it wraps
+// Writes through this store evict the affected entries, but writes made
+// elsewhere, such as by another processor sharing the database, are only
+// seen once an entry's ttl has passed
+// Reads other than Get and GetMulti bypass the cache
+type CachingStore struct {
+	inner Store
+	cache *lru.Cache[string, cacheEntry]
+	ttl   time.Duration
+	clock clockwork.Clock
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	// gens counts the evictions of each stripe of keys so that a record read
+	// while its key was being written is not cached; mu orders fills against
+	// evictions
+	mu   sync.Mutex
+	gens [cacheStripes]uint64
+}
+
+// NewCachingStore wraps inner with a cache of up to maxEntries records, each
+// served for at most ttl; a zero ttl keeps entries until they are evicted
+// maxEntries must be positive
+func NewCachingStore(inner Store, maxEntries int, ttl time.Duration) Store {
+	return newCachingStore(inner, maxEntries, ttl, clockwork.NewRealClock())
+}
+
+// newCachingStore creates a CachingStore that reads the time from clock
+func newCachingStore(inner Store, maxEntries int, ttl time.Duration, clock clockwork.Clock) *CachingStore {
+	cache, err := lru.New[string, cacheEntry](maxEntries)
+	if err != nil {
+		panic("store: invalid cache size: " + err.Error())
+	}
+	return &CachingStore{inner: inner, cache: cache, ttl: ttl, clock: clock}
+}
+
+// CacheStats returns the number of records served from the cache by Get and
+// GetMulti and the number read from the wrapped store
+func (s *CachingStore) CacheStats() (hits, misses int64) {
+	return s.hits.Load(), s.misses.Load()
+}
+
+// cacheStripe returns the index of the write generation for key
+func cacheStripe(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % cacheStripes)
+}
+
+// generation returns the write generation of key, to be passed to add
+func (s *CachingStore) generation(key string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gens[cacheStripe(key)]
+}
+
+// evict removes the cached record for a key and stops reads already under
+// way from caching it
+func (s *CachingStore) evict(ip string, port uint32, service string) {
+	key := makeKey(ip, port, service)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gens[cacheStripe(key)]++
+	s.cache.Remove(key)
+}
+
+// purge empties the cache and stops reads already under way from caching
+// what they find
+func (s *CachingStore) purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.gens {
+		s.gens[i]++
+	}
+	s.cache.Purge()
+}
+
+// Upsert writes through to the wrapped store and evicts the cached record
+// The store fills in fields such as ScanCount and PreviousResponse, so the
+// record is not cached from r; the next Get reads it back instead
+func (s *CachingStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	updated, err := s.inner.Upsert(ctx, r)
+	if updated || err != nil {
+		s.evict(r.IP.String(), r.Port, r.Service)
+	}
+	return updated, err
+}
+
+// BulkUpsert writes through to the wrapped store and evicts every record in
+// the batch
+func (s *CachingStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	updated, err := s.inner.BulkUpsert(ctx, records)
+	for _, r := range records {
+		s.evict(r.IP.String(), r.Port, r.Service)
+	}
+	return updated, err
+}
+
+// UpdateIfUnchanged updates the wrapped store and evicts the record
+func (s *CachingStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	updated, err := s.inner.UpdateIfUnchanged(ctx, r, expectedTimestamp)
+	s.evict(r.IP.String(), r.Port, r.Service)
+	return updated, err
+}
+
+// Get returns the cached record if it has not expired, otherwise reads it
+// from the wrapped store and caches it
+// Missing records are not cached
+func (s *CachingStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	key := makeKey(ip, port, service)
+	if r := s.cached(key); r != nil {
+		return r, nil
+	}
+
+	gen := s.generation(key)
+	r, err := s.inner.Get(ctx, ip, port, service)
+	if err != nil || r == nil {
+		return r, err
+	}
+	s.add(key, r, gen)
+	return r, nil
+}
+
+// GetMulti serves the keys that are cached and reads the rest from the
+// wrapped store in a single call, caching what it finds
+// Each key counts as one hit or miss in CacheStats
+func (s *CachingStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	found := make(map[RecordKey]*ServiceRecord, len(keys))
+	var missed []RecordKey
+	for _, k := range keys {
+		if r := s.cached(makeKey(k.IP, k.Port, k.Service)); r != nil {
+			found[r.Key()] = r
+		} else {
+			missed = append(missed, k)
+		}
+	}
+
+	if len(missed) == 0 {
+		return found, nil
+	}
+	gens := make(map[string]uint64, len(missed))
+	for _, k := range missed {
+		key := makeKey(k.IP, k.Port, k.Service)
+		gens[key] = s.generation(key)
+	}
+	stored, err := s.inner.GetMulti(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	for k, r := range stored {
+		key := makeKey(k.IP, k.Port, k.Service)
+		s.add(key, r, gens[key])
+		found[k] = r
+	}
+	return found, nil
+}
+
+// cached returns a copy of the cached record for key if it has not
+// expired, counting the hit or miss
+func (s *CachingStore) cached(key string) *ServiceRecord {
+	if entry, ok := s.cache.Get(key); ok {
+		if s.ttl <= 0 || s.clock.Now().Before(entry.expires) {
+			s.hits.Add(1)
+			return copyRecord(entry.record)
+		}
+		s.cache.Remove(key)
+	}
+	s.misses.Add(1)
+	return nil
+}
+
+// add caches a copy of r under key unless the key may have been written
+// since generation gen, when r could be stale
+func (s *CachingStore) add(key string, r *ServiceRecord, gen uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gens[cacheStripe(key)] != gen {
+		return
+	}
+	s.cache.Add(key, cacheEntry{record: copyRecord(r), expires: s.clock.Now().Add(s.ttl)})
+}
+
+// List bypasses the cache
+func (s *CachingStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.List(ctx, limit, offset)
+}
+
+// ListV2 bypasses the cache
+func (s *CachingStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	return s.inner.ListV2(ctx, limit, offset)
+}
+
+// ListByIP bypasses the cache
+func (s *CachingStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return s.inner.ListByIP(ctx, ip)
+}
+
+// ListByService bypasses the cache
+func (s *CachingStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByService(ctx, service, limit, offset)
+}
+
+// ListByPort bypasses the cache
+func (s *CachingStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByPort(ctx, port, limit, offset)
+}
+
+// ListByTimestampRange bypasses the cache
+func (s *CachingStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByTimestampRange(ctx, from, to, limit, offset)
+}
+
+// ListByCIDR bypasses the cache
+func (s *CachingStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByCIDR(ctx, cidr, limit, offset)
+}
+
+// SearchByResponse bypasses the cache
+func (s *CachingStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.SearchByResponse(ctx, query, limit, offset)
+}
+
+// ListAfter bypasses the cache
+func (s *CachingStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return s.inner.ListAfter(ctx, after, limit)
+}
+
+// ListDistinctIPs bypasses the cache
+func (s *CachingStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	return s.inner.ListDistinctIPs(ctx, limit, offset)
+}
+
+// CountDistinctIPs bypasses the cache
+func (s *CachingStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	return s.inner.CountDistinctIPs(ctx)
+}
+
+// ListDistinctServices bypasses the cache
+func (s *CachingStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return s.inner.ListDistinctServices(ctx)
+}
+
+// ListDistinctPorts bypasses the cache
+func (s *CachingStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return s.inner.ListDistinctPorts(ctx)
+}
+
+// FindCoOccurrence bypasses the cache
+func (s *CachingStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	return s.inner.FindCoOccurrence(ctx, services)
+}
+
+// ListChangedSince bypasses the cache
+func (s *CachingStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.inner.ListChangedSince(ctx, timestamp)
+}
+
+// Delete soft-deletes the record in the wrapped store and evicts it
+func (s *CachingStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	deleted, err := s.inner.Delete(ctx, ip, port, service)
+	s.evict(ip, port, service)
+	return deleted, err
+}
+
+// Undelete restores the record in the wrapped store and evicts it
+func (s *CachingStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	restored, err := s.inner.Undelete(ctx, ip, port, service)
+	s.evict(ip, port, service)
+	return restored, err
+}
+
+// ListDeleted bypasses the cache
+func (s *CachingStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListDeleted(ctx, limit, offset)
+}
+
+// DeleteOlderThan deletes from the wrapped store and empties the cache,
+// since the deleted keys are not known
+func (s *CachingStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	deleted, err := s.inner.DeleteOlderThan(ctx, beforeTimestamp)
+	s.purge()
+	return deleted, err
+}
+
+// PurgeExpired purges the wrapped store and empties the cache, since the
+// purged keys are not known
+func (s *CachingStore) PurgeExpired(ctx context.Context) (int64, error) {
+	purged, err := s.inner.PurgeExpired(ctx)
+	s.purge()
+	return purged, err
+}
+
+// Count bypasses the cache
+func (s *CachingStore) Count(ctx context.Context) (int64, error) {
+	return s.inner.Count(ctx)
+}
+
+// Stats bypasses the cache
+func (s *CachingStore) Stats(ctx context.Context) (*StoreStats, error) {
+	return s.inner.Stats(ctx)
+}
+
+// Watch calls the wrapped store
+func (s *CachingStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.inner.Watch(ctx)
+}
+
+// Unwatch calls the wrapped store
+func (s *CachingStore) Unwatch(ch <-chan StoreEvent) {
+	s.inner.Unwatch(ch)
+}
+
+// HealthCheck calls the wrapped store
+func (s *CachingStore) HealthCheck(ctx context.Context) error {
+	return s.inner.HealthCheck(ctx)
+}
+
+// Close empties the cache and closes the wrapped store
+func (s *CachingStore) Close() error {
+	s.purge()
+	return s.inner.Close()
+}
+
+// Unwrap returns the wrapped store
+func (s *CachingStore) Unwrap() Store {
+	return s.inner
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// countingStore counts Get calls and keys passed to GetMulti that reach the
+// wrapped store
+type countingStore struct {
+	Store
+	gets      int
+	multiKeys int
+}
+
+func (s *countingStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	s.gets++
+	return s.Store.Get(ctx, ip, port, service)
+}
+
+func (s *countingStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	s.multiKeys += len(keys)
+	return s.Store.GetMulti(ctx, keys)
+}
+
+// pausingStore pauses the first Get after reading the record, until
+// release is closed, to let a write land in between
+type pausingStore struct {
+	Store
+	once    sync.Once
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *pausingStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	r, err := s.Store.Get(ctx, ip, port, service)
+	s.once.Do(func() {
+		close(s.read)
+		<-s.release
+	})
+	return r, err
+}
+
+// TestCachingStore tests cache hits, eviction on writes and TTL expiry
+func TestCachingStore(t *testing.T) {
+	inner := &countingStore{Store: NewMemoryStore()}
+	clock := clockwork.NewFakeClock()
+	s := newCachingStore(inner, 10, time.Minute, clock)
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "first"})
+
+	// The first Get reads through, the second is served from the cache
+	for i := 0; i < 2; i++ {
+		r, err := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+		if err != nil || r == nil || r.Response != "first" {
+			t.Fatalf("Expected cached record, got %+v (err %v)", r, err)
+		}
+	}
+	if hits, misses := s.CacheStats(); hits != 1 || misses != 1 || inner.gets != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %d hits, %d misses and %d inner gets", hits, misses, inner.gets)
+	}
+
+	// Returned records are copies of the cached one
+	r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+	r.Response = "mutated"
+	if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r.Response != "first" {
+		t.Errorf("Expected cached record to be unaffected, got %q", r.Response)
+	}
+
+	// An accepted upsert evicts the entry, so the new record is read through
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "second"})
+	if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r == nil || r.Response != "second" || r.ScanCount != 2 {
+		t.Errorf("Expected updated record after upsert, got %+v", r)
+	}
+
+	// A change behind the cache is served stale until the TTL passes
+	inner.Store.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 3000, Response: "third"})
+	clock.Advance(59 * time.Second)
+	if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r.Response != "second" {
+		t.Errorf("Expected stale record within TTL, got %q", r.Response)
+	}
+	clock.Advance(2 * time.Second)
+	gets := inner.gets
+	if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r.Response != "third" {
+		t.Errorf("Expected expired entry to be refreshed, got %q", r.Response)
+	}
+	if inner.gets != gets+1 {
+		t.Errorf("Expected expired entry to read through")
+	}
+
+	// Delete evicts the entry
+	s.Delete(ctx, "1.1.1.1", 80, "HTTP")
+	if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r != nil {
+		t.Errorf("Expected deleted record to be gone, got %+v", r)
+	}
+}
+
+// TestCachingStoreGetMulti tests that GetMulti serves cached keys and reads
+// only the rest from the wrapped store
+func TestCachingStoreGetMulti(t *testing.T) {
+	inner := &countingStore{Store: NewMemoryStore()}
+	s := newCachingStore(inner, 10, time.Minute, clockwork.NewFakeClock())
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+	s.Upsert(ctx, &ServiceRecord{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+	s.Get(ctx, "1.1.1.1", 80, "HTTP")
+
+	keys := []RecordKey{
+		{IP: "1.1.1.1", Port: 80, Service: "HTTP"},
+		{IP: "2.2.2.2", Port: 80, Service: "HTTP"},
+		{IP: "3.3.3.3", Port: 80, Service: "HTTP"},
+	}
+	found, err := s.GetMulti(ctx, keys)
+	if err != nil || len(found) != 2 {
+		t.Fatalf("Expected 2 records, got %v (err %v)", found, err)
+	}
+	if inner.multiKeys != 2 {
+		t.Errorf("Expected only the 2 uncached keys to read through, got %d", inner.multiKeys)
+	}
+
+	// Both found records are now cached; the missing one is not
+	if _, err := s.GetMulti(ctx, keys); err != nil {
+		t.Fatalf("GetMulti failed: %v", err)
+	}
+	if inner.multiKeys != 3 {
+		t.Errorf("Expected only the missing key to read through again, got %d", inner.multiKeys)
+	}
+	if hits, misses := s.CacheStats(); hits != 3 || misses != 4 {
+		t.Errorf("Expected 3 hits and 4 misses, got %d hits and %d misses", hits, misses)
+	}
+}
+
+// TestCachingStoreLRU tests that the least recently used entry is evicted
+// once the cache is full
+func TestCachingStoreLRU(t *testing.T) {
+	inner := &countingStore{Store: NewMemoryStore()}
+	s := newCachingStore(inner, 2, 0, clockwork.NewFakeClock())
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"} {
+		s.Upsert(ctx, &ServiceRecord{IP: IPAddress(ip), Port: 80, Service: "HTTP", LastTimestamp: 1000})
+		s.Get(ctx, ip, 80, "HTTP")
+	}
+
+	gets := inner.gets
+	s.Get(ctx, "3.3.3.3", 80, "HTTP")
+	s.Get(ctx, "2.2.2.2", 80, "HTTP")
+	if inner.gets != gets {
+		t.Errorf("Expected recent entries to be cached")
+	}
+	s.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if inner.gets != gets+1 {
+		t.Errorf("Expected least recently used entry to be evicted")
+	}
+}
+
+// TestCachingStoreStaleFill tests that a record read while its key is
+// written is not cached over the new record
+func TestCachingStoreStaleFill(t *testing.T) {
+	inner := &pausingStore{Store: NewMemoryStore(), read: make(chan struct{}), release: make(chan struct{})}
+	s := newCachingStore(inner, 10, 0, clockwork.NewFakeClock())
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "first"})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Get(ctx, "1.1.1.1", 80, "HTTP")
+	}()
+	<-inner.read
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "second"})
+	close(inner.release)
+	<-done
+
+	if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r == nil || r.Response != "second" {
+		t.Errorf("Expected the record written during the read, got %+v", r)
+	}
+}
@@ -0,0 +1,390 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ChangelogEntry is one accepted change to a record, as recorded by
+// ChangelogStore
+// The Old fields are empty when the change created the record
+type ChangelogEntry struct {
+	Timestamp        time.Time // when the change was made
+	IP               string
+	Service          string
+	Port             uint32
+	OldResponse      string
+	NewResponse      string
+	OldLastTimestamp int64
+	NewLastTimestamp int64
+}
+
+// changelog stores the entries written by ChangelogStore
+type changelog interface {
+	append(ctx context.Context, e *ChangelogEntry) error
+	// get returns up to limit entries for a key, newest first; limit=0
+	// returns every entry
+	get(ctx context.Context, ip string, port uint32, service string, limit int) ([]*ChangelogEntry, error)
+}
+
+// ChangelogStore is a Store that keeps an append-only history of every
+// accepted Upsert, BulkUpsert and UpdateIfUnchanged of the store it wraps
+// The SQL stores keep the history in their service_record_changelog table;
+// the memory, Badger and Redis stores keep it in memory for the life of the
+// ChangelogStore
+// The previous record is read before each write, and writes through this
+// store are serialized so entries are not interleaved, but writes made
+// elsewhere in between are not seen
+// Deletes, undeletes and purges are not recorded
+type ChangelogStore struct {
+	inner Store
+	log   changelog
+
+	mu sync.Mutex // serializes writes so the previous record stays current
+}
+
+// NewChangelogStore wraps inner so that its changes are recorded
+// Wrappers are looked through with Unwrap to find the backing store, and an
+// error is returned when it is not one of the package's stores
+func NewChangelogStore(inner Store) (*ChangelogStore, error) {
+	var log changelog
+	switch s := unwrapStore(inner).(type) {
+	case *SQLiteStore:
+		log = &sqlChangelog{db: s.db, placeholder: func(int) string { return "?" }, timeArg: sqliteTime}
+	case *PostgresStore:
+		log = &sqlChangelog{db: s.db, placeholder: pgPlaceholder, timeArg: changelogTime}
+	case *PostgresStoreV2:
+		log = &sqlChangelog{db: s.db, placeholder: pgPlaceholder, timeArg: changelogTime}
+	case *MySQLStore:
+		log = &sqlChangelog{db: s.db, placeholder: func(int) string { return "?" }, timeArg: changelogTime}
+	case *MemoryStore, *BadgerStore, *RedisStore:
+		log = &memoryChangelog{}
+	default:
+		return nil, fmt.Errorf("unsupported changelog store: %T", s)
+	}
+	return &ChangelogStore{inner: inner, log: log}, nil
+}
+
+// pgPlaceholder returns the nth PostgreSQL bind parameter
+func pgPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// changelogTime passes a time to drivers that store it natively
+func changelogTime(t *time.Time) interface{} {
+	return *t
+}
+
+// GetChangelog returns up to limit changes to a record, newest first
+// Use limit=0 to return the whole history
+func (s *ChangelogStore) GetChangelog(ctx context.Context, ip string, port uint32, service string, limit int) ([]*ChangelogEntry, error) {
+	return s.log.get(ctx, CanonicalIP(ip), port, service, limit)
+}
+
+// Upsert writes through to the wrapped store and records the change if the
+// record was inserted or updated
+// If the change cannot be recorded the error is returned even though the
+// record was written
+func (s *ChangelogStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.record(ctx, r, func() (bool, error) { return s.inner.Upsert(ctx, r) })
+}
+
+// BulkUpsert upserts the batch one record at a time so each accepted change
+// can be recorded
+func (s *ChangelogStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := 0
+	for _, r := range dedupeRecords(records) {
+		ok, err := s.record(ctx, r, func() (bool, error) { return s.inner.Upsert(ctx, r) })
+		if ok {
+			updated++
+		}
+		if err != nil {
+			return updated, err
+		}
+	}
+	return updated, nil
+}
+
+// UpdateIfUnchanged writes through to the wrapped store and records the
+// change if the record was updated
+func (s *ChangelogStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.record(ctx, r, func() (bool, error) { return s.inner.UpdateIfUnchanged(ctx, r, expectedTimestamp) })
+}
+
+// record reads the current record, runs write and appends an entry if it
+// reports the record was written
+// Caller must hold mu
+func (s *ChangelogStore) record(ctx context.Context, r *ServiceRecord, write func() (bool, error)) (bool, error) {
+	previous, err := s.inner.Get(ctx, r.IP.String(), r.Port, r.Service)
+	if err != nil {
+		return false, fmt.Errorf("failed to read previous record: %w", err)
+	}
+
+	updated, err := write()
+	if err != nil || !updated {
+		return updated, err
+	}
+
+	e := &ChangelogEntry{
+		Timestamp:        time.Now().UTC(),
+		IP:               CanonicalIP(r.IP.String()),
+		Service:          r.Service,
+		Port:             r.Port,
+		NewResponse:      r.Response,
+		NewLastTimestamp: r.LastTimestamp,
+	}
+	if previous != nil {
+		e.OldResponse = previous.Response
+		e.OldLastTimestamp = previous.LastTimestamp
+	}
+	if err := s.log.append(ctx, e); err != nil {
+		return true, fmt.Errorf("failed to record change: %w", err)
+	}
+	return true, nil
+}
+
+// Get calls the wrapped store
+func (s *ChangelogStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	return s.inner.Get(ctx, ip, port, service)
+}
+
+// GetMulti calls the wrapped store
+func (s *ChangelogStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	return s.inner.GetMulti(ctx, keys)
+}
+
+// List calls the wrapped store
+func (s *ChangelogStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.List(ctx, limit, offset)
+}
+
+// ListV2 calls the wrapped store
+func (s *ChangelogStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	return s.inner.ListV2(ctx, limit, offset)
+}
+
+// ListByIP calls the wrapped store
+func (s *ChangelogStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return s.inner.ListByIP(ctx, ip)
+}
+
+// ListByService calls the wrapped store
+func (s *ChangelogStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByService(ctx, service, limit, offset)
+}
+
+// ListByPort calls the wrapped store
+func (s *ChangelogStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByPort(ctx, port, limit, offset)
+}
+
+// ListByTimestampRange calls the wrapped store
+func (s *ChangelogStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByTimestampRange(ctx, from, to, limit, offset)
+}
+
+// ListByCIDR calls the wrapped store
+func (s *ChangelogStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByCIDR(ctx, cidr, limit, offset)
+}
+
+// SearchByResponse calls the wrapped store
+func (s *ChangelogStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.SearchByResponse(ctx, query, limit, offset)
+}
+
+// ListAfter calls the wrapped store
+func (s *ChangelogStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return s.inner.ListAfter(ctx, after, limit)
+}
+
+// ListDistinctIPs calls the wrapped store
+func (s *ChangelogStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	return s.inner.ListDistinctIPs(ctx, limit, offset)
+}
+
+// CountDistinctIPs calls the wrapped store
+func (s *ChangelogStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	return s.inner.CountDistinctIPs(ctx)
+}
+
+// ListDistinctServices calls the wrapped store
+func (s *ChangelogStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return s.inner.ListDistinctServices(ctx)
+}
+
+// ListDistinctPorts calls the wrapped store
+func (s *ChangelogStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return s.inner.ListDistinctPorts(ctx)
+}
+
+// FindCoOccurrence calls the wrapped store
+func (s *ChangelogStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	return s.inner.FindCoOccurrence(ctx, services)
+}
+
+// ListChangedSince calls the wrapped store
+func (s *ChangelogStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.inner.ListChangedSince(ctx, timestamp)
+}
+
+// Delete calls the wrapped store
+func (s *ChangelogStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return s.inner.Delete(ctx, ip, port, service)
+}
+
+// Undelete calls the wrapped store
+func (s *ChangelogStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return s.inner.Undelete(ctx, ip, port, service)
+}
+
+// ListDeleted calls the wrapped store
+func (s *ChangelogStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListDeleted(ctx, limit, offset)
+}
+
+// DeleteOlderThan calls the wrapped store
+func (s *ChangelogStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	return s.inner.DeleteOlderThan(ctx, beforeTimestamp)
+}
+
+// Count calls the wrapped store
+func (s *ChangelogStore) Count(ctx context.Context) (int64, error) {
+	return s.inner.Count(ctx)
+}
+
+// PurgeExpired calls the wrapped store
+func (s *ChangelogStore) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.inner.PurgeExpired(ctx)
+}
+
+// Stats calls the wrapped store
+func (s *ChangelogStore) Stats(ctx context.Context) (*StoreStats, error) {
+	return s.inner.Stats(ctx)
+}
+
+// Watch calls the wrapped store
+func (s *ChangelogStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.inner.Watch(ctx)
+}
+
+// Unwatch calls the wrapped store
+func (s *ChangelogStore) Unwatch(ch <-chan StoreEvent) {
+	s.inner.Unwatch(ch)
+}
+
+// HealthCheck calls the wrapped store
+func (s *ChangelogStore) HealthCheck(ctx context.Context) error {
+	return s.inner.HealthCheck(ctx)
+}
+
+// Close closes the wrapped store
+func (s *ChangelogStore) Close() error {
+	return s.inner.Close()
+}
+
+// Unwrap returns the wrapped store
+func (s *ChangelogStore) Unwrap() Store {
+	return s.inner
+}
+
+// memoryChangelog keeps entries in a slice, oldest first
+type memoryChangelog struct {
+	mu      sync.RWMutex
+	entries []ChangelogEntry
+}
+
+// append adds a copy of e
+func (l *memoryChangelog) append(ctx context.Context, e *ChangelogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, *e)
+	return nil
+}
+
+// get scans the entries from the newest
+func (l *memoryChangelog) get(ctx context.Context, ip string, port uint32, service string, limit int) ([]*ChangelogEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	found := make([]*ChangelogEntry, 0)
+	for _, e := range slices.Backward(l.entries) {
+		if limit > 0 && len(found) == limit {
+			break
+		}
+		if e.IP == ip && e.Port == port && e.Service == service {
+			found = append(found, &e)
+		}
+	}
+	return found, nil
+}
+
+// sqlChangelog keeps entries in the service_record_changelog table of a SQL
+// store
+type sqlChangelog struct {
+	db          *sql.DB
+	placeholder func(n int) string
+	timeArg     func(*time.Time) interface{}
+}
+
+// append inserts e
+func (l *sqlChangelog) append(ctx context.Context, e *ChangelogEntry) error {
+	p := l.placeholder
+	_, err := l.db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO service_record_changelog (changed_at, ip, port, service, old_response, new_response, old_last_timestamp, new_last_timestamp)
+		VALUES (%s, %s, %s, %s, %s, %s, %s, %s)
+	`, p(1), p(2), p(3), p(4), p(5), p(6), p(7), p(8)),
+		l.timeArg(&e.Timestamp), e.IP, e.Port, e.Service, e.OldResponse, e.NewResponse, e.OldLastTimestamp, e.NewLastTimestamp)
+	if err != nil {
+		return fmt.Errorf("failed to insert changelog entry: %w", err)
+	}
+	return nil
+}
+
+// get selects entries in reverse insertion order
+func (l *sqlChangelog) get(ctx context.Context, ip string, port uint32, service string, limit int) ([]*ChangelogEntry, error) {
+	p := l.placeholder
+	query := fmt.Sprintf(`
+		SELECT changed_at, ip, port, service, old_response, new_response, old_last_timestamp, new_last_timestamp
+		FROM service_record_changelog
+		WHERE ip = %s AND port = %s AND service = %s
+		ORDER BY id DESC
+	`, p(1), p(2), p(3))
+	args := []interface{}{ip, port, service}
+	if limit > 0 {
+		query += " LIMIT " + p(4)
+		args = append(args, limit)
+	}
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query changelog: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]*ChangelogEntry, 0)
+	for rows.Next() {
+		var e ChangelogEntry
+		if err := rows.Scan(&e.Timestamp, &e.IP, &e.Port, &e.Service, &e.OldResponse, &e.NewResponse, &e.OldLastTimestamp, &e.NewLastTimestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan changelog entry: %w", err)
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read changelog: %w", err)
+	}
+	return entries, nil
+}
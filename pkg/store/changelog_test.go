@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestChangelogStore tests that every accepted update is recorded with its
+// before and after values, and that skipped updates are not
+func TestChangelogStore(t *testing.T) {
+	for name, inner := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := NewChangelogStore(inner)
+			if err != nil {
+				t.Fatalf("NewChangelogStore failed: %v", err)
+			}
+			start := time.Now().Add(-time.Second)
+
+			for i := 1; i <= 5; i++ {
+				r := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: int64(i * 1000), Response: fmt.Sprintf("response %d", i)}
+				if updated, err := s.Upsert(ctx, r); err != nil || !updated {
+					t.Fatalf("Expected update %d to be accepted, got %v (err %v)", i, updated, err)
+				}
+			}
+			// Older and unrelated writes are not part of the record's history
+			s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1500, Response: "stale"})
+			s.BulkUpsert(ctx, []*ServiceRecord{{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 1000}})
+
+			entries, err := s.GetChangelog(ctx, "1.1.1.1", 80, "HTTP", 0)
+			if err != nil {
+				t.Fatalf("GetChangelog failed: %v", err)
+			}
+			if len(entries) != 5 {
+				t.Fatalf("Expected 5 changelog entries, got %d", len(entries))
+			}
+			for j, e := range entries {
+				// Newest first
+				i := 5 - j
+				want := ChangelogEntry{
+					IP: "1.1.1.1", Port: 80, Service: "HTTP",
+					NewResponse: fmt.Sprintf("response %d", i), NewLastTimestamp: int64(i * 1000),
+				}
+				if i > 1 {
+					want.OldResponse = fmt.Sprintf("response %d", i-1)
+					want.OldLastTimestamp = int64((i - 1) * 1000)
+				}
+				got := *e
+				got.Timestamp = time.Time{}
+				if got != want {
+					t.Errorf("Expected entry %+v, got %+v", want, got)
+				}
+				if e.Timestamp.Before(start) || e.Timestamp.After(time.Now()) {
+					t.Errorf("Expected entry time to be now, got %v", e.Timestamp)
+				}
+			}
+
+			if limited, _ := s.GetChangelog(ctx, "1.1.1.1", 80, "HTTP", 2); len(limited) != 2 || limited[0].NewLastTimestamp != 5000 {
+				t.Errorf("Expected the 2 newest entries, got %v", limited)
+			}
+			if other, _ := s.GetChangelog(ctx, "2.2.2.2", 80, "HTTP", 0); len(other) != 1 || other[0].OldLastTimestamp != 0 {
+				t.Errorf("Expected one insert entry for the bulk upserted record, got %v", other)
+			}
+		})
+	}
+}
+
+// TestChangelogStoreUnwrap tests that the changelog of a wrapped SQL store
+// is kept in its table, and that stores it cannot see through are rejected
+func TestChangelogStoreUnwrap(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSQLiteStore(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	wrapped := NewRetryingStore(NewCircuitBreakerStore(NewMetricsStore(sqliteStore, prometheus.NewRegistry()), 5, time.Second), 3, time.Millisecond)
+	s, err := NewChangelogStore(wrapped)
+	if err != nil {
+		t.Fatalf("NewChangelogStore failed: %v", err)
+	}
+	if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+
+	// A second changelog over the bare store reads the same table
+	direct, err := NewChangelogStore(sqliteStore)
+	if err != nil {
+		t.Fatalf("NewChangelogStore failed: %v", err)
+	}
+	if entries, err := direct.GetChangelog(ctx, "1.1.1.1", 80, "HTTP", 0); err != nil || len(entries) != 1 {
+		t.Errorf("Expected 1 entry in the SQLite changelog, got %d (err %v)", len(entries), err)
+	}
+
+	if _, err := NewChangelogStore(NewCompositeStore(NewMemoryStore())); err == nil {
+		t.Error("Expected an error for a store that cannot be unwrapped")
+	}
+}
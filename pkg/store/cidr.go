@@ -0,0 +1,53 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrInvalidCIDR is returned when a CIDR filter cannot be parsed
+var ErrInvalidCIDR = errors.New("invalid CIDR")
+
+// parseCIDR parses a CIDR string, wrapping failures in ErrInvalidCIDR
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCIDR, cidr)
+	}
+	return network, nil
+}
+
+// cidrContains reports whether the textual IP belongs to the network
+func cidrContains(network *net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && network.Contains(parsed)
+}
+
+// ipv4LikePrefix returns a LIKE pattern matching the whole octets fixed by
+// an IPv4 network (e.g. "192.168.1.%" for 192.168.1.0/24)
+// Returns "" when no octet is fixed or the network is IPv6
+// The pattern only narrows candidates; cidrContains does the exact check
+func ipv4LikePrefix(network *net.IPNet) string {
+	ip4 := network.IP.To4()
+	if ip4 == nil || len(network.Mask) != net.IPv4len {
+		return ""
+	}
+
+	ones, _ := network.Mask.Size()
+	octets := ones / 8
+	if octets > 3 {
+		// Keep the trailing '.' so "1.2.3.4" does not match "1.2.3.45"
+		octets = 3
+	}
+	if octets == 0 {
+		return ""
+	}
+
+	parts := make([]string, octets)
+	for i := 0; i < octets; i++ {
+		parts[i] = fmt.Sprint(ip4[i])
+	}
+	return strings.Join(parts, ".") + ".%"
+}
@@ -0,0 +1,435 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreakerStore while the breaker is
+// open, without calling the wrapped store
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CBState is the state of a CircuitBreakerStore
+type CBState int32
+
+const (
+	// CBClosed passes every call to the wrapped store
+	CBClosed CBState = iota
+	// CBOpen rejects every call with ErrCircuitOpen
+	CBOpen
+	// CBHalfOpen lets a single probe call through to decide whether to
+	// close the breaker again
+	CBHalfOpen
+)
+
+// String returns the state name
+func (s CBState) String() string {
+	switch s {
+	case CBClosed:
+		return "closed"
+	case CBOpen:
+		return "open"
+	case CBHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// cbStateChangeBuffer is how many state changes StateChange holds for a
+// reader that is not keeping up
+const cbStateChangeBuffer = 16
+
+// CircuitBreakerStore is a Store that stops calling the store it wraps after
+// threshold consecutive failures, so callers fail fast instead of piling up
+// while the backend is down
+// Once open, the breaker waits halfOpenDelay before letting one probe call
+// through; the breaker closes if it succeeds and stays open for another
+// halfOpenDelay if it fails
+// Context cancellation and invalid arguments such as ErrInvalidCIDR are not
+// counted as failures
+// Unwatch, HealthCheck and Close always call the wrapped store, so health
+// checks report the backend's current state
+type CircuitBreakerStore struct {
+	inner         Store
+	threshold     int64
+	halfOpenDelay time.Duration
+	clock         clockwork.Clock
+
+	state    atomic.Int32
+	failures atomic.Int64 // consecutive failures while closed
+	openedAt atomic.Int64 // unix nanoseconds the breaker last opened
+
+	stateChange chan CBState
+}
+
+// NewCircuitBreakerStore wraps inner with a breaker that opens after
+// threshold consecutive failures and probes again after halfOpenDelay
+func NewCircuitBreakerStore(inner Store, threshold int, halfOpenDelay time.Duration) Store {
+	return newCircuitBreakerStore(inner, threshold, halfOpenDelay, clockwork.NewRealClock())
+}
+
+// newCircuitBreakerStore creates a CircuitBreakerStore that reads the time
+// from clock
+func newCircuitBreakerStore(inner Store, threshold int, halfOpenDelay time.Duration, clock clockwork.Clock) *CircuitBreakerStore {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreakerStore{
+		inner:         inner,
+		threshold:     int64(threshold),
+		halfOpenDelay: halfOpenDelay,
+		clock:         clock,
+		stateChange:   make(chan CBState, cbStateChangeBuffer),
+	}
+}
+
+// State returns the current state of the breaker
+func (s *CircuitBreakerStore) State() CBState {
+	return CBState(s.state.Load())
+}
+
+// StateChange returns a channel that receives the new state on every
+// transition
+// Changes are dropped rather than blocking callers when the channel is full
+func (s *CircuitBreakerStore) StateChange() <-chan CBState {
+	return s.stateChange
+}
+
+// transition moves the breaker from one state to another and reports
+// whether this caller made the change
+func (s *CircuitBreakerStore) transition(from, to CBState) bool {
+	if !s.state.CompareAndSwap(int32(from), int32(to)) {
+		return false
+	}
+	select {
+	case s.stateChange <- to:
+	default:
+	}
+	return true
+}
+
+// allow reports whether a call may go through to the wrapped store and
+// whether it is the half-open probe
+func (s *CircuitBreakerStore) allow() (probe bool, err error) {
+	switch s.State() {
+	case CBClosed:
+		return false, nil
+	case CBOpen:
+		openedAt := time.Unix(0, s.openedAt.Load())
+		if s.clock.Since(openedAt) >= s.halfOpenDelay && s.transition(CBOpen, CBHalfOpen) {
+			return true, nil
+		}
+	}
+	// Open, or half-open with the probe still in flight
+	return false, ErrCircuitOpen
+}
+
+// record updates the breaker with the result of a call
+func (s *CircuitBreakerStore) record(probe bool, err error) {
+	failed := err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, ErrInvalidCIDR)
+
+	if probe {
+		switch {
+		case err != nil && !failed:
+			// The probe said nothing about the backend, so let the next
+			// call probe again straight away
+			s.transition(CBHalfOpen, CBOpen)
+		case failed:
+			s.openedAt.Store(s.clock.Now().UnixNano())
+			s.transition(CBHalfOpen, CBOpen)
+		default:
+			s.failures.Store(0)
+			s.transition(CBHalfOpen, CBClosed)
+		}
+		return
+	}
+
+	// Calls started before the breaker opened finish without affecting it
+	if s.State() != CBClosed {
+		return
+	}
+	if !failed {
+		s.failures.Store(0)
+		return
+	}
+	if s.failures.Add(1) >= s.threshold {
+		s.openedAt.Store(s.clock.Now().UnixNano())
+		s.transition(CBClosed, CBOpen)
+	}
+}
+
+// call runs fn if the breaker allows it and records the result
+func (s *CircuitBreakerStore) call(fn func() error) error {
+	probe, err := s.allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	s.record(probe, err)
+	return err
+}
+
+// Upsert calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	var updated bool
+	err := s.call(func() (err error) {
+		updated, err = s.inner.Upsert(ctx, r)
+		return err
+	})
+	return updated, err
+}
+
+// BulkUpsert calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	var updated int
+	err := s.call(func() (err error) {
+		updated, err = s.inner.BulkUpsert(ctx, records)
+		return err
+	})
+	return updated, err
+}
+
+// UpdateIfUnchanged calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	var updated bool
+	err := s.call(func() (err error) {
+		updated, err = s.inner.UpdateIfUnchanged(ctx, r, expectedTimestamp)
+		return err
+	})
+	return updated, err
+}
+
+// Get calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	var r *ServiceRecord
+	err := s.call(func() (err error) {
+		r, err = s.inner.Get(ctx, ip, port, service)
+		return err
+	})
+	return r, err
+}
+
+// GetMulti calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	var found map[RecordKey]*ServiceRecord
+	err := s.call(func() (err error) {
+		found, err = s.inner.GetMulti(ctx, keys)
+		return err
+	})
+	return found, err
+}
+
+// list calls a list method of the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) list(fn func() ([]*ServiceRecord, error)) ([]*ServiceRecord, error) {
+	var records []*ServiceRecord
+	err := s.call(func() (err error) {
+		records, err = fn()
+		return err
+	})
+	return records, err
+}
+
+// List calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.List(ctx, limit, offset) })
+}
+
+// ListV2 calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	var result *ListResult
+	err := s.call(func() (err error) {
+		result, err = s.inner.ListV2(ctx, limit, offset)
+		return err
+	})
+	return result, err
+}
+
+// ListByIP calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.ListByIP(ctx, ip) })
+}
+
+// ListByService calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.ListByService(ctx, service, limit, offset) })
+}
+
+// ListByPort calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.ListByPort(ctx, port, limit, offset) })
+}
+
+// ListByTimestampRange calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.ListByTimestampRange(ctx, from, to, limit, offset) })
+}
+
+// ListByCIDR calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.ListByCIDR(ctx, cidr, limit, offset) })
+}
+
+// SearchByResponse calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.SearchByResponse(ctx, query, limit, offset) })
+}
+
+// ListAfter calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.ListAfter(ctx, after, limit) })
+}
+
+// ListDistinctIPs calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	var ips []string
+	err := s.call(func() (err error) {
+		ips, err = s.inner.ListDistinctIPs(ctx, limit, offset)
+		return err
+	})
+	return ips, err
+}
+
+// CountDistinctIPs calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.call(func() (err error) {
+		count, err = s.inner.CountDistinctIPs(ctx)
+		return err
+	})
+	return count, err
+}
+
+// ListDistinctServices calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	var services []string
+	err := s.call(func() (err error) {
+		services, err = s.inner.ListDistinctServices(ctx)
+		return err
+	})
+	return services, err
+}
+
+// ListDistinctPorts calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	var ports []uint32
+	err := s.call(func() (err error) {
+		ports, err = s.inner.ListDistinctPorts(ctx)
+		return err
+	})
+	return ports, err
+}
+
+// FindCoOccurrence calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	var summaries []*IPSummary
+	err := s.call(func() (err error) {
+		summaries, err = s.inner.FindCoOccurrence(ctx, services)
+		return err
+	})
+	return summaries, err
+}
+
+// ListChangedSince calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.ListChangedSince(ctx, timestamp) })
+}
+
+// Delete calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	var deleted bool
+	err := s.call(func() (err error) {
+		deleted, err = s.inner.Delete(ctx, ip, port, service)
+		return err
+	})
+	return deleted, err
+}
+
+// Undelete calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	var restored bool
+	err := s.call(func() (err error) {
+		restored, err = s.inner.Undelete(ctx, ip, port, service)
+		return err
+	})
+	return restored, err
+}
+
+// ListDeleted calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(func() ([]*ServiceRecord, error) { return s.inner.ListDeleted(ctx, limit, offset) })
+}
+
+// DeleteOlderThan calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	var deleted int64
+	err := s.call(func() (err error) {
+		deleted, err = s.inner.DeleteOlderThan(ctx, beforeTimestamp)
+		return err
+	})
+	return deleted, err
+}
+
+// PurgeExpired calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) PurgeExpired(ctx context.Context) (int64, error) {
+	var purged int64
+	err := s.call(func() (err error) {
+		purged, err = s.inner.PurgeExpired(ctx)
+		return err
+	})
+	return purged, err
+}
+
+// Count calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.call(func() (err error) {
+		count, err = s.inner.Count(ctx)
+		return err
+	})
+	return count, err
+}
+
+// Stats calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) Stats(ctx context.Context) (*StoreStats, error) {
+	var stats *StoreStats
+	err := s.call(func() (err error) {
+		stats, err = s.inner.Stats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+// Watch calls the wrapped store unless the breaker is open
+func (s *CircuitBreakerStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	var ch <-chan StoreEvent
+	err := s.call(func() (err error) {
+		ch, err = s.inner.Watch(ctx)
+		return err
+	})
+	return ch, err
+}
+
+// Unwatch calls the wrapped store
+func (s *CircuitBreakerStore) Unwatch(ch <-chan StoreEvent) {
+	s.inner.Unwatch(ch)
+}
+
+// HealthCheck calls the wrapped store whatever the state of the breaker
+func (s *CircuitBreakerStore) HealthCheck(ctx context.Context) error {
+	return s.inner.HealthCheck(ctx)
+}
+
+// Close closes the wrapped store
+func (s *CircuitBreakerStore) Close() error {
+	return s.inner.Close()
+}
+
+// Unwrap returns the wrapped store
+func (s *CircuitBreakerStore) Unwrap() Store {
+	return s.inner
+}
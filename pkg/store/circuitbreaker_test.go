@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// downStore fails Upsert with err while it is set and counts the calls that
+// reach it
+type downStore struct {
+	Store
+	err   error
+	calls int
+}
+
+func (s *downStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	s.calls++
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.Store.Upsert(ctx, r)
+}
+
+// expectChanges fails the test unless the given state changes were sent on
+// StateChange and the breaker is now in the last of them
+func expectChanges(t *testing.T, s *CircuitBreakerStore, want ...CBState) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-s.StateChange():
+			if got != w {
+				t.Errorf("Expected state change to %s, got %s", w, got)
+			}
+		default:
+			t.Errorf("Expected state change to %s", w)
+		}
+	}
+	if got := s.State(); got != want[len(want)-1] {
+		t.Fatalf("Expected state %s, got %s", want[len(want)-1], got)
+	}
+}
+
+// TestCircuitBreakerStore walks the breaker through every transition:
+// closed to open, open to half-open, half-open back to open and half-open
+// to closed
+func TestCircuitBreakerStore(t *testing.T) {
+	inner := &downStore{Store: NewMemoryStore(), err: errors.New("connection refused")}
+	clock := clockwork.NewFakeClock()
+	s := newCircuitBreakerStore(inner, 3, time.Minute, clock)
+	ctx := context.Background()
+	r := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}
+
+	// A success resets the count of consecutive failures
+	s.Upsert(ctx, r)
+	s.Upsert(ctx, r)
+	inner.err = nil
+	s.Upsert(ctx, r)
+	inner.err = errors.New("connection refused")
+	s.Upsert(ctx, r)
+	s.Upsert(ctx, r)
+	if s.State() != CBClosed {
+		t.Fatalf("Expected breaker to stay closed, got %s", s.State())
+	}
+
+	// Closed -> open after 3 consecutive failures
+	s.Upsert(ctx, r)
+	expectChanges(t, s, CBOpen)
+
+	calls := inner.calls
+	if _, err := s.Upsert(ctx, r); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if inner.calls != calls {
+		t.Errorf("Expected open breaker not to call the wrapped store")
+	}
+
+	// Open -> half-open -> open when the probe fails
+	clock.Advance(time.Minute)
+	if _, err := s.Upsert(ctx, r); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected probe to reach the wrapped store, got %v", err)
+	}
+	expectChanges(t, s, CBHalfOpen, CBOpen)
+
+	// The failed probe restarts the delay
+	clock.Advance(59 * time.Second)
+	if _, err := s.Upsert(ctx, r); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen before the delay passes, got %v", err)
+	}
+
+	// Open -> half-open -> closed when the probe succeeds
+	clock.Advance(time.Second)
+	inner.err = nil
+	if _, err := s.Upsert(ctx, r); err != nil {
+		t.Errorf("Expected probe to succeed, got %v", err)
+	}
+	expectChanges(t, s, CBHalfOpen, CBClosed)
+
+	if _, err := s.Upsert(ctx, r); err != nil {
+		t.Errorf("Expected closed breaker to pass calls through, got %v", err)
+	}
+}
+
+// TestCircuitBreakerStoreHalfOpen tests that only one probe is let through
+// while half-open, and that errors that say nothing about the backend do not
+// open the breaker
+func TestCircuitBreakerStoreHalfOpen(t *testing.T) {
+	inner := &downStore{Store: NewMemoryStore()}
+	clock := clockwork.NewFakeClock()
+	s := newCircuitBreakerStore(inner, 1, time.Minute, clock)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		s.ListByCIDR(ctx, "not a cidr", 0, 0)
+	}
+	inner.err = context.Canceled
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+	if s.State() != CBClosed {
+		t.Fatalf("Expected caller errors not to open the breaker, got %s", s.State())
+	}
+
+	// Hold the breaker half-open, as if the probe were still in flight
+	s.state.Store(int32(CBHalfOpen))
+	if _, err := s.Get(ctx, "1.1.1.1", 80, "HTTP"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen while a probe is in flight, got %v", err)
+	}
+	if err := s.HealthCheck(ctx); err != nil {
+		t.Errorf("Expected HealthCheck to bypass the breaker, got %v", err)
+	}
+
+	// A cancelled probe leaves the breaker open, but the next call probes
+	// without waiting again
+	s.state.Store(int32(CBOpen))
+	clock.Advance(time.Minute)
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+	expectChanges(t, s, CBHalfOpen, CBOpen)
+	inner.err = nil
+	if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}); err != nil {
+		t.Errorf("Expected second probe to go through, got %v", err)
+	}
+	expectChanges(t, s, CBHalfOpen, CBClosed)
+}
+
+// TestCBStateString tests the state names
+func TestCBStateString(t *testing.T) {
+	for state, want := range map[CBState]string{CBClosed: "closed", CBOpen: "open", CBHalfOpen: "half-open", CBState(9): "unknown"} {
+		if got := state.String(); got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// CompositeConfig configures a CompositeStore
+type CompositeConfig struct {
+	// StrictMode returns replica write errors instead of logging them
+	// The primary's write has already been applied when one is returned
+	StrictMode bool
+
+	// Logger receives replica write errors, slog.Default() if nil
+	Logger *slog.Logger
+}
+
+// CompositeStore is a Store that writes to a primary store and copies each
+// write that changed it to every replica, for example a MemoryStore for fast
+// reads in front of a durable PostgresStore
+// Reads, Watch and Unwatch use only the primary
+// Replicas are written concurrently after the primary; by default a replica
+// failure is logged and the primary's result returned, so a replica can fall
+// behind until a later write for the same key reaches it
+type CompositeStore struct {
+	primary  Store
+	replicas []Store
+	strict   bool
+	logger   *slog.Logger
+}
+
+// NewCompositeStore creates a store that writes to primary and replicas,
+// logging replica failures
+func NewCompositeStore(primary Store, replicas ...Store) Store {
+	return NewCompositeStoreWithConfig(CompositeConfig{}, primary, replicas...)
+}
+
+// NewCompositeStoreWithConfig creates a composite store with custom settings
+func NewCompositeStoreWithConfig(cfg CompositeConfig, primary Store, replicas ...Store) Store {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &CompositeStore{primary: primary, replicas: replicas, strict: cfg.StrictMode, logger: logger}
+}
+
+// replicate calls fn for every replica concurrently
+// Failures are logged, and the first one is returned in strict mode
+func (s *CompositeStore) replicate(ctx context.Context, op string, fn func(Store) error) error {
+	var g errgroup.Group
+	for i, replica := range s.replicas {
+		g.Go(func() error {
+			if err := fn(replica); err != nil {
+				s.logger.ErrorContext(ctx, "failed to write to replica",
+					slog.Int("replica", i),
+					slog.String("op", op),
+					slog.Any("error", err),
+				)
+				return fmt.Errorf("failed to %s on replica %d: %w", op, i, err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil && s.strict {
+		return err
+	}
+	return nil
+}
+
+// Upsert writes to the primary and, if it stored the record, to the replicas
+func (s *CompositeStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	updated, err := s.primary.Upsert(ctx, r)
+	if err != nil || !updated {
+		return updated, err
+	}
+	return updated, s.replicate(ctx, "upsert", func(replica Store) error {
+		_, err := replica.Upsert(ctx, r)
+		return err
+	})
+}
+
+// BulkUpsert writes the batch to the primary and, if it stored any record,
+// to the replicas, which skip the records that are older as the primary did
+func (s *CompositeStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	updated, err := s.primary.BulkUpsert(ctx, records)
+	if err != nil || updated == 0 {
+		return updated, err
+	}
+	return updated, s.replicate(ctx, "bulk upsert", func(replica Store) error {
+		_, err := replica.BulkUpsert(ctx, records)
+		return err
+	})
+}
+
+// UpdateIfUnchanged checks the timestamp against the primary only, then
+// upserts the record to the replicas
+func (s *CompositeStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	updated, err := s.primary.UpdateIfUnchanged(ctx, r, expectedTimestamp)
+	if err != nil || !updated {
+		return updated, err
+	}
+	return updated, s.replicate(ctx, "update", func(replica Store) error {
+		_, err := replica.Upsert(ctx, r)
+		return err
+	})
+}
+
+// Get reads from the primary
+func (s *CompositeStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	return s.primary.Get(ctx, ip, port, service)
+}
+
+// GetMulti reads from the primary
+func (s *CompositeStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	return s.primary.GetMulti(ctx, keys)
+}
+
+// List reads from the primary
+func (s *CompositeStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.primary.List(ctx, limit, offset)
+}
+
+// ListV2 reads from the primary
+func (s *CompositeStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	return s.primary.ListV2(ctx, limit, offset)
+}
+
+// ListByIP reads from the primary
+func (s *CompositeStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return s.primary.ListByIP(ctx, ip)
+}
+
+// ListByService reads from the primary
+func (s *CompositeStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.primary.ListByService(ctx, service, limit, offset)
+}
+
+// ListByPort reads from the primary
+func (s *CompositeStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.primary.ListByPort(ctx, port, limit, offset)
+}
+
+// ListByTimestampRange reads from the primary
+func (s *CompositeStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.primary.ListByTimestampRange(ctx, from, to, limit, offset)
+}
+
+// ListByCIDR reads from the primary
+func (s *CompositeStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.primary.ListByCIDR(ctx, cidr, limit, offset)
+}
+
+// SearchByResponse reads from the primary
+func (s *CompositeStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.primary.SearchByResponse(ctx, query, limit, offset)
+}
+
+// ListAfter reads from the primary
+func (s *CompositeStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return s.primary.ListAfter(ctx, after, limit)
+}
+
+// ListDistinctIPs reads from the primary
+func (s *CompositeStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	return s.primary.ListDistinctIPs(ctx, limit, offset)
+}
+
+// CountDistinctIPs reads from the primary
+func (s *CompositeStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	return s.primary.CountDistinctIPs(ctx)
+}
+
+// ListDistinctServices reads from the primary
+func (s *CompositeStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return s.primary.ListDistinctServices(ctx)
+}
+
+// ListDistinctPorts reads from the primary
+func (s *CompositeStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return s.primary.ListDistinctPorts(ctx)
+}
+
+// FindCoOccurrence reads from the primary
+func (s *CompositeStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	return s.primary.FindCoOccurrence(ctx, services)
+}
+
+// ListChangedSince reads from the primary
+func (s *CompositeStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.primary.ListChangedSince(ctx, timestamp)
+}
+
+// Delete deletes from the primary and, if it deleted the record, from the
+// replicas
+func (s *CompositeStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	deleted, err := s.primary.Delete(ctx, ip, port, service)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	return deleted, s.replicate(ctx, "delete", func(replica Store) error {
+		_, err := replica.Delete(ctx, ip, port, service)
+		return err
+	})
+}
+
+// Undelete restores the record in the primary and, if it was restored, in
+// the replicas
+func (s *CompositeStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	restored, err := s.primary.Undelete(ctx, ip, port, service)
+	if err != nil || !restored {
+		return restored, err
+	}
+	return restored, s.replicate(ctx, "undelete", func(replica Store) error {
+		_, err := replica.Undelete(ctx, ip, port, service)
+		return err
+	})
+}
+
+// ListDeleted reads from the primary
+func (s *CompositeStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.primary.ListDeleted(ctx, limit, offset)
+}
+
+// DeleteOlderThan removes records from every store, returning the number
+// removed from the primary
+func (s *CompositeStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	deleted, err := s.primary.DeleteOlderThan(ctx, beforeTimestamp)
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, s.replicate(ctx, "delete old records", func(replica Store) error {
+		_, err := replica.DeleteOlderThan(ctx, beforeTimestamp)
+		return err
+	})
+}
+
+// Count reads from the primary
+func (s *CompositeStore) Count(ctx context.Context) (int64, error) {
+	return s.primary.Count(ctx)
+}
+
+// PurgeExpired purges every store, returning the number purged from the
+// primary
+func (s *CompositeStore) PurgeExpired(ctx context.Context) (int64, error) {
+	purged, err := s.primary.PurgeExpired(ctx)
+	if err != nil {
+		return purged, err
+	}
+	return purged, s.replicate(ctx, "purge expired records", func(replica Store) error {
+		_, err := replica.PurgeExpired(ctx)
+		return err
+	})
+}
+
+// Stats reads from the primary
+func (s *CompositeStore) Stats(ctx context.Context) (*StoreStats, error) {
+	return s.primary.Stats(ctx)
+}
+
+// Watch watches the primary
+func (s *CompositeStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.primary.Watch(ctx)
+}
+
+// Unwatch stops a watch on the primary
+func (s *CompositeStore) Unwatch(ch <-chan StoreEvent) {
+	s.primary.Unwatch(ch)
+}
+
+// HealthCheck checks the primary and, in strict mode, every replica, since
+// only then does a failing replica fail writes
+func (s *CompositeStore) HealthCheck(ctx context.Context) error {
+	if err := s.primary.HealthCheck(ctx); err != nil {
+		return err
+	}
+	if !s.strict {
+		return nil
+	}
+	for i, replica := range s.replicas {
+		if err := replica.HealthCheck(ctx); err != nil {
+			return fmt.Errorf("replica %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the primary and every replica
+func (s *CompositeStore) Close() error {
+	errs := []error{s.primary.Close()}
+	for _, replica := range s.replicas {
+		errs = append(errs, replica.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// TestCompositeStore tests that writes reach the primary and replica while
+// reads come from the primary alone
+func TestCompositeStore(t *testing.T) {
+	primary, replica := NewMemoryStore(), NewMemoryStore()
+	s := NewCompositeStore(primary, replica)
+	defer s.Close()
+	ctx := context.Background()
+
+	r := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "hello"}
+	updated, err := s.Upsert(ctx, r)
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if !updated {
+		t.Error("Expected record to be inserted")
+	}
+	for name, store := range map[string]*MemoryStore{"primary": primary, "replica": replica} {
+		got, err := store.Get(ctx, "1.1.1.1", 80, "HTTP")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got == nil || got.Response != "hello" {
+			t.Errorf("Expected the record in the %s, got %+v", name, got)
+		}
+	}
+
+	// A record only the replica has is not visible through the composite
+	if _, err := replica.Upsert(ctx, &ServiceRecord{IP: "2.2.2.2", Port: 22, Service: "SSH", LastTimestamp: 1000}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if got, err := s.Get(ctx, "2.2.2.2", 22, "SSH"); err != nil || got != nil {
+		t.Errorf("Expected Get to read only the primary, got %+v (err %v)", got, err)
+	}
+	if records, err := s.List(ctx, 0, 0); err != nil || len(records) != 1 {
+		t.Errorf("Expected List to read only the primary, got %d records (err %v)", len(records), err)
+	}
+
+	// A write the primary skips is not sent to the replica
+	if _, err := replica.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 500, Response: "replica only"}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if updated, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 900}); err != nil || updated {
+		t.Errorf("Expected the older record to be skipped, got %v (err %v)", updated, err)
+	}
+
+	if deleted, err := s.Delete(ctx, "1.1.1.1", 80, "HTTP"); err != nil || !deleted {
+		t.Fatalf("Expected the record to be deleted, got %v (err %v)", deleted, err)
+	}
+	if got, _ := replica.Get(ctx, "1.1.1.1", 80, "HTTP"); got != nil {
+		t.Errorf("Expected the delete to reach the replica, got %+v", got)
+	}
+}
+
+// TestCompositeStoreReplicaFailure tests that a failing replica is logged
+// by default and returned in strict mode
+func TestCompositeStoreReplicaFailure(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	replicaErr := errors.New("connection refused")
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		strict  bool
+		wantErr bool
+	}{
+		{"fail open", false, false},
+		{"strict", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := NewMemoryStore()
+			replica := &downStore{Store: NewMemoryStore(), err: replicaErr}
+			s := NewCompositeStoreWithConfig(CompositeConfig{StrictMode: tt.strict, Logger: logger}, primary, replica)
+
+			updated, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+			if !updated {
+				t.Error("Expected the primary's result to be returned")
+			}
+			if tt.wantErr && !errors.Is(err, replicaErr) {
+				t.Errorf("Expected the replica error, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if replica.calls != 1 {
+				t.Errorf("Expected 1 replica write, got %d", replica.calls)
+			}
+			if got, _ := primary.Get(ctx, "1.1.1.1", 80, "HTTP"); got == nil {
+				t.Error("Expected the record in the primary")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Cursor is a ListAfter position: the sort key of the last record of the
+// previous page
+// The zero Cursor starts from the first page
+type Cursor struct {
+	Timestamp int64
+	IP        string
+	Port      uint32
+	Service   string
+}
+
+// CursorAfter returns the cursor for the page following r
+func CursorAfter(r *ServiceRecord) Cursor {
+	return Cursor{Timestamp: r.LastTimestamp, IP: r.IP.String(), Port: r.Port, Service: r.Service}
+}
+
+// isStart reports whether c is the starting position
+func (c Cursor) isStart() bool {
+	return c == Cursor{}
+}
+
+// precedes reports whether r comes strictly after c in ListAfter order
+func (c Cursor) precedes(r *ServiceRecord) bool {
+	if c.isStart() {
+		return true
+	}
+	key := &ServiceRecord{LastTimestamp: c.Timestamp, IP: IPAddress(c.IP), Port: c.Port, Service: c.Service}
+	return compareListOrder(key, r) < 0
+}
+
+// cursorJSON is the encoded form of a Cursor
+// Service names and IPv6 addresses may both contain ':', so the fields are
+// not joined with a separator
+type cursorJSON struct {
+	Timestamp int64  `json:"t"`
+	IP        string `json:"i"`
+	Port      uint32 `json:"p"`
+	Service   string `json:"s"`
+}
+
+// EncodeCursor encodes a ListAfter position into an opaque cursor string
+func EncodeCursor(c Cursor) string {
+	raw, _ := json.Marshal(cursorJSON(c))
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor decodes a cursor produced by EncodeCursor
+// An empty cursor decodes to the starting position, the zero Cursor
+func DecodeCursor(cursor string) (Cursor, error) {
+	if cursor == "" {
+		return Cursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor encoding: %w", err)
+	}
+
+	var c cursorJSON
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor format: %w", err)
+	}
+	return Cursor(c), nil
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+)
+
+// DryRunStore is a Store that logs writes instead of applying them
+// Every upsert is reported as stored, reads return nothing and the wrapped
+// store is only used for HealthCheck and Close, so the processor can be run
+// against live traffic without changing any data
+type DryRunStore struct {
+	next   Store
+	logger *slog.Logger
+	hub    watchHub
+}
+
+// NewDryRunStore wraps next so that nothing is written to it
+// Writes are logged at info level to logger, or slog.Default() if nil
+func NewDryRunStore(next Store, logger *slog.Logger) *DryRunStore {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &DryRunStore{next: next, logger: logger}
+}
+
+// Upsert logs the record that would be written and reports it as created
+func (s *DryRunStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	s.logger.InfoContext(ctx, "dry run: would upsert record",
+		slog.String("ip", r.IP.String()),
+		slog.Int("port", int(r.Port)),
+		slog.String("service", r.Service),
+		slog.Int64("timestamp", r.LastTimestamp),
+		slog.String("response", r.Response),
+		slog.String("response_hash", r.ResponseHash),
+		slog.Bool("response_truncated", r.ResponseTruncated),
+		slog.String("tls_version", r.TLSVersion),
+		slog.Int("status_code", r.StatusCode),
+	)
+	if s.hub.active() {
+		s.hub.publish(StoreEvent{Type: EventCreated, Record: copyRecord(r)})
+	}
+	return true, nil
+}
+
+// BulkUpsert logs each record that would be written
+func (s *DryRunStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	records = dedupeRecords(records)
+	for _, r := range records {
+		s.Upsert(ctx, r)
+	}
+	return len(records), nil
+}
+
+// UpdateIfUnchanged logs the update and reports the record as missing
+func (s *DryRunStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	s.logger.InfoContext(ctx, "dry run: would update record",
+		slog.String("ip", r.IP.String()),
+		slog.Int("port", int(r.Port)),
+		slog.String("service", r.Service),
+		slog.Int64("expected_timestamp", expectedTimestamp),
+	)
+	return false, nil
+}
+
+// Get always returns nil, since nothing is stored
+func (s *DryRunStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	return nil, nil
+}
+
+// GetMulti always finds no records
+func (s *DryRunStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	return map[RecordKey]*ServiceRecord{}, nil
+}
+
+// List always returns no records
+func (s *DryRunStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// ListV2 always returns no records
+func (s *DryRunStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	return &ListResult{Records: []*ServiceRecord{}}, nil
+}
+
+// ListByIP always returns no records
+func (s *DryRunStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// ListByService always returns no records
+func (s *DryRunStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// ListByPort always returns no records
+func (s *DryRunStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// ListByTimestampRange always returns no records
+func (s *DryRunStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// ListByCIDR always returns no records, but still rejects an invalid range
+func (s *DryRunStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	if _, err := parseCIDR(cidr); err != nil {
+		return nil, err
+	}
+	return []*ServiceRecord{}, nil
+}
+
+// SearchByResponse always returns no records
+func (s *DryRunStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// ListAfter always returns no records
+func (s *DryRunStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// ListDistinctIPs always returns no IPs
+func (s *DryRunStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	return []string{}, nil
+}
+
+// CountDistinctIPs always returns 0
+func (s *DryRunStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// ListDistinctServices always returns no services
+func (s *DryRunStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return []string{}, nil
+}
+
+// ListDistinctPorts always returns no ports
+func (s *DryRunStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return []uint32{}, nil
+}
+
+// FindCoOccurrence always returns no IPs
+func (s *DryRunStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	return []*IPSummary{}, nil
+}
+
+// ListChangedSince always returns no records
+func (s *DryRunStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// Delete logs the delete and reports the record as missing
+func (s *DryRunStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	s.logger.InfoContext(ctx, "dry run: would delete record",
+		slog.String("ip", ip),
+		slog.Int("port", int(port)),
+		slog.String("service", service),
+	)
+	return false, nil
+}
+
+// Undelete reports the record as missing
+func (s *DryRunStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return false, nil
+}
+
+// ListDeleted always returns no records
+func (s *DryRunStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return []*ServiceRecord{}, nil
+}
+
+// DeleteOlderThan logs the delete and reports nothing removed
+func (s *DryRunStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	s.logger.InfoContext(ctx, "dry run: would delete records older than timestamp",
+		slog.Int64("timestamp", beforeTimestamp),
+	)
+	return 0, nil
+}
+
+// PurgeExpired reports nothing removed
+func (s *DryRunStore) PurgeExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// Count always returns 0
+func (s *DryRunStore) Count(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// Stats returns empty stats
+func (s *DryRunStore) Stats(ctx context.Context) (*StoreStats, error) {
+	return &StoreStats{
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
+	}, nil
+}
+
+// Watch returns a channel of created events for the upserts that would
+// have been written
+func (s *DryRunStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.hub.watch(ctx)
+}
+
+// Unwatch stops delivery to a channel returned by Watch and closes it
+func (s *DryRunStore) Unwatch(ch <-chan StoreEvent) {
+	s.hub.unwatch(ch)
+}
+
+// HealthCheck checks the wrapped store, so a dry run still verifies that
+// the configured store is reachable
+func (s *DryRunStore) HealthCheck(ctx context.Context) error {
+	return s.next.HealthCheck(ctx)
+}
+
+// Close closes any open watch channels and the wrapped store
+func (s *DryRunStore) Close() error {
+	s.hub.close()
+	return s.next.Close()
+}
@@ -0,0 +1,398 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrorRecord is the last processing failure for a record key, as recorded
+// by ErrorTrackingStore
+type ErrorRecord struct {
+	IP          string
+	Port        uint32
+	Service     string
+	LastError   string
+	ErrorCount  int64     // failures since the key was last cleared
+	LastErrorAt time.Time // when the last failure was recorded
+}
+
+// errorLog stores the records written by ErrorTrackingStore
+type errorLog interface {
+	record(ctx context.Context, e *ErrorRecord) error
+	// list returns errors most recent first; limit=0 returns every error
+	list(ctx context.Context, limit, offset int) ([]*ErrorRecord, error)
+	clear(ctx context.Context, ip string, port uint32, service string) error
+}
+
+// ErrorTrackingStore is a Store that also keeps the last error seen while
+// processing each record key, so hosts whose scans keep failing can be
+// found and debugged
+// The SQL stores keep errors in their scan_errors table; the memory, Badger
+// and Redis stores keep them in memory for the life of the
+// ErrorTrackingStore
+// Store methods pass through to the wrapped store unchanged
+type ErrorTrackingStore struct {
+	inner  Store
+	errors errorLog
+}
+
+// NewErrorTrackingStore wraps inner so that processing errors can be
+// recorded alongside it
+// Wrappers are looked through with Unwrap to find the backing store, and an
+// error is returned when it is not one of the package's stores
+func NewErrorTrackingStore(inner Store) (*ErrorTrackingStore, error) {
+	var errors errorLog
+	switch s := unwrapStore(inner).(type) {
+	case *SQLiteStore:
+		errors = &sqlErrorLog{db: s.db, placeholder: func(int) string { return "?" }, timeArg: sqliteTime, upsertSQL: onConflictErrorSQL}
+	case *PostgresStore:
+		errors = &sqlErrorLog{db: s.db, placeholder: pgPlaceholder, timeArg: changelogTime, upsertSQL: onConflictErrorSQL}
+	case *PostgresStoreV2:
+		errors = &sqlErrorLog{db: s.db, placeholder: pgPlaceholder, timeArg: changelogTime, upsertSQL: onConflictErrorSQL}
+	case *MySQLStore:
+		errors = &sqlErrorLog{db: s.db, placeholder: func(int) string { return "?" }, timeArg: changelogTime, upsertSQL: mysqlErrorSQL}
+	case *MemoryStore, *BadgerStore, *RedisStore:
+		errors = &memoryErrorLog{records: make(map[RecordKey]*ErrorRecord)}
+	default:
+		return nil, fmt.Errorf("unsupported error tracking store: %T", s)
+	}
+	return &ErrorTrackingStore{inner: inner, errors: errors}, nil
+}
+
+// RecordError records err as the last error for a key and increments its
+// error count
+// The IP is stored in canonical form when it is a valid address, and as
+// given otherwise so that malformed input can still be traced
+func (s *ErrorTrackingStore) RecordError(ctx context.Context, ip string, port uint32, service string, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := &ErrorRecord{
+		IP:          CanonicalIP(ip),
+		Port:        port,
+		Service:     service,
+		LastError:   err.Error(),
+		ErrorCount:  1,
+		LastErrorAt: time.Now().UTC(),
+	}
+	if err := s.errors.record(ctx, e); err != nil {
+		return fmt.Errorf("failed to record error: %w", err)
+	}
+	return nil
+}
+
+// ListErrors returns recorded errors, most recent first
+// Use limit=0 to return every error
+func (s *ErrorTrackingStore) ListErrors(ctx context.Context, limit, offset int) ([]*ErrorRecord, error) {
+	return s.errors.list(ctx, limit, offset)
+}
+
+// ClearErrors forgets the recorded error for a key
+// Clearing a key with no recorded error is not an error
+func (s *ErrorTrackingStore) ClearErrors(ctx context.Context, ip string, port uint32, service string) error {
+	return s.errors.clear(ctx, CanonicalIP(ip), port, service)
+}
+
+// Upsert calls the wrapped store
+func (s *ErrorTrackingStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	return s.inner.Upsert(ctx, r)
+}
+
+// BulkUpsert calls the wrapped store
+func (s *ErrorTrackingStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	return s.inner.BulkUpsert(ctx, records)
+}
+
+// UpdateIfUnchanged calls the wrapped store
+func (s *ErrorTrackingStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	return s.inner.UpdateIfUnchanged(ctx, r, expectedTimestamp)
+}
+
+// Get calls the wrapped store
+func (s *ErrorTrackingStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	return s.inner.Get(ctx, ip, port, service)
+}
+
+// GetMulti calls the wrapped store
+func (s *ErrorTrackingStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	return s.inner.GetMulti(ctx, keys)
+}
+
+// List calls the wrapped store
+func (s *ErrorTrackingStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.List(ctx, limit, offset)
+}
+
+// ListV2 calls the wrapped store
+func (s *ErrorTrackingStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	return s.inner.ListV2(ctx, limit, offset)
+}
+
+// ListByIP calls the wrapped store
+func (s *ErrorTrackingStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return s.inner.ListByIP(ctx, ip)
+}
+
+// ListByService calls the wrapped store
+func (s *ErrorTrackingStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByService(ctx, service, limit, offset)
+}
+
+// ListByPort calls the wrapped store
+func (s *ErrorTrackingStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByPort(ctx, port, limit, offset)
+}
+
+// ListByTimestampRange calls the wrapped store
+func (s *ErrorTrackingStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByTimestampRange(ctx, from, to, limit, offset)
+}
+
+// ListByCIDR calls the wrapped store
+func (s *ErrorTrackingStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListByCIDR(ctx, cidr, limit, offset)
+}
+
+// SearchByResponse calls the wrapped store
+func (s *ErrorTrackingStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.SearchByResponse(ctx, query, limit, offset)
+}
+
+// ListAfter calls the wrapped store
+func (s *ErrorTrackingStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return s.inner.ListAfter(ctx, after, limit)
+}
+
+// ListDistinctIPs calls the wrapped store
+func (s *ErrorTrackingStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	return s.inner.ListDistinctIPs(ctx, limit, offset)
+}
+
+// CountDistinctIPs calls the wrapped store
+func (s *ErrorTrackingStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	return s.inner.CountDistinctIPs(ctx)
+}
+
+// ListDistinctServices calls the wrapped store
+func (s *ErrorTrackingStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return s.inner.ListDistinctServices(ctx)
+}
+
+// ListDistinctPorts calls the wrapped store
+func (s *ErrorTrackingStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return s.inner.ListDistinctPorts(ctx)
+}
+
+// FindCoOccurrence calls the wrapped store
+func (s *ErrorTrackingStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	return s.inner.FindCoOccurrence(ctx, services)
+}
+
+// ListChangedSince calls the wrapped store
+func (s *ErrorTrackingStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.inner.ListChangedSince(ctx, timestamp)
+}
+
+// Delete calls the wrapped store
+func (s *ErrorTrackingStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return s.inner.Delete(ctx, ip, port, service)
+}
+
+// Undelete calls the wrapped store
+func (s *ErrorTrackingStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return s.inner.Undelete(ctx, ip, port, service)
+}
+
+// ListDeleted calls the wrapped store
+func (s *ErrorTrackingStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.inner.ListDeleted(ctx, limit, offset)
+}
+
+// DeleteOlderThan calls the wrapped store
+func (s *ErrorTrackingStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	return s.inner.DeleteOlderThan(ctx, beforeTimestamp)
+}
+
+// Count calls the wrapped store
+func (s *ErrorTrackingStore) Count(ctx context.Context) (int64, error) {
+	return s.inner.Count(ctx)
+}
+
+// PurgeExpired calls the wrapped store
+func (s *ErrorTrackingStore) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.inner.PurgeExpired(ctx)
+}
+
+// Stats calls the wrapped store
+func (s *ErrorTrackingStore) Stats(ctx context.Context) (*StoreStats, error) {
+	return s.inner.Stats(ctx)
+}
+
+// Watch calls the wrapped store
+func (s *ErrorTrackingStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.inner.Watch(ctx)
+}
+
+// Unwatch calls the wrapped store
+func (s *ErrorTrackingStore) Unwatch(ch <-chan StoreEvent) {
+	s.inner.Unwatch(ch)
+}
+
+// HealthCheck calls the wrapped store
+func (s *ErrorTrackingStore) HealthCheck(ctx context.Context) error {
+	return s.inner.HealthCheck(ctx)
+}
+
+// Close closes the wrapped store
+func (s *ErrorTrackingStore) Close() error {
+	return s.inner.Close()
+}
+
+// Unwrap returns the wrapped store
+func (s *ErrorTrackingStore) Unwrap() Store {
+	return s.inner
+}
+
+// memoryErrorLog keeps errors in a map by record key
+type memoryErrorLog struct {
+	mu      sync.RWMutex
+	records map[RecordKey]*ErrorRecord
+}
+
+// record adds e or folds it into the existing error for its key
+func (l *memoryErrorLog) record(ctx context.Context, e *ErrorRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := RecordKey{IP: e.IP, Port: e.Port, Service: e.Service}
+	if existing, ok := l.records[key]; ok {
+		existing.LastError = e.LastError
+		existing.LastErrorAt = e.LastErrorAt
+		existing.ErrorCount++
+		return nil
+	}
+	stored := *e
+	l.records[key] = &stored
+	return nil
+}
+
+// list sorts copies of the errors by recency
+func (l *memoryErrorLog) list(ctx context.Context, limit, offset int) ([]*ErrorRecord, error) {
+	l.mu.RLock()
+	all := make([]*ErrorRecord, 0, len(l.records))
+	for _, e := range l.records {
+		copied := *e
+		all = append(all, &copied)
+	}
+	l.mu.RUnlock()
+
+	slices.SortFunc(all, func(a, b *ErrorRecord) int {
+		return cmp.Or(
+			b.LastErrorAt.Compare(a.LastErrorAt),
+			cmp.Compare(a.IP, b.IP),
+			cmp.Compare(a.Port, b.Port),
+			cmp.Compare(a.Service, b.Service),
+		)
+	})
+	return paginate(all, limit, offset), nil
+}
+
+// clear deletes the error for a key
+func (l *memoryErrorLog) clear(ctx context.Context, ip string, port uint32, service string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.records, RecordKey{IP: ip, Port: port, Service: service})
+	return nil
+}
+
+// onConflictErrorSQL upserts an error in SQLite and PostgreSQL
+// Arguments: ip, port, service, last_error, last_error_at
+const onConflictErrorSQL = `
+		INSERT INTO scan_errors (ip, port, service, last_error, error_count, last_error_at)
+		VALUES (%s, %s, %s, %s, 1, %s)
+		ON CONFLICT (ip, port, service) DO UPDATE SET
+			last_error = EXCLUDED.last_error,
+			error_count = scan_errors.error_count + 1,
+			last_error_at = EXCLUDED.last_error_at
+	`
+
+// mysqlErrorSQL upserts an error in MySQL
+// Arguments: ip, port, service, last_error, last_error_at
+const mysqlErrorSQL = `
+		INSERT INTO scan_errors (ip, port, service, last_error, error_count, last_error_at)
+		VALUES (%s, %s, %s, %s, 1, %s)
+		ON DUPLICATE KEY UPDATE
+			last_error = VALUES(last_error),
+			error_count = error_count + 1,
+			last_error_at = VALUES(last_error_at)
+	`
+
+// sqlErrorLog keeps errors in the scan_errors table of a SQL store
+type sqlErrorLog struct {
+	db          *sql.DB
+	placeholder func(n int) string
+	timeArg     func(*time.Time) interface{}
+	upsertSQL   string // format string taking five placeholders
+}
+
+// record upserts e
+func (l *sqlErrorLog) record(ctx context.Context, e *ErrorRecord) error {
+	p := l.placeholder
+	_, err := l.db.ExecContext(ctx, fmt.Sprintf(l.upsertSQL, p(1), p(2), p(3), p(4), p(5)),
+		e.IP, e.Port, e.Service, e.LastError, l.timeArg(&e.LastErrorAt))
+	if err != nil {
+		return fmt.Errorf("failed to upsert scan error: %w", err)
+	}
+	return nil
+}
+
+// list selects errors by recency
+func (l *sqlErrorLog) list(ctx context.Context, limit, offset int) ([]*ErrorRecord, error) {
+	p := l.placeholder
+	query := `
+		SELECT ip, port, service, last_error, error_count, last_error_at
+		FROM scan_errors
+		ORDER BY last_error_at DESC, ip, port, service
+	`
+	args := []interface{}{}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %s OFFSET %s", p(1), p(2))
+		args = append(args, limit, offset)
+	}
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scan errors: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]*ErrorRecord, 0)
+	for rows.Next() {
+		var e ErrorRecord
+		if err := rows.Scan(&e.IP, &e.Port, &e.Service, &e.LastError, &e.ErrorCount, &e.LastErrorAt); err != nil {
+			return nil, fmt.Errorf("failed to scan error record: %w", err)
+		}
+		records = append(records, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read scan errors: %w", err)
+	}
+	return records, nil
+}
+
+// clear deletes the error for a key
+func (l *sqlErrorLog) clear(ctx context.Context, ip string, port uint32, service string) error {
+	p := l.placeholder
+	_, err := l.db.ExecContext(ctx, fmt.Sprintf(`
+		DELETE FROM scan_errors WHERE ip = %s AND port = %s AND service = %s
+	`, p(1), p(2), p(3)), ip, port, service)
+	if err != nil {
+		return fmt.Errorf("failed to clear scan errors: %w", err)
+	}
+	return nil
+}
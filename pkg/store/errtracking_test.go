@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestErrorTrackingStore tests that errors are counted per key, listed most
+// recent first and cleared per key
+func TestErrorTrackingStore(t *testing.T) {
+	for name, inner := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := NewErrorTrackingStore(inner)
+			if err != nil {
+				t.Fatalf("NewErrorTrackingStore failed: %v", err)
+			}
+			start := time.Now().Add(-time.Second)
+
+			if err := s.RecordError(ctx, "1.1.1.1", 80, "HTTP", errors.New("first")); err != nil {
+				t.Fatalf("RecordError failed: %v", err)
+			}
+			// Separate the keys in time so the order does not depend on ties
+			time.Sleep(10 * time.Millisecond)
+			s.RecordError(ctx, "::ffff:1.1.1.1", 80, "HTTP", errors.New("second"))
+			time.Sleep(10 * time.Millisecond)
+			s.RecordError(ctx, "2.2.2.2", 443, "HTTPS", errors.New("other"))
+			s.RecordError(ctx, "3.3.3.3", 22, "SSH", nil)
+
+			errs, err := s.ListErrors(ctx, 0, 0)
+			if err != nil {
+				t.Fatalf("ListErrors failed: %v", err)
+			}
+			if len(errs) != 2 {
+				t.Fatalf("Expected 2 error records, got %d", len(errs))
+			}
+			if errs[0].IP != "2.2.2.2" || errs[0].ErrorCount != 1 {
+				t.Errorf("Expected the most recent error first, got %+v", errs[0])
+			}
+			e := errs[1]
+			if e.IP != "1.1.1.1" || e.Port != 80 || e.Service != "HTTP" || e.LastError != "second" || e.ErrorCount != 2 {
+				t.Errorf("Expected 2 errors for 1.1.1.1:80/HTTP ending in \"second\", got %+v", e)
+			}
+			if e.LastErrorAt.Before(start) || e.LastErrorAt.After(time.Now()) {
+				t.Errorf("Expected error time to be now, got %v", e.LastErrorAt)
+			}
+
+			if page, _ := s.ListErrors(ctx, 1, 1); len(page) != 1 || page[0].IP != "1.1.1.1" {
+				t.Errorf("Expected the second error on page 2, got %v", page)
+			}
+
+			if err := s.ClearErrors(ctx, "1.1.1.1", 80, "HTTP"); err != nil {
+				t.Fatalf("ClearErrors failed: %v", err)
+			}
+			if err := s.ClearErrors(ctx, "9.9.9.9", 80, "HTTP"); err != nil {
+				t.Errorf("Expected clearing an unknown key to succeed, got %v", err)
+			}
+			if errs, _ := s.ListErrors(ctx, 0, 0); len(errs) != 1 || errs[0].IP != "2.2.2.2" {
+				t.Errorf("Expected only 2.2.2.2 to remain, got %v", errs)
+			}
+		})
+	}
+}
+
+// TestErrorTrackingStoreUnwrap tests that errors against a wrapped SQL
+// store are kept in its table, and that stores it cannot see through are
+// rejected
+func TestErrorTrackingStoreUnwrap(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSQLiteStore(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	cached := NewCachingStore(NewRetryingStore(sqliteStore, 3, time.Millisecond), 10, 0)
+	s, err := NewErrorTrackingStore(cached)
+	if err != nil {
+		t.Fatalf("NewErrorTrackingStore failed: %v", err)
+	}
+	if err := s.RecordError(ctx, "1.1.1.1", 80, "HTTP", errors.New("failed")); err != nil {
+		t.Fatalf("RecordError failed: %v", err)
+	}
+
+	// A second tracker over the bare store reads the same table
+	direct, err := NewErrorTrackingStore(sqliteStore)
+	if err != nil {
+		t.Fatalf("NewErrorTrackingStore failed: %v", err)
+	}
+	if errs, err := direct.ListErrors(ctx, 0, 0); err != nil || len(errs) != 1 {
+		t.Errorf("Expected 1 error in the SQLite table, got %d (err %v)", len(errs), err)
+	}
+
+	if _, err := NewErrorTrackingStore(NewCompositeStore(NewMemoryStore())); err == nil {
+		t.Error("Expected an error for a store that cannot be unwrapped")
+	}
+}
@@ -0,0 +1,160 @@
+package store
+
+import "container/heap"
+
+// MemoryStoreOption configures a MemoryStore
+type MemoryStoreOption func(*MemoryStore)
+
+// WithMaxCapacity bounds a MemoryStore to n records, counting soft-deleted
+// ones
+// Inserting a new key into a full store evicts the record with the smallest
+// LastTimestamp; updates to existing keys never evict
+// Evicted records are dropped without a watch event
+// n <= 0 leaves the store unbounded
+func WithMaxCapacity(n int) MemoryStoreOption {
+	return func(s *MemoryStore) {
+		if n <= 0 {
+			return
+		}
+		s.maxCapacity = n
+		s.eviction = newEvictionIndex()
+	}
+}
+
+// Evictions returns the number of records evicted to stay within the
+// maximum capacity
+func (s *MemoryStore) Evictions() int64 {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.evictions
+}
+
+// trackLocked records key's timestamp for eviction
+// Caller must hold the write lock
+func (s *MemoryStore) trackLocked(key string, r *ServiceRecord) {
+	if s.eviction != nil {
+		s.eviction.set(key, r.LastTimestamp)
+	}
+}
+
+// deleteLocked removes the record for key
+// Caller must hold the write lock
+func (s *MemoryStore) deleteLocked(key string) {
+	delete(s.records, key)
+	if s.eviction != nil {
+		s.eviction.remove(key)
+	}
+}
+
+// makeRoomLocked evicts the oldest records until one more fits
+// Caller must hold the write lock
+func (s *MemoryStore) makeRoomLocked() {
+	s.evictOverLocked(s.maxCapacity - 1)
+}
+
+// evictOverLocked evicts the oldest records until at most n remain
+// Caller must hold the write lock
+func (s *MemoryStore) evictOverLocked(n int) {
+	if s.eviction == nil {
+		return
+	}
+	for len(s.records) > n {
+		delete(s.records, s.eviction.popOldest())
+		s.evictions++
+	}
+}
+
+// resetEvictionLocked rebuilds the eviction heap after the records are
+// replaced, evicting any over the capacity
+// Caller must hold the write lock
+func (s *MemoryStore) resetEvictionLocked() {
+	if s.eviction == nil {
+		return
+	}
+	s.eviction = newEvictionIndex()
+	for key, r := range s.records {
+		s.eviction.set(key, r.LastTimestamp)
+	}
+	s.evictOverLocked(s.maxCapacity)
+}
+
+// evictionEntry is a record's position in the eviction heap
+type evictionEntry struct {
+	timestamp int64
+	key       string
+	index     int
+}
+
+// evictionHeap is a min-heap of records ordered by LastTimestamp, with the
+// key breaking ties so eviction order is deterministic
+type evictionHeap []*evictionEntry
+
+func (h evictionHeap) Len() int { return len(h) }
+
+func (h evictionHeap) Less(i, j int) bool {
+	if h[i].timestamp != h[j].timestamp {
+		return h[i].timestamp < h[j].timestamp
+	}
+	return h[i].key < h[j].key
+}
+
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *evictionHeap) Push(x any) {
+	e := x.(*evictionEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *evictionHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// evictionIndex is an eviction heap with each key's entry, so a record's
+// timestamp can be updated or removed in O(log n)
+type evictionIndex struct {
+	heap    evictionHeap
+	entries map[string]*evictionEntry
+}
+
+func newEvictionIndex() *evictionIndex {
+	return &evictionIndex{entries: make(map[string]*evictionEntry)}
+}
+
+// set adds key or moves it to its new timestamp
+func (x *evictionIndex) set(key string, timestamp int64) {
+	if e, exists := x.entries[key]; exists {
+		e.timestamp = timestamp
+		heap.Fix(&x.heap, e.index)
+		return
+	}
+	e := &evictionEntry{timestamp: timestamp, key: key}
+	x.entries[key] = e
+	heap.Push(&x.heap, e)
+}
+
+// remove drops key if it is tracked
+func (x *evictionIndex) remove(key string) {
+	if e, exists := x.entries[key]; exists {
+		heap.Remove(&x.heap, e.index)
+		delete(x.entries, key)
+	}
+}
+
+// popOldest removes and returns the key with the smallest timestamp
+// The index must not be empty
+func (x *evictionIndex) popOldest() string {
+	e := heap.Pop(&x.heap).(*evictionEntry)
+	delete(x.entries, e.key)
+	return e.key
+}
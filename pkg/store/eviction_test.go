@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestMemoryStoreMaxCapacity tests that inserting past the capacity evicts
+// the records with the oldest timestamps
+func TestMemoryStoreMaxCapacity(t *testing.T) {
+	const n = 100
+	s := NewMemoryStore(WithMaxCapacity(n))
+	ctx := context.Background()
+
+	for i := 0; i < n+10; i++ {
+		r := &ServiceRecord{IP: IPAddress(fmt.Sprintf("10.0.0.%d", i)), Port: 80, Service: "HTTP", LastTimestamp: int64(1000 + i)}
+		if _, err := s.Upsert(ctx, r); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+
+	if s.Len() != n {
+		t.Errorf("Expected %d records, got %d", n, s.Len())
+	}
+	if s.Evictions() != 10 {
+		t.Errorf("Expected 10 evictions, got %d", s.Evictions())
+	}
+
+	for i := 0; i < n+10; i++ {
+		got, err := s.Get(ctx, fmt.Sprintf("10.0.0.%d", i), 80, "HTTP")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if i < 10 && got != nil {
+			t.Errorf("Expected timestamp %d to be evicted", 1000+i)
+		}
+		if i >= 10 && got == nil {
+			t.Errorf("Expected timestamp %d to be kept", 1000+i)
+		}
+	}
+
+	// Updating an existing key does not evict
+	newest := &ServiceRecord{IP: IPAddress(fmt.Sprintf("10.0.0.%d", n+9)), Port: 80, Service: "HTTP", LastTimestamp: 5000, Response: "updated"}
+	updated, err := s.Upsert(ctx, newest)
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if !updated {
+		t.Error("Expected the newest record to be updated")
+	}
+	if s.Len() != n || s.Evictions() != 10 {
+		t.Errorf("Expected %d records and 10 evictions, got %d and %d", n, s.Len(), s.Evictions())
+	}
+}
+
+// TestMemoryStoreMaxCapacityUpdatedTimestamp tests that eviction uses a
+// record's current timestamp, so a refreshed record outlives newer inserts
+func TestMemoryStoreMaxCapacityUpdatedTimestamp(t *testing.T) {
+	s := NewMemoryStore(WithMaxCapacity(2))
+	ctx := context.Background()
+
+	for _, r := range []*ServiceRecord{
+		{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 100},
+		{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 200},
+		// 1.1.1.1 becomes the newest record
+		{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 300},
+		{IP: "3.3.3.3", Port: 80, Service: "HTTP", LastTimestamp: 250},
+	} {
+		if _, err := s.Upsert(ctx, r); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		ip   string
+		kept bool
+	}{
+		{"1.1.1.1", true},
+		{"2.2.2.2", false},
+		{"3.3.3.3", true},
+	}
+	for _, tt := range tests {
+		got, err := s.Get(ctx, tt.ip, 80, "HTTP")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if (got != nil) != tt.kept {
+			t.Errorf("Expected %s kept=%v, got %+v", tt.ip, tt.kept, got)
+		}
+	}
+
+	// Removed records leave room without an eviction
+	if _, err := s.DeleteOlderThan(ctx, 260); err != nil {
+		t.Fatalf("DeleteOlderThan failed: %v", err)
+	}
+	if _, err := s.Upsert(ctx, &ServiceRecord{IP: "4.4.4.4", Port: 80, Service: "HTTP", LastTimestamp: 50}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if s.Len() != 2 || s.Evictions() != 1 {
+		t.Errorf("Expected 2 records and 1 eviction, got %d and %d", s.Len(), s.Evictions())
+	}
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// StartExpiryWorker calls s.PurgeExpired every interval until ctx is done or
+// the returned stop function is called
+// Purge failures are logged and retried on the next tick; stop waits for an
+// in-progress purge to finish and is safe to call more than once
+func StartExpiryWorker(ctx context.Context, s Store, interval time.Duration) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := s.PurgeExpired(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						slog.Warn("failed to purge expired records", slog.Any("error", err))
+					}
+					continue
+				}
+				if n > 0 {
+					slog.Info("purged expired records", slog.Int64("count", n))
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// exportPageSize is how many records the exporters read per List call
+	exportPageSize = 500
+
+	// importBatchSize is how many records the importers pass to each
+	// BulkUpsert
+	importBatchSize = 500
+)
+
+// csvHeader is the column order written by ExportCSV
+var csvHeader = []string{"ip", "port", "service", "last_timestamp", "response", "updated_at", "first_seen_at", "scan_count"}
+
+// ExportJSON writes every live record in s to w as newline-delimited JSON,
+// one ServiceRecord per line
+// Records are read a page at a time with List, so memory use does not grow
+// with the store; records written during the export may be missed or
+// repeated
+// Soft-deleted records are not exported
+func ExportJSON(ctx context.Context, s Store, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return exportRecords(ctx, s, func(r *ServiceRecord) error {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+		return nil
+	})
+}
+
+// ExportCSV writes every live record in s to w as CSV with a header row
+// Only the columns in csvHeader are written, with times in RFC 3339;
+// records are read a page at a time as in ExportJSON
+func ExportCSV(ctx context.Context, s Store, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	row := make([]string, len(csvHeader))
+	err := exportRecords(ctx, s, func(r *ServiceRecord) error {
+		row[0] = r.IP.String()
+		row[1] = strconv.FormatUint(uint64(r.Port), 10)
+		row[2] = r.Service
+		row[3] = strconv.FormatInt(r.LastTimestamp, 10)
+		row[4] = r.Response
+		row[5] = r.UpdatedAt.Format(time.RFC3339Nano)
+		row[6] = r.FirstSeenAt.Format(time.RFC3339Nano)
+		row[7] = strconv.FormatInt(r.ScanCount, 10)
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+	return nil
+}
+
+// exportRecords calls fn for every live record in s, reading them a page
+// at a time with List
+func exportRecords(ctx context.Context, s Store, fn func(*ServiceRecord) error) error {
+	for offset := 0; ; offset += exportPageSize {
+		page, err := s.List(ctx, exportPageSize, offset)
+		if err != nil {
+			return fmt.Errorf("failed to list records: %w", err)
+		}
+		for _, r := range page {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+	}
+}
+
+// ImportJSON reads newline-delimited JSON records written by ExportJSON from
+// r and upserts them into s in batches, returning the number of records
+// inserted or updated
+// Records go through BulkUpsert, so older timestamps are skipped and the
+// store sets UpdatedAt, FirstSeenAt, ScanCount and the previous response
+// as for any other write
+func ImportJSON(ctx context.Context, s Store, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	i := 0
+	return importRecords(ctx, s, func() (*ServiceRecord, error) {
+		i++
+		var record ServiceRecord
+		err := dec.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode record %d: %w", i, err)
+		}
+		return &record, nil
+	})
+}
+
+// ImportCSV reads CSV written by ExportCSV from r and upserts the records
+// into s as ImportJSON does
+// encoding/csv reads a \r\n inside a quoted field as \n, so responses with
+// CRLF line endings come back with bare newlines; use ExportJSON where
+// responses must round-trip byte for byte
+// Columns are matched by the header row and may appear in any order; ip,
+// port, service and last_timestamp are required and the rest are optional
+// updated_at, first_seen_at and scan_count are set by the store, so their
+// values are ignored, as are unknown columns
+func ImportCSV(ctx context.Context, s Store, r io.Reader) (int, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"ip", "port", "service", "last_timestamp"} {
+		if _, ok := columns[name]; !ok {
+			return 0, fmt.Errorf("missing required column %q", name)
+		}
+	}
+	responseCol, hasResponse := columns["response"]
+
+	rowNum := 1
+	return importRecords(ctx, s, func() (*ServiceRecord, error) {
+		rowNum++
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read row %d: %w", rowNum, err)
+		}
+
+		port, err := strconv.ParseUint(row[columns["port"]], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port on row %d: %w", rowNum, err)
+		}
+		ts, err := strconv.ParseInt(row[columns["last_timestamp"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid last_timestamp on row %d: %w", rowNum, err)
+		}
+		record := &ServiceRecord{
+			IP:            IPAddress(row[columns["ip"]]),
+			Port:          uint32(port),
+			Service:       row[columns["service"]],
+			LastTimestamp: ts,
+		}
+		if hasResponse {
+			record.Response = row[responseCol]
+			record.ResponseHash = HashResponse(record.Response)
+		}
+		return record, nil
+	})
+}
+
+// importRecords upserts the records returned by next in batches until next
+// returns io.EOF, returning the number inserted or updated
+// Records read before an error from next are still imported
+func importRecords(ctx context.Context, s Store, next func() (*ServiceRecord, error)) (int, error) {
+	batch := make([]*ServiceRecord, 0, importBatchSize)
+	imported := 0
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := s.BulkUpsert(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("failed to upsert records: %w", err)
+		}
+		imported += n
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+			return imported, err
+		}
+
+		batch = append(batch, record)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// IPAddress is the address of a scanned host
+// Addresses are kept as text in the canonical form of net.IP.String, so
+// IPv4 and IPv4-mapped IPv6 addresses are dotted quads and other IPv6
+// addresses are compressed and lowercase; every spelling of one address
+// then has the same key in every store
+// JSON encoding canonicalizes in both directions
+type IPAddress string
+
+// ParseIPAddress parses an IPv4 or IPv6 address in any textual form and
+// returns its canonical form
+func ParseIPAddress(s string) (IPAddress, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address: %q", s)
+	}
+	return IPAddress(ip.String()), nil
+}
+
+// CanonicalIP returns the canonical form of ip, or ip unchanged if it is
+// not a valid IP address
+func CanonicalIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+// canonicalRecord returns r with its IP in canonical form
+// r is copied when its IP changes so the caller's record is left as it was
+func canonicalRecord(r *ServiceRecord) *ServiceRecord {
+	ip := IPAddress(CanonicalIP(r.IP.String()))
+	if ip == r.IP {
+		return r
+	}
+	c := *r
+	c.IP = ip
+	return &c
+}
+
+// canonicalKeys returns keys with their IPs in canonical form
+func canonicalKeys(keys []RecordKey) []RecordKey {
+	out := make([]RecordKey, len(keys))
+	for i, k := range keys {
+		out[i] = RecordKey{IP: CanonicalIP(k.IP), Port: k.Port, Service: k.Service}
+	}
+	return out
+}
+
+// String returns the address as text
+func (a IPAddress) String() string {
+	return string(a)
+}
+
+// NetIP returns the address as a net.IP, or nil if it is not valid
+func (a IPAddress) NetIP() net.IP {
+	return net.ParseIP(string(a))
+}
+
+// MarshalJSON encodes the address as a JSON string in canonical form
+func (a IPAddress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(CanonicalIP(string(a)))
+}
+
+// UnmarshalJSON decodes a JSON string holding an IPv4 or IPv6 address and
+// stores its canonical form
+// An empty string decodes to the empty address
+func (a *IPAddress) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*a = ""
+		return nil
+	}
+	ip, err := ParseIPAddress(s)
+	if err != nil {
+		return err
+	}
+	*a = ip
+	return nil
+}
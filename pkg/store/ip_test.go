@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// TestParseIPAddress tests canonicalization of IPv4, IPv6 and dual-stack
+// addresses and rejection of anything else
+func TestParseIPAddress(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    IPAddress
+		wantErr bool
+	}{
+		{"1.2.3.4", "1.2.3.4", false},
+		{"::1", "::1", false},
+		{"0:0:0:0:0:0:0:1", "::1", false},
+		{"0:0::1", "::1", false},
+		{"2001:DB8:0:0:0:0:0:1", "2001:db8::1", false},
+		{"::ffff:127.0.0.1", "127.0.0.1", false},
+		{"::ffff:7f00:1", "127.0.0.1", false},
+		{"", "", true},
+		{"not-an-ip", "", true},
+		{"1.2.3.256", "", true},
+		{"1.2.3.4/24", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseIPAddress(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseIPAddress(%q): expected error %v, got %v", tt.raw, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseIPAddress(%q): expected %q, got %q", tt.raw, tt.want, got)
+		}
+	}
+}
+
+// TestIPAddressJSON tests that JSON encoding canonicalizes addresses and
+// decoding rejects invalid ones
+func TestIPAddressJSON(t *testing.T) {
+	var r ServiceRecord
+	if err := json.Unmarshal([]byte(`{"IP": "0:0:0:0:0:0:0:1"}`), &r); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if r.IP != "::1" {
+		t.Errorf("Expected ::1, got %q", r.IP)
+	}
+
+	data, err := json.Marshal(IPAddress("::FFFF:10.0.0.1"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `"10.0.0.1"` {
+		t.Errorf("Expected \"10.0.0.1\", got %s", data)
+	}
+
+	if err := json.Unmarshal([]byte(`{"IP": "not-an-ip"}`), &r); err == nil {
+		t.Error("Expected error for an invalid address")
+	}
+	if err := json.Unmarshal([]byte(`{"IP": ""}`), &r); err != nil || r.IP != "" {
+		t.Errorf("Expected an empty address to decode, got %q (err %v)", r.IP, err)
+	}
+}
+
+// TestMemoryStoreIPv6Key tests that different spellings of one IPv6
+// address share a record
+func TestMemoryStoreIPv6Key(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	defer s.Close()
+
+	s.Upsert(ctx, &ServiceRecord{IP: "::1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "a"})
+	s.Upsert(ctx, &ServiceRecord{IP: "0:0::1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "b"})
+
+	if s.Len() != 1 {
+		t.Errorf("Expected 1 record, got %d", s.Len())
+	}
+	r, _ := s.Get(ctx, "0:0:0:0:0:0:0:1", 80, "HTTP")
+	if r == nil || r.Response != "b" || r.ScanCount != 2 {
+		t.Errorf("Expected the updated record, got %+v", r)
+	}
+}
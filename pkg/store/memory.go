@@ -1,9 +1,12 @@
 package store
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -13,18 +16,61 @@ import (
 type MemoryStore struct {
 	mu      sync.RWMutex
 	records map[string]*ServiceRecord // key: "ip:port:service"
+	hub     watchHub
+
+	// snapshotPath is saved to by Close when set, see
+	// NewPersistentMemoryStore
+	snapshotPath string
+
+	// maxCapacity bounds the number of records when set, see
+	// WithMaxCapacity; eviction orders them by timestamp
+	maxCapacity int
+	eviction    *evictionIndex
+	evictions   int64
 }
 
 // NewMemoryStore creates a new in-memory store
-func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{
+func NewMemoryStore(opts ...MemoryStoreOption) *MemoryStore {
+	s := &MemoryStore{
 		records: make(map[string]*ServiceRecord),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // makeKey creates a composite key from ip, port, and service
+// The IP is canonicalized so every spelling of an address shares one key
 func makeKey(ip string, port uint32, service string) string {
-	return fmt.Sprintf("%s:%d:%s", ip, port, service)
+	return fmt.Sprintf("%s:%d:%s", CanonicalIP(ip), port, service)
+}
+
+// copyRecord returns a copy of r so callers cannot mutate stored records
+func copyRecord(r *ServiceRecord) *ServiceRecord {
+	c := *r
+	c.ExpiresAt = copyTime(r.ExpiresAt)
+	c.DeletedAt = copyTime(r.DeletedAt)
+	c.MessageMetadata = copyMessageMetadata(r.MessageMetadata)
+	return &c
+}
+
+// copyMessageMetadata returns a copy of optional message metadata
+func copyMessageMetadata(m *MessageMetadata) *MessageMetadata {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	return &c
+}
+
+// copyTime returns a copy of an optional time
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
 }
 
 // Upsert inserts or updates a record if the timestamp is newer
@@ -33,25 +79,108 @@ func (s *MemoryStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	key := makeKey(r.IP, r.Port, r.Service)
+	return s.upsertLocked(r), nil
+}
+
+// BulkUpsert upserts a batch of records under a single lock
+func (s *MemoryStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	// Acquire exclusive lock for writing - blocks other reads and writes until unlocked
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Dedupe first so the count matches the SQL stores
+	updated := 0
+	for _, r := range dedupeRecords(records) {
+		if s.upsertLocked(r) {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// UpdateIfUnchanged replaces a record under the write lock if its
+// LastTimestamp equals expectedTimestamp
+func (s *MemoryStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	// Acquire exclusive lock for writing - blocks other reads and writes until unlocked
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := makeKey(r.IP.String(), r.Port, r.Service)
+	existing, exists := s.records[key]
+	if !exists || existing.DeletedAt != nil || existing.LastTimestamp != expectedTimestamp {
+		return false, nil
+	}
+
+	record := upsertedRecord(r, existing, time.Now())
+	s.records[key] = record
+	s.trackLocked(key, record)
+	if s.hub.active() {
+		s.hub.publish(StoreEvent{Type: EventUpdated, Record: copyRecord(record), Previous: copyRecord(existing)})
+	}
+	return true, nil
+}
+
+// upsertLocked stores r if it is newer than the existing record
+// Caller must hold the write lock
+func (s *MemoryStore) upsertLocked(r *ServiceRecord) bool {
+	key := makeKey(r.IP.String(), r.Port, r.Service)
 	existing, exists := s.records[key]
 
 	if !exists || r.LastTimestamp > existing.LastTimestamp {
-		// Create a copy to avoid external mutation
-		record := &ServiceRecord{
-			IP:            r.IP,
-			Port:          r.Port,
-			Service:       r.Service,
-			LastTimestamp: r.LastTimestamp,
-			Response:      r.Response,
-			UpdatedAt:     time.Now(),
+		if !exists && s.maxCapacity > 0 && len(s.records) >= s.maxCapacity {
+			s.makeRoomLocked()
 		}
+		record := upsertedRecord(r, existing, time.Now())
 		s.records[key] = record
-		return true, nil
+		s.trackLocked(key, record)
+
+		if s.hub.active() {
+			if exists {
+				s.hub.publish(StoreEvent{Type: EventUpdated, Record: copyRecord(record), Previous: copyRecord(existing)})
+			} else {
+				s.hub.publish(StoreEvent{Type: EventCreated, Record: copyRecord(record)})
+			}
+		}
+		return true
 	}
 
 	// Older record, skip
-	return false, nil
+	if s.hub.active() {
+		s.hub.publish(StoreEvent{Type: EventSkipped, Record: copyRecord(r), Previous: copyRecord(existing)})
+	}
+	return false
+}
+
+// upsertedRecord returns the record stored when r replaces existing, which
+// is nil on insert
+// Updates keep the first-seen time, count the scan, remember the replaced
+// response and do not restore soft-deleted records
+func upsertedRecord(r, existing *ServiceRecord, now time.Time) *ServiceRecord {
+	// Create a copy to avoid external mutation
+	record := &ServiceRecord{
+		IP:                IPAddress(CanonicalIP(r.IP.String())),
+		Port:              r.Port,
+		Service:           r.Service,
+		LastTimestamp:     r.LastTimestamp,
+		Response:          r.Response,
+		UpdatedAt:         now,
+		FirstSeenAt:       now,
+		ScanCount:         1,
+		TLSVersion:        r.TLSVersion,
+		StatusCode:        r.StatusCode,
+		ExpiresAt:         copyTime(r.ExpiresAt),
+		ResponseHash:      r.ResponseHash,
+		ResponseTruncated: r.ResponseTruncated,
+		MessageMetadata:   copyMessageMetadata(r.MessageMetadata),
+	}
+	if existing != nil {
+		record.FirstSeenAt = existing.FirstSeenAt
+		record.ScanCount = existing.ScanCount + 1
+		record.PreviousResponse = existing.Response
+		record.PreviousResponseHash = existing.ResponseHash
+		record.DeletedAt = copyTime(existing.DeletedAt)
+	}
+	return record
 }
 
 // Get retrieves a record by its composite key
@@ -62,68 +191,393 @@ func (s *MemoryStore) Get(ctx context.Context, ip string, port uint32, service s
 
 	key := makeKey(ip, port, service)
 	record, exists := s.records[key]
-	if !exists {
+	if !exists || record.DeletedAt != nil {
 		return nil, nil
 	}
 
 	// Return a copy to avoid external mutation
-	return &ServiceRecord{
-		IP:            record.IP,
-		Port:          record.Port,
-		Service:       record.Service,
-		LastTimestamp: record.LastTimestamp,
-		Response:      record.Response,
-		UpdatedAt:     record.UpdatedAt,
-	}, nil
+	return copyRecord(record), nil
+}
+
+// GetMulti retrieves several records under a single read lock
+func (s *MemoryStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := make(map[RecordKey]*ServiceRecord, len(keys))
+	for _, k := range keys {
+		if r, exists := s.records[makeKey(k.IP, k.Port, k.Service)]; exists && r.DeletedAt == nil {
+			found[r.Key()] = copyRecord(r)
+		}
+	}
+	return found, nil
 }
 
 // List returns all records with optional pagination
 func (s *MemoryStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(ctx, func(*ServiceRecord) bool { return true }, limit, offset)
+}
+
+// ListV2 returns a page of records and the number of live records, taken
+// under one read lock
+func (s *MemoryStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	records, total, err := s.listWhereTotal(ctx, func(*ServiceRecord) bool { return true }, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &ListResult{Records: records, TotalCount: total}, nil
+}
+
+// listWhere returns copies of matching records in compareListOrder with
+// optional pagination
+// Soft-deleted records are never matched
+func (s *MemoryStore) listWhere(ctx context.Context, match func(*ServiceRecord) bool, limit, offset int) ([]*ServiceRecord, error) {
+	records, _, err := s.listWhereTotal(ctx, match, limit, offset)
+	return records, err
+}
+
+// listWhereTotal is listWhere that also returns the number of matching
+// records before pagination
+// Scanning every record can take a while on a large store, so a cancelled
+// ctx is checked before the sort
+func (s *MemoryStore) listWhereTotal(ctx context.Context, match func(*ServiceRecord) bool, limit, offset int) ([]*ServiceRecord, int64, error) {
 	// Acquire read lock - allows multiple concurrent readers, but blocks writers
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// Collect all records
+	// Collect matching records
 	all := make([]*ServiceRecord, 0, len(s.records))
 	for _, r := range s.records {
-		all = append(all, &ServiceRecord{
-			IP:            r.IP,
-			Port:          r.Port,
-			Service:       r.Service,
-			LastTimestamp: r.LastTimestamp,
-			Response:      r.Response,
-			UpdatedAt:     r.UpdatedAt,
-		})
-	}
-
-	// Sort by timestamp descending
-	sort.Slice(all, func(i, j int) bool {
-		return all[i].LastTimestamp > all[j].LastTimestamp
+		if r.DeletedAt == nil && match(r) {
+			all = append(all, copyRecord(r))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Sort by timestamp descending, then by composite key
+	sort.SliceStable(all, func(i, j int) bool {
+		return compareListOrder(all[i], all[j]) < 0
+	})
+
+	return paginate(all, limit, offset), int64(len(all)), nil
+}
+
+// ListByIP returns all records for the given IP address
+func (s *MemoryStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	return s.listWhere(ctx, func(r *ServiceRecord) bool { return r.IP.String() == ip }, 0, 0)
+}
+
+// ListByService returns records for the given service with optional pagination
+func (s *MemoryStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(ctx, func(r *ServiceRecord) bool { return r.Service == service }, limit, offset)
+}
+
+// ListByPort returns records for the given port with optional pagination
+func (s *MemoryStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(ctx, func(r *ServiceRecord) bool { return r.Port == port }, limit, offset)
+}
+
+// ListByTimestampRange returns records within the given timestamp window
+func (s *MemoryStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(ctx, func(r *ServiceRecord) bool {
+		return (from == 0 || r.LastTimestamp >= from) && (to == 0 || r.LastTimestamp <= to)
+	}, limit, offset)
+}
+
+// ListByCIDR returns records whose IP falls within the given CIDR range
+func (s *MemoryStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.listWhere(ctx, func(r *ServiceRecord) bool {
+		return cidrContains(network, r.IP.String())
+	}, limit, offset)
+}
+
+// SearchByResponse returns records whose response contains query, ignoring case
+func (s *MemoryStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	query = strings.ToLower(query)
+	return s.listWhere(ctx, func(r *ServiceRecord) bool {
+		return strings.Contains(strings.ToLower(r.Response), query)
+	}, limit, offset)
+}
+
+// ListAfter returns records after the given cursor using keyset pagination
+func (s *MemoryStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Collect records that come after the cursor
+	page := make([]*ServiceRecord, 0)
+	for _, r := range s.records {
+		if r.DeletedAt != nil || !after.precedes(r) {
+			continue
+		}
+		page = append(page, copyRecord(r))
+	}
+
+	slices.SortFunc(page, compareListOrder)
+
+	if limit > 0 && limit < len(page) {
+		page = page[:limit]
+	}
+
+	return page, nil
+}
+
+// ListDistinctIPs returns the unique IPs of live records with optional
+// pagination
+func (s *MemoryStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	return paginate(memoryDistinct(s, recordIP), limit, offset), nil
+}
+
+// CountDistinctIPs returns the number of unique IPs of live records
+func (s *MemoryStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	return int64(len(memoryDistinct(s, recordIP))), nil
+}
+
+// ListDistinctServices returns the unique services of live records
+func (s *MemoryStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return memoryDistinct(s, recordService), nil
+}
+
+// ListDistinctPorts returns the unique ports of live records
+func (s *MemoryStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return memoryDistinct(s, recordPort), nil
+}
+
+// memoryDistinct returns the sorted unique values of key over the live
+// records of s
+func memoryDistinct[T cmp.Ordered](s *MemoryStore, key func(*ServiceRecord) T) []T {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	live := make([]*ServiceRecord, 0, len(s.records))
+	for _, r := range s.records {
+		if r.DeletedAt == nil {
+			live = append(live, r)
+		}
+	}
+	return distinct(live, key)
+}
+
+// FindCoOccurrence returns the IPs with a live record matching every filter
+func (s *MemoryStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	live := make([]*ServiceRecord, 0, len(s.records))
+	for _, r := range s.records {
+		if r.DeletedAt == nil {
+			live = append(live, r)
+		}
+	}
+
+	summaries := coOccurring(live, services)
+	for _, summary := range summaries {
+		for i, r := range summary.Records {
+			summary.Records[i] = copyRecord(r)
+		}
+	}
+	return summaries, nil
+}
+
+// ListChangedSince returns records updated after timestamp with a new
+// response hash
+func (s *MemoryStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	changed := make([]*ServiceRecord, 0)
+	for _, r := range s.records {
+		if r.DeletedAt == nil && r.LastTimestamp > timestamp && hashChanged(r) {
+			changed = append(changed, copyRecord(r))
+		}
+	}
+
+	// Sort by timestamp descending, then IP ascending
+	sort.Slice(changed, func(i, j int) bool {
+		if changed[i].LastTimestamp != changed[j].LastTimestamp {
+			return changed[i].LastTimestamp > changed[j].LastTimestamp
+		}
+		return changed[i].IP < changed[j].IP
+	})
+
+	return changed, nil
+}
+
+// Delete soft-deletes a record by setting DeletedAt
+func (s *MemoryStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	// Acquire exclusive lock for writing - blocks other reads and writes until unlocked
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.records[makeKey(ip, port, service)]
+	if !exists || record.DeletedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	record.DeletedAt = &now
+	return true, nil
+}
+
+// Undelete clears DeletedAt on a soft-deleted record
+func (s *MemoryStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	// Acquire exclusive lock for writing - blocks other reads and writes until unlocked
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.records[makeKey(ip, port, service)]
+	if !exists || record.DeletedAt == nil {
+		return false, nil
+	}
+
+	record.DeletedAt = nil
+	return true, nil
+}
+
+// ListDeleted returns soft-deleted records, most recently deleted first
+func (s *MemoryStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deleted := make([]*ServiceRecord, 0)
+	for _, r := range s.records {
+		if r.DeletedAt != nil {
+			deleted = append(deleted, copyRecord(r))
+		}
+	}
+
+	// Sort by deletion time descending, then timestamp descending
+	sort.Slice(deleted, func(i, j int) bool {
+		if !deleted[i].DeletedAt.Equal(*deleted[j].DeletedAt) {
+			return deleted[i].DeletedAt.After(*deleted[j].DeletedAt)
+		}
+		return deleted[i].LastTimestamp > deleted[j].LastTimestamp
 	})
 
-	// Apply pagination
-	if offset >= len(all) {
-		return []*ServiceRecord{}, nil
+	return paginate(deleted, limit, offset), nil
+}
+
+// DeleteOlderThan removes records with a timestamp before beforeTimestamp
+// Soft-deleted records are removed as well
+func (s *MemoryStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	// Acquire exclusive lock for writing - blocks other reads and writes until unlocked
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for key, r := range s.records {
+		if r.LastTimestamp < beforeTimestamp {
+			s.deleteLocked(key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// PurgeExpired removes records whose ExpiresAt is in the past
+func (s *MemoryStore) PurgeExpired(ctx context.Context) (int64, error) {
+	// Acquire exclusive lock for writing - blocks other reads and writes until unlocked
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var purged int64
+	for key, r := range s.records {
+		if r.ExpiresAt != nil && r.ExpiresAt.Before(now) {
+			s.deleteLocked(key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Count returns the total number of records
+func (s *MemoryStore) Count(ctx context.Context) (int64, error) {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, r := range s.records {
+		if r.DeletedAt == nil {
+			count++
+		}
 	}
+	return count, nil
+}
 
-	all = all[offset:]
+// Stats computes aggregate counts in a single pass over the records
+func (s *MemoryStore) Stats(ctx context.Context) (*StoreStats, error) {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if limit > 0 && limit < len(all) {
-		all = all[:limit]
+	stats := &StoreStats{
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
 	}
 
-	return all, nil
+	first := true
+	for _, r := range s.records {
+		if r.DeletedAt != nil {
+			continue
+		}
+		stats.TotalRecords++
+		stats.RecordsByService[r.Service]++
+		stats.RecordsByPort[r.Port]++
+		if first || r.LastTimestamp < stats.OldestTimestamp {
+			stats.OldestTimestamp = r.LastTimestamp
+		}
+		if first || r.LastTimestamp > stats.NewestTimestamp {
+			stats.NewestTimestamp = r.LastTimestamp
+		}
+		first = false
+	}
+
+	return stats, nil
+}
+
+// Watch returns a channel of record change events
+func (s *MemoryStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.hub.watch(ctx)
+}
+
+// Unwatch stops delivery to a channel returned by Watch and closes it
+func (s *MemoryStore) Unwatch(ch <-chan StoreEvent) {
+	s.hub.unwatch(ch)
+}
+
+// HealthCheck always succeeds for the in-memory store
+func (s *MemoryStore) HealthCheck(ctx context.Context) error {
+	return nil
 }
 
-// Close is a no-op for memory store
+// Close closes any open watch channels and, for a persistent store, saves
+// the snapshot
 func (s *MemoryStore) Close() error {
+	s.hub.close()
+	if s.snapshotPath != "" {
+		return s.SaveSnapshot(s.snapshotPath)
+	}
 	return nil
 }
 
 // Len returns the number of records (useful for testing)
 func (s *MemoryStore) Len() int {
-	// Acquire read lock - allows multiple concurrent readers, but blocks writers
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return len(s.records)
+	n, _ := s.Count(context.Background())
+	return int(n)
 }
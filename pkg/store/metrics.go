@@ -0,0 +1,363 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operation label values for the MetricsStore collectors, one per Store
+// method
+const (
+	opUpsert               = "upsert"
+	opBulkUpsert           = "bulk_upsert"
+	opUpdateIfUnchanged    = "update_if_unchanged"
+	opGet                  = "get"
+	opGetMulti             = "get_multi"
+	opList                 = "list"
+	opListV2               = "list_v2"
+	opListByIP             = "list_by_ip"
+	opListByService        = "list_by_service"
+	opListByPort           = "list_by_port"
+	opListByTimestampRange = "list_by_timestamp_range"
+	opListByCIDR           = "list_by_cidr"
+	opSearchByResponse     = "search_by_response"
+	opListAfter            = "list_after"
+	opListDistinctIPs      = "list_distinct_ips"
+	opCountDistinctIPs     = "count_distinct_ips"
+	opListDistinctServices = "list_distinct_services"
+	opListDistinctPorts    = "list_distinct_ports"
+	opFindCoOccurrence     = "find_co_occurrence"
+	opListChangedSince     = "list_changed_since"
+	opDelete               = "delete"
+	opUndelete             = "undelete"
+	opListDeleted          = "list_deleted"
+	opDeleteOlderThan      = "delete_older_than"
+	opPurgeExpired         = "purge_expired"
+	opCount                = "count"
+	opStats                = "stats"
+	opHealthCheck          = "health_check"
+)
+
+// metricsOperations lists every operation label so the series exist before
+// the first call
+var metricsOperations = []string{
+	opUpsert, opBulkUpsert, opUpdateIfUnchanged, opGet, opGetMulti, opList,
+	opListV2, opListByIP, opListByService, opListByPort, opListByTimestampRange,
+	opListByCIDR, opSearchByResponse, opListAfter, opListDistinctIPs,
+	opCountDistinctIPs, opListDistinctServices, opListDistinctPorts,
+	opFindCoOccurrence, opListChangedSince, opDelete, opUndelete, opListDeleted,
+	opDeleteOlderThan, opPurgeExpired, opCount, opStats, opHealthCheck,
+}
+
+// MetricsStore is a Store that records the latency and errors of every call
+// to the store it wraps
+// Calls are passed through unchanged; Watch, Unwatch and Close are not
+// measured
+type MetricsStore struct {
+	inner Store
+
+	duration      *prometheus.HistogramVec
+	errors        *prometheus.CounterVec
+	upsertUpdated prometheus.Counter
+	upsertSkipped prometheus.Counter
+}
+
+// NewMetricsStore wraps inner and registers its collectors with reg
+func NewMetricsStore(inner Store, reg prometheus.Registerer) Store {
+	s := &MetricsStore{
+		inner: inner,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "store_operation_duration_seconds",
+			Help:    "Time taken by store operations, by operation.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "store_operation_errors_total",
+			Help: "Store operations that returned an error, by operation.",
+		}, []string{"operation"}),
+		upsertUpdated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "store_upsert_updated_total",
+			Help: "Records inserted or updated by Upsert and BulkUpsert.",
+		}),
+		upsertSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "store_upsert_skipped_total",
+			Help: "Records skipped by Upsert and BulkUpsert as not newer than the stored record.",
+		}),
+	}
+
+	// Pre-create each operation so the series exist before the first call
+	for _, op := range metricsOperations {
+		s.duration.WithLabelValues(op)
+		s.errors.WithLabelValues(op)
+	}
+
+	reg.MustRegister(s.duration, s.errors, s.upsertUpdated, s.upsertSkipped)
+	return s
+}
+
+// observe records the duration of an operation started at start and
+// counts err
+func (s *MetricsStore) observe(op string, start time.Time, err error) {
+	s.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.errors.WithLabelValues(op).Inc()
+	}
+}
+
+// Upsert calls the wrapped store and counts whether the record was written
+func (s *MetricsStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	start := time.Now()
+	updated, err := s.inner.Upsert(ctx, r)
+	s.observe(opUpsert, start, err)
+	if err == nil {
+		if updated {
+			s.upsertUpdated.Inc()
+		} else {
+			s.upsertSkipped.Inc()
+		}
+	}
+	return updated, err
+}
+
+// BulkUpsert calls the wrapped store and counts the records written and
+// skipped; duplicates within the batch count as skipped
+func (s *MetricsStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	start := time.Now()
+	updated, err := s.inner.BulkUpsert(ctx, records)
+	s.observe(opBulkUpsert, start, err)
+	if err == nil {
+		s.upsertUpdated.Add(float64(updated))
+		s.upsertSkipped.Add(float64(len(records) - updated))
+	}
+	return updated, err
+}
+
+// UpdateIfUnchanged calls the wrapped store
+func (s *MetricsStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	start := time.Now()
+	updated, err := s.inner.UpdateIfUnchanged(ctx, r, expectedTimestamp)
+	s.observe(opUpdateIfUnchanged, start, err)
+	return updated, err
+}
+
+// Get calls the wrapped store
+func (s *MetricsStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	start := time.Now()
+	r, err := s.inner.Get(ctx, ip, port, service)
+	s.observe(opGet, start, err)
+	return r, err
+}
+
+// GetMulti calls the wrapped store
+func (s *MetricsStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	start := time.Now()
+	found, err := s.inner.GetMulti(ctx, keys)
+	s.observe(opGetMulti, start, err)
+	return found, err
+}
+
+// List calls the wrapped store
+func (s *MetricsStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.List(ctx, limit, offset)
+	s.observe(opList, start, err)
+	return records, err
+}
+
+// ListV2 calls the wrapped store
+func (s *MetricsStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	start := time.Now()
+	result, err := s.inner.ListV2(ctx, limit, offset)
+	s.observe(opListV2, start, err)
+	return result, err
+}
+
+// ListByIP calls the wrapped store
+func (s *MetricsStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.ListByIP(ctx, ip)
+	s.observe(opListByIP, start, err)
+	return records, err
+}
+
+// ListByService calls the wrapped store
+func (s *MetricsStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.ListByService(ctx, service, limit, offset)
+	s.observe(opListByService, start, err)
+	return records, err
+}
+
+// ListByPort calls the wrapped store
+func (s *MetricsStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.ListByPort(ctx, port, limit, offset)
+	s.observe(opListByPort, start, err)
+	return records, err
+}
+
+// ListByTimestampRange calls the wrapped store
+func (s *MetricsStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.ListByTimestampRange(ctx, from, to, limit, offset)
+	s.observe(opListByTimestampRange, start, err)
+	return records, err
+}
+
+// ListByCIDR calls the wrapped store
+func (s *MetricsStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.ListByCIDR(ctx, cidr, limit, offset)
+	s.observe(opListByCIDR, start, err)
+	return records, err
+}
+
+// SearchByResponse calls the wrapped store
+func (s *MetricsStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.SearchByResponse(ctx, query, limit, offset)
+	s.observe(opSearchByResponse, start, err)
+	return records, err
+}
+
+// ListAfter calls the wrapped store
+func (s *MetricsStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.ListAfter(ctx, after, limit)
+	s.observe(opListAfter, start, err)
+	return records, err
+}
+
+// ListDistinctIPs calls the wrapped store
+func (s *MetricsStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	start := time.Now()
+	ips, err := s.inner.ListDistinctIPs(ctx, limit, offset)
+	s.observe(opListDistinctIPs, start, err)
+	return ips, err
+}
+
+// CountDistinctIPs calls the wrapped store
+func (s *MetricsStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	start := time.Now()
+	count, err := s.inner.CountDistinctIPs(ctx)
+	s.observe(opCountDistinctIPs, start, err)
+	return count, err
+}
+
+// ListDistinctServices calls the wrapped store
+func (s *MetricsStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	services, err := s.inner.ListDistinctServices(ctx)
+	s.observe(opListDistinctServices, start, err)
+	return services, err
+}
+
+// ListDistinctPorts calls the wrapped store
+func (s *MetricsStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	start := time.Now()
+	ports, err := s.inner.ListDistinctPorts(ctx)
+	s.observe(opListDistinctPorts, start, err)
+	return ports, err
+}
+
+// FindCoOccurrence calls the wrapped store
+func (s *MetricsStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	start := time.Now()
+	summaries, err := s.inner.FindCoOccurrence(ctx, services)
+	s.observe(opFindCoOccurrence, start, err)
+	return summaries, err
+}
+
+// ListChangedSince calls the wrapped store
+func (s *MetricsStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.ListChangedSince(ctx, timestamp)
+	s.observe(opListChangedSince, start, err)
+	return records, err
+}
+
+// Delete calls the wrapped store
+func (s *MetricsStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	start := time.Now()
+	deleted, err := s.inner.Delete(ctx, ip, port, service)
+	s.observe(opDelete, start, err)
+	return deleted, err
+}
+
+// Undelete calls the wrapped store
+func (s *MetricsStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	start := time.Now()
+	restored, err := s.inner.Undelete(ctx, ip, port, service)
+	s.observe(opUndelete, start, err)
+	return restored, err
+}
+
+// ListDeleted calls the wrapped store
+func (s *MetricsStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	start := time.Now()
+	records, err := s.inner.ListDeleted(ctx, limit, offset)
+	s.observe(opListDeleted, start, err)
+	return records, err
+}
+
+// DeleteOlderThan calls the wrapped store
+func (s *MetricsStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	start := time.Now()
+	deleted, err := s.inner.DeleteOlderThan(ctx, beforeTimestamp)
+	s.observe(opDeleteOlderThan, start, err)
+	return deleted, err
+}
+
+// PurgeExpired calls the wrapped store
+func (s *MetricsStore) PurgeExpired(ctx context.Context) (int64, error) {
+	start := time.Now()
+	purged, err := s.inner.PurgeExpired(ctx)
+	s.observe(opPurgeExpired, start, err)
+	return purged, err
+}
+
+// Count calls the wrapped store
+func (s *MetricsStore) Count(ctx context.Context) (int64, error) {
+	start := time.Now()
+	count, err := s.inner.Count(ctx)
+	s.observe(opCount, start, err)
+	return count, err
+}
+
+// Stats calls the wrapped store
+func (s *MetricsStore) Stats(ctx context.Context) (*StoreStats, error) {
+	start := time.Now()
+	stats, err := s.inner.Stats(ctx)
+	s.observe(opStats, start, err)
+	return stats, err
+}
+
+// Watch calls the wrapped store
+func (s *MetricsStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.inner.Watch(ctx)
+}
+
+// Unwatch calls the wrapped store
+func (s *MetricsStore) Unwatch(ch <-chan StoreEvent) {
+	s.inner.Unwatch(ch)
+}
+
+// HealthCheck calls the wrapped store
+func (s *MetricsStore) HealthCheck(ctx context.Context) error {
+	start := time.Now()
+	err := s.inner.HealthCheck(ctx)
+	s.observe(opHealthCheck, start, err)
+	return err
+}
+
+// Close closes the wrapped store
+func (s *MetricsStore) Close() error {
+	return s.inner.Close()
+}
+
+// Unwrap returns the wrapped store
+func (s *MetricsStore) Unwrap() Store {
+	return s.inner
+}
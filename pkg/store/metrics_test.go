@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMetricsStore tests that every call through a MetricsStore is passed
+// to the wrapped store and recorded in the operation histogram
+func TestMetricsStore(t *testing.T) {
+	inner := NewMemoryStore()
+	reg := prometheus.NewPedanticRegistry()
+	s := NewMetricsStore(inner, reg)
+	defer s.Close()
+	ctx := context.Background()
+
+	r := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "a"}
+	s.Upsert(ctx, r)
+	s.Upsert(ctx, r) // not newer, skipped
+	s.BulkUpsert(ctx, []*ServiceRecord{
+		{IP: "2.2.2.2", Port: 22, Service: "SSH", LastTimestamp: 1000},
+		{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+	})
+	if got, err := s.Get(ctx, "1.1.1.1", 80, "HTTP"); err != nil || got == nil || got.Response != "a" {
+		t.Errorf("Expected Get to pass through, got %+v (err %v)", got, err)
+	}
+	s.UpdateIfUnchanged(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 3000}, 1000)
+	s.GetMulti(ctx, []RecordKey{{IP: "1.1.1.1", Port: 80, Service: "HTTP"}})
+	s.List(ctx, 0, 0)
+	s.List(ctx, 10, 0)
+	s.ListV2(ctx, 10, 0)
+	s.ListByIP(ctx, "1.1.1.1")
+	s.ListByService(ctx, "HTTP", 0, 0)
+	s.ListByPort(ctx, 80, 0, 0)
+	s.ListByTimestampRange(ctx, 0, 0, 0, 0)
+	if _, err := s.ListByCIDR(ctx, "not a cidr", 0, 0); err == nil {
+		t.Error("Expected ListByCIDR error to pass through")
+	}
+	s.SearchByResponse(ctx, "a", 0, 0)
+	s.ListAfter(ctx, Cursor{}, 0)
+	s.ListDistinctIPs(ctx, 0, 0)
+	s.CountDistinctIPs(ctx)
+	s.ListDistinctServices(ctx)
+	s.ListDistinctPorts(ctx)
+	s.FindCoOccurrence(ctx, []ServiceFilter{{Port: 80}})
+	s.ListChangedSince(ctx, 0)
+	if deleted, _ := s.Delete(ctx, "2.2.2.2", 22, "SSH"); !deleted {
+		t.Error("Expected Delete to pass through")
+	}
+	s.Undelete(ctx, "2.2.2.2", 22, "SSH")
+	s.ListDeleted(ctx, 0, 0)
+	s.DeleteOlderThan(ctx, 0)
+	s.PurgeExpired(ctx)
+	if count, _ := s.Count(ctx); count != 2 || inner.Len() != 2 {
+		t.Errorf("Expected 2 records, got %d (inner has %d)", count, inner.Len())
+	}
+	s.Stats(ctx)
+	s.HealthCheck(ctx)
+
+	// Gathering through the pedantic registry validates the metric families
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	counts := make(map[string]uint64)
+	for _, f := range families {
+		if f.GetName() != "store_operation_duration_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			counts[m.GetLabel()[0].GetValue()] = m.GetHistogram().GetSampleCount()
+		}
+	}
+	if len(counts) != len(metricsOperations) {
+		t.Errorf("Expected %d operations, got %d: %v", len(metricsOperations), len(counts), counts)
+	}
+	for _, op := range metricsOperations {
+		want := uint64(1)
+		switch op {
+		case opUpsert, opList:
+			want = 2
+		}
+		if counts[op] != want {
+			t.Errorf("Expected %d %s observations, got %d", want, op, counts[op])
+		}
+	}
+
+	ms := s.(*MetricsStore)
+	if got := testutil.ToFloat64(ms.errors.WithLabelValues(opListByCIDR)); got != 1 {
+		t.Errorf("Expected 1 list_by_cidr error, got %v", got)
+	}
+	if got := testutil.ToFloat64(ms.errors.WithLabelValues(opGet)); got != 0 {
+		t.Errorf("Expected no get errors, got %v", got)
+	}
+	// One Upsert and one BulkUpsert record written, one of each skipped
+	if got := testutil.ToFloat64(ms.upsertUpdated); got != 2 {
+		t.Errorf("Expected store_upsert_updated_total 2, got %v", got)
+	}
+	if got := testutil.ToFloat64(ms.upsertSkipped); got != 2 {
+		t.Errorf("Expected store_upsert_skipped_total 2, got %v", got)
+	}
+}
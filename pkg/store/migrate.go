@@ -0,0 +1,188 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Migration is a numbered schema change for the SQL stores
+// Each migration is applied once, in Version order, and recorded in the
+// schema_migrations table
+type Migration struct {
+	Version int
+	SQL     string
+
+	// Func, if non-nil, runs after SQL in the same transaction for changes
+	// SQL cannot express
+	Func func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrationDialect holds the database-specific parts of applying migrations
+type migrationDialect struct {
+	// recordSQL records the version given by its single %d verb, ignoring a
+	// version another process has already recorded
+	recordSQL string
+
+	// alreadyApplied, if non-nil, reports errors that mean the change is
+	// already present in a database created before versioning, such as
+	// adding an existing column; the migration is then recorded without
+	// failing
+	alreadyApplied func(error) bool
+}
+
+// onConflictRecordSQL is recordSQL for databases with ON CONFLICT
+// Versions are plain integers, so formatting them avoids the differing
+// placeholder syntax of the drivers
+const onConflictRecordSQL = `INSERT INTO schema_migrations (version, applied_at) VALUES (%d, CURRENT_TIMESTAMP) ON CONFLICT (version) DO NOTHING`
+
+// applyMigrations creates schema_migrations if needed and applies every
+// migration not yet recorded there, each in its own transaction
+func applyMigrations(ctx context.Context, db *sql.DB, migrations []Migration, dialect migrationDialect) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	versions, err := migrationsApplied(ctx, db)
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := applyMigration(ctx, db, m, dialect); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", m.Version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs a single migration and records its version in one
+// transaction
+func applyMigration(ctx context.Context, db *sql.DB, m Migration, dialect migrationDialect) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if m.SQL != "" {
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil && (dialect.alreadyApplied == nil || !dialect.alreadyApplied(err)) {
+			return err
+		}
+	}
+	if m.Func != nil {
+		if err := m.Func(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(dialect.recordSQL, m.Version)); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// migrationsApplied returns the recorded migration versions in ascending order
+func migrationsApplied(ctx context.Context, db *sql.DB) ([]int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []int{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating migrations: %w", err)
+	}
+	return versions, nil
+}
+
+// canonicalIPs returns a migration Func that rewrites every ip in
+// service_records to CanonicalIP(ip), for rows written before addresses
+// were canonicalized
+// When two spellings of an address hold the same port and service, the row
+// with the newer last_timestamp is kept
+// placeholder returns the driver's nth bind parameter
+func canonicalIPs(placeholder func(int) string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		// Valid IPv4 addresses are already canonical, so only addresses
+		// containing a colon can need rewriting
+		rows, err := tx.QueryContext(ctx, `SELECT ip, port, service, last_timestamp FROM service_records WHERE ip LIKE '%:%'`)
+		if err != nil {
+			return fmt.Errorf("failed to query records: %w", err)
+		}
+		type spelling struct {
+			key           RecordKey
+			lastTimestamp int64
+		}
+		var stale []spelling
+		for rows.Next() {
+			var r spelling
+			if err := rows.Scan(&r.key.IP, &r.key.Port, &r.key.Service, &r.lastTimestamp); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan record: %w", err)
+			}
+			if CanonicalIP(r.key.IP) != r.key.IP {
+				stale = append(stale, r)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating records: %w", err)
+		}
+
+		where := fmt.Sprintf(`ip = %s AND port = %s AND service = %s`, placeholder(1), placeholder(2), placeholder(3))
+		getSQL := `SELECT last_timestamp FROM service_records WHERE ` + where
+		deleteSQL := `DELETE FROM service_records WHERE ` + where
+		renameSQL := fmt.Sprintf(`UPDATE service_records SET ip = %s WHERE ip = %s AND port = %s AND service = %s`,
+			placeholder(1), placeholder(2), placeholder(3), placeholder(4))
+
+		for _, r := range stale {
+			ip := CanonicalIP(r.key.IP)
+			var existing int64
+			err := tx.QueryRowContext(ctx, getSQL, ip, r.key.Port, r.key.Service).Scan(&existing)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+			case err != nil:
+				return fmt.Errorf("failed to get record: %w", err)
+			case existing >= r.lastTimestamp:
+				if _, err := tx.ExecContext(ctx, deleteSQL, r.key.IP, r.key.Port, r.key.Service); err != nil {
+					return fmt.Errorf("failed to delete record: %w", err)
+				}
+				continue
+			default:
+				if _, err := tx.ExecContext(ctx, deleteSQL, ip, r.key.Port, r.key.Service); err != nil {
+					return fmt.Errorf("failed to delete record: %w", err)
+				}
+			}
+			if _, err := tx.ExecContext(ctx, renameSQL, ip, r.key.IP, r.key.Port, r.key.Service); err != nil {
+				return fmt.Errorf("failed to update record: %w", err)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,812 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlTxAttempts bounds how often an upsert transaction is retried after a
+// deadlock or a concurrent insert of the same key
+const mysqlTxAttempts = 3
+
+// MySQL error numbers that mean a transaction lost a race and can be retried
+const (
+	mysqlErrDuplicateKey = 1062
+	mysqlErrDeadlock     = 1213
+)
+
+// MySQLStore implements Store interface using MySQL 8 or MariaDB
+//
+// Known differences from the other SQL stores:
+//   - Timestamps are DATETIME(6) in UTC (the session time zone is forced to
+//     +00:00), so ExpiresAt and DeletedAt keep microsecond precision only
+//   - ip and service use binary collations, so keys compare exactly as in
+//     the other stores; response uses the table's default collation, so
+//     SearchByResponse case (and, with utf8mb4_0900_ai_ci, accent)
+//     sensitivity follows it
+//   - MySQL has no LISTEN/NOTIFY, so Watch only reports changes made through
+//     this store instance, as with SQLiteStore
+type MySQLStore struct {
+	db  *sql.DB
+	hub watchHub
+}
+
+// mysqlMigrations builds service_records
+// MySQL commits DDL implicitly, so a failed migration may leave its change
+// applied without its version recorded; the table is created with
+// IF NOT EXISTS so the first migration can be re-run
+var mysqlMigrations = []Migration{
+	{Version: 1, SQL: `
+		CREATE TABLE IF NOT EXISTS service_records (
+			ip                     VARCHAR(45) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			port                   INT UNSIGNED NOT NULL,
+			service                VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			last_timestamp         BIGINT NOT NULL,
+			response               MEDIUMTEXT NOT NULL,
+			updated_at             DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			first_seen_at          DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			scan_count             BIGINT NOT NULL DEFAULT 1,
+			previous_response      MEDIUMTEXT NOT NULL,
+			response_hash          CHAR(64) NOT NULL DEFAULT '',
+			previous_response_hash CHAR(64) NOT NULL DEFAULT '',
+			response_truncated     BOOLEAN NOT NULL DEFAULT FALSE,
+			tls_version            VARCHAR(32),
+			status_code            INT,
+			expires_at             DATETIME(6),
+			deleted_at             DATETIME(6),
+			PRIMARY KEY (ip, port, service),
+			INDEX idx_timestamp (last_timestamp),
+			INDEX idx_ip (ip),
+			INDEX idx_service (service),
+			INDEX idx_port (port),
+			INDEX idx_expires_at (expires_at),
+			INDEX idx_deleted_at (deleted_at)
+		)
+	`},
+	// service_record_changelog is written by ChangelogStore
+	{Version: 2, SQL: `
+		CREATE TABLE IF NOT EXISTS service_record_changelog (
+			id                 BIGINT AUTO_INCREMENT PRIMARY KEY,
+			changed_at         DATETIME(6) NOT NULL,
+			ip                 VARCHAR(45) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			port               INT UNSIGNED NOT NULL,
+			service            VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			old_response       MEDIUMTEXT NOT NULL,
+			new_response       MEDIUMTEXT NOT NULL,
+			old_last_timestamp BIGINT NOT NULL,
+			new_last_timestamp BIGINT NOT NULL,
+			INDEX idx_changelog_key (ip, port, service, id)
+		)
+	`},
+	// scan_errors is written by ErrorTrackingStore
+	{Version: 3, SQL: `
+		CREATE TABLE IF NOT EXISTS scan_errors (
+			ip            VARCHAR(45) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			port          INT UNSIGNED NOT NULL,
+			service       VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			last_error    TEXT NOT NULL,
+			error_count   BIGINT NOT NULL,
+			last_error_at DATETIME(6) NOT NULL,
+			PRIMARY KEY (ip, port, service)
+		)
+	`},
+	// pubsub_* identify the Pub/Sub message behind the last accepted upsert
+	{Version: 4, SQL: `
+		ALTER TABLE service_records
+			ADD COLUMN pubsub_message_id      VARCHAR(255),
+			ADD COLUMN pubsub_subscription_id VARCHAR(255),
+			ADD COLUMN pubsub_publish_time    DATETIME(6)
+	`},
+	// Addresses written before IPs were canonicalized are rewritten in Go
+	{Version: 5, Func: canonicalIPs(func(int) string { return "?" })},
+}
+
+// mysqlMigrationDialect records versions with INSERT IGNORE, MySQL's
+// equivalent of ON CONFLICT DO NOTHING
+var mysqlMigrationDialect = migrationDialect{
+	recordSQL: `INSERT IGNORE INTO schema_migrations (version, applied_at) VALUES (%d, CURRENT_TIMESTAMP)`,
+}
+
+// NewMySQLStore creates a new MySQL store
+// dsn uses the go-sql-driver/mysql format, e.g.
+// user:pass@tcp(localhost:3306)/scans; parseTime and the UTC time zone are
+// always enabled
+func NewMySQLStore(dsn string) (*MySQLStore, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DSN: %w", err)
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db := sql.OpenDB(connector)
+
+	// Test connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	if err := applyMigrations(context.Background(), db, mysqlMigrations, mysqlMigrationDialect); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &MySQLStore{db: db}, nil
+}
+
+// Upsert inserts or updates a record if the timestamp is newer
+func (s *MySQLStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	r = canonicalRecord(r)
+	var ev StoreEvent
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		ev, err = s.upsertTx(ctx, tx, r)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if s.hub.active() {
+		s.hub.publish(ev)
+	}
+	return ev.Type != EventSkipped, nil
+}
+
+// BulkUpsert upserts a batch of records inside a single transaction
+func (s *MySQLStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	records = dedupeRecords(records)
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	var events []StoreEvent
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		events = events[:0]
+		for _, r := range records {
+			ev, err := s.upsertTx(ctx, tx, r)
+			if err != nil {
+				return err
+			}
+			events = append(events, ev)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, ev := range events {
+		if ev.Type != EventSkipped {
+			updated++
+		}
+		if s.hub.active() {
+			s.hub.publish(ev)
+		}
+	}
+	return updated, nil
+}
+
+// withTx runs fn in a transaction and commits it, retrying the whole
+// transaction when it loses a race with a concurrent upsert
+func (s *MySQLStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	var err error
+	for attempt := 0; attempt < mysqlTxAttempts; attempt++ {
+		if err = s.runTx(ctx, fn); !mysqlRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// runTx runs fn in a single transaction
+func (s *MySQLStore) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// mysqlRetryable reports whether err is a deadlock or a duplicate key from
+// two transactions inserting the same new record
+func mysqlRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrDuplicateKey
+}
+
+// mysqlUpdateSQL replaces a locked existing row with a newer scan
+// MySQL applies assignments left to right, so the previous values are
+// copied before being overwritten
+const mysqlUpdateSQL = `
+		UPDATE service_records SET
+			previous_response = response,
+			previous_response_hash = response_hash,
+			scan_count = scan_count + 1,
+			last_timestamp = ?,
+			response = ?,
+			updated_at = CURRENT_TIMESTAMP(6),
+			tls_version = NULLIF(?, ''),
+			status_code = NULLIF(?, 0),
+			expires_at = ?,
+			response_hash = ?,
+			response_truncated = ?,
+			pubsub_message_id = ?,
+			pubsub_subscription_id = ?,
+			pubsub_publish_time = ?
+		WHERE ip = ? AND port = ? AND service = ?
+	`
+
+// mysqlUpdateArgs returns the arguments of mysqlUpdateSQL for r
+func mysqlUpdateArgs(r *ServiceRecord) []interface{} {
+	messageID, subscriptionID, publishTime := messageColumns(r)
+	return []interface{}{r.LastTimestamp, r.Response, r.TLSVersion, r.StatusCode, r.ExpiresAt, r.ResponseHash, r.ResponseTruncated,
+		messageID, subscriptionID, publishTime, r.IP, r.Port, r.Service}
+}
+
+// UpdateIfUnchanged replaces a record if its LastTimestamp equals
+// expectedTimestamp
+// The row is locked with SELECT ... FOR UPDATE while it is compared, like
+// upsertTx
+func (s *MySQLStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	r = canonicalRecord(r)
+	var ev *StoreEvent
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		ev = nil
+		previous, err := scanRecord(tx.QueryRowContext(ctx, `
+			SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+			FROM service_records
+			WHERE ip = ? AND port = ? AND service = ?
+			FOR UPDATE
+		`, r.IP, r.Port, r.Service))
+		if err != nil {
+			return fmt.Errorf("failed to lock record: %w", err)
+		}
+		if previous == nil || previous.DeletedAt != nil || previous.LastTimestamp != expectedTimestamp {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, mysqlUpdateSQL, mysqlUpdateArgs(r)...); err != nil {
+			return fmt.Errorf("failed to update record: %w", err)
+		}
+		ev = &StoreEvent{Type: EventUpdated, Previous: previous}
+		if s.hub.active() {
+			ev.Record, err = scanRecord(tx.QueryRowContext(ctx, `
+				SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+				FROM service_records
+				WHERE ip = ? AND port = ? AND service = ?
+			`, r.IP, r.Port, r.Service))
+			if err != nil {
+				return fmt.Errorf("failed to get updated record: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if ev == nil {
+		return false, nil
+	}
+
+	if s.hub.active() {
+		s.hub.publish(*ev)
+	}
+	return true, nil
+}
+
+// upsertTx applies the timestamp guard to one record inside tx and returns
+// the resulting event
+// ON DUPLICATE KEY UPDATE cannot skip the update conditionally, so the
+// existing row is locked with SELECT ... FOR UPDATE and compared here
+func (s *MySQLStore) upsertTx(ctx context.Context, tx *sql.Tx, r *ServiceRecord) (StoreEvent, error) {
+	previous, err := scanRecord(tx.QueryRowContext(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = ? AND port = ? AND service = ?
+		FOR UPDATE
+	`, r.IP, r.Port, r.Service))
+	if err != nil {
+		return StoreEvent{}, fmt.Errorf("failed to lock record: %w", err)
+	}
+
+	switch {
+	case previous == nil:
+		messageID, subscriptionID, publishTime := messageColumns(r)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO service_records (ip, port, service, last_timestamp, response, updated_at, first_seen_at, previous_response, tls_version, status_code, expires_at, response_hash, response_truncated, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time)
+			VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP(6), CURRENT_TIMESTAMP(6), '', NULLIF(?, ''), NULLIF(?, 0), ?, ?, ?, ?, ?, ?)
+		`, r.IP, r.Port, r.Service, r.LastTimestamp, r.Response, r.TLSVersion, r.StatusCode, r.ExpiresAt, r.ResponseHash, r.ResponseTruncated, messageID, subscriptionID, publishTime)
+	case r.LastTimestamp > previous.LastTimestamp:
+		_, err = tx.ExecContext(ctx, mysqlUpdateSQL, mysqlUpdateArgs(r)...)
+	default:
+		return StoreEvent{Type: EventSkipped, Record: copyRecord(r), Previous: previous}, nil
+	}
+	if err != nil {
+		return StoreEvent{}, fmt.Errorf("failed to upsert record: %w", err)
+	}
+
+	ev := StoreEvent{Type: EventCreated}
+	if previous != nil {
+		ev.Type = EventUpdated
+		ev.Previous = previous
+	}
+	if s.hub.active() {
+		// Read back the stored row so watchers see server-set columns
+		ev.Record, err = scanRecord(tx.QueryRowContext(ctx, `
+			SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+			FROM service_records
+			WHERE ip = ? AND port = ? AND service = ?
+		`, r.IP, r.Port, r.Service))
+		if err != nil {
+			return StoreEvent{}, fmt.Errorf("failed to get upserted record: %w", err)
+		}
+	}
+	return ev, nil
+}
+
+// Get retrieves a record by its composite key
+func (s *MySQLStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	row := s.db.QueryRowContext(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = ? AND port = ? AND service = ? AND deleted_at IS NULL
+	`, ip, port, service)
+
+	r, err := scanServiceRecord(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get record: %w", err)
+	}
+
+	return r, nil
+}
+
+// GetMulti retrieves several records with one query per
+// getMultiChunkSize keys
+func (s *MySQLStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	return getMulti(ctx, s.db, keys, "")
+}
+
+// mysqlListSQL selects the live records in List order
+const mysqlListSQL = `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`
+
+// List returns all records with optional pagination
+func (s *MySQLStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, mysqlListSQL, limit, offset)
+}
+
+// ListV2 returns a page of records and the number of live records, read
+// in one transaction
+func (s *MySQLStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	query, args := mysqlListSQL, []interface{}{}
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+	// InnoDB reads both queries from one snapshot under its default
+	// REPEATABLE READ isolation
+	return listWithTotal(ctx, s.db, &sql.TxOptions{ReadOnly: true}, query, args...)
+}
+
+// ListByIP returns all records for the given IP address
+func (s *MySQLStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	return queryRecords(ctx, s.db, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = ? AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`, ip)
+}
+
+// ListByService returns records for the given service with optional pagination
+func (s *MySQLStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE service = ? AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`, limit, offset, service)
+}
+
+// ListByPort returns records for the given port with optional pagination
+func (s *MySQLStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE port = ? AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`, limit, offset, port)
+}
+
+// ListByTimestampRange returns records within the given timestamp window
+func (s *MySQLStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	if from != 0 {
+		conditions = append(conditions, "last_timestamp >= ?")
+		args = append(args, from)
+	}
+	if to != 0 {
+		conditions = append(conditions, "last_timestamp <= ?")
+		args = append(args, to)
+	}
+
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+	`
+	query += ` WHERE ` + strings.Join(conditions, " AND ")
+	query += ` ORDER BY last_timestamp DESC, ip, port, service`
+
+	return s.queryPage(ctx, query, limit, offset, args...)
+}
+
+// ListByCIDR returns records whose IP falls within the given CIDR range
+// Candidates are narrowed with a LIKE prefix as in the other SQL stores
+func (s *MySQLStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`
+	var args []interface{}
+	if prefix := ipv4LikePrefix(network); prefix != "" {
+		query += ` AND ip LIKE ?`
+		args = append(args, prefix)
+	}
+	query += ` ORDER BY last_timestamp DESC, ip, port, service`
+
+	candidates, err := queryRecords(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*ServiceRecord, 0, len(candidates))
+	for _, r := range candidates {
+		if cidrContains(network, r.IP.String()) {
+			matched = append(matched, r)
+		}
+	}
+
+	return paginate(matched, limit, offset), nil
+}
+
+// queryPage appends LIMIT/OFFSET to query when limit > 0 and runs it
+func (s *MySQLStore) queryPage(ctx context.Context, query string, limit, offset int, args ...interface{}) ([]*ServiceRecord, error) {
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+	return queryRecords(ctx, s.db, query, args...)
+}
+
+// SearchByResponse returns records whose response contains query
+// LIKE escapes with a backslash by default, matching escapeLike
+func (s *MySQLStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE response LIKE CONCAT('%', ?, '%') AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`, limit, offset, escapeLike(query))
+}
+
+// ListAfter returns records after the given cursor using keyset pagination
+func (s *MySQLStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`
+	var args []interface{}
+	if !after.isStart() {
+		query += ` AND (last_timestamp < ? OR (last_timestamp = ? AND (ip, port, service) > (?, ?, ?)))`
+		args = append(args, after.Timestamp, after.Timestamp, after.IP, after.Port, after.Service)
+	}
+	query += ` ORDER BY last_timestamp DESC, ip ASC, port ASC, service ASC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	return queryRecords(ctx, s.db, query, args...)
+}
+
+// ListDistinctIPs returns the unique IPs of live records with optional
+// pagination
+func (s *MySQLStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	if limit > 0 {
+		return queryColumn[string](ctx, s.db, `
+			SELECT DISTINCT ip FROM service_records
+			WHERE deleted_at IS NULL
+			ORDER BY ip
+			LIMIT ? OFFSET ?
+		`, limit, offset)
+	}
+
+	return queryColumn[string](ctx, s.db, `
+		SELECT DISTINCT ip FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY ip
+	`)
+}
+
+// CountDistinctIPs returns the number of unique IPs of live records
+func (s *MySQLStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT ip) FROM service_records WHERE deleted_at IS NULL`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count ips: %w", err)
+	}
+	return count, nil
+}
+
+// ListDistinctServices returns the unique services of live records
+func (s *MySQLStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return queryColumn[string](ctx, s.db, `
+		SELECT DISTINCT service FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY service
+	`)
+}
+
+// ListDistinctPorts returns the unique ports of live records
+func (s *MySQLStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return queryColumn[uint32](ctx, s.db, `
+		SELECT DISTINCT port FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY port
+	`)
+}
+
+// FindCoOccurrence returns the IPs with a live record matching every filter
+func (s *MySQLStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	if len(services) == 0 {
+		return []*IPSummary{}, nil
+	}
+
+	query, args := coOccurrenceQuery(services, func(int) string { return "?" })
+	records, err := queryRecords(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return groupByIP(records), nil
+}
+
+// ListChangedSince returns records updated after timestamp with a new
+// response hash
+func (s *MySQLStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return queryRecords(ctx, s.db, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE last_timestamp > ? AND deleted_at IS NULL
+			AND previous_response_hash <> '' AND response_hash <> previous_response_hash
+		ORDER BY last_timestamp DESC, ip ASC
+	`, timestamp)
+}
+
+// Delete soft-deletes a record by setting deleted_at
+func (s *MySQLStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	ip = CanonicalIP(ip)
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE service_records SET deleted_at = CURRENT_TIMESTAMP(6)
+		WHERE ip = ? AND port = ? AND service = ? AND deleted_at IS NULL
+	`, ip, port, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete record: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// Undelete clears deleted_at on a soft-deleted record
+func (s *MySQLStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	ip = CanonicalIP(ip)
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE service_records SET deleted_at = NULL
+		WHERE ip = ? AND port = ? AND service = ? AND deleted_at IS NOT NULL
+	`, ip, port, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to undelete record: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// ListDeleted returns soft-deleted records, most recently deleted first
+func (s *MySQLStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, last_timestamp DESC
+	`, limit, offset)
+}
+
+// DeleteOlderThan removes records with a timestamp before beforeTimestamp
+// Soft-deleted records are removed as well
+func (s *MySQLStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM service_records WHERE last_timestamp < ?`, beforeTimestamp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old records: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+// PurgeExpired removes records whose expires_at is in the past
+func (s *MySQLStore) PurgeExpired(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM service_records
+		WHERE expires_at IS NOT NULL AND expires_at < ?
+	`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired records: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+// Count returns the total number of records
+func (s *MySQLStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM service_records WHERE deleted_at IS NULL`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count records: %w", err)
+	}
+	return count, nil
+}
+
+// Stats returns aggregate counts using GROUP BY queries
+func (s *MySQLStore) Stats(ctx context.Context) (*StoreStats, error) {
+	stats := &StoreStats{
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(MIN(last_timestamp), 0), COALESCE(MAX(last_timestamp), 0)
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`).Scan(&stats.TotalRecords, &stats.OldestTimestamp, &stats.NewestTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query totals: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT service, COUNT(*) FROM service_records
+		WHERE deleted_at IS NULL
+		GROUP BY service
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service counts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var service string
+		var count int64
+		if err := rows.Scan(&service, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan service count: %w", err)
+		}
+		stats.RecordsByService[service] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating service counts: %w", err)
+	}
+
+	portRows, err := s.db.QueryContext(ctx, `
+		SELECT port, COUNT(*) FROM service_records
+		WHERE deleted_at IS NULL
+		GROUP BY port
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query port counts: %w", err)
+	}
+	defer portRows.Close()
+	for portRows.Next() {
+		var port uint32
+		var count int64
+		if err := portRows.Scan(&port, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan port count: %w", err)
+		}
+		stats.RecordsByPort[port] = count
+	}
+	if err := portRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating port counts: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Watch returns a channel of record change events
+// Only changes made through this store instance are reported
+func (s *MySQLStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.hub.watch(ctx)
+}
+
+// Unwatch stops delivery to a channel returned by Watch and closes it
+func (s *MySQLStore) Unwatch(ch <-chan StoreEvent) {
+	s.hub.unwatch(ch)
+}
+
+// MigrationsApplied returns the schema migration versions recorded in the
+// database, in ascending order
+func (s *MySQLStore) MigrationsApplied(ctx context.Context) ([]int, error) {
+	return migrationsApplied(ctx, s.db)
+}
+
+// HealthCheck pings the database
+func (s *MySQLStore) HealthCheck(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
+// Close closes any open watch channels and the database connection
+func (s *MySQLStore) Close() error {
+	s.hub.close()
+	return s.db.Close()
+}
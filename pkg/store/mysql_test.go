@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+)
+
+// mysqlTestDSN returns the database used by the MySQL tests, after dropping
+// any tables left by a previous test
+// Tests are skipped unless MYSQL_TEST_URL is set, for example to
+// scanner:scanner@tcp(localhost:3306)/scans
+func mysqlTestDSN(tb testing.TB) string {
+	dsn := os.Getenv("MYSQL_TEST_URL")
+	if dsn == "" {
+		tb.Skip("MYSQL_TEST_URL not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		tb.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`DROP TABLE IF EXISTS service_records, schema_migrations`); err != nil {
+		tb.Fatalf("Failed to reset database: %v", err)
+	}
+	return dsn
+}
+
+// TestMySQLStore tests the MySQL store implementation
+func TestMySQLStore(t *testing.T) {
+	s, err := NewStore("mysql", mysqlTestDSN(t))
+	if err != nil {
+		t.Fatalf("Failed to create MySQL store: %v", err)
+	}
+	defer s.Close()
+
+	runStoreTests(t, s)
+}
+
+// TestMySQLCanonicalIPMigration tests that MySQL databases written before
+// IPs were canonicalized are rewritten on open
+func TestMySQLCanonicalIPMigration(t *testing.T) {
+	dsn := mysqlTestDSN(t)
+	testCanonicalIPMigration(t, 5, func() (Store, *sql.DB) {
+		s, err := NewMySQLStore(dsn)
+		if err != nil {
+			t.Fatalf("Failed to create MySQL store: %v", err)
+		}
+		return s, s.db
+	})
+}
+
+// TestMySQLStoreTimestamps tests that DATETIME(6) columns round-trip in UTC
+// with microsecond precision
+func TestMySQLStoreTimestamps(t *testing.T) {
+	store, err := NewMySQLStore(mysqlTestDSN(t))
+	if err != nil {
+		t.Fatalf("Failed to create MySQL store: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 123456789, time.FixedZone("EST", -5*3600))
+	if _, err := store.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "r", ExpiresAt: &expires}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+
+	r, err := store.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if err != nil || r == nil || r.ExpiresAt == nil {
+		t.Fatalf("Expected record with expiry, got %+v (err %v)", r, err)
+	}
+	if want := expires.Truncate(time.Microsecond); !r.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expiry %v, got %v", want, r.ExpiresAt)
+	}
+	if r.ExpiresAt.Location() != time.UTC {
+		t.Errorf("Expected expiry in UTC, got %v", r.ExpiresAt.Location())
+	}
+}
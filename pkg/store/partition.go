@@ -0,0 +1,569 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// PartitionHash picks the shard for a record's composite key
+// The result may be any int; it is reduced modulo the number of shards
+type PartitionHash func(ip string, port uint32, service string) int
+
+// DefaultPartitionHash hashes the composite key with FNV-1a
+// The IP is canonicalized first, so every spelling of an address is routed
+// to the same shard
+func DefaultPartitionHash(ip string, port uint32, service string) int {
+	h := fnv.New32a()
+	h.Write([]byte(makeKey(ip, port, service)))
+	return int(h.Sum32())
+}
+
+// PartitionedStore is a Store that shards records across several stores by
+// a hash of their composite key, to spread write load
+// Calls for a single key go to its shard; every other read asks all shards
+// concurrently and merges their results into the order a single store would
+// return
+// Paginated reads fetch offset+limit records from each shard, so deep
+// pages cost more than on a single store
+type PartitionedStore struct {
+	shards []Store
+	hash   PartitionHash
+
+	mu      sync.Mutex
+	watches map[<-chan StoreEvent]context.CancelFunc
+}
+
+// NewPartitionedStore creates a store that routes each key to
+// stores[hash(key) % len(stores)], or DefaultPartitionHash if hash is nil
+// The hash and the order of stores must not change while the shards hold
+// data, or records will be looked for on the wrong shard
+// It panics if stores is empty
+func NewPartitionedStore(stores []Store, hash func(ip string, port uint32, service string) int) Store {
+	if len(stores) == 0 {
+		panic("store: NewPartitionedStore needs at least one store")
+	}
+	if hash == nil {
+		hash = DefaultPartitionHash
+	}
+	return &PartitionedStore{
+		shards:  stores,
+		hash:    hash,
+		watches: make(map[<-chan StoreEvent]context.CancelFunc),
+	}
+}
+
+// shardIndex returns the index of the shard that holds a key
+func (s *PartitionedStore) shardIndex(ip string, port uint32, service string) int {
+	i := s.hash(ip, port, service) % len(s.shards)
+	if i < 0 {
+		i += len(s.shards)
+	}
+	return i
+}
+
+// shard returns the shard that holds a key
+func (s *PartitionedStore) shard(ip string, port uint32, service string) Store {
+	return s.shards[s.shardIndex(ip, port, service)]
+}
+
+// eachShard calls fn for every shard concurrently and returns the results
+// in shard order, or the first error
+func eachShard[T any](ctx context.Context, shards []Store, fn func(ctx context.Context, shard Store) (T, error)) ([]T, error) {
+	results := make([]T, len(shards))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, shard := range shards {
+		g.Go(func() error {
+			result, err := fn(ctx, shard)
+			if err != nil {
+				return fmt.Errorf("shard %d: %w", i, err)
+			}
+			results[i] = result
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// sum adds up the results of every shard
+func sum[T int | int64](results []T) T {
+	var total T
+	for _, n := range results {
+		total += n
+	}
+	return total
+}
+
+// mergeRecords joins the records from every shard and sorts them with
+// compare
+func mergeRecords(pages [][]*ServiceRecord, compare func(a, b *ServiceRecord) int) []*ServiceRecord {
+	merged := slices.Concat(pages...)
+	slices.SortStableFunc(merged, compare)
+	if merged == nil {
+		merged = []*ServiceRecord{}
+	}
+	return merged
+}
+
+// mergeDistinct joins the sorted unique values from every shard
+func mergeDistinct[T cmp.Ordered](results [][]T) []T {
+	merged := slices.Concat(results...)
+	slices.Sort(merged)
+	merged = slices.Compact(merged)
+	if merged == nil {
+		merged = []T{}
+	}
+	return merged
+}
+
+// listMerged runs a paginated list on every shard and merges the results in
+// compareListOrder
+// Each shard is asked for the first offset+limit records, as any of them
+// may fall on the requested page
+func (s *PartitionedStore) listMerged(ctx context.Context, limit, offset int, list func(ctx context.Context, shard Store, limit int) ([]*ServiceRecord, error)) ([]*ServiceRecord, error) {
+	window := 0
+	if limit > 0 {
+		window = offset + limit
+	}
+	pages, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) ([]*ServiceRecord, error) {
+		return list(ctx, shard, window)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paginate(mergeRecords(pages, compareListOrder), limit, offset), nil
+}
+
+// Upsert writes to the record's shard
+func (s *PartitionedStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	return s.shard(r.IP.String(), r.Port, r.Service).Upsert(ctx, r)
+}
+
+// BulkUpsert splits the batch by shard and writes the parts concurrently
+func (s *PartitionedStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	parts := make([][]*ServiceRecord, len(s.shards))
+	for _, r := range records {
+		i := s.shardIndex(r.IP.String(), r.Port, r.Service)
+		parts[i] = append(parts[i], r)
+	}
+
+	updated := make([]int, len(s.shards))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		g.Go(func() error {
+			n, err := s.shards[i].BulkUpsert(ctx, part)
+			if err != nil {
+				return fmt.Errorf("shard %d: %w", i, err)
+			}
+			updated[i] = n
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return 0, err
+	}
+	return sum(updated), nil
+}
+
+// UpdateIfUnchanged updates the record on its shard
+func (s *PartitionedStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	return s.shard(r.IP.String(), r.Port, r.Service).UpdateIfUnchanged(ctx, r, expectedTimestamp)
+}
+
+// Get reads from the key's shard
+func (s *PartitionedStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	return s.shard(ip, port, service).Get(ctx, ip, port, service)
+}
+
+// GetMulti splits the keys by shard and reads the parts concurrently
+func (s *PartitionedStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	parts := make([][]RecordKey, len(s.shards))
+	for _, k := range keys {
+		i := s.shardIndex(k.IP, k.Port, k.Service)
+		parts[i] = append(parts[i], k)
+	}
+
+	results := make([]map[RecordKey]*ServiceRecord, len(s.shards))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		g.Go(func() error {
+			found, err := s.shards[i].GetMulti(ctx, part)
+			if err != nil {
+				return fmt.Errorf("shard %d: %w", i, err)
+			}
+			results[i] = found
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	found := make(map[RecordKey]*ServiceRecord, len(keys))
+	for _, result := range results {
+		for k, r := range result {
+			found[k] = r
+		}
+	}
+	return found, nil
+}
+
+// List merges the newest records of every shard
+func (s *PartitionedStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, limit, offset, func(ctx context.Context, shard Store, limit int) ([]*ServiceRecord, error) {
+		return shard.List(ctx, limit, 0)
+	})
+}
+
+// ListV2 merges the newest records of every shard and adds up their totals
+// The shards are read independently, so the total is only consistent with
+// the page while no writes are in flight
+func (s *PartitionedStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	window := 0
+	if limit > 0 {
+		window = offset + limit
+	}
+	results, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) (*ListResult, error) {
+		return shard.ListV2(ctx, window, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([][]*ServiceRecord, len(results))
+	var total int64
+	for i, result := range results {
+		pages[i] = result.Records
+		total += result.TotalCount
+	}
+	return &ListResult{
+		Records:    paginate(mergeRecords(pages, compareListOrder), limit, offset),
+		TotalCount: total,
+	}, nil
+}
+
+// ListByIP merges the IP's records from every shard, since the hash spreads
+// an IP's services across shards
+func (s *PartitionedStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, 0, 0, func(ctx context.Context, shard Store, _ int) ([]*ServiceRecord, error) {
+		return shard.ListByIP(ctx, ip)
+	})
+}
+
+// ListByService merges the service's records from every shard
+func (s *PartitionedStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, limit, offset, func(ctx context.Context, shard Store, limit int) ([]*ServiceRecord, error) {
+		return shard.ListByService(ctx, service, limit, 0)
+	})
+}
+
+// ListByPort merges the port's records from every shard
+func (s *PartitionedStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, limit, offset, func(ctx context.Context, shard Store, limit int) ([]*ServiceRecord, error) {
+		return shard.ListByPort(ctx, port, limit, 0)
+	})
+}
+
+// ListByTimestampRange merges the records in range from every shard
+func (s *PartitionedStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, limit, offset, func(ctx context.Context, shard Store, limit int) ([]*ServiceRecord, error) {
+		return shard.ListByTimestampRange(ctx, from, to, limit, 0)
+	})
+}
+
+// ListByCIDR merges the records in the range from every shard
+func (s *PartitionedStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, limit, offset, func(ctx context.Context, shard Store, limit int) ([]*ServiceRecord, error) {
+		return shard.ListByCIDR(ctx, cidr, limit, 0)
+	})
+}
+
+// SearchByResponse merges the matching records from every shard
+func (s *PartitionedStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, limit, offset, func(ctx context.Context, shard Store, limit int) ([]*ServiceRecord, error) {
+		return shard.SearchByResponse(ctx, query, limit, 0)
+	})
+}
+
+// ListAfter merges the records after the cursor from every shard
+func (s *PartitionedStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, limit, 0, func(ctx context.Context, shard Store, limit int) ([]*ServiceRecord, error) {
+		return shard.ListAfter(ctx, after, limit)
+	})
+}
+
+// ListDistinctIPs merges the IPs of every shard
+// An IP can be on several shards, so each shard's IPs are read in full
+// before paginating
+func (s *PartitionedStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	ips, err := s.distinctIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(ips, limit, offset), nil
+}
+
+// CountDistinctIPs counts the IPs of every shard, once each
+func (s *PartitionedStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	ips, err := s.distinctIPs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ips)), nil
+}
+
+// distinctIPs returns the sorted unique IPs of every shard
+func (s *PartitionedStore) distinctIPs(ctx context.Context) ([]string, error) {
+	results, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) ([]string, error) {
+		return shard.ListDistinctIPs(ctx, 0, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeDistinct(results), nil
+}
+
+// ListDistinctServices merges the services of every shard
+func (s *PartitionedStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	results, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) ([]string, error) {
+		return shard.ListDistinctServices(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeDistinct(results), nil
+}
+
+// ListDistinctPorts merges the ports of every shard
+func (s *PartitionedStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	results, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) ([]uint32, error) {
+		return shard.ListDistinctPorts(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeDistinct(results), nil
+}
+
+// FindCoOccurrence collects the records matching each filter from every
+// shard and keeps the IPs matching them all
+// An IP's records may be on different shards, so no single shard can
+// answer for the whole filter list
+func (s *PartitionedStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	if len(services) == 0 {
+		return []*IPSummary{}, nil
+	}
+	results, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) ([]*ServiceRecord, error) {
+		var matching []*ServiceRecord
+		for _, f := range services {
+			summaries, err := shard.FindCoOccurrence(ctx, []ServiceFilter{f})
+			if err != nil {
+				return nil, err
+			}
+			for _, summary := range summaries {
+				matching = append(matching, summary.Records...)
+			}
+		}
+		return matching, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A record matching several filters was returned once for each
+	seen := make(map[RecordKey]bool)
+	var records []*ServiceRecord
+	for _, r := range slices.Concat(results...) {
+		if !seen[r.Key()] {
+			seen[r.Key()] = true
+			records = append(records, r)
+		}
+	}
+	return coOccurring(records, services), nil
+}
+
+// ListChangedSince merges the changed records of every shard
+func (s *PartitionedStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.listMerged(ctx, 0, 0, func(ctx context.Context, shard Store, _ int) ([]*ServiceRecord, error) {
+		return shard.ListChangedSince(ctx, timestamp)
+	})
+}
+
+// Delete soft-deletes the record on its shard
+func (s *PartitionedStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return s.shard(ip, port, service).Delete(ctx, ip, port, service)
+}
+
+// Undelete restores the record on its shard
+func (s *PartitionedStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return s.shard(ip, port, service).Undelete(ctx, ip, port, service)
+}
+
+// ListDeleted merges the deleted records of every shard, most recently
+// deleted first
+func (s *PartitionedStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	window := 0
+	if limit > 0 {
+		window = offset + limit
+	}
+	pages, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) ([]*ServiceRecord, error) {
+		return shard.ListDeleted(ctx, window, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	merged := mergeRecords(pages, func(a, b *ServiceRecord) int {
+		return cmp.Or(b.DeletedAt.Compare(*a.DeletedAt), compareListOrder(a, b))
+	})
+	return paginate(merged, limit, offset), nil
+}
+
+// DeleteOlderThan removes old records from every shard
+func (s *PartitionedStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	deleted, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) (int64, error) {
+		return shard.DeleteOlderThan(ctx, beforeTimestamp)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sum(deleted), nil
+}
+
+// Count adds up the records of every shard
+func (s *PartitionedStore) Count(ctx context.Context) (int64, error) {
+	counts, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) (int64, error) {
+		return shard.Count(ctx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sum(counts), nil
+}
+
+// PurgeExpired purges expired records from every shard
+func (s *PartitionedStore) PurgeExpired(ctx context.Context) (int64, error) {
+	purged, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) (int64, error) {
+		return shard.PurgeExpired(ctx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sum(purged), nil
+}
+
+// Stats combines the stats of every shard
+func (s *PartitionedStore) Stats(ctx context.Context) (*StoreStats, error) {
+	results, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) (*StoreStats, error) {
+		return shard.Stats(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &StoreStats{
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
+	}
+	for _, shardStats := range results {
+		if shardStats.TotalRecords == 0 {
+			// Empty shards report zero timestamps
+			continue
+		}
+		if stats.TotalRecords == 0 || shardStats.OldestTimestamp < stats.OldestTimestamp {
+			stats.OldestTimestamp = shardStats.OldestTimestamp
+		}
+		stats.NewestTimestamp = max(stats.NewestTimestamp, shardStats.NewestTimestamp)
+		stats.TotalRecords += shardStats.TotalRecords
+		for service, n := range shardStats.RecordsByService {
+			stats.RecordsByService[service] += n
+		}
+		for port, n := range shardStats.RecordsByPort {
+			stats.RecordsByPort[port] += n
+		}
+	}
+	return stats, nil
+}
+
+// Watch merges the events of every shard into one channel
+// As with a single store, events are dropped if the watcher falls behind
+func (s *PartitionedStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	out := make(chan StoreEvent, watchBufferSize)
+
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		ch, err := shard.Watch(ctx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ev := range ch {
+				select {
+				case out <- ev:
+				default:
+				}
+			}
+		}()
+	}
+
+	s.mu.Lock()
+	s.watches[out] = cancel
+	s.mu.Unlock()
+
+	// The shard channels close when ctx is done or a shard is closed
+	go func() {
+		wg.Wait()
+		s.mu.Lock()
+		delete(s.watches, out)
+		s.mu.Unlock()
+		cancel()
+		close(out)
+	}()
+	return out, nil
+}
+
+// Unwatch stops a watch started by Watch
+// The channel is closed once every shard has stopped delivering to it
+func (s *PartitionedStore) Unwatch(ch <-chan StoreEvent) {
+	s.mu.Lock()
+	cancel, exists := s.watches[ch]
+	s.mu.Unlock()
+	if exists {
+		cancel()
+	}
+}
+
+// HealthCheck checks every shard
+func (s *PartitionedStore) HealthCheck(ctx context.Context) error {
+	_, err := eachShard(ctx, s.shards, func(ctx context.Context, shard Store) (struct{}, error) {
+		return struct{}{}, shard.HealthCheck(ctx)
+	})
+	return err
+}
+
+// Close closes every shard
+func (s *PartitionedStore) Close() error {
+	errs := make([]error, len(s.shards))
+	for i, shard := range s.shards {
+		errs[i] = shard.Close()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// TestPartitionedStore tests that records are spread over the shards, each
+// stored exactly once, and that List merges them back into order
+func TestPartitionedStore(t *testing.T) {
+	shards := []*MemoryStore{NewMemoryStore(), NewMemoryStore(), NewMemoryStore()}
+	s := NewPartitionedStore([]Store{shards[0], shards[1], shards[2]}, nil)
+	defer s.Close()
+	ctx := context.Background()
+
+	// Three services on each IP, which the hash spreads over the shards
+	services := []struct {
+		port    uint32
+		service string
+	}{{80, "HTTP"}, {22, "SSH"}, {443, "HTTPS"}}
+	var records []*ServiceRecord
+	for i := 0; i < 100; i++ {
+		for j, svc := range services {
+			records = append(records, &ServiceRecord{
+				IP:            IPAddress(fmt.Sprintf("10.0.%d.%d", i/10, i%10)),
+				Port:          svc.port,
+				Service:       svc.service,
+				LastTimestamp: int64(1000 + (i*7+j*13)%50),
+			})
+		}
+	}
+	for _, r := range records {
+		if _, err := s.Upsert(ctx, r); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+
+	for _, r := range records {
+		found := 0
+		for _, shard := range shards {
+			if got, _ := shard.Get(ctx, r.IP.String(), r.Port, r.Service); got != nil {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("Expected %s:%d/%s on exactly one shard, found on %d", r.IP, r.Port, r.Service, found)
+		}
+	}
+	for i, shard := range shards {
+		if shard.Len() == 0 {
+			t.Errorf("Expected shard %d to hold records", i)
+		}
+	}
+
+	all, err := s.List(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(all) != len(records) {
+		t.Fatalf("Expected %d records, got %d", len(records), len(all))
+	}
+	if !slices.IsSortedFunc(all, compareListOrder) {
+		t.Error("Expected records newest first, then by ip, port and service")
+	}
+
+	// Pages line up with the full listing
+	var paged []*ServiceRecord
+	for offset := 0; offset < len(records); offset += 40 {
+		page, err := s.List(ctx, 40, offset)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		paged = append(paged, page...)
+	}
+	if !slices.EqualFunc(paged, all, func(a, b *ServiceRecord) bool { return a.Key() == b.Key() }) {
+		t.Error("Expected the pages together to match the full listing")
+	}
+
+	if count, err := s.Count(ctx); err != nil || count != int64(len(records)) {
+		t.Errorf("Expected count %d, got %d (err %v)", len(records), count, err)
+	}
+	if byIP, err := s.ListByIP(ctx, "10.0.4.2"); err != nil || len(byIP) != len(services) {
+		t.Errorf("Expected %d records for 10.0.4.2 across shards, got %d (err %v)", len(services), len(byIP), err)
+	}
+	if n, err := s.CountDistinctIPs(ctx); err != nil || n != 100 {
+		t.Errorf("Expected 100 distinct IPs, got %d (err %v)", n, err)
+	}
+
+	summaries, err := s.FindCoOccurrence(ctx, []ServiceFilter{{Service: "HTTP"}, {Service: "SSH"}})
+	if err != nil {
+		t.Fatalf("FindCoOccurrence failed: %v", err)
+	}
+	if len(summaries) != 100 {
+		t.Errorf("Expected every IP to have HTTP and SSH, got %d", len(summaries))
+	}
+}
+
+// TestPartitionedStoreRouting tests that single-key calls use the hash and
+// that every spelling of an IP reaches the same shard
+func TestPartitionedStoreRouting(t *testing.T) {
+	shards := []*MemoryStore{NewMemoryStore(), NewMemoryStore()}
+	s := NewPartitionedStore([]Store{shards[0], shards[1]}, func(ip string, port uint32, service string) int {
+		return -int(port) // negative hashes still pick a shard
+	})
+	ctx := context.Background()
+
+	if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 81, Service: "HTTP", LastTimestamp: 1000}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if shards[1].Len() != 1 {
+		t.Errorf("Expected the record on shard 1, got %d and %d records", shards[0].Len(), shards[1].Len())
+	}
+	if deleted, err := s.Delete(ctx, "1.1.1.1", 81, "HTTP"); err != nil || !deleted {
+		t.Errorf("Expected Delete to find the record, got %v (err %v)", deleted, err)
+	}
+
+	if DefaultPartitionHash("::1", 80, "HTTP") != DefaultPartitionHash("0:0::1", 80, "HTTP") {
+		t.Error("Expected both spellings of ::1 to hash alike")
+	}
+}
@@ -3,67 +3,306 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// pgNotifyChannel is the NOTIFY channel the change trigger publishes on
+const pgNotifyChannel = "service_record_changes"
+
 // PostgresStore implements Store interface using PostgreSQL
 type PostgresStore struct {
-	db *sql.DB
+	db      *sql.DB
+	connStr string
+	dialer  pq.Dialer // listener dialer, nil for the driver's own
+	hub     watchHub
+
+	mu       sync.Mutex
+	listener *pq.Listener // started on first Watch
+}
+
+// postgresMigrations builds service_records from the original schema
+// Columns use IF NOT EXISTS so databases created before versioning migrate
+// cleanly
+var postgresMigrations = []Migration{
+	{Version: 1, SQL: `
+		CREATE TABLE IF NOT EXISTS service_records (
+			ip            TEXT NOT NULL,
+			port          INTEGER NOT NULL,
+			service       TEXT NOT NULL,
+			last_timestamp BIGINT NOT NULL,
+			response      TEXT NOT NULL,
+			updated_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (ip, port, service)
+		)
+	`},
+	{Version: 2, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS tls_version TEXT`},
+	{Version: 3, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS status_code INTEGER`},
+	{Version: 4, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ`},
+	{Version: 5, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`},
+	{Version: 6, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS first_seen_at TIMESTAMP`},
+	// Existing rows take their first_seen_at from updated_at before the
+	// column becomes NOT NULL
+	{Version: 7, SQL: `
+		UPDATE service_records SET first_seen_at = COALESCE(updated_at, CURRENT_TIMESTAMP) WHERE first_seen_at IS NULL;
+		ALTER TABLE service_records ALTER COLUMN first_seen_at SET DEFAULT CURRENT_TIMESTAMP;
+		ALTER TABLE service_records ALTER COLUMN first_seen_at SET NOT NULL
+	`},
+	{Version: 8, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS scan_count INTEGER NOT NULL DEFAULT 1`},
+	{Version: 9, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS previous_response TEXT NOT NULL DEFAULT ''`},
+	{Version: 10, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS response_hash TEXT NOT NULL DEFAULT ''`},
+	{Version: 11, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS previous_response_hash TEXT NOT NULL DEFAULT ''`},
+	{Version: 12, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS response_truncated BOOLEAN NOT NULL DEFAULT FALSE`},
+	// service_record_changelog is written by ChangelogStore
+	{Version: 13, SQL: `
+		CREATE TABLE IF NOT EXISTS service_record_changelog (
+			id                 BIGSERIAL PRIMARY KEY,
+			changed_at         TIMESTAMPTZ NOT NULL,
+			ip                 TEXT NOT NULL,
+			port               INTEGER NOT NULL,
+			service            TEXT NOT NULL,
+			old_response       TEXT NOT NULL,
+			new_response       TEXT NOT NULL,
+			old_last_timestamp BIGINT NOT NULL,
+			new_last_timestamp BIGINT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_changelog_key ON service_record_changelog (ip, port, service, id)
+	`},
+	// scan_errors is written by ErrorTrackingStore
+	{Version: 14, SQL: `
+		CREATE TABLE IF NOT EXISTS scan_errors (
+			ip            TEXT NOT NULL,
+			port          INTEGER NOT NULL,
+			service       TEXT NOT NULL,
+			last_error    TEXT NOT NULL,
+			error_count   BIGINT NOT NULL,
+			last_error_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (ip, port, service)
+		)
+	`},
+	// pubsub_* identify the Pub/Sub message behind the last accepted upsert
+	{Version: 15, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS pubsub_message_id TEXT`},
+	{Version: 16, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS pubsub_subscription_id TEXT`},
+	{Version: 17, SQL: `ALTER TABLE service_records ADD COLUMN IF NOT EXISTS pubsub_publish_time TIMESTAMP`},
+	// Addresses written before IPs were canonicalized are rewritten in Go
+	{Version: 18, Func: canonicalIPs(pgPlaceholder)},
 }
 
+// pgUpsertSQL upserts a single record, applying the timestamp guard
+// Arguments: see pgUpsertArgs
+const pgUpsertSQL = `
+		INSERT INTO service_records (ip, port, service, last_timestamp, response, updated_at, first_seen_at, tls_version, status_code, expires_at, response_hash, response_truncated, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, NULLIF($6, ''), NULLIF($7, 0), $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (ip, port, service) DO UPDATE SET
+			last_timestamp = EXCLUDED.last_timestamp,
+			response = EXCLUDED.response,
+			updated_at = CURRENT_TIMESTAMP,
+			tls_version = EXCLUDED.tls_version,
+			status_code = EXCLUDED.status_code,
+			scan_count = service_records.scan_count + 1,
+			previous_response = service_records.response,
+			response_hash = EXCLUDED.response_hash,
+			response_truncated = EXCLUDED.response_truncated,
+			previous_response_hash = service_records.response_hash,
+			expires_at = EXCLUDED.expires_at,
+			pubsub_message_id = EXCLUDED.pubsub_message_id,
+			pubsub_subscription_id = EXCLUDED.pubsub_subscription_id,
+			pubsub_publish_time = EXCLUDED.pubsub_publish_time
+		WHERE EXCLUDED.last_timestamp > service_records.last_timestamp
+	`
+
+// pgUpsertArgs returns the arguments of pgUpsertSQL for r: ip, port,
+// service, last_timestamp, response, tls_version, status_code, expires_at,
+// response_hash, response_truncated and the pubsub_* columns
+func pgUpsertArgs(r *ServiceRecord) []interface{} {
+	messageID, subscriptionID, publishTime := messageColumns(r)
+	return []interface{}{r.IP, r.Port, r.Service, r.LastTimestamp, r.Response, r.TLSVersion, r.StatusCode, r.ExpiresAt, r.ResponseHash, r.ResponseTruncated,
+		messageID, subscriptionID, publishTime}
+}
+
+// pgUpdateIfUnchangedSQL replaces a live record only while its
+// last_timestamp still equals $14
+// Arguments: pgUpsertArgs, then the expected timestamp
+const pgUpdateIfUnchangedSQL = `
+		UPDATE service_records SET
+			last_timestamp = $4,
+			response = $5,
+			updated_at = CURRENT_TIMESTAMP,
+			tls_version = NULLIF($6, ''),
+			status_code = NULLIF($7, 0),
+			scan_count = scan_count + 1,
+			previous_response = response,
+			response_hash = $9,
+			response_truncated = $10,
+			previous_response_hash = response_hash,
+			expires_at = $8,
+			pubsub_message_id = $11,
+			pubsub_subscription_id = $12,
+			pubsub_publish_time = $13
+		WHERE ip = $1 AND port = $2 AND service = $3 AND last_timestamp = $14 AND deleted_at IS NULL
+	`
+
+// pgGetMultiSQL selects the live records for keys passed as parallel ip,
+// port and service arrays, so any number of keys takes three parameters
+const pgGetMultiSQL = `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE (ip, port, service) IN (SELECT * FROM UNNEST($1::text[], $2::integer[], $3::text[]))
+		AND deleted_at IS NULL
+	`
+
+// pgBulkUpsertSQL upserts a batch from parallel arrays of pgUpsertSQL's
+// arguments
+// RETURNING reports which rows were written so the rest can be reported as
+// skipped to watchers
+const pgBulkUpsertSQL = `
+		INSERT INTO service_records (ip, port, service, last_timestamp, response, updated_at, first_seen_at, tls_version, status_code, expires_at, response_hash, response_truncated,
+			pubsub_message_id, pubsub_subscription_id, pubsub_publish_time)
+		SELECT ip, port, service, last_timestamp, response, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP,
+			NULLIF(tls_version, ''), NULLIF(status_code, 0), expires_at, response_hash, response_truncated,
+			pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM UNNEST($1::text[], $2::integer[], $3::text[], $4::bigint[], $5::text[], $6::text[], $7::integer[],
+			$8::timestamptz[], $9::text[], $10::boolean[], $11::text[], $12::text[], $13::timestamp[])
+			AS t(ip, port, service, last_timestamp, response, tls_version, status_code, expires_at, response_hash, response_truncated,
+				pubsub_message_id, pubsub_subscription_id, pubsub_publish_time)
+		ON CONFLICT (ip, port, service) DO UPDATE SET
+			last_timestamp = EXCLUDED.last_timestamp,
+			response = EXCLUDED.response,
+			updated_at = CURRENT_TIMESTAMP,
+			tls_version = EXCLUDED.tls_version,
+			status_code = EXCLUDED.status_code,
+			scan_count = service_records.scan_count + 1,
+			previous_response = service_records.response,
+			response_hash = EXCLUDED.response_hash,
+			response_truncated = EXCLUDED.response_truncated,
+			previous_response_hash = service_records.response_hash,
+			expires_at = EXCLUDED.expires_at,
+			pubsub_message_id = EXCLUDED.pubsub_message_id,
+			pubsub_subscription_id = EXCLUDED.pubsub_subscription_id,
+			pubsub_publish_time = EXCLUDED.pubsub_publish_time
+		WHERE EXCLUDED.last_timestamp > service_records.last_timestamp
+		RETURNING ip, port, service
+	`
+
 // NewPostgresStore creates a new PostgreSQL store
+// connStr is validated by ParsePostgresDSN before connecting
 func NewPostgresStore(connStr string) (*PostgresStore, error) {
+	if _, err := ParsePostgresDSN(connStr); err != nil {
+		return nil, err
+	}
+
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	return openPostgresStore(db, connStr, nil)
+}
 
+// openPostgresStore checks the connection and sets up the schema of db,
+// closing it on failure
+// dialer opens the change listener's connection to connStr, or nil for the
+// driver's own
+func openPostgresStore(db *sql.DB, connStr string, dialer pq.Dialer) (*PostgresStore, error) {
 	// Test connection
 	if err := db.Ping(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Create table if not exists
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS service_records (
-			ip            TEXT NOT NULL,
-			port          INTEGER NOT NULL,
-			service       TEXT NOT NULL,
-			last_timestamp BIGINT NOT NULL,
-			response      TEXT NOT NULL,
-			updated_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (ip, port, service)
-		)
+	if err := setupPostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresStore{db: db, connStr: connStr, dialer: dialer}, nil
+}
+
+// setupPostgres applies schema migrations and creates the indexes and change
+// notification trigger
+// Shared by PostgresStore and PostgresStoreV2, which use the same schema
+func setupPostgres(db *sql.DB) error {
+	if err := applyMigrations(context.Background(), db, postgresMigrations, migrationDialect{recordSQL: onConflictRecordSQL}); err != nil {
+		return err
+	}
+
+	// Create indexes for common queries
+	// IF NOT EXISTS also adds any new indexes to existing databases on startup
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_timestamp ON service_records(last_timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_ip ON service_records(ip)`,
+		`CREATE INDEX IF NOT EXISTS idx_service ON service_records(service)`,
+		`CREATE INDEX IF NOT EXISTS idx_port ON service_records(port)`,
+		`CREATE INDEX IF NOT EXISTS idx_expires_at ON service_records(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_deleted_at ON service_records(deleted_at)`,
+	}
+	for _, stmt := range indexes {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	// Publish inserts and updates via NOTIFY so every store instance sharing
+	// the database can stream changes
+	// NOTIFY payloads are limited to 8000 bytes, so large rows are sent as
+	// key-only payloads and fetched by the listener
+	// Upserts always advance last_timestamp, so updates that leave it alone
+	// (soft deletes and undeletes) are not reported
+	_, err := db.Exec(`
+		CREATE OR REPLACE FUNCTION notify_service_record_change() RETURNS trigger AS $$
+		DECLARE
+			payload TEXT;
+		BEGIN
+			IF TG_OP = 'UPDATE' AND NEW.last_timestamp = OLD.last_timestamp THEN
+				RETURN NEW;
+			END IF;
+			payload := json_build_object(
+				'op', TG_OP,
+				'new', row_to_json(NEW),
+				'old', CASE WHEN TG_OP = 'UPDATE' THEN row_to_json(OLD) END
+			)::text;
+			IF octet_length(payload) > 7900 THEN
+				payload := json_build_object(
+					'op', TG_OP,
+					'truncated', true,
+					'new', json_build_object('ip', NEW.ip, 'port', NEW.port, 'service', NEW.service)
+				)::text;
+			END IF;
+			PERFORM pg_notify('` + pgNotifyChannel + `', payload);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql
 	`)
 	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to create table: %w", err)
+		return fmt.Errorf("failed to create notify function: %w", err)
 	}
 
-	// Create index for timestamp queries
-	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_timestamp ON service_records(last_timestamp)`)
+	_, err = db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'service_records_notify') THEN
+				CREATE TRIGGER service_records_notify
+				AFTER INSERT OR UPDATE ON service_records
+				FOR EACH ROW EXECUTE FUNCTION notify_service_record_change();
+			END IF;
+		END
+		$$
+	`)
 	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to create index: %w", err)
+		return fmt.Errorf("failed to create notify trigger: %w", err)
 	}
 
-	return &PostgresStore{db: db}, nil
+	return nil
 }
 
 // Upsert inserts or updates a record if the timestamp is newer
 func (s *PostgresStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
-	result, err := s.db.ExecContext(ctx, `
-		INSERT INTO service_records (ip, port, service, last_timestamp, response, updated_at)
-		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
-		ON CONFLICT (ip, port, service) DO UPDATE SET
-			last_timestamp = EXCLUDED.last_timestamp,
-			response = EXCLUDED.response,
-			updated_at = CURRENT_TIMESTAMP
-		WHERE EXCLUDED.last_timestamp > service_records.last_timestamp
-	`, r.IP, r.Port, r.Service, r.LastTimestamp, r.Response)
+	r = canonicalRecord(r)
+	result, err := s.db.ExecContext(ctx, pgUpsertSQL, pgUpsertArgs(r)...)
 
 	if err != nil {
 		return false, fmt.Errorf("failed to upsert record: %w", err)
@@ -74,19 +313,133 @@ func (s *PostgresStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, err
 		return false, fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
+	if rows == 0 && s.hub.active() {
+		s.publishSkipped(ctx, r)
+	}
+
+	return rows > 0, nil
+}
+
+// UpdateIfUnchanged replaces a record if its LastTimestamp equals
+// expectedTimestamp
+// The notify trigger reports the update to watchers
+func (s *PostgresStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	r = canonicalRecord(r)
+	result, err := s.db.ExecContext(ctx, pgUpdateIfUnchangedSQL, append(pgUpsertArgs(r), expectedTimestamp)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update record: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
 	return rows > 0, nil
 }
 
+// publishSkipped reports an upsert ignored by the timestamp guard
+// The trigger only fires on writes, so skipped events are local to this instance
+func (s *PostgresStore) publishSkipped(ctx context.Context, r *ServiceRecord) {
+	previous, err := s.Get(ctx, r.IP.String(), r.Port, r.Service)
+	if err != nil {
+		previous = nil
+	}
+	s.hub.publish(StoreEvent{Type: EventSkipped, Record: copyRecord(r), Previous: previous})
+}
+
+// BulkUpsert upserts a batch of records in one statement using UNNEST arrays
+func (s *PostgresStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	records = dedupeRecords(records)
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	ips := make([]string, len(records))
+	ports := make([]int64, len(records))
+	services := make([]string, len(records))
+	timestamps := make([]int64, len(records))
+	responses := make([]string, len(records))
+	tlsVersions := make([]string, len(records))
+	statusCodes := make([]int64, len(records))
+	expiresAts := make([]sql.NullString, len(records))
+	hashes := make([]string, len(records))
+	truncated := make([]bool, len(records))
+	messageIDs := make([]sql.NullString, len(records))
+	subscriptionIDs := make([]sql.NullString, len(records))
+	publishTimes := make([]sql.NullString, len(records))
+	for i, r := range records {
+		ips[i] = r.IP.String()
+		ports[i] = int64(r.Port)
+		services[i] = r.Service
+		timestamps[i] = r.LastTimestamp
+		responses[i] = r.Response
+		tlsVersions[i] = r.TLSVersion
+		statusCodes[i] = int64(r.StatusCode)
+		hashes[i] = r.ResponseHash
+		truncated[i] = r.ResponseTruncated
+		if r.ExpiresAt != nil {
+			expiresAts[i] = sql.NullString{String: r.ExpiresAt.Format(time.RFC3339Nano), Valid: true}
+		}
+		var publishTime *time.Time
+		messageIDs[i], subscriptionIDs[i], publishTime = messageColumns(r)
+		if publishTime != nil {
+			publishTimes[i] = sql.NullString{String: publishTime.Format(time.RFC3339Nano), Valid: true}
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, pgBulkUpsertSQL, pq.Array(ips), pq.Array(ports), pq.Array(services), pq.Array(timestamps), pq.Array(responses),
+		pq.Array(tlsVersions), pq.Array(statusCodes), pq.Array(expiresAts), pq.Array(hashes), pq.Array(truncated),
+		pq.Array(messageIDs), pq.Array(subscriptionIDs), pq.Array(publishTimes))
+	if err != nil {
+		return 0, fmt.Errorf("failed to bulk upsert records: %w", err)
+	}
+
+	written := make(map[string]bool, len(records))
+	for rows.Next() {
+		var ip, service string
+		var port uint32
+		if err := rows.Scan(&ip, &port, &service); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan upserted key: %w", err)
+		}
+		written[makeKey(ip, port, service)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to bulk upsert records: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if s.hub.active() {
+		for _, r := range records {
+			if !written[makeKey(r.IP.String(), r.Port, r.Service)] {
+				s.publishSkipped(ctx, r)
+			}
+		}
+	}
+
+	return len(written), nil
+}
+
 // Get retrieves a record by its composite key
 func (s *PostgresStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
 	row := s.db.QueryRowContext(ctx, `
-		SELECT ip, port, service, last_timestamp, response, updated_at
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
 		FROM service_records
-		WHERE ip = $1 AND port = $2 AND service = $3
+		WHERE ip = $1 AND port = $2 AND service = $3 AND deleted_at IS NULL
 	`, ip, port, service)
 
-	var r ServiceRecord
-	err := row.Scan(&r.IP, &r.Port, &r.Service, &r.LastTimestamp, &r.Response, &r.UpdatedAt)
+	r, err := scanServiceRecord(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -94,51 +447,614 @@ func (s *PostgresStore) Get(ctx context.Context, ip string, port uint32, service
 		return nil, fmt.Errorf("failed to get record: %w", err)
 	}
 
-	return &r, nil
+	return r, nil
+}
+
+// GetMulti retrieves several records in a single query
+func (s *PostgresStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	ips, ports, services := splitKeys(keys)
+	records, err := queryRecords(ctx, s.db, pgGetMultiSQL, pq.Array(ips), pq.Array(ports), pq.Array(services))
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[RecordKey]*ServiceRecord, len(records))
+	for _, r := range records {
+		found[r.Key()] = r
+	}
+	return found, nil
+}
+
+// splitKeys returns the fields of keys as parallel arrays for UNNEST, with
+// the IPs in canonical form
+func splitKeys(keys []RecordKey) (ips []string, ports []int64, services []string) {
+	ips = make([]string, len(keys))
+	ports = make([]int64, len(keys))
+	services = make([]string, len(keys))
+	for i, k := range keys {
+		ips[i] = CanonicalIP(k.IP)
+		ports[i] = int64(k.Port)
+		services[i] = k.Service
+	}
+	return ips, ports, services
 }
 
 // List returns all records with optional pagination
 func (s *PostgresStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
-	var rows *sql.Rows
-	var err error
+	query, args := pgListQuery(limit, offset)
+	return queryRecords(ctx, s.db, query, args...)
+}
 
+// ListV2 returns a page of records and the number of live records, read
+// from one snapshot
+func (s *PostgresStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	query, args := pgListQuery(limit, offset)
+	return listWithTotal(ctx, s.db, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, query, args...)
+}
+
+// pgListQuery returns the List query for a page of live records
+// Shared with PostgresStoreV2
+func pgListQuery(limit, offset int) (string, []interface{}) {
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`
+	if limit > 0 {
+		return query + ` LIMIT $1 OFFSET $2`, []interface{}{limit, offset}
+	}
+	return query, nil
+}
+
+// ListByIP returns all records for the given IP address
+func (s *PostgresStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	return queryRecords(ctx, s.db, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = $1 AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`, ip)
+}
+
+// ListByService returns records for the given service with optional pagination
+func (s *PostgresStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE service = $1 AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`, limit, offset, service)
+}
+
+// ListByPort returns records for the given port with optional pagination
+func (s *PostgresStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE port = $1 AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`, limit, offset, port)
+}
+
+// ListByTimestampRange returns records within the given timestamp window
+func (s *PostgresStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	if from != 0 {
+		conditions = append(conditions, fmt.Sprintf("last_timestamp >= $%d", len(args)+1))
+		args = append(args, from)
+	}
+	if to != 0 {
+		conditions = append(conditions, fmt.Sprintf("last_timestamp <= $%d", len(args)+1))
+		args = append(args, to)
+	}
+
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+	`
+	query += ` WHERE ` + strings.Join(conditions, " AND ")
+	query += ` ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"`
+
+	return s.queryPage(ctx, query, limit, offset, args...)
+}
+
+// ListByCIDR returns records whose IP falls within the given CIDR range
+// SQL has no portable CIDR arithmetic, so candidates are narrowed with a LIKE
+// prefix on IPv4 octets and the exact containment check is done in Go
+func (s *PostgresStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`
+	var args []interface{}
+	if prefix := ipv4LikePrefix(network); prefix != "" {
+		query += ` AND ip LIKE $1`
+		args = append(args, prefix)
+	}
+	query += ` ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"`
+
+	candidates, err := queryRecords(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*ServiceRecord, 0, len(candidates))
+	for _, r := range candidates {
+		if cidrContains(network, r.IP.String()) {
+			matched = append(matched, r)
+		}
+	}
+
+	return paginate(matched, limit, offset), nil
+}
+
+// queryPage appends LIMIT/OFFSET to query when limit > 0 and runs it
+func (s *PostgresStore) queryPage(ctx context.Context, query string, limit, offset int, args ...interface{}) ([]*ServiceRecord, error) {
 	if limit > 0 {
-		rows, err = s.db.QueryContext(ctx, `
-			SELECT ip, port, service, last_timestamp, response, updated_at
-			FROM service_records
-			ORDER BY last_timestamp DESC
+		query += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+		args = append(args, limit, offset)
+	}
+	return queryRecords(ctx, s.db, query, args...)
+}
+
+// SearchByResponse returns records whose response contains query, ignoring case
+func (s *PostgresStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE response ILIKE '%' || $1 || '%' ESCAPE '\' AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`, limit, offset, escapeLike(query))
+}
+
+// ListAfter returns records after the given cursor using keyset pagination
+func (s *PostgresStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`
+	var args []interface{}
+	// COLLATE "C" compares bytes like compareListOrder, whatever the database locale
+	if !after.isStart() {
+		query += ` AND (last_timestamp < $1 OR (last_timestamp = $1 AND (ip COLLATE "C", port, service COLLATE "C") > ($2, $3, $4)))`
+		args = append(args, after.Timestamp, after.IP, after.Port, after.Service)
+	}
+	query += ` ORDER BY last_timestamp DESC, ip COLLATE "C" ASC, port ASC, service COLLATE "C" ASC`
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT $%d`, len(args)+1)
+		args = append(args, limit)
+	}
+
+	return queryRecords(ctx, s.db, query, args...)
+}
+
+// ListDistinctIPs returns the unique IPs of live records with optional
+// pagination
+// The C collation sorts by byte value like the other stores
+func (s *PostgresStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	if limit > 0 {
+		return queryColumn[string](ctx, s.db, `
+			SELECT DISTINCT ip FROM service_records
+			WHERE deleted_at IS NULL
+			ORDER BY ip COLLATE "C"
 			LIMIT $1 OFFSET $2
 		`, limit, offset)
-	} else {
-		rows, err = s.db.QueryContext(ctx, `
-			SELECT ip, port, service, last_timestamp, response, updated_at
-			FROM service_records
-			ORDER BY last_timestamp DESC
-		`)
 	}
 
+	return queryColumn[string](ctx, s.db, `
+		SELECT DISTINCT ip FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY ip COLLATE "C"
+	`)
+}
+
+// CountDistinctIPs returns the number of unique IPs of live records
+func (s *PostgresStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT ip) FROM service_records WHERE deleted_at IS NULL`).Scan(&count)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list records: %w", err)
+		return 0, fmt.Errorf("failed to count ips: %w", err)
+	}
+	return count, nil
+}
+
+// ListDistinctServices returns the unique services of live records
+func (s *PostgresStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return queryColumn[string](ctx, s.db, `
+		SELECT DISTINCT service FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY service COLLATE "C"
+	`)
+}
+
+// ListDistinctPorts returns the unique ports of live records
+func (s *PostgresStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return queryColumn[uint32](ctx, s.db, `
+		SELECT DISTINCT port FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY port
+	`)
+}
+
+// FindCoOccurrence returns the IPs with a live record matching every filter
+func (s *PostgresStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	if len(services) == 0 {
+		return []*IPSummary{}, nil
+	}
+
+	query, args := coOccurrenceQuery(services, func(n int) string { return fmt.Sprintf("$%d", n) })
+	records, err := queryRecords(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return groupByIP(records), nil
+}
+
+// ListChangedSince returns records updated after timestamp with a new
+// response hash
+func (s *PostgresStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return queryRecords(ctx, s.db, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE last_timestamp > $1 AND deleted_at IS NULL
+			AND previous_response_hash <> '' AND response_hash <> previous_response_hash
+		ORDER BY last_timestamp DESC, ip ASC
+	`, timestamp)
+}
+
+// Delete soft-deletes a record by setting deleted_at
+func (s *PostgresStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	ip = CanonicalIP(ip)
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE service_records SET deleted_at = CURRENT_TIMESTAMP
+		WHERE ip = $1 AND port = $2 AND service = $3 AND deleted_at IS NULL
+	`, ip, port, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete record: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// Undelete clears deleted_at on a soft-deleted record
+func (s *PostgresStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	ip = CanonicalIP(ip)
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE service_records SET deleted_at = NULL
+		WHERE ip = $1 AND port = $2 AND service = $3 AND deleted_at IS NOT NULL
+	`, ip, port, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to undelete record: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// ListDeleted returns soft-deleted records, most recently deleted first
+func (s *PostgresStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, last_timestamp DESC
+	`, limit, offset)
+}
+
+// DeleteOlderThan removes records with a timestamp before beforeTimestamp
+// Soft-deleted records are removed as well
+func (s *PostgresStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM service_records WHERE last_timestamp < $1`, beforeTimestamp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old records: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+// PurgeExpired removes records whose expires_at is in the past
+func (s *PostgresStore) PurgeExpired(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM service_records
+		WHERE expires_at IS NOT NULL AND expires_at < $1
+	`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired records: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+// Count returns the total number of records
+func (s *PostgresStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM service_records WHERE deleted_at IS NULL`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count records: %w", err)
+	}
+	return count, nil
+}
+
+// Stats returns aggregate counts using GROUP BY queries
+func (s *PostgresStore) Stats(ctx context.Context) (*StoreStats, error) {
+	stats := &StoreStats{
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(MIN(last_timestamp), 0), COALESCE(MAX(last_timestamp), 0)
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`).Scan(&stats.TotalRecords, &stats.OldestTimestamp, &stats.NewestTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query totals: %w", err)
 	}
-	defer rows.Close()
 
-	var records []*ServiceRecord
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT service, COUNT(*) FROM service_records
+		WHERE deleted_at IS NULL
+		GROUP BY service
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service counts: %w", err)
+	}
+	defer rows.Close()
 	for rows.Next() {
-		var r ServiceRecord
-		if err := rows.Scan(&r.IP, &r.Port, &r.Service, &r.LastTimestamp, &r.Response, &r.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan record: %w", err)
+		var service string
+		var count int64
+		if err := rows.Scan(&service, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan service count: %w", err)
 		}
-		records = append(records, &r)
+		stats.RecordsByService[service] = count
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating records: %w", err)
+		return nil, fmt.Errorf("error iterating service counts: %w", err)
 	}
 
-	return records, nil
+	portRows, err := s.db.QueryContext(ctx, `
+		SELECT port, COUNT(*) FROM service_records
+		WHERE deleted_at IS NULL
+		GROUP BY port
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query port counts: %w", err)
+	}
+	defer portRows.Close()
+	for portRows.Next() {
+		var port uint32
+		var count int64
+		if err := portRows.Scan(&port, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan port count: %w", err)
+		}
+		stats.RecordsByPort[port] = count
+	}
+	if err := portRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating port counts: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Watch returns a channel of record change events
+// Created and updated events come from LISTEN/NOTIFY, so they include writes
+// made by other store instances sharing the database
+func (s *PostgresStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	if err := s.startListener(); err != nil {
+		return nil, err
+	}
+	return s.hub.watch(ctx)
 }
 
-// Close closes the database connection
+// Unwatch stops delivery to a channel returned by Watch and closes it
+func (s *PostgresStore) Unwatch(ch <-chan StoreEvent) {
+	s.hub.unwatch(ch)
+}
+
+// startListener opens the dedicated LISTEN connection if not already running
+func (s *PostgresStore) startListener() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener != nil {
+		return nil
+	}
+
+	var listener *pq.Listener
+	if s.dialer != nil {
+		listener = pq.NewDialListener(s.dialer, s.connStr, time.Second, time.Minute, nil)
+	} else {
+		listener = pq.NewListener(s.connStr, time.Second, time.Minute, nil)
+	}
+	if err := listener.Listen(pgNotifyChannel); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen for changes: %w", err)
+	}
+	s.listener = listener
+
+	go s.forwardNotifications(listener)
+	return nil
+}
+
+// forwardNotifications publishes trigger notifications until the listener
+// is closed
+func (s *PostgresStore) forwardNotifications(listener *pq.Listener) {
+	for n := range listener.Notify {
+		if n == nil {
+			// Connection was re-established - notifications sent while it
+			// was down are lost
+			continue
+		}
+
+		// pq's listener has no context; it stops when Close closes it
+		ev, err := parsePgNotification(context.Background(), n.Extra, s.Get)
+		if err != nil {
+			// Malformed payload - nothing useful to deliver
+			continue
+		}
+		s.hub.publish(ev)
+	}
+}
+
+// pgNotifyRow mirrors row_to_json output for service_records
+type pgNotifyRow struct {
+	IP                   string `json:"ip"`
+	Port                 uint32 `json:"port"`
+	Service              string `json:"service"`
+	LastTimestamp        int64  `json:"last_timestamp"`
+	Response             string `json:"response"`
+	UpdatedAt            string `json:"updated_at"`
+	FirstSeenAt          string `json:"first_seen_at"`
+	ScanCount            int64  `json:"scan_count"`
+	PreviousResponse     string `json:"previous_response"`
+	ResponseHash         string `json:"response_hash"`
+	PreviousResponseHash string `json:"previous_response_hash"`
+	ResponseTruncated    bool   `json:"response_truncated"`
+	TLSVersion           string `json:"tls_version"`
+	StatusCode           int    `json:"status_code"`
+	ExpiresAt            string `json:"expires_at"`
+	DeletedAt            string `json:"deleted_at"`
+	PubSubMessageID      string `json:"pubsub_message_id"`
+	PubSubSubscriptionID string `json:"pubsub_subscription_id"`
+	PubSubPublishTime    string `json:"pubsub_publish_time"`
+}
+
+// pgNotifyPayload is the JSON sent by notify_service_record_change
+type pgNotifyPayload struct {
+	Op        string       `json:"op"`
+	Truncated bool         `json:"truncated"`
+	New       *pgNotifyRow `json:"new"`
+	Old       *pgNotifyRow `json:"old"`
+}
+
+// toRecord converts a notification row into a ServiceRecord
+func (r *pgNotifyRow) toRecord() *ServiceRecord {
+	if r == nil {
+		return nil
+	}
+	// updated_at is TIMESTAMP without time zone, rendered without an offset
+	updatedAt, _ := time.Parse("2006-01-02T15:04:05.999999999", r.UpdatedAt)
+	firstSeenAt, _ := time.Parse("2006-01-02T15:04:05.999999999", r.FirstSeenAt)
+	record := &ServiceRecord{
+		IP:                   IPAddress(r.IP),
+		Port:                 r.Port,
+		Service:              r.Service,
+		LastTimestamp:        r.LastTimestamp,
+		Response:             r.Response,
+		UpdatedAt:            updatedAt,
+		FirstSeenAt:          firstSeenAt,
+		ScanCount:            r.ScanCount,
+		PreviousResponse:     r.PreviousResponse,
+		ResponseHash:         r.ResponseHash,
+		PreviousResponseHash: r.PreviousResponseHash,
+		ResponseTruncated:    r.ResponseTruncated,
+		TLSVersion:           r.TLSVersion,
+		StatusCode:           r.StatusCode,
+	}
+	// expires_at is TIMESTAMPTZ, rendered with an offset; null decodes as ""
+	if expiresAt, err := time.Parse(time.RFC3339Nano, r.ExpiresAt); err == nil {
+		record.ExpiresAt = &expiresAt
+	}
+	if deletedAt, err := time.Parse("2006-01-02T15:04:05.999999999", r.DeletedAt); err == nil {
+		record.DeletedAt = &deletedAt
+	}
+	if r.PubSubMessageID != "" {
+		publishTime, _ := time.Parse("2006-01-02T15:04:05.999999999", r.PubSubPublishTime)
+		record.MessageMetadata = &MessageMetadata{
+			MessageID:      r.PubSubMessageID,
+			SubscriptionID: r.PubSubSubscriptionID,
+			PublishTime:    publishTime,
+		}
+	}
+	return record
+}
+
+// parsePgNotification decodes a trigger payload into a StoreEvent
+// Truncated payloads only carry the key, so the record is fetched with get
+// and the previous record is unknown
+func parsePgNotification(ctx context.Context, payload string, get func(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error)) (StoreEvent, error) {
+	var p pgNotifyPayload
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return StoreEvent{}, fmt.Errorf("failed to decode notification: %w", err)
+	}
+	if p.New == nil {
+		return StoreEvent{}, fmt.Errorf("notification has no record")
+	}
+
+	ev := StoreEvent{Type: EventCreated}
+	if p.Op == "UPDATE" {
+		ev.Type = EventUpdated
+	}
+
+	if p.Truncated {
+		record, err := get(ctx, p.New.IP, p.New.Port, p.New.Service)
+		if err != nil {
+			return StoreEvent{}, err
+		}
+		if record == nil {
+			return StoreEvent{}, fmt.Errorf("notified record no longer exists")
+		}
+		ev.Record = record
+		return ev, nil
+	}
+
+	ev.Record = p.New.toRecord()
+	ev.Previous = p.Old.toRecord()
+	return ev, nil
+}
+
+// MigrationsApplied returns the schema migration versions recorded in the
+// database, in ascending order
+func (s *PostgresStore) MigrationsApplied(ctx context.Context) ([]int, error) {
+	return migrationsApplied(ctx, s.db)
+}
+
+// HealthCheck pings the database
+func (s *PostgresStore) HealthCheck(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
+// Close stops the change listener, closes watch channels and the database
+// connection
 func (s *PostgresStore) Close() error {
+	s.mu.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	s.hub.close()
 	return s.db.Close()
 }
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// pgSSLModes are the sslmode values lib/pq accepts
+var pgSSLModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// PostgresDSNConfig holds the connection settings of a PostgreSQL DSN
+// Settings the DSN leaves out are empty, so the driver defaults apply
+type PostgresDSNConfig struct {
+	Host     string
+	Port     string
+	DBName   string
+	User     string
+	Password string
+	SSLMode  string
+}
+
+// ParsePostgresDSN parses and validates a PostgreSQL connection string, either
+// a postgres:// URL or libpq key=value pairs
+// host and dbname are required; other settings, such as connect_timeout, are
+// left for the driver
+func ParsePostgresDSN(connStr string) (*PostgresDSNConfig, error) {
+	settings, err := parsePostgresSettings(connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &PostgresDSNConfig{
+		Host:     settings["host"],
+		Port:     settings["port"],
+		DBName:   settings["dbname"],
+		User:     settings["user"],
+		Password: settings["password"],
+		SSLMode:  settings["sslmode"],
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid postgres connection string: %w", err)
+	}
+	return c, nil
+}
+
+// validate checks for the required settings and valid values
+func (c *PostgresDSNConfig) validate() error {
+	if c.Host == "" {
+		return errors.New("missing host")
+	}
+	if c.DBName == "" {
+		return errors.New("missing dbname")
+	}
+	if c.Port != "" {
+		// libpq takes a port per host, separated by commas
+		for _, p := range strings.Split(c.Port, ",") {
+			if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("invalid port %q", c.Port)
+			}
+		}
+	}
+	if c.SSLMode != "" && !slices.Contains(pgSSLModes, c.SSLMode) {
+		return fmt.Errorf("invalid sslmode %q, must be one of %s", c.SSLMode, strings.Join(pgSSLModes, ", "))
+	}
+	return nil
+}
+
+// String returns the settings as a postgres:// URL with the password
+// replaced, so the DSN can be logged
+func (c *PostgresDSNConfig) String() string {
+	u := &url.URL{Scheme: "postgres", Host: c.Host, Path: "/" + c.DBName}
+	if c.Port != "" {
+		u.Host += ":" + c.Port
+	}
+	switch {
+	case c.Password != "":
+		u.User = url.UserPassword(c.User, "xxxxx")
+	case c.User != "":
+		u.User = url.User(c.User)
+	}
+	if c.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {c.SSLMode}}.Encode()
+	}
+	return u.String()
+}
+
+// parsePostgresSettings returns every setting in a connection string of
+// either form
+func parsePostgresSettings(connStr string) (map[string]string, error) {
+	var settings map[string]string
+	var err error
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		settings, err = parsePostgresURL(connStr)
+	} else {
+		settings, err = parsePostgresKeyValues(connStr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres connection string: %w", err)
+	}
+	return settings, nil
+}
+
+// parsePostgresURL returns the settings of a postgres:// URL
+// Query parameters set any other setting, as libpq allows, and take
+// precedence over the host, user and path
+func parsePostgresURL(connStr string) (map[string]string, error) {
+	u, err := url.Parse(connStr)
+	if err != nil {
+		// url.Error repeats the URL, which may hold the password
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to parse URL: %w", err)
+	}
+
+	settings := map[string]string{
+		"host":   u.Hostname(),
+		"port":   u.Port(),
+		"dbname": strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		settings["user"] = u.User.Username()
+		settings["password"], _ = u.User.Password()
+	}
+	for key, values := range u.Query() {
+		settings[key] = values[len(values)-1]
+	}
+	return settings, nil
+}
+
+// parsePostgresKeyValues returns the settings of a libpq key=value string
+// Values may be single-quoted, with \' and \\ escapes, to hold spaces
+func parsePostgresKeyValues(connStr string) (map[string]string, error) {
+	settings := make(map[string]string)
+	s := []rune(connStr)
+	i := 0
+	skipSpace := func() {
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+	}
+
+	for {
+		skipSpace()
+		if i == len(s) {
+			return settings, nil
+		}
+
+		start := i
+		for i < len(s) && s[i] != '=' && !unicode.IsSpace(s[i]) {
+			i++
+		}
+		key := string(s[start:i])
+		skipSpace()
+		if i == len(s) || s[i] != '=' {
+			return nil, fmt.Errorf("missing \"=\" after %q", key)
+		}
+		i++
+		skipSpace()
+
+		var value strings.Builder
+		if i < len(s) && s[i] == '\'' {
+			i++
+			for {
+				if i == len(s) {
+					return nil, fmt.Errorf("unterminated quoted value for %q", key)
+				}
+				if s[i] == '\'' {
+					i++
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteRune(s[i])
+				i++
+			}
+		} else {
+			for i < len(s) && !unicode.IsSpace(s[i]) {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteRune(s[i])
+				i++
+			}
+		}
+		settings[key] = value.String()
+	}
+}
@@ -0,0 +1,775 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Names of the statements prepared on every pool connection
+const (
+	pgxUpsertStmt = "upsert_record"
+	pgxGetStmt    = "get_record"
+)
+
+// pgxGetSQL fetches a live record by its composite key
+const pgxGetSQL = `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = $1 AND port = $2 AND service = $3 AND deleted_at IS NULL
+	`
+
+// pgxListenRetry is how long the change listener waits before reconnecting
+const pgxListenRetry = time.Second
+
+// PostgresStoreV2 implements Store using a pgx connection pool
+// It shares PostgresStore's schema, so the two can run against the same
+// database; the hot upsert and get paths use statements prepared on each
+// connection, and other queries use pgx's statement cache
+type PostgresStoreV2 struct {
+	pool *pgxpool.Pool
+	db   *sql.DB // database/sql view of pool, used for migrations
+	hub  watchHub
+
+	mu           sync.Mutex
+	listenCancel context.CancelFunc // set once the change listener is running
+	listenDone   chan struct{}
+}
+
+// NewPostgresStoreWithPool creates a PostgreSQL store backed by pgxpool
+// poolConfig, typically from pgxpool.ParseConfig with tuned MaxConns and
+// lifetimes, takes precedence over connStr; when nil the pool is configured
+// from connStr alone
+func NewPostgresStoreWithPool(ctx context.Context, connStr string, poolConfig *pgxpool.Config) (*PostgresStoreV2, error) {
+	if poolConfig == nil {
+		parsed, err := pgxpool.ParseConfig(connStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse connection string: %w", err)
+		}
+		poolConfig = parsed
+	} else {
+		poolConfig = poolConfig.Copy()
+	}
+
+	afterConnect := poolConfig.AfterConnect
+	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		if afterConnect != nil {
+			if err := afterConnect(ctx, conn); err != nil {
+				return err
+			}
+		}
+		if _, err := conn.Prepare(ctx, pgxUpsertStmt, pgUpsertSQL); err != nil {
+			return fmt.Errorf("failed to prepare upsert: %w", err)
+		}
+		if _, err := conn.Prepare(ctx, pgxGetStmt, pgxGetSQL); err != nil {
+			return fmt.Errorf("failed to prepare get: %w", err)
+		}
+		return nil
+	}
+
+	// Statements cannot be prepared before the table exists, so the schema
+	// is set up over a plain connection first
+	setupDB := stdlib.OpenDB(*poolConfig.ConnConfig.Copy())
+	if err := setupDB.PingContext(ctx); err != nil {
+		setupDB.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	err := setupPostgres(setupDB)
+	setupDB.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &PostgresStoreV2{pool: pool, db: stdlib.OpenDBFromPool(pool)}, nil
+}
+
+// Upsert inserts or updates a record if the timestamp is newer
+func (s *PostgresStoreV2) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	r = canonicalRecord(r)
+	tag, err := s.pool.Exec(ctx, pgxUpsertStmt, pgUpsertArgs(r)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to upsert record: %w", err)
+	}
+
+	updated := tag.RowsAffected() > 0
+	if !updated && s.hub.active() {
+		s.publishSkipped(ctx, r)
+	}
+
+	return updated, nil
+}
+
+// UpdateIfUnchanged replaces a record if its LastTimestamp equals
+// expectedTimestamp
+// The notify trigger reports the update to watchers
+func (s *PostgresStoreV2) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	r = canonicalRecord(r)
+	tag, err := s.pool.Exec(ctx, pgUpdateIfUnchangedSQL, append(pgUpsertArgs(r), expectedTimestamp)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update record: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// publishSkipped reports an upsert ignored by the timestamp guard
+// The trigger only fires on writes, so skipped events are local to this instance
+func (s *PostgresStoreV2) publishSkipped(ctx context.Context, r *ServiceRecord) {
+	previous, err := s.Get(ctx, r.IP.String(), r.Port, r.Service)
+	if err != nil {
+		previous = nil
+	}
+	s.hub.publish(StoreEvent{Type: EventSkipped, Record: copyRecord(r), Previous: previous})
+}
+
+// BulkUpsert upserts a batch of records in one statement using UNNEST arrays
+func (s *PostgresStoreV2) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	records = dedupeRecords(records)
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	ips := make([]string, len(records))
+	ports := make([]int64, len(records))
+	services := make([]string, len(records))
+	timestamps := make([]int64, len(records))
+	responses := make([]string, len(records))
+	tlsVersions := make([]string, len(records))
+	statusCodes := make([]int64, len(records))
+	expiresAts := make([]pgtype.Timestamptz, len(records))
+	hashes := make([]string, len(records))
+	truncated := make([]bool, len(records))
+	messageIDs := make([]pgtype.Text, len(records))
+	subscriptionIDs := make([]pgtype.Text, len(records))
+	publishTimes := make([]pgtype.Timestamp, len(records))
+	for i, r := range records {
+		ips[i] = r.IP.String()
+		ports[i] = int64(r.Port)
+		services[i] = r.Service
+		timestamps[i] = r.LastTimestamp
+		responses[i] = r.Response
+		tlsVersions[i] = r.TLSVersion
+		statusCodes[i] = int64(r.StatusCode)
+		hashes[i] = r.ResponseHash
+		truncated[i] = r.ResponseTruncated
+		if r.ExpiresAt != nil {
+			expiresAts[i] = pgtype.Timestamptz{Time: *r.ExpiresAt, Valid: true}
+		}
+		messageID, subscriptionID, publishTime := messageColumns(r)
+		messageIDs[i] = pgtype.Text(messageID)
+		subscriptionIDs[i] = pgtype.Text(subscriptionID)
+		if publishTime != nil {
+			publishTimes[i] = pgtype.Timestamp{Time: *publishTime, Valid: true}
+		}
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, pgBulkUpsertSQL, ips, ports, services, timestamps, responses,
+		tlsVersions, statusCodes, expiresAts, hashes, truncated, messageIDs, subscriptionIDs, publishTimes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bulk upsert records: %w", err)
+	}
+
+	written := make(map[string]bool, len(records))
+	for rows.Next() {
+		var ip, service string
+		var port uint32
+		if err := rows.Scan(&ip, &port, &service); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan upserted key: %w", err)
+		}
+		written[makeKey(ip, port, service)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to bulk upsert records: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if s.hub.active() {
+		for _, r := range records {
+			if !written[makeKey(r.IP.String(), r.Port, r.Service)] {
+				s.publishSkipped(ctx, r)
+			}
+		}
+	}
+
+	return len(written), nil
+}
+
+// Get retrieves a record by its composite key
+func (s *PostgresStoreV2) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	r, err := scanServiceRecord(s.pool.QueryRow(ctx, pgxGetStmt, ip, port, service))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get record: %w", err)
+	}
+
+	return r, nil
+}
+
+// GetMulti retrieves several records in a single query
+func (s *PostgresStoreV2) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	ips, ports, services := splitKeys(keys)
+	records, err := s.queryRecords(ctx, pgGetMultiSQL, ips, ports, services)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[RecordKey]*ServiceRecord, len(records))
+	for _, r := range records {
+		found[r.Key()] = r
+	}
+	return found, nil
+}
+
+// List returns all records with optional pagination
+func (s *PostgresStoreV2) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	query, args := pgListQuery(limit, offset)
+	return s.queryRecords(ctx, query, args...)
+}
+
+// ListV2 returns a page of records and the number of live records, read
+// from one snapshot
+func (s *PostgresStoreV2) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result := &ListResult{}
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM service_records WHERE deleted_at IS NULL`).Scan(&result.TotalCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count records: %w", err)
+	}
+	query, args := pgListQuery(limit, offset)
+	if result.Records, err = queryPgxRecords(ctx, tx, query, args...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
+
+// ListByIP returns all records for the given IP address
+func (s *PostgresStoreV2) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	return s.queryRecords(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = $1 AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`, ip)
+}
+
+// ListByService returns records for the given service with optional pagination
+func (s *PostgresStoreV2) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE service = $1 AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`, limit, offset, service)
+}
+
+// ListByPort returns records for the given port with optional pagination
+func (s *PostgresStoreV2) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE port = $1 AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`, limit, offset, port)
+}
+
+// ListByTimestampRange returns records within the given timestamp window
+func (s *PostgresStoreV2) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	if from != 0 {
+		conditions = append(conditions, fmt.Sprintf("last_timestamp >= $%d", len(args)+1))
+		args = append(args, from)
+	}
+	if to != 0 {
+		conditions = append(conditions, fmt.Sprintf("last_timestamp <= $%d", len(args)+1))
+		args = append(args, to)
+	}
+
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+	`
+	query += ` WHERE ` + strings.Join(conditions, " AND ")
+	query += ` ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"`
+
+	return s.queryPage(ctx, query, limit, offset, args...)
+}
+
+// ListByCIDR returns records whose IP falls within the given CIDR range
+// Candidates are narrowed with a LIKE prefix as in PostgresStore
+func (s *PostgresStoreV2) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`
+	var args []interface{}
+	if prefix := ipv4LikePrefix(network); prefix != "" {
+		query += ` AND ip LIKE $1`
+		args = append(args, prefix)
+	}
+	query += ` ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"`
+
+	candidates, err := s.queryRecords(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*ServiceRecord, 0, len(candidates))
+	for _, r := range candidates {
+		if cidrContains(network, r.IP.String()) {
+			matched = append(matched, r)
+		}
+	}
+
+	return paginate(matched, limit, offset), nil
+}
+
+// queryPage appends LIMIT/OFFSET to query when limit > 0 and runs it
+func (s *PostgresStoreV2) queryPage(ctx context.Context, query string, limit, offset int, args ...interface{}) ([]*ServiceRecord, error) {
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+		args = append(args, limit, offset)
+	}
+	return s.queryRecords(ctx, query, args...)
+}
+
+// queryRecords runs a query returning service_records rows and scans them
+func (s *PostgresStoreV2) queryRecords(ctx context.Context, query string, args ...interface{}) ([]*ServiceRecord, error) {
+	return queryPgxRecords(ctx, s.pool, query, args...)
+}
+
+// pgxQuerier is satisfied by both *pgxpool.Pool and pgx.Tx
+type pgxQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// queryPgxRecords runs a query returning service_records rows on q and
+// scans them
+func queryPgxRecords(ctx context.Context, q pgxQuerier, query string, args ...interface{}) ([]*ServiceRecord, error) {
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*ServiceRecord
+	for rows.Next() {
+		r, err := scanServiceRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan record: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating records: %w", err)
+	}
+
+	return records, nil
+}
+
+// SearchByResponse returns records whose response contains query, ignoring case
+func (s *PostgresStoreV2) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE response ILIKE '%' || $1 || '%' ESCAPE '\' AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip COLLATE "C", port, service COLLATE "C"
+	`, limit, offset, escapeLike(query))
+}
+
+// ListAfter returns records after the given cursor using keyset pagination
+func (s *PostgresStoreV2) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`
+	var args []interface{}
+	// COLLATE "C" compares bytes like compareListOrder, whatever the database locale
+	if !after.isStart() {
+		query += ` AND (last_timestamp < $1 OR (last_timestamp = $1 AND (ip COLLATE "C", port, service COLLATE "C") > ($2, $3, $4)))`
+		args = append(args, after.Timestamp, after.IP, after.Port, after.Service)
+	}
+	query += ` ORDER BY last_timestamp DESC, ip COLLATE "C" ASC, port ASC, service COLLATE "C" ASC`
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT $%d`, len(args)+1)
+		args = append(args, limit)
+	}
+
+	return s.queryRecords(ctx, query, args...)
+}
+
+// ListDistinctIPs returns the unique IPs of live records with optional
+// pagination
+// The C collation sorts by byte value like the other stores
+func (s *PostgresStoreV2) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	query := `
+		SELECT DISTINCT ip FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY ip COLLATE "C"
+	`
+	var args []interface{}
+	if limit > 0 {
+		query += ` LIMIT $1 OFFSET $2`
+		args = append(args, limit, offset)
+	}
+	return pgxColumn[string](ctx, s.pool, query, args...)
+}
+
+// CountDistinctIPs returns the number of unique IPs of live records
+func (s *PostgresStoreV2) CountDistinctIPs(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(DISTINCT ip) FROM service_records WHERE deleted_at IS NULL`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count ips: %w", err)
+	}
+	return count, nil
+}
+
+// ListDistinctServices returns the unique services of live records
+func (s *PostgresStoreV2) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return pgxColumn[string](ctx, s.pool, `
+		SELECT DISTINCT service FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY service COLLATE "C"
+	`)
+}
+
+// ListDistinctPorts returns the unique ports of live records
+func (s *PostgresStoreV2) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return pgxColumn[uint32](ctx, s.pool, `
+		SELECT DISTINCT port FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY port
+	`)
+}
+
+// pgxColumn runs a query returning a single column and scans it
+func pgxColumn[T any](ctx context.Context, pool *pgxpool.Pool, query string, args ...interface{}) ([]T, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query values: %w", err)
+	}
+	values, err := pgx.CollectRows(rows, pgx.RowTo[T])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan value: %w", err)
+	}
+	return values, nil
+}
+
+// FindCoOccurrence returns the IPs with a live record matching every filter
+func (s *PostgresStoreV2) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	if len(services) == 0 {
+		return []*IPSummary{}, nil
+	}
+
+	query, args := coOccurrenceQuery(services, func(n int) string { return fmt.Sprintf("$%d", n) })
+	records, err := s.queryRecords(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return groupByIP(records), nil
+}
+
+// ListChangedSince returns records updated after timestamp with a new
+// response hash
+func (s *PostgresStoreV2) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.queryRecords(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE last_timestamp > $1 AND deleted_at IS NULL
+			AND previous_response_hash <> '' AND response_hash <> previous_response_hash
+		ORDER BY last_timestamp DESC, ip ASC
+	`, timestamp)
+}
+
+// Delete soft-deletes a record by setting deleted_at
+func (s *PostgresStoreV2) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	ip = CanonicalIP(ip)
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE service_records SET deleted_at = CURRENT_TIMESTAMP
+		WHERE ip = $1 AND port = $2 AND service = $3 AND deleted_at IS NULL
+	`, ip, port, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete record: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Undelete clears deleted_at on a soft-deleted record
+func (s *PostgresStoreV2) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	ip = CanonicalIP(ip)
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE service_records SET deleted_at = NULL
+		WHERE ip = $1 AND port = $2 AND service = $3 AND deleted_at IS NOT NULL
+	`, ip, port, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to undelete record: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListDeleted returns soft-deleted records, most recently deleted first
+func (s *PostgresStoreV2) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, last_timestamp DESC
+	`, limit, offset)
+}
+
+// DeleteOlderThan removes records with a timestamp before beforeTimestamp
+// Soft-deleted records are removed as well
+func (s *PostgresStoreV2) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM service_records WHERE last_timestamp < $1`, beforeTimestamp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old records: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// PurgeExpired removes records whose expires_at is in the past
+func (s *PostgresStoreV2) PurgeExpired(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM service_records
+		WHERE expires_at IS NOT NULL AND expires_at < $1
+	`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired records: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Count returns the total number of records
+func (s *PostgresStoreV2) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM service_records WHERE deleted_at IS NULL`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count records: %w", err)
+	}
+	return count, nil
+}
+
+// Stats returns aggregate counts using GROUP BY queries
+func (s *PostgresStoreV2) Stats(ctx context.Context) (*StoreStats, error) {
+	stats := &StoreStats{
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
+	}
+
+	err := s.pool.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(MIN(last_timestamp), 0), COALESCE(MAX(last_timestamp), 0)
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`).Scan(&stats.TotalRecords, &stats.OldestTimestamp, &stats.NewestTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query totals: %w", err)
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT service, COUNT(*) FROM service_records
+		WHERE deleted_at IS NULL
+		GROUP BY service
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service counts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var service string
+		var count int64
+		if err := rows.Scan(&service, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan service count: %w", err)
+		}
+		stats.RecordsByService[service] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating service counts: %w", err)
+	}
+
+	portRows, err := s.pool.Query(ctx, `
+		SELECT port, COUNT(*) FROM service_records
+		WHERE deleted_at IS NULL
+		GROUP BY port
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query port counts: %w", err)
+	}
+	defer portRows.Close()
+	for portRows.Next() {
+		var port uint32
+		var count int64
+		if err := portRows.Scan(&port, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan port count: %w", err)
+		}
+		stats.RecordsByPort[port] = count
+	}
+	if err := portRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating port counts: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Watch returns a channel of record change events
+// Created and updated events come from LISTEN/NOTIFY, so they include writes
+// made by other store instances sharing the database
+func (s *PostgresStoreV2) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	if err := s.startListener(); err != nil {
+		return nil, err
+	}
+	return s.hub.watch(ctx)
+}
+
+// Unwatch stops delivery to a channel returned by Watch and closes it
+func (s *PostgresStoreV2) Unwatch(ch <-chan StoreEvent) {
+	s.hub.unwatch(ch)
+}
+
+// startListener starts the LISTEN connection if not already running
+func (s *PostgresStoreV2) startListener() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listenCancel != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := s.listenConn(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	s.listenCancel = cancel
+	s.listenDone = make(chan struct{})
+
+	go s.forwardNotifications(ctx, conn)
+	return nil
+}
+
+// listenConn takes a connection out of the pool and subscribes it to change
+// notifications
+// The connection is owned by the listener, so it never returns to the pool
+// with a subscription still active
+func (s *PostgresStoreV2) listenConn(ctx context.Context) (*pgx.Conn, error) {
+	pooled, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	conn := pooled.Hijack()
+	if _, err := conn.Exec(ctx, `LISTEN `+pgNotifyChannel); err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("failed to listen for changes: %w", err)
+	}
+	return conn, nil
+}
+
+// forwardNotifications publishes trigger notifications until ctx is done,
+// reconnecting if the listen connection is lost
+func (s *PostgresStoreV2) forwardNotifications(ctx context.Context, conn *pgx.Conn) {
+	defer close(s.listenDone)
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			conn.Close(context.Background())
+			// Connection lost - notifications sent while it is down are lost
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(pgxListenRetry):
+				}
+				if conn, err = s.listenConn(ctx); err == nil {
+					break
+				}
+			}
+			continue
+		}
+
+		ev, err := parsePgNotification(ctx, n.Payload, s.Get)
+		if err != nil {
+			// Malformed payload - nothing useful to deliver
+			continue
+		}
+		s.hub.publish(ev)
+	}
+}
+
+// MigrationsApplied returns the schema migration versions recorded in the
+// database, in ascending order
+func (s *PostgresStoreV2) MigrationsApplied(ctx context.Context) ([]int, error) {
+	return migrationsApplied(ctx, s.db)
+}
+
+// HealthCheck pings the database
+func (s *PostgresStoreV2) HealthCheck(ctx context.Context) error {
+	if err := s.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
+// Close stops the change listener, closes watch channels and the pool
+func (s *PostgresStoreV2) Close() error {
+	s.mu.Lock()
+	if s.listenCancel != nil {
+		s.listenCancel()
+		<-s.listenDone
+	}
+	s.mu.Unlock()
+
+	s.hub.close()
+	err := s.db.Close()
+	s.pool.Close()
+	return err
+}
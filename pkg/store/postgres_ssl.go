@@ -0,0 +1,245 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresSSLConfig holds the TLS settings for a PostgresStore connection
+// Files are PEM encoded; settings left empty keep the connection string's
+type PostgresSSLConfig struct {
+	Mode         string // sslmode, such as require or verify-full
+	CertFile     string // client certificate
+	KeyFile      string // private key for CertFile, readable only by its owner
+	RootCertFile string // CA certificates the server's certificate must chain to
+	MinVersion   uint16 // lowest TLS version accepted, tls.VersionTLS12 if zero
+}
+
+// NewPostgresStoreWithSSL creates a PostgreSQL store that connects with the
+// TLS settings in ssl, added to connStr
+// The certificate files are loaded before connecting, so a bad file fails
+// with a clear error rather than during the TLS handshake
+// The store negotiates TLS itself instead of lib/pq, so the handshake
+// follows MinVersion, and the change listener connects the same way
+func NewPostgresStoreWithSSL(connStr string, ssl PostgresSSLConfig) (*PostgresStore, error) {
+	if _, err := ParsePostgresDSN(connStr); err != nil {
+		return nil, err
+	}
+	settings, err := parsePostgresSettings(connStr)
+	if err != nil {
+		return nil, err
+	}
+	ssl = ssl.withDefaults(settings)
+	if !slices.Contains(pgSSLModes, ssl.Mode) {
+		return nil, fmt.Errorf("invalid sslmode %q, must be one of %s", ssl.Mode, strings.Join(pgSSLModes, ", "))
+	}
+	tlsConfig, err := ssl.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// lib/pq must not start TLS again on connections the dialer upgraded
+	dsn, err := addPostgresSettings(connStr, map[string]string{"sslmode": "disable"})
+	if err != nil {
+		return nil, err
+	}
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connector: %w", err)
+	}
+	var dialer pq.Dialer
+	if tlsConfig != nil {
+		dialer = &postgresTLSDialer{config: tlsConfig}
+		connector.Dialer(dialer)
+	}
+	return openPostgresStore(sql.OpenDB(connector), dsn, dialer)
+}
+
+// withDefaults fills the empty fields of c from the connection string's
+// settings, defaulting Mode to require as lib/pq does
+func (c PostgresSSLConfig) withDefaults(settings map[string]string) PostgresSSLConfig {
+	c.Mode = cmp.Or(c.Mode, settings["sslmode"], "require")
+	c.CertFile = cmp.Or(c.CertFile, settings["sslcert"])
+	c.KeyFile = cmp.Or(c.KeyFile, settings["sslkey"])
+	c.RootCertFile = cmp.Or(c.RootCertFile, settings["sslrootcert"])
+	return c
+}
+
+// tlsConfig loads the client key pair and CA certificates and returns the
+// TLS settings for c.Mode, or nil for disable
+// As in libpq, require checks the server certificate against RootCertFile
+// when one is given, and verify-ca checks it without the host name
+func (c PostgresSSLConfig) tlsConfig() (*tls.Config, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be set together")
+	}
+	config := &tls.Config{MinVersion: cmp.Or(c.MinVersion, tls.VersionTLS12)}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		// libpq refuses keys others can read
+		if info, err := os.Stat(c.KeyFile); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+			return nil, fmt.Errorf("client key %s must not be accessible by group or others", c.KeyFile)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.RootCertFile != "" {
+		pem, err := os.ReadFile(c.RootCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root certificate: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.RootCertFile)
+		}
+	}
+
+	switch {
+	case c.Mode == "disable":
+		return nil, nil
+	case c.Mode == "verify-full":
+		// The dialer sets ServerName to the host of each connection
+	case c.Mode == "verify-ca" || c.RootCertFile != "":
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = verifyCertificateChain(config.RootCAs)
+	default:
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
+
+// verifyCertificateChain returns a check that the server certificate chains
+// to roots, or to the system roots if roots is nil, whatever its host name
+func verifyCertificateChain(roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
+
+// pgSSLRequestCode asks the server to switch a new connection to TLS
+const pgSSLRequestCode = 80877103
+
+// postgresTLSDialer opens connections to PostgreSQL and upgrades them to
+// TLS, in place of lib/pq's sslmode handling
+type postgresTLSDialer struct {
+	config *tls.Config
+	dialer net.Dialer
+}
+
+func (d *postgresTLSDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *postgresTLSDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+// DialContext connects to address and upgrades the connection to TLS
+// Unix sockets are left as they are, as libpq never uses TLS on them
+func (d *postgresTLSDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil || network == "unix" {
+		return conn, err
+	}
+
+	tlsConn, err := d.upgrade(ctx, conn, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// upgrade sends an SSLRequest on conn and runs the TLS handshake, naming
+// the host in address as the server
+func (d *postgresTLSDialer) upgrade(ctx context.Context, conn net.Conn, address string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	// SSLRequest is a length of 8 and the request code
+	var req [8]byte
+	binary.BigEndian.PutUint32(req[:4], 8)
+	binary.BigEndian.PutUint32(req[4:], pgSSLRequestCode)
+	if _, err := conn.Write(req[:]); err != nil {
+		return nil, fmt.Errorf("failed to send SSLRequest: %w", err)
+	}
+	var resp [1]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return nil, fmt.Errorf("failed to read SSLRequest response: %w", err)
+	}
+	if resp[0] != 'S' {
+		return nil, errors.New("SSL is not enabled on the server")
+	}
+
+	config := d.config.Clone()
+	config.ServerName, _, _ = net.SplitHostPort(address)
+	client := tls.Client(conn, config)
+	if err := client.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return client, nil
+}
+
+// addPostgresSettings returns connStr with settings added, replacing any it
+// already has
+// URLs get them as query parameters and key=value strings as quoted pairs,
+// which the driver reads after the earlier ones
+func addPostgresSettings(connStr string, settings map[string]string) (string, error) {
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			// url.Error repeats the URL, which may hold the password
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return "", fmt.Errorf("invalid postgres connection string: failed to parse URL: %w", err)
+		}
+		q := u.Query()
+		for key, value := range settings {
+			q.Set(key, value)
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	var b strings.Builder
+	b.WriteString(connStr)
+	for key, value := range settings {
+		fmt.Fprintf(&b, " %s='%s'", key, quote.Replace(value))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,943 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisKeyPrefix prefixes the hash key holding each record
+	redisKeyPrefix = "service_record:"
+
+	// redisIndexKey is a sorted set of record keys scored by last_timestamp
+	redisIndexKey = "service_records:by_timestamp"
+
+	// redisExpiryKey is a sorted set of expiring record keys scored by
+	// expires_at in Unix milliseconds
+	redisExpiryKey = "service_records:by_expiry"
+
+	// redisDeletedKey is a sorted set of soft-deleted record keys scored by
+	// deleted_at in Unix milliseconds
+	// Soft-deleted records are removed from redisIndexKey, so every read
+	// driven by that index skips them
+	redisDeletedKey = "service_records:deleted"
+)
+
+// redisEventsChannel is the pub/sub channel carrying record change events
+const redisEventsChannel = "service_records:events"
+
+// redisUpsertScript atomically writes the record hash only if the incoming
+// timestamp is newer than the stored one, keeps the index in sync and
+// publishes the change with the previous hash fields
+// KEYS[1] = record key, KEYS[2] = index key, KEYS[3] = expiry index key
+// ARGV = ip, port, service, last_timestamp, response, updated_at,
+// tls_version, status_code, expires_at, expires_at score, channel,
+// response_hash, response_truncated, pubsub_message_id,
+// pubsub_subscription_id, pubsub_publish_time
+// first_seen_at is taken from updated_at on insert and kept afterwards,
+// scan_count is incremented on every accepted write and the previous_*
+// fields keep the response and hash being replaced
+// An empty expires_at removes the record from the expiry index, and
+// soft-deleted records are updated without being re-added to the index
+// An optional ARGV[17] replaces the timestamp guard for UpdateIfUnchanged:
+// only a live record whose last_timestamp equals it is written
+// Returns 0 when skipped, 1 when created, 2 when updated
+var redisUpsertScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'last_timestamp')
+if ARGV[17] then
+	if not current or tonumber(current) ~= tonumber(ARGV[17]) or redis.call('HEXISTS', KEYS[1], 'deleted_at') == 1 then
+		return 0
+	end
+elseif current and tonumber(current) >= tonumber(ARGV[4]) then
+	return 0
+end
+local event = {op = 'created'}
+local previous, previousHash = '', ''
+if current then
+	event.op = 'updated'
+	event.old = {}
+	local fields = redis.call('HGETALL', KEYS[1])
+	for i = 1, #fields, 2 do
+		event.old[fields[i]] = fields[i + 1]
+	end
+	previous = event.old.response or ''
+	previousHash = event.old.response_hash or ''
+	-- Hashes written before scan counting count as seen once
+	if not event.old.scan_count then
+		redis.call('HSET', KEYS[1], 'scan_count', 1)
+	end
+end
+redis.call('HSET', KEYS[1],
+	'ip', ARGV[1],
+	'port', ARGV[2],
+	'service', ARGV[3],
+	'last_timestamp', ARGV[4],
+	'response', ARGV[5],
+	'updated_at', ARGV[6],
+	'tls_version', ARGV[7],
+	'status_code', ARGV[8],
+	'expires_at', ARGV[9],
+	'previous_response', previous,
+	'response_hash', ARGV[12],
+	'response_truncated', ARGV[13],
+	'previous_response_hash', previousHash,
+	'pubsub_message_id', ARGV[14],
+	'pubsub_subscription_id', ARGV[15],
+	'pubsub_publish_time', ARGV[16])
+redis.call('HSETNX', KEYS[1], 'first_seen_at', ARGV[6])
+local scanCount = redis.call('HINCRBY', KEYS[1], 'scan_count', 1)
+if redis.call('HEXISTS', KEYS[1], 'deleted_at') == 0 then
+	redis.call('ZADD', KEYS[2], ARGV[4], KEYS[1])
+end
+if ARGV[9] == '' then
+	redis.call('ZREM', KEYS[3], KEYS[1])
+else
+	redis.call('ZADD', KEYS[3], ARGV[10], KEYS[1])
+end
+event.new = {
+	ip = ARGV[1],
+	port = ARGV[2],
+	service = ARGV[3],
+	last_timestamp = ARGV[4],
+	response = ARGV[5],
+	updated_at = ARGV[6],
+	first_seen_at = redis.call('HGET', KEYS[1], 'first_seen_at'),
+	scan_count = tostring(scanCount),
+	previous_response = previous,
+	response_hash = ARGV[12],
+	response_truncated = ARGV[13],
+	previous_response_hash = previousHash,
+	tls_version = ARGV[7],
+	status_code = ARGV[8],
+	expires_at = ARGV[9],
+	pubsub_message_id = ARGV[14],
+	pubsub_subscription_id = ARGV[15],
+	pubsub_publish_time = ARGV[16]
+}
+redis.call('PUBLISH', ARGV[11], cjson.encode(event))
+if current then
+	return 2
+end
+return 1
+`)
+
+// redisRemoveBelowScript atomically removes every record scored below
+// ARGV[1] in the sorted set KEYS[1], deleting its hash and its entries in
+// every index
+// KEYS[1] = sorted set to scan, KEYS[2..n] = index keys
+// Returns the number of records removed
+var redisRemoveBelowScript = redis.NewScript(`
+local keys = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+for _, key in ipairs(keys) do
+	redis.call('DEL', key)
+	for i = 2, #KEYS do
+		redis.call('ZREM', KEYS[i], key)
+	end
+end
+return #keys
+`)
+
+// redisDeleteOlderScript atomically removes every record, live or
+// soft-deleted, with last_timestamp below ARGV[1]
+// Live records are found through the timestamp index; soft-deleted records
+// are not indexed by timestamp, so each one is checked
+// KEYS[1] = index key, KEYS[2] = expiry index key, KEYS[3] = deleted index key
+// Returns the number of records removed
+var redisDeleteOlderScript = redis.NewScript(`
+local before = tonumber(ARGV[1])
+local keys = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+for _, key in ipairs(redis.call('ZRANGE', KEYS[3], 0, -1)) do
+	local ts = tonumber(redis.call('HGET', key, 'last_timestamp'))
+	if ts and ts < before then
+		table.insert(keys, key)
+	end
+end
+for _, key in ipairs(keys) do
+	redis.call('DEL', key)
+	redis.call('ZREM', KEYS[1], key)
+	redis.call('ZREM', KEYS[2], key)
+	redis.call('ZREM', KEYS[3], key)
+end
+return #keys
+`)
+
+// redisSoftDeleteScript sets deleted_at on a live record and moves it from
+// the timestamp index to the deleted index
+// KEYS[1] = record key, KEYS[2] = index key, KEYS[3] = deleted index key
+// ARGV[1] = deleted_at, ARGV[2] = deleted_at score
+// Returns 1 when deleted, 0 when missing or already deleted
+var redisSoftDeleteScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 or redis.call('HEXISTS', KEYS[1], 'deleted_at') == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'deleted_at', ARGV[1])
+redis.call('ZREM', KEYS[2], KEYS[1])
+redis.call('ZADD', KEYS[3], ARGV[2], KEYS[1])
+return 1
+`)
+
+// redisUndeleteScript clears deleted_at and moves the record back to the
+// timestamp index
+// KEYS[1] = record key, KEYS[2] = index key, KEYS[3] = deleted index key
+// Returns 1 when restored, 0 when missing or not deleted
+var redisUndeleteScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], 'deleted_at') == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[1], 'deleted_at')
+redis.call('ZREM', KEYS[3], KEYS[1])
+redis.call('ZADD', KEYS[2], redis.call('HGET', KEYS[1], 'last_timestamp'), KEYS[1])
+return 1
+`)
+
+// RedisStore implements Store interface using Redis hashes
+// Useful for sharing state between processor instances
+type RedisStore struct {
+	client *redis.Client
+	hub    watchHub
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub // subscribed on first Watch
+}
+
+// NewRedisStore creates a new Redis store
+func NewRedisStore(addr, password string, db int) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	// Test connection
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	return &RedisStore{client: client}, nil
+}
+
+// newRedisStoreFromURL creates a Redis store from a redis:// connection URL
+func newRedisStoreFromURL(url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
+	}
+	return NewRedisStore(opts.Addr, opts.Password, opts.DB)
+}
+
+// redisKey creates the hash key for a record
+func redisKey(ip string, port uint32, service string) string {
+	return redisKeyPrefix + makeKey(ip, port, service)
+}
+
+// upsertKeys returns the script keys for a record
+func upsertKeys(r *ServiceRecord) []string {
+	return []string{redisKey(r.IP.String(), r.Port, r.Service), redisIndexKey, redisExpiryKey}
+}
+
+// upsertArgs returns the script arguments for a record
+func upsertArgs(r *ServiceRecord) []interface{} {
+	var expiresAt string
+	var expiresScore int64
+	if r.ExpiresAt != nil {
+		expiresAt = r.ExpiresAt.UTC().Format(time.RFC3339Nano)
+		expiresScore = r.ExpiresAt.UnixMilli()
+	}
+	// Empty message fields mean the record has no message metadata
+	var messageID, subscriptionID, publishTime string
+	if m := r.MessageMetadata; m != nil {
+		messageID, subscriptionID = m.MessageID, m.SubscriptionID
+		publishTime = m.PublishTime.UTC().Format(time.RFC3339Nano)
+	}
+	return []interface{}{
+		r.IP.String(), r.Port, r.Service, r.LastTimestamp, r.Response,
+		time.Now().UTC().Format(time.RFC3339Nano),
+		r.TLSVersion, r.StatusCode,
+		expiresAt, expiresScore,
+		redisEventsChannel,
+		r.ResponseHash,
+		strconv.FormatBool(r.ResponseTruncated),
+		messageID, subscriptionID, publishTime,
+	}
+}
+
+// Upsert inserts or updates a record if the timestamp is newer
+func (s *RedisStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	r = canonicalRecord(r)
+	updated, err := redisUpsertScript.Run(ctx, s.client, upsertKeys(r), upsertArgs(r)...).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to upsert record: %w", err)
+	}
+	if updated == 0 && s.hub.active() {
+		s.publishSkipped(ctx, r)
+	}
+	return updated > 0, nil
+}
+
+// UpdateIfUnchanged replaces a record if its LastTimestamp equals
+// expectedTimestamp, using the upsert script's expected timestamp argument
+func (s *RedisStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	r = canonicalRecord(r)
+	args := append(upsertArgs(r), expectedTimestamp)
+	updated, err := redisUpsertScript.Run(ctx, s.client, upsertKeys(r), args...).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to update record: %w", err)
+	}
+	return updated > 0, nil
+}
+
+// BulkUpsert upserts a batch of records in a single pipeline
+func (s *RedisStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	records = dedupeRecords(records)
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	// EVALSHA cannot fall back to EVAL inside a pipeline, so load the script first
+	if err := redisUpsertScript.Load(ctx, s.client).Err(); err != nil {
+		return 0, fmt.Errorf("failed to load upsert script: %w", err)
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(records))
+	for i, r := range records {
+		cmds[i] = redisUpsertScript.EvalSha(ctx, pipe, upsertKeys(r), upsertArgs(r)...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to bulk upsert records: %w", err)
+	}
+
+	updated := 0
+	watching := s.hub.active()
+	for i, cmd := range cmds {
+		if n, _ := cmd.Int(); n > 0 {
+			updated++
+		} else if watching {
+			s.publishSkipped(ctx, records[i])
+		}
+	}
+	return updated, nil
+}
+
+// Get retrieves a record by its composite key
+func (s *RedisStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	fields, err := s.client.HGetAll(ctx, redisKey(ip, port, service)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get record: %w", err)
+	}
+	if len(fields) == 0 || fields["deleted_at"] != "" {
+		return nil, nil
+	}
+	return parseRedisRecord(fields)
+}
+
+// GetMulti retrieves several records in a single pipeline
+func (s *RedisStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	redisKeys := make([]string, len(keys))
+	for i, k := range keys {
+		redisKeys[i] = redisKey(k.IP, k.Port, k.Service)
+	}
+	records, err := s.loadRecords(ctx, redisKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[RecordKey]*ServiceRecord, len(records))
+	for _, r := range records {
+		if r.DeletedAt == nil {
+			found[r.Key()] = r
+		}
+	}
+	return found, nil
+}
+
+// List returns all records with optional pagination
+func (s *RedisStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(offset + limit - 1)
+	}
+
+	page, err := s.client.ZRevRangeWithScores(ctx, redisIndexKey, int64(offset), stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+	return s.orderPage(ctx, page, "+inf", limit, offset)
+}
+
+// ListV2 returns a page of records and the number of live records
+// The page keys and the count are read from the index in one MULTI
+// transaction; records deleted before their hashes are loaded are left out
+// of the page but still counted
+func (s *RedisStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(offset + limit - 1)
+	}
+
+	var count *redis.IntCmd
+	var page *redis.ZSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.ZCard(ctx, redisIndexKey)
+		page = pipe.ZRevRangeWithScores(ctx, redisIndexKey, int64(offset), stop)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+
+	records, err := s.orderPage(ctx, page.Val(), "+inf", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &ListResult{Records: records, TotalCount: count.Val()}, nil
+}
+
+// ListByIP returns all records for the given IP address
+func (s *RedisStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	return s.listWhere(ctx, func(r *ServiceRecord) bool { return r.IP.String() == ip }, 0, 0)
+}
+
+// ListByService returns records for the given service with optional pagination
+func (s *RedisStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(ctx, func(r *ServiceRecord) bool { return r.Service == service }, limit, offset)
+}
+
+// ListByPort returns records for the given port with optional pagination
+func (s *RedisStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.listWhere(ctx, func(r *ServiceRecord) bool { return r.Port == port }, limit, offset)
+}
+
+// ListByTimestampRange returns records within the given timestamp window
+func (s *RedisStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	min, max := "-inf", "+inf"
+	if from != 0 {
+		min = strconv.FormatInt(from, 10)
+	}
+	if to != 0 {
+		max = strconv.FormatInt(to, 10)
+	}
+
+	// Count -1 means "no limit" once an offset is given
+	count := int64(limit)
+	if limit <= 0 {
+		count = -1
+	}
+
+	page, err := s.client.ZRevRangeByScoreWithScores(ctx, redisIndexKey, &redis.ZRangeBy{
+		Min:    min,
+		Max:    max,
+		Offset: int64(offset),
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+	return s.orderPage(ctx, page, max, limit, offset)
+}
+
+// ListByCIDR returns records whose IP falls within the given CIDR range
+func (s *RedisStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return s.listWhere(ctx, func(r *ServiceRecord) bool {
+		return cidrContains(network, r.IP.String())
+	}, limit, offset)
+}
+
+// SearchByResponse returns records whose response contains query, ignoring case
+// Redis has no secondary index on response, so this scans every record
+func (s *RedisStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	query = strings.ToLower(query)
+	return s.listWhere(ctx, func(r *ServiceRecord) bool {
+		return strings.Contains(strings.ToLower(r.Response), query)
+	}, limit, offset)
+}
+
+// ListAfter returns records after the given cursor using keyset pagination
+func (s *RedisStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	records, err := s.listWhere(ctx, after.precedes, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(records, compareListOrder)
+
+	return paginate(records, limit, 0), nil
+}
+
+// ListDistinctIPs returns the unique IPs of live records with optional
+// pagination
+func (s *RedisStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	ips, err := redisDistinct(ctx, s, recordIP)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(ips, limit, offset), nil
+}
+
+// CountDistinctIPs returns the number of unique IPs of live records
+func (s *RedisStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	ips, err := redisDistinct(ctx, s, recordIP)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ips)), nil
+}
+
+// ListDistinctServices returns the unique services of live records
+func (s *RedisStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return redisDistinct(ctx, s, recordService)
+}
+
+// ListDistinctPorts returns the unique ports of live records
+func (s *RedisStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return redisDistinct(ctx, s, recordPort)
+}
+
+// redisDistinct loads every live record and returns the sorted unique
+// values of key
+func redisDistinct[T cmp.Ordered](ctx context.Context, s *RedisStore, key func(*ServiceRecord) T) ([]T, error) {
+	records, err := s.List(ctx, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return distinct(records, key), nil
+}
+
+// FindCoOccurrence returns the IPs with a live record matching every filter
+func (s *RedisStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	records, err := s.List(ctx, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return coOccurring(records, services), nil
+}
+
+// ListChangedSince returns records updated after timestamp with a new
+// response hash
+// The index bounds the scan to newer records; hashes are compared in Go
+func (s *RedisStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	keys, err := s.client.ZRevRangeByScore(ctx, redisIndexKey, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(timestamp, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed records: %w", err)
+	}
+	records, err := s.loadRecords(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make([]*ServiceRecord, 0)
+	for _, r := range records {
+		if hashChanged(r) {
+			changed = append(changed, r)
+		}
+	}
+
+	// Sort by timestamp descending, then IP ascending
+	sort.SliceStable(changed, func(i, j int) bool {
+		if changed[i].LastTimestamp != changed[j].LastTimestamp {
+			return changed[i].LastTimestamp > changed[j].LastTimestamp
+		}
+		return changed[i].IP < changed[j].IP
+	})
+
+	return changed, nil
+}
+
+// Delete soft-deletes a record by setting deleted_at
+func (s *RedisStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	now := time.Now()
+	keys := []string{redisKey(ip, port, service), redisIndexKey, redisDeletedKey}
+	deleted, err := redisSoftDeleteScript.Run(ctx, s.client, keys,
+		now.UTC().Format(time.RFC3339Nano), now.UnixMilli()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete record: %w", err)
+	}
+	return deleted > 0, nil
+}
+
+// Undelete clears deleted_at on a soft-deleted record
+func (s *RedisStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	keys := []string{redisKey(ip, port, service), redisIndexKey, redisDeletedKey}
+	restored, err := redisUndeleteScript.Run(ctx, s.client, keys).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to undelete record: %w", err)
+	}
+	return restored > 0, nil
+}
+
+// ListDeleted returns soft-deleted records, most recently deleted first
+func (s *RedisStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(offset + limit - 1)
+	}
+
+	keys, err := s.client.ZRevRange(ctx, redisDeletedKey, int64(offset), stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted records: %w", err)
+	}
+	return s.loadRecords(ctx, keys)
+}
+
+// PurgeExpired removes records whose expires_at is in the past
+// Expiring records are tracked in a sorted set so only they are scanned
+func (s *RedisStore) PurgeExpired(ctx context.Context) (int64, error) {
+	keys := []string{redisExpiryKey, redisIndexKey, redisExpiryKey, redisDeletedKey}
+	purged, err := redisRemoveBelowScript.Run(ctx, s.client, keys, time.Now().UnixMilli()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired records: %w", err)
+	}
+	return purged, nil
+}
+
+// DeleteOlderThan removes records with a timestamp before beforeTimestamp
+// Soft-deleted records are removed as well
+func (s *RedisStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	keys := []string{redisIndexKey, redisExpiryKey, redisDeletedKey}
+	deleted, err := redisDeleteOlderScript.Run(ctx, s.client, keys, beforeTimestamp).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old records: %w", err)
+	}
+	return deleted, nil
+}
+
+// Count returns the total number of records
+func (s *RedisStore) Count(ctx context.Context) (int64, error) {
+	count, err := s.client.ZCard(ctx, redisIndexKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count records: %w", err)
+	}
+	return count, nil
+}
+
+// Stats computes aggregate counts over all records
+func (s *RedisStore) Stats(ctx context.Context) (*StoreStats, error) {
+	records, err := s.List(ctx, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &StoreStats{
+		TotalRecords:     int64(len(records)),
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
+	}
+	for i, r := range records {
+		stats.RecordsByService[r.Service]++
+		stats.RecordsByPort[r.Port]++
+		if i == 0 || r.LastTimestamp < stats.OldestTimestamp {
+			stats.OldestTimestamp = r.LastTimestamp
+		}
+		if i == 0 || r.LastTimestamp > stats.NewestTimestamp {
+			stats.NewestTimestamp = r.LastTimestamp
+		}
+	}
+
+	return stats, nil
+}
+
+// publishSkipped reports an upsert ignored by the timestamp guard
+// The script only publishes writes, so skipped events are local to this instance
+func (s *RedisStore) publishSkipped(ctx context.Context, r *ServiceRecord) {
+	previous, err := s.Get(ctx, r.IP.String(), r.Port, r.Service)
+	if err != nil {
+		previous = nil
+	}
+	s.hub.publish(StoreEvent{Type: EventSkipped, Record: copyRecord(r), Previous: previous})
+}
+
+// Watch returns a channel of record change events
+// Created and updated events come from Redis pub/sub, so they include writes
+// made by other store instances sharing the server
+func (s *RedisStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	if err := s.subscribe(ctx); err != nil {
+		return nil, err
+	}
+	return s.hub.watch(ctx)
+}
+
+// Unwatch stops delivery to a channel returned by Watch and closes it
+func (s *RedisStore) Unwatch(ch <-chan StoreEvent) {
+	s.hub.unwatch(ch)
+}
+
+// subscribe opens the events subscription if not already running
+func (s *RedisStore) subscribe(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pubsub != nil {
+		return nil
+	}
+
+	pubsub := s.client.Subscribe(ctx, redisEventsChannel)
+	// Wait for the subscription to be confirmed so no events are missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to changes: %w", err)
+	}
+	s.pubsub = pubsub
+
+	go s.forwardMessages(pubsub)
+	return nil
+}
+
+// forwardMessages publishes change messages until the subscription is closed
+func (s *RedisStore) forwardMessages(pubsub *redis.PubSub) {
+	for msg := range pubsub.Channel() {
+		ev, err := parseRedisEvent(msg.Payload)
+		if err != nil {
+			// Malformed payload - nothing useful to deliver
+			continue
+		}
+		s.hub.publish(ev)
+	}
+}
+
+// parseRedisEvent decodes a change message published by redisUpsertScript
+func parseRedisEvent(payload string) (StoreEvent, error) {
+	var msg struct {
+		Op  string            `json:"op"`
+		New map[string]string `json:"new"`
+		Old map[string]string `json:"old"`
+	}
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		return StoreEvent{}, fmt.Errorf("failed to decode event: %w", err)
+	}
+
+	record, err := parseRedisRecord(msg.New)
+	if err != nil {
+		return StoreEvent{}, err
+	}
+	ev := StoreEvent{Type: EventType(msg.Op), Record: record}
+
+	if len(msg.Old) > 0 {
+		if ev.Previous, err = parseRedisRecord(msg.Old); err != nil {
+			return StoreEvent{}, err
+		}
+	}
+	return ev, nil
+}
+
+// HealthCheck pings the Redis server
+func (s *RedisStore) HealthCheck(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping redis: %w", err)
+	}
+	return nil
+}
+
+// Close closes the change subscription, watch channels and the Redis client
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	if s.pubsub != nil {
+		s.pubsub.Close()
+	}
+	s.mu.Unlock()
+
+	s.hub.close()
+	return s.client.Close()
+}
+
+// listWhere loads every record in timestamp-descending order, filters it in
+// Go and applies pagination
+// Redis has no secondary indexes on hash fields, so filtered queries are O(n)
+func (s *RedisStore) listWhere(ctx context.Context, match func(*ServiceRecord) bool, limit, offset int) ([]*ServiceRecord, error) {
+	all, err := s.List(ctx, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*ServiceRecord, 0, len(all))
+	for _, r := range all {
+		if match(r) {
+			matched = append(matched, r)
+		}
+	}
+
+	return paginate(matched, limit, offset), nil
+}
+
+// orderPage loads a page read from the index by rank in List order
+// ZREVRANGE breaks timestamp ties by key in reverse, so the page is widened
+// to every record sharing a timestamp with either end, sorted with
+// compareListOrder and cut back down; upper is the score bound the page was
+// read under
+func (s *RedisStore) orderPage(ctx context.Context, page []redis.Z, upper string, limit, offset int) ([]*ServiceRecord, error) {
+	if len(page) == 0 {
+		return []*ServiceRecord{}, nil
+	}
+
+	// The whole range needs no widening
+	if offset == 0 && limit <= 0 {
+		keys := make([]string, len(page))
+		for i, z := range page {
+			keys[i] = z.Member.(string)
+		}
+		records, err := s.loadRecords(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(records, compareListOrder)
+		return records, nil
+	}
+
+	newest := strconv.FormatFloat(page[0].Score, 'f', -1, 64)
+	oldest := strconv.FormatFloat(page[len(page)-1].Score, 'f', -1, 64)
+
+	var above *redis.IntCmd
+	var keys *redis.StringSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		above = pipe.ZCount(ctx, redisIndexKey, "("+newest, upper)
+		keys = pipe.ZRevRangeByScore(ctx, redisIndexKey, &redis.ZRangeBy{Min: oldest, Max: newest})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+
+	records, err := s.loadRecords(ctx, keys.Val())
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(records, compareListOrder)
+	return paginate(records, limit, max(offset-int(above.Val()), 0)), nil
+}
+
+// loadRecords fetches the hashes for the given keys in one pipeline,
+// preserving key order
+func (s *RedisStore) loadRecords(ctx context.Context, keys []string) ([]*ServiceRecord, error) {
+	if len(keys) == 0 {
+		return []*ServiceRecord{}, nil
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HGetAll(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to load records: %w", err)
+	}
+
+	records := make([]*ServiceRecord, 0, len(keys))
+	for _, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			// Deleted between reading the index and loading the hash
+			continue
+		}
+		r, err := parseRedisRecord(fields)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// parseRedisRecord converts hash fields into a ServiceRecord
+func parseRedisRecord(fields map[string]string) (*ServiceRecord, error) {
+	port, err := strconv.ParseUint(fields["port"], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse port: %w", err)
+	}
+	timestamp, err := strconv.ParseInt(fields["last_timestamp"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse last_timestamp: %w", err)
+	}
+	updatedAt, err := time.Parse(time.RFC3339Nano, fields["updated_at"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse updated_at: %w", err)
+	}
+	// first_seen_at is absent from hashes written before it was tracked
+	var firstSeenAt time.Time
+	if v := fields["first_seen_at"]; v != "" {
+		if firstSeenAt, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, fmt.Errorf("failed to parse first_seen_at: %w", err)
+		}
+	}
+	// scan_count is absent from hashes written before it was tracked
+	scanCount := int64(1)
+	if v := fields["scan_count"]; v != "" {
+		if scanCount, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse scan_count: %w", err)
+		}
+	}
+	// status_code is absent from hashes written before V3 support
+	var statusCode int
+	if v := fields["status_code"]; v != "" {
+		if statusCode, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("failed to parse status_code: %w", err)
+		}
+	}
+
+	// expires_at is empty for records that never expire
+	var expiresAt *time.Time
+	if v := fields["expires_at"]; v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse expires_at: %w", err)
+		}
+		expiresAt = &t
+	}
+	var deletedAt *time.Time
+	if v := fields["deleted_at"]; v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse deleted_at: %w", err)
+		}
+		deletedAt = &t
+	}
+	// pubsub_message_id is empty for records not stored from a message and
+	// absent from hashes written before it was tracked
+	var metadata *MessageMetadata
+	if v := fields["pubsub_message_id"]; v != "" {
+		publishTime, err := time.Parse(time.RFC3339Nano, fields["pubsub_publish_time"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pubsub_publish_time: %w", err)
+		}
+		metadata = &MessageMetadata{MessageID: v, SubscriptionID: fields["pubsub_subscription_id"], PublishTime: publishTime}
+	}
+
+	return &ServiceRecord{
+		IP:                   IPAddress(fields["ip"]),
+		Port:                 uint32(port),
+		Service:              fields["service"],
+		LastTimestamp:        timestamp,
+		Response:             fields["response"],
+		UpdatedAt:            updatedAt,
+		FirstSeenAt:          firstSeenAt,
+		ScanCount:            scanCount,
+		PreviousResponse:     fields["previous_response"],
+		ResponseHash:         fields["response_hash"],
+		PreviousResponseHash: fields["previous_response_hash"],
+		ResponseTruncated:    fields["response_truncated"] == "true",
+		TLSVersion:           fields["tls_version"],
+		StatusCode:           statusCode,
+		ExpiresAt:            expiresAt,
+		DeletedAt:            deletedAt,
+		MessageMetadata:      metadata,
+	}, nil
+}
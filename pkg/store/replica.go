@@ -0,0 +1,369 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// Defaults for a ReadReplicaStore created by NewReadReplicaStore
+const (
+	defaultReplicaFailureThreshold = 3
+	defaultReplicaCooldown         = 30 * time.Second
+)
+
+// ReadReplicaConfig configures a ReadReplicaStore
+type ReadReplicaConfig struct {
+	// FailureThreshold is how many consecutive errors take a replica out of
+	// rotation, at least 1
+	FailureThreshold int
+
+	// Cooldown is how long a replica stays out of rotation before it is
+	// tried again
+	Cooldown time.Duration
+}
+
+// ReplicaInfo is the health of one replica of a ReadReplicaStore
+type ReplicaInfo struct {
+	Index   int
+	Healthy bool
+	// LastError is the replica's most recent error, kept after it recovers
+	LastError error
+}
+
+// ReadReplicaStore is a Store that sends writes, Watch and HealthCheck to a
+// primary store and spreads reads over replicas in round-robin order
+// A read that fails on a replica is retried on the primary; after
+// FailureThreshold consecutive failures the replica is left out of rotation
+// for Cooldown, and the first failure after that takes it out again
+// Context cancellation and invalid arguments such as ErrInvalidCIDR are
+// returned as they are, without falling back or counting against the replica
+// Replicas may lag the primary, so a read can miss a write that just
+// returned
+type ReadReplicaStore struct {
+	primary   Store
+	replicas  []*replicaState
+	threshold int
+	cooldown  time.Duration
+	clock     clockwork.Clock
+	next      atomic.Int64
+}
+
+// replicaState is a read replica and its health
+type replicaState struct {
+	store Store
+
+	mu        sync.Mutex
+	failures  int
+	lastError error
+	downUntil time.Time
+}
+
+// NewReadReplicaStore creates a store that writes to primary and reads from
+// replicas, taking a replica out of rotation for 30s after 3 consecutive
+// errors
+func NewReadReplicaStore(primary Store, replicas ...Store) Store {
+	return NewReadReplicaStoreWithConfig(ReadReplicaConfig{
+		FailureThreshold: defaultReplicaFailureThreshold,
+		Cooldown:         defaultReplicaCooldown,
+	}, primary, replicas...)
+}
+
+// NewReadReplicaStoreWithConfig creates a read-replica store with custom
+// settings
+func NewReadReplicaStoreWithConfig(cfg ReadReplicaConfig, primary Store, replicas ...Store) Store {
+	return newReadReplicaStore(cfg, clockwork.NewRealClock(), primary, replicas...)
+}
+
+// newReadReplicaStore creates a ReadReplicaStore that reads the time from
+// clock
+func newReadReplicaStore(cfg ReadReplicaConfig, clock clockwork.Clock, primary Store, replicas ...Store) *ReadReplicaStore {
+	s := &ReadReplicaStore{
+		primary:   primary,
+		threshold: max(cfg.FailureThreshold, 1),
+		cooldown:  cfg.Cooldown,
+		clock:     clock,
+	}
+	for _, r := range replicas {
+		s.replicas = append(s.replicas, &replicaState{store: r})
+	}
+	return s
+}
+
+// ReplicaStatus returns the health of every replica, in the order they were
+// given
+func (s *ReadReplicaStore) ReplicaStatus() []ReplicaInfo {
+	now := s.clock.Now()
+	status := make([]ReplicaInfo, len(s.replicas))
+	for i, r := range s.replicas {
+		r.mu.Lock()
+		status[i] = ReplicaInfo{Index: i, Healthy: r.healthy(now), LastError: r.lastError}
+		r.mu.Unlock()
+	}
+	return status
+}
+
+// healthy reports whether the replica is in rotation
+// Caller must hold r.mu
+func (r *replicaState) healthy(now time.Time) bool {
+	return !now.Before(r.downUntil)
+}
+
+// record updates the replica's health with the result of a read
+func (r *replicaState) record(err error, now time.Time, threshold int, cooldown time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		r.failures = 0
+		return
+	}
+	r.failures++
+	r.lastError = err
+	if r.failures >= threshold {
+		r.downUntil = now.Add(cooldown)
+	}
+}
+
+// pick returns the next healthy replica in round-robin order, or nil if
+// there is none
+func (s *ReadReplicaStore) pick() *replicaState {
+	if len(s.replicas) == 0 {
+		return nil
+	}
+	now := s.clock.Now()
+	start := s.next.Add(1) - 1
+	for k := range int64(len(s.replicas)) {
+		r := s.replicas[(start+k)%int64(len(s.replicas))]
+		r.mu.Lock()
+		healthy := r.healthy(now)
+		r.mu.Unlock()
+		if healthy {
+			return r
+		}
+	}
+	return nil
+}
+
+// readReplica runs read on the next healthy replica, falling back to the
+// primary if it fails or no replica is healthy
+func readReplica[T any](ctx context.Context, s *ReadReplicaStore, read func(Store) (T, error)) (T, error) {
+	r := s.pick()
+	if r == nil {
+		return read(s.primary)
+	}
+	result, err := read(r.store)
+	if err != nil && (ctx.Err() != nil || errors.Is(err, ErrInvalidCIDR)) {
+		return result, err
+	}
+	r.record(err, s.clock.Now(), s.threshold, s.cooldown)
+	if err != nil {
+		return read(s.primary)
+	}
+	return result, nil
+}
+
+// Upsert writes to the primary
+func (s *ReadReplicaStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	return s.primary.Upsert(ctx, r)
+}
+
+// BulkUpsert writes to the primary
+func (s *ReadReplicaStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	return s.primary.BulkUpsert(ctx, records)
+}
+
+// UpdateIfUnchanged updates the primary, whose timestamp is current
+func (s *ReadReplicaStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	return s.primary.UpdateIfUnchanged(ctx, r, expectedTimestamp)
+}
+
+// Get reads from a replica
+func (s *ReadReplicaStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) (*ServiceRecord, error) {
+		return st.Get(ctx, ip, port, service)
+	})
+}
+
+// GetMulti reads from a replica
+func (s *ReadReplicaStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) (map[RecordKey]*ServiceRecord, error) {
+		return st.GetMulti(ctx, keys)
+	})
+}
+
+// List reads from a replica
+func (s *ReadReplicaStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.List(ctx, limit, offset)
+	})
+}
+
+// ListV2 reads from a replica
+func (s *ReadReplicaStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	return readReplica(ctx, s, func(st Store) (*ListResult, error) {
+		return st.ListV2(ctx, limit, offset)
+	})
+}
+
+// ListByIP reads from a replica
+func (s *ReadReplicaStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.ListByIP(ctx, ip)
+	})
+}
+
+// ListByService reads from a replica
+func (s *ReadReplicaStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.ListByService(ctx, service, limit, offset)
+	})
+}
+
+// ListByPort reads from a replica
+func (s *ReadReplicaStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.ListByPort(ctx, port, limit, offset)
+	})
+}
+
+// ListByTimestampRange reads from a replica
+func (s *ReadReplicaStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.ListByTimestampRange(ctx, from, to, limit, offset)
+	})
+}
+
+// ListByCIDR reads from a replica
+func (s *ReadReplicaStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.ListByCIDR(ctx, cidr, limit, offset)
+	})
+}
+
+// SearchByResponse reads from a replica
+func (s *ReadReplicaStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.SearchByResponse(ctx, query, limit, offset)
+	})
+}
+
+// ListAfter reads from a replica
+func (s *ReadReplicaStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.ListAfter(ctx, after, limit)
+	})
+}
+
+// ListDistinctIPs reads from a replica
+func (s *ReadReplicaStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	return readReplica(ctx, s, func(st Store) ([]string, error) {
+		return st.ListDistinctIPs(ctx, limit, offset)
+	})
+}
+
+// CountDistinctIPs reads from a replica
+func (s *ReadReplicaStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	return readReplica(ctx, s, func(st Store) (int64, error) {
+		return st.CountDistinctIPs(ctx)
+	})
+}
+
+// ListDistinctServices reads from a replica
+func (s *ReadReplicaStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return readReplica(ctx, s, func(st Store) ([]string, error) {
+		return st.ListDistinctServices(ctx)
+	})
+}
+
+// ListDistinctPorts reads from a replica
+func (s *ReadReplicaStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return readReplica(ctx, s, func(st Store) ([]uint32, error) {
+		return st.ListDistinctPorts(ctx)
+	})
+}
+
+// FindCoOccurrence reads from a replica
+func (s *ReadReplicaStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	return readReplica(ctx, s, func(st Store) ([]*IPSummary, error) {
+		return st.FindCoOccurrence(ctx, services)
+	})
+}
+
+// ListChangedSince reads from a replica
+func (s *ReadReplicaStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.ListChangedSince(ctx, timestamp)
+	})
+}
+
+// Delete soft-deletes on the primary
+func (s *ReadReplicaStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return s.primary.Delete(ctx, ip, port, service)
+}
+
+// Undelete restores on the primary
+func (s *ReadReplicaStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	return s.primary.Undelete(ctx, ip, port, service)
+}
+
+// ListDeleted reads from a replica
+func (s *ReadReplicaStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return readReplica(ctx, s, func(st Store) ([]*ServiceRecord, error) {
+		return st.ListDeleted(ctx, limit, offset)
+	})
+}
+
+// DeleteOlderThan removes old records on the primary
+func (s *ReadReplicaStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	return s.primary.DeleteOlderThan(ctx, beforeTimestamp)
+}
+
+// Count reads from a replica
+func (s *ReadReplicaStore) Count(ctx context.Context) (int64, error) {
+	return readReplica(ctx, s, func(st Store) (int64, error) {
+		return st.Count(ctx)
+	})
+}
+
+// PurgeExpired purges expired records on the primary
+func (s *ReadReplicaStore) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.primary.PurgeExpired(ctx)
+}
+
+// Stats reads from a replica
+func (s *ReadReplicaStore) Stats(ctx context.Context) (*StoreStats, error) {
+	return readReplica(ctx, s, func(st Store) (*StoreStats, error) {
+		return st.Stats(ctx)
+	})
+}
+
+// Watch watches the primary, where writes are applied
+func (s *ReadReplicaStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.primary.Watch(ctx)
+}
+
+// Unwatch stops a watch on the primary
+func (s *ReadReplicaStore) Unwatch(ch <-chan StoreEvent) {
+	s.primary.Unwatch(ch)
+}
+
+// HealthCheck checks the primary
+// Replicas are not checked, as reads fall back to the primary; see
+// ReplicaStatus for their health
+func (s *ReadReplicaStore) HealthCheck(ctx context.Context) error {
+	return s.primary.HealthCheck(ctx)
+}
+
+// Close closes the primary and every replica
+func (s *ReadReplicaStore) Close() error {
+	errs := []error{s.primary.Close()}
+	for _, r := range s.replicas {
+		errs = append(errs, r.store.Close())
+	}
+	return errors.Join(errs...)
+}
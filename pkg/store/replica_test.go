@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// failingReadStore fails Get and List with err while it is set and counts
+// the calls that reach it
+type failingReadStore struct {
+	Store
+	err   atomic.Pointer[error]
+	calls atomic.Int32
+}
+
+func (s *failingReadStore) fail(err error) { s.err.Store(&err) }
+
+func (s *failingReadStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	s.calls.Add(1)
+	if err := s.err.Load(); err != nil && *err != nil {
+		return nil, *err
+	}
+	return s.Store.Get(ctx, ip, port, service)
+}
+
+func (s *failingReadStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	s.calls.Add(1)
+	if err := s.err.Load(); err != nil && *err != nil {
+		return nil, *err
+	}
+	return s.Store.List(ctx, limit, offset)
+}
+
+// TestReadReplicaStoreRoundRobin tests that writes go to the primary and
+// reads alternate between the replicas
+func TestReadReplicaStoreRoundRobin(t *testing.T) {
+	primary := NewMemoryStore()
+	replicas := []*failingReadStore{{Store: NewMemoryStore()}, {Store: NewMemoryStore()}}
+	s := NewReadReplicaStore(primary, replicas[0], replicas[1])
+	defer s.Close()
+	ctx := context.Background()
+
+	r := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}
+	if _, err := s.Upsert(ctx, r); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if primary.Len() != 1 || replicas[0].Store.(*MemoryStore).Len() != 0 {
+		t.Error("Expected the write on the primary only")
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := s.List(ctx, 0, 0); err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+	}
+	for i, replica := range replicas {
+		if got := replica.calls.Load(); got != 2 {
+			t.Errorf("Expected 2 reads on replica %d, got %d", i, got)
+		}
+	}
+}
+
+// TestReadReplicaStoreFallback tests that a failing replica falls back to
+// the primary, leaves the rotation after repeated errors and returns after
+// the cooldown
+func TestReadReplicaStoreFallback(t *testing.T) {
+	primary := NewMemoryStore()
+	replica := &failingReadStore{Store: NewMemoryStore()}
+	clock := clockwork.NewFakeClock()
+	s := newReadReplicaStore(ReadReplicaConfig{FailureThreshold: 2, Cooldown: time.Minute}, clock, primary, replica)
+	ctx := context.Background()
+
+	if _, err := primary.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	replicaErr := errors.New("replica unavailable")
+	replica.fail(replicaErr)
+
+	// Each failed read is answered by the primary
+	for i := 0; i < 2; i++ {
+		got, err := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+		if err != nil || got == nil {
+			t.Fatalf("Expected the record from the primary, got %+v (err %v)", got, err)
+		}
+	}
+	status := s.ReplicaStatus()
+	if len(status) != 1 || status[0].Index != 0 || status[0].Healthy || !errors.Is(status[0].LastError, replicaErr) {
+		t.Errorf("Expected replica 0 unhealthy with the replica error, got %+v", status)
+	}
+
+	// While cooling down the replica is not called
+	if _, err := s.Get(ctx, "1.1.1.1", 80, "HTTP"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := replica.calls.Load(); got != 2 {
+		t.Errorf("Expected the unhealthy replica to be skipped, got %d calls", got)
+	}
+
+	// After the cooldown the replica is tried again and recovers
+	replica.fail(nil)
+	clock.Advance(time.Minute)
+	if !s.ReplicaStatus()[0].Healthy {
+		t.Error("Expected the replica back in rotation after the cooldown")
+	}
+	if _, err := s.Get(ctx, "1.1.1.1", 80, "HTTP"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := replica.calls.Load(); got != 3 {
+		t.Errorf("Expected the replica to be read after the cooldown, got %d calls", got)
+	}
+
+	// A cancelled read is not retried on the primary and not held against
+	// the replica
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	replica.fail(context.Canceled)
+	if _, err := s.Get(cancelled, "1.1.1.1", 80, "HTTP"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if !s.ReplicaStatus()[0].Healthy {
+		t.Error("Expected cancellation not to count against the replica")
+	}
+}
@@ -0,0 +1,396 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// retryMaxDelay caps the backoff between two attempts of a RetryingStore
+const retryMaxDelay = 5 * time.Second
+
+// MySQL error number for a lock wait that timed out, see also
+// mysqlErrDeadlock
+const mysqlErrLockWaitTimeout = 1205
+
+// IsTransient reports whether err is likely to go away if the call is
+// repeated: network errors, connections the driver reports as bad, lock
+// contention and the Postgres/MySQL errors for serialization failures,
+// deadlocks and exhausted connections
+// Context cancellation and deadlines are never transient
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pgTransientCode(string(pqErr.Code))
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgTransientCode(pgErr.Code)
+	}
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+	return false
+}
+
+// pgTransientCode reports whether a Postgres SQLSTATE is a connection
+// failure, serialization failure, deadlock or a server that cannot accept
+// more connections yet
+func pgTransientCode(code string) bool {
+	switch code {
+	case "40001", // serialization_failure
+		"40P01", // deadlock_detected
+		"53300", // too_many_connections
+		"57P03": // cannot_connect_now
+		return true
+	}
+	// Class 08: connection exception
+	return len(code) == 5 && code[:2] == "08"
+}
+
+// RetryingStore is a Store that repeats calls to the store it wraps when
+// they fail with a transient error, see IsTransient
+// Every write is safe to repeat: upserts are guarded by timestamp, and a
+// Delete or Undelete that was applied before its error reports false when
+// repeated
+// Unwatch, HealthCheck and Close are not retried, so health checks report
+// the backend's current state
+type RetryingStore struct {
+	inner       Store
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+// NewRetryingStore wraps inner so each call is attempted up to maxAttempts
+// times, counting the first
+// Attempt n+1 waits about baseDelay*2^n, with jitter, up to retryMaxDelay
+func NewRetryingStore(inner Store, maxAttempts int, baseDelay time.Duration) Store {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &RetryingStore{inner: inner, maxAttempts: maxAttempts, baseDelay: baseDelay}
+}
+
+// backoff returns how long to wait after the given zero-based attempt
+// The delay is drawn from the upper half of the exponential step so
+// concurrent callers spread out without retrying immediately
+func (s *RetryingStore) backoff(attempt int) time.Duration {
+	d := s.baseDelay
+	for i := 0; i < attempt && d < retryMaxDelay; i++ {
+		d *= 2
+	}
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// do calls fn until it succeeds, fails with an error that is not
+// transient, ctx is done or maxAttempts calls have been made
+func (s *RetryingStore) do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < s.maxAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(s.backoff(attempt - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if err = fn(); !IsTransient(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("after %d retries: %w", s.maxAttempts-1, err)
+}
+
+// Upsert calls the wrapped store, retrying transient errors
+func (s *RetryingStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	var updated bool
+	err := s.do(ctx, func() (err error) {
+		updated, err = s.inner.Upsert(ctx, r)
+		return err
+	})
+	return updated, err
+}
+
+// BulkUpsert calls the wrapped store, retrying transient errors
+func (s *RetryingStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	var updated int
+	err := s.do(ctx, func() (err error) {
+		updated, err = s.inner.BulkUpsert(ctx, records)
+		return err
+	})
+	return updated, err
+}
+
+// UpdateIfUnchanged calls the wrapped store, retrying transient errors
+func (s *RetryingStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	var updated bool
+	err := s.do(ctx, func() (err error) {
+		updated, err = s.inner.UpdateIfUnchanged(ctx, r, expectedTimestamp)
+		return err
+	})
+	return updated, err
+}
+
+// Get calls the wrapped store, retrying transient errors
+func (s *RetryingStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	var r *ServiceRecord
+	err := s.do(ctx, func() (err error) {
+		r, err = s.inner.Get(ctx, ip, port, service)
+		return err
+	})
+	return r, err
+}
+
+// GetMulti calls the wrapped store, retrying transient errors
+func (s *RetryingStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	var found map[RecordKey]*ServiceRecord
+	err := s.do(ctx, func() (err error) {
+		found, err = s.inner.GetMulti(ctx, keys)
+		return err
+	})
+	return found, err
+}
+
+// list calls a list method of the wrapped store, retrying transient errors
+func (s *RetryingStore) list(ctx context.Context, fn func() ([]*ServiceRecord, error)) ([]*ServiceRecord, error) {
+	var records []*ServiceRecord
+	err := s.do(ctx, func() (err error) {
+		records, err = fn()
+		return err
+	})
+	return records, err
+}
+
+// List calls the wrapped store, retrying transient errors
+func (s *RetryingStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.List(ctx, limit, offset) })
+}
+
+// ListV2 calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	var result *ListResult
+	err := s.do(ctx, func() (err error) {
+		result, err = s.inner.ListV2(ctx, limit, offset)
+		return err
+	})
+	return result, err
+}
+
+// ListByIP calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.ListByIP(ctx, ip) })
+}
+
+// ListByService calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.ListByService(ctx, service, limit, offset) })
+}
+
+// ListByPort calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.ListByPort(ctx, port, limit, offset) })
+}
+
+// ListByTimestampRange calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.ListByTimestampRange(ctx, from, to, limit, offset) })
+}
+
+// ListByCIDR calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.ListByCIDR(ctx, cidr, limit, offset) })
+}
+
+// SearchByResponse calls the wrapped store, retrying transient errors
+func (s *RetryingStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.SearchByResponse(ctx, query, limit, offset) })
+}
+
+// ListAfter calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.ListAfter(ctx, after, limit) })
+}
+
+// ListDistinctIPs calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	var ips []string
+	err := s.do(ctx, func() (err error) {
+		ips, err = s.inner.ListDistinctIPs(ctx, limit, offset)
+		return err
+	})
+	return ips, err
+}
+
+// CountDistinctIPs calls the wrapped store, retrying transient errors
+func (s *RetryingStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.do(ctx, func() (err error) {
+		count, err = s.inner.CountDistinctIPs(ctx)
+		return err
+	})
+	return count, err
+}
+
+// ListDistinctServices calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	var services []string
+	err := s.do(ctx, func() (err error) {
+		services, err = s.inner.ListDistinctServices(ctx)
+		return err
+	})
+	return services, err
+}
+
+// ListDistinctPorts calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	var ports []uint32
+	err := s.do(ctx, func() (err error) {
+		ports, err = s.inner.ListDistinctPorts(ctx)
+		return err
+	})
+	return ports, err
+}
+
+// FindCoOccurrence calls the wrapped store, retrying transient errors
+func (s *RetryingStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	var summaries []*IPSummary
+	err := s.do(ctx, func() (err error) {
+		summaries, err = s.inner.FindCoOccurrence(ctx, services)
+		return err
+	})
+	return summaries, err
+}
+
+// ListChangedSince calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.ListChangedSince(ctx, timestamp) })
+}
+
+// Delete calls the wrapped store, retrying transient errors
+func (s *RetryingStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	var deleted bool
+	err := s.do(ctx, func() (err error) {
+		deleted, err = s.inner.Delete(ctx, ip, port, service)
+		return err
+	})
+	return deleted, err
+}
+
+// Undelete calls the wrapped store, retrying transient errors
+func (s *RetryingStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	var restored bool
+	err := s.do(ctx, func() (err error) {
+		restored, err = s.inner.Undelete(ctx, ip, port, service)
+		return err
+	})
+	return restored, err
+}
+
+// ListDeleted calls the wrapped store, retrying transient errors
+func (s *RetryingStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.list(ctx, func() ([]*ServiceRecord, error) { return s.inner.ListDeleted(ctx, limit, offset) })
+}
+
+// DeleteOlderThan calls the wrapped store, retrying transient errors
+func (s *RetryingStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	var deleted int64
+	err := s.do(ctx, func() (err error) {
+		deleted, err = s.inner.DeleteOlderThan(ctx, beforeTimestamp)
+		return err
+	})
+	return deleted, err
+}
+
+// PurgeExpired calls the wrapped store, retrying transient errors
+func (s *RetryingStore) PurgeExpired(ctx context.Context) (int64, error) {
+	var purged int64
+	err := s.do(ctx, func() (err error) {
+		purged, err = s.inner.PurgeExpired(ctx)
+		return err
+	})
+	return purged, err
+}
+
+// Count calls the wrapped store, retrying transient errors
+func (s *RetryingStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.do(ctx, func() (err error) {
+		count, err = s.inner.Count(ctx)
+		return err
+	})
+	return count, err
+}
+
+// Stats calls the wrapped store, retrying transient errors
+func (s *RetryingStore) Stats(ctx context.Context) (*StoreStats, error) {
+	var stats *StoreStats
+	err := s.do(ctx, func() (err error) {
+		stats, err = s.inner.Stats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+// Watch calls the wrapped store, retrying transient errors
+func (s *RetryingStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	var ch <-chan StoreEvent
+	err := s.do(ctx, func() (err error) {
+		ch, err = s.inner.Watch(ctx)
+		return err
+	})
+	return ch, err
+}
+
+// Unwatch calls the wrapped store
+func (s *RetryingStore) Unwatch(ch <-chan StoreEvent) {
+	s.inner.Unwatch(ch)
+}
+
+// HealthCheck calls the wrapped store once
+func (s *RetryingStore) HealthCheck(ctx context.Context) error {
+	return s.inner.HealthCheck(ctx)
+}
+
+// Close closes the wrapped store
+func (s *RetryingStore) Close() error {
+	return s.inner.Close()
+}
+
+// Unwrap returns the wrapped store
+func (s *RetryingStore) Unwrap() Store {
+	return s.inner
+}
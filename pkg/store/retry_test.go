@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// flakyStore fails the first failures calls to Upsert and Get with err
+type flakyStore struct {
+	Store
+	failures int
+	err      error
+	calls    int
+}
+
+func (s *flakyStore) fail() error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func (s *flakyStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	if err := s.fail(); err != nil {
+		return false, err
+	}
+	return s.Store.Upsert(ctx, r)
+}
+
+func (s *flakyStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, ip, port, service)
+}
+
+// TestRetryingStore tests that transient errors are retried until the call
+// succeeds and that other errors are returned at once
+func TestRetryingStore(t *testing.T) {
+	ctx := context.Background()
+	r := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}
+
+	t.Run("succeeds on third attempt", func(t *testing.T) {
+		inner := &flakyStore{Store: NewMemoryStore(), failures: 2, err: driver.ErrBadConn}
+		s := NewRetryingStore(inner, 3, time.Millisecond)
+
+		updated, err := s.Upsert(ctx, r)
+		if err != nil || !updated {
+			t.Fatalf("Expected upsert to succeed, got %v (err %v)", updated, err)
+		}
+		if inner.calls != 3 {
+			t.Errorf("Expected 3 calls, got %d", inner.calls)
+		}
+		if got, err := s.Get(ctx, "1.1.1.1", 80, "HTTP"); err != nil || got == nil {
+			t.Errorf("Expected record, got %+v (err %v)", got, err)
+		}
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		inner := &flakyStore{Store: NewMemoryStore(), failures: 5, err: driver.ErrBadConn}
+		s := NewRetryingStore(inner, 3, time.Millisecond)
+
+		_, err := s.Upsert(ctx, r)
+		if !errors.Is(err, driver.ErrBadConn) {
+			t.Fatalf("Expected wrapped ErrBadConn, got %v", err)
+		}
+		if err.Error() != "after 2 retries: "+driver.ErrBadConn.Error() {
+			t.Errorf("Expected retry count in error, got %q", err)
+		}
+		if inner.calls != 3 {
+			t.Errorf("Expected 3 calls, got %d", inner.calls)
+		}
+	})
+
+	t.Run("permanent error is not retried", func(t *testing.T) {
+		permanent := errors.New("constraint violation")
+		inner := &flakyStore{Store: NewMemoryStore(), failures: 1, err: permanent}
+		s := NewRetryingStore(inner, 3, time.Millisecond)
+
+		if _, err := s.Upsert(ctx, r); err != permanent {
+			t.Errorf("Expected unwrapped permanent error, got %v", err)
+		}
+		if inner.calls != 1 {
+			t.Errorf("Expected 1 call, got %d", inner.calls)
+		}
+	})
+
+	t.Run("context cancelled while waiting", func(t *testing.T) {
+		inner := &flakyStore{Store: NewMemoryStore(), failures: 5, err: driver.ErrBadConn}
+		s := NewRetryingStore(inner, 3, time.Hour)
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		if _, err := s.Upsert(ctx, r); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected deadline exceeded, got %v", err)
+		}
+		if inner.calls != 1 {
+			t.Errorf("Expected 1 call, got %d", inner.calls)
+		}
+	})
+}
+
+// TestRetryingStoreBackoff tests that delays grow exponentially within the
+// jitter range and are capped
+func TestRetryingStoreBackoff(t *testing.T) {
+	s := NewRetryingStore(NewMemoryStore(), 100, 100*time.Millisecond).(*RetryingStore)
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			if d := s.backoff(attempt); d < want/2 || d > want {
+				t.Errorf("Expected attempt %d delay in [%s, %s], got %s", attempt, want/2, want, d)
+			}
+		}
+	}
+	if d := s.backoff(80); d < retryMaxDelay/2 || d > retryMaxDelay {
+		t.Errorf("Expected delay capped at %s, got %s", retryMaxDelay, d)
+	}
+}
+
+// TestIsTransient tests error classification
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain", errors.New("boom"), false},
+		{"bad conn", fmt.Errorf("failed to query: %w", driver.ErrBadConn), true},
+		{"net error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("failed to query: %w", context.DeadlineExceeded), false},
+		{"pq serialization", &pq.Error{Code: "40001"}, true},
+		{"pq connection", &pq.Error{Code: "08006"}, true},
+		{"pq unique", &pq.Error{Code: "23505"}, false},
+		{"pgx deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"pgx too many connections", &pgconn.PgError{Code: "53300"}, true},
+		{"pgx syntax", &pgconn.PgError{Code: "42601"}, false},
+		{"sqlite busy", sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"sqlite locked", sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{"sqlite constraint", sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{"mysql deadlock", &mysql.MySQLError{Number: mysqlErrDeadlock}, true},
+		{"mysql duplicate", &mysql.MySQLError{Number: mysqlErrDuplicateKey}, false},
+		{"mysql invalid conn", mysql.ErrInvalidConn, true},
+	}
+
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("%s: Expected IsTransient %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
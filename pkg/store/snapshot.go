@@ -0,0 +1,107 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// NewPersistentMemoryStore creates an in-memory store backed by a snapshot
+// file at path
+// The snapshot is loaded if it exists and saved again by Close, so records
+// survive a restart as long as the store is closed cleanly
+func NewPersistentMemoryStore(path string, opts ...MemoryStoreOption) (*MemoryStore, error) {
+	s := NewMemoryStore(opts...)
+	if err := s.LoadSnapshot(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	s.snapshotPath = path
+	return s, nil
+}
+
+// SaveSnapshot writes every record, including soft-deleted ones, to path as
+// newline-delimited JSON, one ServiceRecord per line
+// Writes are blocked while the snapshot is written; it goes to a temporary
+// file beside path that is renamed into place, so path is never left
+// half-written
+func (s *MemoryStore) SaveSnapshot(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.writeSnapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to move snapshot into place: %w", err)
+	}
+	return nil
+}
+
+// writeSnapshot encodes the records to f and syncs it
+func (s *MemoryStore) writeSnapshot(f *os.File) error {
+	// Acquire read lock - allows multiple concurrent readers, but blocks writers
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range s.records {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot replaces every record in the store with those in a snapshot
+// written by SaveSnapshot
+// The store is left unchanged if the snapshot cannot be read, and the
+// returned error wraps fs.ErrNotExist if path does not exist
+// Watchers are not notified of the loaded records, and a store with a
+// maximum capacity keeps only the newest records that fit
+func (s *MemoryStore) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	records := make(map[string]*ServiceRecord)
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for i := 1; ; i++ {
+		var r ServiceRecord
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode snapshot record %d: %w", i, err)
+		}
+		records[makeKey(r.IP.String(), r.Port, r.Service)] = &r
+	}
+
+	// Acquire exclusive lock for writing - blocks other reads and writes until unlocked
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = records
+	s.resetEvictionLocked()
+	return nil
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPersistentMemoryStore tests that records, including soft-deleted and
+// expiring ones, survive a close and reopen cycle
+func TestPersistentMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+
+	s, err := NewStore("memory-persistent", path)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	records := []*ServiceRecord{
+		{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "a"},
+		{IP: "2001:db8::1", Port: 22, Service: "SSH", LastTimestamp: 2000, Response: "b\r\n", ExpiresAt: &expires},
+		{IP: "3.3.3.3", Port: 53, Service: "DNS", LastTimestamp: 3000, Response: "c"},
+	}
+	if _, err := s.BulkUpsert(ctx, records); err != nil {
+		t.Fatalf("BulkUpsert failed: %v", err)
+	}
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1500, Response: "a2"})
+	if _, err := s.Delete(ctx, "3.3.3.3", 53, "DNS"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	before, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	s, err = NewStore("memory-persistent", path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer s.Close()
+
+	if count, _ := s.Count(ctx); count != 2 {
+		t.Errorf("Expected 2 live records, got %d", count)
+	}
+	after, err := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if err != nil || after == nil {
+		t.Fatalf("Expected record to survive reopen, got %v (err %v)", after, err)
+	}
+	if after.Response != "a2" || after.PreviousResponse != "a" || after.ScanCount != 2 ||
+		!after.FirstSeenAt.Equal(before.FirstSeenAt) || !after.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("Expected %+v, got %+v", before, after)
+	}
+	ssh, _ := s.Get(ctx, "2001:db8::1", 22, "SSH")
+	if ssh == nil || ssh.Response != "b\r\n" || ssh.ExpiresAt == nil || !ssh.ExpiresAt.Equal(expires) {
+		t.Errorf("Expected SSH record with response and expiry intact, got %+v", ssh)
+	}
+	deleted, _ := s.ListDeleted(ctx, 0, 0)
+	if len(deleted) != 1 || deleted[0].IP != "3.3.3.3" {
+		t.Errorf("Expected the soft-deleted record to stay deleted, got %v", deleted)
+	}
+
+	// No temporary files are left beside the snapshot
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the snapshot file, got %d entries", len(entries))
+	}
+}
+
+// TestLoadSnapshotErrors tests that a missing or corrupt snapshot is
+// reported and leaves the store unchanged
+func TestLoadSnapshotErrors(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := NewMemoryStore()
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+
+	if err := s.LoadSnapshot(filepath.Join(dir, "missing.jsonl")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.jsonl")
+	os.WriteFile(corrupt, []byte(`{"IP":"2.2.2.2","Port":80,"Service":"HTTP"}`+"\nnot json\n"), 0o644)
+	if err := s.LoadSnapshot(corrupt); err == nil {
+		t.Error("Expected error for corrupt snapshot")
+	}
+	if _, err := NewPersistentMemoryStore(corrupt); err == nil {
+		t.Error("Expected NewPersistentMemoryStore to fail on a corrupt snapshot")
+	}
+
+	if s.Len() != 1 {
+		t.Errorf("Expected store to be unchanged, got %d records", s.Len())
+	}
+}
@@ -6,17 +6,105 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// sqliteBulkChunkSize bounds rows per statement to stay below SQLite's
+// bound-parameter limit (13 parameters per row)
+const sqliteBulkChunkSize = 500
+
+// sqliteUpdateIfUnchangedSQL replaces a live record only while its
+// last_timestamp still equals the last argument
+const sqliteUpdateIfUnchangedSQL = `
+	UPDATE service_records SET
+		last_timestamp = ?,
+		response = ?,
+		updated_at = CURRENT_TIMESTAMP,
+		tls_version = NULLIF(?, ''),
+		status_code = NULLIF(?, 0),
+		scan_count = scan_count + 1,
+		previous_response = response,
+		response_hash = ?,
+		response_truncated = ?,
+		previous_response_hash = response_hash,
+		expires_at = ?,
+		pubsub_message_id = ?,
+		pubsub_subscription_id = ?,
+		pubsub_publish_time = ?
+	WHERE ip = ? AND port = ? AND service = ? AND last_timestamp = ? AND deleted_at IS NULL
+`
+
+// sqliteTimeFormat is a fixed-width UTC layout for expires_at, so that
+// comparing stored values as text orders them chronologically
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+
+// sqliteUpsertArgs returns the arguments of the upsert statements' VALUES
+// for r, in column order
+func sqliteUpsertArgs(r *ServiceRecord) []interface{} {
+	messageID, subscriptionID, publishTime := messageColumns(r)
+	return []interface{}{r.IP, r.Port, r.Service, r.LastTimestamp, r.Response, r.TLSVersion, r.StatusCode, sqliteTime(r.ExpiresAt), r.ResponseHash, r.ResponseTruncated,
+		messageID, subscriptionID, sqliteTime(publishTime)}
+}
+
+// sqliteUpdateIfUnchangedArgs returns the arguments of
+// sqliteUpdateIfUnchangedSQL for r
+func sqliteUpdateIfUnchangedArgs(r *ServiceRecord, expectedTimestamp int64) []interface{} {
+	messageID, subscriptionID, publishTime := messageColumns(r)
+	return []interface{}{r.LastTimestamp, r.Response, r.TLSVersion, r.StatusCode, r.ResponseHash, r.ResponseTruncated, sqliteTime(r.ExpiresAt),
+		messageID, subscriptionID, sqliteTime(publishTime), r.IP, r.Port, r.Service, expectedTimestamp}
+}
+
+// sqliteTime formats an optional time for storage, mapping nil to NULL
+func sqliteTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
 // SQLiteStore implements Store interface using SQLite
 type SQLiteStore struct {
-	db *sql.DB
+	db  *sql.DB
+	hub watchHub
 }
 
-// NewSQLiteStore creates a new SQLite store
+// SQLiteConfig holds connection pool settings for SQLiteStore
+// Zero MaxIdleConns and ConnMaxLifetime leave the database/sql defaults
+type SQLiteConfig struct {
+	// MaxOpenConns caps open connections; 0 means unlimited
+	// SQLite allows a single writer, so more than one connection lets
+	// concurrent writes contend for the lock
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// BusyTimeout is how long a connection waits for a lock held by another
+	// connection or process before failing with SQLITE_BUSY
+	BusyTimeout time.Duration
+}
+
+// DefaultSQLiteConfig returns the settings used by NewSQLiteStore:
+// a single connection, so writes are serialized, and a 5s busy timeout
+func DefaultSQLiteConfig() SQLiteConfig {
+	return SQLiteConfig{
+		MaxOpenConns: 1,
+		MaxIdleConns: 1,
+		BusyTimeout:  5 * time.Second,
+	}
+}
+
+// NewSQLiteStore creates a new SQLite store with DefaultSQLiteConfig
 func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
+	return NewSQLiteStoreWithConfig(dbPath, DefaultSQLiteConfig())
+}
+
+// NewSQLiteStoreWithConfig creates a new SQLite store with the given
+// connection pool settings
+func NewSQLiteStoreWithConfig(dbPath string, cfg SQLiteConfig) (*SQLiteStore, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(dbPath)
 	if dir != "" && dir != "." {
@@ -25,10 +113,28 @@ func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
 		}
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	// The driver runs PRAGMA busy_timeout on every new connection, which a
+	// single db.Exec could not do for the whole pool
+	dsn := dbPath
+	if cfg.BusyTimeout > 0 {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += fmt.Sprintf("%s_busy_timeout=%d", sep, cfg.BusyTimeout.Milliseconds())
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
 
 	// Enable WAL mode for better concurrency
 	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
@@ -36,8 +142,37 @@ func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
 		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
 	}
 
-	// Create table if not exists
-	_, err = db.Exec(`
+	if err := applyMigrations(context.Background(), db, sqliteMigrations, sqliteMigrationDialect); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Create indexes for common queries
+	// IF NOT EXISTS also adds any new indexes to existing databases on startup
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_timestamp ON service_records(last_timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_ip ON service_records(ip)`,
+		`CREATE INDEX IF NOT EXISTS idx_service ON service_records(service)`,
+		`CREATE INDEX IF NOT EXISTS idx_port ON service_records(port)`,
+		`CREATE INDEX IF NOT EXISTS idx_expires_at ON service_records(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_deleted_at ON service_records(deleted_at)`,
+	}
+	for _, stmt := range indexes {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// sqliteMigrations builds service_records from the original schema
+// SQLite has no ADD COLUMN IF NOT EXISTS, so each column is its own
+// migration and sqliteDuplicateColumn lets databases created before
+// versioning record the columns they already have
+var sqliteMigrations = []Migration{
+	{Version: 1, SQL: `
 		CREATE TABLE IF NOT EXISTS service_records (
 			ip            TEXT NOT NULL,
 			port          INTEGER NOT NULL,
@@ -47,33 +182,109 @@ func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
 			updated_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
 			PRIMARY KEY (ip, port, service)
 		)
-	`)
-	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to create table: %w", err)
-	}
+	`},
+	{Version: 2, SQL: `ALTER TABLE service_records ADD COLUMN tls_version TEXT`},
+	{Version: 3, SQL: `ALTER TABLE service_records ADD COLUMN status_code INTEGER`},
+	{Version: 4, SQL: `ALTER TABLE service_records ADD COLUMN expires_at DATETIME`},
+	{Version: 5, SQL: `ALTER TABLE service_records ADD COLUMN deleted_at TIMESTAMP`},
+	// ADD COLUMN cannot use a non-constant default, so first_seen_at is
+	// nullable and backfilled from updated_at; inserts always set it
+	{Version: 6, SQL: `ALTER TABLE service_records ADD COLUMN first_seen_at TIMESTAMP`},
+	{Version: 7, SQL: `UPDATE service_records SET first_seen_at = COALESCE(updated_at, CURRENT_TIMESTAMP) WHERE first_seen_at IS NULL`},
+	{Version: 8, SQL: `ALTER TABLE service_records ADD COLUMN scan_count INTEGER NOT NULL DEFAULT 1`},
+	{Version: 9, SQL: `ALTER TABLE service_records ADD COLUMN previous_response TEXT NOT NULL DEFAULT ''`},
+	{Version: 10, SQL: `ALTER TABLE service_records ADD COLUMN response_hash TEXT NOT NULL DEFAULT ''`},
+	{Version: 11, SQL: `ALTER TABLE service_records ADD COLUMN previous_response_hash TEXT NOT NULL DEFAULT ''`},
+	{Version: 12, SQL: `ALTER TABLE service_records ADD COLUMN response_truncated BOOLEAN NOT NULL DEFAULT 0`},
+	// service_record_changelog is written by ChangelogStore
+	{Version: 13, SQL: `
+		CREATE TABLE IF NOT EXISTS service_record_changelog (
+			id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+			changed_at         TIMESTAMP NOT NULL,
+			ip                 TEXT NOT NULL,
+			port               INTEGER NOT NULL,
+			service            TEXT NOT NULL,
+			old_response       TEXT NOT NULL,
+			new_response       TEXT NOT NULL,
+			old_last_timestamp INTEGER NOT NULL,
+			new_last_timestamp INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_changelog_key ON service_record_changelog (ip, port, service, id)
+	`},
+	// scan_errors is written by ErrorTrackingStore
+	{Version: 14, SQL: `
+		CREATE TABLE IF NOT EXISTS scan_errors (
+			ip            TEXT NOT NULL,
+			port          INTEGER NOT NULL,
+			service       TEXT NOT NULL,
+			last_error    TEXT NOT NULL,
+			error_count   INTEGER NOT NULL,
+			last_error_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (ip, port, service)
+		)
+	`},
+	// pubsub_* identify the Pub/Sub message behind the last accepted upsert
+	{Version: 15, SQL: `ALTER TABLE service_records ADD COLUMN pubsub_message_id TEXT`},
+	{Version: 16, SQL: `ALTER TABLE service_records ADD COLUMN pubsub_subscription_id TEXT`},
+	{Version: 17, SQL: `ALTER TABLE service_records ADD COLUMN pubsub_publish_time TIMESTAMP`},
+	// Addresses written before IPs were canonicalized are rewritten in Go
+	{Version: 18, Func: canonicalIPs(func(int) string { return "?" })},
+}
 
-	// Create index for timestamp queries
-	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_timestamp ON service_records(last_timestamp)`)
-	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to create index: %w", err)
-	}
+// sqliteMigrationDialect records versions with ON CONFLICT and treats
+// duplicate columns as already applied
+var sqliteMigrationDialect = migrationDialect{
+	recordSQL:      onConflictRecordSQL,
+	alreadyApplied: sqliteDuplicateColumn,
+}
 
-	return &SQLiteStore{db: db}, nil
+// sqliteDuplicateColumn reports whether err is from adding a column that
+// already exists
+func sqliteDuplicateColumn(err error) bool {
+	return strings.Contains(err.Error(), "duplicate column name")
+}
+
+// MigrationsApplied returns the schema migration versions recorded in the
+// database, in ascending order
+func (s *SQLiteStore) MigrationsApplied(ctx context.Context) ([]int, error) {
+	return migrationsApplied(ctx, s.db)
 }
 
 // Upsert inserts or updates a record if the timestamp is newer
 func (s *SQLiteStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	r = canonicalRecord(r)
+	ctx, span := startSQLiteSpan(ctx, "SQLiteStore.Upsert", "upsert")
+	updated, err := s.upsert(ctx, r)
+	endSpan(span, err, attribute.Bool("updated", updated))
+	return updated, err
+}
+
+// upsert implements Upsert
+func (s *SQLiteStore) upsert(ctx context.Context, r *ServiceRecord) (bool, error) {
+	if s.hub.active() {
+		return s.upsertWatched(ctx, r)
+	}
+
 	result, err := s.db.ExecContext(ctx, `
-		INSERT INTO service_records (ip, port, service, last_timestamp, response, updated_at)
-		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO service_records (ip, port, service, last_timestamp, response, updated_at, first_seen_at, tls_version, status_code, expires_at, response_hash, response_truncated, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, NULLIF(?, ''), NULLIF(?, 0), ?, ?, ?, ?, ?, ?)
 		ON CONFLICT (ip, port, service) DO UPDATE SET
 			last_timestamp = excluded.last_timestamp,
 			response = excluded.response,
-			updated_at = CURRENT_TIMESTAMP
+			updated_at = CURRENT_TIMESTAMP,
+			tls_version = excluded.tls_version,
+			status_code = excluded.status_code,
+			scan_count = service_records.scan_count + 1,
+			previous_response = service_records.response,
+			response_hash = excluded.response_hash,
+			response_truncated = excluded.response_truncated,
+			previous_response_hash = service_records.response_hash,
+			expires_at = excluded.expires_at,
+			pubsub_message_id = excluded.pubsub_message_id,
+			pubsub_subscription_id = excluded.pubsub_subscription_id,
+			pubsub_publish_time = excluded.pubsub_publish_time
 		WHERE excluded.last_timestamp > service_records.last_timestamp
-	`, r.IP, r.Port, r.Service, r.LastTimestamp, r.Response)
+	`, sqliteUpsertArgs(r)...)
 
 	if err != nil {
 		return false, fmt.Errorf("failed to upsert record: %w", err)
@@ -87,16 +298,128 @@ func (s *SQLiteStore) Upsert(ctx context.Context, r *ServiceRecord) (bool, error
 	return rows > 0, nil
 }
 
+// BulkUpsert upserts a batch of records using multi-row INSERT statements
+// inside a single transaction
+// While watchers are registered records are upserted one at a time so each
+// change can be reported
+func (s *SQLiteStore) BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error) {
+	records = dedupeRecords(records)
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	if s.hub.active() {
+		updated := 0
+		for _, r := range records {
+			ok, err := s.upsertWatched(ctx, r)
+			if err != nil {
+				return updated, err
+			}
+			if ok {
+				updated++
+			}
+		}
+		return updated, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	updated := 0
+	for start := 0; start < len(records); start += sqliteBulkChunkSize {
+		end := start + sqliteBulkChunkSize
+		if end > len(records) {
+			end = len(records)
+		}
+		chunk := records[start:end]
+
+		placeholders := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*13)
+		for i, r := range chunk {
+			placeholders[i] = "(?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, NULLIF(?, ''), NULLIF(?, 0), ?, ?, ?, ?, ?, ?)"
+			args = append(args, sqliteUpsertArgs(r)...)
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO service_records (ip, port, service, last_timestamp, response, updated_at, first_seen_at, tls_version, status_code, expires_at, response_hash, response_truncated, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time)
+			VALUES `+strings.Join(placeholders, ", ")+`
+			ON CONFLICT (ip, port, service) DO UPDATE SET
+				last_timestamp = excluded.last_timestamp,
+				response = excluded.response,
+				updated_at = CURRENT_TIMESTAMP,
+				tls_version = excluded.tls_version,
+				status_code = excluded.status_code,
+				scan_count = service_records.scan_count + 1,
+				previous_response = service_records.response,
+				response_hash = excluded.response_hash,
+				response_truncated = excluded.response_truncated,
+				previous_response_hash = service_records.response_hash,
+				expires_at = excluded.expires_at,
+				pubsub_message_id = excluded.pubsub_message_id,
+				pubsub_subscription_id = excluded.pubsub_subscription_id,
+				pubsub_publish_time = excluded.pubsub_publish_time
+			WHERE excluded.last_timestamp > service_records.last_timestamp
+		`, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to bulk upsert records: %w", err)
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		updated += int(rows)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return updated, nil
+}
+
+// UpdateIfUnchanged replaces a record if its LastTimestamp equals
+// expectedTimestamp
+func (s *SQLiteStore) UpdateIfUnchanged(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	r = canonicalRecord(r)
+	if s.hub.active() {
+		return s.updateIfUnchangedWatched(ctx, r, expectedTimestamp)
+	}
+
+	result, err := s.db.ExecContext(ctx, sqliteUpdateIfUnchangedSQL, sqliteUpdateIfUnchangedArgs(r, expectedTimestamp)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update record: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
 // Get retrieves a record by its composite key
 func (s *SQLiteStore) Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	ctx, span := startSQLiteSpan(ctx, "SQLiteStore.Get", "select")
+	r, err := s.get(ctx, ip, port, service)
+	endSpan(span, err, attribute.Bool("found", r != nil))
+	return r, err
+}
+
+// get implements Get
+func (s *SQLiteStore) get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error) {
 	row := s.db.QueryRowContext(ctx, `
-		SELECT ip, port, service, last_timestamp, response, updated_at
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
 		FROM service_records
-		WHERE ip = ? AND port = ? AND service = ?
+		WHERE ip = ? AND port = ? AND service = ? AND deleted_at IS NULL
 	`, ip, port, service)
 
-	var r ServiceRecord
-	err := row.Scan(&r.IP, &r.Port, &r.Service, &r.LastTimestamp, &r.Response, &r.UpdatedAt)
+	r, err := scanServiceRecord(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -104,51 +427,618 @@ func (s *SQLiteStore) Get(ctx context.Context, ip string, port uint32, service s
 		return nil, fmt.Errorf("failed to get record: %w", err)
 	}
 
-	return &r, nil
+	return r, nil
+}
+
+// GetMulti retrieves several records with one query per
+// getMultiChunkSize keys
+func (s *SQLiteStore) GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error) {
+	return getMulti(ctx, s.db, keys, "VALUES ")
 }
 
 // List returns all records with optional pagination
 func (s *SQLiteStore) List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
-	var rows *sql.Rows
-	var err error
+	ctx, span := startSQLiteSpan(ctx, "SQLiteStore.List", "select")
+	records, err := s.list(ctx, limit, offset)
+	endSpan(span, err, attribute.Int("records", len(records)))
+	return records, err
+}
+
+// ListV2 returns a page of records and the number of live records, read
+// in one transaction
+func (s *SQLiteStore) ListV2(ctx context.Context, limit, offset int) (*ListResult, error) {
+	ctx, span := startSQLiteSpan(ctx, "SQLiteStore.ListV2", "select")
+	query, args := sqliteListQuery(limit, offset)
+	// A deferred SQLite transaction reads from one snapshot once it starts
+	result, err := listWithTotal(ctx, s.db, nil, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	endSpan(span, nil, attribute.Int("records", len(result.Records)))
+	return result, nil
+}
+
+// list implements List
+func (s *SQLiteStore) list(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	query, args := sqliteListQuery(limit, offset)
+	return queryRecords(ctx, s.db, query, args...)
+}
 
+// sqliteListQuery returns the List query for a page of live records
+func sqliteListQuery(limit, offset int) (string, []interface{}) {
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`
 	if limit > 0 {
-		rows, err = s.db.QueryContext(ctx, `
-			SELECT ip, port, service, last_timestamp, response, updated_at
-			FROM service_records
-			ORDER BY last_timestamp DESC
+		return query + ` LIMIT ? OFFSET ?`, []interface{}{limit, offset}
+	}
+	return query, nil
+}
+
+// ListByIP returns all records for the given IP address
+func (s *SQLiteStore) ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error) {
+	ip = CanonicalIP(ip)
+	return queryRecords(ctx, s.db, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = ? AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`, ip)
+}
+
+// ListByService returns records for the given service with optional pagination
+func (s *SQLiteStore) ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE service = ? AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`, limit, offset, service)
+}
+
+// ListByPort returns records for the given port with optional pagination
+func (s *SQLiteStore) ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE port = ? AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`, limit, offset, port)
+}
+
+// ListByTimestampRange returns records within the given timestamp window
+func (s *SQLiteStore) ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	if from != 0 {
+		conditions = append(conditions, "last_timestamp >= ?")
+		args = append(args, from)
+	}
+	if to != 0 {
+		conditions = append(conditions, "last_timestamp <= ?")
+		args = append(args, to)
+	}
+
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+	`
+	query += ` WHERE ` + strings.Join(conditions, " AND ")
+	query += ` ORDER BY last_timestamp DESC, ip, port, service`
+
+	return s.queryPage(ctx, query, limit, offset, args...)
+}
+
+// ListByCIDR returns records whose IP falls within the given CIDR range
+// SQL has no portable CIDR arithmetic, so candidates are narrowed with a LIKE
+// prefix on IPv4 octets and the exact containment check is done in Go
+func (s *SQLiteStore) ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`
+	var args []interface{}
+	if prefix := ipv4LikePrefix(network); prefix != "" {
+		query += ` AND ip LIKE ?`
+		args = append(args, prefix)
+	}
+	query += ` ORDER BY last_timestamp DESC, ip, port, service`
+
+	candidates, err := queryRecords(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*ServiceRecord, 0, len(candidates))
+	for _, r := range candidates {
+		if cidrContains(network, r.IP.String()) {
+			matched = append(matched, r)
+		}
+	}
+
+	return paginate(matched, limit, offset), nil
+}
+
+// queryPage appends LIMIT/OFFSET to query when limit > 0 and runs it
+func (s *SQLiteStore) queryPage(ctx context.Context, query string, limit, offset int, args ...interface{}) ([]*ServiceRecord, error) {
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+	return queryRecords(ctx, s.db, query, args...)
+}
+
+// SearchByResponse returns records whose response contains query
+// SQLite's LIKE ignores case for ASCII characters only
+func (s *SQLiteStore) SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE response LIKE '%' || ? || '%' ESCAPE '\' AND deleted_at IS NULL
+		ORDER BY last_timestamp DESC, ip, port, service
+	`, limit, offset, escapeLike(query))
+}
+
+// ListAfter returns records after the given cursor using keyset pagination
+func (s *SQLiteStore) ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error) {
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`
+	var args []interface{}
+	if !after.isStart() {
+		query += ` AND (last_timestamp < ? OR (last_timestamp = ? AND (ip, port, service) > (?, ?, ?)))`
+		args = append(args, after.Timestamp, after.Timestamp, after.IP, after.Port, after.Service)
+	}
+	query += ` ORDER BY last_timestamp DESC, ip ASC, port ASC, service ASC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	return queryRecords(ctx, s.db, query, args...)
+}
+
+// ListDistinctIPs returns the unique IPs of live records with optional
+// pagination
+func (s *SQLiteStore) ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error) {
+	if limit > 0 {
+		return queryColumn[string](ctx, s.db, `
+			SELECT DISTINCT ip FROM service_records
+			WHERE deleted_at IS NULL
+			ORDER BY ip
 			LIMIT ? OFFSET ?
 		`, limit, offset)
-	} else {
-		rows, err = s.db.QueryContext(ctx, `
-			SELECT ip, port, service, last_timestamp, response, updated_at
-			FROM service_records
-			ORDER BY last_timestamp DESC
-		`)
 	}
 
+	return queryColumn[string](ctx, s.db, `
+		SELECT DISTINCT ip FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY ip
+	`)
+}
+
+// CountDistinctIPs returns the number of unique IPs of live records
+func (s *SQLiteStore) CountDistinctIPs(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT ip) FROM service_records WHERE deleted_at IS NULL`).Scan(&count)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list records: %w", err)
+		return 0, fmt.Errorf("failed to count ips: %w", err)
 	}
-	defer rows.Close()
+	return count, nil
+}
+
+// ListDistinctServices returns the unique services of live records
+func (s *SQLiteStore) ListDistinctServices(ctx context.Context) ([]string, error) {
+	return queryColumn[string](ctx, s.db, `
+		SELECT DISTINCT service FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY service
+	`)
+}
+
+// ListDistinctPorts returns the unique ports of live records
+func (s *SQLiteStore) ListDistinctPorts(ctx context.Context) ([]uint32, error) {
+	return queryColumn[uint32](ctx, s.db, `
+		SELECT DISTINCT port FROM service_records
+		WHERE deleted_at IS NULL
+		ORDER BY port
+	`)
+}
+
+// FindCoOccurrence returns the IPs with a live record matching every filter
+func (s *SQLiteStore) FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error) {
+	if len(services) == 0 {
+		return []*IPSummary{}, nil
+	}
+
+	query, args := coOccurrenceQuery(services, func(int) string { return "?" })
+	records, err := queryRecords(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return groupByIP(records), nil
+}
+
+// ListChangedSince returns records updated after timestamp with a new
+// response hash
+func (s *SQLiteStore) ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error) {
+	return queryRecords(ctx, s.db, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE last_timestamp > ? AND deleted_at IS NULL
+			AND previous_response_hash <> '' AND response_hash <> previous_response_hash
+		ORDER BY last_timestamp DESC, ip ASC
+	`, timestamp)
+}
+
+// Delete soft-deletes a record by setting deleted_at
+func (s *SQLiteStore) Delete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	ip = CanonicalIP(ip)
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE service_records SET deleted_at = CURRENT_TIMESTAMP
+		WHERE ip = ? AND port = ? AND service = ? AND deleted_at IS NULL
+	`, ip, port, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete record: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// Undelete clears deleted_at on a soft-deleted record
+func (s *SQLiteStore) Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error) {
+	ip = CanonicalIP(ip)
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE service_records SET deleted_at = NULL
+		WHERE ip = ? AND port = ? AND service = ? AND deleted_at IS NOT NULL
+	`, ip, port, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to undelete record: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// ListDeleted returns soft-deleted records, most recently deleted first
+func (s *SQLiteStore) ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error) {
+	return s.queryPage(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, last_timestamp DESC
+	`, limit, offset)
+}
 
-	var records []*ServiceRecord
+// DeleteOlderThan removes records with a timestamp before beforeTimestamp
+// Soft-deleted records are removed as well
+func (s *SQLiteStore) DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM service_records WHERE last_timestamp < ?`, beforeTimestamp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old records: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+// PurgeExpired removes records whose expires_at is in the past
+func (s *SQLiteStore) PurgeExpired(ctx context.Context) (int64, error) {
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM service_records
+		WHERE expires_at IS NOT NULL AND expires_at < ?
+	`, sqliteTime(&now))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired records: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+// Count returns the total number of records
+func (s *SQLiteStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM service_records WHERE deleted_at IS NULL`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count records: %w", err)
+	}
+	return count, nil
+}
+
+// Stats returns aggregate counts using GROUP BY queries
+func (s *SQLiteStore) Stats(ctx context.Context) (*StoreStats, error) {
+	stats := &StoreStats{
+		RecordsByService: make(map[string]int64),
+		RecordsByPort:    make(map[uint32]int64),
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(MIN(last_timestamp), 0), COALESCE(MAX(last_timestamp), 0)
+		FROM service_records
+		WHERE deleted_at IS NULL
+	`).Scan(&stats.TotalRecords, &stats.OldestTimestamp, &stats.NewestTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query totals: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT service, COUNT(*) FROM service_records
+		WHERE deleted_at IS NULL
+		GROUP BY service
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service counts: %w", err)
+	}
+	defer rows.Close()
 	for rows.Next() {
-		var r ServiceRecord
-		if err := rows.Scan(&r.IP, &r.Port, &r.Service, &r.LastTimestamp, &r.Response, &r.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan record: %w", err)
+		var service string
+		var count int64
+		if err := rows.Scan(&service, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan service count: %w", err)
 		}
-		records = append(records, &r)
+		stats.RecordsByService[service] = count
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating records: %w", err)
+		return nil, fmt.Errorf("error iterating service counts: %w", err)
+	}
+
+	portRows, err := s.db.QueryContext(ctx, `
+		SELECT port, COUNT(*) FROM service_records
+		WHERE deleted_at IS NULL
+		GROUP BY port
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query port counts: %w", err)
+	}
+	defer portRows.Close()
+	for portRows.Next() {
+		var port uint32
+		var count int64
+		if err := portRows.Scan(&port, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan port count: %w", err)
+		}
+		stats.RecordsByPort[port] = count
+	}
+	if err := portRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating port counts: %w", err)
+	}
+
+	return stats, nil
+}
+
+// upsertWatched performs an upsert on a dedicated connection with SQLite's
+// update hook registered, and publishes the resulting event after commit
+// The hook reports whether the row was inserted or updated (and nothing when
+// the timestamp guard skips it); the previous row is read in the same
+// transaction since the hook only provides a rowid
+func (s *SQLiteStore) upsertWatched(ctx context.Context, r *ServiceRecord) (bool, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var ops []int
+	err = conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		sc.RegisterUpdateHook(func(op int, _, table string, _ int64) {
+			if table == "service_records" {
+				ops = append(ops, op)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to register update hook: %w", err)
+	}
+	defer conn.Raw(func(driverConn interface{}) error {
+		driverConn.(*sqlite3.SQLiteConn).RegisterUpdateHook(nil)
+		return nil
+	})
+
+	// BEGIN IMMEDIATE takes the write lock up front so concurrent watched
+	// upserts cannot deadlock upgrading from the read of the previous row
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.Background(), `ROLLBACK`)
+		}
+	}()
+
+	previous, err := scanRecord(conn.QueryRowContext(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = ? AND port = ? AND service = ?
+	`, r.IP, r.Port, r.Service))
+	if err != nil {
+		return false, fmt.Errorf("failed to get previous record: %w", err)
+	}
+
+	_, err = conn.ExecContext(ctx, `
+		INSERT INTO service_records (ip, port, service, last_timestamp, response, updated_at, first_seen_at, tls_version, status_code, expires_at, response_hash, response_truncated, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, NULLIF(?, ''), NULLIF(?, 0), ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ip, port, service) DO UPDATE SET
+			last_timestamp = excluded.last_timestamp,
+			response = excluded.response,
+			updated_at = CURRENT_TIMESTAMP,
+			tls_version = excluded.tls_version,
+			status_code = excluded.status_code,
+			scan_count = service_records.scan_count + 1,
+			previous_response = service_records.response,
+			response_hash = excluded.response_hash,
+			response_truncated = excluded.response_truncated,
+			previous_response_hash = service_records.response_hash,
+			expires_at = excluded.expires_at,
+			pubsub_message_id = excluded.pubsub_message_id,
+			pubsub_subscription_id = excluded.pubsub_subscription_id,
+			pubsub_publish_time = excluded.pubsub_publish_time
+		WHERE excluded.last_timestamp > service_records.last_timestamp
+	`, sqliteUpsertArgs(r)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to upsert record: %w", err)
+	}
+
+	if len(ops) == 0 {
+		if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+			return false, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		committed = true
+		s.hub.publish(StoreEvent{Type: EventSkipped, Record: copyRecord(r), Previous: previous})
+		return false, nil
 	}
 
-	return records, nil
+	current, err := scanRecord(conn.QueryRowContext(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = ? AND port = ? AND service = ?
+	`, r.IP, r.Port, r.Service))
+	if err != nil {
+		return false, fmt.Errorf("failed to get upserted record: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+
+	ev := StoreEvent{Type: EventCreated, Record: current}
+	if ops[len(ops)-1] == sqlite3.SQLITE_UPDATE {
+		ev.Type = EventUpdated
+		ev.Previous = previous
+	}
+	s.hub.publish(ev)
+
+	return true, nil
+}
+
+// updateIfUnchangedWatched performs UpdateIfUnchanged in a transaction that
+// also reads the record before and after, and publishes the update after
+// commit
+func (s *SQLiteStore) updateIfUnchangedWatched(ctx context.Context, r *ServiceRecord, expectedTimestamp int64) (bool, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	// BEGIN IMMEDIATE takes the write lock up front, as in upsertWatched
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.Background(), `ROLLBACK`)
+		}
+	}()
+
+	previous, err := scanRecord(conn.QueryRowContext(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = ? AND port = ? AND service = ?
+	`, r.IP, r.Port, r.Service))
+	if err != nil {
+		return false, fmt.Errorf("failed to get previous record: %w", err)
+	}
+
+	result, err := conn.ExecContext(ctx, sqliteUpdateIfUnchangedSQL, sqliteUpdateIfUnchangedArgs(r, expectedTimestamp)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update record: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	current, err := scanRecord(conn.QueryRowContext(ctx, `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE ip = ? AND port = ? AND service = ?
+	`, r.IP, r.Port, r.Service))
+	if err != nil {
+		return false, fmt.Errorf("failed to get updated record: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+
+	s.hub.publish(StoreEvent{Type: EventUpdated, Record: current, Previous: previous})
+	return true, nil
+}
+
+// scanRecord scans a single record row, returning nil when there is no row
+func scanRecord(row *sql.Row) (*ServiceRecord, error) {
+	r, err := scanServiceRecord(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// Watch returns a channel of record change events
+// Only changes made through this store instance are reported
+func (s *SQLiteStore) Watch(ctx context.Context) (<-chan StoreEvent, error) {
+	return s.hub.watch(ctx)
+}
+
+// Unwatch stops delivery to a channel returned by Watch and closes it
+func (s *SQLiteStore) Unwatch(ch <-chan StoreEvent) {
+	s.hub.unwatch(ch)
+}
+
+// HealthCheck runs a trivial query to confirm the database is usable
+func (s *SQLiteStore) HealthCheck(ctx context.Context) error {
+	var one int
+	if err := s.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one); err != nil {
+		return fmt.Errorf("failed to query database: %w", err)
+	}
+	return nil
 }
 
-// Close closes the database connection
+// Close closes any open watch channels and the database connection
 func (s *SQLiteStore) Close() error {
+	s.hub.close()
 	return s.db.Close()
 }
@@ -1,35 +1,292 @@
 package store
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 )
 
 // ServiceRecord represents a stored scan result
 type ServiceRecord struct {
-	IP            string
-	Port          uint32
-	Service       string
-	LastTimestamp int64
-	Response      string
-	UpdatedAt     time.Time
+	IP               IPAddress
+	Port             uint32
+	Service          string
+	LastTimestamp    int64
+	Response         string
+	UpdatedAt        time.Time
+	FirstSeenAt      time.Time // set when the record is first inserted, never updated
+	ScanCount        int64     // number of accepted upserts, starting at 1
+	PreviousResponse string    // response replaced by the last update, "" after insert
+
+	// ResponseHash is the hex SHA-256 of Response, see HashResponse
+	// PreviousResponseHash is the hash replaced by the last update
+	ResponseHash         string
+	PreviousResponseHash string
+
+	// ResponseTruncated is set when Response was cut to the processor's
+	// maximum response size
+	ResponseTruncated bool
+
+	// Optional V3 fields - empty/zero when the scan did not provide them
+	TLSVersion string
+	StatusCode int
+
+	// ExpiresAt is when the record becomes eligible for PurgeExpired
+	// nil records never expire
+	ExpiresAt *time.Time
+
+	// DeletedAt is set on soft-deleted records returned by ListDeleted
+	DeletedAt *time.Time
+
+	// MessageMetadata identifies the Pub/Sub message behind the last
+	// accepted upsert, nil when the record came from another source
+	MessageMetadata *MessageMetadata
+
+	// Country and ASN are set by the processor's enrichers after the
+	// record is stored and are not persisted by the stores
+	// Country is an ISO 3166-1 alpha-2 code, "" and 0 when unknown
+	Country string
+	ASN     uint32
+}
+
+// MessageMetadata identifies the Pub/Sub message a record was stored from,
+// for tracing dropped or duplicate records
+type MessageMetadata struct {
+	MessageID      string
+	SubscriptionID string
+	PublishTime    time.Time
+}
+
+// messageColumns returns the pubsub_* column values for r, NULL when r has
+// no message metadata
+// The publish time is UTC, as the columns hold no time zone
+func messageColumns(r *ServiceRecord) (messageID, subscriptionID sql.NullString, publishTime *time.Time) {
+	m := r.MessageMetadata
+	if m == nil {
+		return messageID, subscriptionID, nil
+	}
+	t := m.PublishTime.UTC()
+	return sql.NullString{String: m.MessageID, Valid: true}, sql.NullString{String: m.SubscriptionID, Valid: true}, &t
+}
+
+// ExpireAfter sets ExpiresAt to d after UpdatedAt
+// Records that have not been stored yet have no UpdatedAt, so the current
+// time is used instead
+func (r *ServiceRecord) ExpireAfter(d time.Duration) {
+	base := r.UpdatedAt
+	if base.IsZero() {
+		base = time.Now()
+	}
+	expiresAt := base.Add(d)
+	r.ExpiresAt = &expiresAt
+}
+
+// HashResponse returns the hex-encoded SHA-256 of a response, the value
+// stored in ResponseHash
+func HashResponse(response string) string {
+	sum := sha256.Sum256([]byte(response))
+	return hex.EncodeToString(sum[:])
+}
+
+// ResponseChanged reports whether the last update replaced a different
+// response
+func (r *ServiceRecord) ResponseChanged() bool {
+	return r.PreviousResponse != r.Response && r.PreviousResponse != ""
+}
+
+// RecordKey identifies a record by its composite key
+type RecordKey struct {
+	IP      string
+	Port    uint32
+	Service string
+}
+
+// Key returns the composite key of r
+func (r *ServiceRecord) Key() RecordKey {
+	return RecordKey{IP: r.IP.String(), Port: r.Port, Service: r.Service}
+}
+
+// ServiceFilter selects records by port and service for FindCoOccurrence
+// A zero Port or empty Service matches any value
+type ServiceFilter struct {
+	Port    uint32
+	Service string
+}
+
+// matches reports whether r satisfies the filter
+func (f ServiceFilter) matches(r *ServiceRecord) bool {
+	return (f.Port == 0 || r.Port == f.Port) && (f.Service == "" || r.Service == f.Service)
+}
+
+// IPSummary groups the records found for a single IP
+type IPSummary struct {
+	IP      string
+	Records []*ServiceRecord
+}
+
+// ListResult is a page of records together with the number of records
+// across all pages
+type ListResult struct {
+	Records    []*ServiceRecord
+	TotalCount int64
+}
+
+// StoreStats is an aggregate summary of the records in a store
+type StoreStats struct {
+	TotalRecords     int64
+	RecordsByService map[string]int64
+	RecordsByPort    map[uint32]int64
+	OldestTimestamp  int64
+	NewestTimestamp  int64
 }
 
 // Store defines the interface for scan data persistence
+// IPs may be given in any spelling; stores keep and match their canonical
+// form, see CanonicalIP
 type Store interface {
 	// Upsert inserts or updates a record if the timestamp is newer
 	// Returns true if the record was inserted/updated, false if skipped (older timestamp)
 	Upsert(ctx context.Context, record *ServiceRecord) (bool, error)
 
+	// BulkUpsert applies Upsert semantics to a batch of records
+	// Returns the number of records that were inserted/updated (not skipped)
+	BulkUpsert(ctx context.Context, records []*ServiceRecord) (int, error)
+
+	// UpdateIfUnchanged replaces a record only if its LastTimestamp still
+	// equals expectedTimestamp, for optimistic read-modify-write
+	// Returns false, nil if the record changed, does not exist or is deleted
+	// The record should carry a newer LastTimestamp so later calls see the
+	// change
+	UpdateIfUnchanged(ctx context.Context, record *ServiceRecord, expectedTimestamp int64) (bool, error)
+
 	// Get retrieves a record by its composite key
 	// Returns nil, nil if not found
 	Get(ctx context.Context, ip string, port uint32, service string) (*ServiceRecord, error)
 
-	// List returns all records with optional pagination
+	// GetMulti retrieves the records for several keys in a single call
+	// Records are keyed by their stored key, with the IP in canonical form,
+	// and keys without a record are absent from the returned map
+	GetMulti(ctx context.Context, keys []RecordKey) (map[RecordKey]*ServiceRecord, error)
+
+	// List returns all records with optional pagination, newest first and
+	// then by ip, port and service
 	// Use limit=0 to return all records
 	List(ctx context.Context, limit, offset int) ([]*ServiceRecord, error)
 
+	// ListV2 returns the same page as List together with the number of live
+	// records, both read from one consistent view of the store
+	// It is the transition name for List, which will return a ListResult in
+	// the next major version
+	ListV2(ctx context.Context, limit, offset int) (*ListResult, error)
+
+	// ListByIP returns all records for the given IP address
+	ListByIP(ctx context.Context, ip string) ([]*ServiceRecord, error)
+
+	// ListByService returns records for the given service with optional pagination
+	// Use limit=0 to return all matching records
+	ListByService(ctx context.Context, service string, limit, offset int) ([]*ServiceRecord, error)
+
+	// ListByPort returns records for the given port with optional pagination
+	// Use limit=0 to return all matching records
+	ListByPort(ctx context.Context, port uint32, limit, offset int) ([]*ServiceRecord, error)
+
+	// ListByTimestampRange returns records with from <= last_timestamp <= to
+	// A zero from or to leaves that end of the range open
+	// Use limit=0 to return all matching records
+	ListByTimestampRange(ctx context.Context, from, to int64, limit, offset int) ([]*ServiceRecord, error)
+
+	// ListByCIDR returns records whose IP falls within the given CIDR range
+	// Returns ErrInvalidCIDR if cidr cannot be parsed
+	// Use limit=0 to return all matching records
+	ListByCIDR(ctx context.Context, cidr string, limit, offset int) ([]*ServiceRecord, error)
+
+	// SearchByResponse returns records whose response contains query,
+	// ignoring case, ordered by last_timestamp descending
+	// Use limit=0 to return all matching records
+	SearchByResponse(ctx context.Context, query string, limit, offset int) ([]*ServiceRecord, error)
+
+	// ListAfter returns records ordered by (last_timestamp DESC, ip ASC,
+	// port ASC, service ASC) that come strictly after the given cursor
+	// position (keyset pagination)
+	// Use the zero Cursor to start from the first page
+	// Use limit=0 to return all remaining records
+	ListAfter(ctx context.Context, after Cursor, limit int) ([]*ServiceRecord, error)
+
+	// ListDistinctIPs returns the unique IP addresses of all records, in
+	// ascending string order, with optional pagination
+	// Use limit=0 to return all IPs
+	ListDistinctIPs(ctx context.Context, limit, offset int) ([]string, error)
+
+	// CountDistinctIPs returns the number of unique IP addresses in the store
+	CountDistinctIPs(ctx context.Context) (int64, error)
+
+	// ListDistinctServices returns the unique service names of all records
+	// in ascending order
+	ListDistinctServices(ctx context.Context) ([]string, error)
+
+	// ListDistinctPorts returns the unique ports of all records in ascending
+	// order
+	ListDistinctPorts(ctx context.Context) ([]uint32, error)
+
+	// FindCoOccurrence returns the IPs that have a record matching every
+	// filter, ordered by IP, each with the records that matched a filter
+	// ordered by port and service
+	// Returns an empty slice when nothing matches or services is empty
+	FindCoOccurrence(ctx context.Context, services []ServiceFilter) ([]*IPSummary, error)
+
+	// ListChangedSince returns records with last_timestamp > timestamp whose
+	// last update replaced a different response hash, ordered by
+	// (last_timestamp DESC, ip ASC)
+	// Newly inserted records have no previous hash and are not included
+	ListChangedSince(ctx context.Context, timestamp int64) ([]*ServiceRecord, error)
+
+	// Delete soft-deletes a record by its composite key, hiding it from
+	// every read except ListDeleted
+	// Newer upserts update a deleted record without restoring it
+	// Returns true if the record was deleted, false if not found or already deleted
+	Delete(ctx context.Context, ip string, port uint32, service string) (bool, error)
+
+	// Undelete restores a soft-deleted record
+	// Returns true if the record was restored, false if not found or not deleted
+	Undelete(ctx context.Context, ip string, port uint32, service string) (bool, error)
+
+	// ListDeleted returns soft-deleted records, most recently deleted first
+	// Use limit=0 to return all deleted records
+	ListDeleted(ctx context.Context, limit, offset int) ([]*ServiceRecord, error)
+
+	// DeleteOlderThan permanently removes records, including soft-deleted
+	// ones, with last_timestamp < beforeTimestamp
+	// Returns the number of records removed
+	DeleteOlderThan(ctx context.Context, beforeTimestamp int64) (int64, error)
+
+	// Count returns the total number of records in the store
+	Count(ctx context.Context) (int64, error)
+
+	// PurgeExpired removes records whose ExpiresAt is set and in the past
+	// Returns the number of records removed
+	PurgeExpired(ctx context.Context) (int64, error)
+
+	// Stats returns aggregate counts for the store
+	// Oldest/NewestTimestamp are 0 when the store is empty
+	Stats(ctx context.Context) (*StoreStats, error)
+
+	// Watch returns a channel of record change events
+	// The channel is closed when ctx is done, Unwatch is called, or the store
+	// is closed; slow watchers miss events rather than blocking writers
+	Watch(ctx context.Context) (<-chan StoreEvent, error)
+
+	// Unwatch stops delivery to a channel returned by Watch and closes it
+	Unwatch(ch <-chan StoreEvent)
+
+	// HealthCheck returns an error if the store's backend is unreachable
+	HealthCheck(ctx context.Context) error
+
 	// Close releases any resources held by the store
 	Close() error
 }
@@ -41,9 +298,373 @@ func NewStore(storeType, connectionString string) (Store, error) {
 		return NewSQLiteStore(connectionString)
 	case "memory":
 		return NewMemoryStore(), nil
+	case "memory-persistent":
+		return NewPersistentMemoryStore(connectionString)
 	case "postgres":
 		return NewPostgresStore(connectionString)
+	case "pgx":
+		return NewPostgresStoreWithPool(context.Background(), connectionString, nil)
+	case "mysql":
+		return NewMySQLStore(connectionString)
+	case "badger":
+		return NewBadgerStore(connectionString)
+	case "redis":
+		return newRedisStoreFromURL(connectionString)
 	default:
 		return nil, fmt.Errorf("unknown store type: %s", storeType)
 	}
-}
\ No newline at end of file
+}
+
+// dedupeRecords keeps only the newest record for each composite key, with
+// its IP in canonical form
+// A single SQL upsert statement cannot touch the same row twice
+func dedupeRecords(records []*ServiceRecord) []*ServiceRecord {
+	latest := make(map[string]int, len(records))
+	deduped := make([]*ServiceRecord, 0, len(records))
+	for _, r := range records {
+		r = canonicalRecord(r)
+		key := makeKey(r.IP.String(), r.Port, r.Service)
+		if i, exists := latest[key]; exists {
+			if r.LastTimestamp > deduped[i].LastTimestamp {
+				deduped[i] = r
+			}
+			continue
+		}
+		latest[key] = len(deduped)
+		deduped = append(deduped, r)
+	}
+	return deduped
+}
+
+// unwrapStore follows Unwrap through wrapping stores to the store that
+// holds the records
+func unwrapStore(s Store) Store {
+	for {
+		w, ok := s.(interface{ Unwrap() Store })
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
+
+// paginate applies offset/limit to an already sorted slice
+// Use limit=0 to return all items after offset
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+
+	items = items[offset:]
+
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+
+	return items
+}
+
+// distinct returns the unique values of key over records in ascending
+// order, matching ORDER BY in the SQL stores
+func distinct[T cmp.Ordered](records []*ServiceRecord, key func(*ServiceRecord) T) []T {
+	seen := make(map[T]struct{}, len(records))
+	values := make([]T, 0, len(records))
+	for _, r := range records {
+		v := key(r)
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		values = append(values, v)
+	}
+	slices.Sort(values)
+	return values
+}
+
+// recordIP, recordService and recordPort select a field for distinct
+func recordIP(r *ServiceRecord) string      { return r.IP.String() }
+func recordService(r *ServiceRecord) string { return r.Service }
+func recordPort(r *ServiceRecord) uint32    { return r.Port }
+
+// coOccurring groups records by IP and keeps the IPs that have a record
+// matching every filter, with only their matching records
+// Used by the stores that filter in Go
+func coOccurring(records []*ServiceRecord, filters []ServiceFilter) []*IPSummary {
+	if len(filters) == 0 {
+		return []*IPSummary{}
+	}
+
+	var matching []*ServiceRecord
+	byIP := make(map[string][]*ServiceRecord)
+	for _, r := range records {
+		for _, f := range filters {
+			if f.matches(r) {
+				byIP[r.IP.String()] = append(byIP[r.IP.String()], r)
+				break
+			}
+		}
+	}
+	for _, group := range byIP {
+		if matchesAll(group, filters) {
+			matching = append(matching, group...)
+		}
+	}
+	return groupByIP(matching)
+}
+
+// matchesAll reports whether every filter matches at least one record
+func matchesAll(records []*ServiceRecord, filters []ServiceFilter) bool {
+	for _, f := range filters {
+		if !slices.ContainsFunc(records, f.matches) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupByIP groups records into summaries ordered by IP, with each
+// summary's records ordered by port and service
+func groupByIP(records []*ServiceRecord) []*IPSummary {
+	slices.SortFunc(records, func(a, b *ServiceRecord) int {
+		return cmp.Or(cmp.Compare(a.IP, b.IP), cmp.Compare(a.Port, b.Port), cmp.Compare(a.Service, b.Service))
+	})
+
+	summaries := []*IPSummary{}
+	for _, r := range records {
+		if n := len(summaries); n == 0 || summaries[n-1].IP != r.IP.String() {
+			summaries = append(summaries, &IPSummary{IP: r.IP.String()})
+		}
+		last := summaries[len(summaries)-1]
+		last.Records = append(last.Records, r)
+	}
+	return summaries
+}
+
+// coOccurrenceQuery builds the FindCoOccurrence query for the SQL stores,
+// numbering parameters with placeholder
+// The IP subquery joins one copy of the table per filter on ip, so only IPs
+// with a record matching every filter remain; filters must not be empty
+func coOccurrenceQuery(filters []ServiceFilter, placeholder func(n int) string) (string, []interface{}) {
+	var args []interface{}
+	condition := func(alias string, f ServiceFilter) string {
+		conditions := []string{alias + "deleted_at IS NULL"}
+		if f.Port != 0 {
+			args = append(args, f.Port)
+			conditions = append(conditions, alias+"port = "+placeholder(len(args)))
+		}
+		if f.Service != "" {
+			args = append(args, f.Service)
+			conditions = append(conditions, alias+"service = "+placeholder(len(args)))
+		}
+		return strings.Join(conditions, " AND ")
+	}
+
+	// Conditions are built in the order they appear so ? placeholders line
+	// up with args
+	anyFilter := make([]string, len(filters))
+	for i, f := range filters {
+		anyFilter[i] = "(" + condition("", f) + ")"
+	}
+	query := `
+		SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+		FROM service_records
+		WHERE (` + strings.Join(anyFilter, " OR ") + `)
+		AND ip IN (SELECT f0.ip FROM service_records f0`
+	for i, f := range filters[1:] {
+		alias := fmt.Sprintf("f%d", i+1)
+		query += fmt.Sprintf(" JOIN service_records %s ON %s.ip = f0.ip AND %s", alias, alias, condition(alias+".", f))
+	}
+	query += ` WHERE ` + condition("f0.", filters[0]) + `)`
+
+	return query, args
+}
+
+// compareListOrder orders records by (LastTimestamp DESC, IP ASC, Port ASC,
+// Service ASC), matching List in the SQL stores
+// The composite key is unique, so records with equal timestamps always come
+// back in the same order
+func compareListOrder(a, b *ServiceRecord) int {
+	return cmp.Or(
+		cmp.Compare(b.LastTimestamp, a.LastTimestamp),
+		cmp.Compare(a.IP, b.IP),
+		cmp.Compare(a.Port, b.Port),
+		cmp.Compare(a.Service, b.Service),
+	)
+}
+
+// hashChanged reports whether a record's last update replaced a different
+// response hash, matching the ListChangedSince filter of the SQL stores
+func hashChanged(r *ServiceRecord) bool {
+	return r.PreviousResponseHash != "" && r.ResponseHash != r.PreviousResponseHash
+}
+
+// sqlQuerier is satisfied by both *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// ctxError returns ctx's error in place of err once ctx is done
+// Drivers report a query cancelled mid-flight in their own terms, such as
+// SQLite's "interrupted", so callers could not otherwise match
+// context.Canceled
+func ctxError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// queryRecords runs a query returning service_records rows and scans them
+// Shared by the SQL-backed stores; the query must select the standard columns
+func queryRecords(ctx context.Context, db sqlQuerier, query string, args ...interface{}) ([]*ServiceRecord, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", ctxError(ctx, err))
+	}
+	defer rows.Close()
+
+	var records []*ServiceRecord
+	for rows.Next() {
+		r, err := scanServiceRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan record: %w", ctxError(ctx, err))
+		}
+		records = append(records, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating records: %w", ctxError(ctx, err))
+	}
+
+	return records, nil
+}
+
+// listWithTotal counts the live records and runs the page query in a
+// single transaction, so the total matches the page it is returned with
+// Shared by the database/sql stores; opts must give the transaction one
+// snapshot for both queries
+func listWithTotal(ctx context.Context, db *sql.DB, opts *sql.TxOptions, query string, args ...interface{}) (*ListResult, error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", ctxError(ctx, err))
+	}
+	defer tx.Rollback()
+
+	result := &ListResult{}
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM service_records WHERE deleted_at IS NULL`).Scan(&result.TotalCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count records: %w", ctxError(ctx, err))
+	}
+	if result.Records, err = queryRecords(ctx, tx, query, args...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
+
+// queryColumn runs a query returning a single column and scans it
+// Shared by the SQL-backed stores
+func queryColumn[T any](ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query values: %w", ctxError(ctx, err))
+	}
+	defer rows.Close()
+
+	values := []T{}
+	for rows.Next() {
+		var v T
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("failed to scan value: %w", ctxError(ctx, err))
+		}
+		values = append(values, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating values: %w", ctxError(ctx, err))
+	}
+
+	return values, nil
+}
+
+// getMultiChunkSize bounds keys per GetMulti statement to stay below the
+// bound-parameter limits (3 parameters per key)
+const getMultiChunkSize = 500
+
+// getMulti fetches the live records for keys with row-value IN queries
+// Shared by SQLite, which lists the rows with valuesPrefix "VALUES ", and
+// MySQL, which lists them bare
+func getMulti(ctx context.Context, db *sql.DB, keys []RecordKey, valuesPrefix string) (map[RecordKey]*ServiceRecord, error) {
+	keys = canonicalKeys(keys)
+	found := make(map[RecordKey]*ServiceRecord, len(keys))
+	for start := 0; start < len(keys); start += getMultiChunkSize {
+		chunk := keys[start:min(start+getMultiChunkSize, len(keys))]
+
+		placeholders := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*3)
+		for i, k := range chunk {
+			placeholders[i] = "(?, ?, ?)"
+			args = append(args, k.IP, k.Port, k.Service)
+		}
+
+		records, err := queryRecords(ctx, db, `
+			SELECT ip, port, service, last_timestamp, response, updated_at, first_seen_at, scan_count, previous_response, response_hash, previous_response_hash, response_truncated, tls_version, status_code, expires_at, deleted_at, pubsub_message_id, pubsub_subscription_id, pubsub_publish_time
+			FROM service_records
+			WHERE (ip, port, service) IN (`+valuesPrefix+strings.Join(placeholders, ", ")+`) AND deleted_at IS NULL
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			found[r.Key()] = r
+		}
+	}
+	return found, nil
+}
+
+// likeEscaper escapes LIKE wildcards so user input matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes s for use in a LIKE pattern with ESCAPE '\'
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanServiceRecord scans the columns selected by the SQL stores, mapping
+// NULL optional columns to zero values
+func scanServiceRecord(row rowScanner) (*ServiceRecord, error) {
+	var r ServiceRecord
+	var tlsVersion sql.NullString
+	var statusCode sql.NullInt64
+	var firstSeenAt, expiresAt, deletedAt, publishTime sql.NullTime
+	var messageID, subscriptionID sql.NullString
+	err := row.Scan(&r.IP, &r.Port, &r.Service, &r.LastTimestamp, &r.Response, &r.UpdatedAt, &firstSeenAt, &r.ScanCount, &r.PreviousResponse, &r.ResponseHash, &r.PreviousResponseHash, &r.ResponseTruncated,
+		&tlsVersion, &statusCode, &expiresAt, &deletedAt, &messageID, &subscriptionID, &publishTime)
+	if err != nil {
+		return nil, err
+	}
+	r.TLSVersion = tlsVersion.String
+	r.StatusCode = int(statusCode.Int64)
+	r.FirstSeenAt = firstSeenAt.Time
+	if expiresAt.Valid {
+		r.ExpiresAt = &expiresAt.Time
+	}
+	if deletedAt.Valid {
+		r.DeletedAt = &deletedAt.Time
+	}
+	if messageID.Valid {
+		r.MessageMetadata = &MessageMetadata{
+			MessageID:      messageID.String,
+			SubscriptionID: subscriptionID.String,
+			PublishTime:    publishTime.Time,
+		}
+	}
+	return &r, nil
+}
@@ -1,10 +1,25 @@
 package store
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // TestMemoryStore tests the in-memory store implementation
@@ -34,6 +49,600 @@ func TestSQLiteStore(t *testing.T) {
 	runStoreTests(t, store)
 }
 
+// TestSQLiteListCancel tests that cancelling the context while List reads a
+// large database stops the query with context.Canceled
+func TestSQLiteListCancel(t *testing.T) {
+	s, err := NewSQLiteStore(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer s.Close()
+
+	for from := 0; from < 50000; from += 1000 {
+		batch := make([]*ServiceRecord, 1000)
+		for i := range batch {
+			n := from + i
+			batch[i] = &ServiceRecord{
+				IP:            IPAddress(fmt.Sprintf("10.%d.%d.%d", n>>16&0xff, n>>8&0xff, n&0xff)),
+				Port:          80,
+				Service:       "HTTP",
+				LastTimestamp: int64(n + 1),
+				Response:      "response",
+			}
+		}
+		if _, err := s.BulkUpsert(context.Background(), batch); err != nil {
+			t.Fatalf("BulkUpsert failed: %v", err)
+		}
+	}
+
+	// Cancel while the first List is reading rows; should a List still
+	// finish first, the next one fails on the cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	for err == nil {
+		_, err = s.List(ctx, 0, 0)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// TestMemoryListCancel tests that List on a MemoryStore reports a cancelled
+// context instead of sorting and paginating
+func TestMemoryListCancel(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if records, err := s.List(ctx, 10, 0); !errors.Is(err, context.Canceled) || records != nil {
+		t.Errorf("Expected context.Canceled and no records, got %v, %v", records, err)
+	}
+	if _, err := s.ListV2(ctx, 10, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from ListV2, got %v", err)
+	}
+}
+
+// TestBadgerStore tests the Badger store implementation
+func TestBadgerStore(t *testing.T) {
+	store, err := NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create Badger store: %v", err)
+	}
+	defer store.Close()
+
+	runStoreTests(t, store)
+}
+
+// TestRedisStore tests the Redis store implementation against miniredis
+func TestRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	store, err := NewRedisStore(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("Failed to create Redis store: %v", err)
+	}
+	defer store.Close()
+
+	runStoreTests(t, store)
+}
+
+// TestNewStoreRedisURL tests that the redis store type accepts a Redis URL
+func TestNewStoreRedisURL(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	s, err := NewStore("redis", "redis://"+mr.Addr()+"/0")
+	if err != nil {
+		t.Fatalf("Failed to create Redis store from URL: %v", err)
+	}
+	defer s.Close()
+
+	if _, err := NewStore("redis", "not a url"); err == nil {
+		t.Error("Expected error for malformed Redis URL")
+	}
+}
+
+// TestSQLiteIndexMigration tests that new indexes are added to existing databases
+func TestSQLiteIndexMigration(t *testing.T) {
+	path := t.TempDir() + "/existing.db"
+
+	// Simulate a database created before the ip index existed
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE service_records (
+			ip            TEXT NOT NULL,
+			port          INTEGER NOT NULL,
+			service       TEXT NOT NULL,
+			last_timestamp INTEGER NOT NULL,
+			response      TEXT NOT NULL,
+			updated_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (ip, port, service)
+		)
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open existing database: %v", err)
+	}
+	defer store.Close()
+
+	var name string
+	err = store.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'index' AND name = 'idx_ip'`).Scan(&name)
+	if err != nil {
+		t.Fatalf("Expected idx_ip to be created on existing database: %v", err)
+	}
+}
+
+// TestSQLiteColumnMigration tests that optional V3 columns are added to
+// existing databases and that legacy rows read back with zero values
+func TestSQLiteColumnMigration(t *testing.T) {
+	path := t.TempDir() + "/existing.db"
+
+	// Simulate a database created before tls_version and status_code existed
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE service_records (
+			ip            TEXT NOT NULL,
+			port          INTEGER NOT NULL,
+			service       TEXT NOT NULL,
+			last_timestamp INTEGER NOT NULL,
+			response      TEXT NOT NULL,
+			updated_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (ip, port, service)
+		);
+		INSERT INTO service_records (ip, port, service, last_timestamp, response)
+		VALUES ('1.1.1.1', 80, 'HTTP', 1000, 'legacy');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+
+	// Opening twice checks the migration is idempotent
+	for i := 0; i < 2; i++ {
+		store, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatalf("Failed to open existing database: %v", err)
+		}
+		store.Close()
+	}
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open existing database: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	legacy, err := store.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if legacy == nil || legacy.Response != "legacy" || legacy.TLSVersion != "" || legacy.StatusCode != 0 || legacy.ExpiresAt != nil || legacy.MessageMetadata != nil {
+		t.Errorf("Expected legacy record with empty V3 fields, got %+v", legacy)
+	}
+	if legacy != nil && !legacy.FirstSeenAt.Equal(legacy.UpdatedAt) {
+		t.Errorf("Expected first_seen_at backfilled from updated_at, got %v and %v", legacy.FirstSeenAt, legacy.UpdatedAt)
+	}
+
+	r := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "new", TLSVersion: "TLSv1.2", StatusCode: 301}
+	if _, err := store.Upsert(ctx, r); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	updated, err := store.Get(ctx, "1.1.1.1", 80, "HTTP")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if updated.TLSVersion != "TLSv1.2" || updated.StatusCode != 301 {
+		t.Errorf("Expected TLSv1.2/301, got %s/%d", updated.TLSVersion, updated.StatusCode)
+	}
+}
+
+// TestSQLiteSchemaMigrations tests that a database with the original schema
+// is brought up to date and that every migration is recorded once
+func TestSQLiteSchemaMigrations(t *testing.T) {
+	path := t.TempDir() + "/v0.db"
+
+	// The v0 schema predates first_seen_at and schema_migrations
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE service_records (
+			ip            TEXT NOT NULL,
+			port          INTEGER NOT NULL,
+			service       TEXT NOT NULL,
+			last_timestamp INTEGER NOT NULL,
+			response      TEXT NOT NULL,
+			updated_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (ip, port, service)
+		)
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to create v0 table: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		store, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatalf("Failed to open v0 database: %v", err)
+		}
+
+		var count int
+		err = store.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('service_records') WHERE name = 'first_seen_at'`).Scan(&count)
+		if err != nil || count != 1 {
+			t.Errorf("Expected first_seen_at column after migration, got count %d (err %v)", count, err)
+		}
+
+		versions, err := store.MigrationsApplied(ctx)
+		if err != nil {
+			t.Fatalf("MigrationsApplied failed: %v", err)
+		}
+		if len(versions) != len(sqliteMigrations) {
+			t.Fatalf("Expected %d migrations applied, got %v", len(sqliteMigrations), versions)
+		}
+		for j, m := range sqliteMigrations {
+			if versions[j] != m.Version {
+				t.Errorf("Expected version %d at position %d, got %d", m.Version, j, versions[j])
+			}
+		}
+		store.Close()
+	}
+}
+
+// TestApplyMigrationsError tests that a failing migration reports its
+// version and is not recorded
+func TestApplyMigrationsError(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	migrations := []Migration{
+		{Version: 1, SQL: `CREATE TABLE t (id INTEGER)`},
+		{Version: 2, SQL: `ALTER TABLE missing ADD COLUMN x TEXT`},
+	}
+	err = applyMigrations(ctx, db, migrations, sqliteMigrationDialect)
+	if err == nil || !strings.Contains(err.Error(), "migration 2") {
+		t.Fatalf("Expected error naming migration 2, got %v", err)
+	}
+
+	versions, err := migrationsApplied(ctx, db)
+	if err != nil {
+		t.Fatalf("migrationsApplied failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0] != 1 {
+		t.Errorf("Expected only migration 1 recorded, got %v", versions)
+	}
+}
+
+// testCanonicalIPMigration writes rows with non-canonical IPs straight into
+// the database of a store from open, forgets migration version and checks
+// that reopening the store rewrites them, keeping the newest of colliding
+// spellings
+func testCanonicalIPMigration(t *testing.T, version int, open func() (Store, *sql.DB)) {
+	ctx := context.Background()
+	s, db := open()
+	_, err := db.Exec(`
+		INSERT INTO service_records (ip, port, service, last_timestamp, response, previous_response) VALUES
+			('::FFFF:1.2.3.4', 80, 'HTTP', 1000, 'mapped', ''),
+			('2001:DB8::1', 22, 'SSH', 2000, 'newer', ''),
+			('2001:db8::1', 22, 'SSH', 1000, 'older', ''),
+			('2001:0db8::2', 443, 'HTTPS', 1000, 'older', ''),
+			('2001:db8::2', 443, 'HTTPS', 2000, 'newer', '')
+	`)
+	if err != nil {
+		t.Fatalf("Failed to insert records: %v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`DELETE FROM schema_migrations WHERE version = %d`, version)); err != nil {
+		t.Fatalf("Failed to forget migration: %v", err)
+	}
+	s.Close()
+
+	s, _ = open()
+	defer s.Close()
+
+	if count, err := s.Count(ctx); err != nil || count != 3 {
+		t.Errorf("Expected 3 records after merging spellings, got %d (err %v)", count, err)
+	}
+	tests := []struct {
+		key      RecordKey
+		response string
+	}{
+		{RecordKey{IP: "1.2.3.4", Port: 80, Service: "HTTP"}, "mapped"},
+		{RecordKey{IP: "2001:db8::1", Port: 22, Service: "SSH"}, "newer"},
+		{RecordKey{IP: "2001:db8::2", Port: 443, Service: "HTTPS"}, "newer"},
+	}
+	for _, tt := range tests {
+		got, err := s.Get(ctx, tt.key.IP, tt.key.Port, tt.key.Service)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got == nil || got.Response != tt.response {
+			t.Errorf("Expected %v with response %q, got %+v", tt.key, tt.response, got)
+		}
+	}
+}
+
+// TestSQLiteCanonicalIPMigration tests that SQLite databases written before
+// IPs were canonicalized are rewritten on open
+func TestSQLiteCanonicalIPMigration(t *testing.T) {
+	path := t.TempDir() + "/test.db"
+	testCanonicalIPMigration(t, 18, func() (Store, *sql.DB) {
+		s, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatalf("Failed to create SQLite store: %v", err)
+		}
+		return s, s.db
+	})
+}
+
+// TestSQLiteBackup tests that Backup and BackupToWriter produce databases
+// holding every record
+func TestSQLiteBackup(t *testing.T) {
+	s, err := NewSQLiteStore(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	const total = 100
+	for i := 0; i < total; i++ {
+		_, err := s.Upsert(ctx, &ServiceRecord{
+			IP:            IPAddress(fmt.Sprintf("10.0.0.%d", i)),
+			Port:          80,
+			Service:       "HTTP",
+			LastTimestamp: 1000,
+			Response:      fmt.Sprintf("response %d", i),
+		})
+		if err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+
+	// checkBackup opens a backup as a separate store and verifies its records
+	checkBackup := func(t *testing.T, path string) {
+		backup, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatalf("Failed to open backup: %v", err)
+		}
+		defer backup.Close()
+
+		count, err := backup.Count(ctx)
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		if count != total {
+			t.Errorf("Expected %d records in backup, got %d", total, count)
+		}
+		r, err := backup.Get(ctx, "10.0.0.42", 80, "HTTP")
+		if err != nil || r == nil || r.Response != "response 42" {
+			t.Errorf("Expected record 42 in backup, got %+v (err %v)", r, err)
+		}
+	}
+
+	t.Run("Backup", func(t *testing.T) {
+		path := t.TempDir() + "/backup.db"
+		if err := s.Backup(ctx, path); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		checkBackup(t, path)
+	})
+
+	t.Run("BackupToWriter", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := s.BackupToWriter(ctx, &buf); err != nil {
+			t.Fatalf("BackupToWriter failed: %v", err)
+		}
+		path := t.TempDir() + "/streamed.db"
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+		checkBackup(t, path)
+	})
+}
+
+// TestExportImportJSON tests that records exported from one store and
+// imported into another match, across several export pages and import
+// batches
+func TestExportImportJSON(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryStore()
+	defer src.Close()
+
+	const total = 1234
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	for i := 0; i < total; i++ {
+		r := &ServiceRecord{
+			IP:            IPAddress(fmt.Sprintf("10.0.%d.%d", i/256, i%256)),
+			Port:          uint32(80 + i%3),
+			Service:       "HTTP",
+			LastTimestamp: int64(1000 + i),
+			Response:      fmt.Sprintf("response %d\nwith \"quotes\"", i),
+		}
+		if i%2 == 0 {
+			r.TLSVersion = "TLSv1.3"
+			r.StatusCode = 200
+			r.ExpiresAt = &expires
+		}
+		r.ResponseHash = HashResponse(r.Response)
+		if _, err := src.Upsert(ctx, r); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+	// Soft-deleted records are not exported
+	if _, err := src.Upsert(ctx, &ServiceRecord{IP: "192.168.0.1", Port: 22, Service: "SSH", LastTimestamp: 1, Response: "deleted"}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if _, err := src.Delete(ctx, "192.168.0.1", 22, "SSH"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportJSON(ctx, src, &buf); err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != total {
+		t.Errorf("Expected %d exported lines, got %d", total, lines)
+	}
+
+	dst := NewMemoryStore()
+	defer dst.Close()
+	imported, err := ImportJSON(ctx, dst, &buf)
+	if err != nil {
+		t.Fatalf("ImportJSON failed: %v", err)
+	}
+	if imported != total {
+		t.Errorf("Expected %d records imported, got %d", total, imported)
+	}
+
+	want, _ := src.List(ctx, 0, 0)
+	got, _ := dst.List(ctx, 0, 0)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d records, got %d", len(want), len(got))
+	}
+	for i := range want {
+		w, g := want[i], got[i]
+		if g.IP != w.IP || g.Port != w.Port || g.Service != w.Service ||
+			g.LastTimestamp != w.LastTimestamp || g.Response != w.Response ||
+			g.ResponseHash != w.ResponseHash || g.TLSVersion != w.TLSVersion ||
+			g.StatusCode != w.StatusCode || (g.ExpiresAt == nil) != (w.ExpiresAt == nil) ||
+			(g.ExpiresAt != nil && !g.ExpiresAt.Equal(*w.ExpiresAt)) {
+			t.Fatalf("Record %d differs: expected %+v, got %+v", i, w, g)
+		}
+	}
+}
+
+// TestImportJSONInvalid tests that ImportJSON reports which record failed
+// to decode and keeps the records before it
+func TestImportJSONInvalid(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	input := `{"IP":"1.1.1.1","Port":80,"Service":"HTTP","LastTimestamp":1000,"Response":"ok"}` + "\n" + `{"IP":` + "\n"
+	imported, err := ImportJSON(context.Background(), s, strings.NewReader(input))
+	if err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Errorf("Expected error decoding record 2, got %v", err)
+	}
+	if imported != 1 || s.Len() != 1 {
+		t.Errorf("Expected 1 record imported, got %d (store has %d)", imported, s.Len())
+	}
+}
+
+// TestExportImportCSV tests that records survive a CSV round trip, including
+// responses with commas, quotes and newlines
+// encoding/csv reads \r\n inside a field as \n, so responses use bare
+// newlines
+func TestExportImportCSV(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryStore()
+	defer src.Close()
+
+	const total = 1000
+	for i := 0; i < total; i++ {
+		_, err := src.Upsert(ctx, &ServiceRecord{
+			IP:            IPAddress(fmt.Sprintf("10.0.%d.%d", i/256, i%256)),
+			Port:          uint32(80 + i%3),
+			Service:       "HTTP",
+			LastTimestamp: int64(1000 + i),
+			Response:      fmt.Sprintf("HTTP/1.1 200 OK\nServer: a, b\nX-Id: \"%d\"", i),
+		})
+		if err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ExportCSV(ctx, src, &buf); err != nil {
+		t.Fatalf("ExportCSV failed: %v", err)
+	}
+	if header, _, _ := strings.Cut(buf.String(), "\n"); header != "ip,port,service,last_timestamp,response,updated_at,first_seen_at,scan_count" {
+		t.Errorf("Unexpected header %q", header)
+	}
+
+	dst := NewMemoryStore()
+	defer dst.Close()
+	imported, err := ImportCSV(ctx, dst, &buf)
+	if err != nil {
+		t.Fatalf("ImportCSV failed: %v", err)
+	}
+	if imported != total {
+		t.Errorf("Expected %d records imported, got %d", total, imported)
+	}
+
+	want, _ := src.List(ctx, 0, 0)
+	got, _ := dst.List(ctx, 0, 0)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d records, got %d", len(want), len(got))
+	}
+	for i := range want {
+		w, g := want[i], got[i]
+		if g.IP != w.IP || g.Port != w.Port || g.Service != w.Service ||
+			g.LastTimestamp != w.LastTimestamp || g.Response != w.Response {
+			t.Fatalf("Record %d differs: expected %+v, got %+v", i, w, g)
+		}
+	}
+}
+
+// TestImportCSVColumns tests that ImportCSV matches columns by name,
+// tolerates missing optional columns and rejects missing required ones
+func TestImportCSVColumns(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("OptionalMissing", func(t *testing.T) {
+		s := NewMemoryStore()
+		defer s.Close()
+
+		input := "service,last_timestamp,ip,port\nSSH,1000,1.1.1.1,22\n"
+		if _, err := ImportCSV(ctx, s, strings.NewReader(input)); err != nil {
+			t.Fatalf("ImportCSV failed: %v", err)
+		}
+		r, err := s.Get(ctx, "1.1.1.1", 22, "SSH")
+		if err != nil || r == nil || r.LastTimestamp != 1000 || r.Response != "" {
+			t.Errorf("Expected imported record, got %+v (err %v)", r, err)
+		}
+	})
+
+	t.Run("RequiredMissing", func(t *testing.T) {
+		s := NewMemoryStore()
+		defer s.Close()
+
+		_, err := ImportCSV(ctx, s, strings.NewReader("ip,port,service\n1.1.1.1,22,SSH\n"))
+		if err == nil || !strings.Contains(err.Error(), "last_timestamp") {
+			t.Errorf("Expected missing last_timestamp error, got %v", err)
+		}
+	})
+
+	t.Run("InvalidPort", func(t *testing.T) {
+		s := NewMemoryStore()
+		defer s.Close()
+
+		_, err := ImportCSV(ctx, s, strings.NewReader("ip,port,service,last_timestamp\n1.1.1.1,http,SSH,1000\n"))
+		if err == nil || !strings.Contains(err.Error(), "invalid port") {
+			t.Errorf("Expected invalid port error, got %v", err)
+		}
+	})
+}
+
 // runStoreTests runs common tests for any Store implementation
 func runStoreTests(t *testing.T, s Store) {
 	ctx := context.Background()
@@ -227,29 +836,476 @@ func runStoreTests(t *testing.T, s Store) {
 			t.Errorf("Expected at least 1 record with offset, got %d", len(records2))
 		}
 	})
-}
 
-// TestMemoryStoreLen tests the Len helper method on MemoryStore
-func TestMemoryStoreLen(t *testing.T) {
-	store := NewMemoryStore()
-	defer store.Close()
+	t.Run("Bulk upsert", func(t *testing.T) {
+		records := []*ServiceRecord{
+			{IP: "7.7.7.7", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "bulk 1"},
+			{IP: "7.7.7.7", Port: 22, Service: "SSH", LastTimestamp: 1000, Response: "bulk 2"},
+			// Duplicate key within the batch - newest wins
+			{IP: "7.7.7.7", Port: 80, Service: "HTTP", LastTimestamp: 1500, Response: "bulk 1 newer"},
+			// Older than the existing 1.1.1.1:80/HTTP record (2000) - skipped
+			{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 100, Response: "stale"},
+		}
 
-	ctx := context.Background()
+		updated, err := s.BulkUpsert(ctx, records)
+		if err != nil {
+			t.Fatalf("BulkUpsert failed: %v", err)
+		}
+		if updated != 2 {
+			t.Errorf("Expected 2 records updated, got %d", updated)
+		}
 
-	if store.Len() != 0 {
-		t.Errorf("Expected empty store, got %d records", store.Len())
-	}
+		got, _ := s.Get(ctx, "7.7.7.7", 80, "HTTP")
+		if got == nil || got.Response != "bulk 1 newer" {
+			t.Errorf("Expected newest in-batch record to win, got %+v", got)
+		}
+		got, _ = s.Get(ctx, "1.1.1.1", 80, "HTTP")
+		if got == nil || got.Response != "newer response" {
+			t.Errorf("Expected stale bulk record to be skipped, got %+v", got)
+		}
 
-	store.Upsert(ctx, &ServiceRecord{
-		IP: "1.1.1.1", Port: 80, Service: "HTTP",
-		LastTimestamp: 1000, Response: "test",
+		updated, err = s.BulkUpsert(ctx, nil)
+		if err != nil {
+			t.Fatalf("BulkUpsert of empty batch failed: %v", err)
+		}
+		if updated != 0 {
+			t.Errorf("Expected 0 records updated for empty batch, got %d", updated)
+		}
 	})
 
-	if store.Len() != 1 {
-		t.Errorf("Expected 1 record, got %d", store.Len())
-	}
-}
-
+	t.Run("List by IP", func(t *testing.T) {
+		records := []*ServiceRecord{
+			{IP: "8.8.8.8", Port: 53, Service: "DNS", LastTimestamp: 1000, Response: "dns"},
+			{IP: "8.8.8.8", Port: 443, Service: "HTTPS", LastTimestamp: 2000, Response: "https"},
+			{IP: "8.8.4.4", Port: 53, Service: "DNS", LastTimestamp: 1000, Response: "other host"},
+		}
+		for _, r := range records {
+			if _, err := s.Upsert(ctx, r); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+		}
+
+		got, err := s.ListByIP(ctx, "8.8.8.8")
+		if err != nil {
+			t.Fatalf("ListByIP failed: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("Expected 2 records for 8.8.8.8, got %d", len(got))
+		}
+		for _, r := range got {
+			if r.IP != "8.8.8.8" {
+				t.Errorf("Expected only 8.8.8.8 records, got %s", r.IP)
+			}
+		}
+		if got[0].LastTimestamp < got[1].LastTimestamp {
+			t.Error("Expected records ordered by timestamp descending")
+		}
+	})
+
+	t.Run("List by service and port", func(t *testing.T) {
+		records := []*ServiceRecord{
+			{IP: "9.1.1.1", Port: 2323, Service: "TELNET", LastTimestamp: 3000, Response: "telnet 1"},
+			{IP: "9.1.1.2", Port: 2323, Service: "TELNET", LastTimestamp: 2000, Response: "telnet 2"},
+			{IP: "9.1.1.3", Port: 2323, Service: "TELNET", LastTimestamp: 1000, Response: "telnet 3"},
+			{IP: "9.1.1.1", Port: 8443, Service: "RDP", LastTimestamp: 1000, Response: "rdp"},
+		}
+		for _, r := range records {
+			if _, err := s.Upsert(ctx, r); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+		}
+
+		serviceTests := []struct {
+			name       string
+			service    string
+			limit      int
+			offset     int
+			expectedIP []string
+		}{
+			{"empty result", "GOPHER", 0, 0, nil},
+			{"single result", "RDP", 0, 0, []string{"9.1.1.1"}},
+			{"first page", "TELNET", 2, 0, []string{"9.1.1.1", "9.1.1.2"}},
+			{"second page", "TELNET", 2, 2, []string{"9.1.1.3"}},
+		}
+		for _, tt := range serviceTests {
+			got, err := s.ListByService(ctx, tt.service, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("%s: ListByService failed: %v", tt.name, err)
+			}
+			if len(got) != len(tt.expectedIP) {
+				t.Fatalf("%s: expected %d records, got %d", tt.name, len(tt.expectedIP), len(got))
+			}
+			for i, r := range got {
+				if r.IP.String() != tt.expectedIP[i] || r.Service != tt.service {
+					t.Errorf("%s: record %d: expected %s/%s, got %s/%s",
+						tt.name, i, tt.expectedIP[i], tt.service, r.IP, r.Service)
+				}
+			}
+		}
+
+		portTests := []struct {
+			name       string
+			port       uint32
+			limit      int
+			offset     int
+			expectedIP []string
+		}{
+			{"empty result", 7, 0, 0, nil},
+			{"single result", 8443, 0, 0, []string{"9.1.1.1"}},
+			{"first page", 2323, 2, 0, []string{"9.1.1.1", "9.1.1.2"}},
+			{"second page", 2323, 2, 2, []string{"9.1.1.3"}},
+		}
+		for _, tt := range portTests {
+			got, err := s.ListByPort(ctx, tt.port, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("%s: ListByPort failed: %v", tt.name, err)
+			}
+			if len(got) != len(tt.expectedIP) {
+				t.Fatalf("%s: expected %d records, got %d", tt.name, len(tt.expectedIP), len(got))
+			}
+			for i, r := range got {
+				if r.IP.String() != tt.expectedIP[i] || r.Port != tt.port {
+					t.Errorf("%s: record %d: expected %s:%d, got %s:%d",
+						tt.name, i, tt.expectedIP[i], tt.port, r.IP, r.Port)
+				}
+			}
+		}
+	})
+
+	t.Run("List by timestamp range", func(t *testing.T) {
+		const day = int64(24 * 60 * 60)
+		day1 := int64(1700000000)
+		day2 := day1 + day
+		day3 := day2 + day
+
+		records := []*ServiceRecord{
+			{IP: "11.0.0.1", Port: 80, Service: "HTTP", LastTimestamp: day1, Response: "day 1"},
+			{IP: "11.0.0.2", Port: 80, Service: "HTTP", LastTimestamp: day2, Response: "day 2 start"},
+			{IP: "11.0.0.3", Port: 80, Service: "HTTP", LastTimestamp: day2 + 3600, Response: "day 2 later"},
+			{IP: "11.0.0.4", Port: 80, Service: "HTTP", LastTimestamp: day3, Response: "day 3"},
+		}
+		for _, r := range records {
+			if _, err := s.Upsert(ctx, r); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+		}
+
+		tests := []struct {
+			name       string
+			from, to   int64
+			expectedIP []string
+		}{
+			{"middle day", day2, day3 - 1, []string{"11.0.0.3", "11.0.0.2"}},
+			{"from equals to", day2, day2, []string{"11.0.0.2"}},
+			{"open-ended upper bound", day2, 0, []string{"11.0.0.4", "11.0.0.3", "11.0.0.2"}},
+			{"empty window", day3 + 1, day3 + day, nil},
+		}
+		for _, tt := range tests {
+			got, err := s.ListByTimestampRange(ctx, tt.from, tt.to, 0, 0)
+			if err != nil {
+				t.Fatalf("%s: ListByTimestampRange failed: %v", tt.name, err)
+			}
+			if len(got) != len(tt.expectedIP) {
+				t.Fatalf("%s: expected %d records, got %d", tt.name, len(tt.expectedIP), len(got))
+			}
+			for i, r := range got {
+				if r.IP.String() != tt.expectedIP[i] {
+					t.Errorf("%s: record %d: expected %s, got %s", tt.name, i, tt.expectedIP[i], r.IP)
+				}
+			}
+		}
+
+		// Open-ended lower bound includes everything up to the end of day 1
+		got, err := s.ListByTimestampRange(ctx, 0, day1, 0, 0)
+		if err != nil {
+			t.Fatalf("ListByTimestampRange failed: %v", err)
+		}
+		if len(got) == 0 || got[0].IP != "11.0.0.1" {
+			t.Errorf("Expected day 1 record first for open lower bound, got %v", got)
+		}
+		for _, r := range got {
+			if r.LastTimestamp > day1 {
+				t.Errorf("Record %s outside range: %d", r.IP, r.LastTimestamp)
+			}
+		}
+	})
+
+	t.Run("List by CIDR", func(t *testing.T) {
+		records := []*ServiceRecord{
+			{IP: "192.168.1.10", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "in subnet"},
+			{IP: "192.168.1.200", Port: 22, Service: "SSH", LastTimestamp: 1000, Response: "in subnet"},
+			{IP: "192.168.10.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "prefix lookalike"},
+			{IP: "2001:db8::1", Port: 443, Service: "HTTPS", LastTimestamp: 1000, Response: "v6 in subnet"},
+			{IP: "2001:db9::1", Port: 443, Service: "HTTPS", LastTimestamp: 1000, Response: "v6 outside"},
+		}
+		for _, r := range records {
+			if _, err := s.Upsert(ctx, r); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+		}
+
+		tests := []struct {
+			name       string
+			cidr       string
+			limit      int
+			offset     int
+			expectedIP []string
+		}{
+			{"IPv4 /24", "192.168.1.0/24", 0, 0, []string{"192.168.1.10", "192.168.1.200"}},
+			{"IPv4 /25", "192.168.1.128/25", 0, 0, []string{"192.168.1.200"}},
+			{"IPv4 paginated", "192.168.1.0/24", 1, 1, []string{"192.168.1.200"}},
+			{"IPv4 /32", "192.168.1.10/32", 0, 0, []string{"192.168.1.10"}},
+			{"IPv6 /32", "2001:db8::/32", 0, 0, []string{"2001:db8::1"}},
+			{"no matches", "172.16.0.0/12", 0, 0, nil},
+		}
+		for _, tt := range tests {
+			got, err := s.ListByCIDR(ctx, tt.cidr, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("%s: ListByCIDR failed: %v", tt.name, err)
+			}
+			if len(got) != len(tt.expectedIP) {
+				t.Fatalf("%s: expected %d records, got %d", tt.name, len(tt.expectedIP), len(got))
+			}
+			for i, r := range got {
+				if r.IP.String() != tt.expectedIP[i] {
+					t.Errorf("%s: record %d: expected %s, got %s", tt.name, i, tt.expectedIP[i], r.IP)
+				}
+			}
+		}
+
+		_, err := s.ListByCIDR(ctx, "192.168.1.0/33", 0, 0)
+		if !errors.Is(err, ErrInvalidCIDR) {
+			t.Errorf("Expected ErrInvalidCIDR for malformed input, got %v", err)
+		}
+	})
+
+	t.Run("Delete records", func(t *testing.T) {
+		_, err := s.Upsert(ctx, &ServiceRecord{
+			IP: "5.5.5.5", Port: 8080, Service: "HTTP",
+			LastTimestamp: 1000, Response: "to be deleted",
+		})
+		if err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+
+		tests := []struct {
+			name          string
+			ip            string
+			port          uint32
+			service       string
+			expectDeleted bool
+		}{
+			{"existing record", "5.5.5.5", 8080, "HTTP", true},
+			{"already deleted", "5.5.5.5", 8080, "HTTP", false},
+			{"non-existent record", "9.9.9.9", 9999, "UNKNOWN", false},
+		}
+
+		for _, tt := range tests {
+			deleted, err := s.Delete(ctx, tt.ip, tt.port, tt.service)
+			if err != nil {
+				t.Fatalf("%s: Delete failed: %v", tt.name, err)
+			}
+			if deleted != tt.expectDeleted {
+				t.Errorf("%s: expected deleted=%v, got %v", tt.name, tt.expectDeleted, deleted)
+			}
+
+			got, err := s.Get(ctx, tt.ip, tt.port, tt.service)
+			if err != nil {
+				t.Fatalf("%s: Get failed: %v", tt.name, err)
+			}
+			if got != nil {
+				t.Errorf("%s: expected record to be gone after Delete", tt.name)
+			}
+		}
+	})
+
+	t.Run("Count records", func(t *testing.T) {
+		before, err := s.Count(ctx)
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+
+		// Insert a new record - count should increase
+		s.Upsert(ctx, &ServiceRecord{
+			IP: "6.6.6.6", Port: 53, Service: "DNS",
+			LastTimestamp: 2000, Response: "dns response",
+		})
+
+		after, err := s.Count(ctx)
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		if after != before+1 {
+			t.Errorf("Expected count %d after insert, got %d", before+1, after)
+		}
+
+		// Skipped older upsert - count should stay the same
+		s.Upsert(ctx, &ServiceRecord{
+			IP: "6.6.6.6", Port: 53, Service: "DNS",
+			LastTimestamp: 1000, Response: "older dns response",
+		})
+
+		skipped, err := s.Count(ctx)
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		if skipped != after {
+			t.Errorf("Expected count to remain %d after skipped upsert, got %d", after, skipped)
+		}
+	})
+
+	t.Run("Message metadata", func(t *testing.T) {
+		// Microsecond precision survives every backend's timestamp column
+		meta := &MessageMetadata{
+			MessageID:      "msg-1",
+			SubscriptionID: "scan-sub",
+			PublishTime:    time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC),
+		}
+		checkMetadata := func(ip string, port uint32, service string, want *MessageMetadata) {
+			t.Helper()
+			got, err := s.Get(ctx, ip, port, service)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if got == nil {
+				t.Fatal("Expected record to exist")
+			}
+			switch {
+			case want == nil && got.MessageMetadata != nil:
+				t.Errorf("Expected no message metadata, got %+v", got.MessageMetadata)
+			case want != nil && got.MessageMetadata == nil:
+				t.Errorf("Expected message metadata %+v, got nil", want)
+			case want != nil && (got.MessageMetadata.MessageID != want.MessageID ||
+				got.MessageMetadata.SubscriptionID != want.SubscriptionID ||
+				!got.MessageMetadata.PublishTime.Equal(want.PublishTime)):
+				t.Errorf("Expected message metadata %+v, got %+v", want, got.MessageMetadata)
+			}
+		}
+
+		if _, err := s.Upsert(ctx, &ServiceRecord{
+			IP: "4.4.4.4", Port: 443, Service: "HTTPS",
+			LastTimestamp: 1000, Response: "first", MessageMetadata: meta,
+		}); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+		checkMetadata("4.4.4.4", 443, "HTTPS", meta)
+
+		// A bulk write carries each record's own metadata
+		bulkMeta := &MessageMetadata{MessageID: "msg-2", SubscriptionID: "scan-sub", PublishTime: meta.PublishTime.Add(time.Second)}
+		if _, err := s.BulkUpsert(ctx, []*ServiceRecord{
+			{IP: "4.4.4.4", Port: 22, Service: "SSH", LastTimestamp: 1000, Response: "ssh", MessageMetadata: bulkMeta},
+		}); err != nil {
+			t.Fatalf("BulkUpsert failed: %v", err)
+		}
+		checkMetadata("4.4.4.4", 22, "SSH", bulkMeta)
+
+		// An update that did not come from Pub/Sub clears the metadata
+		if _, err := s.Upsert(ctx, &ServiceRecord{
+			IP: "4.4.4.4", Port: 443, Service: "HTTPS",
+			LastTimestamp: 2000, Response: "second",
+		}); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+		checkMetadata("4.4.4.4", 443, "HTTPS", nil)
+	})
+
+	t.Run("IP spellings", func(t *testing.T) {
+		// Every spelling of an IPv6 address names the same record
+		if _, err := s.Upsert(ctx, &ServiceRecord{
+			IP: "2001:db8:77::1", Port: 80, Service: "HTTP",
+			LastTimestamp: 1000, Response: "short",
+		}); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+		if _, err := s.UpdateIfUnchanged(ctx, &ServiceRecord{
+			IP: "2001:0db8:0077:0:0:0:0:1", Port: 80, Service: "HTTP",
+			LastTimestamp: 2000, Response: "long",
+		}, 1000); err != nil {
+			t.Fatalf("UpdateIfUnchanged failed: %v", err)
+		}
+
+		got, err := s.Get(ctx, "2001:DB8:77:0::1", 80, "HTTP")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got == nil || got.IP != "2001:db8:77::1" || got.Response != "long" {
+			t.Errorf("Expected one record at 2001:db8:77::1 with response long, got %+v", got)
+		}
+		byIP, err := s.ListByIP(ctx, "2001:0db8:77::0001")
+		if err != nil {
+			t.Fatalf("ListByIP failed: %v", err)
+		}
+		if len(byIP) != 1 {
+			t.Errorf("Expected 1 record for the address, got %d", len(byIP))
+		}
+	})
+
+	t.Run("Timestamp ties", func(t *testing.T) {
+		// Newer than every other record so the ties lead List
+		const ts = 1 << 50
+		want := []RecordKey{
+			{IP: "10.9.0.1", Port: 9, Service: "TIE"},
+			{IP: "10.9.0.1", Port: 10, Service: "TIE"},
+			{IP: "10.9.0.10", Port: 9, Service: "A"},
+			{IP: "10.9.0.10", Port: 9, Service: "TIE"},
+			{IP: "10.9.0.2", Port: 9, Service: "TIE"},
+		}
+		for _, i := range []int{4, 2, 0, 3, 1} {
+			if _, err := s.Upsert(ctx, &ServiceRecord{
+				IP: IPAddress(want[i].IP), Port: want[i].Port, Service: want[i].Service,
+				LastTimestamp: ts, Response: "tie",
+			}); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+		}
+
+		pages := map[string]func(limit, offset int) ([]*ServiceRecord, error){
+			"List": func(limit, offset int) ([]*ServiceRecord, error) {
+				return s.List(ctx, limit, offset)
+			},
+			"ListByTimestampRange": func(limit, offset int) ([]*ServiceRecord, error) {
+				return s.ListByTimestampRange(ctx, ts, ts, limit, offset)
+			},
+		}
+		for name, page := range pages {
+			var got []RecordKey
+			for offset := 0; offset < len(want); offset += 2 {
+				records, err := page(min(2, len(want)-offset), offset)
+				if err != nil {
+					t.Fatalf("%s failed: %v", name, err)
+				}
+				for _, r := range records {
+					got = append(got, r.Key())
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%s: Expected pages %v, got %v", name, want, got)
+			}
+		}
+	})
+}
+
+// TestMemoryStoreLen tests the Len helper method on MemoryStore
+func TestMemoryStoreLen(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	ctx := context.Background()
+
+	if store.Len() != 0 {
+		t.Errorf("Expected empty store, got %d records", store.Len())
+	}
+
+	store.Upsert(ctx, &ServiceRecord{
+		IP: "1.1.1.1", Port: 80, Service: "HTTP",
+		LastTimestamp: 1000, Response: "test",
+	})
+
+	if store.Len() != 1 {
+		t.Errorf("Expected 1 record, got %d", store.Len())
+	}
+}
+
 // TestUpdatedAt tests that UpdatedAt is set correctly
 func TestUpdatedAt(t *testing.T) {
 	store := NewMemoryStore()
@@ -270,3 +1326,1442 @@ func TestUpdatedAt(t *testing.T) {
 		t.Errorf("UpdatedAt not in expected range")
 	}
 }
+
+// TestFirstSeenAt tests that FirstSeenAt is kept across updates while
+// UpdatedAt advances
+func TestFirstSeenAt(t *testing.T) {
+	stores := newTestStores(t)
+	ctx := context.Background()
+
+	inserted := make(map[string]*ServiceRecord)
+	for name, s := range stores {
+		if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}); err != nil {
+			t.Fatalf("%s: Upsert failed: %v", name, err)
+		}
+		r, err := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+		if err != nil || r == nil {
+			t.Fatalf("%s: Get failed: %v", name, err)
+		}
+		if r.FirstSeenAt.IsZero() {
+			t.Errorf("%s: expected FirstSeenAt to be set on insert", name)
+		}
+		inserted[name] = r
+	}
+
+	// SQLite's CURRENT_TIMESTAMP has one-second resolution
+	time.Sleep(1100 * time.Millisecond)
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			first := inserted[name]
+
+			if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000}); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+			updated, err := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+
+			if !updated.FirstSeenAt.Equal(first.FirstSeenAt) {
+				t.Errorf("Expected FirstSeenAt %v, got %v", first.FirstSeenAt, updated.FirstSeenAt)
+			}
+			if !updated.UpdatedAt.After(first.UpdatedAt) {
+				t.Errorf("Expected UpdatedAt to advance past %v, got %v", first.UpdatedAt, updated.UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestScanCount(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// The initial insert counts as the first scan; the bulk path must
+			// count the same way as single upserts
+			if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+			if _, err := s.BulkUpsert(ctx, []*ServiceRecord{{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000}}); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+			if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1500}); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+
+			r, err := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+			if err != nil || r == nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if r.ScanCount != 2 {
+				t.Errorf("Expected scan count 2, got %d", r.ScanCount)
+			}
+		})
+	}
+}
+
+func TestPreviousResponse(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			steps := []struct {
+				timestamp        int64
+				response         string
+				expectedPrevious string
+				expectedChanged  bool
+			}{
+				{1000, "a", "", false},
+				{2000, "a", "a", false},
+				{3000, "b", "a", true},
+				{2500, "c", "a", true}, // Skipped, nothing changes
+			}
+			for _, step := range steps {
+				record := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: step.timestamp, Response: step.response}
+				if _, err := s.Upsert(ctx, record); err != nil {
+					t.Fatalf("Upsert failed: %v", err)
+				}
+				r, err := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+				if err != nil || r == nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if r.PreviousResponse != step.expectedPrevious {
+					t.Errorf("After timestamp %d: expected previous response %q, got %q", step.timestamp, step.expectedPrevious, r.PreviousResponse)
+				}
+				if r.ResponseChanged() != step.expectedChanged {
+					t.Errorf("After timestamp %d: expected ResponseChanged %v", step.timestamp, step.expectedChanged)
+				}
+			}
+		})
+	}
+}
+
+func TestListChangedSince(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			upsert := func(ip string, timestamp int64, response string) {
+				t.Helper()
+				r := &ServiceRecord{IP: IPAddress(ip), Port: 80, Service: "HTTP", LastTimestamp: timestamp,
+					Response: response, ResponseHash: HashResponse(response)}
+				if _, err := s.Upsert(ctx, r); err != nil {
+					t.Fatalf("Upsert failed: %v", err)
+				}
+			}
+
+			upsert("1.1.1.1", 1000, "a") // Inserted only
+			upsert("2.2.2.2", 1000, "a") // Same response
+			upsert("2.2.2.2", 2000, "a")
+			upsert("3.3.3.3", 1000, "a") // Changed
+			upsert("3.3.3.3", 3000, "b")
+			upsert("4.4.4.4", 1000, "a") // Changed before the cutoff
+			upsert("4.4.4.4", 1400, "b")
+			upsert("5.5.5.5", 1000, "a") // Changed then soft-deleted
+			upsert("5.5.5.5", 2500, "b")
+			if _, err := s.Delete(ctx, "5.5.5.5", 80, "HTTP"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			changed, err := s.ListChangedSince(ctx, 1500)
+			if err != nil {
+				t.Fatalf("ListChangedSince failed: %v", err)
+			}
+			if len(changed) != 1 {
+				t.Fatalf("Expected 1 changed record, got %d", len(changed))
+			}
+			r := changed[0]
+			if r.IP != "3.3.3.3" {
+				t.Errorf("Expected 3.3.3.3, got %s", r.IP)
+			}
+			if r.ResponseHash != HashResponse("b") || r.PreviousResponseHash != HashResponse("a") {
+				t.Errorf("Unexpected hashes %s / %s", r.ResponseHash, r.PreviousResponseHash)
+			}
+
+			all, err := s.ListChangedSince(ctx, 0)
+			if err != nil {
+				t.Fatalf("ListChangedSince failed: %v", err)
+			}
+			if len(all) != 2 || all[0].IP != "3.3.3.3" || all[1].IP != "4.4.4.4" {
+				t.Errorf("Expected 3.3.3.3 and 4.4.4.4 newest first, got %v", all)
+			}
+		})
+	}
+}
+
+func TestResponseTruncatedRoundTrip(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for _, r := range []*ServiceRecord{
+				{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, ResponseTruncated: true},
+				{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+			} {
+				if _, err := s.Upsert(ctx, r); err != nil {
+					t.Fatalf("Upsert failed: %v", err)
+				}
+			}
+
+			truncated, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+			if !truncated.ResponseTruncated {
+				t.Error("Expected ResponseTruncated to be true")
+			}
+			plain, _ := s.Get(ctx, "2.2.2.2", 80, "HTTP")
+			if plain.ResponseTruncated {
+				t.Error("Expected ResponseTruncated to be false")
+			}
+		})
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.HealthCheck(context.Background()); err != nil {
+				t.Errorf("Expected healthy store, got %v", err)
+			}
+		})
+	}
+}
+
+// newTestStores returns fresh, empty instances of each testable Store
+// implementation keyed by name; they are closed when the test ends
+func newTestStores(t *testing.T) map[string]Store {
+	sqliteStore, err := NewSQLiteStore(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+
+	redisStore, err := NewRedisStore(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("Failed to create Redis store: %v", err)
+	}
+
+	badgerStore, err := NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create Badger store: %v", err)
+	}
+
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": sqliteStore,
+		"redis":  redisStore,
+		"badger": badgerStore,
+	}
+	t.Cleanup(func() {
+		for _, s := range stores {
+			s.Close()
+		}
+	})
+	return stores
+}
+
+// TestListAfterTraversal tests that keyset pagination visits every record
+// exactly once while newer records are being inserted concurrently
+func TestListAfterTraversal(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// 250 IPs with four services each, all at the IP's timestamp, and
+			// many shared timestamps to exercise the ip, port and service
+			// tiebreakers
+			const ips = 250
+			services := []struct {
+				port    uint32
+				service string
+			}{{22, "SSH"}, {80, "HTTP"}, {80, "HTTP-ALT"}, {443, "HTTPS"}}
+			var want []RecordKey
+			for i := 0; i < ips; i++ {
+				for _, svc := range services {
+					r := &ServiceRecord{
+						IP:            IPAddress(fmt.Sprintf("10.0.%d.%d", i/256, i%256)),
+						Port:          svc.port,
+						Service:       svc.service,
+						LastTimestamp: int64(1000 + i%50),
+						Response:      "original",
+					}
+					if _, err := s.Upsert(ctx, r); err != nil {
+						t.Fatalf("Upsert failed: %v", err)
+					}
+					want = append(want, r.Key())
+				}
+			}
+
+			// Insert newer records while paging; they sort before the cursor
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					s.Upsert(ctx, &ServiceRecord{
+						IP:            IPAddress(fmt.Sprintf("20.0.%d.%d", i/256%256, i%256)),
+						Port:          80,
+						Service:       "HTTP",
+						LastTimestamp: int64(5000 + i),
+						Response:      "concurrent",
+					})
+				}
+			}()
+
+			seen := make(map[RecordKey]int)
+			cursor := ""
+			for {
+				after, err := DecodeCursor(cursor)
+				if err != nil {
+					t.Fatalf("DecodeCursor failed: %v", err)
+				}
+				page, err := s.ListAfter(ctx, after, 37)
+				if err != nil {
+					t.Fatalf("ListAfter failed: %v", err)
+				}
+				if len(page) == 0 {
+					break
+				}
+				if !slices.IsSortedFunc(page, compareListOrder) {
+					t.Error("Expected each page newest first, then by ip, port and service")
+				}
+				for _, r := range page {
+					seen[r.Key()]++
+				}
+				cursor = EncodeCursor(CursorAfter(page[len(page)-1]))
+			}
+
+			close(done)
+			wg.Wait()
+
+			for key, n := range seen {
+				if n != 1 {
+					t.Errorf("Record %+v returned %d times", key, n)
+				}
+			}
+			for _, key := range want {
+				if seen[key] != 1 {
+					t.Errorf("Record %+v not visited", key)
+				}
+			}
+		})
+	}
+}
+
+// TestCursorRoundTrip tests cursor encoding for IPv4 and IPv6 positions
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{Timestamp: 1000, IP: "1.1.1.1", Port: 80, Service: "HTTP"},
+		{Timestamp: 1700000000, IP: "2001:db8::1", Port: 443, Service: "HTTPS"},
+		{Timestamp: 1000, IP: "::1", Port: 8080, Service: "a:b"},
+		{},
+	}
+
+	for _, tt := range tests {
+		got, err := DecodeCursor(EncodeCursor(tt))
+		if err != nil {
+			t.Fatalf("DecodeCursor failed: %v", err)
+		}
+		if got != tt {
+			t.Errorf("Expected %+v, got %+v", tt, got)
+		}
+	}
+
+	if got, err := DecodeCursor(""); err != nil || got != (Cursor{}) {
+		t.Errorf("Expected the empty cursor to start from the first page, got %+v (err %v)", got, err)
+	}
+	if _, err := DecodeCursor("not a cursor!"); err == nil {
+		t.Error("Expected error for malformed cursor")
+	}
+}
+
+// TestStats tests aggregate statistics against a known set of records
+func TestStats(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			empty, err := s.Stats(ctx)
+			if err != nil {
+				t.Fatalf("Stats failed: %v", err)
+			}
+			if empty.TotalRecords != 0 || empty.OldestTimestamp != 0 || empty.NewestTimestamp != 0 {
+				t.Errorf("Expected zero stats for empty store, got %+v", empty)
+			}
+
+			records := []*ServiceRecord{
+				{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1500, Response: "a"},
+				{IP: "1.1.1.2", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "b"},
+				{IP: "1.1.1.1", Port: 22, Service: "SSH", LastTimestamp: 3000, Response: "c"},
+				{IP: "1.1.1.3", Port: 53, Service: "DNS", LastTimestamp: 2000, Response: "d"},
+				{IP: "1.1.1.4", Port: 8080, Service: "HTTP", LastTimestamp: 2500, Response: "e"},
+			}
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+
+			stats, err := s.Stats(ctx)
+			if err != nil {
+				t.Fatalf("Stats failed: %v", err)
+			}
+
+			if stats.TotalRecords != 5 {
+				t.Errorf("Expected TotalRecords 5, got %d", stats.TotalRecords)
+			}
+			expectedServices := map[string]int64{"HTTP": 3, "SSH": 1, "DNS": 1}
+			if len(stats.RecordsByService) != len(expectedServices) {
+				t.Errorf("Expected %d services, got %v", len(expectedServices), stats.RecordsByService)
+			}
+			for service, count := range expectedServices {
+				if stats.RecordsByService[service] != count {
+					t.Errorf("Expected %d %s records, got %d", count, service, stats.RecordsByService[service])
+				}
+			}
+			expectedPorts := map[uint32]int64{80: 2, 22: 1, 53: 1, 8080: 1}
+			if len(stats.RecordsByPort) != len(expectedPorts) {
+				t.Errorf("Expected %d ports, got %v", len(expectedPorts), stats.RecordsByPort)
+			}
+			for port, count := range expectedPorts {
+				if stats.RecordsByPort[port] != count {
+					t.Errorf("Expected %d records on port %d, got %d", count, port, stats.RecordsByPort[port])
+				}
+			}
+			if stats.OldestTimestamp != 1000 {
+				t.Errorf("Expected OldestTimestamp 1000, got %d", stats.OldestTimestamp)
+			}
+			if stats.NewestTimestamp != 3000 {
+				t.Errorf("Expected NewestTimestamp 3000, got %d", stats.NewestTimestamp)
+			}
+		})
+	}
+}
+
+// TestListDistinctIPs tests that each IP is listed once in ascending order,
+// with mixed IPv4 and IPv6 addresses, and that soft-deleted records are
+// not counted
+func TestListDistinctIPs(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			ips := []string{"4.4.4.4", "2001:db8::1", "10.0.0.1", "fe80::1", "192.168.1.1"}
+			var records []*ServiceRecord
+			for i, ip := range ips {
+				for j, service := range []string{"HTTP", "SSH", "DNS"} {
+					records = append(records, &ServiceRecord{
+						IP: IPAddress(ip), Port: uint32(80 + j), Service: service, LastTimestamp: int64(1000 + i*10 + j), Response: "r",
+					})
+				}
+			}
+			records = append(records, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "r"})
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+			if _, err := s.Delete(ctx, "1.1.1.1", 80, "HTTP"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			got, err := s.ListDistinctIPs(ctx, 0, 0)
+			if err != nil {
+				t.Fatalf("ListDistinctIPs failed: %v", err)
+			}
+			want := []string{"10.0.0.1", "192.168.1.1", "2001:db8::1", "4.4.4.4", "fe80::1"}
+			if !slices.Equal(got, want) {
+				t.Errorf("Expected %v, got %v", want, got)
+			}
+
+			count, err := s.CountDistinctIPs(ctx)
+			if err != nil {
+				t.Fatalf("CountDistinctIPs failed: %v", err)
+			}
+			if count != 5 {
+				t.Errorf("Expected 5 distinct IPs, got %d", count)
+			}
+
+			page, err := s.ListDistinctIPs(ctx, 2, 2)
+			if err != nil {
+				t.Fatalf("ListDistinctIPs failed: %v", err)
+			}
+			if !slices.Equal(page, want[2:4]) {
+				t.Errorf("Expected page %v, got %v", want[2:4], page)
+			}
+
+			page, err = s.ListDistinctIPs(ctx, 10, 5)
+			if err != nil {
+				t.Fatalf("ListDistinctIPs failed: %v", err)
+			}
+			if len(page) != 0 {
+				t.Errorf("Expected empty page past the end, got %v", page)
+			}
+		})
+	}
+}
+
+// TestListDistinctServicesAndPorts tests that services are listed in
+// alphabetical order and ports in numeric order, each once
+func TestListDistinctServicesAndPorts(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			records := []*ServiceRecord{
+				{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+				{IP: "1.1.1.1", Port: 443, Service: "TLS", LastTimestamp: 1000},
+				{IP: "1.1.1.1", Port: 22, Service: "SSH", LastTimestamp: 1000},
+				{IP: "1.1.1.2", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+				{IP: "1.1.1.2", Port: 8080, Service: "HTTP", LastTimestamp: 1000},
+				{IP: "1.1.1.3", Port: 443, Service: "HTTP", LastTimestamp: 1000},
+				{IP: "1.1.1.3", Port: 22, Service: "SSH", LastTimestamp: 1000},
+				{IP: "1.1.1.4", Port: 443, Service: "TLS", LastTimestamp: 1000},
+				{IP: "1.1.1.4", Port: 8080, Service: "TLS", LastTimestamp: 1000},
+				{IP: "1.1.1.5", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+			}
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+
+			services, err := s.ListDistinctServices(ctx)
+			if err != nil {
+				t.Fatalf("ListDistinctServices failed: %v", err)
+			}
+			if want := []string{"HTTP", "SSH", "TLS"}; !slices.Equal(services, want) {
+				t.Errorf("Expected services %v, got %v", want, services)
+			}
+
+			ports, err := s.ListDistinctPorts(ctx)
+			if err != nil {
+				t.Fatalf("ListDistinctPorts failed: %v", err)
+			}
+			if want := []uint32{22, 80, 443, 8080}; !slices.Equal(ports, want) {
+				t.Errorf("Expected ports %v, got %v", want, ports)
+			}
+		})
+	}
+}
+
+// TestFindCoOccurrence tests that only IPs with a record matching every
+// filter are returned, across 20 IPs of which 3 run both SSH on 22 and
+// HTTP on 8080
+func TestFindCoOccurrence(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var records []*ServiceRecord
+			for i := 1; i <= 20; i++ {
+				ip := fmt.Sprintf("10.0.0.%d", i)
+				switch {
+				case i <= 3:
+					// Both services, plus an unrelated one
+					records = append(records,
+						&ServiceRecord{IP: IPAddress(ip), Port: 22, Service: "SSH", LastTimestamp: 1000},
+						&ServiceRecord{IP: IPAddress(ip), Port: 8080, Service: "HTTP", LastTimestamp: 1000},
+						&ServiceRecord{IP: IPAddress(ip), Port: 53, Service: "DNS", LastTimestamp: 1000})
+				case i <= 8:
+					records = append(records, &ServiceRecord{IP: IPAddress(ip), Port: 22, Service: "SSH", LastTimestamp: 1000})
+				case i <= 13:
+					records = append(records, &ServiceRecord{IP: IPAddress(ip), Port: 8080, Service: "HTTP", LastTimestamp: 1000})
+				case i <= 16:
+					// The right services on the wrong ports
+					records = append(records,
+						&ServiceRecord{IP: IPAddress(ip), Port: 2222, Service: "SSH", LastTimestamp: 1000},
+						&ServiceRecord{IP: IPAddress(ip), Port: 8080, Service: "HTTP", LastTimestamp: 1000})
+				case i == 17:
+					// Matches only until the SSH record is deleted
+					records = append(records,
+						&ServiceRecord{IP: IPAddress(ip), Port: 22, Service: "SSH", LastTimestamp: 1000},
+						&ServiceRecord{IP: IPAddress(ip), Port: 8080, Service: "HTTP", LastTimestamp: 1000})
+				default:
+					records = append(records, &ServiceRecord{IP: IPAddress(ip), Port: 443, Service: "TLS", LastTimestamp: 1000})
+				}
+			}
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+			if _, err := s.Delete(ctx, "10.0.0.17", 22, "SSH"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			summaries, err := s.FindCoOccurrence(ctx, []ServiceFilter{{Port: 22, Service: "SSH"}, {Port: 8080, Service: "HTTP"}})
+			if err != nil {
+				t.Fatalf("FindCoOccurrence failed: %v", err)
+			}
+			var ips []string
+			for _, summary := range summaries {
+				ips = append(ips, summary.IP)
+				if len(summary.Records) != 2 || summary.Records[0].Port != 22 || summary.Records[1].Port != 8080 {
+					t.Errorf("Expected the SSH and HTTP records for %s, got %+v", summary.IP, summary.Records)
+				}
+			}
+			if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !slices.Equal(ips, want) {
+				t.Errorf("Expected IPs %v, got %v", want, ips)
+			}
+
+			// Three filters, one matching any port
+			summaries, err = s.FindCoOccurrence(ctx, []ServiceFilter{{Service: "SSH"}, {Port: 8080}, {Port: 53, Service: "DNS"}})
+			if err != nil {
+				t.Fatalf("FindCoOccurrence failed: %v", err)
+			}
+			if len(summaries) != 3 || len(summaries[0].Records) != 3 {
+				t.Errorf("Expected 3 IPs with 3 records each, got %+v", summaries)
+			}
+
+			for _, filters := range [][]ServiceFilter{{{Port: 22, Service: "TLS"}}, nil} {
+				summaries, err = s.FindCoOccurrence(ctx, filters)
+				if err != nil {
+					t.Fatalf("FindCoOccurrence failed: %v", err)
+				}
+				if summaries == nil || len(summaries) != 0 {
+					t.Errorf("Expected empty non-nil slice for %v, got %#v", filters, summaries)
+				}
+			}
+		})
+	}
+}
+
+// TestListOrderEqualTimestamps tests that records sharing a timestamp are
+// listed by IP, then port, then service, on every page
+// Redis orders records with equal timestamps by key and is not covered
+func TestListOrderEqualTimestamps(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		if name == "redis" {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var records []*ServiceRecord
+			for i := 0; i < 20; i++ {
+				records = append(records, &ServiceRecord{
+					IP:            IPAddress(fmt.Sprintf("10.0.0.%d", 1+i%5)),
+					Port:          []uint32{8080, 22, 443, 80}[i%4],
+					Service:       []string{"TLS", "HTTP"}[i%2],
+					LastTimestamp: 1000,
+				})
+			}
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+
+			want := slices.Clone(records)
+			slices.SortFunc(want, func(a, b *ServiceRecord) int {
+				return cmp.Or(strings.Compare(a.IP.String(), b.IP.String()), cmp.Compare(a.Port, b.Port), strings.Compare(a.Service, b.Service))
+			})
+			key := func(r *ServiceRecord) string { return makeKey(r.IP.String(), r.Port, r.Service) }
+
+			all, err := s.List(ctx, 0, 0)
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			var paged []*ServiceRecord
+			for offset := 0; offset < len(want); offset += 3 {
+				page, err := s.List(ctx, 3, offset)
+				if err != nil {
+					t.Fatalf("List failed: %v", err)
+				}
+				paged = append(paged, page...)
+			}
+			for _, got := range [][]*ServiceRecord{all, paged} {
+				if len(got) != len(want) {
+					t.Fatalf("Expected %d records, got %d", len(want), len(got))
+				}
+				for i := range want {
+					if key(got[i]) != key(want[i]) {
+						t.Errorf("Expected record %d to be %s, got %s", i, key(want[i]), key(got[i]))
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestGetMulti tests that a batch lookup of 100 keys returns exactly the
+// 60 that exist, with soft-deleted records treated as missing
+func TestGetMulti(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var keys []RecordKey
+			var records []*ServiceRecord
+			for i := 0; i < 100; i++ {
+				k := RecordKey{IP: fmt.Sprintf("10.0.%d.%d", i/10, i%10), Port: uint32(80 + i%3), Service: "HTTP"}
+				keys = append(keys, k)
+				if i%5 < 3 {
+					records = append(records, &ServiceRecord{IP: IPAddress(k.IP), Port: k.Port, Service: k.Service, LastTimestamp: int64(1000 + i), Response: k.IP})
+				}
+			}
+			// Same IP and port as a stored key but another service
+			records = append(records, &ServiceRecord{IP: "10.0.0.3", Port: 80, Service: "SSH", LastTimestamp: 1000})
+			records = append(records, &ServiceRecord{IP: "10.0.9.9", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+			if _, err := s.Delete(ctx, "10.0.9.9", 80, "HTTP"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			found, err := s.GetMulti(ctx, keys)
+			if err != nil {
+				t.Fatalf("GetMulti failed: %v", err)
+			}
+			if len(found) != 60 {
+				t.Errorf("Expected 60 records, got %d", len(found))
+			}
+			for i, k := range keys {
+				r, ok := found[k]
+				if want := i%5 < 3; ok != want {
+					t.Errorf("Expected key %v present=%v, got %v", k, want, ok)
+					continue
+				}
+				if ok && (r.Key() != k || r.Response != k.IP) {
+					t.Errorf("Expected record for %v, got %+v", k, r)
+				}
+			}
+
+			if found, err := s.GetMulti(ctx, nil); err != nil || len(found) != 0 {
+				t.Errorf("Expected empty map for no keys, got %v (err %v)", found, err)
+			}
+		})
+	}
+}
+
+// TestUpdateIfUnchanged tests that concurrent conditional updates of the
+// same record let exactly one writer win
+func TestUpdateIfUnchanged(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "original"})
+
+			var wg sync.WaitGroup
+			results := make([]bool, 2)
+			for i := range results {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: fmt.Sprintf("writer %d", i)}
+					updated, err := s.UpdateIfUnchanged(ctx, r, 1000)
+					if err != nil {
+						t.Errorf("UpdateIfUnchanged failed: %v", err)
+					}
+					results[i] = updated
+				}()
+			}
+			wg.Wait()
+
+			if results[0] == results[1] {
+				t.Fatalf("Expected exactly one writer to win, got %v", results)
+			}
+			winner := 0
+			if results[1] {
+				winner = 1
+			}
+			got, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP")
+			if got == nil || got.Response != fmt.Sprintf("writer %d", winner) || got.LastTimestamp != 2000 ||
+				got.PreviousResponse != "original" || got.ScanCount != 2 {
+				t.Errorf("Expected the winning write, got %+v", got)
+			}
+
+			// A stale expected timestamp, a missing record and a deleted
+			// record are all lost races
+			stale := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 3000}
+			if updated, err := s.UpdateIfUnchanged(ctx, stale, 1000); updated || err != nil {
+				t.Errorf("Expected false, nil for a stale timestamp, got %v, %v", updated, err)
+			}
+			missing := &ServiceRecord{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 3000}
+			if updated, err := s.UpdateIfUnchanged(ctx, missing, 0); updated || err != nil {
+				t.Errorf("Expected false, nil for a missing record, got %v, %v", updated, err)
+			}
+			s.Delete(ctx, "1.1.1.1", 80, "HTTP")
+			if updated, err := s.UpdateIfUnchanged(ctx, stale, 2000); updated || err != nil {
+				t.Errorf("Expected false, nil for a deleted record, got %v, %v", updated, err)
+			}
+		})
+	}
+}
+
+// TestListV2 tests that pages come with the number of live records across
+// all pages
+func TestListV2(t *testing.T) {
+	ctx := context.Background()
+
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				s.Upsert(ctx, &ServiceRecord{IP: IPAddress(fmt.Sprintf("10.0.0.%d", i)), Port: 80, Service: "HTTP", LastTimestamp: int64(1000 + i)})
+			}
+			s.Delete(ctx, "10.0.0.0", 80, "HTTP")
+
+			result, err := s.ListV2(ctx, 2, 1)
+			if err != nil {
+				t.Fatalf("ListV2 failed: %v", err)
+			}
+			if result.TotalCount != 4 {
+				t.Errorf("Expected total 4, got %d", result.TotalCount)
+			}
+			if len(result.Records) != 2 || result.Records[0].LastTimestamp != 1003 || result.Records[1].LastTimestamp != 1002 {
+				t.Errorf("Expected records 1003 and 1002, got %d records", len(result.Records))
+			}
+
+			all, err := s.ListV2(ctx, 0, 0)
+			if err != nil || len(all.Records) != 4 || all.TotalCount != 4 {
+				t.Errorf("Expected all 4 records with total 4, got %+v (err %v)", all, err)
+			}
+			past, err := s.ListV2(ctx, 2, 10)
+			if err != nil || len(past.Records) != 0 || past.TotalCount != 4 {
+				t.Errorf("Expected an empty page with total 4 past the end, got %+v (err %v)", past, err)
+			}
+		})
+	}
+}
+
+// TestSearchByResponse tests case-insensitive substring search over a
+// corpus of 50 records with varying banners
+func TestSearchByResponse(t *testing.T) {
+	banners := []string{
+		"HTTP/1.1 200 OK\r\nServer: nginx/1.18.0",
+		"SSH-2.0-OpenSSH_8.9p1 Ubuntu",
+		"HTTP/1.1 403 Forbidden\r\nServer: Apache/2.4.41",
+		"220 ProFTPD 1.3.5 Server ready",
+		"progress 100%_done",
+	}
+
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			records := make([]*ServiceRecord, 50)
+			for i := range records {
+				records[i] = &ServiceRecord{
+					IP:            IPAddress(fmt.Sprintf("10.0.%d.%d", i/10, i%10)),
+					Port:          80,
+					Service:       "HTTP",
+					LastTimestamp: int64(1000 + i),
+					Response:      banners[i%len(banners)],
+				}
+			}
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+
+			tests := []struct {
+				query         string
+				limit, offset int
+				expectedCount int
+			}{
+				{"nginx", 0, 0, 10},
+				{"NGINX", 0, 0, 10},
+				{"openssh", 0, 0, 10},
+				{"Server: ", 0, 0, 20},
+				{"server", 0, 0, 30},
+				{"server", 5, 27, 3},
+				{"%_", 0, 0, 10},
+				{"telnet", 0, 0, 0},
+			}
+			for _, tt := range tests {
+				got, err := s.SearchByResponse(ctx, tt.query, tt.limit, tt.offset)
+				if err != nil {
+					t.Fatalf("SearchByResponse(%q) failed: %v", tt.query, err)
+				}
+				if len(got) != tt.expectedCount {
+					t.Errorf("SearchByResponse(%q, %d, %d): expected %d records, got %d",
+						tt.query, tt.limit, tt.offset, tt.expectedCount, len(got))
+				}
+				for i, r := range got {
+					if !strings.Contains(strings.ToLower(r.Response), strings.ToLower(tt.query)) {
+						t.Errorf("SearchByResponse(%q): unexpected match %q", tt.query, r.Response)
+					}
+					if i > 0 && r.LastTimestamp > got[i-1].LastTimestamp {
+						t.Errorf("SearchByResponse(%q): expected newest first", tt.query)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestPurgeExpired tests that only records past their ExpiresAt are purged
+// and that records without an ExpiresAt are kept
+func TestPurgeExpired(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			past := time.Now().Add(-time.Minute)
+			future := time.Now().Add(time.Hour)
+
+			records := []*ServiceRecord{
+				{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, ExpiresAt: &past},
+				{IP: "1.1.1.2", Port: 80, Service: "HTTP", LastTimestamp: 1000, ExpiresAt: &future},
+				{IP: "1.1.1.3", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+			}
+			if _, err := s.Upsert(ctx, records[0]); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+			if _, err := s.BulkUpsert(ctx, records[1:]); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+
+			kept, err := s.Get(ctx, "1.1.1.2", 80, "HTTP")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if kept.ExpiresAt == nil || !kept.ExpiresAt.Equal(future) {
+				t.Errorf("Expected ExpiresAt %v, got %v", future, kept.ExpiresAt)
+			}
+
+			purged, err := s.PurgeExpired(ctx)
+			if err != nil {
+				t.Fatalf("PurgeExpired failed: %v", err)
+			}
+			if purged != 1 {
+				t.Errorf("Expected 1 purged record, got %d", purged)
+			}
+
+			if r, _ := s.Get(ctx, "1.1.1.1", 80, "HTTP"); r != nil {
+				t.Error("Expected expired record to be purged")
+			}
+			for _, ip := range []string{"1.1.1.2", "1.1.1.3"} {
+				if r, _ := s.Get(ctx, ip, 80, "HTTP"); r == nil {
+					t.Errorf("Expected record %s to be kept", ip)
+				}
+			}
+			if count, _ := s.Count(ctx); count != 2 {
+				t.Errorf("Expected 2 records after purge, got %d", count)
+			}
+
+			// A newer scan without an expiry clears the old one
+			if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.2", Port: 80, Service: "HTTP", LastTimestamp: 2000}); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+			cleared, _ := s.Get(ctx, "1.1.1.2", 80, "HTTP")
+			if cleared == nil || cleared.ExpiresAt != nil {
+				t.Errorf("Expected ExpiresAt to be cleared, got %+v", cleared)
+			}
+
+			if purged, _ := s.PurgeExpired(ctx); purged != 0 {
+				t.Errorf("Expected nothing left to purge, got %d", purged)
+			}
+		})
+	}
+}
+
+// TestDeleteOlderThan tests that only records before the cutoff are removed
+func TestDeleteOlderThan(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			records := make([]*ServiceRecord, 10)
+			for i := range records {
+				records[i] = &ServiceRecord{
+					IP:            IPAddress(fmt.Sprintf("10.0.0.%d", i)),
+					Port:          80,
+					Service:       "HTTP",
+					LastTimestamp: int64(1000 + i*100),
+				}
+			}
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+
+			// Tombstones are removed along with live records
+			if _, err := s.Delete(ctx, "10.0.0.0", 80, "HTTP"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			// 1000-1400 are older than the midpoint; 1500 itself is kept
+			deleted, err := s.DeleteOlderThan(ctx, 1500)
+			if err != nil {
+				t.Fatalf("DeleteOlderThan failed: %v", err)
+			}
+			if deleted != 5 {
+				t.Errorf("Expected 5 deleted records, got %d", deleted)
+			}
+
+			remaining, err := s.List(ctx, 0, 0)
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(remaining) != 5 {
+				t.Errorf("Expected 5 remaining records, got %d", len(remaining))
+			}
+			for _, r := range remaining {
+				if r.LastTimestamp < 1500 {
+					t.Errorf("Expected records before 1500 to be deleted, found %d", r.LastTimestamp)
+				}
+			}
+			if count, _ := s.Count(ctx); count != 5 {
+				t.Errorf("Expected count 5, got %d", count)
+			}
+
+			if tombstones, _ := s.ListDeleted(ctx, 0, 0); len(tombstones) != 0 {
+				t.Errorf("Expected old tombstone to be removed, got %d", len(tombstones))
+			}
+
+			if deleted, _ := s.DeleteOlderThan(ctx, 1500); deleted != 0 {
+				t.Errorf("Expected second delete to remove nothing, got %d", deleted)
+			}
+		})
+	}
+}
+
+// TestSoftDeleteLifecycle tests insert, soft-delete, undelete and that
+// tombstones are hidden from reads but listed by ListDeleted
+func TestSoftDeleteLifecycle(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			records := []*ServiceRecord{
+				{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "keep"},
+				{IP: "1.1.1.2", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "delete me"},
+			}
+			if _, err := s.BulkUpsert(ctx, records); err != nil {
+				t.Fatalf("BulkUpsert failed: %v", err)
+			}
+
+			visible := func() []string {
+				t.Helper()
+				got, err := s.List(ctx, 0, 0)
+				if err != nil {
+					t.Fatalf("List failed: %v", err)
+				}
+				ips := make([]string, len(got))
+				for i, r := range got {
+					ips[i] = r.IP.String()
+				}
+				return ips
+			}
+
+			deleted, err := s.Delete(ctx, "1.1.1.2", 80, "HTTP")
+			if err != nil || !deleted {
+				t.Fatalf("Expected Delete to succeed, got %v, %v", deleted, err)
+			}
+
+			if ips := visible(); len(ips) != 1 || ips[0] != "1.1.1.1" {
+				t.Errorf("Expected only 1.1.1.1 after delete, got %v", ips)
+			}
+			if r, _ := s.Get(ctx, "1.1.1.2", 80, "HTTP"); r != nil {
+				t.Error("Expected Get to hide the deleted record")
+			}
+			if got, _ := s.ListByService(ctx, "HTTP", 0, 0); len(got) != 1 {
+				t.Errorf("Expected ListByService to hide the deleted record, got %d records", len(got))
+			}
+			if got, _ := s.SearchByResponse(ctx, "delete", 0, 0); len(got) != 0 {
+				t.Errorf("Expected SearchByResponse to hide the deleted record, got %d records", len(got))
+			}
+			if count, _ := s.Count(ctx); count != 1 {
+				t.Errorf("Expected count 1 after delete, got %d", count)
+			}
+
+			tombstones, err := s.ListDeleted(ctx, 0, 0)
+			if err != nil {
+				t.Fatalf("ListDeleted failed: %v", err)
+			}
+			if len(tombstones) != 1 || tombstones[0].IP != "1.1.1.2" || tombstones[0].DeletedAt == nil {
+				t.Fatalf("Expected 1.1.1.2 tombstone with DeletedAt, got %+v", tombstones)
+			}
+
+			// A newer scan updates the tombstone without restoring it
+			if _, err := s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.2", Port: 80, Service: "HTTP", LastTimestamp: 3000, Response: "rescanned"}); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+			if r, _ := s.Get(ctx, "1.1.1.2", 80, "HTTP"); r != nil {
+				t.Error("Expected upsert to leave the record deleted")
+			}
+
+			if undeleted, err := s.Undelete(ctx, "1.1.1.1", 80, "HTTP"); err != nil || undeleted {
+				t.Errorf("Expected Undelete of a live record to report false, got %v, %v", undeleted, err)
+			}
+			undeleted, err := s.Undelete(ctx, "1.1.1.2", 80, "HTTP")
+			if err != nil || !undeleted {
+				t.Fatalf("Expected Undelete to succeed, got %v, %v", undeleted, err)
+			}
+
+			if ips := visible(); len(ips) != 2 || ips[0] != "1.1.1.2" {
+				t.Errorf("Expected 1.1.1.2 to be listed first again, got %v", ips)
+			}
+			restored, _ := s.Get(ctx, "1.1.1.2", 80, "HTTP")
+			if restored == nil || restored.Response != "rescanned" || restored.DeletedAt != nil {
+				t.Errorf("Expected restored record with the newer scan, got %+v", restored)
+			}
+			if tombstones, _ := s.ListDeleted(ctx, 0, 0); len(tombstones) != 0 {
+				t.Errorf("Expected no tombstones after undelete, got %d", len(tombstones))
+			}
+		})
+	}
+}
+
+// TestExpireAfter tests that ExpireAfter is relative to UpdatedAt
+func TestExpireAfter(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &ServiceRecord{UpdatedAt: updatedAt}
+	r.ExpireAfter(time.Hour)
+
+	if r.ExpiresAt == nil || !r.ExpiresAt.Equal(updatedAt.Add(time.Hour)) {
+		t.Errorf("Expected ExpiresAt %v, got %v", updatedAt.Add(time.Hour), r.ExpiresAt)
+	}
+}
+
+// TestStartExpiryWorker tests that the worker purges records as they expire
+// and stops when asked
+func TestStartExpiryWorker(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	expiring := &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000}
+	expiring.ExpireAfter(50 * time.Millisecond)
+	s.Upsert(ctx, expiring)
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.2", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+
+	stop := StartExpiryWorker(ctx, s, 10*time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected expired record to be purged, %d records remain", s.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r, _ := s.Get(ctx, "1.1.1.2", 80, "HTTP"); r == nil {
+		t.Error("Expected record without ExpiresAt to be kept")
+	}
+
+	stop()
+	stop()
+
+	past := time.Now().Add(-time.Minute)
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.3", Port: 80, Service: "HTTP", LastTimestamp: 1000, ExpiresAt: &past})
+	time.Sleep(50 * time.Millisecond)
+	if s.Len() != 2 {
+		t.Errorf("Expected stopped worker to leave records alone, got %d records", s.Len())
+	}
+}
+
+// TestSQLiteTracing tests that SQLite calls emit spans with database attributes
+func TestSQLiteTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	s, err := NewSQLiteStore(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	s.Upsert(ctx, &ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "a"})
+	s.Get(ctx, "1.1.1.1", 80, "HTTP")
+	s.List(ctx, 10, 0)
+
+	expected := map[string]string{
+		"SQLiteStore.Upsert": "upsert",
+		"SQLiteStore.Get":    "select",
+		"SQLiteStore.List":   "select",
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != len(expected) {
+		t.Fatalf("Expected %d spans, got %d", len(expected), len(spans))
+	}
+	for _, span := range spans {
+		operation, ok := expected[span.Name]
+		if !ok {
+			t.Errorf("Unexpected span %s", span.Name)
+			continue
+		}
+		attrs := attribute.NewSet(span.Attributes...)
+		if v, _ := attrs.Value("db.system"); v.AsString() != "sqlite" {
+			t.Errorf("%s: expected db.system sqlite, got %v", span.Name, v.Emit())
+		}
+		if v, _ := attrs.Value("db.operation.name"); v.AsString() != operation {
+			t.Errorf("%s: expected db.operation.name %s, got %v", span.Name, operation, v.Emit())
+		}
+	}
+}
+
+// TestWatch tests that every upsert is delivered to a watcher while
+// several goroutines write concurrently
+func TestWatch(t *testing.T) {
+	const workers = 5
+	const perWorker = 10
+	const total = workers * perWorker
+
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			events, err := s.Watch(ctx)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						ip := fmt.Sprintf("10.0.%d.%d", w, i)
+						// Create, update, then an older scan that is skipped
+						for _, ts := range []int64{2000, 3000, 1000} {
+							r := &ServiceRecord{IP: IPAddress(ip), Port: 80, Service: "HTTP", LastTimestamp: ts, Response: "r"}
+							if _, err := s.Upsert(ctx, r); err != nil {
+								t.Errorf("Upsert failed: %v", err)
+							}
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			counts := make(map[EventType]int)
+			timeout := time.After(5 * time.Second)
+			for received := 0; received < total*3; received++ {
+				select {
+				case ev := <-events:
+					counts[ev.Type]++
+					switch ev.Type {
+					case EventCreated:
+						if ev.Record.LastTimestamp != 2000 || ev.Previous != nil {
+							t.Errorf("Unexpected created event: %+v", ev)
+						}
+					case EventUpdated:
+						if ev.Record.LastTimestamp != 3000 || ev.Previous == nil || ev.Previous.LastTimestamp != 2000 {
+							t.Errorf("Unexpected updated event: %+v", ev)
+						}
+					case EventSkipped:
+						if ev.Record.LastTimestamp != 1000 || ev.Previous == nil || ev.Previous.LastTimestamp != 3000 {
+							t.Errorf("Unexpected skipped event: %+v", ev)
+						}
+					}
+				case <-timeout:
+					t.Fatalf("Timed out after %d events: %v", received, counts)
+				}
+			}
+
+			for _, typ := range []EventType{EventCreated, EventUpdated, EventSkipped} {
+				if counts[typ] != total {
+					t.Errorf("Expected %d %s events, got %d", total, typ, counts[typ])
+				}
+			}
+		})
+	}
+}
+
+// TestUnwatch tests that watch channels are closed by Unwatch, context
+// cancellation and Close
+func TestUnwatch(t *testing.T) {
+	for name, s := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			unwatched, err := s.Watch(ctx)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+			s.Unwatch(unwatched)
+			if _, ok := <-unwatched; ok {
+				t.Error("Expected channel to be closed after Unwatch")
+			}
+			// A second Unwatch is a no-op
+			s.Unwatch(unwatched)
+
+			cancelCtx, cancel := context.WithCancel(ctx)
+			cancelled, err := s.Watch(cancelCtx)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+			cancel()
+			select {
+			case _, ok := <-cancelled:
+				if ok {
+					t.Error("Expected no events after cancel")
+				}
+			case <-time.After(time.Second):
+				t.Error("Expected channel to be closed after context cancel")
+			}
+
+			open, err := s.Watch(ctx)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+			s.Close()
+			if _, ok := <-open; ok {
+				t.Error("Expected channel to be closed after Close")
+			}
+			if _, err := s.Watch(ctx); !errors.Is(err, ErrStoreClosed) {
+				t.Errorf("Expected ErrStoreClosed after Close, got %v", err)
+			}
+		})
+	}
+}
+
+// BenchmarkMemoryStoreStats10000 measures Stats on a 10 000 record MemoryStore
+// (expected to stay well under a millisecond per call)
+func BenchmarkMemoryStoreStats10000(b *testing.B) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	for i := 0; i < 10000; i++ {
+		s.Upsert(ctx, &ServiceRecord{
+			IP:            IPAddress(fmt.Sprintf("10.0.%d.%d", i/256, i%256)),
+			Port:          uint32(i%100 + 1),
+			Service:       []string{"HTTP", "SSH", "DNS"}[i%3],
+			LastTimestamp: int64(i),
+			Response:      "bench",
+		})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Stats(ctx); err != nil {
+			b.Fatalf("Stats failed: %v", err)
+		}
+	}
+}
+
+// newBenchSQLiteStore creates a SQLite store in a temp dir for benchmarks
+func newBenchSQLiteStore(b *testing.B) *SQLiteStore {
+	s, err := NewSQLiteStore(b.TempDir() + "/bench.db")
+	if err != nil {
+		b.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	b.Cleanup(func() { s.Close() })
+	return s
+}
+
+// benchBatch builds a batch of 100 distinct records for iteration i
+func benchBatch(i int) []*ServiceRecord {
+	batch := make([]*ServiceRecord, 100)
+	for j := range batch {
+		batch[j] = &ServiceRecord{
+			IP:            IPAddress(fmt.Sprintf("10.%d.%d.%d", i/65536%256, i/256%256, i%256)),
+			Port:          uint32(j + 1),
+			Service:       "HTTP",
+			LastTimestamp: int64(i),
+			Response:      "bench response",
+		}
+	}
+	return batch
+}
+
+// BenchmarkSQLiteUpsert100 upserts batches of 100 records one at a time
+func BenchmarkSQLiteUpsert100(b *testing.B) {
+	s := newBenchSQLiteStore(b)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, r := range benchBatch(i) {
+			if _, err := s.Upsert(ctx, r); err != nil {
+				b.Fatalf("Upsert failed: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkSQLiteBulkUpsert100 upserts batches of 100 records with BulkUpsert
+func BenchmarkSQLiteBulkUpsert100(b *testing.B) {
+	s := newBenchSQLiteStore(b)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.BulkUpsert(ctx, benchBatch(i)); err != nil {
+			b.Fatalf("BulkUpsert failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkSQLiteConcurrentUpsert upserts from 10 goroutines at once; with
+// DefaultSQLiteConfig writes are serialized and none fail with SQLITE_BUSY
+func BenchmarkSQLiteConcurrentUpsert(b *testing.B) {
+	s := newBenchSQLiteStore(b)
+	ctx := context.Background()
+
+	const workers = 10
+	var next atomic.Int64
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := next.Add(1); i <= int64(b.N); i = next.Add(1) {
+				_, err := s.Upsert(ctx, &ServiceRecord{
+					IP:            IPAddress(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)),
+					Port:          80,
+					Service:       "HTTP",
+					LastTimestamp: i,
+					Response:      "bench response",
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		b.Errorf("Upsert failed: %v", err)
+	}
+}
+
+// benchUpsert10K upserts 10K distinct records per iteration and reports
+// the mean latency of a single Upsert
+func benchUpsert10K(b *testing.B, s Store) {
+	const records = 10000
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < records; j++ {
+			_, err := s.Upsert(ctx, &ServiceRecord{
+				IP:            IPAddress(fmt.Sprintf("10.0.%d.%d", j/256, j%256)),
+				Port:          80,
+				Service:       "HTTP",
+				LastTimestamp: int64(i*records + j + 1),
+				Response:      "bench response",
+			})
+			if err != nil {
+				b.Fatalf("Upsert failed: %v", err)
+			}
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*records), "ns/upsert")
+}
+
+// BenchmarkSQLiteUpsert10K measures sequential Upsert into SQLite
+func BenchmarkSQLiteUpsert10K(b *testing.B) {
+	benchUpsert10K(b, newBenchSQLiteStore(b))
+}
+
+// BenchmarkBadgerUpsert10K measures sequential Upsert into Badger
+func BenchmarkBadgerUpsert10K(b *testing.B) {
+	s, err := NewBadgerStore(b.TempDir())
+	if err != nil {
+		b.Fatalf("Failed to create Badger store: %v", err)
+	}
+	defer s.Close()
+	benchUpsert10K(b, s)
+}
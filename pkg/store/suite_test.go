@@ -0,0 +1,65 @@
+package store_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/censys/scan-takehome/pkg/store"
+	"github.com/censys/scan-takehome/pkg/store/testutil"
+)
+
+// These tests live in the external test package because testutil imports
+// store, which the internal tests in store_test.go cannot import back
+
+// TestMemoryStoreSuite runs the Store contract suite against MemoryStore
+func TestMemoryStoreSuite(t *testing.T) {
+	testutil.RunStoreSuite(t, func() store.Store {
+		return store.NewMemoryStore()
+	})
+}
+
+// TestSQLiteStoreSuite runs the Store contract suite against SQLiteStore,
+// with a new database file for each case
+func TestSQLiteStoreSuite(t *testing.T) {
+	dir := t.TempDir()
+	n := 0
+	testutil.RunStoreSuite(t, func() store.Store {
+		n++
+		s, err := store.NewSQLiteStore(filepath.Join(dir, fmt.Sprintf("suite-%d.db", n)))
+		if err != nil {
+			// The factory runs in each case's goroutine, where t.Fatalf
+			// cannot stop the test
+			panic(fmt.Sprintf("failed to create SQLite store: %v", err))
+		}
+		return s
+	})
+}
+
+// TestBadgerStoreSuite runs the Store contract suite against BadgerStore,
+// with a new database directory for each case
+func TestBadgerStoreSuite(t *testing.T) {
+	dir := t.TempDir()
+	n := 0
+	testutil.RunStoreSuite(t, func() store.Store {
+		n++
+		s, err := store.NewBadgerStore(filepath.Join(dir, fmt.Sprintf("suite-%d", n)))
+		if err != nil {
+			panic(fmt.Sprintf("failed to create Badger store: %v", err))
+		}
+		return s
+	})
+}
+
+// TestRedisStoreSuite runs the Store contract suite against RedisStore,
+// with a new miniredis server for each case
+func TestRedisStoreSuite(t *testing.T) {
+	testutil.RunStoreSuite(t, func() store.Store {
+		s, err := store.NewRedisStore(miniredis.RunT(t).Addr(), "", 0)
+		if err != nil {
+			panic(fmt.Sprintf("failed to create Redis store: %v", err))
+		}
+		return s
+	})
+}
@@ -0,0 +1,425 @@
+// Package testutil provides a contract test suite for store.Store
+// implementations
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/store"
+)
+
+// RunStoreSuite runs the Store contract tests against stores built by
+// factory
+// Any compliant Store implementation must pass every case
+// Each case is a sub-test with a fresh store from factory, which the case
+// closes when it finishes
+// Run with -race so the concurrent upsert case can catch data races
+func RunStoreSuite(t *testing.T, factory func() store.Store) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, s store.Store)
+	}{
+		{"Insert", testInsert},
+		{"UpsertNewer", testUpsertNewer},
+		{"SkipOlder", testSkipOlder},
+		{"SkipEqualTimestamp", testSkipEqualTimestamp},
+		{"GetMissing", testGetMissing},
+		{"ListAll", testListAll},
+		{"ListPaginated", testListPaginated},
+		{"ListByIP", testListByIP},
+		{"IPSpellings", testIPSpellings},
+		{"BulkUpsert", testBulkUpsert},
+		{"Count", testCount},
+		{"Stats", testStats},
+		{"Delete", testDelete},
+		{"PurgeExpired", testPurgeExpired},
+		{"ConcurrentUpsert", testConcurrentUpsert},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := factory()
+			t.Cleanup(func() { s.Close() })
+			c.fn(t, s)
+		})
+	}
+}
+
+// upsert stores records one at a time, failing the test on error
+func upsert(t *testing.T, s store.Store, records ...*store.ServiceRecord) {
+	t.Helper()
+	for _, r := range records {
+		if _, err := s.Upsert(context.Background(), r); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+}
+
+// mustGet returns the record for a key, failing the test on error
+func mustGet(t *testing.T, s store.Store, ip string, port uint32, service string) *store.ServiceRecord {
+	t.Helper()
+	r, err := s.Get(context.Background(), ip, port, service)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	return r
+}
+
+// testInsert tests that a new record is stored with its fields intact
+func testInsert(t *testing.T, s store.Store) {
+	r := &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "hello"}
+	updated, err := s.Upsert(context.Background(), r)
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if !updated {
+		t.Error("Expected record to be inserted")
+	}
+
+	got := mustGet(t, s, "1.1.1.1", 80, "HTTP")
+	if got == nil {
+		t.Fatal("Expected record to exist")
+	}
+	if got.IP != r.IP || got.Port != r.Port || got.Service != r.Service ||
+		got.LastTimestamp != r.LastTimestamp || got.Response != r.Response {
+		t.Errorf("Expected %+v, got %+v", r, got)
+	}
+}
+
+// testUpsertNewer tests that a newer timestamp replaces the record
+func testUpsertNewer(t *testing.T, s store.Store) {
+	upsert(t, s, &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "old"})
+	updated, err := s.Upsert(context.Background(), &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "new"})
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if !updated {
+		t.Error("Expected record to be updated")
+	}
+
+	got := mustGet(t, s, "1.1.1.1", 80, "HTTP")
+	if got == nil || got.Response != "new" || got.LastTimestamp != 2000 {
+		t.Errorf("Expected the newer record, got %+v", got)
+	}
+}
+
+// testSkipOlder tests that an older timestamp leaves the record unchanged
+func testSkipOlder(t *testing.T, s store.Store) {
+	upsert(t, s, &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "current"})
+	updated, err := s.Upsert(context.Background(), &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "stale"})
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if updated {
+		t.Error("Expected record NOT to be updated (older timestamp)")
+	}
+
+	got := mustGet(t, s, "1.1.1.1", 80, "HTTP")
+	if got == nil || got.Response != "current" || got.LastTimestamp != 2000 {
+		t.Errorf("Expected the record to be unchanged, got %+v", got)
+	}
+}
+
+// testSkipEqualTimestamp tests that an equal timestamp leaves the record
+// unchanged
+func testSkipEqualTimestamp(t *testing.T, s store.Store) {
+	upsert(t, s, &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "current"})
+	updated, err := s.Upsert(context.Background(), &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "duplicate"})
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if updated {
+		t.Error("Expected record NOT to be updated (equal timestamp)")
+	}
+
+	got := mustGet(t, s, "1.1.1.1", 80, "HTTP")
+	if got == nil || got.Response != "current" {
+		t.Errorf("Expected the record to be unchanged, got %+v", got)
+	}
+}
+
+// testGetMissing tests that a missing key returns nil, nil
+func testGetMissing(t *testing.T, s store.Store) {
+	upsert(t, s, &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000})
+	if got := mustGet(t, s, "1.1.1.1", 80, "SSH"); got != nil {
+		t.Errorf("Expected nil for missing record, got %+v", got)
+	}
+}
+
+// testListAll tests that List with no limit returns every record
+func testListAll(t *testing.T, s store.Store) {
+	upsert(t, s,
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 22, Service: "SSH", LastTimestamp: 2000},
+		&store.ServiceRecord{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 3000},
+	)
+	records, err := s.List(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(records) != 3 {
+		t.Errorf("Expected 3 records, got %d", len(records))
+	}
+}
+
+// testListPaginated tests that pages are newest first and together cover
+// every record exactly once
+func testListPaginated(t *testing.T, s store.Store) {
+	for i := 0; i < 5; i++ {
+		upsert(t, s, &store.ServiceRecord{IP: store.IPAddress(fmt.Sprintf("10.0.0.%d", i)), Port: 80, Service: "HTTP", LastTimestamp: int64(1000 + i)})
+	}
+
+	var got []int64
+	for offset := 0; offset < 6; offset += 2 {
+		page, err := s.List(context.Background(), 2, offset)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if want := min(2, 5-offset); len(page) != want {
+			t.Errorf("Expected %d records at offset %d, got %d", want, offset, len(page))
+		}
+		for _, r := range page {
+			got = append(got, r.LastTimestamp)
+		}
+	}
+	want := []int64{1004, 1003, 1002, 1001, 1000}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected timestamps %v, got %v", want, got)
+	}
+
+	if page, err := s.List(context.Background(), 2, 10); err != nil || len(page) != 0 {
+		t.Errorf("Expected an empty page past the end, got %d records (err %v)", len(page), err)
+	}
+}
+
+// testListByIP tests that only the given IP's records are returned
+func testListByIP(t *testing.T, s store.Store) {
+	upsert(t, s,
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 22, Service: "SSH", LastTimestamp: 1000},
+		&store.ServiceRecord{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+	)
+	records, err := s.ListByIP(context.Background(), "1.1.1.1")
+	if err != nil {
+		t.Fatalf("ListByIP failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("Expected 2 records, got %d", len(records))
+	}
+	for _, r := range records {
+		if r.IP != "1.1.1.1" {
+			t.Errorf("Expected IP 1.1.1.1, got %s", r.IP)
+		}
+	}
+}
+
+// testIPSpellings tests that every spelling of an address reads and writes
+// one record, stored under the canonical form
+func testIPSpellings(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	upsert(t, s,
+		&store.ServiceRecord{IP: "::1", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "a"},
+		&store.ServiceRecord{IP: "0:0::1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "b"},
+	)
+	if _, err := s.BulkUpsert(ctx, []*store.ServiceRecord{
+		{IP: "0:0:0:0:0:0:0:1", Port: 22, Service: "SSH", LastTimestamp: 1000, Response: "ssh"},
+	}); err != nil {
+		t.Fatalf("BulkUpsert failed: %v", err)
+	}
+
+	if count, _ := s.Count(ctx); count != 2 {
+		t.Errorf("Expected 2 records, got %d", count)
+	}
+	for _, ip := range []string{"::1", "0:0::1", "0000::0001"} {
+		got := mustGet(t, s, ip, 80, "HTTP")
+		if got == nil || got.IP != "::1" || got.Response != "b" || got.ScanCount != 2 {
+			t.Errorf("Expected the updated ::1 record for %s, got %+v", ip, got)
+		}
+		records, err := s.ListByIP(ctx, ip)
+		if err != nil {
+			t.Fatalf("ListByIP failed: %v", err)
+		}
+		if len(records) != 2 {
+			t.Errorf("Expected 2 records for %s, got %d", ip, len(records))
+		}
+	}
+
+	found, err := s.GetMulti(ctx, []store.RecordKey{{IP: "0:0::1", Port: 22, Service: "SSH"}})
+	if err != nil {
+		t.Fatalf("GetMulti failed: %v", err)
+	}
+	if got := found[store.RecordKey{IP: "::1", Port: 22, Service: "SSH"}]; got == nil {
+		t.Errorf("Expected GetMulti to key the SSH record by ::1, got %v", found)
+	}
+
+	if deleted, err := s.Delete(ctx, "0:0::1", 22, "SSH"); err != nil || !deleted {
+		t.Errorf("Expected Delete by another spelling to find the record, got %v (err %v)", deleted, err)
+	}
+}
+
+// testBulkUpsert tests that a batch follows Upsert semantics, with the
+// newest record winning for keys repeated within the batch
+func testBulkUpsert(t *testing.T, s store.Store) {
+	upsert(t, s, &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000, Response: "current"})
+
+	updated, err := s.BulkUpsert(context.Background(), []*store.ServiceRecord{
+		{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 1000, Response: "first"},
+		{IP: "2.2.2.2", Port: 22, Service: "SSH", LastTimestamp: 1000, Response: "ssh"},
+		{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 1500, Response: "second"},
+		// Older than the stored record, skipped
+		{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 100, Response: "stale"},
+	})
+	if err != nil {
+		t.Fatalf("BulkUpsert failed: %v", err)
+	}
+	if updated != 2 {
+		t.Errorf("Expected 2 updated records, got %d", updated)
+	}
+
+	if got := mustGet(t, s, "2.2.2.2", 80, "HTTP"); got == nil || got.Response != "second" {
+		t.Errorf("Expected the newest record in the batch, got %+v", got)
+	}
+	if got := mustGet(t, s, "2.2.2.2", 22, "SSH"); got == nil {
+		t.Error("Expected SSH record to be inserted")
+	}
+	if got := mustGet(t, s, "1.1.1.1", 80, "HTTP"); got == nil || got.Response != "current" {
+		t.Errorf("Expected the stale record to be skipped, got %+v", got)
+	}
+}
+
+// testCount tests that Count includes every live record
+func testCount(t *testing.T, s store.Store) {
+	if count, err := s.Count(context.Background()); err != nil || count != 0 {
+		t.Errorf("Expected 0 records in a new store, got %d (err %v)", count, err)
+	}
+	upsert(t, s,
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 2000},
+		&store.ServiceRecord{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+	)
+	if count, err := s.Count(context.Background()); err != nil || count != 2 {
+		t.Errorf("Expected 2 records, got %d (err %v)", count, err)
+	}
+}
+
+// testStats tests the totals, breakdowns and timestamp range
+func testStats(t *testing.T, s store.Store) {
+	upsert(t, s,
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 22, Service: "SSH", LastTimestamp: 2000},
+		&store.ServiceRecord{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 3000},
+	)
+	stats, err := s.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalRecords != 3 {
+		t.Errorf("Expected 3 total records, got %d", stats.TotalRecords)
+	}
+	if stats.RecordsByService["HTTP"] != 2 || stats.RecordsByService["SSH"] != 1 {
+		t.Errorf("Expected 2 HTTP and 1 SSH, got %v", stats.RecordsByService)
+	}
+	if stats.RecordsByPort[80] != 2 || stats.RecordsByPort[22] != 1 {
+		t.Errorf("Expected 2 on port 80 and 1 on port 22, got %v", stats.RecordsByPort)
+	}
+	if stats.OldestTimestamp != 1000 || stats.NewestTimestamp != 3000 {
+		t.Errorf("Expected timestamps 1000 to 3000, got %d to %d", stats.OldestTimestamp, stats.NewestTimestamp)
+	}
+}
+
+// testDelete tests that a deleted record is no longer returned and that
+// deleting it again reports nothing deleted
+func testDelete(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	upsert(t, s,
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+		&store.ServiceRecord{IP: "2.2.2.2", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+	)
+
+	deleted, err := s.Delete(ctx, "1.1.1.1", 80, "HTTP")
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if !deleted {
+		t.Error("Expected record to be deleted")
+	}
+	if got := mustGet(t, s, "1.1.1.1", 80, "HTTP"); got != nil {
+		t.Errorf("Expected deleted record to be gone, got %+v", got)
+	}
+	if count, _ := s.Count(ctx); count != 1 {
+		t.Errorf("Expected 1 record after delete, got %d", count)
+	}
+
+	if deleted, err := s.Delete(ctx, "1.1.1.1", 80, "HTTP"); err != nil || deleted {
+		t.Errorf("Expected second delete to report false, got %v (err %v)", deleted, err)
+	}
+	if deleted, err := s.Delete(ctx, "9.9.9.9", 80, "HTTP"); err != nil || deleted {
+		t.Errorf("Expected delete of a missing record to report false, got %v (err %v)", deleted, err)
+	}
+}
+
+// testPurgeExpired tests that only records past their ExpiresAt are purged
+func testPurgeExpired(t *testing.T, s store.Store) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	upsert(t, s,
+		&store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: 1000, ExpiresAt: &past},
+		&store.ServiceRecord{IP: "1.1.1.2", Port: 80, Service: "HTTP", LastTimestamp: 1000, ExpiresAt: &future},
+		&store.ServiceRecord{IP: "1.1.1.3", Port: 80, Service: "HTTP", LastTimestamp: 1000},
+	)
+
+	purged, err := s.PurgeExpired(context.Background())
+	if err != nil {
+		t.Fatalf("PurgeExpired failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 purged record, got %d", purged)
+	}
+	if got := mustGet(t, s, "1.1.1.1", 80, "HTTP"); got != nil {
+		t.Error("Expected expired record to be purged")
+	}
+	for _, ip := range []string{"1.1.1.2", "1.1.1.3"} {
+		if got := mustGet(t, s, ip, 80, "HTTP"); got == nil {
+			t.Errorf("Expected record %s to be kept", ip)
+		}
+	}
+}
+
+// testConcurrentUpsert tests that concurrent upserts of shared and distinct
+// keys keep the newest record for each key
+func testConcurrentUpsert(t *testing.T, s store.Store) {
+	const workers, perWorker = 8, 25
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				ts := int64(i*workers + w + 1)
+				shared := &store.ServiceRecord{IP: "1.1.1.1", Port: 80, Service: "HTTP", LastTimestamp: ts, Response: fmt.Sprint(ts)}
+				own := &store.ServiceRecord{IP: store.IPAddress(fmt.Sprintf("10.0.%d.%d", w, i)), Port: 80, Service: "HTTP", LastTimestamp: ts}
+				for _, r := range []*store.ServiceRecord{shared, own} {
+					if _, err := s.Upsert(ctx, r); err != nil {
+						t.Errorf("Upsert failed: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	newest := int64(workers * perWorker)
+	got := mustGet(t, s, "1.1.1.1", 80, "HTTP")
+	if got == nil || got.LastTimestamp != newest || got.Response != fmt.Sprint(newest) {
+		t.Errorf("Expected the newest shared record (timestamp %d), got %+v", newest, got)
+	}
+	if count, _ := s.Count(ctx); count != workers*perWorker+1 {
+		t.Errorf("Expected %d records, got %d", workers*perWorker+1, count)
+	}
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans created by this package
+const tracerName = "github.com/censys/scan-takehome/pkg/store"
+
+// startSQLiteSpan starts a client span for a SQLite operation using the
+// global tracer provider
+func startSQLiteSpan(ctx context.Context, name, operation string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName("service_records"),
+		),
+	)
+}
+
+// endSpan records err (if any) on span and ends it
+func endSpan(span trace.Span, err error, attrs ...attribute.KeyValue) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attrs...)
+	span.End()
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// EventType describes what happened to a record during an upsert
+type EventType string
+
+const (
+	// EventCreated is emitted when a new record is inserted
+	EventCreated EventType = "created"
+	// EventUpdated is emitted when an existing record is replaced by a newer one
+	EventUpdated EventType = "updated"
+	// EventSkipped is emitted when an upsert is ignored due to an older timestamp
+	EventSkipped EventType = "skipped"
+)
+
+// watchBufferSize is the per-watcher channel buffer
+// Events are dropped for watchers that fall further behind than this
+const watchBufferSize = 256
+
+// ErrStoreClosed is returned by Watch after the store has been closed
+var ErrStoreClosed = errors.New("store closed")
+
+// StoreEvent describes a change (or skipped change) to a record
+type StoreEvent struct {
+	Type EventType
+	// Record is the stored record for created/updated events and the
+	// rejected incoming record for skipped events
+	Record *ServiceRecord
+	// Previous is the record that was replaced, when known
+	Previous *ServiceRecord
+}
+
+// watchHub fans out store events to registered watchers
+// Publishing never blocks: a watcher with a full buffer misses the event
+type watchHub struct {
+	mu       sync.Mutex
+	watchers []chan StoreEvent
+	closed   bool
+}
+
+// watch registers a new watcher that is removed when ctx is done
+func (h *watchHub) watch(ctx context.Context) (<-chan StoreEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrStoreClosed
+	}
+
+	ch := make(chan StoreEvent, watchBufferSize)
+	h.watchers = append(h.watchers, ch)
+
+	go func() {
+		<-ctx.Done()
+		h.unwatch(ch)
+	}()
+
+	return ch, nil
+}
+
+// unwatch deregisters and closes a watcher channel
+// Unknown or already removed channels are ignored
+func (h *watchHub) unwatch(ch <-chan StoreEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, w := range h.watchers {
+		if w == ch {
+			h.watchers = append(h.watchers[:i], h.watchers[i+1:]...)
+			close(w)
+			return
+		}
+	}
+}
+
+// active reports whether anyone is watching
+func (h *watchHub) active() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.watchers) > 0
+}
+
+// publish delivers an event to every watcher without blocking
+func (h *watchHub) publish(ev StoreEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, w := range h.watchers {
+		select {
+		case w <- ev:
+		default:
+			// Watcher is not keeping up - drop rather than stall writers
+		}
+	}
+}
+
+// close closes all watcher channels and rejects new watchers
+func (h *watchHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, w := range h.watchers {
+		close(w)
+	}
+	h.watchers = nil
+}